@@ -0,0 +1,529 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/archive"
+	"github.com/dafaath/iot-server/internal/database"
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// The admin command work on the database of the config directly, so it can be used
+// on the server machine without a running instance or an admin account, e.g.
+// `server-iot admin user-create -username alice -email alice@example.com -password secret -admin`
+var adminCommands = map[string]subcommand{
+	"user-list": {
+		description: "List every user",
+		run:         runAdminUserList,
+	},
+	"user-create": {
+		description: "Create an activated user, optionally as admin",
+		run:         runAdminUserCreate,
+	},
+	"user-promote": {
+		description: "Make the user an admin",
+		run:         func(args []string) error { return runAdminUserSetAdmin("user-promote", args, true) },
+	},
+	"user-demote": {
+		description: "Remove the admin role of the user",
+		run:         func(args []string) error { return runAdminUserSetAdmin("user-demote", args, false) },
+	},
+	"user-password": {
+		description: "Set the password of the user",
+		run:         runAdminUserPassword,
+	},
+	"rotate-jwt": {
+		description: "Generate a new JWT secret key, every issued token is invalid once the server use it",
+		run:         runAdminRotateJWT,
+	},
+	"purge": {
+		description: "Delete channel older than a time and the expired notification",
+		run:         runAdminPurge,
+	},
+	"export": {
+		description: "Write the channel of a sensor as CSV",
+		run:         runAdminExport,
+	},
+	"compress-bench": {
+		description: "Compare the size of generated channel as table row, compressed day and Parquet",
+		run:         runAdminCompressBench,
+	},
+}
+
+func runAdmin(args []string) error {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" {
+		printAdminUsage()
+		return nil
+	}
+
+	command, ok := adminCommands[args[0]]
+	if !ok {
+		printAdminUsage()
+		return fmt.Errorf("unknown admin command %q", args[0])
+	}
+	return command.run(args[1:])
+}
+
+func printAdminUsage() {
+	names := []string{}
+	for name := range adminCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Println("Usage: server-iot admin <command> [flags], use -h on a command to see its flags")
+	for _, name := range names {
+		fmt.Printf("  %-15s %s\n", name, adminCommands[name].description)
+	}
+}
+
+func adminConnect() (*pgxpool.Pool, error) {
+	db, err := database.GetConnection()
+	if err != nil {
+		return nil, fmt.Errorf("connect to database: %w", err)
+	}
+	return db, nil
+}
+
+// adminChannelRepository read the compressed, archived and rolled up channel too, like the server
+func adminChannelRepository() (repositories.ChannelRepository, error) {
+	config := configs.GetConfig()
+	s3Store, err := dependencies.NewS3Store(config)
+	if err != nil {
+		return repositories.ChannelRepository{}, err
+	}
+	archiveRepository, err := repositories.NewArchiveRepository(s3Store, config)
+	if err != nil {
+		return repositories.ChannelRepository{}, err
+	}
+	compressionRepository, err := repositories.NewCompressionRepository(config)
+	if err != nil {
+		return repositories.ChannelRepository{}, err
+	}
+	rollupRepository, err := repositories.NewRollupRepository()
+	if err != nil {
+		return repositories.ChannelRepository{}, err
+	}
+	aggregateRepository, err := repositories.NewAggregateRepository(config)
+	if err != nil {
+		return repositories.ChannelRepository{}, err
+	}
+	return repositories.NewChannelRepository(&compressionRepository, &archiveRepository, &rollupRepository, &aggregateRepository)
+}
+
+func runAdminUserList(args []string) error {
+	flags := flag.NewFlagSet("admin user-list", flag.ExitOnError)
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	db, err := adminConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	userRepository, err := repositories.NewUserRepository(nil)
+	if err != nil {
+		return err
+	}
+	users, err := userRepository.GetAll(context.Background(), db)
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].IdUser < users[j].IdUser
+	})
+	fmt.Printf("%-6s %-20s %-32s %-8s %s\n", "ID", "USERNAME", "EMAIL", "ACTIVE", "ADMIN")
+	for _, user := range users {
+		fmt.Printf("%-6d %-20s %-32s %-8t %t\n", user.IdUser, user.Username, user.Email, user.Status, user.IsAdmin)
+	}
+	return nil
+}
+
+func runAdminUserCreate(args []string) error {
+	flags := flag.NewFlagSet("admin user-create", flag.ExitOnError)
+	username := flags.String("username", "", "Username of the new user")
+	email := flags.String("email", "", "Email of the new user")
+	password := flags.String("password", "", "Password of the new user")
+	isAdmin := flags.Bool("admin", false, "Create the user as admin")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *username == "" || *email == "" || *password == "" {
+		return errors.New("username, email and password are required")
+	}
+
+	db, err := adminConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	userRepository, err := repositories.NewUserRepository(nil)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	user, err := userRepository.Create(ctx, tx, entities.UserCreate{
+		Email:    *email,
+		Username: *username,
+		Password: *password,
+	})
+	if err != nil {
+		return err
+	}
+
+	// No activation email is sent, the user created by the admin is active right away
+	err = userRepository.UpdateStatus(ctx, tx, user.IdUser, true)
+	if err != nil {
+		return err
+	}
+	if *isAdmin {
+		err = userRepository.UpdateIsAdmin(ctx, tx, user.IdUser, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Created user %s with id %d (admin: %t)\n", user.Username, user.IdUser, *isAdmin)
+	return nil
+}
+
+func runAdminUserSetAdmin(name string, args []string, isAdmin bool) error {
+	flags := flag.NewFlagSet("admin "+name, flag.ExitOnError)
+	username := flags.String("username", "", "Username of the user")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *username == "" {
+		return errors.New("username is required")
+	}
+
+	db, err := adminConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	userRepository, err := repositories.NewUserRepository(nil)
+	if err != nil {
+		return err
+	}
+	user, err := userRepository.GetByUsername(ctx, db, *username)
+	if err != nil {
+		return err
+	}
+
+	err = userRepository.UpdateIsAdmin(ctx, db, user.IdUser, isAdmin)
+	if err != nil {
+		return err
+	}
+
+	// The role is read from the JWT, so the user see the change after logging in again
+	fmt.Printf("Set admin of user %s to %t, the user has to login again to get the new role\n", user.Username, isAdmin)
+	return nil
+}
+
+func runAdminUserPassword(args []string) error {
+	flags := flag.NewFlagSet("admin user-password", flag.ExitOnError)
+	username := flags.String("username", "", "Username of the user")
+	password := flags.String("password", "", "New password")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *username == "" || *password == "" {
+		return errors.New("username and password are required")
+	}
+
+	db, err := adminConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	userRepository, err := repositories.NewUserRepository(nil)
+	if err != nil {
+		return err
+	}
+	user, err := userRepository.GetByUsername(ctx, db, *username)
+	if err != nil {
+		return err
+	}
+
+	err = userRepository.UpdatePassword(ctx, db, user.IdUser, *password)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Updated the password of user %s\n", user.Username)
+	return nil
+}
+
+// The secret is read from the config at start, so the command only print the new value
+// for the operator to put in .env and restart every instance
+func runAdminRotateJWT(args []string) error {
+	flags := flag.NewFlagSet("admin rotate-jwt", flag.ExitOnError)
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	secret := make([]byte, 32)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Put the new secret in .env (or the environment) of every instance and restart them,")
+	fmt.Println("every user has to login again afterward:")
+	fmt.Println()
+	fmt.Printf("APP_JWT_SECRETKEY=%s\n", hex.EncodeToString(secret))
+	return nil
+}
+
+// parseAdminTime parse RFC3339 time or a date like 2006-01-02 in UTC
+func parseAdminTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return t, fmt.Errorf("invalid time %q, use RFC3339 or 2006-01-02", value)
+	}
+	return t, nil
+}
+
+func runAdminPurge(args []string) error {
+	flags := flag.NewFlagSet("admin purge", flag.ExitOnError)
+	before := flags.String("before", "", "Delete channel older than this time (RFC3339 or 2006-01-02)")
+	olderThan := flags.Duration("older-than", 0, "Delete channel older than this duration, e.g. 8760h")
+	sensorId := flags.Int("sensor", 0, "Only purge the channel of this sensor")
+	notifications := flags.Bool("notifications", false, "Delete the expired notification like the notification-retention job")
+	dryRun := flags.Bool("dry-run", false, "Only count what would be deleted")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *before != "" && *olderThan > 0 {
+		return errors.New("use either before or older-than")
+	}
+	if *before == "" && *olderThan == 0 && !*notifications {
+		return errors.New("nothing to purge, give before, older-than or notifications")
+	}
+
+	db, err := adminConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if *before != "" || *olderThan > 0 {
+		cutoff := time.Now().UTC().Add(-*olderThan)
+		if *before != "" {
+			cutoff, err = parseAdminTime(*before)
+			if err != nil {
+				return err
+			}
+		}
+
+		channelRepository, err := adminChannelRepository()
+		if err != nil {
+			return err
+		}
+		count, err := channelRepository.DeleteBefore(ctx, tx, *sensorId, cutoff)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Deleted %d channel before %s\n", count, cutoff.Format(time.RFC3339))
+	}
+
+	if *notifications {
+		config := configs.GetConfig()
+		notificationRepository, err := repositories.NewNotificationRepository()
+		if err != nil {
+			return err
+		}
+		count, err := notificationRepository.DeleteExpired(ctx, tx, config.Notification.RetentionDays, config.Notification.MaxPerUser)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Deleted %d notification\n", count)
+	}
+
+	if *dryRun {
+		fmt.Println("Dry run, nothing is deleted")
+		return nil
+	}
+	return tx.Commit(ctx)
+}
+
+func runAdminExport(args []string) error {
+	flags := flag.NewFlagSet("admin export", flag.ExitOnError)
+	sensorId := flags.Int("sensor", 0, "Sensor to export")
+	from := flags.String("from", "", "Start time (RFC3339 or 2006-01-02)")
+	to := flags.String("to", "", "End time, exclusive (RFC3339 or 2006-01-02)")
+	output := flags.String("out", "", "Output file, default to stdout")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *sensorId == 0 {
+		return errors.New("sensor is required")
+	}
+
+	query := entities.ChannelQuery{}
+	if *from != "" {
+		t, err := parseAdminTime(*from)
+		if err != nil {
+			return err
+		}
+		query.From = &t
+	}
+	if *to != "" {
+		t, err := parseAdminTime(*to)
+		if err != nil {
+			return err
+		}
+		query.To = &t
+	}
+
+	db, err := adminConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	channelRepository, err := adminChannelRepository()
+	if err != nil {
+		return err
+	}
+
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"time", "value"})
+	count := 0
+	err = channelRepository.ForEachBySensor(context.Background(), db, *sensorId, query, func(channel entities.Channel) error {
+		count++
+		return writer.Write([]string{
+			channel.Time.UTC().Format(time.RFC3339Nano),
+			strconv.FormatFloat(channel.Value, 'f', -1, 64),
+		})
+	})
+	if err != nil {
+		return err
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+
+	if *output != "" {
+		fmt.Printf("Exported %d channel of sensor %d to %s\n", count, *sensorId, *output)
+	}
+	return nil
+}
+
+// runAdminCompressBench encode a day of the seed sensor at the interval, so the storage reduction of
+// the compression can be checked without touching the database
+func runAdminCompressBench(args []string) error {
+	flags := flag.NewFlagSet("admin compress-bench", flag.ExitOnError)
+	interval := flags.Duration("interval", 10*time.Second, "Time between two channel of a sensor")
+	randomSeed := flags.Int64("seed", 1, "Seed of the random noise")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *interval <= 0 {
+		return errors.New("interval must be positive")
+	}
+
+	random := mathrand.New(mathrand.NewSource(*randomSeed))
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	from := to.AddDate(0, 0, -1)
+	sensors := []seedSensor{seedTemperature, seedHumidity, seedPressure, seedLight, seedSoil}
+
+	fmt.Printf("%-14s %8s %12s %12s %7s %12s %7s\n", "SENSOR", "ROWS", "TABLE", "COMPRESSED", "RATIO", "PARQUET", "RATIO")
+	for _, sensor := range sensors {
+		points := []archive.Point{}
+		for _, row := range seedChannel(0, sensor, from, to, *interval, random) {
+			points = append(points, archive.Point{Time: row[0].(time.Time), Value: row[1].(float64)})
+		}
+
+		compressed, err := archive.EncodeDelta(points)
+		if err != nil {
+			return err
+		}
+		decoded, err := archive.DecodeDelta(compressed)
+		if err != nil {
+			return err
+		}
+		for i := range points {
+			if !decoded[i].Time.Equal(points[i].Time) || decoded[i].Value != points[i].Value {
+				return fmt.Errorf("%s channel %d is not restored exactly", sensor.name, i)
+			}
+		}
+		parquet := &bytes.Buffer{}
+		err = archive.Write(parquet, points)
+		if err != nil {
+			return err
+		}
+
+		tableBytes := len(points) * archive.ChannelRowBytes
+		fmt.Printf("%-14s %8d %12d %12d %6.1fx %12d %6.1fx\n", sensor.name, len(points), tableBytes, len(compressed),
+			float64(tableBytes)/float64(len(compressed)), parquet.Len(), float64(tableBytes)/float64(parquet.Len()))
+	}
+	return nil
+}
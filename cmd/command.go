@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Subcommand run instead of the server when the first argument match its name, e.g. `server-iot loadtest -duration 30s`
+type subcommand struct {
+	description string
+	run         func(args []string) error
+}
+
+var subcommands = map[string]subcommand{
+	"doctor": {
+		description: "Verify config, database, schema, SMTP, cluster and clock, then print actionable errors",
+		run:         runDoctor,
+	},
+	"loadtest": {
+		description: "Drive ingest and query traffic against a running instance and report latency percentiles",
+		run:         runLoadTest,
+	},
+	"seed": {
+		description: "Create demo users, hardware, nodes, sensors and a few weeks of channel data",
+		run:         runSeed,
+	},
+	"admin": {
+		description: "Manage users, rotate the JWT secret, purge old data and export channel directly on the database",
+		run:         runAdmin,
+	},
+}
+
+// Return true if a subcommand is executed
+func runSubcommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	command, ok := subcommands[args[0]]
+	if !ok {
+		return false
+	}
+
+	err := command.run(args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+		os.Exit(1)
+	}
+	return true
+}
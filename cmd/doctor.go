@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/database"
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/mqtt/packet"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type doctorStatus string
+
+const (
+	doctorOk   doctorStatus = " OK "
+	doctorWarn doctorStatus = "WARN"
+	doctorFail doctorStatus = "FAIL"
+)
+
+type doctor struct {
+	failed int
+	warned int
+}
+
+func (d *doctor) report(status doctorStatus, check string, message string, hint string) {
+	fmt.Printf("[%s] %-20s %s\n", status, check, message)
+	if hint != "" && status != doctorOk {
+		fmt.Printf("       %-20s -> %s\n", "", hint)
+	}
+
+	switch status {
+	case doctorFail:
+		d.failed++
+	case doctorWarn:
+		d.warned++
+	}
+}
+
+func runDoctor(args []string) error {
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	timeout := flags.Duration("timeout", 5*time.Second, "Timeout for each network check")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	config := configs.GetConfig()
+	d := doctor{}
+
+	d.checkConfig(config)
+	db := d.checkDatabase(config, *timeout)
+	if db != nil {
+		d.checkSchema(db, *timeout)
+		d.checkClock(db, *timeout)
+		db.Close()
+	}
+	d.checkSMTP(config, *timeout)
+	d.checkMqtt("mqtt", config.Mqtt.Url, config.Mqtt.Username, config.Mqtt.Password, *timeout)
+	d.checkMqtt("mqtt ingest", config.MqttIngest.Url, config.MqttIngest.Username, config.MqttIngest.Password, *timeout)
+	d.checkCluster(config)
+	d.checkS3(config, *timeout)
+
+	fmt.Printf("\n%d failed, %d warning\n", d.failed, d.warned)
+	if d.failed > 0 {
+		return errors.New("some check failed")
+	}
+	return nil
+}
+
+func (d *doctor) checkConfig(config *configs.Config) {
+	if config.Server.Port <= 0 || config.Server.Port > 65535 {
+		d.report(doctorFail, "config server", fmt.Sprintf("invalid port %d", config.Server.Port), "set server.port or APP_SERVER_PORT between 1 and 65535")
+	} else {
+		d.report(doctorOk, "config server", fmt.Sprintf("listen on %s:%d", config.Server.Host, config.Server.Port), "")
+	}
+
+	if len(config.JWT.SecretKey) < 32 {
+		d.report(doctorFail, "config jwt", "secret key is empty or shorter than 32 characters", "set jwt.secretKey or APP_JWT_SECRETKEY to a long random string")
+	} else {
+		d.report(doctorOk, "config jwt", "secret key is set", "")
+	}
+
+	missing := []string{}
+	if config.Database.Host == "" {
+		missing = append(missing, "host (APP_DATABASE_HOST)")
+	}
+	if config.Database.Name == "" {
+		missing = append(missing, "name (APP_DATABASE_NAME)")
+	}
+	if config.Database.Username == "" {
+		missing = append(missing, "username (APP_DATABASE_USERNAME)")
+	}
+	if len(missing) > 0 {
+		d.report(doctorFail, "config database", "missing "+strings.Join(missing, ", "), "set the missing value in .env or configs/config.json")
+	} else {
+		d.report(doctorOk, "config database", fmt.Sprintf("%s@%s:%d/%s", config.Database.Username, config.Database.Host, config.Database.Port, config.Database.Name), "")
+	}
+
+	if config.Mail.AuthenticationMail == "" || config.Mail.AuthenticationPassword == "" {
+		d.report(doctorWarn, "config mail", "mail authentication is empty, activation and forgot password email can't be sent", "set APP_MAIL_AUTHENTICATIONMAIL and APP_MAIL_AUTHENTICATIONPASSWORD")
+	} else {
+		d.report(doctorOk, "config mail", fmt.Sprintf("send as %s", config.Mail.AuthenticationMail), "")
+	}
+}
+
+func (d *doctor) checkDatabase(config *configs.Config, timeout time.Duration) *pgxpool.Pool {
+	type result struct {
+		db  *pgxpool.Pool
+		err error
+	}
+	resultChannel := make(chan result, 1)
+	go func() {
+		db, err := database.GetConnection()
+		resultChannel <- result{db, err}
+	}()
+
+	select {
+	case res := <-resultChannel:
+		if res.err != nil {
+			d.report(doctorFail, "database", res.err.Error(), "check postgres is running and the database config, create the database with ./script/create-db.sh")
+			return nil
+		}
+		d.report(doctorOk, "database", "connected", "")
+		return res.db
+	case <-time.After(timeout):
+		d.report(doctorFail, "database", fmt.Sprintf("connection timeout after %s", timeout), fmt.Sprintf("check %s:%d is reachable from this machine", config.Database.Host, config.Database.Port))
+		return nil
+	}
+}
+
+func (d *doctor) checkSchema(db *pgxpool.Pool, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	missing, err := database.GetMissingTable(ctx, db)
+	if err != nil {
+		d.report(doctorFail, "schema", err.Error(), "")
+		return
+	}
+	if len(missing) > 0 {
+		d.report(doctorFail, "schema", "missing table "+strings.Join(missing, ", "), "start the server once to create it, or apply internal/database/sql/table.sql")
+		return
+	}
+	missing, err = database.GetMissingColumn(ctx, db)
+	if err != nil {
+		d.report(doctorFail, "schema", err.Error(), "")
+		return
+	}
+	if len(missing) > 0 {
+		d.report(doctorFail, "schema", "missing column "+strings.Join(missing, ", "), "start the server once to add it, or apply internal/database/sql/upgrade.sql")
+		return
+	}
+	d.report(doctorOk, "schema", "every table and column exist", "")
+}
+
+func (d *doctor) checkClock(db *pgxpool.Pool, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var databaseTime time.Time
+	before := time.Now()
+	err := db.QueryRow(ctx, `SELECT now()`).Scan(&databaseTime)
+	if err != nil {
+		d.report(doctorFail, "clock", err.Error(), "")
+		return
+	}
+	after := time.Now()
+
+	// Compare with the middle of the round trip
+	localTime := before.Add(after.Sub(before) / 2)
+	drift := localTime.Sub(databaseTime)
+	if drift < 0 {
+		drift = -drift
+	}
+
+	if drift > 5*time.Second {
+		d.report(doctorWarn, "clock", fmt.Sprintf("server and database clock differ by %s", drift.Round(time.Millisecond)), "enable NTP (timedatectl set-ntp true) on both machines, channel time is taken from the server clock")
+	} else {
+		d.report(doctorOk, "clock", fmt.Sprintf("drift with database %s", drift.Round(time.Millisecond)), "")
+	}
+
+	if localTime.Year() < 2023 {
+		d.report(doctorFail, "clock", fmt.Sprintf("server clock is %s", localTime.Format(time.RFC3339)), "the server clock is not set, enable NTP")
+	}
+}
+
+func (d *doctor) checkSMTP(config *configs.Config, timeout time.Duration) {
+	address := net.JoinHostPort(config.Mail.SMTPHost, fmt.Sprint(config.Mail.SMTPPort))
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		d.report(doctorWarn, "smtp", err.Error(), fmt.Sprintf("check outbound connection to %s is allowed by the firewall", address))
+		return
+	}
+	conn.Close()
+
+	if config.Mail.AuthenticationMail == "" {
+		d.report(doctorOk, "smtp", fmt.Sprintf("%s reachable", address), "")
+		return
+	}
+
+	dialer, err := dependencies.NewMailDialer(config)
+	if err != nil {
+		d.report(doctorWarn, "smtp", err.Error(), "")
+		return
+	}
+	sendCloser, err := dialer.Dial()
+	if err != nil {
+		d.report(doctorWarn, "smtp", fmt.Sprintf("%s reachable but login failed: %v", address, err), "check the mail authentication, gmail require an app password")
+		return
+	}
+	sendCloser.Close()
+	d.report(doctorOk, "smtp", fmt.Sprintf("%s reachable and login success", address), "")
+}
+
+// checkMqtt connect to the broker with its own client id, so the session of a running server isn't
+// taken over
+func (d *doctor) checkMqtt(check string, brokerUrl string, username string, password string, timeout time.Duration) {
+	if brokerUrl == "" {
+		d.report(doctorOk, check, "not configured", "")
+		return
+	}
+
+	clientId := fmt.Sprintf("iot-server-doctor-%d", time.Now().UnixNano())
+	err := packet.Probe(brokerUrl, clientId, username, password, timeout)
+	if err != nil {
+		d.report(doctorFail, check, err.Error(), fmt.Sprintf("check the broker at %s is reachable and the username and password", brokerUrl))
+		return
+	}
+	d.report(doctorOk, check, fmt.Sprintf("%s reachable and login success", brokerUrl), "")
+}
+
+func (d *doctor) checkCluster(config *configs.Config) {
+	if config.Cluster.RedisUrl == "" {
+		d.report(doctorOk, "cluster", "redis not configured, single instance mode", "")
+		return
+	}
+
+	_, err := dependencies.NewCluster(config)
+	if err != nil {
+		d.report(doctorFail, "cluster", err.Error(), "check cluster.redisUrl or APP_CLUSTER_REDISURL")
+		return
+	}
+	d.report(doctorOk, "cluster", "redis reachable", "")
+}
+
+func (d *doctor) checkS3(config *configs.Config, timeout time.Duration) {
+	store, err := dependencies.NewS3Store(config)
+	if err != nil {
+		d.report(doctorFail, "s3", err.Error(), "check s3.endpoint or APP_S3_ENDPOINT")
+		return
+	}
+	if store == nil {
+		d.report(doctorOk, "s3", "not configured, backup can only target file", "")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	_, err = store.List(ctx, config.Backup.S3Prefix)
+	if err != nil {
+		d.report(doctorFail, "s3", err.Error(), "check the s3 bucket, region and credential")
+		return
+	}
+	d.report(doctorOk, "s3", fmt.Sprintf("bucket %s readable", config.S3.Bucket), "")
+}
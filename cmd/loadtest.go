@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type loadTestOptions struct {
+	baseUrl     string
+	username    string
+	password    string
+	token       string
+	duration    time.Duration
+	concurrency int
+	rate        int
+	ingestRatio float64
+	sensorIds   []int
+}
+
+type loadTestResult struct {
+	operation string
+	latency   time.Duration
+	err       error
+}
+
+func runLoadTest(args []string) error {
+	flags := flag.NewFlagSet("loadtest", flag.ExitOnError)
+	options := loadTestOptions{}
+	var sensors string
+	flags.StringVar(&options.baseUrl, "url", "http://localhost:3000", "Base URL of the running instance")
+	flags.StringVar(&options.username, "username", "user", "Username used to login")
+	flags.StringVar(&options.password, "password", "user", "Password used to login")
+	flags.StringVar(&options.token, "token", "", "Use this bearer token instead of login")
+	flags.DurationVar(&options.duration, "duration", 30*time.Second, "How long the test run")
+	flags.IntVar(&options.concurrency, "concurrency", 10, "Number of concurrent workers")
+	flags.IntVar(&options.rate, "rate", 0, "Maximum total request per second, 0 means unlimited")
+	flags.Float64Var(&options.ingestRatio, "ingest-ratio", 0.8, "Fraction of request that ingest channel data, the rest query sensor data")
+	flags.StringVar(&sensors, "sensors", "", "Comma separated sensor id to target, default to every sensor owned by the user")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if options.concurrency <= 0 {
+		return errors.New("concurrency must be positive")
+	}
+	if options.ingestRatio < 0 || options.ingestRatio > 1 {
+		return errors.New("ingest-ratio must be between 0 and 1")
+	}
+	options.baseUrl = strings.TrimSuffix(options.baseUrl, "/")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	if options.token == "" {
+		options.token, err = loadTestLogin(client, options)
+		if err != nil {
+			return err
+		}
+	}
+
+	if sensors != "" {
+		for _, idString := range strings.Split(sensors, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(idString))
+			if err != nil {
+				return fmt.Errorf("invalid sensor id %q", idString)
+			}
+			options.sensorIds = append(options.sensorIds, id)
+		}
+	} else {
+		options.sensorIds, err = loadTestDiscoverSensor(client, options)
+		if err != nil {
+			return err
+		}
+	}
+	if len(options.sensorIds) == 0 {
+		return errors.New("no sensor to target, create a sensor first or use -sensors")
+	}
+
+	fmt.Printf("Running load test against %s for %s with %d workers on %d sensors\n", options.baseUrl, options.duration, options.concurrency, len(options.sensorIds))
+	results := loadTestRun(client, options)
+	loadTestReport(results, options.duration)
+	return nil
+}
+
+func loadTestLogin(client *http.Client, options loadTestOptions) (string, error) {
+	body, err := json.Marshal(map[string]string{
+		"username": options.username,
+		"password": options.password,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	res, err := client.Post(options.baseUrl+"/user/login", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	token, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("login failed with status %d: %s", res.StatusCode, token)
+	}
+	return string(token), nil
+}
+
+func loadTestDiscoverSensor(client *http.Client, options loadTestOptions) ([]int, error) {
+	req, err := http.NewRequest(http.MethodGet, options.baseUrl+"/sensor", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+options.token)
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("listing sensor failed with status %d", res.StatusCode)
+	}
+
+	sensors := []struct {
+		IdSensor int `json:"id_sensor"`
+	}{}
+	err = json.NewDecoder(res.Body).Decode(&sensors)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []int{}
+	for _, sensor := range sensors {
+		ids = append(ids, sensor.IdSensor)
+	}
+	return ids, nil
+}
+
+func loadTestRun(client *http.Client, options loadTestOptions) []loadTestResult {
+	ctx, cancel := context.WithTimeout(context.Background(), options.duration)
+	defer cancel()
+
+	// Token bucket shared by every worker when rate is limited
+	var tickets <-chan time.Time
+	if options.rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(options.rate))
+		defer ticker.Stop()
+		tickets = ticker.C
+	}
+
+	resultChannel := make(chan loadTestResult, options.concurrency*16)
+	var wg sync.WaitGroup
+	for i := 0; i < options.concurrency; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			random := rand.New(rand.NewSource(time.Now().UnixNano() + int64(worker)))
+			for {
+				if tickets != nil {
+					select {
+					case <-ctx.Done():
+						return
+					case <-tickets:
+					}
+				} else if ctx.Err() != nil {
+					return
+				}
+
+				idSensor := options.sensorIds[random.Intn(len(options.sensorIds))]
+				var result loadTestResult
+				if random.Float64() < options.ingestRatio {
+					result = loadTestIngest(ctx, client, options, idSensor, random.Float64()*100+1)
+				} else {
+					result = loadTestQuery(ctx, client, options, idSensor)
+				}
+
+				// Request canceled because the test is over is not counted
+				if ctx.Err() != nil {
+					return
+				}
+				resultChannel <- result
+			}
+		}(i)
+	}
+
+	go func() {
+		wg.Wait()
+		close(resultChannel)
+	}()
+
+	results := []loadTestResult{}
+	for result := range resultChannel {
+		results = append(results, result)
+	}
+	return results
+}
+
+func loadTestDo(ctx context.Context, client *http.Client, options loadTestOptions, operation string, method string, path string, body []byte) loadTestResult {
+	req, err := http.NewRequestWithContext(ctx, method, options.baseUrl+path, bytes.NewReader(body))
+	if err != nil {
+		return loadTestResult{operation: operation, err: err}
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+options.token)
+
+	start := time.Now()
+	res, err := client.Do(req)
+	if err != nil {
+		return loadTestResult{operation: operation, latency: time.Since(start), err: err}
+	}
+	_, err = io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+	latency := time.Since(start)
+	if err != nil {
+		return loadTestResult{operation: operation, latency: latency, err: err}
+	}
+	if res.StatusCode >= 400 {
+		return loadTestResult{operation: operation, latency: latency, err: fmt.Errorf("status %d", res.StatusCode)}
+	}
+	return loadTestResult{operation: operation, latency: latency}
+}
+
+func loadTestIngest(ctx context.Context, client *http.Client, options loadTestOptions, idSensor int, value float64) loadTestResult {
+	body, err := json.Marshal(map[string]interface{}{
+		"id_sensor": idSensor,
+		"value":     value,
+	})
+	if err != nil {
+		return loadTestResult{operation: "ingest", err: err}
+	}
+	return loadTestDo(ctx, client, options, "ingest", http.MethodPost, "/channel/", body)
+}
+
+func loadTestQuery(ctx context.Context, client *http.Client, options loadTestOptions, idSensor int) loadTestResult {
+	return loadTestDo(ctx, client, options, "query", http.MethodGet, fmt.Sprintf("/sensor/%d", idSensor), nil)
+}
+
+func loadTestReport(results []loadTestResult, duration time.Duration) {
+	byOperation := map[string][]loadTestResult{}
+	for _, result := range results {
+		byOperation[result.operation] = append(byOperation[result.operation], result)
+		byOperation["total"] = append(byOperation["total"], result)
+	}
+
+	fmt.Printf("\n%-8s %9s %8s %10s %10s %10s %10s %10s %10s\n", "op", "requests", "errors", "req/s", "p50", "p90", "p95", "p99", "max")
+	for _, operation := range []string{"ingest", "query", "total"} {
+		operationResults := byOperation[operation]
+		if len(operationResults) == 0 {
+			continue
+		}
+
+		latencies := []time.Duration{}
+		errorCount := 0
+		errorSamples := map[string]int{}
+		for _, result := range operationResults {
+			if result.err != nil {
+				errorCount++
+				errorSamples[result.err.Error()]++
+				continue
+			}
+			latencies = append(latencies, result.latency)
+		}
+		sort.Slice(latencies, func(i, j int) bool {
+			return latencies[i] < latencies[j]
+		})
+
+		fmt.Printf("%-8s %9d %8d %10.1f %10s %10s %10s %10s %10s\n",
+			operation,
+			len(operationResults),
+			errorCount,
+			float64(len(operationResults))/duration.Seconds(),
+			loadTestPercentile(latencies, 50),
+			loadTestPercentile(latencies, 90),
+			loadTestPercentile(latencies, 95),
+			loadTestPercentile(latencies, 99),
+			loadTestPercentile(latencies, 100),
+		)
+		if operation != "total" {
+			for message, count := range errorSamples {
+				fmt.Printf("    %d x %s\n", count, message)
+			}
+		}
+	}
+}
+
+func loadTestPercentile(sortedLatencies []time.Duration, percentile float64) string {
+	if len(sortedLatencies) == 0 {
+		return "-"
+	}
+	index := int(float64(len(sortedLatencies)-1) * percentile / 100)
+	return sortedLatencies[index].Round(10 * time.Microsecond).String()
+}
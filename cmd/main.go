@@ -1,18 +1,38 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/bacnet"
+	"github.com/dafaath/iot-server/internal/bridge"
+	"github.com/dafaath/iot-server/internal/coap"
 	"github.com/dafaath/iot-server/internal/database"
 	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/dependencies/notifier"
+	"github.com/dafaath/iot-server/internal/entities"
 	"github.com/dafaath/iot-server/internal/handlers"
 	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/dafaath/iot-server/internal/i18n"
+	"github.com/dafaath/iot-server/internal/ingest"
+	"github.com/dafaath/iot-server/internal/metering"
 	"github.com/dafaath/iot-server/internal/middlewares"
+	"github.com/dafaath/iot-server/internal/mqtt"
+	"github.com/dafaath/iot-server/internal/opcua"
+	"github.com/dafaath/iot-server/internal/replication"
 	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/dafaath/iot-server/internal/rpc"
+	"github.com/dafaath/iot-server/internal/scheduler"
+	"github.com/dafaath/iot-server/internal/script"
+	"github.com/dafaath/iot-server/internal/snmp"
+	"github.com/dafaath/iot-server/internal/weather"
+	"github.com/dafaath/iot-server/internal/webhook"
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/recover"
@@ -28,6 +48,10 @@ func init() {
 
 // Declare all dependencies and run server
 func main() {
+	if runSubcommand(os.Args[1:]) {
+		os.Exit(0)
+	}
+
 	// Parse flag
 	flag.Parse()
 
@@ -38,6 +62,7 @@ func main() {
 	}
 
 	engine := handlebars.New("./internal/views", ".hbs")
+	engine.AddFunc("t", i18n.T)
 
 	app := fiber.New(
 		fiber.Config{
@@ -55,9 +80,21 @@ func main() {
 	validate := validator.New()
 	db, err := database.GetConnection()
 	helper.PanicIfError(err)
+	err = database.UpgradeTable(context.Background(), db)
+	helper.PanicIfError(err)
 	myValidator := dependencies.NewValidator(validate)
 	dialer, err := dependencies.NewMailDialer(config)
 	helper.PanicIfError(err)
+	cluster, err := dependencies.NewCluster(config)
+	helper.PanicIfError(err)
+	realtimeHub, err := dependencies.NewRealtimeHub(&cluster)
+	helper.PanicIfError(err)
+	backupStore, err := dependencies.NewFileStore(config.Backup.Directory)
+	helper.PanicIfError(err)
+	s3Store, err := dependencies.NewS3Store(config)
+	helper.PanicIfError(err)
+	firmwareStore, err := dependencies.NewFileStore(config.Firmware.Directory)
+	helper.PanicIfError(err)
 	// END
 
 	// BEGIN Middleware
@@ -65,43 +102,575 @@ func main() {
 		EnableStackTrace: true,
 	}))
 	authenticationMiddleware := middlewares.NewAuthenticationMiddleware(&myValidator)
+	formMiddleware := middlewares.NewFormMiddleware()
+	// The device clock sync is routed before the middleware that read the database, so it is answered
+	// as fast as possible
+	timeHandler, err := handlers.NewTimeHandler()
+	helper.PanicIfError(err)
+	app.Get("/time", timeHandler.GetTime)
+	rateLimitMiddleware := middlewares.NewRateLimitMiddleware(&cluster, config)
+	app.Use(rateLimitMiddleware.Limit)
 	// END
 
 	// BEGIN Repositories declaration
 	userRepository, err := repositories.NewUserRepository(dialer)
 	helper.PanicIfError(err)
+	sessionRepository, err := repositories.NewSessionRepository(config)
+	helper.PanicIfError(err)
+	helper.SetSessionChecker(func(sessionId string) error {
+		return sessionRepository.Check(context.Background(), db, sessionId)
+	})
 	hardwareRepository, err := repositories.NewHardwareRepository()
 	helper.PanicIfError(err)
 	nodeRepository, err := repositories.NewNodeRepository()
 	helper.PanicIfError(err)
 	sensorRepository, err := repositories.NewSensorRepository()
 	helper.PanicIfError(err)
-	channelRepository, err := repositories.NewChannelRepository()
+	archiveRepository, err := repositories.NewArchiveRepository(s3Store, config)
+	helper.PanicIfError(err)
+	firmwareRepository, err := repositories.NewFirmwareRepository(firmwareStore, s3Store, config)
+	helper.PanicIfError(err)
+	compressionRepository, err := repositories.NewCompressionRepository(config)
+	helper.PanicIfError(err)
+	rollupRepository, err := repositories.NewRollupRepository()
+	helper.PanicIfError(err)
+	aggregateRepository, err := repositories.NewAggregateRepository(config)
+	helper.PanicIfError(err)
+	channelRepository, err := repositories.NewChannelRepository(&compressionRepository, &archiveRepository, &rollupRepository, &aggregateRepository)
+	helper.PanicIfError(err)
+	storagePolicyRepository, err := repositories.NewStoragePolicyRepository(&channelRepository, &rollupRepository)
+	helper.PanicIfError(err)
+	featureRepository, err := repositories.NewFeatureRepository(config)
+	helper.PanicIfError(err)
+	jobRepository, err := repositories.NewJobRepository()
+	helper.PanicIfError(err)
+	dashboardRepository, err := repositories.NewDashboardRepository()
+	helper.PanicIfError(err)
+	notificationRepository, err := repositories.NewNotificationRepository()
+	helper.PanicIfError(err)
+	statsRepository, err := repositories.NewStatsRepository()
+	helper.PanicIfError(err)
+	brandingRepository, err := repositories.NewBrandingRepository(config)
+	helper.PanicIfError(err)
+	backupRepository, err := repositories.NewBackupRepository()
+	helper.PanicIfError(err)
+	bundleRepository, err := repositories.NewBundleRepository()
+	helper.PanicIfError(err)
+	usageRepository, err := repositories.NewUsageRepository()
+	helper.PanicIfError(err)
+	webhookRepository, err := repositories.NewWebhookRepository()
+	helper.PanicIfError(err)
+	historyRepository, err := repositories.NewHistoryRepository()
+	helper.PanicIfError(err)
+	validationRepository, err := repositories.NewValidationRepository()
+	helper.PanicIfError(err)
+	filterRepository, err := repositories.NewFilterRepository()
+	helper.PanicIfError(err)
+	throttleRepository, err := repositories.NewThrottleRepository()
+	helper.PanicIfError(err)
+	transferRepository, err := repositories.NewTransferRepository()
+	helper.PanicIfError(err)
+	kpiRepository, err := repositories.NewKpiRepository(&channelRepository)
+	helper.PanicIfError(err)
+	slaRepository, err := repositories.NewSlaRepository(&channelRepository, &sensorRepository, &dashboardRepository)
+	helper.PanicIfError(err)
+	ingestRepository, err := repositories.NewIngestRepository()
+	helper.PanicIfError(err)
+	digestRepository, err := repositories.NewDigestRepository(&channelRepository, &sensorRepository, &dashboardRepository, &userRepository)
+	helper.PanicIfError(err)
+	reportRepository, err := repositories.NewReportRepository(&channelRepository, &sensorRepository, &dashboardRepository, &userRepository)
+	helper.PanicIfError(err)
+	decoderRepository, err := repositories.NewDecoderRepository()
+	helper.PanicIfError(err)
+	sigfoxRepository, err := repositories.NewSigfoxRepository()
+	helper.PanicIfError(err)
+	opcuaRepository, err := repositories.NewOpcuaRepository()
+	helper.PanicIfError(err)
+	bacnetRepository, err := repositories.NewBacnetRepository()
+	helper.PanicIfError(err)
+	snmpRepository, err := repositories.NewSnmpRepository()
+	helper.PanicIfError(err)
+	weatherRepository, err := repositories.NewWeatherRepository()
+	helper.PanicIfError(err)
+	transformRepository, err := repositories.NewTransformRepository()
+	helper.PanicIfError(err)
+	scriptRepository, err := repositories.NewScriptRepository()
+	helper.PanicIfError(err)
+	statusPageRepository, err := repositories.NewStatusPageRepository()
+	helper.PanicIfError(err)
+	apiKeyRepository, err := repositories.NewApiKeyRepository()
+	helper.PanicIfError(err)
+	alertRepository, err := repositories.NewAlertRepository()
+	helper.PanicIfError(err)
+	syncRepository, err := repositories.NewSyncRepository(&hardwareRepository, &nodeRepository, &sensorRepository, &historyRepository, &transformRepository, &validationRepository)
+	helper.PanicIfError(err)
+	integrityRepository, err := repositories.NewIntegrityRepository(&channelRepository, &notificationRepository, config)
+	helper.PanicIfError(err)
+	retentionRepository, err := repositories.NewRetentionRepository(&channelRepository, config)
 	helper.PanicIfError(err)
 	// END
 
+	// BEGIN Usage metering
+	meter, err := metering.NewMeter(db, &usageRepository)
+	helper.PanicIfError(err)
+	meter.Start(context.Background(), time.Duration(config.Usage.FlushSeconds)*time.Second)
+	ingestMeter, err := metering.NewIngestMeter(db, &ingestRepository)
+	helper.PanicIfError(err)
+	ingestMeter.Start(context.Background(), time.Duration(config.Usage.FlushSeconds)*time.Second)
+	// END
+
+	// BEGIN Background jobs
+	jobScheduler, err := scheduler.NewScheduler(db, &jobRepository, config, &cluster)
+	helper.PanicIfError(err)
+	err = jobScheduler.Register("notification-retention", "@daily", func(ctx context.Context) (string, error) {
+		count, err := notificationRepository.DeleteExpired(ctx, db, config.Notification.RetentionDays, config.Notification.MaxPerUser)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Deleted %d notification", count), nil
+	})
+	helper.PanicIfError(err)
+	err = jobScheduler.Register("webhook-retention", "@daily", func(ctx context.Context) (string, error) {
+		count, err := webhookRepository.DeleteExpired(ctx, db, config.Webhook.RetentionDays)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Deleted %d webhook delivery", count), nil
+	})
+	helper.PanicIfError(err)
+	offlineMinutes := config.Heartbeat.OfflineMinutes
+	if offlineMinutes <= 0 {
+		offlineMinutes = 15
+	}
+	err = jobScheduler.Register("node-offline", "@every 1m", func(ctx context.Context) (string, error) {
+		count, err := nodeRepository.MarkOffline(ctx, db, time.Now().UTC().Add(-time.Duration(offlineMinutes)*time.Minute))
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Marked %d node offline", count), nil
+	})
+	helper.PanicIfError(err)
+	err = jobScheduler.Register("session-cleanup", "@daily", func(ctx context.Context) (string, error) {
+		count, err := sessionRepository.DeleteExpired(ctx, db)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Deleted %d expired session", count), nil
+	})
+	helper.PanicIfError(err)
+	err = jobScheduler.Register("ingest-retention", "@daily", func(ctx context.Context) (string, error) {
+		count, err := ingestRepository.DeleteBefore(ctx, db, time.Now().Add(-entities.IngestWindows["30d"]-time.Hour))
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Deleted %d hourly ingest count", count), nil
+	})
+	helper.PanicIfError(err)
+	err = jobScheduler.Register("storage-policy", "@daily", func(ctx context.Context) (string, error) {
+		now := time.Now().UTC()
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		runs, err := storagePolicyRepository.Apply(ctx, db, today)
+		if err != nil {
+			return "", err
+		}
+		var sensors int
+		var rolledUp, rawDeleted, rollupDeleted int64
+		for _, run := range runs {
+			sensors += run.Sensors
+			rolledUp += run.RolledUp
+			rawDeleted += run.RawDeleted
+			rollupDeleted += run.RollupDeleted
+		}
+		return fmt.Sprintf("Applied %d policy to %d sensor, wrote %d rollup, deleted %d raw channel and %d rolled up channel", len(runs), sensors, rolledUp, rawDeleted, rollupDeleted), nil
+	})
+	helper.PanicIfError(err)
+	err = jobScheduler.Register("channel-aggregate", "@every 15m", func(ctx context.Context) (string, error) {
+		sensors, count, err := aggregateRepository.Refresh(ctx, db, time.Now())
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Wrote %d hourly and daily bucket of %d sensor", count, sensors), nil
+	})
+	helper.PanicIfError(err)
+	err = jobScheduler.Register("channel-retention", "@hourly", func(ctx context.Context) (string, error) {
+		sensors, count, err := retentionRepository.Purge(ctx, db, time.Now().UTC())
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Purged %d channel of %d sensor", count, sensors), nil
+	})
+	helper.PanicIfError(err)
+	// Always registered, a sensor can enable compression when the default is off
+	err = jobScheduler.Register("channel-compress", "@daily", func(ctx context.Context) (string, error) {
+		now := time.Now().UTC()
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		days, count, bytes, err := compressionRepository.CompressBefore(ctx, db, today)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Compressed %d channel of %d sensor day into %d byte", count, days, bytes), nil
+	})
+	helper.PanicIfError(err)
+	err = jobScheduler.Register("channel-integrity", "@daily", func(ctx context.Context) (string, error) {
+		result, err := integrityRepository.Run(ctx, db, time.Now())
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Took %d checksum, verified %d sensor day, found %d mismatch", result.Computed, result.Verified, len(result.Mismatches)), nil
+	})
+	helper.PanicIfError(err)
+	err = jobScheduler.Register("kpi", "@hourly", func(ctx context.Context) (string, error) {
+		kpis, count, err := kpiRepository.Materialize(ctx, db, time.Now())
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Computed %d value of %d KPI", count, kpis), nil
+	})
+	helper.PanicIfError(err)
+	// Tell every node owner the SLA of their node in the previous month
+	err = jobScheduler.Register("sla-report", "@monthly", func(ctx context.Context) (string, error) {
+		now := time.Now()
+		month := repositories.UsageMonth(now).AddDate(0, -1, 0)
+		nodes, err := nodeRepository.GetAll(ctx, db, &entities.UserRead{IsAdmin: true}, &entities.NodeQuery{})
+		if err != nil {
+			return "", err
+		}
+		reports, err := slaRepository.GetMonthReports(ctx, db, nodes, month, now)
+		if err != nil {
+			return "", err
+		}
+
+		lines := map[int][]string{}
+		for _, report := range reports {
+			lines[report.IdUser] = append(lines[report.IdUser], fmt.Sprintf("%s %.2f%% available, %d downtime, %d alert", report.Name, report.Availability, len(report.Downtimes), report.Alerts))
+		}
+		link := fmt.Sprintf("/node/sla?month=%s", month.Format("2006-01"))
+		for idUser, userLines := range lines {
+			_, err := notificationRepository.Create(ctx, db, idUser, &entities.NotificationCreate{
+				Type:    "system",
+				Title:   fmt.Sprintf("SLA report %s", month.Format("2006-01")),
+				Message: strings.Join(userLines, "\n"),
+				Link:    &link,
+			})
+			if err != nil {
+				return "", err
+			}
+			meter.Add(idUser, entities.UsageNotificationsSent, 1)
+		}
+		return fmt.Sprintf("Reported %d node to %d user", len(reports), len(lines)), nil
+	})
+	helper.PanicIfError(err)
+	// Email the summary of the previous day to every user who opted in the digest
+	err = jobScheduler.Register("email-digest", "@daily", func(ctx context.Context) (string, error) {
+		now := time.Now().UTC()
+		to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		from := to.AddDate(0, 0, -1)
+		users, err := userRepository.GetDigestUsers(ctx, db)
+		if err != nil {
+			return "", err
+		}
+
+		sent, failed := 0, 0
+		for _, user := range users {
+			digest, err := digestRepository.GetUserDigest(ctx, db, user, from, to)
+			if err != nil {
+				return "", err
+			}
+			if len(digest.Sensors) == 0 {
+				continue
+			}
+			// A bad address should not stop the digest of the other user
+			err = digestRepository.Send(ctx, digest)
+			if err != nil {
+				log.Printf("Failed to send the digest to user %d: %v", user.IdUser, err)
+				failed++
+				continue
+			}
+			meter.Add(user.IdUser, entities.UsageNotificationsSent, 1)
+			sent++
+		}
+		return fmt.Sprintf("Sent %d digest, %d failed", sent, failed), nil
+	})
+	helper.PanicIfError(err)
+	// Email the scheduled report ending at this midnight to their owner
+	err = jobScheduler.Register("report", "@daily", func(ctx context.Context) (string, error) {
+		now := time.Now().UTC()
+		to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		reports, err := reportRepository.GetDue(ctx, db, to)
+		if err != nil {
+			return "", err
+		}
+
+		sent, failed := 0, 0
+		for _, report := range reports {
+			owner, err := userRepository.GetById(ctx, db, report.IdUser)
+			if err != nil {
+				return "", err
+			}
+			from, err := reportRepository.From(&report.ReportCreate, to)
+			if err != nil {
+				return "", err
+			}
+			err = reportRepository.Send(ctx, db, report, owner, from, to)
+			if err != nil {
+				log.Printf("Failed to send the report %d: %v", report.IdReport, err)
+				failed++
+				continue
+			}
+			err = reportRepository.SetLastSent(ctx, db, report.IdReport, now)
+			if err != nil {
+				return "", err
+			}
+			meter.Add(report.IdUser, entities.UsageNotificationsSent, 1)
+			sent++
+		}
+		return fmt.Sprintf("Sent %d report, %d failed", sent, failed), nil
+	})
+	helper.PanicIfError(err)
+	if config.Archive.AfterDays > 0 {
+		if !archiveRepository.Enabled() {
+			log.Fatal("archive.afterDays need the s3 bucket to be configured")
+		}
+		err = jobScheduler.Register("channel-archive", "@daily", func(ctx context.Context) (string, error) {
+			now := time.Now().UTC()
+			before := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -config.Archive.AfterDays)
+			days, count, err := archiveRepository.ArchiveBefore(ctx, db, before)
+			if err != nil {
+				return "", err
+			}
+			orphans, err := archiveRepository.DeleteOrphans(ctx, db)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("Archived %d channel of %d sensor day, deleted %d orphan file", count, days, orphans), nil
+		})
+		helper.PanicIfError(err)
+	}
+	// END
+
+	// BEGIN Edge sync
+	syncPusher, err := replication.NewPusher(db, &syncRepository, config)
+	helper.PanicIfError(err)
+	if syncPusher != nil {
+		err = jobScheduler.Register("sync", "@every 1m", syncPusher.Push)
+		helper.PanicIfError(err)
+	}
+	// END
+
+	// BEGIN Webhook delivery
+	webhookDispatcher, err := webhook.NewDispatcher(db, &webhookRepository)
+	helper.PanicIfError(err)
+	webhookDispatcher.Start(context.Background(), time.Duration(config.Webhook.PollSeconds)*time.Second)
+	// END
+
+	// BEGIN Outbound bridge
+	mqttPublisher, err := bridge.NewMqttPublisher(config, cluster.InstanceId)
+	helper.PanicIfError(err)
+	amqpPublisher, err := bridge.NewAmqpPublisher(config)
+	helper.PanicIfError(err)
+	influxPublisher, err := bridge.NewInfluxPublisher(config)
+	helper.PanicIfError(err)
+	pluginPublishers, err := bridge.NewPluginPublishers(config)
+	helper.PanicIfError(err)
+	channelBridge, err := bridge.NewBridge(db, &dashboardRepository, append([]bridge.Publisher{mqttPublisher, amqpPublisher, influxPublisher}, pluginPublishers...)...)
+	helper.PanicIfError(err)
+	channelBridge.Start(context.Background())
+	// END
+
+	// BEGIN Ingest pipeline
+	scriptHooks, err := script.NewHooks(db, &scriptRepository, &notificationRepository, config)
+	helper.PanicIfError(err)
+	alertNotifier, err := notifier.NewNotifier(db, &alertRepository, dialer, config)
+	helper.PanicIfError(err)
+	alertNotifier.Start(context.Background())
+	pipeline, err := ingest.NewPipeline(db, &channelRepository, &validationRepository, &filterRepository, &throttleRepository, &transformRepository, scriptHooks, &alertRepository, &notificationRepository, alertNotifier, realtimeHub, channelBridge, meter, ingestMeter, &nodeRepository)
+	helper.PanicIfError(err)
+	// END
+
+	// BEGIN Inbound protocol
+	opcuaSubscriber, err := opcua.NewSubscriber(db, &opcuaRepository, pipeline, config, cluster.InstanceId)
+	helper.PanicIfError(err)
+	opcuaSubscriber.Start(context.Background())
+	mqttSubscriber, err := mqtt.NewSubscriber(db, &sensorRepository, &apiKeyRepository, pipeline, &myValidator, config, cluster.InstanceId)
+	helper.PanicIfError(err)
+	mqttSubscriber.Start(context.Background())
+	if config.Bacnet.Enabled {
+		bacnetPoller, err := bacnet.NewPoller(db, &bacnetRepository, pipeline, config)
+		helper.PanicIfError(err)
+		err = jobScheduler.Register("bacnet-poll", "@every 1m", bacnetPoller.Poll)
+		helper.PanicIfError(err)
+	}
+	if config.Snmp.Enabled {
+		snmpPoller, err := snmp.NewPoller(db, &snmpRepository, pipeline, config)
+		helper.PanicIfError(err)
+		err = jobScheduler.Register("snmp-poll", "@every 1m", snmpPoller.Poll)
+		helper.PanicIfError(err)
+	}
+	weatherFetcher, err := weather.NewFetcher(db, &weatherRepository, pipeline, config)
+	helper.PanicIfError(err)
+	if weatherFetcher != nil {
+		err = jobScheduler.Register("weather", "@every 15m", weatherFetcher.Fetch)
+		helper.PanicIfError(err)
+	}
+	// END
+
+	// BEGIN Middleware that depends on repositories
+	usageMiddleware := middlewares.NewUsageMiddleware(meter)
+	app.Use(usageMiddleware.CountApiCall)
+	featureMiddleware := middlewares.NewFeatureMiddleware(db, &featureRepository, &myValidator)
+	themeMiddleware := middlewares.NewThemeMiddleware(db, &userRepository, config)
+	app.Use(themeMiddleware.Apply)
+	localeMiddleware := middlewares.NewLocaleMiddleware(db, &userRepository)
+	app.Use(localeMiddleware.Apply)
+	brandingMiddleware := middlewares.NewBrandingMiddleware(db, &brandingRepository)
+	app.Use(brandingMiddleware.Apply)
+	publicMiddleware := middlewares.NewPublicMiddleware(&cluster, config)
+	apiKeyMiddleware := middlewares.NewApiKeyMiddleware(db, &apiKeyRepository)
+	// END
+
 	// BEGIN Handlers declaration
-	userHandler, err := handlers.NewUserHandler(db, &userRepository, &myValidator)
+	loginLimiter, err := dependencies.NewLoginLimiter(&cluster, config)
+	helper.PanicIfError(err)
+	userHandler, err := handlers.NewUserHandler(db, &userRepository, &sessionRepository, &webhookRepository, &loginLimiter, &myValidator)
+	helper.PanicIfError(err)
+	hardwareHandler, err := handlers.NewHardwareHandler(db, &hardwareRepository, &nodeRepository, &sensorRepository, &historyRepository, &decoderRepository, &myValidator)
+	helper.PanicIfError(err)
+	nodeHandler, err := handlers.NewNodeHandler(db, &nodeRepository, &hardwareRepository, &sensorRepository, &channelRepository, &dashboardRepository, &notificationRepository, &webhookRepository, &historyRepository, meter, &myValidator)
+	helper.PanicIfError(err)
+	sensorHandler, err := handlers.NewSensorHandler(db, &sensorRepository, &hardwareRepository, &nodeRepository, &channelRepository, &dashboardRepository, &compressionRepository, &webhookRepository, &historyRepository, &validationRepository, &filterRepository, &throttleRepository, &retentionRepository, &transformRepository, &myValidator)
+	helper.PanicIfError(err)
+	channelHandler, err := handlers.NewChannelHandler(db, &nodeRepository, &sensorRepository, pipeline, &myValidator)
+	helper.PanicIfError(err)
+	compactHandler, err := handlers.NewCompactHandler(db, &sensorRepository, &apiKeyRepository, pipeline, &myValidator)
 	helper.PanicIfError(err)
-	hardwareHandler, err := handlers.NewHardwareHandler(db, &hardwareRepository, &nodeRepository, &sensorRepository, &myValidator)
+	sigfoxHandler, err := handlers.NewSigfoxHandler(db, &sigfoxRepository, &nodeRepository, &sensorRepository, &decoderRepository, pipeline, &myValidator)
 	helper.PanicIfError(err)
-	nodeHandler, err := handlers.NewNodeHandler(db, &nodeRepository, &hardwareRepository, &sensorRepository, &myValidator)
+	uplinkHandler, err := handlers.NewUplinkHandler(db, &nodeRepository, &sensorRepository, &decoderRepository, pipeline, &myValidator)
 	helper.PanicIfError(err)
-	sensorHandler, err := handlers.NewSensorHandler(db, &sensorRepository, &hardwareRepository, &nodeRepository, &myValidator)
+	opcuaHandler, err := handlers.NewOpcuaHandler(db, &nodeRepository, &opcuaRepository, &sensorRepository, &myValidator)
 	helper.PanicIfError(err)
-	channelHandler, err := handlers.NewChannelHandler(db, &channelRepository, &sensorRepository, &myValidator)
+	bacnetHandler, err := handlers.NewBacnetHandler(db, &nodeRepository, &bacnetRepository, &sensorRepository, &myValidator)
+	helper.PanicIfError(err)
+	snmpHandler, err := handlers.NewSnmpHandler(db, &nodeRepository, &snmpRepository, &sensorRepository, &myValidator)
+	helper.PanicIfError(err)
+	weatherHandler, err := handlers.NewWeatherHandler(db, &weatherRepository, &nodeRepository, &hardwareRepository, &sensorRepository, &historyRepository, &webhookRepository, &myValidator)
+	helper.PanicIfError(err)
+	docsHandler, err := handlers.NewDocsHandler(app)
+	helper.PanicIfError(err)
+	featureHandler, err := handlers.NewFeatureHandler(db, &featureRepository, &userRepository, &myValidator)
+	helper.PanicIfError(err)
+	jobHandler, err := handlers.NewJobHandler(db, &jobRepository, &jobScheduler)
+	helper.PanicIfError(err)
+	dashboardHandler, err := handlers.NewDashboardHandler(db, &dashboardRepository, &sensorRepository, &nodeRepository, &hardwareRepository, &channelRepository, &myValidator)
+	helper.PanicIfError(err)
+	realtimeHandler, err := handlers.NewRealtimeHandler(db, &nodeRepository, &sensorRepository, &channelRepository, realtimeHub, &myValidator)
+	helper.PanicIfError(err)
+	notificationHandler, err := handlers.NewNotificationHandler(db, &notificationRepository, &userRepository, &usageRepository, meter, &myValidator)
+	helper.PanicIfError(err)
+	statsHandler, err := handlers.NewStatsHandler(db, &statsRepository, &myValidator)
+	helper.PanicIfError(err)
+	brandingHandler, err := handlers.NewBrandingHandler(db, &brandingRepository, &myValidator)
+	helper.PanicIfError(err)
+	backupHandler, err := handlers.NewBackupHandler(db, &backupRepository, backupStore, s3Store, &cluster, config, &myValidator)
+	helper.PanicIfError(err)
+	bundleHandler, err := handlers.NewBundleHandler(db, &bundleRepository, &myValidator)
+	helper.PanicIfError(err)
+	usageHandler, err := handlers.NewUsageHandler(db, &usageRepository, meter, &myValidator)
+	helper.PanicIfError(err)
+	storagePolicyHandler, err := handlers.NewStoragePolicyHandler(db, &storagePolicyRepository, &sensorRepository, &myValidator)
+	helper.PanicIfError(err)
+	applyHandler, err := handlers.NewApplyHandler(db, &nodeRepository, &sensorRepository, &hardwareRepository, &dashboardRepository, &webhookRepository, &historyRepository, &myValidator)
+	helper.PanicIfError(err)
+	webhookHandler, err := handlers.NewWebhookHandler(db, &webhookRepository, &myValidator)
+	helper.PanicIfError(err)
+	scriptHandler, err := handlers.NewScriptHandler(db, &scriptRepository, &sensorRepository, scriptHooks, &myValidator)
+	helper.PanicIfError(err)
+	ingestHandler, err := handlers.NewIngestHandler(db, &ingestRepository, &nodeRepository, &sensorRepository, ingestMeter, &myValidator)
+	helper.PanicIfError(err)
+	slaHandler, err := handlers.NewSlaHandler(db, &slaRepository, &nodeRepository, &myValidator)
+	helper.PanicIfError(err)
+	kpiHandler, err := handlers.NewKpiHandler(db, &nodeRepository, &kpiRepository, &sensorRepository, &myValidator)
+	helper.PanicIfError(err)
+	reportHandler, err := handlers.NewReportHandler(db, &reportRepository, &sensorRepository, &userRepository, &myValidator)
+	helper.PanicIfError(err)
+	analyticsHandler, err := handlers.NewAnalyticsHandler(db, &nodeRepository, &sensorRepository, &channelRepository, &myValidator)
+	helper.PanicIfError(err)
+	transferHandler, err := handlers.NewTransferHandler(db, &transferRepository, &nodeRepository, &sensorRepository, &userRepository, &notificationRepository, &webhookRepository, &historyRepository, meter, &myValidator)
+	helper.PanicIfError(err)
+	statusPageHandler, err := handlers.NewStatusPageHandler(db, &statusPageRepository, &nodeRepository, &sensorRepository, &channelRepository, &slaRepository, &myValidator)
+	helper.PanicIfError(err)
+	syncHandler, err := handlers.NewSyncHandler(db, &syncRepository, syncPusher, &myValidator)
+	helper.PanicIfError(err)
+	integrityHandler, err := handlers.NewIntegrityHandler(db, &integrityRepository, &sensorRepository, &myValidator)
+	helper.PanicIfError(err)
+	apiKeyHandler, err := handlers.NewApiKeyHandler(db, &apiKeyRepository, &nodeRepository, &myValidator)
+	helper.PanicIfError(err)
+	firmwareHandler, err := handlers.NewFirmwareHandler(db, &firmwareRepository, &nodeRepository, &hardwareRepository, &myValidator)
+	helper.PanicIfError(err)
+	alertHandler, err := handlers.NewAlertHandler(db, &nodeRepository, &alertRepository, &sensorRepository, &myValidator)
 	helper.PanicIfError(err)
 	// END
 
 	// BEGIN Routes declaration
-	router, err := NewRouter(app, &authenticationMiddleware)
+	router, err := NewRouter(app, &authenticationMiddleware, &featureMiddleware, &formMiddleware)
 	helper.PanicIfError(err)
 	router.CreateHealthCheckRoute()
 	router.CreateUserRoute(&userHandler)
 	router.CreateHardwareRoute(&hardwareHandler)
-	router.CreateNodeRoute(&nodeHandler)
-	router.CreateSensorRoute(&sensorHandler)
-	router.CreateChannelRoute(&channelHandler)
+	router.CreateNodeRoute(&nodeHandler, &slaHandler, &sigfoxHandler, &weatherHandler, &uplinkHandler)
+	router.CreateSensorRoute(&sensorHandler, &opcuaHandler, &bacnetHandler, &snmpHandler, &realtimeHandler)
+	router.CreateChannelRoute(&channelHandler, &apiKeyMiddleware, &rateLimitMiddleware)
+	router.CreateCompactRoute(&compactHandler, &rateLimitMiddleware)
+	router.CreateHeartbeatRoute(&nodeHandler, &apiKeyMiddleware, &rateLimitMiddleware)
+	router.CreateFirmwareRoute(&firmwareHandler, &apiKeyMiddleware, &rateLimitMiddleware)
+	router.CreateSigfoxRoute(&sigfoxHandler)
+	router.CreateDashboardRoute(&dashboardHandler)
+	router.CreateDocsRoute(&docsHandler)
+	router.CreateFeatureRoute(&featureHandler)
+	router.CreateJobRoute(&jobHandler)
+	router.CreateNotificationRoute(&notificationHandler)
+	router.CreateAdminRoute(&statsHandler, &brandingHandler, &backupHandler, &bundleHandler, &usageHandler, &storagePolicyHandler, &integrityHandler)
+	router.CreateApplyRoute(&applyHandler)
+	router.CreateWebhookRoute(&webhookHandler)
+	router.CreateScriptRoute(&scriptHandler)
+	router.CreateTransferRoute(&transferHandler)
+	router.CreateKpiRoute(&kpiHandler)
+	router.CreateReportRoute(&reportHandler)
+	router.CreateAnalyticsRoute(&analyticsHandler)
+	router.CreateIngestRoute(&ingestHandler)
+	router.CreateRealtimeRoute(&realtimeHandler)
+	router.CreatePublicRoute(&dashboardHandler, &realtimeHandler)
+	router.CreateEmbedRoute(&sensorHandler, &realtimeHandler)
+	router.CreateStatusPageRoute(&statusPageHandler, &publicMiddleware)
+	router.CreateSyncRoute(&syncHandler)
+	router.CreateApiKeyRoute(&apiKeyHandler)
+	router.CreateAlertRoute(&alertHandler)
+	// END
+
+	err = jobScheduler.Start(context.Background())
+	helper.PanicIfError(err)
+
+	// BEGIN gRPC
+	if config.Grpc.Port > 0 {
+		if config.Grpc.CertFile == "" || config.Grpc.KeyFile == "" {
+			log.Fatal("grpc.port need grpc.certFile and grpc.keyFile")
+		}
+		rpcServer, err := rpc.NewServer(db, &nodeRepository, &sensorRepository, &channelRepository, &dashboardRepository, pipeline, realtimeHub, &myValidator)
+		helper.PanicIfError(err)
+		go func() {
+			log.Fatal(rpcServer.ListenAndServeTLS(fmt.Sprintf("%s:%d", config.Server.Host, config.Grpc.Port), config.Grpc.CertFile, config.Grpc.KeyFile))
+		}()
+	}
+	// END
+
+	// BEGIN CoAP
+	if config.Coap.Port > 0 {
+		coapServer, err := coap.NewServer(db, &sensorRepository, &channelRepository, &apiKeyRepository, pipeline, realtimeHub, &myValidator, config)
+		helper.PanicIfError(err)
+		go func() {
+			log.Fatal(coapServer.ListenAndServe(fmt.Sprintf("%s:%d", config.Server.Host, config.Coap.Port)))
+		}()
+	}
 	// END
 
 	// Initialize default config
@@ -0,0 +1,7 @@
+package main
+
+// Decoder and sink plugin linked in the server, each one register itself in its init function. See
+// the plugin package.
+import (
+// _ "example.com/acme/iot-decoder"
+)
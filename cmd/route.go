@@ -4,17 +4,22 @@ import (
 	"github.com/dafaath/iot-server/internal/handlers"
 	"github.com/dafaath/iot-server/internal/middlewares"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
 )
 
 type Router struct {
-	app            *fiber.App
-	authMiddleware *middlewares.AuthenticationMiddleware
+	app               *fiber.App
+	authMiddleware    *middlewares.AuthenticationMiddleware
+	featureMiddleware *middlewares.FeatureMiddleware
+	formMiddleware    *middlewares.FormMiddleware
 }
 
-func NewRouter(app *fiber.App, authMiddleware *middlewares.AuthenticationMiddleware) (Router, error) {
+func NewRouter(app *fiber.App, authMiddleware *middlewares.AuthenticationMiddleware, featureMiddleware *middlewares.FeatureMiddleware, formMiddleware *middlewares.FormMiddleware) (Router, error) {
 	return Router{
-		app:            app,
-		authMiddleware: authMiddleware,
+		app:               app,
+		authMiddleware:    authMiddleware,
+		featureMiddleware: featureMiddleware,
+		formMiddleware:    formMiddleware,
 	}, nil
 }
 
@@ -31,6 +36,141 @@ func (r *Router) CreateHealthCheckRoute() {
 	})
 }
 
+func (r *Router) CreateDocsRoute(handler *handlers.DocsHandler) {
+	r.app.Get("/openapi.json", handler.Spec)
+	r.app.Get("/docs", handler.SwaggerUI)
+	r.app.Get("/swagger", handler.SwaggerUI)
+}
+
+func (r *Router) CreateFeatureRoute(handler *handlers.FeatureHandler) {
+	featureRouter := r.app.Group("/feature")
+	featureRouter.Get("/", r.authMiddleware.ValidateUser, handler.GetAll)
+	featureRouter.Put("/:name", r.authMiddleware.ValidateAdmin, handler.Update)
+	featureRouter.Put("/:name/user/:id", r.authMiddleware.ValidateAdmin, handler.UpdateForUser)
+	featureRouter.Delete("/:name/user/:id", r.authMiddleware.ValidateAdmin, handler.DeleteForUser)
+}
+
+func (r *Router) CreateNotificationRoute(handler *handlers.NotificationHandler) {
+	notificationRouter := r.app.Group("/notification")
+	notificationRouter.Get("/", r.authMiddleware.ValidateUser, handler.GetAll)
+	notificationRouter.Post("/", r.authMiddleware.ValidateAdmin, handler.Broadcast)
+	notificationRouter.Get("/unread", r.authMiddleware.ValidateUser, handler.CountUnread)
+	notificationRouter.Put("/read", r.authMiddleware.ValidateUser, handler.MarkAllRead)
+	notificationRouter.Put("/:id/read", r.authMiddleware.ValidateUser, handler.MarkRead)
+	notificationRouter.Delete("/:id", r.authMiddleware.ValidateUser, handler.Delete)
+}
+
+func (r *Router) CreateAdminRoute(statsHandler *handlers.StatsHandler, brandingHandler *handlers.BrandingHandler, backupHandler *handlers.BackupHandler, bundleHandler *handlers.BundleHandler, usageHandler *handlers.UsageHandler, storagePolicyHandler *handlers.StoragePolicyHandler, integrityHandler *handlers.IntegrityHandler) {
+	adminRouter := r.app.Group("/admin")
+	adminRouter.Get("/stats", r.authMiddleware.ValidateAdmin, statsHandler.Get)
+	adminRouter.Get("/storage", r.authMiddleware.ValidateAdmin, statsHandler.GetStorage)
+	adminRouter.Get("/storage/:id", r.authMiddleware.ValidateAdmin, statsHandler.GetUserStorage)
+	adminRouter.Get("/branding", r.authMiddleware.ValidateAdmin, brandingHandler.Get)
+	adminRouter.Put("/branding", r.authMiddleware.ValidateAdmin, brandingHandler.Update)
+	adminRouter.Get("/backup", r.authMiddleware.ValidateAdmin, backupHandler.GetAll)
+	adminRouter.Post("/backup", r.authMiddleware.ValidateAdmin, backupHandler.Create)
+	adminRouter.Get("/backup/operation/:id", r.authMiddleware.ValidateAdmin, backupHandler.GetOperation)
+	adminRouter.Post("/restore", r.authMiddleware.ValidateAdmin, backupHandler.Restore)
+	adminRouter.Get("/config/export", r.authMiddleware.ValidateAdmin, bundleHandler.Export)
+	adminRouter.Post("/config/import", r.authMiddleware.ValidateAdmin, bundleHandler.Import)
+	adminRouter.Get("/usage", r.authMiddleware.ValidateAdmin, usageHandler.Export)
+	adminRouter.Get("/storage-policy", r.authMiddleware.ValidateAdmin, storagePolicyHandler.GetAll)
+	adminRouter.Post("/storage-policy", r.authMiddleware.ValidateAdmin, storagePolicyHandler.Create)
+	adminRouter.Get("/storage-policy/:id/run", r.authMiddleware.ValidateAdmin, storagePolicyHandler.GetRuns)
+	adminRouter.Get("/storage-policy/:id", r.authMiddleware.ValidateAdmin, storagePolicyHandler.GetById)
+	adminRouter.Put("/storage-policy/:id", r.authMiddleware.ValidateAdmin, storagePolicyHandler.Update)
+	adminRouter.Delete("/storage-policy/:id", r.authMiddleware.ValidateAdmin, storagePolicyHandler.Delete)
+	adminRouter.Get("/integrity", r.authMiddleware.ValidateAdmin, integrityHandler.GetStatus)
+	adminRouter.Post("/integrity/verify", r.authMiddleware.ValidateAdmin, integrityHandler.Verify)
+	adminRouter.Post("/integrity/accept", r.authMiddleware.ValidateAdmin, integrityHandler.Accept)
+}
+
+func (r *Router) CreateApplyRoute(handler *handlers.ApplyHandler) {
+	r.app.Post("/apply", r.authMiddleware.ValidateUser, handler.Apply)
+}
+
+func (r *Router) CreateWebhookRoute(handler *handlers.WebhookHandler) {
+	webhookRouter := r.app.Group("/webhook")
+	webhookRouter.Get("/", r.authMiddleware.ValidateUser, handler.GetAll)
+	webhookRouter.Post("/", r.authMiddleware.ValidateUser, handler.Create)
+	webhookRouter.Get("/:id/delivery", r.authMiddleware.ValidateUser, handler.GetDeliveries)
+	webhookRouter.Post("/:id/delivery/:delivery/redeliver", r.authMiddleware.ValidateUser, handler.Redeliver)
+	webhookRouter.Get("/:id", r.authMiddleware.ValidateUser, handler.GetById)
+	webhookRouter.Put("/:id", r.authMiddleware.ValidateUser, handler.Update)
+	webhookRouter.Delete("/:id", r.authMiddleware.ValidateUser, handler.Delete)
+}
+
+func (r *Router) CreateScriptRoute(handler *handlers.ScriptHandler) {
+	scriptRouter := r.app.Group("/script")
+	scriptRouter.Get("/", r.authMiddleware.ValidateUser, handler.GetAll)
+	scriptRouter.Post("/", r.authMiddleware.ValidateUser, handler.Create)
+	scriptRouter.Post("/test", r.authMiddleware.ValidateUser, handler.Test)
+	scriptRouter.Get("/:id", r.authMiddleware.ValidateUser, handler.GetById)
+	scriptRouter.Put("/:id", r.authMiddleware.ValidateUser, handler.Update)
+	scriptRouter.Delete("/:id", r.authMiddleware.ValidateUser, handler.Delete)
+}
+
+func (r *Router) CreateTransferRoute(handler *handlers.TransferHandler) {
+	transferRouter := r.app.Group("/transfer")
+	transferRouter.Get("/", r.authMiddleware.ValidateUser, handler.GetAll)
+	transferRouter.Post("/", r.authMiddleware.ValidateUser, handler.Create)
+	transferRouter.Post("/:id/accept", r.authMiddleware.ValidateUser, handler.Accept)
+	transferRouter.Post("/:id/decline", r.authMiddleware.ValidateUser, handler.Decline)
+	transferRouter.Delete("/:id", r.authMiddleware.ValidateUser, handler.Cancel)
+}
+
+func (r *Router) CreateKpiRoute(handler *handlers.KpiHandler) {
+	kpiRouter := r.app.Group("/kpi")
+	kpiRouter.Get("/", r.authMiddleware.ValidateUser, handler.GetAll)
+	kpiRouter.Post("/", r.authMiddleware.ValidateUser, handler.Create)
+	kpiRouter.Get("/:id/series", r.authMiddleware.ValidateUser, handler.GetSeries)
+	kpiRouter.Get("/:id", r.authMiddleware.ValidateUser, handler.GetById)
+	kpiRouter.Put("/:id", r.authMiddleware.ValidateUser, handler.Update)
+	kpiRouter.Delete("/:id", r.authMiddleware.ValidateUser, handler.Delete)
+}
+
+// CreateAlertRoute register the alert rule of the sensor, the alert they fired and the target notified of it
+func (r *Router) CreateAlertRoute(handler *handlers.AlertHandler) {
+	alertRouter := r.app.Group("/alert")
+	alertRouter.Get("/", r.authMiddleware.ValidateUser, handler.GetAll)
+	alertRouter.Get("/rule", r.authMiddleware.ValidateUser, handler.GetAllRule)
+	alertRouter.Post("/rule", r.authMiddleware.ValidateUser, handler.CreateRule)
+	alertRouter.Get("/rule/:id", r.authMiddleware.ValidateUser, handler.GetRuleById)
+	alertRouter.Put("/rule/:id", r.authMiddleware.ValidateUser, handler.UpdateRule)
+	alertRouter.Delete("/rule/:id", r.authMiddleware.ValidateUser, handler.DeleteRule)
+	alertRouter.Get("/target", r.authMiddleware.ValidateUser, handler.GetAllTarget)
+	alertRouter.Post("/target", r.authMiddleware.ValidateUser, handler.CreateTarget)
+	alertRouter.Delete("/target/:id", r.authMiddleware.ValidateUser, handler.DeleteTarget)
+	alertRouter.Put("/:id/acknowledge", r.authMiddleware.ValidateUser, handler.Acknowledge)
+}
+
+func (r *Router) CreateReportRoute(handler *handlers.ReportHandler) {
+	reportRouter := r.app.Group("/report")
+	reportRouter.Get("/", r.authMiddleware.ValidateUser, handler.GetAll)
+	reportRouter.Post("/", r.authMiddleware.ValidateUser, handler.Create)
+	reportRouter.Get("/:id/render", r.authMiddleware.ValidateUser, handler.Render)
+	reportRouter.Get("/:id", r.authMiddleware.ValidateUser, handler.GetById)
+	reportRouter.Put("/:id", r.authMiddleware.ValidateUser, handler.Update)
+	reportRouter.Delete("/:id", r.authMiddleware.ValidateUser, handler.Delete)
+}
+
+func (r *Router) CreateAnalyticsRoute(handler *handlers.AnalyticsHandler) {
+	analyticsRouter := r.app.Group("/analytics")
+	analyticsRouter.Get("/correlate", r.authMiddleware.ValidateUser, handler.Correlate)
+}
+
+func (r *Router) CreateIngestRoute(handler *handlers.IngestHandler) {
+	r.app.Get("/node/:id/ingest", r.authMiddleware.ValidateUser, handler.GetByNode)
+	r.app.Get("/sensor/:id/ingest", r.authMiddleware.ValidateUser, handler.GetBySensor)
+}
+
+func (r *Router) CreateJobRoute(handler *handlers.JobHandler) {
+	jobRouter := r.app.Group("/job")
+	jobRouter.Get("/", r.authMiddleware.ValidateAdmin, handler.GetAll)
+	jobRouter.Get("/:name", r.authMiddleware.ValidateAdmin, handler.GetByName)
+	jobRouter.Post("/:name/run", r.authMiddleware.ValidateAdmin, handler.Run)
+}
+
 func (r *Router) CreateUserRoute(handler *handlers.UserHandler) {
 	userRouter := r.app.Group("/user")
 	userRouter.Post("/signup", handler.Register)
@@ -40,6 +180,15 @@ func (r *Router) CreateUserRoute(handler *handlers.UserHandler) {
 	userRouter.Post("/forget-password", handler.ForgotPassword)
 	userRouter.Get("/forget-password", handler.ForgotPasswordPage)
 	userRouter.Get("/activation", handler.Activation)
+	userRouter.Get("/profile", r.authMiddleware.ValidateUser, handler.Profile)
+	userRouter.Put("/email", r.authMiddleware.ValidateUser, handler.UpdateEmail)
+	userRouter.Put("/theme", r.authMiddleware.ValidateUser, handler.UpdateTheme)
+	userRouter.Put("/language", r.authMiddleware.ValidateUser, handler.UpdateLanguage)
+	userRouter.Put("/digest", r.authMiddleware.ValidateUser, handler.UpdateDigest)
+	userRouter.Post("/refresh", handler.Refresh)
+	userRouter.Post("/logout", r.authMiddleware.ValidateUser, handler.Logout)
+	userRouter.Get("/session", r.authMiddleware.ValidateUser, handler.GetSessions)
+	userRouter.Delete("/session/:session", r.authMiddleware.ValidateUser, handler.RevokeSession)
 	userRouter.Get("/", r.authMiddleware.ValidateAdmin, handler.GetAll)
 	userRouter.Get("/:id", r.authMiddleware.ValidateAdmin, handler.GetOne)
 	userRouter.Put("/:id", r.authMiddleware.ValidateUserSameAsUrlIdOrAdmin, handler.Update)
@@ -49,37 +198,216 @@ func (r *Router) CreateUserRoute(handler *handlers.UserHandler) {
 func (r *Router) CreateHardwareRoute(handler *handlers.HardwareHandler) {
 	hardwareRouter := r.app.Group("/hardware")
 	hardwareRouter.Get("/create", r.authMiddleware.ValidateUser, handler.CreateForm)
-	hardwareRouter.Post("/", r.authMiddleware.ValidateUser, handler.Create)
+	hardwareRouter.Post("/", r.authMiddleware.ValidateUser, r.formMiddleware.Rerender(handler.CreateForm, "/hardware"), handler.Create)
 	hardwareRouter.Get("/", r.authMiddleware.ValidateUser, handler.GetAll)
 	hardwareRouter.Get("/:id/edit", r.authMiddleware.ValidateUser, handler.UpdateForm)
+	hardwareRouter.Post("/:id/edit", r.authMiddleware.ValidateUser, r.formMiddleware.Rerender(handler.UpdateForm, "/hardware"), handler.Update)
+	hardwareRouter.Get("/:id/history", r.authMiddleware.ValidateUser, handler.GetHistory)
+	hardwareRouter.Get("/:id/decoder", r.authMiddleware.ValidateUser, handler.GetDecoder)
+	hardwareRouter.Put("/:id/decoder", r.authMiddleware.ValidateUser, handler.UpdateDecoder)
 	hardwareRouter.Get("/:id", r.authMiddleware.ValidateUser, handler.GetById)
 	hardwareRouter.Put("/:id", r.authMiddleware.ValidateUser, handler.Update)
 	hardwareRouter.Delete("/:id", r.authMiddleware.ValidateUser, handler.Delete)
 }
 
-func (r *Router) CreateNodeRoute(handler *handlers.NodeHandler) {
+func (r *Router) CreateNodeRoute(handler *handlers.NodeHandler, slaHandler *handlers.SlaHandler, sigfoxHandler *handlers.SigfoxHandler, weatherHandler *handlers.WeatherHandler, uplinkHandler *handlers.UplinkHandler) {
 	nodeRouter := r.app.Group("/node")
 	nodeRouter.Get("/create", r.authMiddleware.ValidateUser, handler.CreateForm)
-	nodeRouter.Post("/", r.authMiddleware.ValidateUser, handler.Create)
+	nodeRouter.Get("/map", r.authMiddleware.ValidateUser, handler.Map)
+	nodeRouter.Get("/geojson", r.authMiddleware.ValidateUser, handler.GetGeoJSON)
+	nodeRouter.Get("/sla", r.authMiddleware.ValidateUser, slaHandler.GetAll)
+	nodeRouter.Get("/deleted", r.authMiddleware.ValidateAdmin, handler.GetDeleted)
+	nodeRouter.Post("/", r.authMiddleware.ValidateUser, r.formMiddleware.Rerender(handler.CreateForm, "/node"), handler.Create)
 	nodeRouter.Get("/", r.authMiddleware.ValidateUser, handler.GetAll)
 	nodeRouter.Get("/:id/edit", r.authMiddleware.ValidateUser, handler.UpdateForm)
+	nodeRouter.Post("/:id/edit", r.authMiddleware.ValidateUser, r.formMiddleware.Rerender(handler.UpdateForm, "/node"), handler.Update)
+	nodeRouter.Get("/:id/export", r.authMiddleware.ValidateUser, handler.Export)
+	nodeRouter.Get("/:id/history", r.authMiddleware.ValidateUser, handler.GetHistory)
+	nodeRouter.Get("/:id/history/diff", r.authMiddleware.ValidateUser, handler.GetHistoryDiff)
+	nodeRouter.Post("/:id/history/:version/rollback", r.authMiddleware.ValidateUser, handler.Rollback)
+	nodeRouter.Get("/:id/sla", r.authMiddleware.ValidateUser, slaHandler.GetByNode)
+	nodeRouter.Get("/:id/maintenance", r.authMiddleware.ValidateUser, slaHandler.GetMaintenances)
+	nodeRouter.Post("/:id/maintenance", r.authMiddleware.ValidateUser, slaHandler.CreateMaintenance)
+	nodeRouter.Delete("/:id/maintenance/:maintenance", r.authMiddleware.ValidateUser, slaHandler.DeleteMaintenance)
+	nodeRouter.Get("/:id/sigfox", r.authMiddleware.ValidateUser, sigfoxHandler.GetDevice)
+	nodeRouter.Put("/:id/sigfox", r.authMiddleware.ValidateUser, sigfoxHandler.UpdateDevice)
+	nodeRouter.Get("/:id/weather", r.authMiddleware.ValidateUser, weatherHandler.GetItems)
+	nodeRouter.Put("/:id/weather", r.authMiddleware.ValidateUser, weatherHandler.UpdateItems)
+	nodeRouter.Post("/:id/uplink", r.authMiddleware.ValidateUser, uplinkHandler.Receive)
+	nodeRouter.Post("/:id/restore", r.authMiddleware.ValidateAdmin, handler.Restore)
+	nodeRouter.Get("/:id/share", r.authMiddleware.ValidateUser, handler.GetShares)
+	nodeRouter.Post("/:id/share", r.authMiddleware.ValidateUser, handler.Share)
+	nodeRouter.Delete("/:id/share/:user", r.authMiddleware.ValidateUser, handler.Unshare)
 	nodeRouter.Get("/:id", r.authMiddleware.ValidateUser, handler.GetById)
 	nodeRouter.Put("/:id", r.authMiddleware.ValidateUser, handler.Update)
 	nodeRouter.Delete("/:id", r.authMiddleware.ValidateUser, handler.Delete)
 }
 
-func (r *Router) CreateSensorRoute(handler *handlers.SensorHandler) {
+func (r *Router) CreateSensorRoute(handler *handlers.SensorHandler, opcuaHandler *handlers.OpcuaHandler, bacnetHandler *handlers.BacnetHandler, snmpHandler *handlers.SnmpHandler, realtimeHandler *handlers.RealtimeHandler) {
 	sensorRouter := r.app.Group("/sensor")
 	sensorRouter.Get("/create", r.authMiddleware.ValidateUser, handler.CreateForm)
-	sensorRouter.Post("/", r.authMiddleware.ValidateUser, handler.Create)
+	sensorRouter.Post("/", r.authMiddleware.ValidateUser, r.formMiddleware.Rerender(handler.CreateForm, "/sensor"), handler.Create)
 	sensorRouter.Get("/", r.authMiddleware.ValidateUser, handler.GetAll)
+	sensorRouter.Get("/compare", r.authMiddleware.ValidateUser, handler.Compare)
+	sensorRouter.Get("/deleted", r.authMiddleware.ValidateAdmin, handler.GetDeleted)
+	sensorRouter.Get("/compare/series", r.authMiddleware.ValidateUser, handler.GetCompareSeries)
 	sensorRouter.Get("/:id/edit", r.authMiddleware.ValidateUser, handler.UpdateForm)
+	sensorRouter.Post("/:id/edit", r.authMiddleware.ValidateUser, r.formMiddleware.Rerender(handler.UpdateForm, "/sensor"), handler.Update)
+	sensorRouter.Get("/:id/series", r.authMiddleware.ValidateUser, handler.GetSeries)
+	sensorRouter.Get("/:id/export", r.authMiddleware.ValidateUser, handler.Export)
+	sensorRouter.Get("/:id/chart.:format", r.authMiddleware.ValidateUser, handler.Chart)
+	sensorRouter.Post("/:id/restore", r.authMiddleware.ValidateAdmin, handler.Restore)
+	sensorRouter.Post("/:id/embed", r.authMiddleware.ValidateUser, handler.Embed)
+	sensorRouter.Delete("/:id/embed", r.authMiddleware.ValidateUser, handler.Unembed)
+	sensorRouter.Get("/:id/compression", r.authMiddleware.ValidateUser, handler.GetCompression)
+	sensorRouter.Put("/:id/compression", r.authMiddleware.ValidateUser, handler.UpdateCompression)
+	sensorRouter.Put("/:id/quality", r.authMiddleware.ValidateUser, handler.UpdateQuality)
+	sensorRouter.Get("/:id/channel-name", r.authMiddleware.ValidateUser, handler.GetChannelNames)
+	sensorRouter.Get("/:id/display", r.authMiddleware.ValidateUser, handler.GetDisplay)
+	sensorRouter.Put("/:id/display", r.authMiddleware.ValidateUser, handler.UpdateDisplay)
+	sensorRouter.Get("/:id/validation", r.authMiddleware.ValidateUser, handler.GetValidation)
+	sensorRouter.Put("/:id/validation", r.authMiddleware.ValidateUser, handler.UpdateValidation)
+	sensorRouter.Get("/:id/filter", r.authMiddleware.ValidateUser, handler.GetFilter)
+	sensorRouter.Put("/:id/filter", r.authMiddleware.ValidateUser, handler.UpdateFilter)
+	sensorRouter.Get("/:id/throttle", r.authMiddleware.ValidateUser, handler.GetThrottle)
+	sensorRouter.Put("/:id/throttle", r.authMiddleware.ValidateUser, handler.UpdateThrottle)
+	sensorRouter.Get("/:id/retention", r.authMiddleware.ValidateUser, handler.GetRetention)
+	sensorRouter.Put("/:id/retention", r.authMiddleware.ValidateUser, handler.UpdateRetention)
+	sensorRouter.Get("/:id/transform", r.authMiddleware.ValidateUser, handler.GetTransform)
+	sensorRouter.Put("/:id/transform", r.authMiddleware.ValidateUser, handler.UpdateTransform)
+	sensorRouter.Get("/:id/opcua", r.authMiddleware.ValidateUser, opcuaHandler.GetItems)
+	sensorRouter.Put("/:id/opcua", r.authMiddleware.ValidateUser, opcuaHandler.UpdateItems)
+	sensorRouter.Get("/:id/bacnet", r.authMiddleware.ValidateUser, bacnetHandler.GetItems)
+	sensorRouter.Put("/:id/bacnet", r.authMiddleware.ValidateUser, bacnetHandler.UpdateItems)
+	sensorRouter.Get("/:id/snmp", r.authMiddleware.ValidateUser, snmpHandler.GetDevice)
+	sensorRouter.Put("/:id/snmp", r.authMiddleware.ValidateUser, snmpHandler.UpdateDevice)
+	sensorRouter.Post("/:id/merge", r.authMiddleware.ValidateUser, handler.Merge)
+	sensorRouter.Post("/:id/move", r.authMiddleware.ValidateUser, handler.Move)
+	sensorRouter.Get("/:id/tag", r.authMiddleware.ValidateUser, handler.GetTags)
+	sensorRouter.Put("/:id/tag", r.authMiddleware.ValidateUser, handler.UpdateTags)
+	sensorRouter.Get("/:id/poll", r.authMiddleware.ValidateUser, realtimeHandler.Poll)
+	sensorRouter.Get("/:id/stream", r.authMiddleware.ValidateUser, handler.AuthorizeStream, websocket.New(realtimeHandler.Stream))
+	sensorRouter.Get("/:id/history", r.authMiddleware.ValidateUser, handler.GetHistory)
+	sensorRouter.Get("/:id/history/diff", r.authMiddleware.ValidateUser, handler.GetHistoryDiff)
+	sensorRouter.Post("/:id/history/:version/rollback", r.authMiddleware.ValidateUser, handler.Rollback)
 	sensorRouter.Get("/:id", r.authMiddleware.ValidateUser, handler.GetById)
 	sensorRouter.Put("/:id", r.authMiddleware.ValidateUser, handler.Update)
 	sensorRouter.Delete("/:id", r.authMiddleware.ValidateUser, handler.Delete)
+	r.app.Post("/node/:id/sensors/batch", r.authMiddleware.ValidateUser, handler.CreateBatch)
+}
+
+func (r *Router) CreateDashboardRoute(handler *handlers.DashboardHandler) {
+	dashboardRouter := r.app.Group("/dashboard")
+	dashboardRouter.Get("/template", r.authMiddleware.ValidateUser, handler.GetAllTemplate)
+	dashboardRouter.Post("/template", r.authMiddleware.ValidateUser, handler.CreateTemplate)
+	dashboardRouter.Delete("/template/:id", r.authMiddleware.ValidateUser, handler.DeleteTemplate)
+	dashboardRouter.Get("/create", r.authMiddleware.ValidateUser, handler.CreateForm)
+	dashboardRouter.Post("/", r.authMiddleware.ValidateUser, handler.Create)
+	dashboardRouter.Get("/", r.authMiddleware.ValidateUser, handler.GetAll)
+	dashboardRouter.Get("/:id/edit", r.authMiddleware.ValidateUser, handler.UpdateForm)
+	dashboardRouter.Get("/:id", r.authMiddleware.ValidateUser, handler.GetById)
+	dashboardRouter.Put("/:id", r.authMiddleware.ValidateUser, handler.Update)
+	dashboardRouter.Delete("/:id", r.authMiddleware.ValidateUser, handler.Delete)
+	dashboardRouter.Post("/:id/widget", r.authMiddleware.ValidateUser, handler.CreateWidget)
+	dashboardRouter.Put("/:id/widget/:widget", r.authMiddleware.ValidateUser, handler.UpdateWidget)
+	dashboardRouter.Delete("/:id/widget/:widget", r.authMiddleware.ValidateUser, handler.DeleteWidget)
+	dashboardRouter.Post("/:id/publish", r.authMiddleware.ValidateUser, handler.Publish)
+	dashboardRouter.Delete("/:id/publish", r.authMiddleware.ValidateUser, handler.Unpublish)
+	dashboardRouter.Post("/:id/template", r.authMiddleware.ValidateUser, handler.SaveAsTemplate)
+}
+
+// CreatePublicRoute register the unauthenticated read-only route of published dashboards
+func (r *Router) CreatePublicRoute(dashboardHandler *handlers.DashboardHandler, realtimeHandler *handlers.RealtimeHandler) {
+	publicRouter := r.app.Group("/public")
+	publicRouter.Get("/dashboard/:token", dashboardHandler.GetPublic)
+	publicRouter.Get("/dashboard/:token/widget/:widget/series", dashboardHandler.GetPublicWidgetSeries)
+	publicRouter.Get("/dashboard/:token/realtime", dashboardHandler.AuthorizePublicRealtime, websocket.New(realtimeHandler.Stream))
+}
+
+// CreateStatusPageRoute register the status page setting of the node and the unauthenticated status
+// page, which is rate limited and cached
+func (r *Router) CreateStatusPageRoute(handler *handlers.StatusPageHandler, publicMiddleware *middlewares.PublicMiddleware) {
+	nodeRouter := r.app.Group("/node")
+	nodeRouter.Get("/:id/status-page", r.authMiddleware.ValidateUser, handler.Get)
+	nodeRouter.Put("/:id/status-page", r.authMiddleware.ValidateUser, handler.Publish)
+	nodeRouter.Delete("/:id/status-page", r.authMiddleware.ValidateUser, handler.Unpublish)
+	r.app.Get("/public/node/:token", publicMiddleware.Limit, publicMiddleware.Cache, handler.GetPublic)
+}
+
+// CreateApiKeyRoute register the management of the API key of the node
+func (r *Router) CreateApiKeyRoute(handler *handlers.ApiKeyHandler) {
+	nodeRouter := r.app.Group("/node")
+	nodeRouter.Get("/:id/api-key", r.authMiddleware.ValidateUser, handler.GetAll)
+	nodeRouter.Post("/:id/api-key", r.authMiddleware.ValidateUser, handler.Create)
+	nodeRouter.Delete("/:id/api-key/:key", r.authMiddleware.ValidateUser, handler.Delete)
 }
 
-func (r *Router) CreateChannelRoute(handler *handlers.ChannelHandler) {
+// CreateFirmwareRoute register the firmware upload, the target of the node and the check of the
+// device, which can authenticate with the API key of its node. The download is authenticated by the
+// signature of its url
+func (r *Router) CreateFirmwareRoute(handler *handlers.FirmwareHandler, apiKeyMiddleware *middlewares.ApiKeyMiddleware, rateLimitMiddleware *middlewares.RateLimitMiddleware) {
+	firmwareRouter := r.app.Group("/firmware")
+	firmwareRouter.Post("/", r.authMiddleware.ValidateUser, handler.Create)
+	firmwareRouter.Get("/", r.authMiddleware.ValidateUser, handler.GetAll)
+	firmwareRouter.Get("/:id/download", handler.Download)
+	firmwareRouter.Get("/:id", r.authMiddleware.ValidateUser, handler.GetById)
+	firmwareRouter.Delete("/:id", r.authMiddleware.ValidateUser, handler.Delete)
+
+	nodeRouter := r.app.Group("/node")
+	nodeRouter.Get("/:id/firmware", r.authMiddleware.ValidateUser, handler.GetNode)
+	nodeRouter.Put("/:id/firmware", r.authMiddleware.ValidateUser, handler.Assign)
+	nodeRouter.Delete("/:id/firmware", r.authMiddleware.ValidateUser, handler.Unassign)
+	nodeRouter.Post("/:id/firmware/check", rateLimitMiddleware.LimitIngest, apiKeyMiddleware.ValidateUserOrApiKey, handler.Check)
+}
+
+// CreateSyncRoute register the push of the edge instance, the sync state and the delta of the mobile client
+func (r *Router) CreateSyncRoute(handler *handlers.SyncHandler) {
+	syncRouter := r.app.Group("/sync")
+	syncRouter.Get("/", r.authMiddleware.ValidateUser, handler.GetDelta)
+	syncRouter.Post("/push", r.authMiddleware.ValidateUser, handler.Push)
+	syncRouter.Get("/status", r.authMiddleware.ValidateAdmin, handler.GetStatus)
+	syncRouter.Get("/site", r.authMiddleware.ValidateUser, handler.GetSites)
+	syncRouter.Put("/site/:site", r.authMiddleware.ValidateUser, handler.UpdateSite)
+	syncRouter.Delete("/site/:site", r.authMiddleware.ValidateUser, handler.DeleteSite)
+}
+
+// CreateEmbedRoute register the unauthenticated chart that can be embedded in other sites
+func (r *Router) CreateEmbedRoute(sensorHandler *handlers.SensorHandler, realtimeHandler *handlers.RealtimeHandler) {
+	embedRouter := r.app.Group("/embed")
+	embedRouter.Get("/sensor/:token", sensorHandler.GetEmbed)
+	embedRouter.Get("/sensor/:token/series", sensorHandler.GetEmbedSeries)
+	embedRouter.Get("/sensor/:token/chart.:format", sensorHandler.GetEmbedChart)
+	embedRouter.Get("/sensor/:token/realtime", sensorHandler.AuthorizeEmbedRealtime, websocket.New(realtimeHandler.Stream))
+}
+
+// CreateChannelRoute register the ingest of the channel, a device can authenticate with the API key
+// of its node instead of the user token. The ingest limit is checked before the key is read from the database
+func (r *Router) CreateChannelRoute(handler *handlers.ChannelHandler, apiKeyMiddleware *middlewares.ApiKeyMiddleware, rateLimitMiddleware *middlewares.RateLimitMiddleware) {
 	channelRouter := r.app.Group("/channel")
-	channelRouter.Post("/", r.authMiddleware.ValidateUser, handler.Create)
+	channelRouter.Post("/", rateLimitMiddleware.LimitIngest, apiKeyMiddleware.ValidateUserOrApiKey, handler.Create)
+	channelRouter.Post("/bulk", rateLimitMiddleware.LimitIngest, apiKeyMiddleware.ValidateUserOrApiKey, handler.CreateBulk)
+}
+
+// CreateHeartbeatRoute register the heartbeat of a device, which can authenticate with the API key
+// of its node like the channel
+func (r *Router) CreateHeartbeatRoute(handler *handlers.NodeHandler, apiKeyMiddleware *middlewares.ApiKeyMiddleware, rateLimitMiddleware *middlewares.RateLimitMiddleware) {
+	r.app.Post("/node/:id/heartbeat", rateLimitMiddleware.LimitIngest, apiKeyMiddleware.ValidateUserOrApiKey, handler.Heartbeat)
+}
+
+// CreateCompactRoute register the short path of the compact profile for a constrained device, the
+// handler check the token itself so an error is a short code too
+func (r *Router) CreateCompactRoute(handler *handlers.CompactHandler, rateLimitMiddleware *middlewares.RateLimitMiddleware) {
+	compactRouter := r.app.Group("/d")
+	compactRouter.Post("/:id", rateLimitMiddleware.LimitIngest, handler.Create)
+}
+
+// CreateSigfoxRoute register the callback the Sigfox backend forward the uplink to
+func (r *Router) CreateSigfoxRoute(handler *handlers.SigfoxHandler) {
+	sigfoxRouter := r.app.Group("/sigfox")
+	sigfoxRouter.Post("/callback", r.authMiddleware.ValidateUser, handler.Callback)
+}
+
+func (r *Router) CreateRealtimeRoute(handler *handlers.RealtimeHandler) {
+	realtimeRouter := r.app.Group("/realtime")
+	realtimeRouter.Get("/", r.authMiddleware.ValidateUser, handler.Authorize, websocket.New(handler.Stream))
 }
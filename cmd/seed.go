@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+// seedSensor describe a demo sensor, its value follow a daily cycle around base
+// with the given amplitude, a slow drift, and a random noise. Daylight sensor is
+// zero at night and peak at noon instead
+type seedSensor struct {
+	name      string
+	unit      string
+	hardware  string
+	base      float64
+	amplitude float64
+	noise     float64
+	decimals  int
+	daylight  bool
+}
+
+type seedNode struct {
+	name     string
+	location string
+	sensors  []seedSensor
+}
+
+var seedHardware = []entities.HardwareCreate{
+	{Name: "ESP32 DevKit", Type: "microcontroller unit", Description: "Dual core Wi-Fi and Bluetooth microcontroller"},
+	{Name: "Raspberry Pi 4", Type: "single-board computer", Description: "Quad core single-board computer with 4GB RAM"},
+	{Name: "DHT22", Type: "sensor", Description: "Temperature and humidity sensor"},
+	{Name: "BMP280", Type: "sensor", Description: "Barometric pressure sensor"},
+	{Name: "BH1750", Type: "sensor", Description: "Ambient light sensor"},
+	{Name: "Capacitive Soil Moisture", Type: "sensor", Description: "Corrosion resistant soil moisture sensor"},
+}
+
+var (
+	seedTemperature = seedSensor{name: "Temperature", unit: "°C", hardware: "DHT22", base: 27, amplitude: 4, noise: 0.3, decimals: 1}
+	seedHumidity    = seedSensor{name: "Humidity", unit: "%", hardware: "DHT22", base: 70, amplitude: -12, noise: 1.5, decimals: 1}
+	seedPressure    = seedSensor{name: "Pressure", unit: "hPa", hardware: "BMP280", base: 1009, amplitude: 1.5, noise: 0.2, decimals: 1}
+	seedLight       = seedSensor{name: "Light", unit: "lux", hardware: "BH1750", base: 0, amplitude: 900, noise: 20, decimals: 0, daylight: true}
+	seedSoil        = seedSensor{name: "Soil Moisture", unit: "%", hardware: "Capacitive Soil Moisture", base: 45, amplitude: -5, noise: 1, decimals: 1}
+)
+
+// The location is "latitude,longitude" so the node is shown on the map
+var seedNodes = []seedNode{
+	{name: "Greenhouse", location: "-6.5971,106.8060", sensors: []seedSensor{seedTemperature, seedHumidity, seedLight, seedSoil}},
+	{name: "Office", location: "-6.2088,106.8456", sensors: []seedSensor{seedTemperature, seedHumidity, seedPressure}},
+	{name: "Warehouse", location: "-6.9175,107.6191", sensors: []seedSensor{seedTemperature, seedHumidity}},
+}
+
+func runSeed(args []string) error {
+	flags := flag.NewFlagSet("seed", flag.ExitOnError)
+	username := flags.String("username", "demo", "Username of the demo user, the demo admin is <username>-admin")
+	password := flags.String("password", "demo", "Password of both demo users")
+	days := flags.Int("days", 21, "Days of channel data until now")
+	interval := flags.Duration("interval", 10*time.Minute, "Time between two channel of a sensor")
+	randomSeed := flags.Int64("seed", 1, "Seed of the random noise, the same seed give the same data")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *days <= 0 || *interval <= 0 {
+		return errors.New("days and interval must be positive")
+	}
+
+	db, err := adminConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	userRepository, err := repositories.NewUserRepository(nil)
+	if err != nil {
+		return err
+	}
+	hardwareRepository, err := repositories.NewHardwareRepository()
+	if err != nil {
+		return err
+	}
+	nodeRepository, err := repositories.NewNodeRepository()
+	if err != nil {
+		return err
+	}
+	sensorRepository, err := repositories.NewSensorRepository()
+	if err != nil {
+		return err
+	}
+
+	_, err = userRepository.GetByUsername(ctx, db, *username)
+	if err == nil {
+		return fmt.Errorf("user %s already exist, the database is already seeded or use another -username", *username)
+	}
+	if fiberErr, ok := err.(*fiber.Error); !ok || fiberErr.Code != fiber.StatusNotFound {
+		return err
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	user, err := seedUser(ctx, tx, userRepository, *username, *password, false)
+	if err != nil {
+		return err
+	}
+	_, err = seedUser(ctx, tx, userRepository, *username+"-admin", *password, true)
+	if err != nil {
+		return err
+	}
+
+	hardwareIds := map[string]int{}
+	for _, payload := range seedHardware {
+		payload := payload
+		hardware, err := hardwareRepository.Create(ctx, tx, &payload)
+		if err != nil {
+			return err
+		}
+		hardwareIds[hardware.Name] = hardware.IdHardware
+	}
+
+	to := time.Now().UTC().Truncate(*interval)
+	from := to.AddDate(0, 0, -*days)
+	random := rand.New(rand.NewSource(*randomSeed))
+	sensorCount, channelCount := 0, 0
+	for _, seed := range seedNodes {
+		node, err := nodeRepository.Create(ctx, tx, &entities.NodeCreate{
+			Name:       seed.name,
+			Location:   seed.location,
+			IdHardware: hardwareIds["ESP32 DevKit"],
+		}, &user)
+		if err != nil {
+			return err
+		}
+
+		for _, sensorSeed := range seed.sensors {
+			sensor, err := sensorRepository.Create(ctx, tx, &entities.SensorCreate{
+				Name:       sensorSeed.name,
+				Unit:       sensorSeed.unit,
+				IdNode:     node.IdNode,
+				IdHardware: hardwareIds[sensorSeed.hardware],
+			})
+			if err != nil {
+				return err
+			}
+
+			rows := seedChannel(sensor.IdSensor, sensorSeed, from, to, *interval, random)
+			_, err = tx.CopyFrom(ctx, pgx.Identifier{"channel"}, []string{"time", "value", "id_sensor"}, pgx.CopyFromRows(rows))
+			if err != nil {
+				return err
+			}
+			sensorCount++
+			channelCount += len(rows)
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Created user %[1]s and admin %[1]s-admin with password %[2]s\n", *username, *password)
+	fmt.Printf("Created %d hardware, %d node, %d sensor and %d channel from %s to %s\n",
+		len(seedHardware), len(seedNodes), sensorCount, channelCount, from.Format(time.RFC3339), to.Format(time.RFC3339))
+	return nil
+}
+
+func seedUser(ctx context.Context, tx pgx.Tx, userRepository repositories.UserRepository, username string, password string, isAdmin bool) (entities.UserRead, error) {
+	user, err := userRepository.Create(ctx, tx, entities.UserCreate{
+		Email:    username + "@example.com",
+		Username: username,
+		Password: password,
+	})
+	if err != nil {
+		return user, err
+	}
+
+	err = userRepository.UpdateStatus(ctx, tx, user.IdUser, true)
+	if err != nil {
+		return user, err
+	}
+	user.Status = true
+
+	if isAdmin {
+		err = userRepository.UpdateIsAdmin(ctx, tx, user.IdUser, true)
+		if err != nil {
+			return user, err
+		}
+		user.IsAdmin = true
+	}
+	return user, nil
+}
+
+// seedChannel generate the channel of the sensor between from and to. The daily cycle peak at 14:00
+// in UTC+7 and a few gap is left to look like a real device
+func seedChannel(sensorId int, seed seedSensor, from time.Time, to time.Time, interval time.Duration, random *rand.Rand) [][]interface{} {
+	rows := [][]interface{}{}
+	drift := 0.0
+	precision := math.Pow(10, float64(seed.decimals))
+	for t := from; t.Before(to); t = t.Add(interval) {
+		// Offline for about an hour once every few days
+		if random.Float64() < float64(interval)/float64(3*24*time.Hour) {
+			t = t.Add(time.Hour)
+			continue
+		}
+
+		hour := float64(t.Add(7*time.Hour).Hour()) + float64(t.Minute())/60
+		cycle := math.Cos((hour - 14) / 24 * 2 * math.Pi)
+		drift = drift*0.995 + random.NormFloat64()*seed.noise*0.1
+		value := seed.base + seed.amplitude*cycle + drift + random.NormFloat64()*seed.noise
+		if seed.daylight {
+			value = math.Max(0, seed.amplitude*math.Cos((hour-12)/12*math.Pi)+random.NormFloat64()*seed.noise)
+		}
+		value = math.Round(value*precision) / precision
+		rows = append(rows, []interface{}{t, value, sensorId})
+	}
+	return rows
+}
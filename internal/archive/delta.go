@@ -0,0 +1,187 @@
+package archive
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"time"
+)
+
+// Compressed day block, deflate of:
+//
+//	version byte, count uvarint, decimals byte
+//	time: first microsecond varint, then the delta of the delta varint
+//	value: with decimals the value times 10^decimals as varint then its delta varint,
+//	       with rawDecimals the first float bits then the xor with the previous uvarint
+//
+// Sensor reading usually come at a fixed interval with a fixed precision, so most delta is one byte
+const (
+	deltaVersion = 1
+	rawDecimals  = 255
+	maxDecimals  = 6
+)
+
+// Estimated size of a channel row, the heap tuple (23 byte header padded to 24, time, value,
+// id_sensor, and a 4 byte line pointer) and its channel_id_sensor_time_idx entry. The compressed
+// day is compared with it
+const ChannelRowBytes = 52 + 28
+
+// valueDecimals return the smallest decimals that store every value exactly as an integer, or rawDecimals
+func valueDecimals(points []Point) byte {
+	for decimals := 0; decimals <= maxDecimals; decimals++ {
+		scale := math.Pow(10, float64(decimals))
+		exact := true
+		for _, point := range points {
+			scaled := math.Round(point.Value * scale)
+			if math.Abs(scaled) > 1<<53 || scaled/scale != point.Value {
+				exact = false
+				break
+			}
+		}
+		if exact {
+			return byte(decimals)
+		}
+	}
+	return rawDecimals
+}
+
+// EncodeDelta compress the points ordered by time, the value is restored exactly by DecodeDelta
+func EncodeDelta(points []Point) ([]byte, error) {
+	block := &bytes.Buffer{}
+	var scratch [binary.MaxVarintLen64]byte
+	putVarint := func(value int64) {
+		block.Write(scratch[:binary.PutVarint(scratch[:], value)])
+	}
+	putUvarint := func(value uint64) {
+		block.Write(scratch[:binary.PutUvarint(scratch[:], value)])
+	}
+
+	decimals := valueDecimals(points)
+	block.WriteByte(deltaVersion)
+	putUvarint(uint64(len(points)))
+	block.WriteByte(decimals)
+
+	var previousTime, previousDelta int64
+	for i, point := range points {
+		micro := point.Time.UnixMicro()
+		if i == 0 {
+			putVarint(micro)
+		} else {
+			delta := micro - previousTime
+			putVarint(delta - previousDelta)
+			previousDelta = delta
+		}
+		previousTime = micro
+	}
+
+	if decimals == rawDecimals {
+		var previous uint64
+		for i, point := range points {
+			bits := math.Float64bits(point.Value)
+			if i == 0 {
+				binary.LittleEndian.PutUint64(scratch[:8], bits)
+				block.Write(scratch[:8])
+			} else {
+				putUvarint(bits ^ previous)
+			}
+			previous = bits
+		}
+	} else {
+		scale := math.Pow(10, float64(decimals))
+		var previous int64
+		for _, point := range points {
+			scaled := int64(math.Round(point.Value * scale))
+			putVarint(scaled - previous)
+			previous = scaled
+		}
+	}
+
+	compressed := &bytes.Buffer{}
+	writer, err := flate.NewWriter(compressed, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	_, err = writer.Write(block.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	return compressed.Bytes(), nil
+}
+
+var errDeltaCorrupt = errors.New("compressed channel is corrupt")
+
+// DecodeDelta restore the points of EncodeDelta
+func DecodeDelta(data []byte) ([]Point, error) {
+	block, err := io.ReadAll(flate.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return nil, err
+	}
+	reader := bytes.NewReader(block)
+
+	version, err := reader.ReadByte()
+	if err != nil || version != deltaVersion {
+		return nil, errDeltaCorrupt
+	}
+	count, err := binary.ReadUvarint(reader)
+	if err != nil || count > uint64(len(block)) {
+		return nil, errDeltaCorrupt
+	}
+	decimals, err := reader.ReadByte()
+	if err != nil {
+		return nil, errDeltaCorrupt
+	}
+
+	points := make([]Point, count)
+	var micro, delta int64
+	for i := range points {
+		value, err := binary.ReadVarint(reader)
+		if err != nil {
+			return nil, errDeltaCorrupt
+		}
+		if i == 0 {
+			micro = value
+		} else {
+			delta += value
+			micro += delta
+		}
+		points[i].Time = time.UnixMicro(micro).UTC()
+	}
+
+	if decimals == rawDecimals {
+		var bits uint64
+		for i := range points {
+			if i == 0 {
+				var first [8]byte
+				_, err = io.ReadFull(reader, first[:])
+				bits = binary.LittleEndian.Uint64(first[:])
+			} else {
+				var xor uint64
+				xor, err = binary.ReadUvarint(reader)
+				bits ^= xor
+			}
+			if err != nil {
+				return nil, errDeltaCorrupt
+			}
+			points[i].Value = math.Float64frombits(bits)
+		}
+	} else {
+		scale := math.Pow(10, float64(decimals))
+		var scaled int64
+		for i := range points {
+			delta, err := binary.ReadVarint(reader)
+			if err != nil {
+				return nil, errDeltaCorrupt
+			}
+			scaled += delta
+			points[i].Value = float64(scaled) / scale
+		}
+	}
+	return points, nil
+}
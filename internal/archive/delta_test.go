@@ -0,0 +1,184 @@
+package archive_test
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/archive"
+)
+
+// generateDay return a day of a sensor read every interval, a daily cycle with noise rounded to the
+// decimals, or kept as is with a negative decimals
+func generateDay(interval time.Duration, decimals int) []archive.Point {
+	random := rand.New(rand.NewSource(1))
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	precision := math.Pow(10, float64(decimals))
+	points := []archive.Point{}
+	for t := from; t.Before(from.Add(24 * time.Hour)); t = t.Add(interval) {
+		cycle := math.Cos(float64(t.Sub(from)) / float64(24*time.Hour) * 2 * math.Pi)
+		value := 27 + 4*cycle + random.NormFloat64()*0.3
+		if decimals >= 0 {
+			value = math.Round(value*precision) / precision
+		}
+		points = append(points, archive.Point{Time: t, Value: value})
+	}
+	return points
+}
+
+var deltaCases = []struct {
+	name     string
+	interval time.Duration
+	decimals int
+}{
+	{"10s", 10 * time.Second, 1},
+	{"1m", time.Minute, 1},
+	{"10m", 10 * time.Minute, 1},
+	{"10s-integer", 10 * time.Second, 0},
+	{"10s-raw", 10 * time.Second, -1},
+}
+
+func TestDeltaRoundTrip(t *testing.T) {
+	for _, tc := range deltaCases {
+		t.Run(tc.name, func(t *testing.T) {
+			points := generateDay(tc.interval, tc.decimals)
+			compressed, err := archive.EncodeDelta(points)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := archive.DecodeDelta(compressed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(decoded) != len(points) {
+				t.Fatalf("decoded %d points, want %d", len(decoded), len(points))
+			}
+			for i := range points {
+				if !decoded[i].Time.Equal(points[i].Time) || decoded[i].Value != points[i].Value {
+					t.Fatalf("point %d is %v %v, want %v %v", i, decoded[i].Time, decoded[i].Value, points[i].Time, points[i].Value)
+				}
+			}
+		})
+	}
+}
+
+func TestDeltaIrregularTime(t *testing.T) {
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	points := []archive.Point{
+		{Time: from, Value: 1.5},
+		{Time: from.Add(time.Second), Value: -2},
+		{Time: from.Add(time.Hour), Value: 1e9},
+		{Time: from.Add(time.Hour + time.Microsecond), Value: 0.000001},
+	}
+	compressed, err := archive.EncodeDelta(points)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := archive.DecodeDelta(compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range points {
+		if !decoded[i].Time.Equal(points[i].Time) || decoded[i].Value != points[i].Value {
+			t.Fatalf("point %d is %v %v, want %v %v", i, decoded[i].Time, decoded[i].Value, points[i].Time, points[i].Value)
+		}
+	}
+}
+
+func TestDeltaEmpty(t *testing.T) {
+	compressed, err := archive.EncodeDelta(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := archive.DecodeDelta(compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 0 {
+		t.Fatalf("decoded %d points, want 0", len(decoded))
+	}
+}
+
+func TestDeltaCorrupt(t *testing.T) {
+	compressed, err := archive.EncodeDelta(generateDay(time.Minute, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = archive.DecodeDelta(compressed[:len(compressed)/2])
+	if err == nil {
+		t.Fatal("decoding a truncated block should fail")
+	}
+	_, err = archive.DecodeDelta([]byte("not a block"))
+	if err == nil {
+		t.Fatal("decoding garbage should fail")
+	}
+}
+
+// BenchmarkEncodeDelta compress a sensor day, like the channel-compress job does for every sensor day.
+// The storage reduction against the channel table and Parquet is reported as table-x and parquet-x
+func BenchmarkEncodeDelta(b *testing.B) {
+	for _, tc := range deltaCases {
+		b.Run(tc.name, func(b *testing.B) {
+			points := generateDay(tc.interval, tc.decimals)
+			tableBytes := len(points) * archive.ChannelRowBytes
+			parquet := &bytes.Buffer{}
+			err := archive.Write(parquet, points)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.SetBytes(int64(tableBytes))
+			b.ResetTimer()
+			var compressed []byte
+			for i := 0; i < b.N; i++ {
+				compressed, err = archive.EncodeDelta(points)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+
+			b.ReportMetric(float64(len(compressed)), "bytes/day")
+			b.ReportMetric(float64(len(compressed))/float64(len(points)), "bytes/point")
+			b.ReportMetric(float64(tableBytes)/float64(len(compressed)), "table-x")
+			b.ReportMetric(float64(parquet.Len())/float64(len(compressed)), "parquet-x")
+		})
+	}
+}
+
+// BenchmarkDecodeDelta restore a sensor day, the hot path of reading a compressed channel
+func BenchmarkDecodeDelta(b *testing.B) {
+	for _, tc := range deltaCases {
+		b.Run(tc.name, func(b *testing.B) {
+			points := generateDay(tc.interval, tc.decimals)
+			compressed, err := archive.EncodeDelta(points)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.SetBytes(int64(len(points) * archive.ChannelRowBytes))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := archive.DecodeDelta(compressed)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(len(points)), "points/op")
+		})
+	}
+}
+
+// BenchmarkWriteParquet is the archive format the compressed day is compared with
+func BenchmarkWriteParquet(b *testing.B) {
+	points := generateDay(10*time.Second, 1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := archive.Write(&bytes.Buffer{}, points)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
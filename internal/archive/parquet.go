@@ -0,0 +1,284 @@
+// Package archive encode the channel moved out of the channel table, the compressed day block
+// kept in postgres (delta.go) and the cold storage file. The file is Parquet with one row group of
+// two required column, time (INT64 TIMESTAMP_MICROS, UTC) and value (DOUBLE), each in a single
+// PLAIN encoded data page compressed with GZIP, so any Parquet tool can read it.
+// Only the file written by this package is supported by Read.
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+const parquetMagic = "PAR1"
+
+// Parquet enum used by the file
+const (
+	parquetTypeInt64        = 2
+	parquetTypeDouble       = 5
+	parquetRequired         = 0
+	parquetTimestampMicros  = 10
+	parquetEncodingPlain    = 0
+	parquetEncodingRle      = 3
+	parquetCodecNone        = 0
+	parquetCodecGzip        = 2
+	parquetPageTypeDataPage = 0
+)
+
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+type column struct {
+	name          string
+	parquetType   int32
+	convertedType int32
+	data          []byte
+}
+
+// Write encode the points as a Parquet file, the point is written in the given order
+func Write(w io.Writer, points []Point) error {
+	timeData := make([]byte, 8*len(points))
+	valueData := make([]byte, 8*len(points))
+	for i, point := range points {
+		binary.LittleEndian.PutUint64(timeData[8*i:], uint64(point.Time.UnixMicro()))
+		binary.LittleEndian.PutUint64(valueData[8*i:], math.Float64bits(point.Value))
+	}
+	columns := []column{
+		{name: "time", parquetType: parquetTypeInt64, convertedType: parquetTimestampMicros, data: timeData},
+		{name: "value", parquetType: parquetTypeDouble, convertedType: -1, data: valueData},
+	}
+
+	body := bytes.NewBufferString(parquetMagic)
+	chunks := &thriftWriter{}
+	var totalSize int64
+	for _, column := range columns {
+		compressed := &bytes.Buffer{}
+		writer := gzip.NewWriter(compressed)
+		_, err := writer.Write(column.data)
+		if err != nil {
+			return err
+		}
+		err = writer.Close()
+		if err != nil {
+			return err
+		}
+
+		header := &thriftWriter{}
+		header.i32(1, parquetPageTypeDataPage)
+		header.i32(2, int32(len(column.data)))
+		header.i32(3, int32(compressed.Len()))
+		header.structField(5)
+		header.i32(1, int32(len(points)))
+		header.i32(2, parquetEncodingPlain)
+		header.i32(3, parquetEncodingRle)
+		header.i32(4, parquetEncodingRle)
+		header.structEnd()
+		header.structEnd()
+
+		offset := int64(body.Len())
+		uncompressedSize := int64(header.buffer.Len() + len(column.data))
+		compressedSize := int64(header.buffer.Len() + compressed.Len())
+		totalSize += uncompressedSize
+		body.Write(header.buffer.Bytes())
+		body.Write(compressed.Bytes())
+
+		// ColumnChunk with its ColumnMetaData
+		chunks.structElement()
+		chunks.i64(2, offset)
+		chunks.structField(3)
+		chunks.i32(1, column.parquetType)
+		chunks.list(2, thriftI32, 2)
+		chunks.i32Element(parquetEncodingPlain)
+		chunks.i32Element(parquetEncodingRle)
+		chunks.list(3, thriftBinary, 1)
+		chunks.stringElement(column.name)
+		chunks.i32(4, parquetCodecGzip)
+		chunks.i64(5, int64(len(points)))
+		chunks.i64(6, uncompressedSize)
+		chunks.i64(7, compressedSize)
+		chunks.i64(9, offset)
+		chunks.structEnd()
+		chunks.structEnd()
+	}
+
+	// FileMetaData
+	meta := &thriftWriter{}
+	meta.i32(1, 1)
+	meta.list(2, thriftStruct, len(columns)+1)
+	meta.structElement()
+	meta.string(4, "schema")
+	meta.i32(5, int32(len(columns)))
+	meta.structEnd()
+	for _, column := range columns {
+		meta.structElement()
+		meta.i32(1, column.parquetType)
+		meta.i32(3, parquetRequired)
+		meta.string(4, column.name)
+		if column.convertedType >= 0 {
+			meta.i32(6, column.convertedType)
+		}
+		meta.structEnd()
+	}
+	meta.i64(3, int64(len(points)))
+	meta.list(4, thriftStruct, 1)
+	meta.structElement()
+	meta.list(1, thriftStruct, len(columns))
+	meta.buffer.Write(chunks.buffer.Bytes())
+	meta.i64(2, totalSize)
+	meta.i64(3, int64(len(points)))
+	meta.structEnd()
+	meta.string(6, "iot-server")
+	meta.structEnd()
+
+	body.Write(meta.buffer.Bytes())
+	err := binary.Write(body, binary.LittleEndian, uint32(meta.buffer.Len()))
+	if err != nil {
+		return err
+	}
+	body.WriteString(parquetMagic)
+
+	_, err = w.Write(body.Bytes())
+	return err
+}
+
+// Read decode the points of a file written by Write
+func Read(data []byte) (points []Point, err error) {
+	if len(data) < 12 || string(data[:4]) != parquetMagic || string(data[len(data)-4:]) != parquetMagic {
+		return nil, errors.New("not a parquet file")
+	}
+	metaSize := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	if metaSize <= 0 || metaSize > len(data)-12 {
+		return nil, errThriftTruncated
+	}
+
+	reader := &thriftReader{data: data[len(data)-8-metaSize : len(data)-8]}
+	meta := reader.readStruct()
+	if reader.err != nil {
+		return nil, reader.err
+	}
+
+	// The first schema element is the root, the rest is the column
+	for i, element := range meta.list(2) {
+		schema, _ := element.(thriftFields)
+		repetition, _ := schema.int(3)
+		if i > 0 && repetition != parquetRequired {
+			name, _ := schema.bytes(4)
+			return nil, fmt.Errorf("parquet column %s is not required", name)
+		}
+	}
+
+	points = []Point{}
+	for _, element := range meta.list(4) {
+		rowGroup, _ := element.(thriftFields)
+		var times, values []byte
+		for _, element := range rowGroup.list(1) {
+			chunk, _ := element.(thriftFields)
+			columnMeta, ok := chunk.fields(3)
+			if !ok {
+				return nil, errors.New("parquet column chunk has no metadata")
+			}
+			path := columnMeta.list(3)
+			if len(path) != 1 {
+				return nil, errors.New("parquet column is nested")
+			}
+			name, _ := path[0].([]byte)
+
+			columnData, err := readColumn(data, columnMeta)
+			if err != nil {
+				return nil, fmt.Errorf("parquet column %s: %w", name, err)
+			}
+			switch string(name) {
+			case "time":
+				times = columnData
+			case "value":
+				values = columnData
+			}
+		}
+
+		if len(times) != len(values) {
+			return nil, errors.New("parquet time and value column have different length")
+		}
+		for i := 0; i+8 <= len(times); i += 8 {
+			points = append(points, Point{
+				Time:  time.UnixMicro(int64(binary.LittleEndian.Uint64(times[i:]))).UTC(),
+				Value: math.Float64frombits(binary.LittleEndian.Uint64(values[i:])),
+			})
+		}
+	}
+	return points, nil
+}
+
+// readColumn return the PLAIN encoded value of every data page of the column chunk
+func readColumn(data []byte, columnMeta thriftFields) ([]byte, error) {
+	if _, ok := columnMeta.int(11); ok {
+		return nil, errors.New("dictionary page is not supported")
+	}
+	codec, _ := columnMeta.int(4)
+	numValues, _ := columnMeta.int(5)
+	offset, _ := columnMeta.int(9)
+
+	columnData := []byte{}
+	for read := int64(0); read < numValues; {
+		if offset < 0 || offset >= int64(len(data)) {
+			return nil, errThriftTruncated
+		}
+		reader := &thriftReader{data: data[offset:]}
+		header := reader.readStruct()
+		if reader.err != nil {
+			return nil, reader.err
+		}
+		pageType, _ := header.int(1)
+		compressedSize, _ := header.int(3)
+		dataPage, ok := header.fields(5)
+		if pageType != parquetPageTypeDataPage || !ok {
+			return nil, errors.New("only data page is supported")
+		}
+		if encoding, _ := dataPage.int(2); encoding != parquetEncodingPlain {
+			return nil, errors.New("only plain encoding is supported")
+		}
+		// num_values is an i32
+		pageValues, _ := dataPage.int(1)
+		if pageValues <= 0 || pageValues > math.MaxInt32 {
+			return nil, errors.New("data page has an invalid value count")
+		}
+
+		start := offset + int64(reader.pos)
+		if compressedSize < 0 || compressedSize > int64(len(data))-start {
+			return nil, errThriftTruncated
+		}
+		end := start + compressedSize
+		page := data[start:end]
+
+		switch codec {
+		case parquetCodecNone:
+		case parquetCodecGzip:
+			decompressor, err := gzip.NewReader(bytes.NewReader(page))
+			if err != nil {
+				return nil, err
+			}
+			// A page decompressing to more than its value is read no further
+			page, err = io.ReadAll(io.LimitReader(decompressor, 8*pageValues+1))
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("compression codec %d is not supported", codec)
+		}
+		if int64(len(page)) != 8*pageValues {
+			return nil, errors.New("page size doesn't match its value count")
+		}
+
+		columnData = append(columnData, page...)
+		read += pageValues
+		offset = end
+	}
+	return columnData, nil
+}
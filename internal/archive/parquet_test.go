@@ -0,0 +1,190 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParquetRoundTrip(t *testing.T) {
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	day := []Point{}
+	for i := 0; i < 8640; i++ {
+		day = append(day, Point{Time: from.Add(time.Duration(i) * 10 * time.Second), Value: 27 + math.Sin(float64(i)/100)})
+	}
+	tests := []struct {
+		name   string
+		points []Point
+	}{
+		{"empty", []Point{}},
+		{"one", []Point{{Time: from, Value: 21.5}}},
+		{"microsecond", []Point{{Time: from.Add(time.Microsecond), Value: -1}, {Time: from, Value: 0}}},
+		{"before 1970", []Point{{Time: time.Date(1960, 1, 1, 0, 0, 0, 0, time.UTC), Value: math.MaxFloat64}}},
+		{"special value", []Point{{Time: from, Value: math.Inf(1)}, {Time: from, Value: math.Inf(-1)}, {Time: from, Value: math.NaN()}}},
+		{"day", day},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			file := &bytes.Buffer{}
+			err := Write(file, tc.points)
+			if err != nil {
+				t.Fatal(err)
+			}
+			points, err := Read(file.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(points) != len(tc.points) {
+				t.Fatalf("read %d point, want %d", len(points), len(tc.points))
+			}
+			for i, point := range tc.points {
+				if !points[i].Time.Equal(point.Time) || math.Float64bits(points[i].Value) != math.Float64bits(point.Value) {
+					t.Fatalf("point %d is %+v, want %+v", i, points[i], point)
+				}
+			}
+		})
+	}
+}
+
+func TestParquetTruncated(t *testing.T) {
+	file := &bytes.Buffer{}
+	err := Write(file, []Point{{Time: time.Unix(1714521600, 0), Value: 21.5}, {Time: time.Unix(1714521610, 0), Value: 21.6}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := file.Bytes()
+	for size := 0; size < len(data); size++ {
+		_, err := Read(data[:size])
+		if err == nil {
+			t.Fatalf("reading %d of %d byte should fail", size, len(data))
+		}
+	}
+}
+
+// testPage is a data page of testFile, compressedSize is the length of data when it is 0
+type testPage struct {
+	pageType       int32
+	encoding       int32
+	values         int64
+	compressedSize int64
+	data           []byte
+}
+
+// testFile return a file with a time and value column of the same pages, so the malformed page
+// Write never write can be read
+func testFile(codec int32, numValues int64, pages ...testPage) []byte {
+	body := bytes.NewBufferString(parquetMagic)
+	chunks := &thriftWriter{}
+	for _, name := range []string{"time", "value"} {
+		offset := int64(body.Len())
+		for _, page := range pages {
+			compressedSize := page.compressedSize
+			if compressedSize == 0 {
+				compressedSize = int64(len(page.data))
+			}
+			header := &thriftWriter{}
+			header.i32(1, page.pageType)
+			header.i32(2, int32(len(page.data)))
+			header.i64(3, compressedSize)
+			header.structField(5)
+			header.i64(1, page.values)
+			header.i32(2, page.encoding)
+			header.structEnd()
+			header.structEnd()
+			body.Write(header.buffer.Bytes())
+			body.Write(page.data)
+		}
+
+		chunks.structElement()
+		chunks.structField(3)
+		chunks.list(3, thriftBinary, 1)
+		chunks.stringElement(name)
+		chunks.i32(4, codec)
+		chunks.i64(5, numValues)
+		chunks.i64(9, offset)
+		chunks.structEnd()
+		chunks.structEnd()
+	}
+
+	meta := &thriftWriter{}
+	meta.list(4, thriftStruct, 1)
+	meta.structElement()
+	meta.list(1, thriftStruct, 2)
+	meta.buffer.Write(chunks.buffer.Bytes())
+	meta.structEnd()
+	meta.structEnd()
+	body.Write(meta.buffer.Bytes())
+	binary.Write(body, binary.LittleEndian, uint32(meta.buffer.Len()))
+	body.WriteString(parquetMagic)
+	return body.Bytes()
+}
+
+func TestParquetMalformed(t *testing.T) {
+	twoValues := make([]byte, 16)
+	compressed := &bytes.Buffer{}
+	writer := gzip.NewWriter(compressed)
+	writer.Write(make([]byte, 1<<20))
+	writer.Close()
+
+	// Two page of one value are read as two point
+	points, err := Read(testFile(parquetCodecNone, 2, testPage{values: 1, data: twoValues[:8]}, testPage{values: 1, data: twoValues[8:]}))
+	if err != nil || len(points) != 2 {
+		t.Fatalf("read %d point with %v", len(points), err)
+	}
+
+	tests := map[string][]byte{
+		"not parquet":              []byte("PAR0" + "00000000" + "PAR1"),
+		"empty metadata":           []byte("PAR1" + "\x00\x00\x00\x00" + "PAR1"),
+		"metadata larger":          []byte("PAR1" + "\x00" + "\x10\x00\x00\x00" + "PAR1"),
+		"dictionary page":          testFile(parquetCodecNone, 2, testPage{pageType: 2, values: 2, data: twoValues}),
+		"other encoding":           testFile(parquetCodecNone, 2, testPage{encoding: 2, values: 2, data: twoValues}),
+		"page without value":       testFile(parquetCodecNone, 2, testPage{values: 0, data: twoValues}),
+		"value count over i32":     testFile(parquetCodecNone, 2, testPage{values: 1<<61 + 2, data: twoValues}),
+		"page size mismatch":       testFile(parquetCodecNone, 3, testPage{values: 3, data: twoValues}),
+		"compressed size negative": testFile(parquetCodecNone, 2, testPage{values: 2, compressedSize: -1, data: twoValues}),
+		"compressed size overflow": testFile(parquetCodecNone, 2, testPage{values: 2, compressedSize: math.MaxInt64, data: twoValues}),
+		"missing page":             testFile(parquetCodecNone, 4, testPage{values: 2, data: twoValues}),
+		"unknown codec":            testFile(1, 2, testPage{values: 2, data: twoValues}),
+		"invalid gzip":             testFile(parquetCodecGzip, 2, testPage{values: 2, data: twoValues}),
+		"gzip larger than page":    testFile(parquetCodecGzip, 1, testPage{values: 1, data: compressed.Bytes()}),
+	}
+	for name, file := range tests {
+		t.Run(name, func(t *testing.T) {
+			points, err := Read(file)
+			if err == nil {
+				t.Fatalf("got %d point, want an error", len(points))
+			}
+		})
+	}
+}
+
+// FuzzRead check a file from the storage never panic, and that its points are written back the same
+func FuzzRead(f *testing.F) {
+	file := &bytes.Buffer{}
+	Write(file, []Point{{Time: time.Unix(1714521600, 0), Value: 21.5}, {Time: time.Unix(1714521610, 0), Value: 21.6}})
+	f.Add(file.Bytes())
+	f.Add(testFile(parquetCodecNone, 2, testPage{values: 1, data: make([]byte, 8)}, testPage{values: 1, data: make([]byte, 8)}))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		points, err := Read(data)
+		if err != nil {
+			return
+		}
+		written := &bytes.Buffer{}
+		err = Write(written, points)
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, err := Read(written.Bytes())
+		if err != nil || len(again) != len(points) {
+			t.Fatalf("read back %d of %d point with %v", len(again), len(points), err)
+		}
+		for i := range points {
+			if !again[i].Time.Equal(points[i].Time) || math.Float64bits(again[i].Value) != math.Float64bits(points[i].Value) {
+				t.Fatalf("point %d is read back as %+v, want %+v", i, again[i], points[i])
+			}
+		}
+	})
+}
@@ -0,0 +1,268 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Thrift compact protocol type of the field header
+const (
+	thriftBoolTrue  = 1
+	thriftBoolFalse = 2
+	thriftByte      = 3
+	thriftI16       = 4
+	thriftI32       = 5
+	thriftI64       = 6
+	thriftDouble    = 7
+	thriftBinary    = 8
+	thriftList      = 9
+	thriftSet       = 10
+	thriftMap       = 11
+	thriftStruct    = 12
+)
+
+// Struct, list and map nested deeper than this are a corrupted file, each level take a single byte so
+// a file of nested value would exhaust the stack
+const thriftMaxNesting = 64
+
+var errThriftTruncated = errors.New("parquet metadata is truncated")
+
+// thriftWriter encode the struct of the parquet metadata with the thrift compact protocol.
+// Field must be written in increasing id inside a struct
+type thriftWriter struct {
+	buffer bytes.Buffer
+	lastId int16
+	stack  []int16
+}
+
+func (w *thriftWriter) varint(value uint64) {
+	var scratch [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(scratch[:], value)
+	w.buffer.Write(scratch[:n])
+}
+
+func (w *thriftWriter) zigzag(value int64) {
+	w.varint(uint64((value << 1) ^ (value >> 63)))
+}
+
+func (w *thriftWriter) field(id int16, fieldType byte) {
+	delta := id - w.lastId
+	if delta > 0 && delta <= 15 {
+		w.buffer.WriteByte(byte(delta)<<4 | fieldType)
+	} else {
+		w.buffer.WriteByte(fieldType)
+		w.zigzag(int64(id))
+	}
+	w.lastId = id
+}
+
+func (w *thriftWriter) i32(id int16, value int32) {
+	w.field(id, thriftI32)
+	w.zigzag(int64(value))
+}
+
+func (w *thriftWriter) i64(id int16, value int64) {
+	w.field(id, thriftI64)
+	w.zigzag(value)
+}
+
+func (w *thriftWriter) string(id int16, value string) {
+	w.field(id, thriftBinary)
+	w.varint(uint64(len(value)))
+	w.buffer.WriteString(value)
+}
+
+func (w *thriftWriter) list(id int16, elementType byte, size int) {
+	w.field(id, thriftList)
+	if size < 15 {
+		w.buffer.WriteByte(byte(size)<<4 | elementType)
+	} else {
+		w.buffer.WriteByte(0xf0 | elementType)
+		w.varint(uint64(size))
+	}
+}
+
+func (w *thriftWriter) i32Element(value int32) {
+	w.zigzag(int64(value))
+}
+
+func (w *thriftWriter) stringElement(value string) {
+	w.varint(uint64(len(value)))
+	w.buffer.WriteString(value)
+}
+
+// structField start a struct value of a field, structElement one of a list
+func (w *thriftWriter) structField(id int16) {
+	w.field(id, thriftStruct)
+	w.structElement()
+}
+
+func (w *thriftWriter) structElement() {
+	w.stack = append(w.stack, w.lastId)
+	w.lastId = 0
+}
+
+func (w *thriftWriter) structEnd() {
+	w.buffer.WriteByte(0)
+	if len(w.stack) > 0 {
+		w.lastId = w.stack[len(w.stack)-1]
+		w.stack = w.stack[:len(w.stack)-1]
+	}
+}
+
+// thriftFields is a decoded struct by field id. The value is int64, float64, bool, []byte,
+// []interface{} or thriftFields for a nested struct
+type thriftFields map[int16]interface{}
+
+func (f thriftFields) int(id int16) (int64, bool) {
+	value, ok := f[id].(int64)
+	return value, ok
+}
+
+func (f thriftFields) bytes(id int16) ([]byte, bool) {
+	value, ok := f[id].([]byte)
+	return value, ok
+}
+
+func (f thriftFields) list(id int16) []interface{} {
+	value, _ := f[id].([]interface{})
+	return value
+}
+
+func (f thriftFields) fields(id int16) (thriftFields, bool) {
+	value, ok := f[id].(thriftFields)
+	return value, ok
+}
+
+// thriftReader decode any compact protocol struct, the first error is kept in err
+type thriftReader struct {
+	data  []byte
+	pos   int
+	err   error
+	depth int
+}
+
+func (r *thriftReader) byte() byte {
+	if r.pos >= len(r.data) {
+		r.err = errThriftTruncated
+		return 0
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *thriftReader) varint() uint64 {
+	value, n := binary.Uvarint(r.data[min(r.pos, len(r.data)):])
+	if n <= 0 {
+		r.err = errThriftTruncated
+		return 0
+	}
+	r.pos += n
+	return value
+}
+
+func (r *thriftReader) zigzag() int64 {
+	value := r.varint()
+	return int64(value>>1) ^ -int64(value&1)
+}
+
+func (r *thriftReader) readStruct() thriftFields {
+	fields := thriftFields{}
+	var lastId int16
+	for r.err == nil {
+		header := r.byte()
+		if header == 0 {
+			break
+		}
+
+		fieldType := header & 0x0f
+		id := lastId + int16(header>>4)
+		if header>>4 == 0 {
+			id = int16(r.zigzag())
+		}
+		lastId = id
+
+		switch fieldType {
+		case thriftBoolTrue:
+			fields[id] = true
+		case thriftBoolFalse:
+			fields[id] = false
+		default:
+			fields[id] = r.readValue(fieldType)
+		}
+	}
+	return fields
+}
+
+func (r *thriftReader) readValue(valueType byte) interface{} {
+	if r.depth >= thriftMaxNesting {
+		r.err = errors.New("parquet metadata is nested too deep")
+		return nil
+	}
+	r.depth++
+	defer func() { r.depth-- }()
+
+	switch valueType {
+	case thriftBoolTrue, thriftBoolFalse:
+		// Boolean inside a list is a byte
+		return r.byte() == thriftBoolTrue
+	case thriftByte:
+		return int64(int8(r.byte()))
+	case thriftI16, thriftI32, thriftI64:
+		return r.zigzag()
+	case thriftDouble:
+		if r.pos+8 > len(r.data) {
+			r.err = errThriftTruncated
+			return float64(0)
+		}
+		value := math.Float64frombits(binary.LittleEndian.Uint64(r.data[r.pos:]))
+		r.pos += 8
+		return value
+	case thriftBinary:
+		size := int(r.varint())
+		if size < 0 || size > len(r.data)-r.pos {
+			r.err = errThriftTruncated
+			return []byte{}
+		}
+		value := r.data[r.pos : r.pos+size]
+		r.pos += size
+		return value
+	case thriftList, thriftSet:
+		header := r.byte()
+		size := int(header >> 4)
+		if size == 15 {
+			size = int(r.varint())
+		}
+		values := []interface{}{}
+		for i := 0; i < size && r.err == nil; i++ {
+			values = append(values, r.readValue(header&0x0f))
+		}
+		return values
+	case thriftMap:
+		size := int(r.varint())
+		if size == 0 {
+			return []interface{}{}
+		}
+		types := r.byte()
+		values := []interface{}{}
+		for i := 0; i < size && r.err == nil; i++ {
+			values = append(values, r.readValue(types>>4), r.readValue(types&0x0f))
+		}
+		return values
+	case thriftStruct:
+		return r.readStruct()
+	default:
+		r.err = errors.New("parquet metadata has an unknown thrift type")
+		return nil
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
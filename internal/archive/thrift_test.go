@@ -0,0 +1,221 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestThriftGolden(t *testing.T) {
+	// The root schema element of Write, a short field header hold the id delta and the type
+	w := &thriftWriter{}
+	w.string(4, "schema")
+	w.i32(5, 2)
+	w.structEnd()
+	want := "48" + "06" + hex.EncodeToString([]byte("schema")) + "15" + "04" + "00"
+	if hex.EncodeToString(w.buffer.Bytes()) != want {
+		t.Fatalf("encoded %x, want %s", w.buffer.Bytes(), want)
+	}
+}
+
+func TestThriftRoundTrip(t *testing.T) {
+	longList := make([]int32, 20)
+	for i := range longList {
+		longList[i] = int32(i*1000 - 5000)
+	}
+
+	w := &thriftWriter{}
+	w.i32(1, -1)
+	w.i64(2, math.MaxInt64)
+	w.i64(3, math.MinInt64)
+	w.string(4, "")
+	w.string(5, strings.Repeat("a", 300))
+	w.list(6, thriftI32, 2)
+	w.i32Element(0)
+	w.i32Element(math.MaxInt32)
+	w.list(7, thriftI32, len(longList))
+	for _, value := range longList {
+		w.i32Element(value)
+	}
+	w.list(8, thriftBinary, 1)
+	w.stringElement("time")
+	// A delta over 15 take the long field header
+	w.structField(30)
+	w.i32(1, 7)
+	w.structField(2)
+	w.i64(1, 8)
+	w.structEnd()
+	w.structEnd()
+	w.list(31, thriftStruct, 2)
+	w.structElement()
+	w.i32(1, 9)
+	w.structEnd()
+	w.structElement()
+	w.structEnd()
+	w.i32(1000, 10)
+	w.structEnd()
+
+	r := &thriftReader{data: w.buffer.Bytes()}
+	fields := r.readStruct()
+	if r.err != nil || r.pos != len(r.data) {
+		t.Fatalf("read %d of %d byte with %v", r.pos, len(r.data), r.err)
+	}
+	check := func(id int16, want int64) {
+		if value, ok := fields.int(id); !ok || value != want {
+			t.Errorf("field %d is %d %v, want %d", id, value, ok, want)
+		}
+	}
+	check(1, -1)
+	check(2, math.MaxInt64)
+	check(3, math.MinInt64)
+	check(1000, 10)
+	if value, ok := fields.bytes(4); !ok || len(value) != 0 {
+		t.Errorf("field 4 is %q", value)
+	}
+	if value, _ := fields.bytes(5); string(value) != strings.Repeat("a", 300) {
+		t.Errorf("field 5 is %d byte", len(value))
+	}
+	if list := fields.list(6); len(list) != 2 || list[0] != int64(0) || list[1] != int64(math.MaxInt32) {
+		t.Errorf("field 6 is %v", list)
+	}
+	list := fields.list(7)
+	if len(list) != len(longList) {
+		t.Fatalf("field 7 has %d element, want %d", len(list), len(longList))
+	}
+	for i, value := range longList {
+		if list[i] != int64(value) {
+			t.Errorf("element %d of field 7 is %v, want %d", i, list[i], value)
+		}
+	}
+	if list := fields.list(8); len(list) != 1 || string(list[0].([]byte)) != "time" {
+		t.Errorf("field 8 is %v", list)
+	}
+	nested, ok := fields.fields(30)
+	if !ok {
+		t.Fatal("field 30 isn't a struct")
+	}
+	inner, _ := nested.fields(2)
+	if value, _ := nested.int(1); value != 7 {
+		t.Errorf("field 30.1 is %d", value)
+	}
+	if value, _ := inner.int(1); value != 8 {
+		t.Errorf("field 30.2.1 is %d", value)
+	}
+	if list := fields.list(31); len(list) != 2 || len(list[0].(thriftFields)) != 1 || len(list[1].(thriftFields)) != 0 {
+		t.Errorf("field 31 is %v", list)
+	}
+}
+
+func TestThriftReaderValue(t *testing.T) {
+	// The type Write doesn't use are still read, a reader may get a file with any of them
+	tests := []struct {
+		name string
+		data string
+		want interface{}
+	}{
+		{"bool true", "11" + "00", true},
+		{"bool false", "12" + "00", false},
+		{"byte", "13" + "ff" + "00", int64(-1)},
+		{"i16", "14" + "0f" + "00", int64(-8)},
+		{"double", "17" + "000000000000f83f" + "00", 1.5},
+		{"set", "1a" + "25" + "02" + "04" + "00", []interface{}{int64(1), int64(2)}},
+		{"bool list", "19" + "21" + "01" + "02" + "00", []interface{}{true, false}},
+		{"map", "1b" + "02" + "85" + "0161" + "02" + "0162" + "04" + "00", []interface{}{[]byte("a"), int64(1), []byte("b"), int64(2)}},
+		{"empty map", "1b" + "00" + "00", []interface{}{}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			data, _ := hex.DecodeString(tc.data)
+			r := &thriftReader{data: data}
+			fields := r.readStruct()
+			if r.err != nil || r.pos != len(data) {
+				t.Fatalf("read %d of %d byte with %v", r.pos, len(data), r.err)
+			}
+			if !equalThrift(fields[1], tc.want) {
+				t.Fatalf("got %#v, want %#v", fields[1], tc.want)
+			}
+		})
+	}
+}
+
+func equalThrift(a interface{}, b interface{}) bool {
+	switch a := a.(type) {
+	case []byte:
+		b, ok := b.([]byte)
+		return ok && bytes.Equal(a, b)
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equalThrift(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+func TestThriftReaderMalformed(t *testing.T) {
+	tests := map[string]string{
+		"empty":              "",
+		"no stop":            "1502",
+		"truncated varint":   "1580",
+		"truncated field id": "0580",
+		"truncated binary":   "18" + "05" + "6162",
+		"huge binary":        "18" + "ffffffffffffffff7f",
+		"truncated double":   "17" + "000000",
+		"truncated list":     "19" + "35" + "02",
+		"truncated map":      "1b" + "02" + "55" + "02",
+		"unknown type":       "1d" + "00",
+		"unknown list type":  "19" + "1d" + "00",
+		"nested struct":      strings.Repeat("1c", thriftMaxNesting+1) + strings.Repeat("00", thriftMaxNesting+2),
+		"nested list":        "19" + strings.Repeat("19", thriftMaxNesting) + "00" + "00",
+	}
+	for name, message := range tests {
+		t.Run(name, func(t *testing.T) {
+			data, _ := hex.DecodeString(message)
+			r := &thriftReader{data: data}
+			r.readStruct()
+			if r.err == nil {
+				t.Fatalf("reading %s should fail", message)
+			}
+		})
+	}
+
+	// The deepest struct allowed is read
+	data, _ := hex.DecodeString(strings.Repeat("1c", thriftMaxNesting) + strings.Repeat("00", thriftMaxNesting+1))
+	r := &thriftReader{data: data}
+	r.readStruct()
+	if r.err != nil || r.pos != len(data) {
+		t.Fatalf("read %d of %d byte with %v", r.pos, len(data), r.err)
+	}
+}
+
+// FuzzThriftReader check the metadata of a file never panic nor read past its end
+func FuzzThriftReader(f *testing.F) {
+	w := &thriftWriter{}
+	w.i32(1, 1)
+	w.list(2, thriftStruct, 1)
+	w.structElement()
+	w.string(4, "schema")
+	w.structEnd()
+	w.structField(3)
+	w.i64(1, -1)
+	w.structEnd()
+	w.structEnd()
+	f.Add(w.buffer.Bytes())
+	f.Add([]byte{0x1b, 0x02, 0x85, 0x01, 0x61, 0x02, 0x01, 0x62, 0x04, 0x00})
+	f.Add([]byte{0x17, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf8, 0x3f, 0x00})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := &thriftReader{data: data}
+		r.readStruct()
+		if r.pos > len(data) || r.depth != 0 {
+			t.Fatalf("reader is at %d of %d byte and depth %d", r.pos, len(data), r.depth)
+		}
+	})
+}
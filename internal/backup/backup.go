@@ -0,0 +1,175 @@
+// Package backup read and write the logical backup file. The file is gzip compressed JSON lines,
+// the first line is the Header, then each table start with a Section line followed by one line per row.
+// The row is the JSON of the postgres row_to_json so it can be restored with json_populate_recordset.
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const Version = 1
+
+type Table struct {
+	Name string `json:"name"`
+	Rows int64  `json:"rows"`
+}
+
+type Header struct {
+	Version   int        `json:"version"`
+	CreatedAt time.Time  `json:"created_at"`
+	Tables    []Table    `json:"tables"`
+	Channel   bool       `json:"channel"`
+	From      *time.Time `json:"from,omitempty"`
+	To        *time.Time `json:"to,omitempty"`
+}
+
+// TotalRows is the number of row of every table, used to report the progress
+func (h *Header) TotalRows() (total int64) {
+	for _, table := range h.Tables {
+		total += table.Rows
+	}
+	return total
+}
+
+type section struct {
+	Table string `json:"table"`
+	Rows  int64  `json:"rows"`
+}
+
+type Writer struct {
+	gzip   *gzip.Writer
+	buffer *bufio.Writer
+	left   int64
+}
+
+func NewWriter(w io.Writer, header Header) (*Writer, error) {
+	header.Version = Version
+	zipper := gzip.NewWriter(w)
+	writer := &Writer{gzip: zipper, buffer: bufio.NewWriterSize(zipper, 64*1024)}
+	return writer, writer.writeJSON(header)
+}
+
+func (w *Writer) writeJSON(value interface{}) error {
+	line, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return w.writeLine(line)
+}
+
+func (w *Writer) writeLine(line []byte) error {
+	_, err := w.buffer.Write(line)
+	if err != nil {
+		return err
+	}
+	return w.buffer.WriteByte('\n')
+}
+
+// WriteTable start the section of the table, exactly rows call of WriteRow must follow
+func (w *Writer) WriteTable(name string, rows int64) error {
+	if w.left != 0 {
+		return fmt.Errorf("%d row is missing from the previous table", w.left)
+	}
+	w.left = rows
+	return w.writeJSON(section{Table: name, Rows: rows})
+}
+
+func (w *Writer) WriteRow(row []byte) error {
+	if w.left == 0 {
+		return errors.New("more row than declared for the table")
+	}
+	w.left--
+	return w.writeLine(row)
+}
+
+func (w *Writer) Close() error {
+	if w.left != 0 {
+		return fmt.Errorf("%d row is missing from the last table", w.left)
+	}
+	err := w.buffer.Flush()
+	if err != nil {
+		return err
+	}
+	return w.gzip.Close()
+}
+
+type Reader struct {
+	Header  Header
+	gzip    *gzip.Reader
+	scanner *bufio.Scanner
+	table   string
+	left    int64
+}
+
+// Row longer than this is rejected, the biggest row is a dashboard template with its widgets
+const maxLineSize = 16 * 1024 * 1024
+
+func NewReader(r io.Reader) (*Reader, error) {
+	zipper, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a backup file: %w", err)
+	}
+	scanner := bufio.NewScanner(zipper)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+
+	reader := &Reader{gzip: zipper, scanner: scanner}
+	line, err := reader.readLine()
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(line, &reader.Header)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup header: %w", err)
+	}
+	if reader.Header.Version != Version {
+		return nil, fmt.Errorf("unsupported backup version %d", reader.Header.Version)
+	}
+	return reader, nil
+}
+
+func (r *Reader) readLine() ([]byte, error) {
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	return r.scanner.Bytes(), nil
+}
+
+// Next return the next row and its table, the row is only valid until the next call.
+// io.EOF is returned after the last row
+func (r *Reader) Next() (table string, row []byte, err error) {
+	for r.left == 0 {
+		line, err := r.readLine()
+		if err != nil {
+			return "", nil, err
+		}
+		current := section{}
+		err = json.Unmarshal(line, &current)
+		if err != nil || current.Table == "" {
+			return "", nil, fmt.Errorf("invalid backup section: %s", line)
+		}
+		r.table, r.left = current.Table, current.Rows
+	}
+
+	row, err = r.readLine()
+	if err == io.EOF {
+		return "", nil, fmt.Errorf("backup is truncated in table %s", r.table)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	r.left--
+	return r.table, row, nil
+}
+
+func (r *Reader) Close() error {
+	return r.gzip.Close()
+}
@@ -0,0 +1,198 @@
+// Package bacnet read the object property of BACnet/IP building automation controller, e.g. the
+// present value of an analog input, and store it in the sensor channel mapped to the property.
+package bacnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/ingest"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	defaultTimeout = 3 * time.Second
+	// Device read at the same time, the item of one device are read one after another
+	maxParallelDevice = 16
+	maxFrameSize      = 1500
+)
+
+// Poller read every mapped item once per Poll, it is run by the scheduler so only one instance poll
+type Poller struct {
+	db         *pgxpool.Pool
+	repository *repositories.BacnetRepository
+	pipeline   *ingest.Pipeline
+	timeout    time.Duration
+	retries    int
+}
+
+func NewPoller(db *pgxpool.Pool, bacnetRepository *repositories.BacnetRepository, pipeline *ingest.Pipeline, config *configs.Config) (*Poller, error) {
+	poller := &Poller{
+		db:         db,
+		repository: bacnetRepository,
+		pipeline:   pipeline,
+		timeout:    time.Duration(config.Bacnet.TimeoutMs) * time.Millisecond,
+		retries:    config.Bacnet.Retries,
+	}
+	if poller.timeout <= 0 {
+		poller.timeout = defaultTimeout
+	}
+	if poller.retries < 0 {
+		poller.retries = 0
+	}
+	return poller, nil
+}
+
+// Poll read the item of every device and store the value like POST /channel
+func (p *Poller) Poll(ctx context.Context) (string, error) {
+	items, err := p.repository.GetAll(ctx, p.db)
+	if err != nil {
+		return "", err
+	}
+	devices := map[string][]entities.BacnetItem{}
+	for _, item := range items {
+		devices[item.Address] = append(devices[item.Address], item)
+	}
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	read := 0
+	limit := make(chan struct{}, maxParallelDevice)
+	for address, deviceItems := range devices {
+		wg.Add(1)
+		limit <- struct{}{}
+		go func(address string, deviceItems []entities.BacnetItem) {
+			defer func() {
+				<-limit
+				wg.Done()
+			}()
+			count := p.pollDevice(ctx, address, deviceItems)
+			mutex.Lock()
+			read += count
+			mutex.Unlock()
+		}(address, deviceItems)
+	}
+	wg.Wait()
+
+	return fmt.Sprintf("Read %d of %d BACnet item from %d device", read, len(items), len(devices)), nil
+}
+
+// pollDevice read the item of one device with its own socket, return the number of item read
+func (p *Poller) pollDevice(ctx context.Context, address string, items []entities.BacnetItem) int {
+	deviceAddress, err := ParseAddress(address)
+	if err == nil {
+		var udpAddress *net.UDPAddr
+		udpAddress, err = net.ResolveUDPAddr("udp", deviceAddress)
+		if err == nil {
+			var conn *net.UDPConn
+			conn, err = net.ListenUDP("udp", nil)
+			if err == nil {
+				defer conn.Close()
+				return p.readItems(ctx, conn, udpAddress, items)
+			}
+		}
+	}
+	for _, item := range items {
+		p.setStatus(ctx, item, err.Error())
+	}
+	return 0
+}
+
+func (p *Poller) readItems(ctx context.Context, conn *net.UDPConn, address *net.UDPAddr, items []entities.BacnetItem) int {
+	read := 0
+	var invokeId byte
+	for _, item := range items {
+		if ctx.Err() != nil {
+			return read
+		}
+		object, err := ParseObject(item.Object)
+		if err != nil {
+			p.setStatus(ctx, item, err.Error())
+			continue
+		}
+		property, err := ParseProperty(item.Property)
+		if err != nil {
+			p.setStatus(ctx, item, err.Error())
+			continue
+		}
+
+		var value float64
+		for attempt := 0; attempt <= p.retries; attempt++ {
+			invokeId++
+			value, err = p.readProperty(conn, address, invokeId, object, property)
+			var timeout net.Error
+			if err == nil || !(errors.As(err, &timeout) && timeout.Timeout()) {
+				break
+			}
+		}
+		if err != nil {
+			var timeout net.Error
+			if errors.As(err, &timeout) && timeout.Timeout() {
+				err = fmt.Errorf("the device didn't answer in %s", p.timeout)
+			}
+			p.setStatus(ctx, item, err.Error())
+			continue
+		}
+		p.setStatus(ctx, item, entities.BacnetOk)
+
+		payload := &entities.ChannelCreate{
+			IdSensor: item.IdSensor,
+			Name:     item.Name,
+			Value:    value,
+		}
+		_, _, err = p.pipeline.Store(ctx, item.IdUser, payload)
+		p.pipeline.Record(item.IdSensor, 0, err)
+		if err == nil {
+			read++
+		}
+	}
+	return read
+}
+
+// readProperty send one ReadProperty request and wait for its response, the frame of an earlier
+// request that timed out is skipped by its invoke id
+func (p *Poller) readProperty(conn *net.UDPConn, address *net.UDPAddr, invokeId byte, object ObjectId, property uint32) (float64, error) {
+	_, err := conn.WriteToUDP(readPropertyRequest(invokeId, object, property), address)
+	if err != nil {
+		return 0, err
+	}
+	err = conn.SetReadDeadline(time.Now().Add(p.timeout))
+	if err != nil {
+		return 0, err
+	}
+
+	buffer := make([]byte, maxFrameSize)
+	for {
+		n, sender, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			return 0, err
+		}
+		if !sender.IP.Equal(address.IP) || sender.Port != address.Port {
+			continue
+		}
+		responseId, value, err := parseResponse(buffer[:n])
+		if errors.Is(err, errNotResponse) || responseId != invokeId {
+			continue
+		}
+		return value, err
+	}
+}
+
+// setStatus save the status of the item when it changed, failing to save it doesn't stop the poll
+func (p *Poller) setStatus(ctx context.Context, item entities.BacnetItem, status string) {
+	if item.Status == status {
+		return
+	}
+	err := p.repository.SetStatus(ctx, p.db, item.IdItem, status)
+	if err != nil {
+		log.Printf("[BACNET] Error saving the status of item %d: %v", item.IdItem, err)
+	}
+}
@@ -0,0 +1,363 @@
+package bacnet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPort = "47808"
+	// BACnet virtual link control of BACnet/IP
+	bvlcType              = 0x81
+	bvlcForwarded         = 0x04
+	bvlcOriginalUnicast   = 0x0a
+	bvlcOriginalBroadcast = 0x0b
+	// NPDU control bit
+	npduExpectingReply   = 0x04
+	npduSourcePresent    = 0x08
+	npduDestination      = 0x20
+	npduNetworkMessage   = 0x80
+	serviceReadProperty  = 12
+	pduConfirmedRequest  = 0x0
+	pduComplexAck        = 0x3
+	pduError             = 0x5
+	pduReject            = 0x6
+	pduAbort             = 0x7
+	pduSegmentedResponse = 0x08
+	// Accept a response of up to 1476 byte, the largest unsegmented APDU over BACnet/IP
+	maxApdu1476 = 0x05
+)
+
+var objectTypes = map[string]uint32{
+	"analog-input":       0,
+	"analog-output":      1,
+	"analog-value":       2,
+	"binary-input":       3,
+	"binary-output":      4,
+	"binary-value":       5,
+	"multi-state-input":  13,
+	"multi-state-output": 14,
+	"multi-state-value":  19,
+	"accumulator":        23,
+	"pulse-converter":    24,
+}
+
+var properties = map[string]uint32{
+	"cov-increment":      22,
+	"high-limit":         45,
+	"low-limit":          59,
+	"present-value":      85,
+	"relinquish-default": 104,
+}
+
+// Error code with a name in the error, the other are only shown as number
+var errorCodes = map[uint32]string{
+	27: "read-access-denied",
+	31: "unknown-object",
+	32: "unknown-property",
+}
+
+// ObjectId is the type and instance of a BACnet object
+type ObjectId struct {
+	Type     uint32
+	Instance uint32
+}
+
+// ParseObject parse the object as type:instance, the type is its name like analog-input or its number
+func ParseObject(text string) (ObjectId, error) {
+	object := ObjectId{}
+	typeName, instance, ok := strings.Cut(text, ":")
+	if !ok {
+		return object, fmt.Errorf("object %q must be type:instance, e.g. analog-input:1", text)
+	}
+	objectType, ok := objectTypes[typeName]
+	if !ok {
+		number, err := strconv.ParseUint(typeName, 10, 32)
+		if err != nil || number > 1023 {
+			return object, fmt.Errorf("object %q has an unknown type", text)
+		}
+		objectType = uint32(number)
+	}
+	number, err := strconv.ParseUint(instance, 10, 32)
+	if err != nil || number > 4194302 {
+		return object, fmt.Errorf("object %q has an invalid instance", text)
+	}
+	object.Type = objectType
+	object.Instance = uint32(number)
+	return object, nil
+}
+
+// ParseProperty parse the property name like present-value or its number
+func ParseProperty(text string) (uint32, error) {
+	if property, ok := properties[text]; ok {
+		return property, nil
+	}
+	number, err := strconv.ParseUint(text, 10, 32)
+	if err != nil || number > 4194303 {
+		return 0, fmt.Errorf("property %q is unknown, use its number", text)
+	}
+	return uint32(number), nil
+}
+
+// ParseAddress add the default BACnet/IP port to the address without one
+func ParseAddress(text string) (string, error) {
+	host, port, err := net.SplitHostPort(text)
+	if err != nil {
+		host, port = text, defaultPort
+	}
+	if host == "" || strings.ContainsAny(host, "/ ") {
+		return "", fmt.Errorf("address %q must be a host with an optional port", text)
+	}
+	number, err := strconv.Atoi(port)
+	if err != nil || number <= 0 || number > 65535 {
+		return "", fmt.Errorf("address %q has an invalid port", text)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// readPropertyRequest encode the confirmed ReadProperty request in a BACnet/IP unicast
+func readPropertyRequest(invokeId byte, object ObjectId, property uint32) []byte {
+	apdu := []byte{pduConfirmedRequest << 4, maxApdu1476, invokeId, serviceReadProperty}
+	// Context tag 0, the object identifier
+	apdu = append(apdu, 0x0c)
+	apdu = binary.BigEndian.AppendUint32(apdu, object.Type<<22|object.Instance)
+	// Context tag 1, the property identifier in as few byte as needed
+	value := binary.BigEndian.AppendUint32(nil, property)
+	for len(value) > 1 && value[0] == 0 {
+		value = value[1:]
+	}
+	apdu = append(apdu, 0x18|byte(len(value)))
+	apdu = append(apdu, value...)
+
+	frame := []byte{bvlcType, bvlcOriginalUnicast, 0, 0, 0x01, npduExpectingReply}
+	frame = append(frame, apdu...)
+	binary.BigEndian.PutUint16(frame[2:], uint16(len(frame)))
+	return frame
+}
+
+var errNotResponse = errors.New("not a response")
+
+// parseResponse return the invoke id of the response and the value of the property, or the error of
+// the device. errNotResponse is returned for any other frame
+func parseResponse(frame []byte) (invokeId byte, value float64, err error) {
+	if len(frame) < 4 || frame[0] != bvlcType {
+		return 0, 0, errNotResponse
+	}
+	offset := 4
+	switch frame[1] {
+	case bvlcOriginalUnicast, bvlcOriginalBroadcast:
+	case bvlcForwarded:
+		// The address of the original sender
+		offset += 6
+	default:
+		return 0, 0, errNotResponse
+	}
+
+	if len(frame) < offset+2 || frame[offset] != 0x01 {
+		return 0, 0, errNotResponse
+	}
+	control := frame[offset+1]
+	offset += 2
+	if control&npduNetworkMessage != 0 {
+		return 0, 0, errNotResponse
+	}
+	if control&npduDestination != 0 {
+		if len(frame) < offset+3 {
+			return 0, 0, errNotResponse
+		}
+		offset += 3 + int(frame[offset+2])
+	}
+	if control&npduSourcePresent != 0 {
+		if len(frame) < offset+3 {
+			return 0, 0, errNotResponse
+		}
+		offset += 3 + int(frame[offset+2])
+	}
+	if control&npduDestination != 0 {
+		// Hop count
+		offset++
+	}
+	if len(frame) < offset+3 {
+		return 0, 0, errNotResponse
+	}
+
+	apdu := frame[offset:]
+	invokeId = apdu[1]
+	switch apdu[0] >> 4 {
+	case pduComplexAck:
+		if apdu[0]&pduSegmentedResponse != 0 {
+			return invokeId, 0, errors.New("the device sent a segmented response, which isn't supported")
+		}
+		if apdu[2] != serviceReadProperty {
+			return 0, 0, errNotResponse
+		}
+		value, err = propertyValue(apdu[3:])
+		return invokeId, value, err
+	case pduError:
+		return invokeId, 0, deviceError(apdu[3:])
+	case pduReject:
+		return invokeId, 0, fmt.Errorf("the device rejected the request with reason %d", apdu[2])
+	case pduAbort:
+		return invokeId, 0, fmt.Errorf("the device aborted the request with reason %d", apdu[2])
+	}
+	return 0, 0, errNotResponse
+}
+
+// tag is the header of an encoded BACnet value, length is the content length or the boolean value
+type tag struct {
+	number  byte
+	context bool
+	opening bool
+	closing bool
+	length  int
+}
+
+// readTag return the tag at the start of data and the size of its header
+func readTag(data []byte) (t tag, size int, err error) {
+	if len(data) < 1 {
+		return t, 0, errors.New("the response is truncated")
+	}
+	t.number = data[0] >> 4
+	t.context = data[0]&0x08 != 0
+	lengthValueType := data[0] & 0x07
+	size = 1
+	if t.number == 15 {
+		if len(data) < 2 {
+			return t, 0, errors.New("the response is truncated")
+		}
+		t.number = data[1]
+		size++
+	}
+	if t.context && lengthValueType == 6 {
+		t.opening = true
+		return t, size, nil
+	}
+	if t.context && lengthValueType == 7 {
+		t.closing = true
+		return t, size, nil
+	}
+
+	t.length = int(lengthValueType)
+	if lengthValueType == 5 {
+		if len(data) < size+1 {
+			return t, 0, errors.New("the response is truncated")
+		}
+		extended := data[size]
+		size++
+		switch extended {
+		case 254:
+			if len(data) < size+2 {
+				return t, 0, errors.New("the response is truncated")
+			}
+			t.length = int(binary.BigEndian.Uint16(data[size:]))
+			size += 2
+		case 255:
+			if len(data) < size+4 {
+				return t, 0, errors.New("the response is truncated")
+			}
+			t.length = int(binary.BigEndian.Uint32(data[size:]))
+			size += 4
+		default:
+			t.length = int(extended)
+		}
+	}
+	return t, size, nil
+}
+
+// propertyValue read the first value inside the opening tag 3 of a ReadProperty ack as a number
+func propertyValue(data []byte) (float64, error) {
+	for len(data) > 0 {
+		t, size, err := readTag(data)
+		if err != nil {
+			return 0, err
+		}
+		data = data[size:]
+		if t.opening && t.number == 3 {
+			break
+		}
+		if len(data) < t.length {
+			return 0, errors.New("the response is truncated")
+		}
+		data = data[t.length:]
+	}
+
+	t, size, err := readTag(data)
+	if err != nil {
+		return 0, err
+	}
+	if t.context || t.opening {
+		return 0, errors.New("the property value isn't a number")
+	}
+	content := data[size:]
+	// A boolean has its value in the tag
+	if t.number == 1 {
+		return float64(t.length), nil
+	}
+	if len(content) < t.length {
+		return 0, errors.New("the response is truncated")
+	}
+	content = content[:t.length]
+
+	switch t.number {
+	case 2, 9:
+		if t.length < 1 || t.length > 8 {
+			return 0, errors.New("the property value has an invalid length")
+		}
+		var value uint64
+		for _, b := range content {
+			value = value<<8 | uint64(b)
+		}
+		return float64(value), nil
+	case 3:
+		if t.length < 1 || t.length > 8 {
+			return 0, errors.New("the property value has an invalid length")
+		}
+		value := int64(int8(content[0]))
+		for _, b := range content[1:] {
+			value = value<<8 | int64(b)
+		}
+		return float64(value), nil
+	case 4:
+		if t.length != 4 {
+			return 0, errors.New("the property value has an invalid length")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(content))), nil
+	case 5:
+		if t.length != 8 {
+			return 0, errors.New("the property value has an invalid length")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(content)), nil
+	case 0:
+		return 0, errors.New("the property value is null")
+	}
+	return 0, fmt.Errorf("the property value has application tag %d, which isn't a number", t.number)
+}
+
+// deviceError read the error class and code of an error PDU
+func deviceError(data []byte) error {
+	codes := []uint32{}
+	for len(codes) < 2 && len(data) > 0 {
+		t, size, err := readTag(data)
+		if err != nil || t.context || t.number != 9 || len(data) < size+t.length || t.length > 4 {
+			break
+		}
+		var value uint32
+		for _, b := range data[size : size+t.length] {
+			value = value<<8 | uint32(b)
+		}
+		codes = append(codes, value)
+		data = data[size+t.length:]
+	}
+	if len(codes) < 2 {
+		return errors.New("the device returned an error")
+	}
+	if name, ok := errorCodes[codes[1]]; ok {
+		return fmt.Errorf("the device returned error %s (class %d, code %d)", name, codes[0], codes[1])
+	}
+	return fmt.Errorf("the device returned error class %d, code %d", codes[0], codes[1])
+}
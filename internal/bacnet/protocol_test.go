@@ -0,0 +1,221 @@
+package bacnet
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+func TestParseObject(t *testing.T) {
+	tests := []struct {
+		text   string
+		object ObjectId
+		fail   bool
+	}{
+		{"analog-input:1", ObjectId{0, 1}, false},
+		{"multi-state-value:4194302", ObjectId{19, 4194302}, false},
+		{"1023:0", ObjectId{1023, 0}, false},
+		{"analog-input", ObjectId{}, true},
+		{"analog-input:4194303", ObjectId{}, true},
+		{"analog-input:-1", ObjectId{}, true},
+		{"1024:1", ObjectId{}, true},
+		{"thermostat:1", ObjectId{}, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.text, func(t *testing.T) {
+			object, err := ParseObject(tc.text)
+			if (err != nil) != tc.fail || object != tc.object {
+				t.Fatalf("got %+v %v, want %+v", object, err, tc.object)
+			}
+		})
+	}
+}
+
+func TestReadPropertyRequest(t *testing.T) {
+	tests := []struct {
+		invokeId byte
+		object   ObjectId
+		property uint32
+		frame    string
+	}{
+		// Unicast of the NPDU expecting a reply, then the confirmed ReadProperty
+		{1, ObjectId{0, 1}, 85, "810a0011" + "0104" + "0005010c" + "0c00000001" + "1955"},
+		{255, ObjectId{19, 4194302}, 0, "810a0011" + "0104" + "0005ff0c" + "0c04fffffe" + "1900"},
+		{7, ObjectId{2, 3}, 4194303, "810a0013" + "0104" + "0005070c" + "0c00800003" + "1b3fffff"},
+	}
+	for _, tc := range tests {
+		frame := readPropertyRequest(tc.invokeId, tc.object, tc.property)
+		if hex.EncodeToString(frame) != tc.frame {
+			t.Errorf("encoded %x, want %s", frame, tc.frame)
+			continue
+		}
+
+		// The object and property are read back with their tag
+		data := frame[10:]
+		objectTag, size, err := readTag(data)
+		if err != nil || !objectTag.context || objectTag.number != 0 || objectTag.length != 4 {
+			t.Fatalf("object tag is %+v %v", objectTag, err)
+		}
+		objectId := binary.BigEndian.Uint32(data[size:])
+		data = data[size+objectTag.length:]
+		propertyTag, size, err := readTag(data)
+		if err != nil || !propertyTag.context || propertyTag.number != 1 || size+propertyTag.length != len(data) {
+			t.Fatalf("property tag is %+v %v", propertyTag, err)
+		}
+		var property uint32
+		for _, b := range data[size:] {
+			property = property<<8 | uint32(b)
+		}
+		if (ObjectId{objectId >> 22, objectId & 0x3fffff}) != tc.object || property != tc.property {
+			t.Errorf("read back %x and %d, want %+v and %d", objectId, property, tc.object, tc.property)
+		}
+	}
+}
+
+func TestReadTag(t *testing.T) {
+	tests := []struct {
+		data string
+		tag  tag
+		size int
+	}{
+		{"00", tag{number: 0}, 1},
+		{"11", tag{number: 1, length: 1}, 1},
+		{"44", tag{number: 4, length: 4}, 1},
+		{"0c", tag{number: 0, context: true, length: 4}, 1},
+		{"3e", tag{number: 3, context: true, opening: true}, 1},
+		{"3f", tag{number: 3, context: true, closing: true}, 1},
+		{"7510", tag{number: 7, length: 16}, 2},
+		{"75fe0100", tag{number: 7, length: 256}, 4},
+		{"75ff00010000", tag{number: 7, length: 65536}, 6},
+		{"f921", tag{number: 33, context: true, length: 1}, 2},
+		{"fe21", tag{number: 33, context: true, opening: true}, 2},
+		{"fd21fe0100", tag{number: 33, context: true, length: 256}, 5},
+	}
+	for _, tc := range tests {
+		data, _ := hex.DecodeString(tc.data)
+		got, size, err := readTag(data)
+		if err != nil || got != tc.tag || size != tc.size {
+			t.Errorf("readTag(%s) is %+v %d %v, want %+v %d", tc.data, got, size, err, tc.tag, tc.size)
+		}
+	}
+
+	for _, truncated := range []string{"", "f0", "75", "75fe01", "75ff000100"} {
+		data, _ := hex.DecodeString(truncated)
+		_, _, err := readTag(data)
+		if err == nil {
+			t.Errorf("readTag(%s) should fail", truncated)
+		}
+	}
+}
+
+// unicast return the BACnet/IP frame of the NPDU header and APDU
+func unicast(npdu string, apdu string) []byte {
+	frame, err := hex.DecodeString("810a0000" + npdu + apdu)
+	if err != nil {
+		panic(err)
+	}
+	binary.BigEndian.PutUint16(frame[2:], uint16(len(frame)))
+	return frame
+}
+
+// ack is the ReadProperty ack of analog-input:1 present-value with the encoded value
+func ack(invokeId string, value string) string {
+	return "30" + invokeId + "0c" + "0c00000001" + "1955" + "3e" + value + "3f"
+}
+
+func TestParseResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		frame    []byte
+		invokeId byte
+		value    float64
+		err      string
+	}{
+		{"real", unicast("0100", ack("01", "4441ac0000")), 1, 21.5, ""},
+		{"double", unicast("0100", ack("02", "5508c00c000000000000")), 2, -3.5, ""},
+		{"unsigned", unicast("0100", ack("03", "22012c")), 3, 300, ""},
+		{"signed", unicast("0100", ack("04", "31fe")), 4, -2, ""},
+		{"signed 8 byte", unicast("0100", ack("05", "35088000000000000000")), 5, -9223372036854775808, ""},
+		{"enumerated", unicast("0100", ack("06", "9101")), 6, 1, ""},
+		{"boolean", unicast("0100", ack("07", "11")), 7, 1, ""},
+		{"array index before the value", unicast("0100", "30080c"+"0c00000001"+"1955"+"2901"+"3e"+"4441ac0000"+"3f"), 8, 21.5, ""},
+		{"from a router", unicast("0108"+"0005"+"01"+"07", ack("09", "22012c")), 9, 300, ""},
+		{"through a router", unicast("0120"+"0005"+"02"+"0a0b"+"ff", ack("0a", "22012c")), 10, 300, ""},
+		{"forwarded ack", append(append([]byte{0x81, 0x04, 0x00, 0x1d}, 192, 168, 1, 5, 0xba, 0xc0), unicast("0100", ack("0b", "4441ac0000"))[4:]...), 11, 21.5, ""},
+		{"null", unicast("0100", ack("0c", "00")), 12, 0, "the property value is null"},
+		{"string", unicast("0100", ack("0d", "7503007570")), 13, 0, "the property value has application tag 7, which isn't a number"},
+		{"context value", unicast("0100", ack("0e", "0901")), 14, 0, "the property value isn't a number"},
+		{"real of 2 byte", unicast("0100", ack("0f", "4241ac")), 15, 0, "the property value has an invalid length"},
+		{"truncated value", unicast("0100", ack("10", "4441ac")), 16, 0, "the response is truncated"},
+		{"no value", unicast("0100", "30110c0c00000001"+"1955"), 17, 0, "the response is truncated"},
+		{"error", unicast("0100", "5012"+"0c"+"9102"+"9120"), 18, 0, "the device returned error unknown-property (class 2, code 32)"},
+		{"unnamed error", unicast("0100", "5013"+"0c"+"9101"+"9105"), 19, 0, "the device returned error class 1, code 5"},
+		{"error without code", unicast("0100", "5014"+"0c"), 20, 0, "the device returned an error"},
+		{"reject", unicast("0100", "6015"+"09"), 21, 0, "the device rejected the request with reason 9"},
+		{"abort", unicast("0100", "7016"+"04"), 22, 0, "the device aborted the request with reason 4"},
+		{"segmented", unicast("0100", "3817000c01"), 23, 0, "the device sent a segmented response, which isn't supported"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			invokeId, value, err := parseResponse(tc.frame)
+			if tc.err != "" {
+				if err == nil || err.Error() != tc.err || invokeId != tc.invokeId {
+					t.Fatalf("got invoke %d and %v, want %d and %s", invokeId, err, tc.invokeId, tc.err)
+				}
+				return
+			}
+			if err != nil || invokeId != tc.invokeId || value != tc.value {
+				t.Fatalf("got invoke %d, %v and %v, want %d and %v", invokeId, value, err, tc.invokeId, tc.value)
+			}
+		})
+	}
+}
+
+func TestParseResponseNotResponse(t *testing.T) {
+	tests := map[string][]byte{
+		"empty":               {},
+		"short":               {0x81, 0x0a, 0x00},
+		"other bvlc type":     {0x82, 0x0a, 0x00, 0x08, 0x01, 0x00, 0x30, 0x01},
+		"bvlc result":         {0x81, 0x00, 0x00, 0x06, 0x00, 0x30},
+		"truncated forwarded": {0x81, 0x04, 0x00, 0x08, 192, 168, 1, 5},
+		"npdu version 2":      unicast("0200", ack("01", "4441ac0000")),
+		"network message":     unicast("0180", "0100"),
+		"truncated source":    unicast("0108"+"00", ""),
+		"truncated router":    unicast("0120"+"0005"+"06"+"0a0b", ""),
+		"truncated apdu":      unicast("0100", "3001"),
+		"request":             unicast("0104", "0005010c0c000000011955"),
+		"other service ack":   unicast("0100", "30010e"),
+		"simple ack":          unicast("0100", "200f0c"),
+	}
+	for name, frame := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := parseResponse(frame)
+			if err != errNotResponse {
+				t.Fatalf("parsing %x got %v, want %v", frame, err, errNotResponse)
+			}
+		})
+	}
+}
+
+// FuzzParseResponse check a frame from the network never panic, and that the request is never taken
+// for a response
+func FuzzParseResponse(f *testing.F) {
+	f.Add(unicast("0100", ack("01", "4441ac0000")))
+	f.Add(unicast("0128"+"0005"+"02"+"0a0b"+"0006"+"01"+"07"+"ff", ack("02", "75fe0002"+"0000")))
+	f.Add(unicast("0100", "5012"+"0c"+"9102"+"9120"))
+	f.Add(readPropertyRequest(1, ObjectId{0, 1}, 85))
+	f.Fuzz(func(t *testing.T, frame []byte) {
+		parseResponse(frame)
+		tagged := append([]byte{}, frame...)
+		for len(tagged) > 0 {
+			tag, size, err := readTag(tagged)
+			if err != nil {
+				break
+			}
+			if size < 1 || size > len(tagged) || tag.length < 0 {
+				t.Fatalf("read tag %+v of size %d from %x", tag, size, tagged)
+			}
+			tagged = tagged[size:]
+		}
+	})
+}
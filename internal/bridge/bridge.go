@@ -0,0 +1,252 @@
+// Package bridge republish the stored channel and the alert they raise to external systems, so a
+// downstream consumer get the clean data without polling the API.
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// Channel waiting to be republished, a newer channel is dropped when it is full
+	queueSize      = 1024
+	publishTimeout = 10 * time.Second
+	dialTimeout    = 10 * time.Second
+	// A broker connection is not retried before this long after it failed
+	reconnectDelay = 10 * time.Second
+	tickInterval   = time.Second
+	// Error and dropped channel are logged at most once per this interval
+	reportInterval = time.Minute
+	// A widget change is seen by the bridge after at most this long
+	widgetCacheDuration = time.Minute
+)
+
+var errUnavailable = errors.New("broker is unavailable, waiting before reconnecting")
+
+// Type of the republished event
+const (
+	EventReading = "reading"
+	EventAlert   = "alert"
+)
+
+// Event is a stored channel, or an alert when the channel is outside the normal range of an alert
+// widget of its sensor
+type Event struct {
+	Type    string
+	Channel entities.Channel
+	// The alert widget of an alert, nil for a reading
+	Widget *entities.Widget
+}
+
+type alertPayload struct {
+	entities.Channel
+	IdWidget int      `json:"id_widget"`
+	Title    string   `json:"title"`
+	Min      *float64 `json:"min,omitempty"`
+	Max      *float64 `json:"max,omitempty"`
+}
+
+// Payload return the JSON body of the event, a reading is the channel and an alert is the channel
+// with the widget and its range
+func (e Event) Payload() ([]byte, error) {
+	if e.Widget == nil {
+		return json.Marshal(e.Channel)
+	}
+	return json.Marshal(alertPayload{
+		Channel:  e.Channel,
+		IdWidget: e.Widget.IdWidget,
+		Title:    e.Widget.Title,
+		Min:      e.Widget.Options.Min,
+		Max:      e.Widget.Options.Max,
+	})
+}
+
+// Topic replace {id_sensor} in the topic or routing key template
+func (e Event) Topic(template string) string {
+	return strings.ReplaceAll(template, "{id_sensor}", strconv.Itoa(e.Channel.IdSensor))
+}
+
+// Publisher send the event to one external system. The bridge call it from a single goroutine,
+// so it doesn't need to be safe for concurrent use
+type Publisher interface {
+	Name() string
+	Publish(ctx context.Context, event Event) error
+	// Tick is called every second, e.g. to keep an idle connection alive
+	Tick(ctx context.Context, now time.Time) error
+	Close() error
+}
+
+// deadline of the broker read and write, the publish timeout when the context has none
+func deadline(ctx context.Context) time.Time {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(publishTimeout)
+	}
+	return deadline
+}
+
+type cachedWidgets struct {
+	widgets   []entities.Widget
+	expiresAt time.Time
+}
+
+// Bridge republish the channel in the background, the ingest never wait for a publisher
+type Bridge struct {
+	db                  *pgxpool.Pool
+	dashboardRepository *repositories.DashboardRepository
+	publishers          []Publisher
+	queue               chan entities.Channel
+	dropped             atomic.Int64
+	widgets             map[int]cachedWidgets
+	failures            map[string]int
+	lastErrors          map[string]error
+}
+
+// NewBridge republish to the publishers that aren't nil, the bridge does nothing without one
+func NewBridge(db *pgxpool.Pool, dashboardRepository *repositories.DashboardRepository, publishers ...Publisher) (*Bridge, error) {
+	bridge := &Bridge{
+		db:                  db,
+		dashboardRepository: dashboardRepository,
+		queue:               make(chan entities.Channel, queueSize),
+		widgets:             map[int]cachedWidgets{},
+		failures:            map[string]int{},
+		lastErrors:          map[string]error{},
+	}
+	for _, publisher := range publishers {
+		if publisher != nil {
+			bridge.publishers = append(bridge.publishers, publisher)
+		}
+	}
+	return bridge, nil
+}
+
+func (b *Bridge) Enabled() bool {
+	return len(b.publishers) > 0
+}
+
+// Enqueue queue the stored channel to be republished, it is dropped when the queue is full so a
+// slow external system never slow the ingest
+func (b *Bridge) Enqueue(channel entities.Channel) {
+	if !b.Enabled() {
+		return
+	}
+	select {
+	case b.queue <- channel:
+	default:
+		b.dropped.Add(1)
+	}
+}
+
+// Start republish the queued channel until the context is done, then close the publishers
+func (b *Bridge) Start(ctx context.Context) {
+	if !b.Enabled() {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(tickInterval)
+		defer ticker.Stop()
+		lastReport := time.Now()
+
+		for {
+			select {
+			case channel := <-b.queue:
+				b.republish(ctx, channel)
+			case now := <-ticker.C:
+				for _, publisher := range b.publishers {
+					err := publisher.Tick(ctx, now)
+					if err != nil {
+						b.fail(publisher, err)
+					}
+				}
+				if now.Sub(lastReport) >= reportInterval {
+					b.report()
+					lastReport = now
+				}
+			case <-ctx.Done():
+				for _, publisher := range b.publishers {
+					err := publisher.Close()
+					if err != nil {
+						log.Printf("[BRIDGE] Error closing %s: %v", publisher.Name(), err)
+					}
+				}
+				return
+			}
+		}
+	}()
+}
+
+func (b *Bridge) republish(ctx context.Context, channel entities.Channel) {
+	events := []Event{{Type: EventReading, Channel: channel}}
+	widgets, err := b.alertWidgets(ctx, channel.IdSensor)
+	if err != nil {
+		log.Printf("[BRIDGE] Error reading the alert widget of sensor %d: %v", channel.IdSensor, err)
+	}
+	for i := range widgets {
+		if widgets[i].Options.IsOutOfRange(channel.Value) {
+			events = append(events, Event{Type: EventAlert, Channel: channel, Widget: &widgets[i]})
+		}
+	}
+
+	for _, publisher := range b.publishers {
+		for _, event := range events {
+			publishCtx, cancel := context.WithTimeout(ctx, publishTimeout)
+			err := publisher.Publish(publishCtx, event)
+			cancel()
+			if err != nil {
+				b.fail(publisher, err)
+			}
+		}
+	}
+}
+
+// alertWidgets return the alert widget of the sensor, cached for a while since every channel need it
+func (b *Bridge) alertWidgets(ctx context.Context, idSensor int) ([]entities.Widget, error) {
+	now := time.Now()
+	cached, found := b.widgets[idSensor]
+	if found && now.Before(cached.expiresAt) {
+		return cached.widgets, nil
+	}
+
+	widgets, err := b.dashboardRepository.GetAlertWidgetsBySensors(ctx, b.db, []int{idSensor})
+	if err != nil {
+		return nil, err
+	}
+	b.widgets[idSensor] = cachedWidgets{widgets: widgets[idSensor], expiresAt: now.Add(widgetCacheDuration)}
+	return widgets[idSensor], nil
+}
+
+// fail log the first error of the publisher right away, the next one are summed up by report
+func (b *Bridge) fail(publisher Publisher, err error) {
+	name := publisher.Name()
+	if b.failures[name] == 0 {
+		log.Printf("[BRIDGE] Error publishing to %s: %v", name, err)
+	}
+	b.failures[name]++
+	b.lastErrors[name] = err
+}
+
+func (b *Bridge) report() {
+	for name, count := range b.failures {
+		if count > 1 {
+			log.Printf("[BRIDGE] %d error publishing to %s, last one: %v", count, name, b.lastErrors[name])
+		}
+	}
+	b.failures = map[string]int{}
+	b.lastErrors = map[string]error{}
+
+	dropped := b.dropped.Swap(0)
+	if dropped > 0 {
+		log.Printf("[BRIDGE] Queue is full, dropped %d channel", dropped)
+	}
+}
@@ -0,0 +1,241 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+)
+
+const (
+	defaultInfluxMeasurement = "channel"
+	defaultInfluxBatchSize   = 500
+	defaultInfluxFlush       = 5 * time.Second
+	defaultInfluxMaxBuffer   = 100000
+	// The failed write is retried with a doubling wait between these
+	influxFirstRetry = 5 * time.Second
+	influxMaxRetry   = 5 * time.Minute
+)
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxTagEscaper         = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+)
+
+// influxPublisher mirror the reading to an InfluxDB (or VictoriaMetrics) write endpoint in the line
+// protocol. The line is buffered and written in batch, a failed batch is kept and retried
+type influxPublisher struct {
+	url           string
+	token         string
+	measurement   string
+	batchSize     int
+	flushInterval time.Duration
+	maxBuffer     int
+	client        *http.Client
+
+	lines     []string
+	dropped   int
+	lastFlush time.Time
+	failures  int
+	retryAt   time.Time
+}
+
+// NewInfluxPublisher return nil when influx.url is empty
+func NewInfluxPublisher(config *configs.Config) (Publisher, error) {
+	if config.Influx.Url == "" {
+		return nil, nil
+	}
+	writeUrl, err := url.Parse(config.Influx.Url)
+	if err != nil || (writeUrl.Scheme != "http" && writeUrl.Scheme != "https") || writeUrl.Host == "" {
+		return nil, fmt.Errorf("invalid influx url %q", config.Influx.Url)
+	}
+
+	publisher := &influxPublisher{
+		url:           config.Influx.Url,
+		token:         config.Influx.Token,
+		measurement:   config.Influx.Measurement,
+		batchSize:     config.Influx.BatchSize,
+		flushInterval: time.Duration(config.Influx.FlushSeconds) * time.Second,
+		maxBuffer:     config.Influx.MaxBuffer,
+		client:        &http.Client{Timeout: publishTimeout},
+		lastFlush:     time.Now(),
+	}
+	if publisher.measurement == "" {
+		publisher.measurement = defaultInfluxMeasurement
+	}
+	if publisher.batchSize <= 0 {
+		publisher.batchSize = defaultInfluxBatchSize
+	}
+	if publisher.flushInterval <= 0 {
+		publisher.flushInterval = defaultInfluxFlush
+	}
+	if publisher.maxBuffer < publisher.batchSize {
+		publisher.maxBuffer = defaultInfluxMaxBuffer
+	}
+	return publisher, nil
+}
+
+func (p *influxPublisher) Name() string {
+	return "influx " + p.url
+}
+
+// line format the channel, the sensor id, channel name and quality are tag
+func (p *influxPublisher) line(event Event) string {
+	channel := event.Channel
+	line := &strings.Builder{}
+	line.WriteString(influxMeasurementEscaper.Replace(p.measurement))
+	line.WriteString(",id_sensor=")
+	line.WriteString(strconv.Itoa(channel.IdSensor))
+	if channel.Name != "" {
+		line.WriteString(",name=")
+		line.WriteString(influxTagEscaper.Replace(channel.Name))
+	}
+	if channel.Quality != "" {
+		line.WriteString(",quality=")
+		line.WriteString(influxTagEscaper.Replace(channel.Quality))
+	}
+	line.WriteString(" value=")
+	line.WriteString(strconv.FormatFloat(channel.Value, 'g', -1, 64))
+	if channel.FilteredValue != nil {
+		line.WriteString(",filtered_value=")
+		line.WriteString(strconv.FormatFloat(*channel.FilteredValue, 'g', -1, 64))
+	}
+	line.WriteString(" ")
+	line.WriteString(strconv.FormatInt(channel.Time.UnixNano(), 10))
+	return line.String()
+}
+
+// Publish buffer the reading and write the buffer once it has a full batch, the alert isn't mirrored
+func (p *influxPublisher) Publish(ctx context.Context, event Event) error {
+	if event.Type != EventReading {
+		return nil
+	}
+
+	p.lines = append(p.lines, p.line(event))
+	if len(p.lines) > p.maxBuffer {
+		overflow := len(p.lines) - p.maxBuffer
+		p.lines = p.lines[overflow:]
+		p.dropped += overflow
+	}
+	if len(p.lines) < p.batchSize {
+		return nil
+	}
+	return p.flush(ctx, time.Now())
+}
+
+// Tick write the buffer every flush interval, or when the wait after a failed write is over
+func (p *influxPublisher) Tick(ctx context.Context, now time.Time) error {
+	if len(p.lines) == 0 || now.Sub(p.lastFlush) < p.flushInterval {
+		return nil
+	}
+	return p.flush(ctx, now)
+}
+
+// Close try to write what is left in the buffer
+func (p *influxPublisher) Close() error {
+	if len(p.lines) == 0 {
+		return nil
+	}
+	p.retryAt = time.Time{}
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	return p.flush(ctx, time.Now())
+}
+
+// flush write the buffer batch by batch, a batch is kept in the buffer until it is written
+func (p *influxPublisher) flush(ctx context.Context, now time.Time) error {
+	if now.Before(p.retryAt) {
+		return nil
+	}
+	p.lastFlush = now
+
+	for len(p.lines) > 0 {
+		size := p.batchSize
+		if size > len(p.lines) {
+			size = len(p.lines)
+		}
+		err := p.write(ctx, p.lines[:size])
+		var permanent *influxPermanentError
+		if errors.As(err, &permanent) {
+			// Retrying a rejected batch won't help, it is dropped
+			p.lines = p.lines[size:]
+			return err
+		}
+		if err != nil {
+			p.failures++
+			p.retryAt = now.Add(influxRetryDelay(p.failures))
+			return err
+		}
+		p.lines = p.lines[size:]
+		p.failures = 0
+		p.retryAt = time.Time{}
+	}
+	// Don't keep the underlying array of a big backlog
+	p.lines = nil
+
+	if p.dropped > 0 {
+		dropped := p.dropped
+		p.dropped = 0
+		return fmt.Errorf("buffer was full, dropped the %d oldest reading", dropped)
+	}
+	return nil
+}
+
+// influxPermanentError is a batch refused by the endpoint, e.g. a malformed line or a wrong bucket
+type influxPermanentError struct {
+	status string
+	body   string
+}
+
+func (e *influxPermanentError) Error() string {
+	return fmt.Sprintf("influx rejected the batch with %s: %s", e.status, e.body)
+}
+
+func (p *influxPublisher) write(ctx context.Context, lines []string) error {
+	body := []byte(strings.Join(lines, "\n"))
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	request.Header.Set("User-Agent", "iot-server-bridge")
+	if p.token != "" {
+		request.Header.Set("Authorization", "Token "+p.token)
+	}
+
+	response, err := p.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+
+	if response.StatusCode >= 200 && response.StatusCode <= 299 {
+		return nil
+	}
+	// Too many request and server error are temporary
+	if response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500 {
+		return fmt.Errorf("influx responded %s: %s", response.Status, strings.TrimSpace(string(message)))
+	}
+	return &influxPermanentError{status: response.Status, body: strings.TrimSpace(string(message))}
+}
+
+// influxRetryDelay double the wait after each failed write, up to the max retry
+func influxRetryDelay(failures int) time.Duration {
+	delay := influxFirstRetry
+	for i := 1; i < failures && delay < influxMaxRetry; i++ {
+		delay *= 2
+	}
+	if delay > influxMaxRetry {
+		delay = influxMaxRetry
+	}
+	return delay
+}
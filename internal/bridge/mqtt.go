@@ -0,0 +1,268 @@
+package bridge
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/mqtt/packet"
+)
+
+// mqttPublisher publish the event to an MQTT broker with QoS 0 or 1. It is a minimal MQTT 3.1.1
+// client, it only connect with a clean session and publish
+type mqttPublisher struct {
+	address      string
+	tlsConfig    *tls.Config
+	clientId     string
+	username     string
+	password     string
+	readingTopic string
+	alertTopic   string
+	qos          byte
+	retain       bool
+
+	conn     net.Conn
+	reader   *bufio.Reader
+	packetId uint16
+	lastSent time.Time
+	retryAt  time.Time
+}
+
+// NewMqttPublisher return nil when mqtt.url is empty. The client id default to iot-server- followed
+// by the instance id, so every replica has its own session
+func NewMqttPublisher(config *configs.Config, instanceId string) (Publisher, error) {
+	if config.Mqtt.Url == "" {
+		return nil, nil
+	}
+
+	brokerUrl, err := url.Parse(config.Mqtt.Url)
+	if err != nil || brokerUrl.Hostname() == "" {
+		return nil, fmt.Errorf("invalid mqtt url %q", config.Mqtt.Url)
+	}
+	if config.Mqtt.Qos != 0 && config.Mqtt.Qos != 1 {
+		return nil, fmt.Errorf("mqtt qos must be 0 or 1, got %d", config.Mqtt.Qos)
+	}
+	if config.Mqtt.ReadingTopic == "" && config.Mqtt.AlertTopic == "" {
+		return nil, errors.New("mqtt.url need mqtt.readingTopic or mqtt.alertTopic")
+	}
+
+	publisher := &mqttPublisher{
+		clientId:     config.Mqtt.ClientId,
+		username:     config.Mqtt.Username,
+		password:     config.Mqtt.Password,
+		readingTopic: config.Mqtt.ReadingTopic,
+		alertTopic:   config.Mqtt.AlertTopic,
+		qos:          byte(config.Mqtt.Qos),
+		retain:       config.Mqtt.Retain,
+	}
+	if publisher.clientId == "" {
+		publisher.clientId = "iot-server-" + instanceId
+	}
+
+	port := brokerUrl.Port()
+	switch brokerUrl.Scheme {
+	case "tcp", "mqtt":
+		if port == "" {
+			port = "1883"
+		}
+	case "ssl", "tls", "mqtts":
+		if port == "" {
+			port = "8883"
+		}
+		publisher.tlsConfig = &tls.Config{ServerName: brokerUrl.Hostname()}
+	default:
+		return nil, fmt.Errorf("unsupported mqtt url scheme %q, use tcp or ssl", brokerUrl.Scheme)
+	}
+	publisher.address = net.JoinHostPort(brokerUrl.Hostname(), port)
+
+	return publisher, nil
+}
+
+func (p *mqttPublisher) Name() string {
+	return "mqtt " + p.address
+}
+
+func (p *mqttPublisher) Publish(ctx context.Context, event Event) error {
+	template := p.readingTopic
+	if event.Type == EventAlert {
+		template = p.alertTopic
+	}
+	if template == "" {
+		return nil
+	}
+
+	payload, err := event.Payload()
+	if err != nil {
+		return err
+	}
+	err = p.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	topic := event.Topic(template)
+	header := byte(packet.Publish) | p.qos<<1
+	if p.retain {
+		header |= 1
+	}
+	body := &bytes.Buffer{}
+	packet.WriteString(body, topic)
+	if p.qos > 0 {
+		p.packetId++
+		if p.packetId == 0 {
+			p.packetId = 1
+		}
+		binary.Write(body, binary.BigEndian, p.packetId)
+	}
+	body.Write(payload)
+
+	err = p.send(ctx, header, body.Bytes())
+	if err != nil {
+		return err
+	}
+	if p.qos == 0 {
+		return nil
+	}
+
+	packetType, ack, err := p.receive(ctx)
+	if err != nil {
+		return err
+	}
+	if packetType != packet.Puback || len(ack) != 2 || binary.BigEndian.Uint16(ack) != p.packetId {
+		p.close()
+		return fmt.Errorf("expected PUBACK of packet %d from the broker", p.packetId)
+	}
+	return nil
+}
+
+// Tick ping the broker when nothing was sent for half the keep alive, so the broker doesn't close
+// the connection and a dead connection is noticed before the next event
+func (p *mqttPublisher) Tick(ctx context.Context, now time.Time) error {
+	if p.conn == nil || now.Sub(p.lastSent) < packet.KeepAlive/2 {
+		return nil
+	}
+
+	err := p.send(ctx, packet.Pingreq, nil)
+	if err != nil {
+		return err
+	}
+	packetType, _, err := p.receive(ctx)
+	if err != nil {
+		return err
+	}
+	if packetType != packet.Pingresp {
+		p.close()
+		return errors.New("expected PINGRESP from the broker")
+	}
+	return nil
+}
+
+func (p *mqttPublisher) Close() error {
+	if p.conn == nil {
+		return nil
+	}
+	p.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	_, err := p.conn.Write([]byte{packet.Disconnect, 0})
+	p.close()
+	return err
+}
+
+func (p *mqttPublisher) close() {
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	p.conn = nil
+	p.reader = nil
+}
+
+// connect open the connection when there is none, at most once per reconnect delay
+func (p *mqttPublisher) connect(ctx context.Context) (err error) {
+	if p.conn != nil {
+		return nil
+	}
+	if time.Now().Before(p.retryAt) {
+		return errUnavailable
+	}
+	defer func() {
+		if err != nil {
+			p.close()
+			p.retryAt = time.Now().Add(reconnectDelay)
+		}
+	}()
+
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	if p.tlsConfig != nil {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: p.tlsConfig}
+		p.conn, err = tlsDialer.DialContext(ctx, "tcp", p.address)
+	} else {
+		p.conn, err = dialer.DialContext(ctx, "tcp", p.address)
+	}
+	if err != nil {
+		return err
+	}
+	p.reader = bufio.NewReader(p.conn)
+
+	// Clean session, the bridge only publish so there is nothing to resume
+	err = p.send(ctx, packet.Connect, packet.ConnectBody(p.clientId, p.username, p.password))
+	if err != nil {
+		return err
+	}
+
+	packetType, ack, err := p.receive(ctx)
+	if err != nil {
+		return err
+	}
+	if packetType != packet.Connack || len(ack) != 2 {
+		return errors.New("expected CONNACK from the broker")
+	}
+	if ack[1] != 0 {
+		return fmt.Errorf("broker refused the connection with return code %d", ack[1])
+	}
+	return nil
+}
+
+// send write one packet, the connection is closed when it fails
+func (p *mqttPublisher) send(ctx context.Context, header byte, body []byte) error {
+	p.conn.SetWriteDeadline(deadline(ctx))
+	_, err := p.conn.Write(packet.Encode(header, body))
+	if err != nil {
+		p.close()
+		return err
+	}
+	p.lastSent = time.Now()
+	return nil
+}
+
+// receive read one packet and return its type without the flags, the connection is closed when it fails
+func (p *mqttPublisher) receive(ctx context.Context) (packetType byte, body []byte, err error) {
+	defer func() {
+		if err != nil {
+			p.close()
+		}
+	}()
+
+	p.conn.SetReadDeadline(deadline(ctx))
+	header, err := p.reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, err := packet.ReadLength(p.reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	body = make([]byte, length)
+	_, err = io.ReadFull(p.reader, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return header & 0xf0, body, nil
+}
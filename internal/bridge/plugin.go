@@ -0,0 +1,78 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/plugin"
+)
+
+// pluginPublisher republish to a sink plugin
+type pluginPublisher struct {
+	name string
+	sink plugin.Sink
+}
+
+// NewPluginPublishers create the registered sink plugin that has a setting in plugin.sinks, a sink
+// that isn't registered is an error so a typo doesn't silently disable it
+func NewPluginPublishers(config *configs.Config) ([]Publisher, error) {
+	names := make([]string, 0, len(config.Plugin.Sinks))
+	for name := range config.Plugin.Sinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	publishers := []Publisher{}
+	for _, name := range names {
+		factory, ok := plugin.LookupSink(name)
+		if !ok {
+			return nil, fmt.Errorf("sink plugin %q in plugin.sinks is not registered, registered: %s", name, strings.Join(plugin.Sinks(), ", "))
+		}
+		settings := config.Plugin.Sinks[name]
+		if settings == nil {
+			settings = map[string]string{}
+		}
+		sink, err := factory(settings)
+		if err != nil {
+			return nil, fmt.Errorf("error creating sink plugin %s: %w", name, err)
+		}
+		publishers = append(publishers, &pluginPublisher{name: name, sink: sink})
+	}
+	return publishers, nil
+}
+
+func (p *pluginPublisher) Name() string {
+	return "plugin " + p.name
+}
+
+func (p *pluginPublisher) Publish(ctx context.Context, event Event) error {
+	reading := plugin.Reading{
+		IdSensor: event.Channel.IdSensor,
+		Name:     event.Channel.Name,
+		Value:    event.Channel.Value,
+		Quality:  event.Channel.Quality,
+		Time:     event.Channel.Time,
+	}
+	if event.Widget == nil {
+		return p.sink.WriteReading(ctx, reading)
+	}
+	return p.sink.WriteAlert(ctx, plugin.Alert{
+		Reading:  reading,
+		IdWidget: event.Widget.IdWidget,
+		Title:    event.Widget.Title,
+		Min:      event.Widget.Options.Min,
+		Max:      event.Widget.Options.Max,
+	})
+}
+
+func (p *pluginPublisher) Tick(ctx context.Context, now time.Time) error {
+	return nil
+}
+
+func (p *pluginPublisher) Close() error {
+	return p.sink.Close()
+}
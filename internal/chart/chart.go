@@ -0,0 +1,223 @@
+// Package chart render a sensor time series to SVG or PNG on the server, for the client
+// that can't run the javascript chart like email, report, and chat notification.
+// Only the standard library is used, the PNG text is drawn with the bitmap font in font.go.
+package chart
+
+import (
+	"math"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultWidth  = 800
+	DefaultHeight = 400
+)
+
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// Chart is a single series line chart. From and To is the shown range,
+// when zero the range of the points is used. The time label is in UTC
+type Chart struct {
+	Title  string
+	Width  int
+	Height int
+	Dark   bool
+	From   time.Time
+	To     time.Time
+	Points []Point
+}
+
+type palette struct {
+	background string
+	text       string
+	grid       string
+	line       string
+}
+
+var lightPalette = palette{background: "#ffffff", text: "#373d3f", grid: "#e0e0e0", line: "#008ffb"}
+var darkPalette = palette{background: "#212529", text: "#dee2e6", grid: "#495057", line: "#3ea6ff"}
+
+func (c *Chart) palette() palette {
+	if c.Dark {
+		return darkPalette
+	}
+	return lightPalette
+}
+
+func (c *Chart) size() (int, int) {
+	width, height := c.Width, c.Height
+	if width <= 0 {
+		width = DefaultWidth
+	}
+	if height <= 0 {
+		height = DefaultHeight
+	}
+	return width, height
+}
+
+// Label width is estimated from the character count, both renderer use around 7 pixel per character
+const charWidth = 7
+
+// layout is the position of the plot area and the axis ticks shared by both renderer
+type layout struct {
+	width, height            int
+	left, top, right, bottom float64
+	from, to                 time.Time
+	min, max                 float64
+	yTicks                   []float64
+	yDecimals                int
+	xTicks                   []time.Time
+	xFormat                  string
+}
+
+func (c *Chart) layout() layout {
+	l := layout{}
+	l.width, l.height = c.size()
+
+	l.from, l.to = c.From, c.To
+	if len(c.Points) > 0 {
+		if l.from.IsZero() {
+			l.from = c.Points[0].Time
+		}
+		if l.to.IsZero() {
+			l.to = c.Points[len(c.Points)-1].Time
+		}
+	}
+	if l.to.IsZero() {
+		l.to = time.Now()
+	}
+	if l.from.IsZero() {
+		l.from = l.to.Add(-24 * time.Hour)
+	}
+	if !l.from.Before(l.to) {
+		l.from = l.to.Add(-time.Minute)
+	}
+
+	l.min, l.max = math.Inf(1), math.Inf(-1)
+	for _, point := range c.Points {
+		l.min = math.Min(l.min, point.Value)
+		l.max = math.Max(l.max, point.Value)
+	}
+	if len(c.Points) == 0 {
+		l.min, l.max = 0, 1
+	}
+	if l.min == l.max {
+		l.min, l.max = l.min-1, l.max+1
+	}
+
+	// Around one y tick per 60 pixel
+	yStep := niceStep((l.max - l.min) / math.Max(2, float64(l.height)/60))
+	l.min = math.Floor(l.min/yStep) * yStep
+	l.max = math.Ceil(l.max/yStep) * yStep
+	for v := l.min; v <= l.max+yStep/2; v += yStep {
+		l.yTicks = append(l.yTicks, v)
+	}
+	if yStep < 1 {
+		l.yDecimals = int(math.Ceil(-math.Log10(yStep)))
+	}
+
+	labelLength := 0
+	for _, tick := range l.yTicks {
+		if length := len(l.formatValue(tick)); length > labelLength {
+			labelLength = length
+		}
+	}
+	l.left = float64(labelLength*charWidth + 14)
+	l.top = 14
+	if c.Title != "" {
+		l.top = 36
+	}
+	l.right = float64(l.width) - 20
+	l.bottom = float64(l.height) - 28
+
+	// Tick which label would be cut by the image edge is skipped
+	ticks, format := timeTicks(l.from, l.to, int((l.right-l.left)/110))
+	l.xFormat = format
+	halfLabel := float64(len(format)*charWidth) / 2
+	for _, tick := range ticks {
+		x := l.x(tick)
+		if x-halfLabel >= 0 && x+halfLabel <= float64(l.width) {
+			l.xTicks = append(l.xTicks, tick)
+		}
+	}
+	return l
+}
+
+func (l *layout) x(t time.Time) float64 {
+	ratio := float64(t.Sub(l.from)) / float64(l.to.Sub(l.from))
+	return l.left + ratio*(l.right-l.left)
+}
+
+func (l *layout) y(value float64) float64 {
+	ratio := (value - l.min) / (l.max - l.min)
+	return l.bottom - ratio*(l.bottom-l.top)
+}
+
+func (l *layout) formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', l.yDecimals, 64)
+}
+
+func (l *layout) formatTime(t time.Time) string {
+	return t.UTC().Format(l.xFormat)
+}
+
+// niceStep round the step up to 1, 2, or 5 times power of ten
+func niceStep(step float64) float64 {
+	if step <= 0 || math.IsNaN(step) || math.IsInf(step, 0) {
+		return 1
+	}
+	magnitude := math.Pow(10, math.Floor(math.Log10(step)))
+	for _, multiplier := range []float64{1, 2, 5, 10} {
+		if step <= multiplier*magnitude {
+			return multiplier * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+var timeSteps = []time.Duration{
+	time.Second, 5 * time.Second, 15 * time.Second, 30 * time.Second,
+	time.Minute, 5 * time.Minute, 15 * time.Minute, 30 * time.Minute,
+	time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour,
+	24 * time.Hour, 2 * 24 * time.Hour, 7 * 24 * time.Hour, 30 * 24 * time.Hour,
+	90 * 24 * time.Hour, 365 * 24 * time.Hour,
+}
+
+// timeTicks return the tick at a round time with at most maxTicks tick and the format of its label
+func timeTicks(from time.Time, to time.Time, maxTicks int) ([]time.Time, string) {
+	if maxTicks < 2 {
+		maxTicks = 2
+	}
+	span := to.Sub(from)
+	step := timeSteps[len(timeSteps)-1]
+	for _, candidate := range timeSteps {
+		if span/candidate <= time.Duration(maxTicks) {
+			step = candidate
+			break
+		}
+	}
+
+	format := "2006-01-02"
+	switch {
+	case step < time.Minute:
+		format = "15:04:05"
+	case step < 24*time.Hour && span <= 24*time.Hour:
+		format = "15:04"
+	case step < 24*time.Hour:
+		format = "01-02 15:04"
+	}
+
+	ticks := []time.Time{}
+	tick := from.UTC().Truncate(step)
+	if tick.Before(from) {
+		tick = tick.Add(step)
+	}
+	for ; !tick.After(to); tick = tick.Add(step) {
+		ticks = append(ticks, tick)
+	}
+	return ticks, format
+}
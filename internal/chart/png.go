@@ -0,0 +1,112 @@
+package chart
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"strconv"
+)
+
+// PNG write the chart as PNG image
+func (c *Chart) PNG(w io.Writer) error {
+	return png.Encode(w, c.Image())
+}
+
+// Image draw the chart on a new image, for the document that embed the chart pixel like PDF
+func (c *Chart) Image() *image.RGBA {
+	l := c.layout()
+	p := c.palette()
+	img := image.NewRGBA(image.Rect(0, 0, l.width, l.height))
+
+	background := parseHex(p.background)
+	text := parseHex(p.text)
+	grid := parseHex(p.grid)
+	line := parseHex(p.line)
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = background.R, background.G, background.B, 255
+	}
+
+	if c.Title != "" {
+		drawText(img, int(l.left), 10, c.Title, text, 2, alignLeft)
+	}
+
+	for _, tick := range l.yTicks {
+		y := int(math.Round(l.y(tick)))
+		fillRect(img, int(l.left), y, int(l.right), y+1, grid, 1)
+		drawText(img, int(l.left)-6, y-3, l.formatValue(tick), text, 1, alignRight)
+	}
+	for _, tick := range l.xTicks {
+		x := int(math.Round(l.x(tick)))
+		fillRect(img, x, int(l.bottom), x+1, int(l.bottom)+5, grid, 1)
+		drawText(img, x, int(l.bottom)+10, l.formatTime(tick), text, 1, alignCenter)
+	}
+
+	if len(c.Points) == 0 {
+		drawText(img, int((l.left+l.right)/2), int((l.top+l.bottom)/2), "No data in this range", text, 1, alignCenter)
+	} else {
+		// Area under the line, filled column by column between two point
+		for i := 1; i < len(c.Points); i++ {
+			x0, y0 := l.x(c.Points[i-1].Time), l.y(c.Points[i-1].Value)
+			x1, y1 := l.x(c.Points[i].Time), l.y(c.Points[i].Value)
+			for x := int(math.Ceil(x0)); float64(x) < x1; x++ {
+				y := y0 + (y1-y0)*(float64(x)-x0)/(x1-x0)
+				fillRect(img, x, int(math.Round(y)), x+1, int(l.bottom), line, 0.2)
+			}
+		}
+		for i, point := range c.Points {
+			x, y := l.x(point.Time), l.y(point.Value)
+			if i == 0 {
+				fillRect(img, int(x)-1, int(y)-1, int(x)+1, int(y)+1, line, 1)
+				continue
+			}
+			previous := c.Points[i-1]
+			drawLine(img, l.x(previous.Time), l.y(previous.Value), x, y, line)
+		}
+	}
+
+	fillRect(img, int(l.left), int(l.bottom), int(l.right), int(l.bottom)+1, text, 1)
+	return img
+}
+
+func parseHex(hex string) color.RGBA {
+	value, _ := strconv.ParseUint(hex[1:], 16, 32)
+	return color.RGBA{R: uint8(value >> 16), G: uint8(value >> 8), B: uint8(value), A: 255}
+}
+
+// blend paint the pixel with the color over the current pixel with the given opacity
+func blend(img *image.RGBA, x int, y int, c color.RGBA, opacity float64) {
+	if !(image.Point{X: x, Y: y}).In(img.Rect) {
+		return
+	}
+	i := img.PixOffset(x, y)
+	img.Pix[i] = uint8(float64(img.Pix[i])*(1-opacity) + float64(c.R)*opacity)
+	img.Pix[i+1] = uint8(float64(img.Pix[i+1])*(1-opacity) + float64(c.G)*opacity)
+	img.Pix[i+2] = uint8(float64(img.Pix[i+2])*(1-opacity) + float64(c.B)*opacity)
+}
+
+func fillRect(img *image.RGBA, x0 int, y0 int, x1 int, y1 int, c color.RGBA, opacity float64) {
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			blend(img, x, y, c, opacity)
+		}
+	}
+}
+
+// drawLine stamp a 2 pixel square along the line, good enough for a chart without antialiasing
+func drawLine(img *image.RGBA, x0 float64, y0 float64, x1 float64, y1 float64, c color.RGBA) {
+	steps := int(math.Ceil(math.Max(math.Abs(x1-x0), math.Abs(y1-y0))))
+	if steps == 0 {
+		steps = 1
+	}
+	for i := 0; i <= steps; i++ {
+		t := float64(i) / float64(steps)
+		x := int(math.Round(x0 + (x1-x0)*t))
+		y := int(math.Round(y0 + (y1-y0)*t))
+		img.SetRGBA(x, y, c)
+		img.SetRGBA(x+1, y, c)
+		img.SetRGBA(x, y+1, c)
+		img.SetRGBA(x+1, y+1, c)
+	}
+}
@@ -0,0 +1,52 @@
+package chart
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+)
+
+// SVG write the chart as SVG document
+func (c *Chart) SVG(w io.Writer) error {
+	l := c.layout()
+	p := c.palette()
+	out := bufio.NewWriter(w)
+
+	fmt.Fprintf(out, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="Helvetica, Arial, sans-serif" font-size="12">`, l.width, l.height, l.width, l.height)
+	fmt.Fprintf(out, `<rect width="100%%" height="100%%" fill="%s"/>`, p.background)
+	if c.Title != "" {
+		fmt.Fprintf(out, `<text x="%.1f" y="22" fill="%s" font-size="15" font-weight="bold">%s</text>`, l.left, p.text, html.EscapeString(c.Title))
+	}
+
+	for _, tick := range l.yTicks {
+		y := l.y(tick)
+		fmt.Fprintf(out, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="%s"/>`, l.left, y, l.right, y, p.grid)
+		fmt.Fprintf(out, `<text x="%.1f" y="%.1f" fill="%s" text-anchor="end" dominant-baseline="middle">%s</text>`, l.left-6, y, p.text, l.formatValue(tick))
+	}
+	for _, tick := range l.xTicks {
+		x := l.x(tick)
+		fmt.Fprintf(out, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="%s"/>`, x, l.bottom, x, l.bottom+5, p.grid)
+		fmt.Fprintf(out, `<text x="%.1f" y="%.1f" fill="%s" text-anchor="middle">%s</text>`, x, l.bottom+18, p.text, l.formatTime(tick))
+	}
+
+	if len(c.Points) == 0 {
+		fmt.Fprintf(out, `<text x="%.1f" y="%.1f" fill="%s" text-anchor="middle">No data in this range</text>`, (l.left+l.right)/2, (l.top+l.bottom)/2, p.text)
+	} else {
+		line := make([]byte, 0, len(c.Points)*16)
+		for i, point := range c.Points {
+			command := "L"
+			if i == 0 {
+				command = "M"
+			}
+			line = append(line, fmt.Sprintf("%s%.1f %.1f", command, l.x(point.Time), l.y(point.Value))...)
+		}
+		first, last := l.x(c.Points[0].Time), l.x(c.Points[len(c.Points)-1].Time)
+		fmt.Fprintf(out, `<path d="%sL%.1f %.1fL%.1f %.1fZ" fill="%s" fill-opacity="0.2" stroke="none"/>`, line, last, l.bottom, first, l.bottom, p.line)
+		fmt.Fprintf(out, `<path d="%s" fill="none" stroke="%s" stroke-width="2" stroke-linejoin="round"/>`, line, p.line)
+	}
+
+	fmt.Fprintf(out, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="%s"/>`, l.left, l.bottom, l.right, l.bottom, p.text)
+	out.WriteString("</svg>")
+	return out.Flush()
+}
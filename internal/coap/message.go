@@ -0,0 +1,255 @@
+package coap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// Message type
+const (
+	typeConfirmable     = 0
+	typeNonConfirmable  = 1
+	typeAcknowledgement = 2
+	typeReset           = 3
+)
+
+// Code is the class in the 3 high bit and the detail in the 5 low bit, written class.detail
+const (
+	codeEmpty               = 0x00
+	codeGet                 = 0x01
+	codePost                = 0x02
+	codeCreated             = 0x41
+	codeChanged             = 0x44
+	codeContent             = 0x45
+	codeBadRequest          = 0x80
+	codeUnauthorized        = 0x81
+	codeBadOption           = 0x82
+	codeForbidden           = 0x83
+	codeNotFound            = 0x84
+	codeMethodNotAllowed    = 0x85
+	codeRequestTooLarge     = 0x8d
+	codeUnsupportedFormat   = 0x8f
+	codeTooManyRequests     = 0x9d
+	codeInternalServerError = 0xa0
+	codeServiceUnavailable  = 0xa3
+)
+
+// Option number, an odd number is critical and must be understood by the server
+const (
+	optionUriHost       = 3
+	optionObserve       = 6
+	optionUriPort       = 7
+	optionUriPath       = 11
+	optionContentFormat = 12
+	optionMaxAge        = 14
+	optionUriQuery      = 15
+	optionAccept        = 17
+)
+
+// Content format
+const (
+	formatText = 0
+	formatJson = 50
+)
+
+const (
+	version       = 1
+	payloadMarker = 0xff
+	maxTokenSize  = 8
+)
+
+var errMalformed = errors.New("malformed CoAP message")
+
+type option struct {
+	number uint16
+	value  []byte
+}
+
+type message struct {
+	kind    byte
+	code    byte
+	id      uint16
+	token   []byte
+	options []option
+	payload []byte
+}
+
+// decodeMessage parse a datagram, the option are kept in the order they are sent which is by number
+func decodeMessage(data []byte) (msg message, err error) {
+	if len(data) < 4 || data[0]>>6 != version {
+		return msg, errMalformed
+	}
+	msg.kind = (data[0] >> 4) & 0x03
+	tokenSize := int(data[0] & 0x0f)
+	msg.code = data[1]
+	msg.id = binary.BigEndian.Uint16(data[2:])
+	if tokenSize > maxTokenSize || len(data) < 4+tokenSize {
+		return msg, errMalformed
+	}
+	msg.token = data[4 : 4+tokenSize]
+	rest := data[4+tokenSize:]
+
+	number := 0
+	for len(rest) > 0 {
+		if rest[0] == payloadMarker {
+			// A marker followed by nothing is a format error
+			if len(rest) == 1 {
+				return msg, errMalformed
+			}
+			msg.payload = rest[1:]
+			break
+		}
+		delta := int(rest[0] >> 4)
+		length := int(rest[0] & 0x0f)
+		rest = rest[1:]
+		delta, rest, err = readOptionNibble(delta, rest)
+		if err != nil {
+			return msg, err
+		}
+		length, rest, err = readOptionNibble(length, rest)
+		if err != nil {
+			return msg, err
+		}
+		if len(rest) < length {
+			return msg, errMalformed
+		}
+		number += delta
+		if number > 0xffff {
+			return msg, errMalformed
+		}
+		msg.options = append(msg.options, option{number: uint16(number), value: rest[:length]})
+		rest = rest[length:]
+	}
+	return msg, nil
+}
+
+// readOptionNibble read the extended delta or length that follow the option header, 13 add one byte
+// and 14 add two byte, 15 is reserved for the payload marker
+func readOptionNibble(nibble int, rest []byte) (int, []byte, error) {
+	switch nibble {
+	case 13:
+		if len(rest) < 1 {
+			return 0, nil, errMalformed
+		}
+		return int(rest[0]) + 13, rest[1:], nil
+	case 14:
+		if len(rest) < 2 {
+			return 0, nil, errMalformed
+		}
+		return int(binary.BigEndian.Uint16(rest)) + 269, rest[2:], nil
+	case 15:
+		return 0, nil, errMalformed
+	}
+	return nibble, rest, nil
+}
+
+// encode write the message with its option sorted by number, as the option delta require
+func (m *message) encode() []byte {
+	buffer := &bytes.Buffer{}
+	buffer.WriteByte(version<<6 | m.kind<<4 | byte(len(m.token)))
+	buffer.WriteByte(m.code)
+	binary.Write(buffer, binary.BigEndian, m.id)
+	buffer.Write(m.token)
+
+	options := append([]option{}, m.options...)
+	sort.SliceStable(options, func(i, j int) bool {
+		return options[i].number < options[j].number
+	})
+	previous := 0
+	for _, opt := range options {
+		delta, deltaExtended := optionNibble(int(opt.number) - previous)
+		length, lengthExtended := optionNibble(len(opt.value))
+		buffer.WriteByte(byte(delta<<4 | length))
+		buffer.Write(deltaExtended)
+		buffer.Write(lengthExtended)
+		buffer.Write(opt.value)
+		previous = int(opt.number)
+	}
+
+	if len(m.payload) > 0 {
+		buffer.WriteByte(payloadMarker)
+		buffer.Write(m.payload)
+	}
+	return buffer.Bytes()
+}
+
+// optionNibble return the nibble and the extended byte of an option delta or length
+func optionNibble(value int) (int, []byte) {
+	switch {
+	case value < 13:
+		return value, nil
+	case value < 269:
+		return 13, []byte{byte(value - 13)}
+	default:
+		extended := make([]byte, 2)
+		binary.BigEndian.PutUint16(extended, uint16(value-269))
+		return 14, extended
+	}
+}
+
+// encodeUint return the shortest big endian form of an uint option, 0 is empty
+func encodeUint(value uint32) []byte {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, value)
+	for len(data) > 0 && data[0] == 0 {
+		data = data[1:]
+	}
+	return data
+}
+
+func decodeUint(data []byte) (value uint32) {
+	for _, b := range data {
+		value = value<<8 | uint32(b)
+	}
+	return value
+}
+
+// uintOption return the first option of the number as an uint
+func (m *message) uintOption(number uint16) (value uint32, ok bool) {
+	for _, opt := range m.options {
+		if opt.number == number {
+			return decodeUint(opt.value), true
+		}
+	}
+	return 0, false
+}
+
+// path return the Uri-Path segment
+func (m *message) path() []string {
+	path := []string{}
+	for _, opt := range m.options {
+		if opt.number == optionUriPath {
+			path = append(path, string(opt.value))
+		}
+	}
+	return path
+}
+
+// query return the value of a Uri-Query written name=value
+func (m *message) query(name string) string {
+	prefix := name + "="
+	for _, opt := range m.options {
+		if opt.number == optionUriQuery && bytes.HasPrefix(opt.value, []byte(prefix)) {
+			return string(opt.value[len(prefix):])
+		}
+	}
+	return ""
+}
+
+// unknownCritical return true when the message has a critical option the server doesn't understand,
+// like Block1 and Block2 since a request and a response always fit in one datagram
+func (m *message) unknownCritical() bool {
+	for _, opt := range m.options {
+		if opt.number%2 == 0 {
+			continue
+		}
+		switch opt.number {
+		case optionUriHost, optionUriPort, optionUriPath, optionUriQuery, optionAccept:
+		default:
+			return true
+		}
+	}
+	return false
+}
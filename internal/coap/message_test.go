@@ -0,0 +1,199 @@
+package coap
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestDecodeMessageGolden(t *testing.T) {
+	// The GET of the RFC 7252 appendix A, with a token and a CON Uri-Path "temperature"
+	data, _ := hex.DecodeString("4201" + "7d34" + "2030" + "bb" + hex.EncodeToString([]byte("temperature")))
+	msg, err := decodeMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.kind != typeConfirmable || msg.code != codeGet || msg.id != 0x7d34 || !bytes.Equal(msg.token, []byte{0x20, 0x30}) {
+		t.Fatalf("got %+v", msg)
+	}
+	if path := msg.path(); len(path) != 1 || path[0] != "temperature" || msg.payload != nil {
+		t.Fatalf("got path %q and payload %q", path, msg.payload)
+	}
+	if !bytes.Equal(msg.encode(), data) {
+		t.Fatalf("encoded %x, want %x", msg.encode(), data)
+	}
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  message
+	}{
+		{"empty ack", message{kind: typeAcknowledgement, code: codeEmpty, id: 1, token: []byte{}}},
+		{"reset", message{kind: typeReset, code: codeEmpty, id: 0xffff, token: []byte{}}},
+		{"full token", message{kind: typeNonConfirmable, code: codePost, id: 2, token: []byte{1, 2, 3, 4, 5, 6, 7, 8}}},
+		{"post with payload", message{kind: typeConfirmable, code: codePost, id: 3, token: []byte{9},
+			options: []option{
+				{number: optionUriPath, value: []byte("sensor")},
+				{number: optionUriPath, value: []byte("12")},
+				{number: optionContentFormat, value: encodeUint(formatJson)},
+				{number: optionUriQuery, value: []byte("key=abc")},
+			},
+			payload: []byte(`{"value": 21.5}`)}},
+		{"empty option", message{kind: typeConfirmable, code: codeGet, id: 4, token: []byte{},
+			options: []option{{number: optionObserve, value: []byte{}}, {number: optionContentFormat, value: []byte{}}}}},
+		// A delta and a length of 13 to 268 take one more byte, from 269 two more byte
+		{"one byte extension", message{kind: typeConfirmable, code: codeContent, id: 5, token: []byte{},
+			options: []option{{number: 13, value: bytes.Repeat([]byte{'a'}, 13)}, {number: 281, value: bytes.Repeat([]byte{'b'}, 268)}}}},
+		{"two byte extension", message{kind: typeConfirmable, code: codeContent, id: 6, token: []byte{},
+			options: []option{{number: 269, value: bytes.Repeat([]byte{'c'}, 269)}, {number: 0xffff, value: bytes.Repeat([]byte{'d'}, 1000)}}}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			data := tc.msg.encode()
+			decoded, err := decodeMessage(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decoded.kind != tc.msg.kind || decoded.code != tc.msg.code || decoded.id != tc.msg.id || !bytes.Equal(decoded.token, tc.msg.token) || !bytes.Equal(decoded.payload, tc.msg.payload) {
+				t.Fatalf("got %+v, want %+v", decoded, tc.msg)
+			}
+			if len(decoded.options) != len(tc.msg.options) {
+				t.Fatalf("got %d option, want %d", len(decoded.options), len(tc.msg.options))
+			}
+			for i, opt := range tc.msg.options {
+				if decoded.options[i].number != opt.number || !bytes.Equal(decoded.options[i].value, opt.value) {
+					t.Fatalf("option %d is %d %q, want %d %q", i, decoded.options[i].number, decoded.options[i].value, opt.number, opt.value)
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeSortOption(t *testing.T) {
+	msg := message{kind: typeConfirmable, code: codeGet, id: 7, token: []byte{},
+		options: []option{
+			{number: optionUriQuery, value: []byte("a=1")},
+			{number: optionUriPath, value: []byte("sensor")},
+			{number: optionUriPath, value: []byte("3")},
+		}}
+	decoded, err := decodeMessage(msg.encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The Uri-Path keep their order, they are a repeated option
+	if path := decoded.path(); strings.Join(path, "/") != "sensor/3" || decoded.query("a") != "1" {
+		t.Fatalf("got path %q and query %q", path, decoded.query("a"))
+	}
+	if decoded.options[2].number != optionUriQuery {
+		t.Fatalf("the option isn't sorted: %+v", decoded.options)
+	}
+}
+
+func TestDecodeMessageMalformed(t *testing.T) {
+	tests := map[string]string{
+		"empty":                 "",
+		"short header":          "4001",
+		"version 0":             "00010001",
+		"version 2":             "80010001",
+		"token over 8":          "49010001" + "010203040506070809",
+		"truncated token":       "42010001" + "01",
+		"marker without body":   "40010001" + "ff",
+		"delta 15":              "40010001" + "f0",
+		"length 15":             "40010001" + "bf",
+		"truncated delta 13":    "40010001" + "d0",
+		"truncated delta 14":    "40010001" + "e000",
+		"truncated length 13":   "40010001" + "0d",
+		"truncated length 14":   "40010001" + "0e01",
+		"truncated value":       "40010001" + "b4" + "7465",
+		"option number too big": "40010001" + "e0fef0" + "e00010",
+	}
+	for name, message := range tests {
+		t.Run(name, func(t *testing.T) {
+			data, err := hex.DecodeString(message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = decodeMessage(data)
+			if err != errMalformed {
+				t.Fatalf("decoding %s got %v, want %v", message, err, errMalformed)
+			}
+		})
+	}
+}
+
+func TestUintOption(t *testing.T) {
+	tests := []struct {
+		value uint32
+		data  string
+	}{
+		{0, ""},
+		{1, "01"},
+		{50, "32"},
+		{256, "0100"},
+		{0xffffffff, "ffffffff"},
+	}
+	for _, tc := range tests {
+		got := hex.EncodeToString(encodeUint(tc.value))
+		if got != tc.data {
+			t.Errorf("encodeUint(%d) is %s, want %s", tc.value, got, tc.data)
+		}
+		data, _ := hex.DecodeString(tc.data)
+		if decodeUint(data) != tc.value {
+			t.Errorf("decodeUint(%s) is %d, want %d", tc.data, decodeUint(data), tc.value)
+		}
+	}
+
+	msg := message{options: []option{{number: optionObserve, value: []byte{}}, {number: optionContentFormat, value: []byte{50}}}}
+	if value, ok := msg.uintOption(optionObserve); !ok || value != 0 {
+		t.Errorf("observe is %d %v, want 0 true", value, ok)
+	}
+	if value, ok := msg.uintOption(optionContentFormat); !ok || value != formatJson {
+		t.Errorf("content format is %d %v, want 50 true", value, ok)
+	}
+	if _, ok := msg.uintOption(optionAccept); ok {
+		t.Error("accept isn't set")
+	}
+}
+
+func TestUnknownCritical(t *testing.T) {
+	tests := []struct {
+		number uint16
+		want   bool
+	}{
+		{optionUriPath, false},
+		{optionUriQuery, false},
+		{optionContentFormat, false},
+		{optionObserve, false},
+		{27, true}, // Block1
+		{23, true}, // Block2
+		{28, false},
+	}
+	for _, tc := range tests {
+		msg := message{options: []option{{number: tc.number}}}
+		if msg.unknownCritical() != tc.want {
+			t.Errorf("option %d critical is %v, want %v", tc.number, !tc.want, tc.want)
+		}
+	}
+}
+
+// FuzzDecodeMessage check a datagram never panic, and that a valid one is encoded back the same since
+// every delta and length has one encoding
+func FuzzDecodeMessage(f *testing.F) {
+	f.Add([]byte{0x40, 0x01, 0x7d, 0x34, 0xbb, 't', 'e', 'm', 'p', 'e', 'r', 'a', 't', 'u', 'r', 'e'})
+	f.Add((&message{kind: typeConfirmable, code: codePost, id: 3, token: []byte{9},
+		options: []option{{number: optionUriPath, value: []byte("sensor")}, {number: 300, value: bytes.Repeat([]byte{1}, 300)}},
+		payload: []byte("21.5")}).encode())
+	f.Add([]byte{0x40, 0x01, 0x00, 0x01, 0xff})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, err := decodeMessage(data)
+		if err != nil {
+			return
+		}
+		encoded := msg.encode()
+		if !bytes.Equal(encoded, data) {
+			t.Fatalf("decoded %x encoded back as %x", data, encoded)
+		}
+	})
+}
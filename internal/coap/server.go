@@ -0,0 +1,562 @@
+// Package coap serve the sensor to constrained device over CoAP (RFC 7252) on UDP. A device send its
+// reading with POST like POST /channel and watch a sensor with an observed GET (RFC 7641). CoAP has
+// no header, so the device is authenticated with the API key of its node in the key query. The
+// message is encoded by hand (message.go).
+package coap
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/ingest"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// A request and its response fit in one datagram, block transfer isn't supported
+	maxMessageSize = 1152
+	// A request sent again within this long get the same response, so it isn't stored twice
+	exchangeLifetime = 247 * time.Second
+	requestTimeout   = 10 * time.Second
+	// Request handled at once, the other wait in the socket buffer
+	maxConcurrentRequest = 64
+	// An observation end after this long unless the device register again, a device gone without
+	// a reset would be notified forever otherwise
+	observeLifetime     = time.Hour
+	observeSequenceMask = 0xffffff
+	codeNotAcceptable   = 0x86
+)
+
+// Server answer the CoAP request of the device. The observation is kept by the instance the device
+// registered on and notified from the realtime hub, which receive the channel of every instance
+type Server struct {
+	db                *pgxpool.Pool
+	sensorRepository  *repositories.SensorRepository
+	channelRepository *repositories.ChannelRepository
+	apiKeyRepository  *repositories.ApiKeyRepository
+	pipeline          *ingest.Pipeline
+	hub               *dependencies.RealtimeHub
+	validator         *dependencies.Validator
+	maxObservers      int
+	conn              *net.UDPConn
+	messageId         uint32
+	mutex             sync.Mutex
+	// Response of the recent request by address and message id, nil while it is handled
+	exchanges map[string]*exchange
+	// Observation by address and token
+	observers map[string]*observer
+}
+
+type exchange struct {
+	response []byte
+	expires  time.Time
+}
+
+type observer struct {
+	addr     *net.UDPAddr
+	token    []byte
+	idSensor int
+	accept   uint32
+	sequence uint32
+	// A reset to the last notification end the observation
+	lastMessageId uint16
+	stop          func()
+}
+
+func NewServer(db *pgxpool.Pool, sensorRepository *repositories.SensorRepository, channelRepository *repositories.ChannelRepository, apiKeyRepository *repositories.ApiKeyRepository, pipeline *ingest.Pipeline, hub *dependencies.RealtimeHub, validator *dependencies.Validator, config *configs.Config) (*Server, error) {
+	maxObservers := config.Coap.MaxObservers
+	if maxObservers <= 0 {
+		maxObservers = 1000
+	}
+	return &Server{
+		db:                db,
+		sensorRepository:  sensorRepository,
+		channelRepository: channelRepository,
+		apiKeyRepository:  apiKeyRepository,
+		pipeline:          pipeline,
+		hub:               hub,
+		validator:         validator,
+		maxObservers:      maxObservers,
+		// The message id of a restarted server shouldn't repeat the one the device just saw
+		messageId: uint32(time.Now().UnixNano()),
+		exchanges: map[string]*exchange{},
+		observers: map[string]*observer{},
+	}, nil
+}
+
+// ListenAndServe serve CoAP on the UDP address until it fail
+func (s *Server) ListenAndServe(addr string) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	s.conn = conn
+	log.Printf("[COAP] Listening on %s", addr)
+
+	semaphore := make(chan struct{}, maxConcurrentRequest)
+	cleanAt := time.Now().Add(time.Minute)
+	// One byte more than the limit tell a datagram that was too large
+	buffer := make([]byte, maxMessageSize+1)
+	for {
+		n, remote, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			return err
+		}
+		if now := time.Now(); now.After(cleanAt) {
+			s.cleanExchanges(now)
+			cleanAt = now.Add(time.Minute)
+		}
+
+		data := append([]byte{}, buffer[:n]...)
+		semaphore <- struct{}{}
+		go func() {
+			defer func() { <-semaphore }()
+			s.handle(remote, data, n > maxMessageSize)
+		}()
+	}
+}
+
+func (s *Server) nextMessageId() uint16 {
+	return uint16(atomic.AddUint32(&s.messageId, 1))
+}
+
+func (s *Server) send(msg *message, addr *net.UDPAddr) []byte {
+	data := msg.encode()
+	_, err := s.conn.WriteToUDP(data, addr)
+	if err != nil {
+		log.Printf("[COAP] Error sending to %s: %v", addr, err)
+	}
+	return data
+}
+
+func (s *Server) cleanExchanges(now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for key, exchange := range s.exchanges {
+		if now.After(exchange.expires) {
+			delete(s.exchanges, key)
+		}
+	}
+}
+
+// handle answer one datagram, a confirmable request is answered with a piggybacked acknowledgement
+// and a non-confirmable one with a non-confirmable response
+func (s *Server) handle(remote *net.UDPAddr, data []byte, tooLarge bool) {
+	request, err := decodeMessage(data)
+	if err != nil {
+		if len(data) >= 4 && (data[0]>>4)&0x03 == typeConfirmable {
+			s.send(&message{kind: typeReset, id: binary.BigEndian.Uint16(data[2:])}, remote)
+		}
+		return
+	}
+
+	switch {
+	case request.kind == typeReset:
+		s.cancelByMessageId(remote, request.id)
+		return
+	case request.kind == typeAcknowledgement:
+		return
+	case request.code == codeEmpty || request.code>>5 != 0:
+		// An empty confirmable message is a ping, a response isn't expected by the server
+		if request.kind == typeConfirmable {
+			s.send(&message{kind: typeReset, id: request.id}, remote)
+		}
+		return
+	}
+
+	key := remote.String() + "/" + strconv.Itoa(int(request.id))
+	s.mutex.Lock()
+	previous, found := s.exchanges[key]
+	var previousResponse []byte
+	if found {
+		previousResponse = previous.response
+	} else {
+		s.exchanges[key] = &exchange{expires: time.Now().Add(exchangeLifetime)}
+	}
+	s.mutex.Unlock()
+	if found {
+		// A request still handled is answered when it is done
+		if previousResponse != nil {
+			s.conn.WriteToUDP(previousResponse, remote)
+		}
+		return
+	}
+
+	response := message{kind: typeNonConfirmable, id: s.nextMessageId(), token: request.token}
+	if request.kind == typeConfirmable {
+		response.kind = typeAcknowledgement
+		response.id = request.id
+	}
+	var after func()
+	switch {
+	case tooLarge:
+		setError(&response, fiber.NewError(fiber.StatusRequestEntityTooLarge, fmt.Sprintf("The message is larger than %d byte", maxMessageSize)))
+	case request.unknownCritical():
+		response.code = codeBadOption
+	default:
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		after = s.serve(ctx, remote, &request, &response)
+		cancel()
+	}
+
+	sent := s.send(&response, remote)
+	s.mutex.Lock()
+	s.exchanges[key].response = sent
+	s.mutex.Unlock()
+	if after != nil {
+		after()
+	}
+}
+
+// serve route the request, after is run once the response is sent
+func (s *Server) serve(ctx context.Context, remote *net.UDPAddr, request *message, response *message) (after func()) {
+	path := request.path()
+	var err error
+	switch {
+	case len(path) == 2 && path[0] == "sensor":
+		idSensor, convErr := strconv.Atoi(path[1])
+		if convErr != nil || idSensor <= 0 {
+			err = fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("Sensor %s not found", path[1]))
+			break
+		}
+		switch request.code {
+		case codeGet:
+			after, err = s.read(ctx, remote, request, response, idSensor)
+		case codePost:
+			err = s.store(ctx, request, response, idSensor)
+		default:
+			err = fiber.NewError(fiber.StatusMethodNotAllowed, "Only GET and POST are allowed on a sensor")
+		}
+	case len(path) == 1 && path[0] == "channel":
+		if request.code != codePost {
+			err = fiber.NewError(fiber.StatusMethodNotAllowed, "Only POST is allowed on channel")
+			break
+		}
+		err = s.store(ctx, request, response, 0)
+	default:
+		err = fiber.NewError(fiber.StatusNotFound, "Resource not found, use /sensor/{id} or /channel")
+	}
+	if err != nil {
+		setError(response, err)
+		return nil
+	}
+	return after
+}
+
+// authenticate return the owner of the node of the API key, which must be the node of the sensor
+func (s *Server) authenticate(ctx context.Context, request *message, idSensor int) (user entities.UserRead, err error) {
+	key := request.query("key")
+	if key == "" {
+		return user, fiber.NewError(fiber.StatusUnauthorized, "The key query with the API key of the node is required")
+	}
+	user, nodeId, err := s.apiKeyRepository.Authenticate(ctx, s.db, key)
+	if err != nil {
+		return user, err
+	}
+	sensorNode, err := s.sensorRepository.GetIdNodeById(ctx, s.db, idSensor)
+	if err != nil {
+		return user, err
+	}
+	if sensorNode != nodeId {
+		return user, fiber.NewError(fiber.StatusForbidden, "The API key can only access the sensor of its node")
+	}
+	return user, nil
+}
+
+// store the channel of the payload like POST /channel. On /sensor/{id} the payload is a JSON channel
+// without id_sensor or a compact profile body, on /channel it is the JSON body of POST /channel
+func (s *Server) store(ctx context.Context, request *message, response *message, idSensor int) (err error) {
+	var user entities.UserRead
+	if idSensor > 0 {
+		user, err = s.authenticate(ctx, request, idSensor)
+		if err != nil {
+			return err
+		}
+	}
+
+	channels, err := s.parsePayload(request, idSensor)
+	if err != nil {
+		if idSensor > 0 {
+			s.pipeline.Record(idSensor, len(request.payload), err)
+		}
+		return err
+	}
+	if idSensor == 0 {
+		idSensor = channels[0].IdSensor
+		user, err = s.authenticate(ctx, request, idSensor)
+		if err != nil {
+			return err
+		}
+	}
+
+	stored := false
+	coalesced := false
+	for i := range channels {
+		_, isCoalesced, err := s.pipeline.Store(ctx, user.IdUser, &channels[i])
+		s.pipeline.Record(idSensor, len(request.payload), err)
+		if errors.Is(err, ingest.ErrDropped) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		stored = true
+		coalesced = coalesced || isCoalesced
+	}
+	switch {
+	case !stored:
+		return ingest.ErrDropped
+	case coalesced:
+		response.code = codeChanged
+	default:
+		response.code = codeCreated
+	}
+	return nil
+}
+
+// parsePayload read a JSON payload, or a compact profile body when the content format is text or
+// left out and the payload isn't an object
+func (s *Server) parsePayload(request *message, idSensor int) ([]entities.ChannelCreate, error) {
+	format, hasFormat := request.uintOption(optionContentFormat)
+	if hasFormat && format != formatJson && format != formatText {
+		return nil, fiber.NewError(fiber.StatusUnsupportedMediaType, "The content format must be text/plain (0) or application/json (50)")
+	}
+	isJson := format == formatJson && hasFormat
+	if !hasFormat {
+		isJson = bytes.HasPrefix(bytes.TrimSpace(request.payload), []byte("{"))
+	}
+
+	if !isJson {
+		if idSensor == 0 {
+			return nil, fiber.NewError(fiber.StatusUnsupportedMediaType, "The compact body is only accepted on /sensor/{id}")
+		}
+		return ingest.ParseCompact(idSensor, string(request.payload))
+	}
+
+	channel := entities.ChannelCreate{IdSensor: idSensor}
+	err := s.validator.ParseJson(request.payload, &channel)
+	if err != nil {
+		return nil, err
+	}
+	if idSensor > 0 && channel.IdSensor != idSensor {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "The id_sensor of the payload is not the sensor of the path")
+	}
+	return []entities.ChannelCreate{channel}, nil
+}
+
+// read answer the latest channel of the sensor. With Observe 0 the device is notified of every new
+// channel, Observe 1 end the observation. When the server has too many observation the response is
+// sent without Observe, which tell the device it isn't observed
+func (s *Server) read(ctx context.Context, remote *net.UDPAddr, request *message, response *message, idSensor int) (after func(), err error) {
+	_, err = s.authenticate(ctx, request, idSensor)
+	if err != nil {
+		return nil, err
+	}
+	accept, hasAccept := request.uintOption(optionAccept)
+	if !hasAccept {
+		accept = formatJson
+	}
+	if accept != formatJson && accept != formatText {
+		return nil, fiber.NewError(fiber.StatusNotAcceptable, "The accept must be text/plain (0) or application/json (50)")
+	}
+
+	channels, err := s.channelRepository.GetLatestBySensors(ctx, s.db, []int{idSensor})
+	if err != nil {
+		return nil, err
+	}
+	response.code = codeContent
+	if len(channels) > 0 {
+		setChannel(response, channels[0], accept)
+	}
+
+	key := remote.String() + "/" + string(request.token)
+	observe, ok := request.uintOption(optionObserve)
+	switch {
+	case !ok:
+		return nil, nil
+	case observe == 1:
+		s.cancel(key, nil)
+		return nil, nil
+	case observe != 0:
+		return nil, nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	// Registering again with the same token replace the observation
+	if existing := s.observers[key]; existing != nil {
+		delete(s.observers, key)
+		existing.stop()
+	}
+	if len(s.observers) >= s.maxObservers {
+		return nil, nil
+	}
+	updates, unsubscribe := s.hub.Subscribe([]int{idSensor})
+	done := make(chan struct{})
+	var once sync.Once
+	obs := &observer{
+		addr:     remote,
+		token:    append([]byte{}, request.token...),
+		idSensor: idSensor,
+		accept:   accept,
+		sequence: 1,
+		stop: func() {
+			once.Do(func() {
+				unsubscribe()
+				close(done)
+			})
+		},
+	}
+	s.observers[key] = obs
+	response.options = append(response.options, option{number: optionObserve, value: encodeUint(obs.sequence)})
+	return func() { go s.notify(key, obs, updates, done) }, nil
+}
+
+// notify send a non-confirmable notification for each new channel until the observation is canceled
+// or expire. The last one is sent without Observe so the device know to register again
+func (s *Server) notify(key string, obs *observer, updates <-chan entities.Channel, done chan struct{}) {
+	expire := time.NewTimer(observeLifetime)
+	defer expire.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-expire.C:
+			s.cancel(key, obs)
+			notification := message{kind: typeNonConfirmable, code: codeContent, id: s.nextMessageId(), token: obs.token}
+			ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+			channels, err := s.channelRepository.GetLatestBySensors(ctx, s.db, []int{obs.idSensor})
+			cancel()
+			if err != nil {
+				setError(&notification, err)
+			} else if len(channels) > 0 {
+				setChannel(&notification, channels[0], obs.accept)
+			}
+			s.send(&notification, obs.addr)
+			return
+		case channel := <-updates:
+			s.mutex.Lock()
+			obs.sequence = (obs.sequence + 1) & observeSequenceMask
+			obs.lastMessageId = s.nextMessageId()
+			notification := message{
+				kind:    typeNonConfirmable,
+				code:    codeContent,
+				id:      obs.lastMessageId,
+				token:   obs.token,
+				options: []option{{number: optionObserve, value: encodeUint(obs.sequence)}},
+			}
+			s.mutex.Unlock()
+			setChannel(&notification, channel, obs.accept)
+			s.send(&notification, obs.addr)
+		}
+	}
+}
+
+// cancel end the observation of the key, only when it is still obs unless obs is nil
+func (s *Server) cancel(key string, obs *observer) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	existing := s.observers[key]
+	if existing == nil || (obs != nil && existing != obs) {
+		return
+	}
+	delete(s.observers, key)
+	existing.stop()
+}
+
+// cancelByMessageId end the observation the device answered with a reset
+func (s *Server) cancelByMessageId(remote *net.UDPAddr, id uint16) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for key, obs := range s.observers {
+		if obs.lastMessageId == id && obs.addr.String() == remote.String() {
+			delete(s.observers, key)
+			obs.stop()
+		}
+	}
+}
+
+// setChannel write the channel as JSON, or only its value as text
+func setChannel(msg *message, channel entities.Channel, format uint32) {
+	if format == formatText {
+		msg.options = append(msg.options, option{number: optionContentFormat, value: encodeUint(formatText)})
+		msg.payload = []byte(strconv.FormatFloat(channel.Value, 'f', -1, 64))
+		return
+	}
+	payload, err := json.Marshal(channel)
+	if err != nil {
+		setError(msg, err)
+		return
+	}
+	msg.options = append(msg.options, option{number: optionContentFormat, value: encodeUint(formatJson)})
+	msg.payload = payload
+}
+
+// setError map the fiber error to the response code with the message as diagnostic payload. A
+// throttled channel carry the wait in Max-Age, like the Retry-After of the HTTP API
+func setError(msg *message, err error) {
+	status := fiber.StatusInternalServerError
+	diagnostic := "Internal server error"
+	var fiberError *fiber.Error
+	if errors.As(err, &fiberError) {
+		status = fiberError.Code
+		diagnostic = fiberError.Message
+	} else {
+		log.Printf("[COAP] %v", err)
+	}
+
+	switch {
+	case status < 300:
+		msg.code = codeChanged
+	case status == fiber.StatusUnauthorized:
+		msg.code = codeUnauthorized
+	case status == fiber.StatusForbidden:
+		msg.code = codeForbidden
+	case status == fiber.StatusNotFound:
+		msg.code = codeNotFound
+	case status == fiber.StatusMethodNotAllowed:
+		msg.code = codeMethodNotAllowed
+	case status == fiber.StatusNotAcceptable:
+		msg.code = codeNotAcceptable
+	case status == fiber.StatusRequestEntityTooLarge:
+		msg.code = codeRequestTooLarge
+	case status == fiber.StatusUnsupportedMediaType:
+		msg.code = codeUnsupportedFormat
+	case status == fiber.StatusTooManyRequests:
+		msg.code = codeTooManyRequests
+	case status == fiber.StatusServiceUnavailable:
+		msg.code = codeServiceUnavailable
+	case status >= 500:
+		msg.code = codeInternalServerError
+	default:
+		msg.code = codeBadRequest
+	}
+
+	var throttled *ingest.ThrottledError
+	if errors.As(err, &throttled) {
+		seconds := uint32(math.Ceil(throttled.RetryAfter.Seconds()))
+		msg.options = append(msg.options, option{number: optionMaxAge, value: encodeUint(seconds)})
+	}
+	msg.payload = []byte(diagnostic)
+}
@@ -7,12 +7,15 @@ import (
 	"io/ioutil"
 	"log"
 	"path/filepath"
+	"regexp"
+	"strings"
 
 	"github.com/dafaath/iot-server/configs"
 	"github.com/dafaath/iot-server/internal/entities"
 	"github.com/dafaath/iot-server/internal/helper"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type SQLType int
@@ -25,6 +28,8 @@ const (
 	NODE
 	SENSOR
 	CHANNEL
+	DASHBOARD_TEMPLATE
+	UPGRADE
 )
 
 func hashPassword(ctx context.Context, password string) (hashedPassword string, err error) {
@@ -51,6 +56,10 @@ func openSqlFile(sqlType SQLType) string {
 		path = filepath.Join(sqlFolderPath, "sensor.sql")
 	case CHANNEL:
 		path = filepath.Join(sqlFolderPath, "channel.sql")
+	case DASHBOARD_TEMPLATE:
+		path = filepath.Join(sqlFolderPath, "dashboard_template.sql")
+	case UPGRADE:
+		path = filepath.Join(sqlFolderPath, "upgrade.sql")
 	default:
 		panic("There is no sqltype for this code")
 	}
@@ -132,6 +141,14 @@ func createChannel(tx pgx.Tx) error {
 	return err
 }
 
+// Shipped dashboard template, created with the table because it is not mock data
+func createDashboardTemplate(tx pgx.Tx) error {
+	log.Println("Creating dashboard template")
+	sqlStatement := openSqlFile(DASHBOARD_TEMPLATE)
+	_, err := tx.Exec(context.Background(), sqlStatement)
+	return err
+}
+
 func createMockData(tx pgx.Tx, config *configs.Config) error {
 	log.Println("Creating mock data")
 	err := createAdminData(tx, config)
@@ -195,6 +212,9 @@ func CreateTableAndMockData() {
 	err = createTable(tx)
 	helper.PanicIfError(err)
 
+	err = createDashboardTemplate(tx)
+	helper.PanicIfError(err)
+
 	err = createMockData(tx, config)
 	helper.PanicIfError(err)
 
@@ -218,3 +238,68 @@ func CreateTableAndMockData() {
 		}
 	}()
 }
+
+// GetMissingTable compare the table declared in table.sql with the table in the database
+func GetMissingTable(ctx context.Context, db *pgxpool.Pool) (missing []string, err error) {
+	missing = []string{}
+	sqlStatement := openSqlFile(TABLE)
+	matches := regexp.MustCompile(`(?i)CREATE TABLE IF NOT EXISTS\s+"?(\w+)"?`).FindAllStringSubmatch(sqlStatement, -1)
+	for _, match := range matches {
+		var exist bool
+		err = db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema=current_schema() AND table_name=$1)`, match[1]).Scan(&exist)
+		if err != nil {
+			return missing, err
+		}
+		if !exist {
+			missing = append(missing, match[1])
+		}
+	}
+	return missing, nil
+}
+
+// GetMissingColumn compare the column of each table declared in table.sql with the column of the
+// table in the database, as table.column. A missing table is left to GetMissingTable
+func GetMissingColumn(ctx context.Context, db *pgxpool.Pool) (missing []string, err error) {
+	missing = []string{}
+	sqlStatement := openSqlFile(TABLE)
+	tables := regexp.MustCompile(`(?is)CREATE TABLE IF NOT EXISTS\s+"?(\w+)"?\s*\((.*?)\n\);`).FindAllStringSubmatch(sqlStatement, -1)
+	for _, table := range tables {
+		existing := map[string]bool{}
+		rows, err := db.Query(ctx, `SELECT column_name FROM information_schema.columns WHERE table_schema=current_schema() AND table_name=$1`, table[1])
+		if err != nil {
+			return missing, err
+		}
+		for rows.Next() {
+			var column string
+			err = rows.Scan(&column)
+			if err != nil {
+				rows.Close()
+				return missing, err
+			}
+			existing[column] = true
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return missing, err
+		}
+		if len(existing) == 0 {
+			continue
+		}
+
+		for _, line := range strings.Split(table[2], "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+			column := strings.Trim(fields[0], `"`)
+			switch strings.ToUpper(column) {
+			case "FOREIGN", "PRIMARY", "UNIQUE", "CHECK", "CONSTRAINT":
+				continue
+			}
+			if !existing[column] {
+				missing = append(missing, table[1]+"."+column)
+			}
+		}
+	}
+	return missing, nil
+}
@@ -0,0 +1 @@
+insert into dashboard_template (name, columns, auto_apply, widgets) values ('Node overview', 12, true, '[{"type": "map", "title": "Location", "x": 0, "y": 0, "width": 12, "height": 2, "options": {}}, {"type": "value", "sensor_name": "*", "x": 0, "y": 2, "width": 3, "height": 2, "options": {}}, {"type": "chart", "sensor_name": "*", "x": 3, "y": 2, "width": 9, "height": 2, "options": {"range": "24h"}}]');
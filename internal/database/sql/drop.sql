@@ -3,3 +3,58 @@ DROP TABLE IF EXISTS "hardware" CASCADE;
 DROP TABLE IF EXISTS "node" CASCADE;
 DROP TABLE IF EXISTS "sensor" CASCADE;
 DROP TABLE IF EXISTS "channel" CASCADE;
+DROP TABLE IF EXISTS "channel_compressed" CASCADE;
+DROP TABLE IF EXISTS "sensor_compression" CASCADE;
+DROP TABLE IF EXISTS "sensor_validation" CASCADE;
+DROP TABLE IF EXISTS "sensor_filter" CASCADE;
+DROP TABLE IF EXISTS "sensor_throttle" CASCADE;
+DROP TABLE IF EXISTS "sensor_retention" CASCADE;
+DROP TABLE IF EXISTS "sensor_display" CASCADE;
+DROP TABLE IF EXISTS "channel_archive" CASCADE;
+DROP TABLE IF EXISTS "channel_rollup" CASCADE;
+DROP TABLE IF EXISTS "sensor_tag" CASCADE;
+DROP TABLE IF EXISTS "feature_flag" CASCADE;
+DROP TABLE IF EXISTS "user_feature_flag" CASCADE;
+DROP TABLE IF EXISTS "scheduled_job" CASCADE;
+DROP TABLE IF EXISTS "dashboard" CASCADE;
+DROP TABLE IF EXISTS "dashboard_widget" CASCADE;
+DROP TABLE IF EXISTS "dashboard_template" CASCADE;
+DROP TABLE IF EXISTS "notification" CASCADE;
+DROP TABLE IF EXISTS "setting" CASCADE;
+DROP TABLE IF EXISTS "usage_counter" CASCADE;
+DROP TABLE IF EXISTS "storage_policy" CASCADE;
+DROP TABLE IF EXISTS "storage_policy_run" CASCADE;
+DROP TABLE IF EXISTS "webhook" CASCADE;
+DROP TABLE IF EXISTS "webhook_delivery" CASCADE;
+DROP TABLE IF EXISTS "entity_revision" CASCADE;
+DROP TABLE IF EXISTS "node_transfer" CASCADE;
+DROP TABLE IF EXISTS "kpi" CASCADE;
+DROP TABLE IF EXISTS "kpi_value" CASCADE;
+DROP TABLE IF EXISTS "node_maintenance" CASCADE;
+DROP TABLE IF EXISTS "ingest_stat" CASCADE;
+DROP TABLE IF EXISTS "sensor_ingest" CASCADE;
+DROP TABLE IF EXISTS "report" CASCADE;
+DROP TABLE IF EXISTS "hardware_decoder" CASCADE;
+DROP TABLE IF EXISTS "node_sigfox" CASCADE;
+DROP TABLE IF EXISTS "sensor_opcua" CASCADE;
+DROP TABLE IF EXISTS "sensor_bacnet" CASCADE;
+DROP TABLE IF EXISTS "sensor_snmp" CASCADE;
+DROP TABLE IF EXISTS "sensor_snmp_item" CASCADE;
+DROP TABLE IF EXISTS "node_weather" CASCADE;
+DROP TABLE IF EXISTS "sensor_transform" CASCADE;
+DROP TABLE IF EXISTS "script" CASCADE;
+DROP TABLE IF EXISTS "node_status_page" CASCADE;
+DROP TABLE IF EXISTS "sync_state" CASCADE;
+DROP TABLE IF EXISTS "sync_site" CASCADE;
+DROP TABLE IF EXISTS "sync_mapping" CASCADE;
+DROP TABLE IF EXISTS "channel_checksum" CASCADE;
+DROP TABLE IF EXISTS "node_api_key" CASCADE;
+DROP TABLE IF EXISTS "alert_rule" CASCADE;
+DROP TABLE IF EXISTS "alert" CASCADE;
+DROP TABLE IF EXISTS "alert_target" CASCADE;
+DROP TABLE IF EXISTS "node_share" CASCADE;
+DROP TABLE IF EXISTS "channel_aggregate" CASCADE;
+DROP TABLE IF EXISTS "channel_aggregate_state" CASCADE;
+DROP TABLE IF EXISTS "user_session" CASCADE;
+DROP TABLE IF EXISTS "node_firmware" CASCADE;
+DROP TABLE IF EXISTS "firmware" CASCADE;
\ No newline at end of file
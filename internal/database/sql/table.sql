@@ -5,7 +5,10 @@ CREATE TABLE IF NOT EXISTS user_person (
   password VARCHAR (255) NOT NULL, 
   status BOOLEAN DEFAULT FALSE, 
   isadmin BOOLEAN DEFAULT FALSE, 
-  token VARCHAR (255)
+  token VARCHAR (255), 
+  theme VARCHAR (16) NOT NULL DEFAULT 'system', 
+  language VARCHAR (8), 
+  digest BOOLEAN NOT NULL DEFAULT FALSE
 );
 CREATE TABLE IF NOT EXISTS hardware (
   id_hardware SERIAL PRIMARY KEY, 
@@ -19,6 +22,9 @@ CREATE TABLE IF NOT EXISTS node (
   location VARCHAR (255) NOT NULL, 
   id_hardware INTEGER NOT NULL, 
   id_user INTEGER NOT NULL, 
+  deleted_at TIMESTAMP, 
+  last_seen TIMESTAMP, 
+  online BOOLEAN NOT NULL DEFAULT FALSE, 
   FOREIGN KEY (id_hardware) REFERENCES hardware (id_hardware) ON UPDATE CASCADE ON DELETE CASCADE, 
   FOREIGN KEY (id_user) REFERENCES user_person (id_user) ON UPDATE CASCADE ON DELETE CASCADE
 );
@@ -28,6 +34,12 @@ CREATE TABLE IF NOT EXISTS sensor (
   unit VARCHAR (255) NOT NULL, 
   id_hardware INTEGER NOT NULL, 
   id_node INTEGER NOT NULL, 
+  embed_token VARCHAR (64) UNIQUE, 
+  deleted_at TIMESTAMP, 
+  min_value FLOAT, 
+  max_value FLOAT, 
+  calibration_scale FLOAT NOT NULL DEFAULT 1, 
+  calibration_offset FLOAT NOT NULL DEFAULT 0, 
   FOREIGN KEY (id_hardware) REFERENCES hardware (id_hardware) ON UPDATE CASCADE ON DELETE CASCADE, 
   FOREIGN KEY (id_node) REFERENCES node (id_node) ON UPDATE CASCADE ON DELETE CASCADE
 );
@@ -35,5 +47,542 @@ CREATE TABLE IF NOT EXISTS channel (
   time TIMESTAMP, 
   value FLOAT NOT NULL, 
   id_sensor INTEGER NOT NULL, 
+  name VARCHAR (32) NOT NULL DEFAULT '', 
+  quality VARCHAR (16) NOT NULL DEFAULT 'good', 
+  filtered_value FLOAT, 
   FOREIGN KEY (id_sensor) REFERENCES sensor (id_sensor) ON UPDATE CASCADE ON DELETE CASCADE
 );
+CREATE INDEX IF NOT EXISTS channel_id_sensor_time_idx ON channel (id_sensor, time);
+CREATE TABLE IF NOT EXISTS channel_compressed (
+  id_sensor INTEGER NOT NULL, 
+  day DATE NOT NULL, 
+  row_count INTEGER NOT NULL, 
+  first_time TIMESTAMP NOT NULL, 
+  last_time TIMESTAMP NOT NULL, 
+  data BYTEA NOT NULL, 
+  compressed_at TIMESTAMP NOT NULL DEFAULT NOW(), 
+  PRIMARY KEY (id_sensor, day), 
+  FOREIGN KEY (id_sensor) REFERENCES sensor (id_sensor) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS sensor_compression (
+  id_sensor INTEGER PRIMARY KEY, 
+  after_days INTEGER NOT NULL, 
+  FOREIGN KEY (id_sensor) REFERENCES sensor (id_sensor) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS sensor_validation (
+  id_sensor INTEGER PRIMARY KEY, 
+  min_value FLOAT, 
+  max_value FLOAT, 
+  max_step FLOAT, 
+  action VARCHAR (16) NOT NULL DEFAULT 'flag', 
+  flagged BIGINT NOT NULL DEFAULT 0, 
+  rejected BIGINT NOT NULL DEFAULT 0, 
+  FOREIGN KEY (id_sensor) REFERENCES sensor (id_sensor) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS sensor_filter (
+  id_sensor INTEGER PRIMARY KEY, 
+  method VARCHAR (16) NOT NULL, 
+  window_size INTEGER NOT NULL, 
+  threshold FLOAT NOT NULL, 
+  FOREIGN KEY (id_sensor) REFERENCES sensor (id_sensor) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS sensor_throttle (
+  id_sensor INTEGER PRIMARY KEY, 
+  min_interval_ms INTEGER NOT NULL, 
+  mode VARCHAR (16) NOT NULL DEFAULT 'reject', 
+  throttled BIGINT NOT NULL DEFAULT 0, 
+  FOREIGN KEY (id_sensor) REFERENCES sensor (id_sensor) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS sensor_retention (
+  id_sensor INTEGER PRIMARY KEY, 
+  keep_days INTEGER NOT NULL, 
+  purged BIGINT NOT NULL DEFAULT 0, 
+  last_purged BIGINT NOT NULL DEFAULT 0, 
+  last_run_at TIMESTAMP, 
+  FOREIGN KEY (id_sensor) REFERENCES sensor (id_sensor) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS sensor_display (
+  id_sensor INTEGER NOT NULL, 
+  name VARCHAR (32) NOT NULL DEFAULT '', 
+  label VARCHAR (255) NOT NULL DEFAULT '', 
+  unit VARCHAR (32) NOT NULL DEFAULT '', 
+  decimals INTEGER, 
+  PRIMARY KEY (id_sensor, name), 
+  FOREIGN KEY (id_sensor) REFERENCES sensor (id_sensor) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS channel_archive (
+  id_sensor INTEGER NOT NULL, 
+  day DATE NOT NULL, 
+  object_key VARCHAR (255) NOT NULL, 
+  row_count INTEGER NOT NULL, 
+  first_time TIMESTAMP NOT NULL, 
+  last_time TIMESTAMP NOT NULL, 
+  archived_at TIMESTAMP NOT NULL DEFAULT NOW(), 
+  PRIMARY KEY (id_sensor, day), 
+  FOREIGN KEY (id_sensor) REFERENCES sensor (id_sensor) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS channel_rollup (
+  id_sensor INTEGER NOT NULL, 
+  resolution INTEGER NOT NULL, 
+  bucket TIMESTAMP NOT NULL, 
+  row_count BIGINT NOT NULL, 
+  avg_value FLOAT NOT NULL, 
+  min_value FLOAT NOT NULL, 
+  max_value FLOAT NOT NULL, 
+  last_value FLOAT NOT NULL, 
+  PRIMARY KEY (id_sensor, resolution, bucket), 
+  FOREIGN KEY (id_sensor) REFERENCES sensor (id_sensor) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS sensor_tag (
+  id_sensor INTEGER NOT NULL, 
+  tag VARCHAR (64) NOT NULL, 
+  PRIMARY KEY (id_sensor, tag), 
+  FOREIGN KEY (id_sensor) REFERENCES sensor (id_sensor) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS sensor_tag_tag_idx ON sensor_tag (tag);
+CREATE TABLE IF NOT EXISTS feature_flag (
+  name VARCHAR (255) PRIMARY KEY, 
+  enabled BOOLEAN NOT NULL DEFAULT FALSE
+);
+CREATE TABLE IF NOT EXISTS user_feature_flag (
+  id_user INTEGER NOT NULL, 
+  name VARCHAR (255) NOT NULL, 
+  enabled BOOLEAN NOT NULL DEFAULT FALSE, 
+  PRIMARY KEY (id_user, name), 
+  FOREIGN KEY (id_user) REFERENCES user_person (id_user) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS scheduled_job (
+  name VARCHAR (255) PRIMARY KEY, 
+  schedule VARCHAR (255) NOT NULL, 
+  running BOOLEAN NOT NULL DEFAULT FALSE, 
+  last_run_at TIMESTAMP, 
+  last_instance VARCHAR (255) NOT NULL DEFAULT '', 
+  last_duration_ms BIGINT NOT NULL DEFAULT 0, 
+  last_status VARCHAR (255) NOT NULL DEFAULT '', 
+  last_message TEXT NOT NULL DEFAULT '', 
+  next_run_at TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS dashboard_template (
+  id_template SERIAL PRIMARY KEY, 
+  name VARCHAR (255) NOT NULL, 
+  id_user INTEGER, 
+  id_hardware INTEGER, 
+  columns INTEGER NOT NULL DEFAULT 12, 
+  auto_apply BOOLEAN NOT NULL DEFAULT FALSE, 
+  widgets JSONB NOT NULL DEFAULT '[]', 
+  FOREIGN KEY (id_user) REFERENCES user_person (id_user) ON UPDATE CASCADE ON DELETE CASCADE, 
+  FOREIGN KEY (id_hardware) REFERENCES hardware (id_hardware) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS dashboard (
+  id_dashboard SERIAL PRIMARY KEY, 
+  name VARCHAR (255) NOT NULL, 
+  columns INTEGER NOT NULL DEFAULT 12, 
+  id_user INTEGER NOT NULL, 
+  public_token VARCHAR (255) UNIQUE, 
+  id_node INTEGER, 
+  id_template INTEGER, 
+  FOREIGN KEY (id_user) REFERENCES user_person (id_user) ON UPDATE CASCADE ON DELETE CASCADE, 
+  FOREIGN KEY (id_node) REFERENCES node (id_node) ON UPDATE CASCADE ON DELETE SET NULL, 
+  FOREIGN KEY (id_template) REFERENCES dashboard_template (id_template) ON UPDATE CASCADE ON DELETE SET NULL
+);
+CREATE TABLE IF NOT EXISTS dashboard_widget (
+  id_widget SERIAL PRIMARY KEY, 
+  id_dashboard INTEGER NOT NULL, 
+  type VARCHAR (255) NOT NULL, 
+  title VARCHAR (255) NOT NULL DEFAULT '', 
+  id_sensor INTEGER, 
+  x INTEGER NOT NULL DEFAULT 0, 
+  y INTEGER NOT NULL DEFAULT 0, 
+  width INTEGER NOT NULL DEFAULT 4, 
+  height INTEGER NOT NULL DEFAULT 2, 
+  options JSONB NOT NULL DEFAULT '{}', 
+  FOREIGN KEY (id_dashboard) REFERENCES dashboard (id_dashboard) ON UPDATE CASCADE ON DELETE CASCADE, 
+  FOREIGN KEY (id_sensor) REFERENCES sensor (id_sensor) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS notification (
+  id_notification SERIAL PRIMARY KEY, 
+  id_user INTEGER NOT NULL, 
+  type VARCHAR (16) NOT NULL, 
+  title VARCHAR (255) NOT NULL, 
+  message TEXT NOT NULL DEFAULT '', 
+  link VARCHAR (255), 
+  is_read BOOLEAN NOT NULL DEFAULT FALSE, 
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(), 
+  FOREIGN KEY (id_user) REFERENCES user_person (id_user) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS notification_id_user_created_at_idx ON notification (id_user, created_at);
+CREATE TABLE IF NOT EXISTS setting (
+  name VARCHAR (255) PRIMARY KEY, 
+  value TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS usage_counter (
+  id_user INTEGER NOT NULL, 
+  month DATE NOT NULL, 
+  metric VARCHAR (32) NOT NULL, 
+  value BIGINT NOT NULL DEFAULT 0, 
+  PRIMARY KEY (id_user, month, metric), 
+  FOREIGN KEY (id_user) REFERENCES user_person (id_user) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS storage_policy (
+  id_policy SERIAL PRIMARY KEY, 
+  name VARCHAR (255) NOT NULL, 
+  id_sensor INTEGER, 
+  tag VARCHAR (64), 
+  raw_days INTEGER NOT NULL, 
+  rollup_seconds INTEGER NOT NULL DEFAULT 0, 
+  rollup_days INTEGER NOT NULL DEFAULT 0, 
+  CHECK ((id_sensor IS NULL) <> (tag IS NULL)), 
+  FOREIGN KEY (id_sensor) REFERENCES sensor (id_sensor) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS storage_policy_run (
+  id_run SERIAL PRIMARY KEY, 
+  id_policy INTEGER NOT NULL, 
+  started_at TIMESTAMP NOT NULL, 
+  finished_at TIMESTAMP NOT NULL, 
+  sensors INTEGER NOT NULL DEFAULT 0, 
+  rolled_up BIGINT NOT NULL DEFAULT 0, 
+  raw_deleted BIGINT NOT NULL DEFAULT 0, 
+  rollup_deleted BIGINT NOT NULL DEFAULT 0, 
+  status VARCHAR (16) NOT NULL, 
+  message TEXT NOT NULL DEFAULT '', 
+  FOREIGN KEY (id_policy) REFERENCES storage_policy (id_policy) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS storage_policy_run_id_policy_idx ON storage_policy_run (id_policy, started_at);
+CREATE TABLE IF NOT EXISTS webhook (
+  id_webhook SERIAL PRIMARY KEY, 
+  id_user INTEGER NOT NULL, 
+  url VARCHAR (2048) NOT NULL, 
+  secret VARCHAR (64) NOT NULL, 
+  events VARCHAR (64)[] NOT NULL, 
+  is_active BOOLEAN NOT NULL DEFAULT TRUE, 
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(), 
+  FOREIGN KEY (id_user) REFERENCES user_person (id_user) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS webhook_delivery (
+  id_delivery BIGSERIAL PRIMARY KEY, 
+  id_webhook INTEGER NOT NULL, 
+  event VARCHAR (64) NOT NULL, 
+  payload TEXT NOT NULL, 
+  status VARCHAR (16) NOT NULL DEFAULT 'pending', 
+  attempts INTEGER NOT NULL DEFAULT 0, 
+  next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(), 
+  response_code INTEGER, 
+  last_error TEXT NOT NULL DEFAULT '', 
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(), 
+  delivered_at TIMESTAMP, 
+  FOREIGN KEY (id_webhook) REFERENCES webhook (id_webhook) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS webhook_delivery_pending_idx ON webhook_delivery (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_delivery_id_webhook_idx ON webhook_delivery (id_webhook, created_at);
+CREATE TABLE IF NOT EXISTS entity_revision (
+  id_revision BIGSERIAL PRIMARY KEY, 
+  entity_type VARCHAR (16) NOT NULL, 
+  id_entity INTEGER NOT NULL, 
+  version INTEGER NOT NULL, 
+  action VARCHAR (16) NOT NULL, 
+  id_user INTEGER, 
+  changed_at TIMESTAMP NOT NULL DEFAULT NOW(), 
+  data JSONB NOT NULL, 
+  changes JSONB NOT NULL DEFAULT '{}', 
+  UNIQUE (entity_type, id_entity, version), 
+  FOREIGN KEY (id_user) REFERENCES user_person (id_user) ON UPDATE CASCADE ON DELETE SET NULL
+);
+CREATE TABLE IF NOT EXISTS node_transfer (
+  id_transfer SERIAL PRIMARY KEY, 
+  id_node INTEGER NOT NULL, 
+  id_from_user INTEGER NOT NULL, 
+  id_to_user INTEGER NOT NULL, 
+  status VARCHAR (16) NOT NULL DEFAULT 'pending', 
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(), 
+  responded_at TIMESTAMP, 
+  FOREIGN KEY (id_node) REFERENCES node (id_node) ON UPDATE CASCADE ON DELETE CASCADE, 
+  FOREIGN KEY (id_from_user) REFERENCES user_person (id_user) ON UPDATE CASCADE ON DELETE CASCADE, 
+  FOREIGN KEY (id_to_user) REFERENCES user_person (id_user) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS kpi (
+  id_kpi SERIAL PRIMARY KEY, 
+  id_sensor INTEGER NOT NULL, 
+  name VARCHAR (64) NOT NULL, 
+  function VARCHAR (32) NOT NULL, 
+  window_seconds INTEGER NOT NULL DEFAULT 86400, 
+  base_value FLOAT, 
+  scale FLOAT NOT NULL DEFAULT 1, 
+  unit VARCHAR (32) NOT NULL DEFAULT '', 
+  computed_until TIMESTAMP, 
+  FOREIGN KEY (id_sensor) REFERENCES sensor (id_sensor) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS kpi_value (
+  id_kpi INTEGER NOT NULL, 
+  bucket TIMESTAMP NOT NULL, 
+  value FLOAT NOT NULL, 
+  PRIMARY KEY (id_kpi, bucket), 
+  FOREIGN KEY (id_kpi) REFERENCES kpi (id_kpi) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS node_maintenance (
+  id_maintenance SERIAL PRIMARY KEY, 
+  id_node INTEGER NOT NULL, 
+  start_time TIMESTAMP NOT NULL, 
+  end_time TIMESTAMP NOT NULL, 
+  reason VARCHAR (255) NOT NULL DEFAULT '', 
+  FOREIGN KEY (id_node) REFERENCES node (id_node) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS ingest_stat (
+  id_sensor INTEGER NOT NULL, 
+  bucket TIMESTAMP NOT NULL, 
+  accepted BIGINT NOT NULL DEFAULT 0, 
+  rejected BIGINT NOT NULL DEFAULT 0, 
+  bytes BIGINT NOT NULL DEFAULT 0, 
+  PRIMARY KEY (id_sensor, bucket), 
+  FOREIGN KEY (id_sensor) REFERENCES sensor (id_sensor) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS sensor_ingest (
+  id_sensor INTEGER PRIMARY KEY, 
+  last_received_at TIMESTAMP, 
+  last_error_at TIMESTAMP, 
+  last_error_status INTEGER, 
+  last_error_message TEXT, 
+  FOREIGN KEY (id_sensor) REFERENCES sensor (id_sensor) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS report (
+  id_report SERIAL PRIMARY KEY, 
+  id_user INTEGER NOT NULL, 
+  name VARCHAR (255) NOT NULL, 
+  time_range VARCHAR (16) NOT NULL DEFAULT '', 
+  schedule VARCHAR (16) NOT NULL DEFAULT '', 
+  format VARCHAR (8) NOT NULL DEFAULT '', 
+  sections JSONB NOT NULL DEFAULT '[]', 
+  last_sent TIMESTAMP, 
+  FOREIGN KEY (id_user) REFERENCES user_person (id_user) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS hardware_decoder (
+  id_hardware INTEGER PRIMARY KEY, 
+  fields JSONB NOT NULL DEFAULT '[]', 
+  plugin VARCHAR (64) NOT NULL DEFAULT '', 
+  FOREIGN KEY (id_hardware) REFERENCES hardware (id_hardware) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS node_sigfox (
+  device_id VARCHAR (8) PRIMARY KEY, 
+  id_node INTEGER NOT NULL UNIQUE, 
+  FOREIGN KEY (id_node) REFERENCES node (id_node) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS sensor_opcua (
+  id_item SERIAL PRIMARY KEY, 
+  id_sensor INTEGER NOT NULL, 
+  name VARCHAR (32) NOT NULL DEFAULT '', 
+  node_id VARCHAR (255) NOT NULL, 
+  status VARCHAR (255) NOT NULL DEFAULT 'pending', 
+  UNIQUE (id_sensor, name), 
+  FOREIGN KEY (id_sensor) REFERENCES sensor (id_sensor) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS sensor_bacnet (
+  id_item SERIAL PRIMARY KEY, 
+  id_sensor INTEGER NOT NULL, 
+  name VARCHAR (32) NOT NULL DEFAULT '', 
+  address VARCHAR (255) NOT NULL, 
+  object VARCHAR (64) NOT NULL, 
+  property VARCHAR (64) NOT NULL DEFAULT 'present-value', 
+  status VARCHAR (255) NOT NULL DEFAULT 'pending', 
+  UNIQUE (id_sensor, name), 
+  FOREIGN KEY (id_sensor) REFERENCES sensor (id_sensor) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS sensor_snmp (
+  id_sensor INTEGER PRIMARY KEY, 
+  address VARCHAR (255) NOT NULL, 
+  version VARCHAR (2) NOT NULL, 
+  community VARCHAR (64) NOT NULL DEFAULT '', 
+  username VARCHAR (32) NOT NULL DEFAULT '', 
+  auth_protocol VARCHAR (8) NOT NULL DEFAULT '', 
+  auth_password VARCHAR (64) NOT NULL DEFAULT '', 
+  priv_protocol VARCHAR (8) NOT NULL DEFAULT '', 
+  priv_password VARCHAR (64) NOT NULL DEFAULT '', 
+  FOREIGN KEY (id_sensor) REFERENCES sensor (id_sensor) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS sensor_snmp_item (
+  id_item SERIAL PRIMARY KEY, 
+  id_sensor INTEGER NOT NULL, 
+  name VARCHAR (32) NOT NULL DEFAULT '', 
+  oid VARCHAR (255) NOT NULL, 
+  status VARCHAR (255) NOT NULL DEFAULT 'pending', 
+  UNIQUE (id_sensor, name), 
+  FOREIGN KEY (id_sensor) REFERENCES sensor_snmp (id_sensor) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS node_weather (
+  id_item SERIAL PRIMARY KEY, 
+  id_node INTEGER NOT NULL, 
+  variable VARCHAR (16) NOT NULL, 
+  id_sensor INTEGER NOT NULL UNIQUE, 
+  status VARCHAR (255) NOT NULL DEFAULT 'pending', 
+  UNIQUE (id_node, variable), 
+  FOREIGN KEY (id_node) REFERENCES node (id_node) ON UPDATE CASCADE ON DELETE CASCADE, 
+  FOREIGN KEY (id_sensor) REFERENCES sensor (id_sensor) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS sensor_transform (
+  id_sensor INTEGER PRIMARY KEY, 
+  steps JSONB NOT NULL DEFAULT '[]', 
+  FOREIGN KEY (id_sensor) REFERENCES sensor (id_sensor) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS script (
+  id_script SERIAL PRIMARY KEY, 
+  id_user INTEGER NOT NULL, 
+  name VARCHAR (64) NOT NULL, 
+  event VARCHAR (16) NOT NULL, 
+  id_sensor INTEGER, 
+  source TEXT NOT NULL, 
+  is_active BOOLEAN NOT NULL DEFAULT TRUE, 
+  last_error TEXT, 
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(), 
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW(), 
+  FOREIGN KEY (id_user) REFERENCES user_person (id_user) ON UPDATE CASCADE ON DELETE CASCADE, 
+  FOREIGN KEY (id_sensor) REFERENCES sensor (id_sensor) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS node_status_page (
+  id_node INTEGER PRIMARY KEY, 
+  token VARCHAR (64) NOT NULL UNIQUE, 
+  id_sensors INTEGER[] NOT NULL DEFAULT '{}', 
+  FOREIGN KEY (id_node) REFERENCES node (id_node) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS sync_state (
+  site VARCHAR (64) PRIMARY KEY, 
+  cursor_time TIMESTAMP, 
+  last_attempt_at TIMESTAMP, 
+  last_success_at TIMESTAMP, 
+  last_error TEXT, 
+  pushed BIGINT NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS sync_site (
+  site VARCHAR (64) PRIMARY KEY, 
+  id_user INTEGER NOT NULL, 
+  conflict VARCHAR (16) NOT NULL DEFAULT 'edge', 
+  last_sync_at TIMESTAMP, 
+  channels BIGINT NOT NULL DEFAULT 0, 
+  FOREIGN KEY (id_user) REFERENCES user_person (id_user) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS sync_mapping (
+  site VARCHAR (64) NOT NULL, 
+  entity VARCHAR (16) NOT NULL, 
+  local_id INTEGER NOT NULL, 
+  remote_id INTEGER NOT NULL, 
+  last_time TIMESTAMP, 
+  PRIMARY KEY (site, entity, local_id), 
+  FOREIGN KEY (site) REFERENCES sync_site (site) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS channel_checksum (
+  id_sensor INTEGER NOT NULL, 
+  day DATE NOT NULL, 
+  row_count BIGINT NOT NULL, 
+  checksum VARCHAR (16) NOT NULL, 
+  computed_at TIMESTAMP NOT NULL DEFAULT NOW(), 
+  verified_at TIMESTAMP NOT NULL DEFAULT NOW(), 
+  status VARCHAR (16) NOT NULL DEFAULT 'ok', 
+  actual_row_count BIGINT, 
+  actual_checksum VARCHAR (16), 
+  PRIMARY KEY (id_sensor, day), 
+  FOREIGN KEY (id_sensor) REFERENCES sensor (id_sensor) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS channel_checksum_verified_at_idx ON channel_checksum (verified_at);
+CREATE TABLE IF NOT EXISTS node_api_key (
+  id_key SERIAL PRIMARY KEY, 
+  id_node INTEGER NOT NULL, 
+  name VARCHAR (64) NOT NULL DEFAULT '', 
+  prefix VARCHAR (16) NOT NULL, 
+  key_hash VARCHAR (64) NOT NULL UNIQUE, 
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(), 
+  last_used_at TIMESTAMP, 
+  FOREIGN KEY (id_node) REFERENCES node (id_node) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS alert_rule (
+  id_rule SERIAL PRIMARY KEY, 
+  id_sensor INTEGER NOT NULL, 
+  name VARCHAR (32), 
+  operator VARCHAR (2) NOT NULL, 
+  threshold DOUBLE PRECISION NOT NULL, 
+  duration_seconds INTEGER NOT NULL DEFAULT 0, 
+  title VARCHAR (255) NOT NULL DEFAULT '', 
+  enabled BOOLEAN NOT NULL DEFAULT TRUE, 
+  pending_since TIMESTAMP, 
+  FOREIGN KEY (id_sensor) REFERENCES sensor (id_sensor) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS alert (
+  id_alert SERIAL PRIMARY KEY, 
+  id_rule INTEGER NOT NULL, 
+  value DOUBLE PRECISION NOT NULL, 
+  fired_at TIMESTAMP NOT NULL DEFAULT NOW(), 
+  resolved_at TIMESTAMP, 
+  acknowledged_at TIMESTAMP, 
+  FOREIGN KEY (id_rule) REFERENCES alert_rule (id_rule) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS alert_active_idx ON alert (id_rule) WHERE resolved_at IS NULL;
+CREATE TABLE IF NOT EXISTS alert_target (
+  id_target SERIAL PRIMARY KEY, 
+  id_user INTEGER NOT NULL, 
+  type VARCHAR (16) NOT NULL, 
+  address VARCHAR (2048) NOT NULL, 
+  secret VARCHAR (64) NOT NULL DEFAULT '', 
+  enabled BOOLEAN NOT NULL DEFAULT TRUE, 
+  last_error TEXT, 
+  FOREIGN KEY (id_user) REFERENCES user_person (id_user) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS node_share (
+  id_node INTEGER NOT NULL, 
+  id_user INTEGER NOT NULL, 
+  permission VARCHAR (8) NOT NULL, 
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(), 
+  PRIMARY KEY (id_node, id_user), 
+  FOREIGN KEY (id_node) REFERENCES node (id_node) ON UPDATE CASCADE ON DELETE CASCADE, 
+  FOREIGN KEY (id_user) REFERENCES user_person (id_user) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS node_share_id_user_idx ON node_share (id_user);
+CREATE TABLE IF NOT EXISTS channel_aggregate (
+  id_sensor INTEGER NOT NULL, 
+  resolution INTEGER NOT NULL, 
+  bucket TIMESTAMP NOT NULL, 
+  row_count BIGINT NOT NULL, 
+  sum_value FLOAT NOT NULL, 
+  min_value FLOAT NOT NULL, 
+  max_value FLOAT NOT NULL, 
+  last_value FLOAT NOT NULL, 
+  last_time TIMESTAMP NOT NULL, 
+  PRIMARY KEY (id_sensor, resolution, bucket), 
+  FOREIGN KEY (id_sensor) REFERENCES sensor (id_sensor) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS channel_aggregate_state (
+  id_sensor INTEGER NOT NULL, 
+  resolution INTEGER NOT NULL, 
+  refreshed_until TIMESTAMP NOT NULL, 
+  PRIMARY KEY (id_sensor, resolution), 
+  FOREIGN KEY (id_sensor) REFERENCES sensor (id_sensor) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS user_session (
+  id_session VARCHAR (36) PRIMARY KEY, 
+  id_user INTEGER NOT NULL, 
+  refresh_hash VARCHAR (64) NOT NULL UNIQUE, 
+  user_agent VARCHAR (255) NOT NULL DEFAULT '', 
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(), 
+  last_used_at TIMESTAMP NOT NULL DEFAULT NOW(), 
+  FOREIGN KEY (id_user) REFERENCES user_person (id_user) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS user_session_id_user_idx ON user_session (id_user);
+CREATE TABLE IF NOT EXISTS firmware (
+  id_firmware SERIAL PRIMARY KEY, 
+  id_hardware INTEGER NOT NULL, 
+  id_user INTEGER NOT NULL, 
+  version VARCHAR (64) NOT NULL, 
+  notes VARCHAR (255) NOT NULL DEFAULT '', 
+  size BIGINT NOT NULL, 
+  sha256 VARCHAR (64) NOT NULL, 
+  storage VARCHAR (8) NOT NULL, 
+  object_key VARCHAR (255) NOT NULL, 
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(), 
+  UNIQUE (id_user, id_hardware, version), 
+  FOREIGN KEY (id_hardware) REFERENCES hardware (id_hardware) ON UPDATE CASCADE ON DELETE CASCADE, 
+  FOREIGN KEY (id_user) REFERENCES user_person (id_user) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS node_firmware (
+  id_node INTEGER PRIMARY KEY, 
+  id_firmware INTEGER, 
+  current_version VARCHAR (64), 
+  reported_at TIMESTAMP, 
+  assigned_at TIMESTAMP, 
+  FOREIGN KEY (id_node) REFERENCES node (id_node) ON UPDATE CASCADE ON DELETE CASCADE, 
+  FOREIGN KEY (id_firmware) REFERENCES firmware (id_firmware) ON UPDATE CASCADE ON DELETE SET NULL
+);
@@ -0,0 +1,20 @@
+ALTER TABLE IF EXISTS scheduled_job ADD COLUMN IF NOT EXISTS last_instance VARCHAR (255) NOT NULL DEFAULT '';
+ALTER TABLE IF EXISTS dashboard ADD COLUMN IF NOT EXISTS public_token VARCHAR (255) UNIQUE;
+ALTER TABLE IF EXISTS dashboard ADD COLUMN IF NOT EXISTS id_node INTEGER REFERENCES node (id_node) ON UPDATE CASCADE ON DELETE SET NULL;
+ALTER TABLE IF EXISTS dashboard ADD COLUMN IF NOT EXISTS id_template INTEGER REFERENCES dashboard_template (id_template) ON UPDATE CASCADE ON DELETE SET NULL;
+ALTER TABLE IF EXISTS user_person ADD COLUMN IF NOT EXISTS theme VARCHAR (16) NOT NULL DEFAULT 'system';
+ALTER TABLE IF EXISTS user_person ADD COLUMN IF NOT EXISTS language VARCHAR (8);
+ALTER TABLE IF EXISTS user_person ADD COLUMN IF NOT EXISTS digest BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE IF EXISTS sensor ADD COLUMN IF NOT EXISTS embed_token VARCHAR (64) UNIQUE;
+ALTER TABLE IF EXISTS channel ADD COLUMN IF NOT EXISTS quality VARCHAR (16) NOT NULL DEFAULT 'good';
+ALTER TABLE IF EXISTS channel ADD COLUMN IF NOT EXISTS filtered_value FLOAT;
+ALTER TABLE IF EXISTS channel ADD COLUMN IF NOT EXISTS name VARCHAR (32) NOT NULL DEFAULT '';
+ALTER TABLE IF EXISTS hardware_decoder ADD COLUMN IF NOT EXISTS plugin VARCHAR (64) NOT NULL DEFAULT '';
+ALTER TABLE IF EXISTS node ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE IF EXISTS sensor ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE IF EXISTS node ADD COLUMN IF NOT EXISTS last_seen TIMESTAMP;
+ALTER TABLE IF EXISTS node ADD COLUMN IF NOT EXISTS online BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE IF EXISTS sensor ADD COLUMN IF NOT EXISTS min_value FLOAT;
+ALTER TABLE IF EXISTS sensor ADD COLUMN IF NOT EXISTS max_value FLOAT;
+ALTER TABLE IF EXISTS sensor ADD COLUMN IF NOT EXISTS calibration_scale FLOAT NOT NULL DEFAULT 1;
+ALTER TABLE IF EXISTS sensor ADD COLUMN IF NOT EXISTS calibration_offset FLOAT NOT NULL DEFAULT 0;
@@ -0,0 +1,40 @@
+package database
+
+import (
+	"context"
+	"log"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// UpgradeTable bring a database created by an older version to the current schema without touching
+// its data. The missing table of table.sql is created, then the column added to an existing table
+// since is added by upgrade.sql. Both are idempotent so it is run at every startup, a lock keep two
+// instance starting together from upgrading at the same time
+func UpgradeTable(ctx context.Context, db *pgxpool.Pool) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('schema_upgrade'))`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, openSqlFile(TABLE))
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, openSqlFile(UPGRADE))
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+	log.Println("Database schema is up to date")
+	return nil
+}
@@ -0,0 +1,366 @@
+package dependencies
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/redis/go-redis/v9"
+)
+
+// ClusterStore is a key value store shared by every instance of the server
+type ClusterStore interface {
+	// Incr increase the counter by one and set the expiry if the key is new, then return the new value
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	// TTL return the remaining time to live of the key, zero if the key not exist
+	TTL(ctx context.Context, key string) (time.Duration, error)
+	// Take a token of the bucket refilled with rate token per second up to burst, a new bucket is full
+	Take(ctx context.Context, key string, rate float64, burst int64) (Bucket, error)
+	Get(ctx context.Context, key string) (value string, found bool, err error)
+	Set(ctx context.Context, key string, value string, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+// ClusterPubSub broadcast message to every instance of the server, including the publisher
+type ClusterPubSub interface {
+	Publish(ctx context.Context, channel string, payload []byte) error
+	Subscribe(ctx context.Context, channel string, handler func(payload []byte)) error
+}
+
+// Bucket is the token bucket after a Take, RetryAfter is the wait until the next token when it isn't allowed
+type Bucket struct {
+	Allowed    bool
+	Remaining  int64
+	RetryAfter time.Duration
+}
+
+type Cluster struct {
+	InstanceId string
+	Store      ClusterStore
+	PubSub     ClusterPubSub
+}
+
+// NewCluster use redis when cluster.redisUrl is set, otherwise fallback to in-process store
+// which is only safe when running a single instance.
+func NewCluster(config *configs.Config) (Cluster, error) {
+	instanceId := config.Cluster.InstanceId
+	if instanceId == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return Cluster{}, err
+		}
+		instanceId = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+
+	if config.Cluster.RedisUrl == "" {
+		log.Println("Cluster redis is not configured, using in-process store (single instance only)")
+		return Cluster{
+			InstanceId: instanceId,
+			Store:      newMemoryStore(),
+			PubSub:     newMemoryPubSub(),
+		}, nil
+	}
+
+	options, err := redis.ParseURL(config.Cluster.RedisUrl)
+	if err != nil {
+		return Cluster{}, fmt.Errorf("error parsing cluster redis url %w", err)
+	}
+	client := redis.NewClient(options)
+	err = client.Ping(context.Background()).Err()
+	if err != nil {
+		return Cluster{}, fmt.Errorf("error connecting to cluster redis %w", err)
+	}
+	log.Println("Connected to cluster redis")
+
+	return Cluster{
+		InstanceId: instanceId,
+		Store:      &redisStore{client: client, prefix: config.Cluster.KeyPrefix},
+		PubSub:     &redisPubSub{client: client, prefix: config.Cluster.KeyPrefix},
+	}, nil
+}
+
+type redisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// incrScript increase the counter and set its expiry in one step, so a counter is never left without
+// expiry when the server crash between the two command
+var incrScript = redis.NewScript(`
+local value = redis.call("INCR", KEYS[1])
+if value == 1 and tonumber(ARGV[1]) > 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return value
+`)
+
+func (r *redisStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return incrScript.Run(ctx, r.client, []string{r.prefix + key}, ttl.Milliseconds()).Int64()
+}
+
+// takeScript refill and take the bucket in one step with the redis clock, so every instance see the
+// same bucket. The bucket expire once it would be full again
+var takeScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "at")
+local tokens = tonumber(bucket[1]) or burst
+local at = tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - at) * rate / 1000)
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) / rate * 1000)
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "at", tostring(now))
+redis.call("PEXPIRE", KEYS[1], math.ceil((burst - tokens) / rate * 1000) + 1)
+return {allowed, math.floor(tokens), wait}
+`)
+
+func (r *redisStore) Take(ctx context.Context, key string, rate float64, burst int64) (Bucket, error) {
+	values, err := takeScript.Run(ctx, r.client, []string{r.prefix + key}, rate, burst).Int64Slice()
+	if err != nil {
+		return Bucket{}, err
+	}
+	return Bucket{
+		Allowed:    values[0] == 1,
+		Remaining:  values[1],
+		RetryAfter: time.Duration(values[2]) * time.Millisecond,
+	}, nil
+}
+
+func (r *redisStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := r.client.PTTL(ctx, r.prefix+key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+func (r *redisStore) Get(ctx context.Context, key string) (string, bool, error) {
+	value, err := r.client.Get(ctx, r.prefix+key).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+func (r *redisStore) Set(ctx context.Context, key string, value string, ttl time.Duration) error {
+	return r.client.Set(ctx, r.prefix+key, value, ttl).Err()
+}
+
+func (r *redisStore) Delete(ctx context.Context, key string) error {
+	return r.client.Del(ctx, r.prefix+key).Err()
+}
+
+type redisPubSub struct {
+	client *redis.Client
+	prefix string
+}
+
+func (r *redisPubSub) Publish(ctx context.Context, channel string, payload []byte) error {
+	return r.client.Publish(ctx, r.prefix+channel, payload).Err()
+}
+
+func (r *redisPubSub) Subscribe(ctx context.Context, channel string, handler func(payload []byte)) error {
+	subscription := r.client.Subscribe(ctx, r.prefix+channel)
+	_, err := subscription.Receive(ctx)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		defer subscription.Close()
+		messages := subscription.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case message, ok := <-messages:
+				if !ok {
+					return
+				}
+				handler([]byte(message.Payload))
+			}
+		}
+	}()
+	return nil
+}
+
+type memoryItem struct {
+	value     string
+	counter   int64
+	tokens    float64
+	updatedAt time.Time
+	expiredAt time.Time
+}
+
+type memoryStore struct {
+	mutex sync.Mutex
+	items map[string]*memoryItem
+}
+
+func newMemoryStore() *memoryStore {
+	store := &memoryStore{items: map[string]*memoryItem{}}
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for range ticker.C {
+			store.cleanup()
+		}
+	}()
+	return store
+}
+
+func (m *memoryStore) cleanup() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := time.Now()
+	for key, item := range m.items {
+		if !item.expiredAt.IsZero() && now.After(item.expiredAt) {
+			delete(m.items, key)
+		}
+	}
+}
+
+// Get the item if not expired, must be called while holding the lock
+func (m *memoryStore) get(key string) (*memoryItem, bool) {
+	item, ok := m.items[key]
+	if !ok {
+		return nil, false
+	}
+	if !item.expiredAt.IsZero() && time.Now().After(item.expiredAt) {
+		delete(m.items, key)
+		return nil, false
+	}
+	return item, true
+}
+
+func (m *memoryStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	item, ok := m.get(key)
+	if !ok {
+		item = &memoryItem{}
+		if ttl > 0 {
+			item.expiredAt = time.Now().Add(ttl)
+		}
+		m.items[key] = item
+	}
+	item.counter++
+	item.value = fmt.Sprint(item.counter)
+	return item.counter, nil
+}
+
+func (m *memoryStore) Take(ctx context.Context, key string, rate float64, burst int64) (Bucket, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	item, ok := m.get(key)
+	if !ok {
+		item = &memoryItem{tokens: float64(burst), updatedAt: now}
+		m.items[key] = item
+	}
+	tokens := math.Min(float64(burst), item.tokens+now.Sub(item.updatedAt).Seconds()*rate)
+	bucket := Bucket{}
+	if tokens >= 1 {
+		tokens--
+		bucket.Allowed = true
+	} else {
+		bucket.RetryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+	bucket.Remaining = int64(tokens)
+
+	// The bucket is forgotten once it is full again, like a new bucket
+	item.tokens = tokens
+	item.updatedAt = now
+	item.expiredAt = now.Add(time.Duration((float64(burst)-tokens)/rate*float64(time.Second)) + time.Millisecond)
+	return bucket, nil
+}
+
+func (m *memoryStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	item, ok := m.get(key)
+	if !ok || item.expiredAt.IsZero() {
+		return 0, nil
+	}
+	return time.Until(item.expiredAt), nil
+}
+
+func (m *memoryStore) Get(ctx context.Context, key string) (string, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	item, ok := m.get(key)
+	if !ok {
+		return "", false, nil
+	}
+	return item.value, true, nil
+}
+
+func (m *memoryStore) Set(ctx context.Context, key string, value string, ttl time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	item := &memoryItem{value: value}
+	if ttl > 0 {
+		item.expiredAt = time.Now().Add(ttl)
+	}
+	m.items[key] = item
+	return nil
+}
+
+func (m *memoryStore) Delete(ctx context.Context, key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.items, key)
+	return nil
+}
+
+type memoryPubSub struct {
+	mutex    sync.RWMutex
+	handlers map[string][]func(payload []byte)
+}
+
+func newMemoryPubSub() *memoryPubSub {
+	return &memoryPubSub{handlers: map[string][]func(payload []byte){}}
+}
+
+func (m *memoryPubSub) Publish(ctx context.Context, channel string, payload []byte) error {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	for _, handler := range m.handlers[channel] {
+		handler(payload)
+	}
+	return nil
+}
+
+func (m *memoryPubSub) Subscribe(ctx context.Context, channel string, handler func(payload []byte)) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.handlers[channel] = append(m.handlers[channel], handler)
+	return nil
+}
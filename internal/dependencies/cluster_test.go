@@ -0,0 +1,87 @@
+package dependencies
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreTake(t *testing.T) {
+	store := newMemoryStore()
+	ctx := context.Background()
+
+	// A new bucket is full, the burst is allowed at once
+	for i := 2; i >= 0; i-- {
+		bucket, err := store.Take(ctx, "client", 1, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bucket.Allowed || bucket.Remaining != int64(i) {
+			t.Fatalf("got %+v, want allowed with %d remaining", bucket, i)
+		}
+	}
+	bucket, _ := store.Take(ctx, "client", 1, 3)
+	if bucket.Allowed || bucket.Remaining != 0 || bucket.RetryAfter <= 900*time.Millisecond || bucket.RetryAfter > time.Second {
+		t.Fatalf("got %+v, want refused until the next token", bucket)
+	}
+
+	// Another client has its own bucket
+	bucket, _ = store.Take(ctx, "other", 1, 3)
+	if !bucket.Allowed {
+		t.Fatalf("got %+v, want allowed", bucket)
+	}
+
+	// The bucket is refilled with rate token per second, never over the burst
+	store.items["client"].updatedAt = time.Now().Add(-2 * time.Second)
+	for i := 0; i < 2; i++ {
+		bucket, _ = store.Take(ctx, "client", 1, 3)
+		if !bucket.Allowed {
+			t.Fatalf("token %d got %+v, want allowed", i, bucket)
+		}
+	}
+	bucket, _ = store.Take(ctx, "client", 1, 3)
+	if bucket.Allowed {
+		t.Fatalf("got %+v, want refused", bucket)
+	}
+	store.items["client"].updatedAt = time.Now().Add(-time.Hour)
+	store.items["client"].expiredAt = time.Now().Add(time.Hour)
+	bucket, _ = store.Take(ctx, "client", 1, 3)
+	if !bucket.Allowed || bucket.Remaining != 2 {
+		t.Fatalf("got %+v, want a full bucket", bucket)
+	}
+}
+
+func TestMemoryStoreTakeExpire(t *testing.T) {
+	store := newMemoryStore()
+	ctx := context.Background()
+	_, err := store.Take(ctx, "client", 1000, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The bucket is forgotten once it would be full again
+	item := store.items["client"]
+	if wait := time.Until(item.expiredAt); wait <= 0 || wait > 5*time.Millisecond {
+		t.Fatalf("the bucket expire in %v, want about 1ms", wait)
+	}
+	time.Sleep(5 * time.Millisecond)
+	store.cleanup()
+	if _, ok := store.items["client"]; ok {
+		t.Fatal("a full bucket should be removed")
+	}
+}
+
+func TestMemoryStoreIncr(t *testing.T) {
+	store := newMemoryStore()
+	ctx := context.Background()
+	for want := int64(1); want <= 3; want++ {
+		value, err := store.Incr(ctx, "counter", time.Minute)
+		if err != nil || value != want {
+			t.Fatalf("got %d %v, want %d", value, err, want)
+		}
+	}
+	ttl, _ := store.TTL(ctx, "counter")
+	if ttl <= 59*time.Second || ttl > time.Minute {
+		t.Fatalf("ttl is %v, want the first expiry", ttl)
+	}
+}
@@ -0,0 +1,146 @@
+package dependencies
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+)
+
+// LoginLimiter protect the login from credential stuffing. Every attempt of an IP is rate limited
+// per minute, and a username or an IP with too many failed login is locked for a while. The counter
+// is kept in the cluster store, so it is shared by every instance when redis is configured
+type LoginLimiter struct {
+	store         ClusterStore
+	ipRateLimit   int64
+	maxFailures   int64
+	ipMaxFailures int64
+	lock          time.Duration
+}
+
+func NewLoginLimiter(cluster *Cluster, config *configs.Config) (LoginLimiter, error) {
+	ipRateLimit := config.Login.IpRateLimit
+	if ipRateLimit <= 0 {
+		ipRateLimit = 20
+	}
+	maxFailures := config.Login.MaxFailures
+	if maxFailures <= 0 {
+		maxFailures = 5
+	}
+	ipMaxFailures := config.Login.IpMaxFailures
+	if ipMaxFailures <= 0 {
+		ipMaxFailures = 50
+	}
+	lockMinutes := config.Login.LockMinutes
+	if lockMinutes <= 0 {
+		lockMinutes = 15
+	}
+
+	return LoginLimiter{
+		store:         cluster.Store,
+		ipRateLimit:   int64(ipRateLimit),
+		maxFailures:   int64(maxFailures),
+		ipMaxFailures: int64(ipMaxFailures),
+		lock:          time.Duration(lockMinutes) * time.Minute,
+	}, nil
+}
+
+// The username is matched case insensitive so the lock can't be avoided by changing the case
+func loginUserKey(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// lockedFor return the remaining lock of the key, zero when it isn't locked
+func (l *LoginLimiter) lockedFor(ctx context.Context, key string) (time.Duration, error) {
+	_, found, err := l.store.Get(ctx, key)
+	if err != nil || !found {
+		return 0, err
+	}
+	ttl, err := l.store.TTL(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	if ttl <= 0 {
+		// The lock expired between the two read
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+// Allow count the attempt of the IP and return how long the client must wait when the IP is over
+// its rate limit or the IP or username is locked, zero when the attempt is allowed
+func (l *LoginLimiter) Allow(ctx context.Context, ip string, username string) (retryAfter time.Duration, err error) {
+	rateKey := "login:limit:" + ip
+	count, err := l.store.Incr(ctx, rateKey, time.Minute)
+	if err != nil {
+		return 0, err
+	}
+	if count > l.ipRateLimit {
+		ttl, err := l.store.TTL(ctx, rateKey)
+		if err != nil || ttl <= 0 {
+			ttl = time.Minute
+		}
+		return ttl, nil
+	}
+
+	retryAfter, err = l.lockedFor(ctx, "login:lock:ip:"+ip)
+	if err != nil || retryAfter > 0 {
+		return retryAfter, err
+	}
+	return l.lockedFor(ctx, "login:lock:user:"+loginUserKey(username))
+}
+
+// Fail count a failed login of the username from the IP, and lock the one reaching its limit. The
+// failure is counted over the lock duration, so a slow guessing is also locked
+func (l *LoginLimiter) Fail(ctx context.Context, ip string, username string) (locked bool, err error) {
+	user := loginUserKey(username)
+	count, err := l.store.Incr(ctx, "login:fail:user:"+user, l.lock)
+	if err != nil {
+		return false, err
+	}
+	if count >= l.maxFailures {
+		err = l.store.Set(ctx, "login:lock:user:"+user, "1", l.lock)
+		if err != nil {
+			return false, err
+		}
+		err = l.store.Delete(ctx, "login:fail:user:"+user)
+		if err != nil {
+			return false, err
+		}
+		locked = true
+	}
+
+	count, err = l.store.Incr(ctx, "login:fail:ip:"+ip, l.lock)
+	if err != nil {
+		return locked, err
+	}
+	if count >= l.ipMaxFailures {
+		err = l.store.Set(ctx, "login:lock:ip:"+ip, "1", l.lock)
+		if err != nil {
+			return locked, err
+		}
+		err = l.store.Delete(ctx, "login:fail:ip:"+ip)
+		if err != nil {
+			return locked, err
+		}
+		locked = true
+	}
+	return locked, nil
+}
+
+// Success reset the failed login of the username, the failure of the IP is kept because a stuffing
+// IP may also own an account
+func (l *LoginLimiter) Success(ctx context.Context, username string) error {
+	return l.store.Delete(ctx, "login:fail:user:"+loginUserKey(username))
+}
+
+// Unlock remove the lock and failure of the username, once its password is reset
+func (l *LoginLimiter) Unlock(ctx context.Context, username string) error {
+	user := loginUserKey(username)
+	err := l.store.Delete(ctx, "login:lock:user:"+user)
+	if err != nil {
+		return err
+	}
+	return l.store.Delete(ctx, "login:fail:user:"+user)
+}
@@ -0,0 +1,196 @@
+// Package notifier send the alert of a user to their email and webhook target, retried with backoff
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/dafaath/iot-server/internal/webhook"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"gopkg.in/gomail.v2"
+)
+
+const (
+	// Delivery waiting to be sent, a delivery is dropped when it is full
+	queueSize      = 1000
+	workers        = 4
+	requestTimeout = 10 * time.Second
+	// The delivery is given up after this many attempt, about 30 minutes after the alert
+	maxAttempts = 6
+	firstRetry  = time.Minute
+)
+
+// delivery is the alert event to send to one target
+type delivery struct {
+	target   entities.AlertTarget
+	event    entities.AlertEvent
+	attempts int
+}
+
+// Notifier send the alert event in the background so the ingest doesn't wait for the SMTP server or
+// the webhook. The queue is kept in memory, a delivery waiting for its retry is lost on restart
+type Notifier struct {
+	db         *pgxpool.Pool
+	repository *repositories.AlertRepository
+	dialer     *gomail.Dialer
+	config     *configs.Config
+	client     *http.Client
+	queue      chan delivery
+}
+
+func NewNotifier(db *pgxpool.Pool, alertRepository *repositories.AlertRepository, dialer *gomail.Dialer, config *configs.Config) (*Notifier, error) {
+	return &Notifier{
+		db:         db,
+		repository: alertRepository,
+		dialer:     dialer,
+		config:     config,
+		client:     &http.Client{Timeout: requestTimeout},
+		queue:      make(chan delivery, queueSize),
+	}, nil
+}
+
+// retryDelay double the wait after each failed attempt, 0 when there is no attempt left
+func retryDelay(attempts int) time.Duration {
+	if attempts >= maxAttempts {
+		return 0
+	}
+	delay := firstRetry
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+	}
+	return delay
+}
+
+// Notify queue the event of the alert for every enabled target of the user
+func (n *Notifier) Notify(ctx context.Context, idUser int, event string, alert entities.Alert) error {
+	targets, err := n.repository.GetTargets(ctx, n.db, idUser, true)
+	if err != nil {
+		return err
+	}
+
+	for _, target := range targets {
+		n.enqueue(delivery{target: target, event: entities.AlertEvent{Event: event, Alert: alert}})
+	}
+	return nil
+}
+
+func (n *Notifier) enqueue(d delivery) {
+	select {
+	case n.queue <- d:
+	default:
+		log.Printf("[NOTIFIER] Queue is full, dropping %s of alert %d to target %d", d.event.Event, d.event.Alert.IdAlert, d.target.IdTarget)
+	}
+}
+
+// Start the worker sending the queued delivery until the context is done
+func (n *Notifier) Start(ctx context.Context) {
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				select {
+				case d := <-n.queue:
+					n.deliver(ctx, d)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+}
+
+// deliver send the event, a failed delivery is queued again after its retry delay and the error is
+// kept on the target once it is given up
+func (n *Notifier) deliver(ctx context.Context, d delivery) {
+	var err error
+	switch d.target.Type {
+	case entities.AlertTargetEmail:
+		err = n.sendEmail(d)
+	case entities.AlertTargetWebhook:
+		err = n.sendWebhook(ctx, d)
+	default:
+		err = fmt.Errorf("unknown alert target type %s", d.target.Type)
+	}
+
+	if err == nil {
+		if d.target.LastError != nil {
+			err = n.repository.SetTargetError(ctx, n.db, d.target.IdTarget, nil)
+			if err != nil {
+				log.Printf("[NOTIFIER] Error clearing the error of target %d: %v", d.target.IdTarget, err)
+			}
+		}
+		return
+	}
+
+	d.attempts++
+	delay := retryDelay(d.attempts)
+	if delay == 0 {
+		log.Printf("[NOTIFIER] Giving up %s of alert %d to target %d: %v", d.event.Event, d.event.Alert.IdAlert, d.target.IdTarget, err)
+		message := err.Error()
+		err = n.repository.SetTargetError(ctx, n.db, d.target.IdTarget, &message)
+		if err != nil {
+			log.Printf("[NOTIFIER] Error keeping the error of target %d: %v", d.target.IdTarget, err)
+		}
+		return
+	}
+	time.AfterFunc(delay, func() {
+		n.enqueue(d)
+	})
+}
+
+func (n *Notifier) sendEmail(d delivery) error {
+	alert := d.event.Alert
+	subject := "[Alert] " + alert.Title
+	body := fmt.Sprintf("<p>Sensor %d sent %g at %s.</p>", alert.IdSensor, alert.Value, alert.FiredAt.Format(time.RFC1123))
+	if d.event.Event == entities.EventAlertResolved {
+		subject = "[Resolved] " + alert.Title
+		body = fmt.Sprintf("<p>The alert fired at %s is resolved at %s.</p>", alert.FiredAt.Format(time.RFC1123), alert.ResolvedAt.Format(time.RFC1123))
+	}
+
+	mailer := gomail.NewMessage()
+	mailer.SetHeader("From", n.config.Mail.SenderName)
+	mailer.SetHeader("To", d.target.Address)
+	mailer.SetHeader("Subject", subject)
+	mailer.SetBody("text/html", body)
+	return n.dialer.DialAndSend(mailer)
+}
+
+// sendWebhook post the event signed like the entity webhook, only a 2xx response is a success
+func (n *Notifier) sendWebhook(ctx context.Context, d delivery) error {
+	body, err := json.Marshal(d.event)
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, d.target.Address, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "iot-server-webhook")
+	request.Header.Set("X-Webhook-Event", d.event.Event)
+	request.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	request.Header.Set("X-Webhook-Signature", webhook.Sign(d.target.Secret, timestamp, body))
+
+	response, err := n.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook responded %s", response.Status)
+	}
+	return nil
+}
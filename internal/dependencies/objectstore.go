@@ -0,0 +1,379 @@
+package dependencies
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+)
+
+var ErrObjectNotFound = errors.New("object not found")
+
+type ObjectInfo struct {
+	Key        string    `json:"key"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// ObjectStore save and read blob by key, the key use "/" as separator on every store
+type ObjectStore interface {
+	Put(ctx context.Context, key string, body io.ReadSeeker) error
+	// Get return ErrObjectNotFound when the key doesn't exist
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// Delete doesn't fail when the key doesn't exist
+	Delete(ctx context.Context, key string) error
+}
+
+// validateObjectKey reject key that could escape the directory of the file store
+func validateObjectKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") || path.Clean(key) != key || strings.HasPrefix(key, "..") {
+		return fmt.Errorf("invalid object key %q", key)
+	}
+	return nil
+}
+
+type fileStore struct {
+	directory string
+}
+
+// NewFileStore store the object as file under the directory, which is created when missing
+func NewFileStore(directory string) (ObjectStore, error) {
+	err := os.MkdirAll(directory, 0o750)
+	if err != nil {
+		return nil, err
+	}
+	return &fileStore{directory: directory}, nil
+}
+
+func (s *fileStore) Put(ctx context.Context, key string, body io.ReadSeeker) error {
+	err := validateObjectKey(key)
+	if err != nil {
+		return err
+	}
+
+	target := filepath.Join(s.directory, filepath.FromSlash(key))
+	err = os.MkdirAll(filepath.Dir(target), 0o750)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a partial object is never visible
+	file, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	_, err = io.Copy(file, body)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), target)
+}
+
+func (s *fileStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	err := validateObjectKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(filepath.Join(s.directory, filepath.FromSlash(key)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrObjectNotFound
+	}
+	return file, err
+}
+
+func (s *fileStore) Delete(ctx context.Context, key string) error {
+	err := validateObjectKey(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(filepath.Join(s.directory, filepath.FromSlash(key)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *fileStore) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	objects := []ObjectInfo{}
+	err := filepath.WalkDir(s.directory, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".upload-") {
+			return nil
+		}
+
+		relative, err := filepath.Rel(s.directory, name)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(relative)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{Key: key, Size: info.Size(), ModifiedAt: info.ModTime().UTC()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+	return objects, nil
+}
+
+// s3Store talk to S3 compatible storage with path style url and AWS signature version 4.
+// The object is uploaded with a single PUT, so it is limited to 5GB
+type s3Store struct {
+	client    *http.Client
+	endpoint  string
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+}
+
+// NewS3Store return nil store without error when the bucket isn't configured
+func NewS3Store(config *configs.Config) (ObjectStore, error) {
+	if config.S3.Bucket == "" {
+		return nil, nil
+	}
+
+	endpoint, err := url.Parse(config.S3.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", config.S3.Endpoint)
+	}
+
+	return &s3Store{
+		client:    &http.Client{},
+		endpoint:  strings.TrimSuffix(config.S3.Endpoint, "/"),
+		region:    config.S3.Region,
+		bucket:    config.S3.Bucket,
+		accessKey: config.S3.AccessKey,
+		secretKey: config.S3.SecretKey,
+	}, nil
+}
+
+func (s *s3Store) Put(ctx context.Context, key string, body io.ReadSeeker) error {
+	size, err := body.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	_, err = body.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	response, err := s.do(ctx, http.MethodPut, key, nil, io.NopCloser(body), size)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	return s.checkResponse(response)
+}
+
+func (s *s3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	response, err := s.do(ctx, http.MethodGet, key, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode == http.StatusNotFound {
+		response.Body.Close()
+		return nil, ErrObjectNotFound
+	}
+
+	err = s.checkResponse(response)
+	if err != nil {
+		response.Body.Close()
+		return nil, err
+	}
+	return response.Body, nil
+}
+
+func (s *s3Store) Delete(ctx context.Context, key string) error {
+	response, err := s.do(ctx, http.MethodDelete, key, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	return s.checkResponse(response)
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *s3Store) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	objects := []ObjectInfo{}
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		response, err := s.do(ctx, http.MethodGet, "", query, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		result := s3ListResult{}
+		err = s.checkResponse(response)
+		if err == nil {
+			err = xml.NewDecoder(response.Body).Decode(&result)
+		}
+		response.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, content := range result.Contents {
+			objects = append(objects, ObjectInfo{Key: content.Key, Size: content.Size, ModifiedAt: content.LastModified.UTC()})
+		}
+		if !result.IsTruncated {
+			return objects, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+func (s *s3Store) checkResponse(response *http.Response) error {
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return nil
+	}
+	message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+	return fmt.Errorf("s3 responded with status %d: %s", response.StatusCode, strings.TrimSpace(string(message)))
+}
+
+func (s *s3Store) do(ctx context.Context, method string, key string, query url.Values, body io.ReadCloser, size int64) (*http.Response, error) {
+	objectPath := "/" + s.bucket
+	if key != "" {
+		objectPath += "/" + key
+	}
+	canonicalQuery := s3Escape(query)
+	target := s.endpoint + s3EscapePath(objectPath)
+	if canonicalQuery != "" {
+		target += "?" + canonicalQuery
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.ContentLength = size
+	}
+	s.sign(request, objectPath, canonicalQuery)
+	return s.client.Do(request)
+}
+
+// sign add the AWS signature version 4 header, the payload is not signed
+// so the body can be streamed
+func (s *s3Store) sign(request *http.Request, objectPath string, canonicalQuery string) {
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := "UNSIGNED-PAYLOAD"
+
+	request.Header.Set("x-amz-date", amzDate)
+	request.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		s3EscapePath(objectPath),
+		canonicalQuery,
+		"host:" + request.URL.Host + "\n" + "x-amz-content-sha256:" + payloadHash + "\n" + "x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3EscapeComponent percent encode everything except the unreserved character, as required by the signature
+func s3EscapeComponent(value string) string {
+	builder := strings.Builder{}
+	for _, b := range []byte(value) {
+		if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') || b == '-' || b == '_' || b == '.' || b == '~' {
+			builder.WriteByte(b)
+		} else {
+			fmt.Fprintf(&builder, "%%%02X", b)
+		}
+	}
+	return builder.String()
+}
+
+func s3EscapePath(value string) string {
+	segments := strings.Split(value, "/")
+	for i, segment := range segments {
+		segments[i] = s3EscapeComponent(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// s3Escape return the query sorted by key, which is both the canonical and the sent query
+func s3Escape(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := []string{}
+	for _, key := range keys {
+		for _, value := range query[key] {
+			parts = append(parts, s3EscapeComponent(key)+"="+s3EscapeComponent(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
@@ -0,0 +1,88 @@
+package dependencies
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+
+	"github.com/dafaath/iot-server/internal/entities"
+)
+
+const realtimeChannelTopic = "realtime:channel"
+
+// RealtimeHub fan-out new channel to the subscriber of its sensor. Channel is published through
+// the cluster pub/sub so subscriber connected to another instance also receive it.
+type RealtimeHub struct {
+	pubsub      ClusterPubSub
+	mutex       sync.RWMutex
+	subscribers map[int]map[chan entities.Channel]bool
+}
+
+func NewRealtimeHub(cluster *Cluster) (*RealtimeHub, error) {
+	hub := &RealtimeHub{
+		pubsub:      cluster.PubSub,
+		subscribers: map[int]map[chan entities.Channel]bool{},
+	}
+
+	err := cluster.PubSub.Subscribe(context.Background(), realtimeChannelTopic, hub.dispatch)
+	if err != nil {
+		return nil, err
+	}
+
+	return hub, nil
+}
+
+// Publish the new channel to every instance
+func (h *RealtimeHub) Publish(ctx context.Context, channel entities.Channel) error {
+	payload, err := json.Marshal(channel)
+	if err != nil {
+		return err
+	}
+	return h.pubsub.Publish(ctx, realtimeChannelTopic, payload)
+}
+
+func (h *RealtimeHub) dispatch(payload []byte) {
+	var channel entities.Channel
+	err := json.Unmarshal(payload, &channel)
+	if err != nil {
+		log.Printf("[REALTIME] Invalid channel payload: %v", err)
+		return
+	}
+
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	for subscriber := range h.subscribers[channel.IdSensor] {
+		// Slow subscriber miss the update instead of blocking the other
+		select {
+		case subscriber <- channel:
+		default:
+		}
+	}
+}
+
+// Subscribe return a channel receiving the new channel of the sensors, call unsubscribe when done
+func (h *RealtimeHub) Subscribe(sensorIds []int) (updates <-chan entities.Channel, unsubscribe func()) {
+	subscriber := make(chan entities.Channel, 64)
+
+	h.mutex.Lock()
+	for _, id := range sensorIds {
+		if h.subscribers[id] == nil {
+			h.subscribers[id] = map[chan entities.Channel]bool{}
+		}
+		h.subscribers[id][subscriber] = true
+	}
+	h.mutex.Unlock()
+
+	unsubscribe = func() {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+		for _, id := range sensorIds {
+			delete(h.subscribers[id], subscriber)
+			if len(h.subscribers[id]) == 0 {
+				delete(h.subscribers, id)
+			}
+		}
+	}
+	return subscriber, unsubscribe
+}
@@ -1,15 +1,18 @@
 package dependencies
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/dafaath/iot-server/internal/entities"
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
+	"gopkg.in/yaml.v3"
 )
 
 type Validator struct {
@@ -20,6 +23,22 @@ func NewValidator(validate *validator.Validate) Validator {
 	return Validator{Validate: validate}
 }
 
+// ValidationError is the 400 error of a payload that fail validation.
+// Fields map the json name of each invalid field to a short message for the HTML form
+type ValidationError struct {
+	Message string
+	Fields  map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// Unwrap let errors.As find the fiber error, so the error handler send it as 400
+func (e *ValidationError) Unwrap() error {
+	return fiber.NewError(400, e.Message)
+}
+
 func (v *Validator) formaFieldErrorMessage(fe validator.FieldError) string {
 	var sb strings.Builder
 
@@ -38,27 +57,40 @@ func (v *Validator) formaFieldErrorMessage(fe validator.FieldError) string {
 	return sb.String()
 }
 
+// Short message shown under the field of the form
+func (v *Validator) formatFieldMessage(fe validator.FieldError) string {
+	switch fe.ActualTag() {
+	case "required":
+		return "This field is required"
+	case "oneof":
+		return fmt.Sprintf("Must be one of %s", fe.Param())
+	case "email":
+		return "Must be a valid email address"
+	default:
+		return fmt.Sprintf("Must satisfy %s", strings.TrimSpace(fe.ActualTag()+" "+fe.Param()))
+	}
+}
+
 func (v *Validator) validateStruct(payload interface{}) error {
 
 	err := v.Validate.Struct(payload)
 	errMessage := ""
 	if err != nil {
+		fields := map[string]string{}
 		for _, err := range err.(validator.ValidationErrors) {
 			errMessage += v.formaFieldErrorMessage(err) + "\n"
+			if _, ok := fields[err.Field()]; !ok {
+				fields[err.Field()] = v.formatFieldMessage(err)
+			}
 		}
-		return fiber.NewError(400, errMessage)
+		return &ValidationError{Message: errMessage, Fields: fields}
 	} else {
 		return nil
 	}
 }
 
 func (v *Validator) validateParse(c *fiber.Ctx, payload interface{}) error {
-	err := v.validateStruct(payload)
-	if err != nil {
-		return fiber.NewError(400, err.Error())
-	}
-
-	return nil
+	return v.validateStruct(payload)
 }
 
 func (v *Validator) ParseQuery(c *fiber.Ctx, queryStruct interface{}) error {
@@ -84,6 +116,62 @@ func (v *Validator) ParseBody(c *fiber.Ctx, bodyStruct interface{}) error {
 	return v.validateParse(c, bodyStruct)
 }
 
+// ParseDocument parse the body as YAML when the content type is YAML, otherwise as ParseBody.
+// The YAML field name come from the yaml tag, which follow the json name
+func (v *Validator) ParseDocument(c *fiber.Ctx, bodyStruct interface{}) error {
+	if !strings.Contains(c.Get(fiber.HeaderContentType), "yaml") {
+		return v.ParseBody(c, bodyStruct)
+	}
+
+	v.Validate.RegisterTagNameFunc(func(fld reflect.StructField) string {
+		name := strings.SplitN(fld.Tag.Get("json"), ",", 2)[0]
+		return name
+	})
+
+	err := yaml.Unmarshal(c.Body(), bodyStruct)
+	if err != nil {
+		return fiber.NewError(400, err.Error())
+	}
+
+	return v.validateParse(c, bodyStruct)
+}
+
+// ParseBodyArray parse a JSON array body into list, a field of bodyStruct, then validate bodyStruct
+// like ParseBody
+func (v *Validator) ParseBodyArray(c *fiber.Ctx, bodyStruct interface{}, list interface{}) error {
+	v.Validate.RegisterTagNameFunc(func(fld reflect.StructField) string {
+		name := strings.SplitN(fld.Tag.Get("json"), ",", 2)[0]
+		return name
+	})
+
+	err := json.Unmarshal(c.Body(), list)
+	if err != nil {
+		return fiber.NewError(400, err.Error())
+	}
+
+	return v.validateParse(c, bodyStruct)
+}
+
+// ParseJson parse and validate a JSON payload received outside of a request, e.g. from a broker
+func (v *Validator) ParseJson(data []byte, payload interface{}) error {
+	v.Validate.RegisterTagNameFunc(func(fld reflect.StructField) string {
+		name := strings.SplitN(fld.Tag.Get("json"), ",", 2)[0]
+		return name
+	})
+
+	err := json.Unmarshal(data, payload)
+	if err != nil {
+		return fiber.NewError(400, err.Error())
+	}
+
+	return v.validateStruct(payload)
+}
+
+// ValidateStruct validate a payload decoded outside of a request, e.g. from a gRPC message
+func (v *Validator) ValidateStruct(payload interface{}) error {
+	return v.validateStruct(payload)
+}
+
 func (v *Validator) ParseIdFromUrlParameter(c *fiber.Ctx) (int, error) {
 	potentialId := c.Locals("id")
 	if potentialId == nil {
@@ -110,6 +198,17 @@ func (v *Validator) ParseIdFromUrlParameter(c *fiber.Ctx) (int, error) {
 	}
 }
 
+// ParseIntFromUrlParameter parse other url parameter than id, e.g. the widget id in /dashboard/:id/widget/:widget
+func (v *Validator) ParseIntFromUrlParameter(c *fiber.Ctx, key string) (int, error) {
+	param := c.Params(key)
+	err := v.Validate.Var(param, "required,number")
+	if err != nil {
+		return 0, fiber.NewError(400, fmt.Sprintf("%s parameter must be a valid positive integer", key))
+	}
+
+	return strconv.Atoi(param)
+}
+
 func (v *Validator) GetAuthentication(c *fiber.Ctx) (entities.UserRead, error) {
 	potentialUser := c.Locals("currentUser")
 	if potentialUser == nil {
@@ -123,3 +222,113 @@ func (v *Validator) GetAuthentication(c *fiber.Ctx) (entities.UserRead, error) {
 
 	return user, nil
 }
+
+// GetApiKeyNode return the node of the API key the request is authenticated with, 0 when it is
+// authenticated with the user token
+func (v *Validator) GetApiKeyNode(c *fiber.Ctx) int {
+	nodeId, _ := c.Locals("apiKeyNode").(int)
+	return nodeId
+}
+
+// ParseTimeQuery parse the query parameter as RFC3339 or epoch milliseconds, return nil if it is empty
+func (v *Validator) ParseTimeQuery(c *fiber.Ctx, key string) (*time.Time, error) {
+	value := c.Query(key)
+	if value == "" {
+		return nil, nil
+	}
+
+	if epochMilli, err := strconv.ParseInt(value, 10, 64); err == nil {
+		t := time.UnixMilli(epochMilli).UTC()
+		return &t, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fiber.NewError(400, fmt.Sprintf("%s parameter must be RFC3339 time or epoch milliseconds", key))
+	}
+	t = t.UTC()
+	return &t, nil
+}
+
+// ParseDurationQuery parse the query parameter as duration (e.g. 1h30m) or number of seconds, return 0 if it is empty
+func (v *Validator) ParseDurationQuery(c *fiber.Ctx, key string) (time.Duration, error) {
+	value := c.Query(key)
+	if value == "" {
+		return 0, nil
+	}
+
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, fiber.NewError(400, fmt.Sprintf("%s parameter must be a positive duration like 5m or 1h, or number of seconds", key))
+	}
+	return duration, nil
+}
+
+// ParseDryRun parse the dry_run query parameter, dryRun is accepted too
+func (v *Validator) ParseDryRun(c *fiber.Ctx) (bool, error) {
+	value := c.Query("dry_run", c.Query("dryRun", "false"))
+	dryRun, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fiber.NewError(400, "dry_run must be true or false")
+	}
+	return dryRun, nil
+}
+
+// ParseChannelQuery parse from, to, interval, and agg query parameter
+func (v *Validator) ParseChannelQuery(c *fiber.Ctx) (query entities.ChannelQuery, err error) {
+	query.From, err = v.ParseTimeQuery(c, "from")
+	if err != nil {
+		return query, err
+	}
+
+	query.To, err = v.ParseTimeQuery(c, "to")
+	if err != nil {
+		return query, err
+	}
+
+	if query.From != nil && query.To != nil && !query.From.Before(*query.To) {
+		return query, fiber.NewError(400, "from parameter must be before to parameter")
+	}
+
+	query.Interval, err = v.ParseDurationQuery(c, "interval")
+	if err != nil {
+		return query, err
+	}
+
+	query.Aggregate = c.Query("agg")
+	if quality := c.Query("quality"); quality != "" {
+		query.Quality = strings.Split(quality, ",")
+	}
+	query.Filtered = c.Query("filtered") == "true"
+	if c.Context().QueryArgs().Has("name") {
+		name := c.Query("name")
+		query.Name = &name
+	}
+	err = v.validateStruct(&query)
+	if err != nil {
+		return query, err
+	}
+
+	return query, nil
+}
+
+// ParseChannelFieldsQuery is ParseChannelQuery with fields, the comma separated name of the channel
+// to read. Only the endpoint returning the name of each channel accept it
+func (v *Validator) ParseChannelFieldsQuery(c *fiber.Ctx) (query entities.ChannelQuery, err error) {
+	query, err = v.ParseChannelQuery(c)
+	if err != nil {
+		return query, err
+	}
+	if fields := c.Query("fields"); fields != "" {
+		query.Fields = strings.Split(fields, ",")
+		err = v.validateStruct(&query)
+		if err != nil {
+			return query, err
+		}
+	}
+	return query, nil
+}
@@ -0,0 +1,103 @@
+package entities
+
+import "time"
+
+// AlertRule fire an alert when the channel of its sensor keep matching the condition for
+// DurationSeconds, e.g. value > 80 for 5 minutes. PendingSince is the time of the first channel of
+// the current match, nil when the last channel didn't match
+type AlertRule struct {
+	IdRule int `json:"id_rule"`
+	AlertRuleCreate
+	PendingSince *time.Time `json:"pending_since"`
+}
+
+type AlertRuleCreate struct {
+	IdSensor int `json:"id_sensor" validate:"required"`
+	// Channel of a multi-channel sensor, every channel of the sensor when nil
+	Name *string `json:"name" validate:"omitempty,max=32"`
+	// Comparison of the channel value with the threshold
+	Operator  string  `json:"operator" validate:"required,oneof=> >= < <= == !="`
+	Threshold float64 `json:"threshold"`
+	// 0 fire on the first matching channel
+	DurationSeconds int `json:"duration_seconds" validate:"min=0,max=604800"`
+	// Default to the condition, e.g. value > 80 on sensor 12
+	Title string `json:"title" validate:"max=255"`
+	// Default to true
+	Enabled *bool `json:"enabled"`
+}
+
+// Match is true when the value match the condition of the rule
+func (r *AlertRuleCreate) Match(value float64) bool {
+	switch r.Operator {
+	case ">":
+		return value > r.Threshold
+	case ">=":
+		return value >= r.Threshold
+	case "<":
+		return value < r.Threshold
+	case "<=":
+		return value <= r.Threshold
+	case "==":
+		return value == r.Threshold
+	case "!=":
+		return value != r.Threshold
+	}
+	return false
+}
+
+// Alert is fired by the rule, it is active until a channel stop matching the rule. Acknowledging it
+// only tell the other user someone is on it
+type Alert struct {
+	IdAlert        int        `json:"id_alert"`
+	IdRule         int        `json:"id_rule"`
+	IdSensor       int        `json:"id_sensor"`
+	Title          string     `json:"title"`
+	Value          float64    `json:"value"`
+	FiredAt        time.Time  `json:"fired_at"`
+	ResolvedAt     *time.Time `json:"resolved_at"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at"`
+}
+
+type AlertQuery struct {
+	// Include the resolved alert, only the active one otherwise
+	Resolved bool `query:"resolved"`
+	Limit    int  `query:"limit" validate:"omitempty,min=1,max=1000"`
+}
+
+// Type of the alert target
+const (
+	AlertTargetEmail   = "email"
+	AlertTargetWebhook = "webhook"
+)
+
+// Event of the alert sent to the alert target
+const (
+	EventAlertFired    = "alert.fired"
+	EventAlertResolved = "alert.resolved"
+)
+
+// AlertTarget receive the alert of the user sensor when it fire and when it is resolved, by email or
+// by a signed POST to a webhook url like the entity webhook
+type AlertTarget struct {
+	IdTarget int `json:"id_target"`
+	IdUser   int `json:"id_user"`
+	AlertTargetCreate
+	// Error of the last delivery that failed for good, nil after a delivery succeed
+	LastError *string `json:"last_error"`
+	// Only returned when a webhook target is created
+	Secret string `json:"secret,omitempty"`
+}
+
+type AlertTargetCreate struct {
+	Type string `json:"type" validate:"required,oneof=email webhook"`
+	// The email address or the webhook url
+	Address string `json:"address" validate:"required,max=2048"`
+	// Default to true
+	Enabled *bool `json:"enabled"`
+}
+
+// AlertEvent is the body posted to a webhook target
+type AlertEvent struct {
+	Event string `json:"event"`
+	Alert Alert  `json:"alert"`
+}
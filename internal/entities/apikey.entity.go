@@ -0,0 +1,30 @@
+package entities
+
+import "time"
+
+// ApiKeyPrefix start every node API key, so a leaked key is easy to recognize
+const ApiKeyPrefix = "nk_"
+
+// ApiKeyHeader is the header a device send its node API key in
+const ApiKeyHeader = "X-API-Key"
+
+// NodeApiKey authenticate a device of the node with the X-API-Key header, it can only send channel to
+// the sensor of the node. Only the hash of the key is stored, Prefix is the start of it to tell them apart
+type NodeApiKey struct {
+	IdKey      int        `json:"id_key"`
+	IdNode     int        `json:"id_node"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+type NodeApiKeyCreate struct {
+	Name string `json:"name" validate:"max=64"`
+}
+
+// NodeApiKeyCreated is the answer of the key creation, the key is only shown this time
+type NodeApiKeyCreated struct {
+	NodeApiKey
+	Key string `json:"key"`
+}
@@ -0,0 +1,37 @@
+package entities
+
+// ResourceChange is a row created, updated, or deleted by a bulk change, the unchanged row is only counted
+type ResourceChange struct {
+	Kind   string `json:"kind"`
+	Key    string `json:"key"`
+	Action string `json:"action"`
+}
+
+// ApplyManifest is the desired nodes and sensors of the caller, matched with the existing
+// one by name. The hardware is referenced by its name
+type ApplyManifest struct {
+	Nodes []ApplyNode `json:"nodes" yaml:"nodes" validate:"dive"`
+}
+
+type ApplyNode struct {
+	Name     string        `json:"name" yaml:"name" validate:"required"`
+	Location string        `json:"location" yaml:"location" validate:"required"`
+	Hardware string        `json:"hardware" yaml:"hardware" validate:"required"`
+	Sensors  []ApplySensor `json:"sensors" yaml:"sensors" validate:"dive"`
+}
+
+type ApplySensor struct {
+	Name     string `json:"name" yaml:"name" validate:"required"`
+	Unit     string `json:"unit" yaml:"unit" validate:"required"`
+	Hardware string `json:"hardware" yaml:"hardware" validate:"required"`
+}
+
+// ApplyResult list the change made to match the manifest. Unmanaged is the node and sensor
+// that exist but are not in the manifest, they are only deleted with prune
+type ApplyResult struct {
+	DryRun    bool             `json:"dry_run"`
+	Prune     bool             `json:"prune"`
+	Changes   []ResourceChange `json:"changes"`
+	Unchanged int              `json:"unchanged"`
+	Unmanaged []string         `json:"unmanaged"`
+}
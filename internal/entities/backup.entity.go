@@ -0,0 +1,43 @@
+package entities
+
+import "time"
+
+// BackupCreate start a backup, channel is only included when Channel is true,
+// optionally limited to the time range [From, To)
+type BackupCreate struct {
+	Target  string     `json:"target" validate:"required,oneof=file s3"`
+	Channel bool       `json:"channel"`
+	From    *time.Time `json:"from"`
+	To      *time.Time `json:"to"`
+}
+
+// BackupRestore start a restore of the backup with the name, see BackupFile.
+// Force allow replacing an instance that already has node, sensor, or channel
+type BackupRestore struct {
+	Target string `json:"target" validate:"required,oneof=file s3"`
+	Name   string `json:"name" validate:"required"`
+	Force  bool   `json:"force"`
+}
+
+type BackupFile struct {
+	Target     string    `json:"target"`
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// BackupOperation is the progress of a backup or restore, Rows of TotalRows are done
+type BackupOperation struct {
+	Id         string     `json:"id"`
+	Operation  string     `json:"operation"`
+	Target     string     `json:"target"`
+	Name       string     `json:"name"`
+	Status     string     `json:"status"`
+	Table      string     `json:"table"`
+	Rows       int64      `json:"rows"`
+	TotalRows  int64      `json:"total_rows"`
+	Message    string     `json:"message"`
+	Instance   string     `json:"instance"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
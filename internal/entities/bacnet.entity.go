@@ -0,0 +1,44 @@
+package entities
+
+// Status of a BACnet item before it is first read, and once it is read. Otherwise the status is why
+// the last read failed
+const (
+	BacnetPending = "pending"
+	BacnetOk      = "ok"
+)
+
+// SensorBacnetItem map a property of a BACnet object to the channel Name of the sensor
+type SensorBacnetItem struct {
+	Name     string `json:"name"`
+	Address  string `json:"address"`
+	Object   string `json:"object"`
+	Property string `json:"property"`
+	Status   string `json:"status"`
+}
+
+type SensorBacnetItemUpdate struct {
+	Name string `json:"name" validate:"max=32"`
+	// IP address or host name of the controller with an optional port, 47808 by default
+	Address string `json:"address" validate:"required,max=255"`
+	// Object type and instance, e.g. analog-input:1
+	Object string `json:"object" validate:"required,max=64"`
+	// present-value when empty
+	Property string `json:"property" validate:"max=64"`
+}
+
+// SensorBacnetUpdate replace the item of the sensor, no item stop polling the sensor
+type SensorBacnetUpdate struct {
+	Items []SensorBacnetItemUpdate `json:"items" validate:"max=64,dive"`
+}
+
+// BacnetItem is an item read by the poller, with the owner of its sensor
+type BacnetItem struct {
+	IdItem   int
+	IdSensor int
+	IdUser   int
+	Name     string
+	Address  string
+	Object   string
+	Property string
+	Status   string
+}
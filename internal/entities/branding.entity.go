@@ -0,0 +1,17 @@
+package entities
+
+// Branding is the white-label setting of the deployment, shown in every rendered layout
+type Branding struct {
+	ProductName  string `json:"product_name"`
+	LogoUrl      string `json:"logo_url"`
+	FooterText   string `json:"footer_text"`
+	LoginMessage string `json:"login_message"`
+}
+
+// BrandingUpdate override the config value, omitted field is kept and empty string restore the config value
+type BrandingUpdate struct {
+	ProductName  *string `json:"product_name" validate:"omitempty,max=64"`
+	LogoUrl      *string `json:"logo_url" validate:"omitempty,max=2048"`
+	FooterText   *string `json:"footer_text" validate:"omitempty,max=512"`
+	LoginMessage *string `json:"login_message" validate:"omitempty,max=1024"`
+}
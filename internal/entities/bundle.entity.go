@@ -0,0 +1,83 @@
+package entities
+
+import "time"
+
+// ConfigBundle is the non-timeseries state of an instance. Row reference each other by natural key
+// instead of id (username, hardware name, node name of its owner) so the bundle can be imported into
+// another instance. Password, activation token, embed token and public dashboard token are not exported
+type ConfigBundle struct {
+	Version      int                 `json:"version" yaml:"version"`
+	ExportedAt   time.Time           `json:"exported_at" yaml:"exported_at"`
+	Users        []BundleUser        `json:"users" yaml:"users" validate:"dive"`
+	Hardware     []BundleHardware    `json:"hardware" yaml:"hardware" validate:"dive"`
+	Nodes        []BundleNode        `json:"nodes" yaml:"nodes" validate:"dive"`
+	Sensors      []BundleSensor      `json:"sensors" yaml:"sensors" validate:"dive"`
+	Dashboards   []BundleDashboard   `json:"dashboards" yaml:"dashboards" validate:"dive"`
+	FeatureFlags []BundleFeatureFlag `json:"feature_flags" yaml:"feature_flags" validate:"dive"`
+	Settings     map[string]string   `json:"settings" yaml:"settings"`
+}
+
+type BundleUser struct {
+	Username string  `json:"username" yaml:"username" validate:"required"`
+	Email    string  `json:"email" yaml:"email" validate:"required,email"`
+	IsAdmin  bool    `json:"is_admin" yaml:"is_admin"`
+	Status   bool    `json:"status" yaml:"status"`
+	Theme    string  `json:"theme" yaml:"theme" validate:"omitempty,oneof=light dark system"`
+	Language *string `json:"language" yaml:"language" validate:"omitempty,oneof=en id"`
+}
+
+type BundleHardware struct {
+	Name        string `json:"name" yaml:"name" validate:"required"`
+	Type        string `json:"type" yaml:"type" validate:"required,oneof='microcontroller unit' 'single-board computer' 'sensor'"`
+	Description string `json:"description" yaml:"description" validate:"required"`
+}
+
+type BundleNode struct {
+	Owner    string `json:"owner" yaml:"owner" validate:"required"`
+	Name     string `json:"name" yaml:"name" validate:"required"`
+	Location string `json:"location" yaml:"location" validate:"required"`
+	Hardware string `json:"hardware" yaml:"hardware" validate:"required"`
+}
+
+type BundleSensor struct {
+	Owner    string `json:"owner" yaml:"owner" validate:"required"`
+	Node     string `json:"node" yaml:"node" validate:"required"`
+	Name     string `json:"name" yaml:"name" validate:"required"`
+	Unit     string `json:"unit" yaml:"unit" validate:"required"`
+	Hardware string `json:"hardware" yaml:"hardware" validate:"required"`
+}
+
+// BundleDashboard is linked to the node with the name when Node is set, the template link is not kept
+type BundleDashboard struct {
+	Owner   string         `json:"owner" yaml:"owner" validate:"required"`
+	Name    string         `json:"name" yaml:"name" validate:"required"`
+	Columns int            `json:"columns" yaml:"columns" validate:"omitempty,min=1,max=24"`
+	Node    string         `json:"node,omitempty" yaml:"node,omitempty"`
+	Widgets []BundleWidget `json:"widgets" yaml:"widgets" validate:"dive"`
+}
+
+// BundleWidget reference the sensor with the name on the node of the dashboard owner
+type BundleWidget struct {
+	Type    string        `json:"type" yaml:"type" validate:"required,oneof=value gauge chart map alert"`
+	Title   string        `json:"title" yaml:"title"`
+	Node    string        `json:"node,omitempty" yaml:"node,omitempty" validate:"required_with=Sensor"`
+	Sensor  string        `json:"sensor,omitempty" yaml:"sensor,omitempty" validate:"required_unless=Type map"`
+	X       int           `json:"x" yaml:"x" validate:"min=0"`
+	Y       int           `json:"y" yaml:"y" validate:"min=0"`
+	Width   int           `json:"width" yaml:"width" validate:"required,min=1,max=24"`
+	Height  int           `json:"height" yaml:"height" validate:"required,min=1,max=12"`
+	Options WidgetOptions `json:"options" yaml:"options"`
+}
+
+// BundleFeatureFlag is the override of the flag for the user, or for everyone when User is empty
+type BundleFeatureFlag struct {
+	Name    string `json:"name" yaml:"name" validate:"required"`
+	User    string `json:"user,omitempty" yaml:"user,omitempty"`
+	Enabled bool   `json:"enabled" yaml:"enabled"`
+}
+
+type BundleImportResult struct {
+	DryRun    bool             `json:"dry_run"`
+	Changes   []ResourceChange `json:"changes"`
+	Unchanged int              `json:"unchanged"`
+}
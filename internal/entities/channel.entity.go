@@ -1,13 +1,146 @@
 package entities
 
-import "time"
+import (
+	"sort"
+	"time"
+)
+
+// Quality flag of a channel, only the good channel is aggregated unless the query ask for another
+const (
+	QualityGood        = "good"
+	QualitySuspect     = "suspect"
+	QualityCalibrating = "calibrating"
+	QualityOutOfRange  = "out-of-range"
+)
+
+var ChannelQualities = []string{QualityGood, QualitySuspect, QualityCalibrating, QualityOutOfRange}
 
 type Channel struct {
 	Time time.Time `json:"time" validate:"required"`
 	ChannelCreate
+	// Value after the ingest filter of the sensor, Value is kept as it was received
+	FilteredValue *float64 `json:"filtered_value,omitempty"`
+}
+
+// ChannelPoll is the answer of a long-polling request, Cursor is the time of the newest channel to
+// send as since in the next request
+type ChannelPoll struct {
+	Cursor   *time.Time `json:"cursor"`
+	Channels []Channel  `json:"channels"`
 }
 
 type ChannelCreate struct {
-	Value    float64 `json:"value" validate:"required"`
+	Value    float64 `json:"value" validate:"required_without=Fields,excluded_with=Fields"`
 	IdSensor int     `json:"id_sensor" validate:"required"`
+	// Name of the channel of a multi-channel sensor like x, y and z, empty for a single value sensor
+	Name string `json:"name,omitempty" validate:"omitempty,max=32,excluded_with=Fields"`
+	// Good when omitted, an aggregated bucket has no quality
+	Quality string `json:"quality,omitempty" validate:"omitempty,oneof=good suspect calibrating out-of-range"`
+	// Value of each named channel read at once, instead of Value and Name. Each field is stored as
+	// the channel of its name at the same time
+	Fields map[string]float64 `json:"fields,omitempty" validate:"omitempty,min=1,max=32,dive,keys,min=1,max=32,endkeys"`
+}
+
+// Split return the channel of each field ordered by name, or the channel itself when it has no field
+func (c ChannelCreate) Split() []ChannelCreate {
+	if len(c.Fields) == 0 {
+		return []ChannelCreate{c}
+	}
+	names := make([]string, 0, len(c.Fields))
+	for name := range c.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	channels := make([]ChannelCreate, 0, len(names))
+	for _, name := range names {
+		channels = append(channels, ChannelCreate{Value: c.Fields[name], IdSensor: c.IdSensor, Name: name, Quality: c.Quality})
+	}
+	return channels
+}
+
+// ChannelBulkItem is a channel buffered by the device, stored at Time or at the time it is received
+// when it is omitted
+type ChannelBulkItem struct {
+	ChannelCreate
+	Time *time.Time `json:"time,omitempty"`
+}
+
+// ChannelBulk is the array body of POST /channel/bulk
+type ChannelBulk struct {
+	Channels []ChannelBulkItem `json:"channels" validate:"min=1,max=10000,dive"`
+}
+
+type ChannelBulkResult struct {
+	Created int `json:"created"`
+}
+
+// ChannelQuery filter the channel by time range [From, To) and Quality, and downsample it
+// into buckets of Interval using Aggregate when Interval is set
+type ChannelQuery struct {
+	From      *time.Time    `json:"from"`
+	To        *time.Time    `json:"to"`
+	Interval  time.Duration `json:"interval"`
+	Aggregate string        `json:"agg" validate:"omitempty,oneof=avg min max last"`
+	Quality   []string      `json:"quality" validate:"omitempty,dive,oneof=good suspect calibrating out-of-range"`
+	// Read the filtered value instead of the received value when the channel has one
+	Filtered bool `json:"filtered"`
+	// Read only the channel with this name, "" is the unnamed channel
+	Name *string `json:"name" validate:"omitempty,max=32"`
+	// Read only the named channel in the list, each is aggregated on its own
+	Fields []string `json:"fields" validate:"omitempty,max=32,excluded_with=Name,dive,min=1,max=32"`
+}
+
+// Qualities return the quality of the channel to read, every quality when it is nil. Without
+// Quality the aggregate only use the good channel
+func (q ChannelQuery) Qualities() []string {
+	if len(q.Quality) > 0 {
+		return q.Quality
+	}
+	if q.Interval > 0 {
+		return []string{QualityGood}
+	}
+	return nil
+}
+
+// ChannelName return the name of the channel to read, every channel when it is nil. Without Name the
+// aggregate only use the unnamed channel, the named channel of a sensor isn't aggregated together
+func (q ChannelQuery) ChannelName() *string {
+	if q.Name != nil {
+		return q.Name
+	}
+	if q.Interval > 0 {
+		unnamed := ""
+		return &unnamed
+	}
+	return nil
+}
+
+// ChannelQualityUpdate flag every channel of the sensor in [From, To)
+type ChannelQualityUpdate struct {
+	From    time.Time `json:"from" validate:"required"`
+	To      time.Time `json:"to" validate:"required"`
+	Quality string    `json:"quality" validate:"required,oneof=good suspect calibrating out-of-range"`
+}
+
+// ChannelArchive is the channel of a sensor in a day (UTC) moved to the object storage
+type ChannelArchive struct {
+	IdSensor   int       `json:"id_sensor"`
+	Day        time.Time `json:"day"`
+	ObjectKey  string    `json:"object_key"`
+	RowCount   int64     `json:"row_count"`
+	FirstTime  time.Time `json:"first_time"`
+	LastTime   time.Time `json:"last_time"`
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+// ChannelCompressed is the channel of a sensor in a day (UTC) compressed into one row
+type ChannelCompressed struct {
+	IdSensor     int       `json:"id_sensor"`
+	Day          time.Time `json:"day"`
+	RowCount     int64     `json:"row_count"`
+	FirstTime    time.Time `json:"first_time"`
+	LastTime     time.Time `json:"last_time"`
+	Data         []byte    `json:"-"`
+	CompressedAt time.Time `json:"compressed_at"`
 }
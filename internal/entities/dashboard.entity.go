@@ -0,0 +1,107 @@
+package entities
+
+type Dashboard struct {
+	IdDashboard int `json:"id_dashboard" validate:"required"`
+	DashboardCreate
+	IdUser int `json:"id_user" validate:"required"`
+	// PublicToken is set when the dashboard is published at /public/dashboard/{token}
+	PublicToken *string `json:"public_token"`
+	// IdNode and IdTemplate are set when the dashboard is instantiated from a template for a node
+	IdNode     *int `json:"id_node"`
+	IdTemplate *int `json:"id_template"`
+}
+
+type DashboardCreate struct {
+	Name string `json:"name" validate:"required"`
+	// Columns is the number of column in the layout grid, default to 12
+	Columns int `json:"columns" validate:"omitempty,min=1,max=24"`
+}
+
+type DashboardUpdate struct {
+	Name    string `json:"name"`
+	Columns int    `json:"columns" validate:"omitempty,min=1,max=24"`
+}
+
+func (du *DashboardUpdate) ChangeSettedFieldOnly(dashboard *Dashboard) {
+	if du.Name == "" {
+		du.Name = dashboard.Name
+	}
+
+	if du.Columns == 0 {
+		du.Columns = dashboard.Columns
+	}
+}
+
+type DashboardWithWidgets struct {
+	Dashboard
+	Widgets []Widget `json:"widgets"`
+}
+
+type Widget struct {
+	IdWidget    int `json:"id_widget" validate:"required"`
+	IdDashboard int `json:"id_dashboard" validate:"required"`
+	WidgetCreate
+}
+
+// WidgetCreate place the widget at column X and row Y of the dashboard grid, spanning Width columns
+// and Height rows. Every type except map must be bound to a sensor, map without sensor show every node.
+type WidgetCreate struct {
+	Type     string        `json:"type" validate:"required,oneof=value gauge chart map alert"`
+	Title    string        `json:"title"`
+	IdSensor *int          `json:"id_sensor" validate:"required_unless=Type map"`
+	X        int           `json:"x" validate:"min=0"`
+	Y        int           `json:"y" validate:"min=0"`
+	Width    int           `json:"width" validate:"required,min=1,max=24"`
+	Height   int           `json:"height" validate:"required,min=1,max=12"`
+	Options  WidgetOptions `json:"options"`
+}
+
+type WidgetOptions struct {
+	// Min and Max are the gauge scale, or the normal range for alert list
+	Min *float64 `json:"min,omitempty" yaml:"min,omitempty"`
+	Max *float64 `json:"max,omitempty" yaml:"max,omitempty"`
+	// Range is how far back the chart show, e.g. 24h, default to 24h
+	Range string `json:"range,omitempty" yaml:"range,omitempty"`
+}
+
+// IsOutOfRange is the same check as the alert widget of the dashboard
+func (o WidgetOptions) IsOutOfRange(value float64) bool {
+	return (o.Min != nil && value < *o.Min) || (o.Max != nil && value > *o.Max)
+}
+
+// DashboardTemplate is instantiated as a new dashboard when a node is created. Template without
+// IdUser is shared with every user, and template without IdHardware apply to any node hardware.
+type DashboardTemplate struct {
+	IdTemplate int  `json:"id_template" validate:"required"`
+	IdUser     *int `json:"id_user"`
+	DashboardTemplateCreate
+}
+
+type DashboardTemplateCreate struct {
+	Name       string           `json:"name" validate:"required"`
+	IdHardware *int             `json:"id_hardware"`
+	Columns    int              `json:"columns" validate:"omitempty,min=1,max=24"`
+	AutoApply  bool             `json:"auto_apply"`
+	Widgets    []TemplateWidget `json:"widgets" validate:"dive"`
+}
+
+// TemplateWidget is bound to the node sensor with the same name (case insensitive) when the sensor
+// is created. SensorName "*" repeat the widget for every sensor, below the existing widgets.
+type TemplateWidget struct {
+	Type       string        `json:"type" validate:"required,oneof=value gauge chart map alert"`
+	Title      string        `json:"title"`
+	SensorName string        `json:"sensor_name" validate:"required_unless=Type map"`
+	X          int           `json:"x" validate:"min=0"`
+	Y          int           `json:"y" validate:"min=0"`
+	Width      int           `json:"width" validate:"required,min=1,max=24"`
+	Height     int           `json:"height" validate:"required,min=1,max=12"`
+	Options    WidgetOptions `json:"options"`
+}
+
+// DashboardTemplateSave save an existing dashboard as template, Global is only allowed for admin
+type DashboardTemplateSave struct {
+	Name       string `json:"name" validate:"required"`
+	IdHardware *int   `json:"id_hardware"`
+	AutoApply  bool   `json:"auto_apply"`
+	Global     bool   `json:"global"`
+}
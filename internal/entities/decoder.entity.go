@@ -0,0 +1,54 @@
+package entities
+
+// DecoderField read one value of the binary payload at Offset byte as Type in Endian order, big
+// by default. The bits type read Bits unsigned bit starting at bit Bit of the Offset byte, bit 0 being
+// its most significant bit, e.g. a flag or a packed 12 bit value. The value is stored as
+// value*Scale+Add in the channel Name of the node sensor named Sensor
+type DecoderField struct {
+	Sensor string   `json:"sensor" validate:"required"`
+	Name   string   `json:"name,omitempty" validate:"omitempty,max=32"`
+	Offset int      `json:"offset" validate:"min=0,max=255"`
+	Type   string   `json:"type" validate:"required,oneof=int8 uint8 int16 uint16 int24 uint24 int32 uint32 int64 uint64 float32 float64 bits"`
+	Endian string   `json:"endian,omitempty" validate:"omitempty,oneof=big little"`
+	Bit    int      `json:"bit,omitempty" validate:"min=0,max=7"`
+	Bits   int      `json:"bits,omitempty" validate:"required_if=Type bits,max=32"`
+	Scale  *float64 `json:"scale,omitempty"`
+	Add    float64  `json:"add,omitempty"`
+}
+
+// HardwareDecoder decode the binary payload sent by the node of the hardware, e.g. a Sigfox uplink.
+// With Plugin the payload is decoded by the registered decoder plugin of that name instead of Fields
+type HardwareDecoder struct {
+	IdHardware int            `json:"id_hardware"`
+	Fields     []DecoderField `json:"fields"`
+	Plugin     string         `json:"plugin,omitempty"`
+}
+
+// HardwareDecoderUpdate without field and plugin remove the decoder
+type HardwareDecoderUpdate struct {
+	Fields []DecoderField `json:"fields" validate:"max=64,dive"`
+	Plugin string         `json:"plugin" validate:"max=64"`
+}
+
+// DecodedValue is the value of a decoder field
+type DecodedValue struct {
+	Sensor string  `json:"sensor"`
+	Name   string  `json:"name,omitempty"`
+	Value  float64 `json:"value"`
+}
+
+// DecodedResult is what happened to each decoded value, Status is the status POST /channel would
+// have answered
+type DecodedResult struct {
+	DecodedValue
+	IdSensor *int   `json:"id_sensor"`
+	Status   int    `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Uplink is a raw payload of the node, e.g. forwarded by a LoRaWAN network server, decoded by the
+// decoder of the node hardware. Encoding is hex by default
+type Uplink struct {
+	Data     string `json:"data" validate:"required,max=1024"`
+	Encoding string `json:"encoding" validate:"omitempty,oneof=hex base64"`
+}
@@ -0,0 +1,30 @@
+package entities
+
+import "time"
+
+// UserDigestUpdate opt the current user in or out of the daily email digest
+type UserDigestUpdate struct {
+	Digest *bool `json:"digest" validate:"required"`
+}
+
+// SensorSummary is the summary of a sensor in [From, To) of the digest and report. Min, Max and Avg
+// are read from the good unnamed channel, nil when there is none
+type SensorSummary struct {
+	IdSensor int          `json:"id_sensor"`
+	Name     string       `json:"name"`
+	Unit     string       `json:"unit"`
+	Count    int64        `json:"count"`
+	Min      *float64     `json:"min"`
+	Max      *float64     `json:"max"`
+	Avg      *float64     `json:"avg"`
+	Gaps     []TimeWindow `json:"gaps"`
+	Alerts   int64        `json:"alerts"`
+}
+
+// UserDigest is the daily email digest of every sensor of the user
+type UserDigest struct {
+	User    UserRead        `json:"-"`
+	From    time.Time       `json:"from"`
+	To      time.Time       `json:"to"`
+	Sensors []SensorSummary `json:"sensors"`
+}
@@ -0,0 +1,15 @@
+package entities
+
+type FeatureFlag struct {
+	Name    string `json:"name" validate:"required"`
+	Enabled bool   `json:"enabled"`
+}
+
+type UserFeatureFlag struct {
+	IdUser int `json:"id_user" validate:"required"`
+	FeatureFlag
+}
+
+type FeatureFlagUpdate struct {
+	Enabled *bool `json:"enabled" validate:"required"`
+}
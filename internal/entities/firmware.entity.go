@@ -0,0 +1,67 @@
+package entities
+
+import "time"
+
+// Where the firmware binary is kept, firmware.storage of the config
+const (
+	FirmwareStorageFile = "file"
+	FirmwareStorageS3   = "s3"
+)
+
+// Firmware is a binary of the hardware uploaded by a user, a node of the hardware can be updated to
+// it. Only the uploader and an admin can see it
+type Firmware struct {
+	IdFirmware int       `json:"id_firmware"`
+	IdHardware int       `json:"id_hardware"`
+	IdUser     int       `json:"id_user"`
+	Version    string    `json:"version"`
+	Notes      string    `json:"notes"`
+	Size       int64     `json:"size"`
+	Sha256     string    `json:"sha256"`
+	Storage    string    `json:"storage"`
+	ObjectKey  string    `json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+	// Signed download url, only set for a single firmware
+	Url string `json:"url,omitempty"`
+}
+
+// FirmwareCreate is the form field of the upload, the binary is the file field
+type FirmwareCreate struct {
+	IdHardware int    `json:"id_hardware" form:"id_hardware" validate:"required,min=1"`
+	Version    string `json:"version" form:"version" validate:"required,max=64"`
+	Notes      string `json:"notes" form:"notes" validate:"max=255"`
+}
+
+type FirmwareQuery struct {
+	IdHardware int `query:"id_hardware" validate:"omitempty,min=1"`
+}
+
+// NodeFirmware is the version a node reported and the firmware it should run. Pending is true when
+// the target version isn't the reported one
+type NodeFirmware struct {
+	IdNode         int        `json:"id_node"`
+	CurrentVersion *string    `json:"current_version"`
+	ReportedAt     *time.Time `json:"reported_at"`
+	Target         *Firmware  `json:"target"`
+	AssignedAt     *time.Time `json:"assigned_at"`
+	Pending        bool       `json:"pending"`
+}
+
+// NodeFirmwareAssign set the target of the node to the firmware of this version for its hardware
+type NodeFirmwareAssign struct {
+	Version string `json:"version" validate:"required,max=64"`
+}
+
+// FirmwareCheck is sent by the device with the version it run
+type FirmwareCheck struct {
+	Version string `json:"version" validate:"required,max=64"`
+}
+
+// FirmwareCheckResult tell the device whether to update, and where to download the firmware
+type FirmwareCheckResult struct {
+	Update  bool   `json:"update"`
+	Version string `json:"version,omitempty"`
+	Url     string `json:"url,omitempty"`
+	Size    int64  `json:"size,omitempty"`
+	Sha256  string `json:"sha256,omitempty"`
+}
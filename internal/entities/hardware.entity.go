@@ -1,15 +1,21 @@
 package entities
 
 type HardwareCreate struct {
-	Name        string `json:"name" validate:"required"`
-	Type        string `json:"type" validate:"required,oneof='microcontroller unit' 'single-board computer' 'sensor'"`
-	Description string `json:"description" validate:"required"`
+	Name        string `json:"name" form:"name" validate:"required"`
+	Type        string `json:"type" form:"type" validate:"required,oneof='microcontroller unit' 'single-board computer' 'sensor'"`
+	Description string `json:"description" form:"description" validate:"required"`
+}
+
+// HardwareQuery filter the hardware list, Search match the name or description
+type HardwareQuery struct {
+	Search string `query:"q"`
+	Type   string `query:"type" validate:"omitempty,oneof='microcontroller unit' 'single-board computer' 'sensor'"`
 }
 
 type HardwareUpdate struct {
-	Name        string `json:"name"`
-	Type        string `json:"type" validate:"oneof='microcontroller unit' 'single-board computer' 'sensor'"`
-	Description string `json:"description"`
+	Name        string `json:"name" form:"name"`
+	Type        string `json:"type" form:"type" validate:"oneof='microcontroller unit' 'single-board computer' 'sensor'"`
+	Description string `json:"description" form:"description"`
 }
 
 func (hu *HardwareUpdate) ChangeSettedFieldOnly(hardware *Hardware) {
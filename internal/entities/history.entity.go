@@ -0,0 +1,61 @@
+package entities
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Entity which configuration change is kept in the history
+const (
+	EntitySensor   = "sensor"
+	EntityNode     = "node"
+	EntityHardware = "hardware"
+)
+
+const (
+	RevisionCreate = "create"
+	RevisionUpdate = "update"
+	RevisionDelete = "delete"
+	// The entity is set back to the data of an older version
+	RevisionRollback = "rollback"
+	// A deleted entity is restored by an admin
+	RevisionRestore = "restore"
+)
+
+// EntityRevision is one version of an entity, Data is the entity after the change (before it for
+// a delete) and Changes the field that changed from the previous version
+type EntityRevision struct {
+	IdRevision int64                  `json:"id_revision"`
+	EntityType string                 `json:"entity_type"`
+	IdEntity   int                    `json:"id_entity"`
+	Version    int                    `json:"version"`
+	Action     string                 `json:"action"`
+	IdUser     *int                   `json:"id_user"`
+	Username   *string                `json:"username"`
+	ChangedAt  time.Time              `json:"changed_at"`
+	Data       json.RawMessage        `json:"data"`
+	Changes    map[string]FieldChange `json:"changes"`
+}
+
+type FieldChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+type RevisionQuery struct {
+	Limit int `query:"limit" validate:"omitempty,min=1,max=500"`
+}
+
+// RevisionDiffQuery compare version From to version To, the latest version when To is omitted
+type RevisionDiffQuery struct {
+	From int `query:"from" validate:"required,min=1"`
+	To   int `query:"to" validate:"omitempty,min=1"`
+}
+
+type RevisionDiff struct {
+	EntityType string                 `json:"entity_type"`
+	IdEntity   int                    `json:"id_entity"`
+	From       int                    `json:"from"`
+	To         int                    `json:"to"`
+	Changes    map[string]FieldChange `json:"changes"`
+}
@@ -0,0 +1,12 @@
+package entities
+
+// DeleteImpact is what a delete would remove with it, answered instead of deleting with dry_run.
+// Channels count the channel of the sensors, compressed and archived included
+type DeleteImpact struct {
+	DryRun   bool  `json:"dry_run"`
+	Users    []int `json:"users"`
+	Hardware []int `json:"hardware"`
+	Nodes    []int `json:"nodes"`
+	Sensors  []int `json:"sensors"`
+	Channels int64 `json:"channels"`
+}
@@ -0,0 +1,51 @@
+package entities
+
+import "time"
+
+// Window of the ingest statistic, counted per hour
+var IngestWindows = map[string]time.Duration{
+	"1h":  time.Hour,
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+}
+
+// IngestQuery select the window of the ingest statistic, default to 24h
+type IngestQuery struct {
+	Window string `query:"window" validate:"omitempty,oneof=1h 24h 7d 30d"`
+}
+
+// IngestError is the last channel of the sensor that was refused, with the response status and message
+type IngestError struct {
+	Time    time.Time `json:"time"`
+	Status  int       `json:"status"`
+	Message string    `json:"message"`
+}
+
+// IngestStat is the channel posted to a sensor in the window. Accepted is the stored channel, Rejected
+// the channel refused after the sensor is known, e.g. an invalid body or a validation rule, and Bytes
+// the request body size of both
+type IngestStat struct {
+	IdSensor      int          `json:"id_sensor"`
+	Name          string       `json:"name"`
+	Window        string       `json:"window"`
+	Accepted      int64        `json:"accepted"`
+	Rejected      int64        `json:"rejected"`
+	Bytes         int64        `json:"bytes"`
+	RatePerMinute float64      `json:"rate_per_minute"`
+	LastReceived  *time.Time   `json:"last_received"`
+	LastError     *IngestError `json:"last_error"`
+}
+
+// NodeIngestStat is the sum of the ingest statistic of the node sensor
+type NodeIngestStat struct {
+	IdNode        int          `json:"id_node"`
+	Name          string       `json:"name"`
+	Window        string       `json:"window"`
+	Accepted      int64        `json:"accepted"`
+	Rejected      int64        `json:"rejected"`
+	Bytes         int64        `json:"bytes"`
+	RatePerMinute float64      `json:"rate_per_minute"`
+	LastReceived  *time.Time   `json:"last_received"`
+	Sensors       []IngestStat `json:"sensors"`
+}
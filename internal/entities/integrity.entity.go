@@ -0,0 +1,51 @@
+package entities
+
+import "time"
+
+const (
+	ChecksumOk       = "ok"
+	ChecksumMismatch = "mismatch"
+)
+
+// ChannelChecksum is the row count and checksum of the channel of a sensor day, taken once the day
+// is settled. A mismatch keep what was taken and what the verification found in Actual
+type ChannelChecksum struct {
+	IdSensor       int       `json:"id_sensor"`
+	Day            time.Time `json:"day"`
+	RowCount       int64     `json:"row_count"`
+	Checksum       string    `json:"checksum"`
+	ComputedAt     time.Time `json:"computed_at"`
+	VerifiedAt     time.Time `json:"verified_at"`
+	Status         string    `json:"status"`
+	ActualRowCount *int64    `json:"actual_row_count"`
+	ActualChecksum *string   `json:"actual_checksum"`
+}
+
+// IntegrityStatus is the state of the checksum of every sensor day
+type IntegrityStatus struct {
+	Days             int64             `json:"days"`
+	MismatchDays     int64             `json:"mismatch_days"`
+	OldestVerifiedAt *time.Time        `json:"oldest_verified_at"`
+	Mismatches       []ChannelChecksum `json:"mismatches"`
+}
+
+// IntegrityVerify verify the day of the sensor in [from, to] now
+type IntegrityVerify struct {
+	IdSensor int       `json:"id_sensor" validate:"required"`
+	From     time.Time `json:"from" validate:"required"`
+	To       time.Time `json:"to" validate:"required"`
+}
+
+// IntegrityAccept take the current channel of the sensor day as its checksum, after the mismatch is
+// explained
+type IntegrityAccept struct {
+	IdSensor int       `json:"id_sensor" validate:"required"`
+	Day      time.Time `json:"day" validate:"required"`
+}
+
+// IntegrityResult is what a verification did, Mismatches is the mismatch it found
+type IntegrityResult struct {
+	Computed   int               `json:"computed"`
+	Verified   int               `json:"verified"`
+	Mismatches []ChannelChecksum `json:"mismatches"`
+}
@@ -0,0 +1,15 @@
+package entities
+
+import "time"
+
+type Job struct {
+	Name           string     `json:"name" validate:"required"`
+	Schedule       string     `json:"schedule" validate:"required"`
+	Running        bool       `json:"running"`
+	LastRunAt      *time.Time `json:"last_run_at"`
+	LastInstance   string     `json:"last_instance"`
+	LastDurationMs int64      `json:"last_duration_ms"`
+	LastStatus     string     `json:"last_status"`
+	LastMessage    string     `json:"last_message"`
+	NextRunAt      *time.Time `json:"next_run_at"`
+}
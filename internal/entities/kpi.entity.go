@@ -0,0 +1,44 @@
+package entities
+
+import "time"
+
+// Function of the KPI, computed over each window of the sensor channel
+const (
+	KpiAvg   = "avg"
+	KpiMin   = "min"
+	KpiMax   = "max"
+	KpiSum   = "sum"
+	KpiCount = "count"
+	// Time integral of the value in hour, e.g. the Wh of a sensor in W
+	KpiIntegral = "integral"
+	// Degree below and above the base of the window average, counted per day of the window
+	KpiHeatingDegreeDay = "heating-degree-day"
+	KpiCoolingDegreeDay = "cooling-degree-day"
+)
+
+// Kpi is a value derived from the good unnamed channel of a sensor per window, like the daily kWh of
+// a power sensor. The kpi job materialize each finished window up to ComputedUntil
+type Kpi struct {
+	IdKpi int `json:"id_kpi"`
+	KpiCreate
+	ComputedUntil *time.Time `json:"computed_until"`
+}
+
+type KpiCreate struct {
+	IdSensor int    `json:"id_sensor" validate:"required"`
+	Name     string `json:"name" validate:"required,max=64"`
+	Function string `json:"function" validate:"required,oneof=avg min max sum count integral heating-degree-day cooling-degree-day"`
+	// Default to a day, otherwise it must divide a day
+	WindowSeconds int `json:"window_seconds" validate:"omitempty,min=60,max=86400"`
+	// The degree day base, default to 18
+	Base *float64 `json:"base"`
+	// Multiply the computed value, e.g. 0.001 to get the kWh of a sensor in W. Default to 1
+	Scale *float64 `json:"scale"`
+	Unit  string   `json:"unit" validate:"max=32"`
+}
+
+// KpiValue is the KPI of the window starting at Bucket
+type KpiValue struct {
+	Bucket time.Time `json:"bucket"`
+	Value  float64   `json:"value"`
+}
@@ -0,0 +1,13 @@
+package entities
+
+// MqttReading is the JSON payload a device publish to the sensor topic, it is stored like the body
+// of POST /channel. Token is the token of the node owner, like the Authorization header, or ApiKey
+// an API key of the node of the sensor, like the X-API-Key header
+type MqttReading struct {
+	Token   string             `json:"token" validate:"excluded_with=ApiKey"`
+	ApiKey  string             `json:"api_key"`
+	Value   float64            `json:"value" validate:"required_without=Fields,excluded_with=Fields"`
+	Name    string             `json:"name,omitempty" validate:"omitempty,max=32,excluded_with=Fields"`
+	Quality string             `json:"quality,omitempty" validate:"omitempty,oneof=good suspect calibrating out-of-range"`
+	Fields  map[string]float64 `json:"fields,omitempty" validate:"omitempty,min=1,max=32,dive,keys,min=1,max=32,endkeys"`
+}
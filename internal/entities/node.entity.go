@@ -1,20 +1,41 @@
 package entities
 
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
 type Node struct {
 	IdNode int `json:"id_node" validate:"required"`
 	NodeCreate
 	IdUser int `json:"id_user" validate:"required"`
+	// Last channel or heartbeat of the device. Online is set by both and cleared by the node-offline
+	// job after heartbeat.offlineMinutes without any
+	LastSeen *time.Time `json:"last_seen"`
+	Online   bool       `json:"online"`
 }
 
 type NodeCreate struct {
-	Name       string `json:"name" validate:"required"`
-	Location   string `json:"location" validate:"required"`
-	IdHardware int    `json:"id_hardware" validate:"required"`
+	Name       string `json:"name" form:"name" validate:"required"`
+	Location   string `json:"location" form:"location" validate:"required"`
+	IdHardware int    `json:"id_hardware" form:"id_hardware" validate:"required"`
+}
+
+// NodeQuery filter the node list, Search match the name or location.
+// Status is computed from the node activity, see NodeFeatureProperties
+type NodeQuery struct {
+	Search     string `query:"q"`
+	IdHardware int    `query:"id_hardware" validate:"omitempty,min=1"`
+	Status     string `query:"status" validate:"omitempty,oneof=online stale offline no_data"`
+	// Set by the handler for the paginated list, 0 Limit return every node
+	Limit  int `query:"-"`
+	Offset int `query:"-"`
 }
 
 type NodeUpdate struct {
-	Name     string `json:"name"`
-	Location string `json:"location"`
+	Name     string `json:"name" form:"name"`
+	Location string `json:"location" form:"location"`
 }
 
 func (hu *NodeUpdate) ChangeSettedFieldOnly(node *Node) {
@@ -27,8 +48,129 @@ func (hu *NodeUpdate) ChangeSettedFieldOnly(node *Node) {
 	}
 }
 
+// ParseNodeLocation read node location written as "latitude,longitude"
+func ParseNodeLocation(location string) (latitude float64, longitude float64, ok bool) {
+	parts := strings.Split(location, ",")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	latitude, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil || latitude < -90 || latitude > 90 {
+		return 0, 0, false
+	}
+	longitude, err = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil || longitude < -180 || longitude > 180 {
+		return 0, 0, false
+	}
+	return latitude, longitude, true
+}
+
 type NodeWithHardwareAndSensors struct {
 	Node
 	Hardware Hardware `json:"hardware"`
 	Sensor   []Sensor `json:"sensor"`
 }
+
+// NodeActivity is the last time any sensor of the node sent a channel or the node sent a heartbeat
+type NodeActivity struct {
+	IdNode      int        `json:"id_node"`
+	SensorCount int        `json:"sensor_count"`
+	LastSeen    *time.Time `json:"last_seen"`
+}
+
+// NodeHeartbeat is the answer of POST /node/{id}/heartbeat
+type NodeHeartbeat struct {
+	IdNode   int       `json:"id_node"`
+	LastSeen time.Time `json:"last_seen"`
+	Online   bool      `json:"online"`
+}
+
+// NodeFeatureCollection is the GeoJSON (RFC 7946) of the nodes located with "latitude,longitude"
+type NodeFeatureCollection struct {
+	Type     string        `json:"type"`
+	Features []NodeFeature `json:"features"`
+}
+
+type NodeFeature struct {
+	Type       string                `json:"type"`
+	Geometry   NodeFeatureGeometry   `json:"geometry"`
+	Properties NodeFeatureProperties `json:"properties"`
+}
+
+type NodeFeatureGeometry struct {
+	Type string `json:"type"`
+	// Longitude first as required by GeoJSON
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+type NodeFeatureProperties struct {
+	IdNode      int        `json:"id_node"`
+	Name        string     `json:"name"`
+	Location    string     `json:"location"`
+	IdHardware  int        `json:"id_hardware"`
+	SensorCount int        `json:"sensor_count"`
+	LastSeen    *time.Time `json:"last_seen"`
+	// online, stale, offline or no_data
+	Status string `json:"status"`
+}
+
+// NodeStatusPage publish the node status at /public/node/{token}, only the latest value of IdSensors
+// is shown
+type NodeStatusPage struct {
+	IdNode    int    `json:"id_node"`
+	Token     string `json:"token"`
+	IdSensors []int  `json:"id_sensors"`
+}
+
+type NodeStatusPageUpdate struct {
+	IdSensors []int `json:"id_sensors" validate:"max=20,dive,min=1"`
+	// Replace the token, the previously shared url stop working
+	Rotate bool `json:"rotate"`
+}
+
+// NodePublicStatus is what the public status page show, without any id so the owner stay private
+type NodePublicStatus struct {
+	Name     string     `json:"name"`
+	Status   string     `json:"status"`
+	LastSeen *time.Time `json:"last_seen"`
+	// Percent of the last 7 day outside maintenance the node was up
+	Uptime    float64           `json:"uptime"`
+	Values    []NodePublicValue `json:"values"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+type NodePublicValue struct {
+	Sensor string    `json:"sensor"`
+	Unit   string    `json:"unit"`
+	Name   string    `json:"name,omitempty"`
+	Value  float64   `json:"value"`
+	Time   time.Time `json:"time"`
+}
+
+// Permission of a shared node, write include read
+const (
+	ShareRead  = "read"
+	ShareWrite = "write"
+)
+
+// NodeShare give another user access to the node, read to see the node, its sensor and their
+// channel, write to also add and edit the sensor of the node
+type NodeShare struct {
+	IdNode     int       `json:"id_node"`
+	IdUser     int       `json:"id_user"`
+	Username   string    `json:"username"`
+	Permission string    `json:"permission"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// NodeShareCreate share the node with the user, the permission of a user already shared with is replaced
+type NodeShareCreate struct {
+	Username   string `json:"username" validate:"required"`
+	Permission string `json:"permission" validate:"required,oneof=read write"`
+}
+
+// DeletedNode is a deleted node kept with its sensor and channel until an admin restore it
+type DeletedNode struct {
+	Node
+	DeletedAt time.Time `json:"deleted_at"`
+}
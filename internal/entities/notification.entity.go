@@ -0,0 +1,32 @@
+package entities
+
+import "time"
+
+type Notification struct {
+	IdNotification int `json:"id_notification" validate:"required"`
+	IdUser         int `json:"id_user" validate:"required"`
+	NotificationCreate
+	IsRead    bool      `json:"is_read"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type NotificationCreate struct {
+	// alert, share or system
+	Type    string  `json:"type" validate:"required,oneof=alert share system"`
+	Title   string  `json:"title" validate:"required,max=255"`
+	Message string  `json:"message"`
+	Link    *string `json:"link"`
+}
+
+// NotificationBroadcast is a system message from admin, sent to every user when IdUser is nil
+type NotificationBroadcast struct {
+	IdUser  *int    `json:"id_user"`
+	Title   string  `json:"title" validate:"required,max=255"`
+	Message string  `json:"message"`
+	Link    *string `json:"link"`
+}
+
+type NotificationQuery struct {
+	Unread bool `query:"unread"`
+	Limit  int  `query:"limit" validate:"omitempty,min=1,max=200"`
+}
@@ -0,0 +1,36 @@
+package entities
+
+// Status of an OPC-UA item before the subscriber monitor it, and once it does. Otherwise the status
+// is why the server or the subscriber refused the item
+const (
+	OpcuaPending    = "pending"
+	OpcuaMonitoring = "monitoring"
+)
+
+// SensorOpcuaItem map a node of the OPC-UA server to the channel Name of the sensor
+type SensorOpcuaItem struct {
+	Name   string `json:"name"`
+	NodeId string `json:"node_id"`
+	Status string `json:"status"`
+}
+
+type SensorOpcuaItemUpdate struct {
+	Name string `json:"name" validate:"max=32"`
+	// e.g. ns=2;s=Line1.Temperature
+	NodeId string `json:"node_id" validate:"required,max=255"`
+}
+
+// SensorOpcuaUpdate replace the item of the sensor, no item stop monitoring the sensor
+type SensorOpcuaUpdate struct {
+	Items []SensorOpcuaItemUpdate `json:"items" validate:"max=64,dive"`
+}
+
+// OpcuaItem is an item monitored by the subscriber, with the owner of its sensor
+type OpcuaItem struct {
+	IdItem   int
+	IdSensor int
+	IdUser   int
+	Name     string
+	NodeId   string
+	Status   string
+}
@@ -0,0 +1,76 @@
+package entities
+
+const DefaultPageSize = 25
+
+// PageSizes is the page size option of the HTML list
+var PageSizes = []int{10, 25, 50, 100}
+
+// PageQuery is the requested page of an HTML list
+type PageQuery struct {
+	Page int `query:"page" validate:"omitempty,min=1"`
+	Size int `query:"size" validate:"omitempty,oneof=10 25 50 100"`
+}
+
+func (p *PageQuery) Limit() int {
+	if p.Size == 0 {
+		return DefaultPageSize
+	}
+	return p.Size
+}
+
+func (p *PageQuery) Offset() int {
+	if p.Page <= 1 {
+		return 0
+	}
+	return (p.Page - 1) * p.Limit()
+}
+
+// Page is the pagination control rendered under an HTML list.
+// Filter is the url query of the list filter, so the page link keep the filter
+type Page struct {
+	Number     int    `json:"number"`
+	Size       int    `json:"size"`
+	Sizes      []int  `json:"sizes"`
+	Total      int    `json:"total"`
+	TotalPages int    `json:"total_pages"`
+	From       int    `json:"from"`
+	To         int    `json:"to"`
+	Previous   int    `json:"previous"`
+	Next       int    `json:"next"`
+	Filter     string `json:"filter"`
+}
+
+// NewPage return the pagination of total item, Previous and Next is 0 when there is no such page
+func NewPage(query PageQuery, total int, filter string) Page {
+	page := Page{
+		Number: query.Page,
+		Size:   query.Limit(),
+		Sizes:  PageSizes,
+		Total:  total,
+		Filter: filter,
+	}
+	if page.Number < 1 {
+		page.Number = 1
+	}
+
+	page.TotalPages = (total + page.Size - 1) / page.Size
+	if page.TotalPages < 1 {
+		page.TotalPages = 1
+	}
+
+	if total > 0 {
+		page.From = query.Offset() + 1
+		page.To = query.Offset() + page.Size
+		if page.To > total {
+			page.To = total
+		}
+	}
+
+	if page.Number > 1 {
+		page.Previous = page.Number - 1
+	}
+	if page.Number < page.TotalPages {
+		page.Next = page.Number + 1
+	}
+	return page
+}
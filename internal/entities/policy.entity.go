@@ -0,0 +1,55 @@
+package entities
+
+import "time"
+
+// StoragePolicy move the channel of a sensor through the tier, raw for RawDays, then rolled up
+// into a RollupSeconds bucket kept until RollupDays old, then deleted.
+// A policy apply to one sensor or to every sensor with the tag, the sensor policy win over a tag
+// policy and between tag policy the oldest win
+type StoragePolicy struct {
+	IdPolicy int `json:"id_policy"`
+	StoragePolicyCreate
+	// The sensor the policy currently apply to
+	Sensors []int `json:"sensors"`
+}
+
+type StoragePolicyCreate struct {
+	Name     string  `json:"name" validate:"required"`
+	IdSensor *int    `json:"id_sensor" validate:"required_without=Tag,excluded_with=Tag"`
+	Tag      *string `json:"tag" validate:"required_without=IdSensor"`
+	RawDays  int     `json:"raw_days" validate:"required,min=1"`
+	// 0 delete the raw channel without rollup, otherwise it must divide a day
+	RollupSeconds int `json:"rollup_seconds" validate:"omitempty,min=60,max=86400"`
+	// 0 keep the rollup forever, otherwise it must be more than RawDays
+	RollupDays int `json:"rollup_days" validate:"omitempty,min=1"`
+}
+
+// StoragePolicyRun is the audit of a policy in one run of the storage-policy job
+type StoragePolicyRun struct {
+	IdRun         int       `json:"id_run"`
+	IdPolicy      int       `json:"id_policy"`
+	StartedAt     time.Time `json:"started_at"`
+	FinishedAt    time.Time `json:"finished_at"`
+	Sensors       int       `json:"sensors"`
+	RolledUp      int64     `json:"rolled_up"`
+	RawDeleted    int64     `json:"raw_deleted"`
+	RollupDeleted int64     `json:"rollup_deleted"`
+	Status        string    `json:"status"`
+	Message       string    `json:"message"`
+}
+
+// ChannelRollup summarize the channel of a sensor in a bucket of Resolution seconds
+type ChannelRollup struct {
+	IdSensor   int       `json:"id_sensor"`
+	Resolution int       `json:"resolution"`
+	Bucket     time.Time `json:"bucket"`
+	RowCount   int64     `json:"row_count"`
+	Avg        float64   `json:"avg"`
+	Min        float64   `json:"min"`
+	Max        float64   `json:"max"`
+	Last       float64   `json:"last"`
+}
+
+type SensorTagUpdate struct {
+	Tags []string `json:"tags" validate:"max=32,dive,required,max=64"`
+}
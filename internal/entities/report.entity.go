@@ -0,0 +1,52 @@
+package entities
+
+import "time"
+
+// Type of the report section
+const (
+	ReportSectionChart  = "chart"
+	ReportSectionStats  = "stats"
+	ReportSectionAlerts = "alerts"
+	ReportSectionText   = "text"
+)
+
+// Report is a document composed by the user from sections, rendered to HTML or PDF on demand and
+// emailed to its owner by the report job when it has a schedule
+type Report struct {
+	IdReport int `json:"id_report"`
+	IdUser   int `json:"id_user"`
+	ReportCreate
+	LastSent *time.Time `json:"last_sent"`
+}
+
+type ReportCreate struct {
+	Name string `json:"name" validate:"required,max=255"`
+	// Range is how far back the report show, e.g. 48h. Default to a day, a week for the weekly report,
+	// and the previous month for the monthly report
+	Range string `json:"range" validate:"max=16"`
+	// Schedule email the report at midnight UTC, every monday for weekly and the first day of the
+	// month for monthly. Empty is only rendered on demand
+	Schedule string `json:"schedule" validate:"omitempty,oneof=daily weekly monthly"`
+	// Format of the emailed report, default to html
+	Format   string          `json:"format" validate:"omitempty,oneof=html pdf"`
+	Sections []ReportSection `json:"sections" validate:"required,min=1,max=32,dive"`
+}
+
+// ReportSection is a block of the report. Chart draw each sensor, stats give the min, max, and
+// average of each sensor, and alerts list the channel outside Min and Max, or outside the alert
+// widget range of the sensor when both are nil. Text is a free paragraph
+type ReportSection struct {
+	Type    string   `json:"type" validate:"required,oneof=chart stats alerts text"`
+	Title   string   `json:"title" validate:"max=255"`
+	Sensors []int    `json:"sensors" validate:"required_unless=Type text,max=8,dive,min=1"`
+	Text    string   `json:"text" validate:"required_if=Type text,max=10000"`
+	Min     *float64 `json:"min,omitempty"`
+	Max     *float64 `json:"max,omitempty"`
+	// Limit the listed alert of each sensor, default to 20
+	Limit int `json:"limit,omitempty" validate:"omitempty,min=1,max=100"`
+}
+
+// ReportQuery render the report in [from, to) when set, otherwise the Range up to now
+type ReportQuery struct {
+	Format string `query:"format" validate:"omitempty,oneof=html pdf"`
+}
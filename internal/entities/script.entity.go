@@ -0,0 +1,57 @@
+package entities
+
+import "time"
+
+// Event a script is run on
+const (
+	// Run on the received channel after the sensor transformation, it can change value and name or
+	// call drop() and reject(reason)
+	ScriptIngest = "ingest"
+	// Run on the stored channel, it call alert(title, message) to notify the owner
+	ScriptAlert = "alert"
+)
+
+// Script is a small script of the user run on the event of one of their sensor, or of every sensor
+// when IdSensor is nil. LastError is the error of the last run, nil when it succeeded
+type Script struct {
+	IdScript int `json:"id_script"`
+	IdUser   int `json:"id_user"`
+	ScriptCreate
+	LastError *string   `json:"last_error"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type ScriptCreate struct {
+	Name     string `json:"name" validate:"required,max=64"`
+	Event    string `json:"event" validate:"required,oneof=ingest alert"`
+	IdSensor *int   `json:"id_sensor"`
+	Source   string `json:"source" validate:"required,max=8192"`
+	// Default to true
+	IsActive *bool `json:"is_active"`
+}
+
+// ScriptTest run the source on a sample channel without storing or notifying anything
+type ScriptTest struct {
+	Event   string  `json:"event" validate:"required,oneof=ingest alert"`
+	Source  string  `json:"source" validate:"required,max=8192"`
+	Value   float64 `json:"value"`
+	Name    string  `json:"name" validate:"max=32"`
+	Quality string  `json:"quality" validate:"omitempty,oneof=good suspect calibrating out-of-range"`
+}
+
+// ScriptAlertCall is one alert() call of an alert script
+type ScriptAlertCall struct {
+	Title   string `json:"title"`
+	Message string `json:"message"`
+}
+
+// ScriptResult is the channel after the script, and what the script did
+type ScriptResult struct {
+	Value   float64           `json:"value"`
+	Name    string            `json:"name"`
+	Dropped bool              `json:"dropped"`
+	Reject  *string           `json:"reject"`
+	Alerts  []ScriptAlertCall `json:"alerts"`
+	Error   *string           `json:"error"`
+}
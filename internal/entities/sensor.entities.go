@@ -1,20 +1,129 @@
 package entities
 
+import "time"
+
 type Sensor struct {
 	IdSensor int `json:"id_sensor" validate:"required"`
 	SensorCreate
+	// How the client show the channel of the sensor, set for the API response
+	Display []ChannelDisplay `json:"display,omitempty"`
+}
+
+// ChannelDisplay is the label, unit and decimal count a client show a channel of the sensor with,
+// Name is the channel name and "" the unnamed channel. Precision nil show the value as it is
+type ChannelDisplay struct {
+	Name      string `json:"name" validate:"max=32"`
+	Label     string `json:"label" validate:"max=255"`
+	Unit      string `json:"unit" validate:"max=32"`
+	Precision *int   `json:"precision" validate:"omitempty,min=0,max=10"`
+}
+
+// ChannelDisplay return the display of the channel name, a channel without display is labeled with
+// its name, or the sensor name for the unnamed channel, in the sensor unit
+func (s *Sensor) ChannelDisplay(name string) ChannelDisplay {
+	for _, display := range s.Display {
+		if display.Name == name {
+			return display
+		}
+	}
+	if name == "" {
+		return ChannelDisplay{Name: name, Label: s.Name, Unit: s.Unit}
+	}
+	return ChannelDisplay{Name: name, Label: name, Unit: s.Unit}
+}
+
+type SensorDisplayUpdate struct {
+	Display []ChannelDisplay `json:"display" validate:"dive"`
 }
 
 type SensorCreate struct {
-	Name       string `json:"name" validate:"required"`
-	Unit       string `json:"unit" validate:"required"`
-	IdNode     int    `json:"id_node" validate:"required"`
-	IdHardware int    `json:"id_hardware" validate:"required"`
+	Name       string `json:"name" form:"name" validate:"required"`
+	Unit       string `json:"unit" form:"unit" validate:"required"`
+	IdNode     int    `json:"id_node" form:"id_node" validate:"required"`
+	IdHardware int    `json:"id_hardware" form:"id_hardware" validate:"required"`
+	// Range the sensor can measure in its unit, a nil bound is open
+	MinValue *float64 `json:"min_value" form:"min_value"`
+	MaxValue *float64 `json:"max_value" form:"max_value"`
+	// The received value is stored as value*calibration_scale+calibration_offset, 1 and 0 when omitted
+	CalibrationScale  *float64 `json:"calibration_scale" form:"calibration_scale" validate:"omitempty,ne=0"`
+	CalibrationOffset *float64 `json:"calibration_offset" form:"calibration_offset"`
+}
+
+// ValidRange is false when the minimum of the range is more than its maximum
+func (s *SensorCreate) ValidRange() bool {
+	return s.MinValue == nil || s.MaxValue == nil || *s.MinValue <= *s.MaxValue
+}
+
+// SensorBatch is the body of POST /node/{id}/sensors/batch, an array of sensor. The id_node of a
+// sensor default to the node of the path, each sensor is validated by the handler so the result
+// tell which one is invalid
+type SensorBatch struct {
+	Sensors []SensorCreate `validate:"min=1,max=100"`
+}
+
+// SensorBatchResult is the result of the sensor at Index of the batch. Status is 201 when it is
+// created, and 424 when it is valid but nothing is created because another sensor isn't
+type SensorBatchResult struct {
+	Index    int    `json:"index"`
+	IdSensor *int   `json:"id_sensor"`
+	Status   int    `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// SensorQuery filter the sensor list, Search match the name or unit and Name only the name
+type SensorQuery struct {
+	Search     string `query:"q"`
+	Name       string `query:"name"`
+	IdNode     int    `query:"id_node" validate:"omitempty,min=1"`
+	IdHardware int    `query:"id_hardware" validate:"omitempty,min=1"`
+	// Set by the handler for the paginated list, 0 Limit return every sensor
+	Limit  int `query:"-"`
+	Offset int `query:"-"`
+	// Return the sensor after this one in the name order, set by the handler from the cursor
+	After *SensorCursor `query:"-"`
+}
+
+// SensorCursor is the last sensor of a page of the JSON list, it is sent base64 encoded
+type SensorCursor struct {
+	Name     string `json:"name"`
+	IdSensor int    `json:"id_sensor"`
+}
+
+// SensorListQuery paginate the JSON sensor list, either by Offset or by the next_cursor of the
+// previous page. Without Limit every sensor is returned as an array
+type SensorListQuery struct {
+	Limit  int    `query:"limit" validate:"omitempty,min=1,max=1000"`
+	Offset int    `query:"offset" validate:"omitempty,min=0"`
+	Cursor string `query:"cursor"`
+}
+
+// SensorList is a page of the JSON sensor list, Total count every sensor matching the filter and
+// NextCursor is empty on the last page
+type SensorList struct {
+	Total      int      `json:"total"`
+	Limit      int      `json:"limit"`
+	Offset     int      `json:"offset"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+	Sensors    []Sensor `json:"sensors"`
+}
+
+// SensorMerge is the sensor the duplicate sensor is merged into
+type SensorMerge struct {
+	IdTarget int `json:"id_target" validate:"required"`
+}
+
+// SensorMove is the node the sensor is moved to
+type SensorMove struct {
+	IdNode int `json:"id_node" validate:"required"`
 }
 
 type SensorUpdate struct {
-	Name string `json:"name"`
-	Unit string `json:"unit"`
+	Name              string   `json:"name" form:"name"`
+	Unit              string   `json:"unit" form:"unit"`
+	MinValue          *float64 `json:"min_value" form:"min_value"`
+	MaxValue          *float64 `json:"max_value" form:"max_value"`
+	CalibrationScale  *float64 `json:"calibration_scale" form:"calibration_scale" validate:"omitempty,ne=0"`
+	CalibrationOffset *float64 `json:"calibration_offset" form:"calibration_offset"`
 }
 
 func (su *SensorUpdate) ChangeSettedFieldOnly(sensor *Sensor) {
@@ -25,9 +134,197 @@ func (su *SensorUpdate) ChangeSettedFieldOnly(sensor *Sensor) {
 	if su.Unit == "" {
 		su.Unit = sensor.Unit
 	}
+
+	if su.MinValue == nil {
+		su.MinValue = sensor.MinValue
+	}
+
+	if su.MaxValue == nil {
+		su.MaxValue = sensor.MaxValue
+	}
+
+	if su.CalibrationScale == nil {
+		su.CalibrationScale = sensor.CalibrationScale
+	}
+
+	if su.CalibrationOffset == nil {
+		su.CalibrationOffset = sensor.CalibrationOffset
+	}
+}
+
+// Apply set the updated field on the sensor, after ChangeSettedFieldOnly
+func (su *SensorUpdate) Apply(sensor *Sensor) {
+	sensor.Name = su.Name
+	sensor.Unit = su.Unit
+	sensor.MinValue = su.MinValue
+	sensor.MaxValue = su.MaxValue
+	sensor.CalibrationScale = su.CalibrationScale
+	sensor.CalibrationOffset = su.CalibrationOffset
 }
 
 type SensorWithChannel struct {
 	Sensor
 	Channel []Channel `json:"channel"`
 }
+
+// SensorChartQuery is the option of the chart image, the range is given as the channel query
+type SensorChartQuery struct {
+	Width  int    `query:"width" validate:"omitempty,min=100,max=4000"`
+	Height int    `query:"height" validate:"omitempty,min=100,max=2000"`
+	Theme  string `query:"theme" validate:"omitempty,oneof=light dark"`
+	Title  string `query:"title" validate:"omitempty,oneof=true false"`
+}
+
+// SensorEmbedQuery is the option of the embedded chart, given as query of the iframe url
+type SensorEmbedQuery struct {
+	Range  string `query:"range"`
+	Width  int    `query:"width" validate:"omitempty,min=100,max=4000"`
+	Height int    `query:"height" validate:"omitempty,min=100,max=2000"`
+	Theme  string `query:"theme" validate:"omitempty,oneof=light dark system"`
+	Title  string `query:"title" validate:"omitempty,oneof=true false"`
+}
+
+// SensorCompression is the compression setting of the sensor and the size of its compressed channel
+type SensorCompression struct {
+	IdSensor int `json:"id_sensor"`
+	// Compress the channel older than this many days, 0 never compress, nil use the server default
+	AfterDays        *int  `json:"after_days"`
+	DefaultAfterDays int   `json:"default_after_days"`
+	Days             int64 `json:"days"`
+	Rows             int64 `json:"rows"`
+	Bytes            int64 `json:"bytes"`
+	// Estimated size of the compressed rows if they were in the channel table
+	RowBytes int64 `json:"row_bytes"`
+}
+
+type SensorCompressionUpdate struct {
+	AfterDays *int `json:"after_days" validate:"omitempty,min=0"`
+}
+
+// Action of the validation rule on a channel that violate it
+const (
+	ValidationFlag   = "flag"
+	ValidationReject = "reject"
+)
+
+// SensorValidation is the acceptable value of the sensor channel, a nil bound is not checked.
+// MaxStep is the largest change from the last good channel. Flagged and Rejected count the
+// channel that violated the rule
+type SensorValidation struct {
+	IdSensor int      `json:"id_sensor"`
+	Min      *float64 `json:"min"`
+	Max      *float64 `json:"max"`
+	MaxStep  *float64 `json:"max_step"`
+	Action   string   `json:"action"`
+	Flagged  int64    `json:"flagged"`
+	Rejected int64    `json:"rejected"`
+}
+
+type SensorValidationUpdate struct {
+	Min     *float64 `json:"min"`
+	Max     *float64 `json:"max"`
+	MaxStep *float64 `json:"max_step" validate:"omitempty,gt=0"`
+	Action  string   `json:"action" validate:"omitempty,oneof=flag reject"`
+}
+
+// Ingest filter of a noisy sensor, see SensorFilter
+const (
+	FilterOff    = "off"
+	FilterMedian = "median"
+	FilterHampel = "hampel"
+)
+
+// SensorFilter smooth the channel when it is received over the last Window channel. The median
+// filter store the median of the window, the Hampel filter replace the value by the median only
+// when it is more than Threshold scaled MAD away from it
+type SensorFilter struct {
+	IdSensor  int     `json:"id_sensor"`
+	Method    string  `json:"method"`
+	Window    int     `json:"window"`
+	Threshold float64 `json:"threshold"`
+}
+
+type SensorFilterUpdate struct {
+	Method    string  `json:"method" validate:"required,oneof=off median hampel"`
+	Window    int     `json:"window" validate:"omitempty,min=3,max=51"`
+	Threshold float64 `json:"threshold" validate:"omitempty,gt=0"`
+}
+
+// What happen to a channel of a throttled sensor received sooner than its minimum interval
+const (
+	ThrottleReject   = "reject"
+	ThrottleCoalesce = "coalesce"
+)
+
+// SensorThrottle limit how often a channel of the same name is stored for the sensor. A channel
+// received within MinIntervalMs of the last one is rejected with 429, or coalesced by replacing the
+// value of the last one. Throttled count them
+type SensorThrottle struct {
+	IdSensor      int    `json:"id_sensor"`
+	MinIntervalMs int    `json:"min_interval_ms"`
+	Mode          string `json:"mode"`
+	Throttled     int64  `json:"throttled"`
+}
+
+// SensorThrottleUpdate with MinIntervalMs 0 remove the throttle
+type SensorThrottleUpdate struct {
+	MinIntervalMs int    `json:"min_interval_ms" validate:"min=0,max=86400000"`
+	Mode          string `json:"mode" validate:"omitempty,oneof=reject coalesce"`
+}
+
+// SensorRetention delete the channel of the sensor older than KeepDays with the channel-retention job,
+// Purged count every channel it deleted and LastPurged those of the last run
+type SensorRetention struct {
+	IdSensor   int        `json:"id_sensor"`
+	KeepDays   int        `json:"keep_days"`
+	Purged     int64      `json:"purged"`
+	LastPurged int64      `json:"last_purged"`
+	LastRunAt  *time.Time `json:"last_run_at"`
+}
+
+// SensorRetentionUpdate with KeepDays 0 keep the channel forever
+type SensorRetentionUpdate struct {
+	KeepDays int `json:"keep_days" validate:"min=0,max=36500"`
+}
+
+// Step of the sensor transformation, see SensorTransformStep
+const (
+	TransformScale   = "scale"
+	TransformClamp   = "clamp"
+	TransformConvert = "convert"
+	TransformRename  = "rename"
+	TransformDrop    = "drop"
+)
+
+// SensorTransformStep change the received channel before it is stored. Scale store
+// value*Factor+Offset, clamp limit the value to [Min, Max], convert change the unit From to the unit
+// To, rename change the channel name to Name and drop doesn't store the channel
+type SensorTransformStep struct {
+	// Name of the channel the step apply to after the previous step, every channel when omitted
+	Channel *string  `json:"channel,omitempty" validate:"omitempty,max=32"`
+	Type    string   `json:"type" validate:"required,oneof=scale clamp convert rename drop"`
+	Factor  *float64 `json:"factor,omitempty"`
+	Offset  *float64 `json:"offset,omitempty"`
+	Min     *float64 `json:"min,omitempty"`
+	Max     *float64 `json:"max,omitempty"`
+	From    string   `json:"from,omitempty" validate:"max=16"`
+	To      string   `json:"to,omitempty" validate:"max=16"`
+	Name    *string  `json:"name,omitempty" validate:"omitempty,max=32"`
+}
+
+// SensorTransform is the ordered step run on the channel of the sensor when it is received
+type SensorTransform struct {
+	IdSensor int                   `json:"id_sensor"`
+	Steps    []SensorTransformStep `json:"steps"`
+}
+
+// SensorTransformUpdate replace the step of the sensor, no step remove the transformation
+type SensorTransformUpdate struct {
+	Steps []SensorTransformStep `json:"steps" validate:"max=32,dive"`
+}
+
+// DeletedSensor is a deleted sensor kept with its channel until an admin restore it
+type DeletedSensor struct {
+	Sensor
+	DeletedAt time.Time `json:"deleted_at"`
+}
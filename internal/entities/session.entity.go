@@ -0,0 +1,30 @@
+package entities
+
+import "time"
+
+// RefreshTokenPrefix start every refresh token, so a leaked token is easy to recognize
+const RefreshTokenPrefix = "rt_"
+
+// UserSession is a login of the user, the access token carry its id and is refused once it is
+// revoked. It expire when it isn't used for jwt.refreshDays, only the hash of its refresh token is kept
+type UserSession struct {
+	IdSession  string    `json:"id_session"`
+	IdUser     int       `json:"id_user"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	// The session of the token the list is requested with
+	Current bool `json:"current"`
+}
+
+// UserTokens is the JSON answer of the login and the refresh. ExpiresIn is the access token lifetime
+// in second, 0 when it doesn't expire
+type UserTokens struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+type UserRefresh struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
@@ -0,0 +1,21 @@
+package entities
+
+// SigfoxDevice link the Sigfox device to the node, its uplink is decoded by the node hardware decoder
+type SigfoxDevice struct {
+	IdNode   int     `json:"id_node"`
+	DeviceId *string `json:"device_id"`
+}
+
+// SigfoxDeviceUpdate with an empty DeviceId unlink the device
+type SigfoxDeviceUpdate struct {
+	DeviceId string `json:"device_id" validate:"omitempty,hexadecimal,max=8"`
+}
+
+// SigfoxCallback is the body of the Sigfox data callback, configured in the Sigfox backend as
+// {"device": "{device}", "time": {time}, "data": "{data}", "seqNumber": {seqNumber}}
+type SigfoxCallback struct {
+	Device    string `json:"device" validate:"required,hexadecimal,max=8"`
+	Time      int64  `json:"time"`
+	Data      string `json:"data" validate:"required,hexadecimal,max=24"`
+	SeqNumber int    `json:"seqNumber"`
+}
@@ -0,0 +1,48 @@
+package entities
+
+import "time"
+
+// NodeMaintenance is a planned window when the node is expected to be down, it is left out of the
+// node SLA
+type NodeMaintenance struct {
+	IdMaintenance int `json:"id_maintenance"`
+	IdNode        int `json:"id_node"`
+	NodeMaintenanceCreate
+}
+
+type NodeMaintenanceCreate struct {
+	Start  time.Time `json:"start" validate:"required"`
+	End    time.Time `json:"end" validate:"required,gtfield=Start"`
+	Reason string    `json:"reason" validate:"max=255"`
+}
+
+// SlaQuery select the month of the SLA report, month is YYYY-MM and default to the current month
+type SlaQuery struct {
+	Month  string `query:"month" validate:"omitempty,datetime=2006-01"`
+	Format string `query:"format" validate:"omitempty,oneof=json csv"`
+}
+
+// TimeWindow is the time range [Start, End)
+type TimeWindow struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// NodeSlaReport is the availability of a node in a month. The node is down when none of its sensor
+// sent a channel in the last 15 minutes, like the node status, and the maintenance window is not counted
+type NodeSlaReport struct {
+	Month  string    `json:"month"`
+	IdNode int       `json:"id_node"`
+	IdUser int       `json:"id_user"`
+	Name   string    `json:"name"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	// Percent of the time outside maintenance the node was up
+	Availability       float64 `json:"availability"`
+	DowntimeSeconds    int64   `json:"downtime_seconds"`
+	MaintenanceSeconds int64   `json:"maintenance_seconds"`
+	// Channel outside the range of an alert widget of the node sensor, outside maintenance
+	Alerts       int64             `json:"alerts"`
+	Downtimes    []TimeWindow      `json:"downtimes"`
+	Maintenances []NodeMaintenance `json:"maintenances"`
+}
@@ -0,0 +1,69 @@
+package entities
+
+// Status of an SNMP item before it is first read, and once it is read. Otherwise the status is why
+// the last read failed
+const (
+	SnmpPending = "pending"
+	SnmpOk      = "ok"
+)
+
+// SensorSnmp is the device polled for the sensor, the community and password aren't returned
+type SensorSnmp struct {
+	Address      string           `json:"address"`
+	Version      string           `json:"version"`
+	Username     string           `json:"username"`
+	AuthProtocol string           `json:"auth_protocol"`
+	PrivProtocol string           `json:"priv_protocol"`
+	Items        []SensorSnmpItem `json:"items"`
+}
+
+// SensorSnmpItem map an OID of the device to the channel Name of the sensor
+type SensorSnmpItem struct {
+	Name   string `json:"name"`
+	Oid    string `json:"oid"`
+	Status string `json:"status"`
+}
+
+type SensorSnmpItemUpdate struct {
+	Name string `json:"name" validate:"max=32"`
+	// Dotted OID of a scalar, e.g. 1.3.6.1.2.1.33.1.2.4.0
+	Oid string `json:"oid" validate:"required,max=255"`
+}
+
+// SensorSnmpUpdate replace the device and item of the sensor, no item stop polling the sensor.
+// The community and password are sent again on every update
+type SensorSnmpUpdate struct {
+	// IP address or host name of the device with an optional port, 161 by default
+	Address   string `json:"address" validate:"max=255"`
+	Version   string `json:"version" validate:"omitempty,oneof=2c 3"`
+	Community string `json:"community" validate:"max=64"`
+	// SNMPv3 user, noAuthNoPriv without auth protocol and authNoPriv without priv protocol
+	Username     string                 `json:"username" validate:"max=32"`
+	AuthProtocol string                 `json:"auth_protocol" validate:"omitempty,oneof=md5 sha sha256"`
+	AuthPassword string                 `json:"auth_password" validate:"max=64"`
+	PrivProtocol string                 `json:"priv_protocol" validate:"omitempty,oneof=des aes"`
+	PrivPassword string                 `json:"priv_password" validate:"max=64"`
+	Items        []SensorSnmpItemUpdate `json:"items" validate:"max=64,dive"`
+}
+
+// SnmpDevice is a device polled by the poller with its item and the owner of its sensor
+type SnmpDevice struct {
+	IdSensor     int
+	IdUser       int
+	Address      string
+	Version      string
+	Community    string
+	Username     string
+	AuthProtocol string
+	AuthPassword string
+	PrivProtocol string
+	PrivPassword string
+	Items        []SnmpItem
+}
+
+type SnmpItem struct {
+	IdItem int
+	Name   string
+	Oid    string
+	Status string
+}
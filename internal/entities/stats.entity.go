@@ -0,0 +1,47 @@
+package entities
+
+import "time"
+
+// PlatformStats is the platform health shown on the admin statistics page
+type PlatformStats struct {
+	Users    int   `json:"users"`
+	Nodes    int   `json:"nodes"`
+	Sensors  int   `json:"sensors"`
+	Channels int64 `json:"channels"`
+	// Channel received in the last minute and hour, by channel time
+	IngestLastMinute int64         `json:"ingest_last_minute"`
+	IngestLastHour   int64         `json:"ingest_last_hour"`
+	IngestPerHour    []StatsBucket `json:"ingest_per_hour"`
+	ChannelPerDay    []StatsBucket `json:"channel_per_day"`
+	TableSizes       []TableSize   `json:"table_sizes"`
+	TopTalkers       []TopTalker   `json:"top_talkers"`
+	RecentErrors     []RecentError `json:"recent_errors"`
+}
+
+type StatsBucket struct {
+	Time  time.Time `json:"time"`
+	Count int64     `json:"count"`
+}
+
+type TableSize struct {
+	Name  string `json:"name"`
+	Bytes int64  `json:"bytes"`
+}
+
+// TopTalker is a sensor with the most channel in the last 24 hours
+type TopTalker struct {
+	IdSensor int    `json:"id_sensor"`
+	Sensor   string `json:"sensor"`
+	IdNode   int    `json:"id_node"`
+	Node     string `json:"node"`
+	Username string `json:"username"`
+	Count    int64  `json:"count"`
+}
+
+type RecentError struct {
+	Time    time.Time `json:"time"`
+	Method  string    `json:"method"`
+	Path    string    `json:"path"`
+	Code    int       `json:"code"`
+	Message string    `json:"message"`
+}
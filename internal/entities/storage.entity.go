@@ -0,0 +1,41 @@
+package entities
+
+// StorageReport break down the channel storage per user. Byte is an estimate, the size of the
+// channel table (with its index) spread evenly over its rows
+type StorageReport struct {
+	ChannelBytes    int64         `json:"channel_bytes"`
+	Channels        int64         `json:"channels"`
+	BytesPerChannel float64       `json:"bytes_per_channel"`
+	Users           []UserStorage `json:"users"`
+}
+
+type UserStorage struct {
+	IdUser   int    `json:"id_user"`
+	Username string `json:"username"`
+	Nodes    int    `json:"nodes"`
+	Sensors  int    `json:"sensors"`
+	Channels int64  `json:"channels"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// UserStorageDetail is the storage of a user per node and sensor
+type UserStorageDetail struct {
+	User            UserStorage   `json:"user"`
+	BytesPerChannel float64       `json:"bytes_per_channel"`
+	Nodes           []NodeStorage `json:"nodes"`
+}
+
+type NodeStorage struct {
+	IdNode   int             `json:"id_node"`
+	Name     string          `json:"name"`
+	Channels int64           `json:"channels"`
+	Bytes    int64           `json:"bytes"`
+	Sensors  []SensorStorage `json:"sensors"`
+}
+
+type SensorStorage struct {
+	IdSensor int    `json:"id_sensor"`
+	Name     string `json:"name"`
+	Channels int64  `json:"channels"`
+	Bytes    int64  `json:"bytes"`
+}
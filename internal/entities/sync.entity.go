@@ -0,0 +1,115 @@
+package entities
+
+import "time"
+
+// Conflict rule of a site, applied by the central instance to the entity of the edge
+const (
+	// The edge own its entity, an edit on the central is overwritten and a deleted entity is created again
+	SyncConflictEdge = "edge"
+	// The entity is only created once, an edit on the central is kept and a deleted entity stay
+	// deleted with its reading dropped
+	SyncConflictCentral = "central"
+)
+
+// SyncBatch is what an edge instance push to the central instance, its hardware, node and sensor
+// with their edge id and the reading stored since the last push. The channel of a sensor is sent by
+// time order
+type SyncBatch struct {
+	Site     string         `json:"site" validate:"required,max=64"`
+	Hardware []SyncHardware `json:"hardware" validate:"max=1000,dive"`
+	Nodes    []SyncNode     `json:"nodes" validate:"max=10000,dive"`
+	Sensors  []SyncSensor   `json:"sensors" validate:"max=10000,dive"`
+	Channels []SyncChannel  `json:"channels" validate:"max=10000,dive"`
+}
+
+type SyncHardware struct {
+	Id int `json:"id" validate:"required"`
+	HardwareCreate
+}
+
+type SyncNode struct {
+	Id         int    `json:"id" validate:"required"`
+	Name       string `json:"name" validate:"required"`
+	Location   string `json:"location" validate:"required"`
+	IdHardware int    `json:"id_hardware" validate:"required"`
+}
+
+type SyncSensor struct {
+	Id         int    `json:"id" validate:"required"`
+	Name       string `json:"name" validate:"required"`
+	Unit       string `json:"unit" validate:"required"`
+	IdNode     int    `json:"id_node" validate:"required"`
+	IdHardware int    `json:"id_hardware" validate:"required"`
+}
+
+type SyncChannel struct {
+	IdSensor int       `json:"id_sensor" validate:"required"`
+	Time     time.Time `json:"time" validate:"required"`
+	Name     string    `json:"name,omitempty" validate:"omitempty,max=32"`
+	Value    float64   `json:"value"`
+	Quality  string    `json:"quality,omitempty" validate:"omitempty,oneof=good suspect calibrating out-of-range"`
+}
+
+// SyncResult is the answer of the central instance to a push
+type SyncResult struct {
+	Created  int `json:"created"`
+	Updated  int `json:"updated"`
+	Channels int `json:"channels"`
+	// Channel already received, of an entity deleted on the central or rejected by the sensor validation rule
+	Skipped int `json:"skipped"`
+}
+
+// SyncSite is an edge instance that pushed to this central instance
+type SyncSite struct {
+	Site       string     `json:"site"`
+	IdUser     int        `json:"id_user"`
+	Conflict   string     `json:"conflict"`
+	LastSyncAt *time.Time `json:"last_sync_at"`
+	Channels   int64      `json:"channels"`
+	Nodes      int        `json:"nodes"`
+	Sensors    int        `json:"sensors"`
+}
+
+type SyncSiteUpdate struct {
+	Conflict string `json:"conflict" validate:"required,oneof=edge central"`
+}
+
+// SyncStatus is the push state of this edge instance, Cursor is the time of the last pushed channel
+type SyncStatus struct {
+	Enabled       bool       `json:"enabled"`
+	Url           string     `json:"url"`
+	Site          string     `json:"site"`
+	Cursor        *time.Time `json:"cursor"`
+	LastAttemptAt *time.Time `json:"last_attempt_at"`
+	LastSuccessAt *time.Time `json:"last_success_at"`
+	LastError     *string    `json:"last_error"`
+	Pushed        int64      `json:"pushed"`
+	// Channel stored after the cursor, waiting for the next push
+	Pending int64 `json:"pending"`
+}
+
+// SyncDelta is what changed for a client since its cursor, Cursor is given back as since in the next
+// request. Full is set when the client sent no cursor, the entity is then every entity of the user.
+// A changed node is sent with every sensor of it
+type SyncDelta struct {
+	Cursor   time.Time     `json:"cursor"`
+	Full     bool          `json:"full"`
+	More     bool          `json:"more"`
+	Hardware []Hardware    `json:"hardware"`
+	Nodes    []Node        `json:"nodes"`
+	Sensors  []Sensor      `json:"sensors"`
+	Deleted  SyncDeleted   `json:"deleted"`
+	Readings []SyncReading `json:"readings"`
+}
+
+// SyncDeleted is the id of the entity deleted or no longer visible to the client, a deleted node
+// delete its sensor
+type SyncDeleted struct {
+	Hardware []int `json:"hardware"`
+	Nodes    []int `json:"nodes"`
+	Sensors  []int `json:"sensors"`
+}
+
+// SyncReading is a channel as [id_sensor, time in epoch milliseconds, value] with its name appended
+// when it has one
+type SyncReading []interface{}
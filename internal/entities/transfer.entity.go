@@ -0,0 +1,31 @@
+package entities
+
+import "time"
+
+// Status of a node transfer, only a pending transfer can be accepted, declined or cancelled
+const (
+	TransferPending   = "pending"
+	TransferAccepted  = "accepted"
+	TransferDeclined  = "declined"
+	TransferCancelled = "cancelled"
+)
+
+// NodeTransfer is the offer of the node owner to give the node, with its sensor and their channel,
+// to another user. The node change owner when the user accept it
+type NodeTransfer struct {
+	IdTransfer   int        `json:"id_transfer"`
+	IdNode       int        `json:"id_node"`
+	NodeName     string     `json:"node_name"`
+	IdFromUser   int        `json:"id_from_user"`
+	FromUsername string     `json:"from_username"`
+	IdToUser     int        `json:"id_to_user"`
+	ToUsername   string     `json:"to_username"`
+	Status       string     `json:"status"`
+	CreatedAt    time.Time  `json:"created_at"`
+	RespondedAt  *time.Time `json:"responded_at"`
+}
+
+type NodeTransferCreate struct {
+	IdNode   int    `json:"id_node" validate:"required"`
+	Username string `json:"username" validate:"required"`
+}
@@ -0,0 +1,33 @@
+package entities
+
+import "time"
+
+// Metered usage, counted per user and rolled up per calendar month (UTC)
+const (
+	UsageApiCalls          = "api_calls"
+	UsagePointsStored      = "points_stored"
+	UsageNotificationsSent = "notifications_sent"
+)
+
+// UsageQuery select the month of the usage export, month is YYYY-MM and default to the current month
+type UsageQuery struct {
+	Month  string `query:"month" validate:"omitempty,datetime=2006-01"`
+	Format string `query:"format" validate:"omitempty,oneof=json csv"`
+}
+
+// UsageReport is one invoice line, the usage of a user in a month
+type UsageReport struct {
+	Month             string `json:"month"`
+	IdUser            int    `json:"id_user"`
+	Username          string `json:"username"`
+	Email             string `json:"email"`
+	ApiCalls          int64  `json:"api_calls"`
+	PointsStored      int64  `json:"points_stored"`
+	NotificationsSent int64  `json:"notifications_sent"`
+}
+
+type UsageExport struct {
+	Month      string        `json:"month"`
+	ExportedAt time.Time     `json:"exported_at"`
+	Users      []UsageReport `json:"users"`
+}
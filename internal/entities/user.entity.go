@@ -23,6 +23,8 @@ type UserRead struct {
 	Status   bool   `json:"status" validate:"required"`
 	Token    string `json:"token" validate:"required"`
 	IsAdmin  bool   `json:"is_admin" validate:"required"`
+	// Session of the access token, set when it is validated
+	SessionId string `json:"-"`
 }
 
 type UserLogin struct {
@@ -40,6 +42,27 @@ type UserUpdatePassword struct {
 	NewPassword string `json:"new_password" validate:"required"`
 }
 
+type UserUpdateEmail struct {
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required"`
+}
+
+// UserProfile is the profile page of the current user
+type UserProfile struct {
+	UserRead
+	Theme    string  `json:"theme"`
+	Language *string `json:"language"`
+	Digest   bool    `json:"digest"`
+}
+
+type UserTheme struct {
+	Theme string `json:"theme" validate:"required,oneof=light dark system"`
+}
+
+type UserLanguage struct {
+	Language string `json:"language" validate:"required,oneof=en id"`
+}
+
 type UserValidate struct {
 	Token string `query:"token" validate:"required"`
 }
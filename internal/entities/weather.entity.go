@@ -0,0 +1,48 @@
+package entities
+
+// Status of a weather item before it is first fetched, and once it is. Otherwise the status is why
+// the last fetch failed
+const (
+	WeatherPending = "pending"
+	WeatherOk      = "ok"
+)
+
+// WeatherUnits is the unit of the virtual sensor created for each weather variable
+var WeatherUnits = map[string]string{
+	"temperature":   "°C",
+	"humidity":      "%",
+	"pressure":      "hPa",
+	"wind_speed":    "m/s",
+	"precipitation": "mm",
+	"cloud_cover":   "%",
+}
+
+// NodeWeatherItem is a weather variable stored in the virtual sensor IdSensor of the node
+type NodeWeatherItem struct {
+	Variable string `json:"variable"`
+	IdSensor int    `json:"id_sensor"`
+	Status   string `json:"status"`
+}
+
+// NodeWeatherUpdate replace the variable fetched for the node, no variable stop fetching the weather
+// of the node. The virtual sensor of a new variable is created with the IdHardware sensor hardware,
+// the sensor of a removed variable is kept with its channel
+type NodeWeatherUpdate struct {
+	IdHardware int      `json:"id_hardware" validate:"omitempty,min=1"`
+	Variables  []string `json:"variables" validate:"max=6,dive,oneof=temperature humidity pressure wind_speed precipitation cloud_cover"`
+}
+
+// WeatherNode is a node fetched by the weather job with its item and owner
+type WeatherNode struct {
+	IdNode   int
+	IdUser   int
+	Location string
+	Items    []WeatherItem
+}
+
+type WeatherItem struct {
+	IdItem   int
+	IdSensor int
+	Variable string
+	Status   string
+}
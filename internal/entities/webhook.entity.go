@@ -0,0 +1,73 @@
+package entities
+
+import "time"
+
+// Entity lifecycle event sent to the webhook
+const (
+	EventUserRegistered = "user.registered"
+	EventUserDeleted    = "user.deleted"
+	EventNodeCreated    = "node.created"
+	EventNodeUpdated    = "node.updated"
+	EventNodeDeleted    = "node.deleted"
+	EventSensorCreated  = "sensor.created"
+	EventSensorUpdated  = "sensor.updated"
+	EventSensorDeleted  = "sensor.deleted"
+	// EventAll subscribe the webhook to every event
+	EventAll = "*"
+)
+
+var WebhookEvents = []string{
+	EventUserRegistered, EventUserDeleted,
+	EventNodeCreated, EventNodeUpdated, EventNodeDeleted,
+	EventSensorCreated, EventSensorUpdated, EventSensorDeleted,
+}
+
+// Webhook receive the event of the entity owned by its user, the webhook of an admin receive
+// the event of every user
+type Webhook struct {
+	IdWebhook int       `json:"id_webhook"`
+	IdUser    int       `json:"id_user"`
+	Url       string    `json:"url"`
+	Events    []string  `json:"events"`
+	IsActive  bool      `json:"is_active"`
+	CreatedAt time.Time `json:"created_at"`
+	// Only returned when the webhook is created
+	Secret string `json:"secret,omitempty"`
+}
+
+type WebhookCreate struct {
+	Url      string   `json:"url" validate:"required,url,max=2048"`
+	Events   []string `json:"events" validate:"required,min=1,dive,required"`
+	IsActive *bool    `json:"is_active"`
+}
+
+// WebhookDelivery is one event sent to a webhook, retried with backoff until delivered or failed
+type WebhookDelivery struct {
+	IdDelivery    int64      `json:"id_delivery"`
+	IdWebhook     int        `json:"id_webhook"`
+	Event         string     `json:"event"`
+	Payload       string     `json:"payload"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	ResponseCode  *int       `json:"response_code"`
+	LastError     string     `json:"last_error"`
+	CreatedAt     time.Time  `json:"created_at"`
+	DeliveredAt   *time.Time `json:"delivered_at"`
+}
+
+// WebhookPayload is the body posted to the webhook, Data is the entity after the change or
+// before it is deleted
+type WebhookPayload struct {
+	Event      string      `json:"event"`
+	OccurredAt time.Time   `json:"occurred_at"`
+	Data       interface{} `json:"data"`
+}
+
+// WebhookUser is the user in the user event, without its password and token
+type WebhookUser struct {
+	IdUser   int    `json:"id_user"`
+	Email    string `json:"email"`
+	Username string `json:"username"`
+	IsAdmin  bool   `json:"is_admin"`
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+)
+
+// checkSensorAccess return forbidden error with the message if the current user is not admin, not the
+// sensor owner and the sensor node is not shared with them with the permission
+func checkSensorAccess(ctx context.Context, tx helper.Querier, nodeRepository *repositories.NodeRepository, currentUser *entities.UserRead, sensorId int, permission string, message string) error {
+	allowed, err := nodeRepository.HasSensorAccess(ctx, tx, sensorId, currentUser, permission)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return fiber.NewError(403, message)
+	}
+	return nil
+}
+
+// validateSensorAccess is checkSensorAccess for the authenticated user of the request
+func validateSensorAccess(ctx context.Context, c *fiber.Ctx, validator *dependencies.Validator, tx helper.Querier, nodeRepository *repositories.NodeRepository, sensorId int, permission string, message string) error {
+	currentUser, err := validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+	return checkSensorAccess(ctx, tx, nodeRepository, &currentUser, sensorId, permission, message)
+}
+
+// getOwnNode return the node of the id url parameter, or forbidden error with the message if the
+// current user is not admin and not the node owner. A shared node is not enough, it is for what only
+// the owner can manage like the share, API key, firmware and status page
+func getOwnNode(ctx context.Context, c *fiber.Ctx, validator *dependencies.Validator, tx helper.Querier, nodeRepository *repositories.NodeRepository, message string) (node entities.Node, err error) {
+	id, err := validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return node, err
+	}
+
+	node, err = nodeRepository.GetById(ctx, tx, id)
+	if err != nil {
+		return node, err
+	}
+
+	currentUser, err := validator.GetAuthentication(c)
+	if err != nil {
+		return node, err
+	}
+
+	if node.IdUser != currentUser.IdUser && !currentUser.IsAdmin {
+		return node, fiber.NewError(403, message)
+	}
+	return node, nil
+}
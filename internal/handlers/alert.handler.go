@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type AlertHandler struct {
+	db               *pgxpool.Pool
+	nodeRepository   *repositories.NodeRepository
+	repository       *repositories.AlertRepository
+	sensorRepository *repositories.SensorRepository
+	validator        *dependencies.Validator
+}
+
+func NewAlertHandler(db *pgxpool.Pool, nodeRepository *repositories.NodeRepository, alertRepository *repositories.AlertRepository, sensorRepository *repositories.SensorRepository, validator *dependencies.Validator) (AlertHandler, error) {
+	return AlertHandler{
+		db:               db,
+		nodeRepository:   nodeRepository,
+		repository:       alertRepository,
+		sensorRepository: sensorRepository,
+		validator:        validator,
+	}, nil
+}
+
+// getOwnRule return the rule when the current user has the permission on its sensor
+func (h *AlertHandler) getOwnRule(ctx context.Context, c *fiber.Ctx, permission string) (rule entities.AlertRule, err error) {
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return rule, err
+	}
+
+	rule, err = h.repository.GetRuleById(ctx, h.db, id)
+	if err != nil {
+		return rule, err
+	}
+
+	err = validateSensorAccess(ctx, c, h.validator, h.db, h.nodeRepository, rule.IdSensor, permission, "You can’t access another user’s alert rule")
+	return rule, err
+}
+
+// GetAll return the active alert of the current user sensor, with the resolved one when resolved=true
+func (h *AlertHandler) GetAll(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	query := entities.AlertQuery{}
+	err = h.validator.ParseQuery(c, &query)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	alerts, err := h.repository.GetAll(ctx, h.db, currentUser.IdUser, &query)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(alerts)
+}
+
+// Acknowledge the alert, it stay active until a channel stop matching its rule
+func (h *AlertHandler) Acknowledge(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	alert, err := h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	err = validateSensorAccess(ctx, c, h.validator, h.db, h.nodeRepository, alert.IdSensor, entities.ShareWrite, "You can’t acknowledge another user’s alert")
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.Acknowledge(ctx, h.db, alert.IdAlert)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success acknowledge alert")
+}
+
+func (h *AlertHandler) GetAllRule(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	rules, err := h.repository.GetAllRule(ctx, h.db, currentUser.IdUser)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(rules)
+}
+
+func (h *AlertHandler) GetRuleById(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	rule, err := h.getOwnRule(ctx, c, entities.ShareRead)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(rule)
+}
+
+// CreateRule add the rule, it is evaluated from the next channel of the sensor
+func (h *AlertHandler) CreateRule(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := entities.AlertRuleCreate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	err = validateSensorAccess(ctx, c, h.validator, h.db, h.nodeRepository, bodyPayload.IdSensor, entities.ShareWrite, "You can’t add an alert rule to another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	rule, err := h.repository.CreateRule(ctx, h.db, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(rule)
+}
+
+// UpdateRule change the rule, its active alert is resolved and the duration is counted again
+func (h *AlertHandler) UpdateRule(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := entities.AlertRuleCreate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	rule, err := h.getOwnRule(ctx, c, entities.ShareWrite)
+	if err != nil {
+		return err
+	}
+
+	err = validateSensorAccess(ctx, c, h.validator, h.db, h.nodeRepository, bodyPayload.IdSensor, entities.ShareWrite, "You can’t add an alert rule to another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = h.repository.UpdateRule(ctx, tx, rule.IdRule, &bodyPayload)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success edit alert rule")
+}
+
+func (h *AlertHandler) DeleteRule(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	rule, err := h.getOwnRule(ctx, c, entities.ShareWrite)
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.DeleteRule(ctx, h.db, rule.IdRule)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success delete alert rule")
+}
+
+// GetAllTarget return the email and webhook target of the current user, without the webhook secret
+func (h *AlertHandler) GetAllTarget(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	targets, err := h.repository.GetTargets(ctx, h.db, currentUser.IdUser, false)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(targets)
+}
+
+// CreateTarget add the target of the current user, the secret of a webhook target is only returned here
+func (h *AlertHandler) CreateTarget(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := entities.AlertTargetCreate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	tag := "email"
+	if bodyPayload.Type == entities.AlertTargetWebhook {
+		tag = "url"
+	}
+	err = h.validator.Validate.Var(bodyPayload.Address, tag)
+	if err != nil {
+		return fiber.NewError(400, fmt.Sprintf("address of a %s target must be a valid %s", bodyPayload.Type, tag))
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	target, err := h.repository.CreateTarget(ctx, h.db, currentUser.IdUser, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(target)
+}
+
+func (h *AlertHandler) DeleteTarget(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	target, err := h.repository.GetTargetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+	if target.IdUser != currentUser.IdUser && !currentUser.IsAdmin {
+		return fiber.NewError(403, "You can’t delete another user’s alert target")
+	}
+
+	err = h.repository.DeleteTarget(ctx, h.db, target.IdTarget)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success delete alert target")
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Fewest bucket both series must have for their correlation to be computed
+const correlateMinPoints = 3
+
+type AnalyticsHandler struct {
+	db                *pgxpool.Pool
+	nodeRepository    *repositories.NodeRepository
+	sensorRepository  *repositories.SensorRepository
+	channelRepository *repositories.ChannelRepository
+	validator         *dependencies.Validator
+}
+
+func NewAnalyticsHandler(db *pgxpool.Pool, nodeRepository *repositories.NodeRepository, sensorRepository *repositories.SensorRepository, channelRepository *repositories.ChannelRepository, validator *dependencies.Validator) (AnalyticsHandler, error) {
+	return AnalyticsHandler{
+		db:                db,
+		nodeRepository:    nodeRepository,
+		sensorRepository:  sensorRepository,
+		channelRepository: channelRepository,
+		validator:         validator,
+	}, nil
+}
+
+// Correlate return the Pearson correlation coefficient of every pair of sensor in the `sensors` query.
+// The series are resampled with the same interval, like GetCompareSeries, and only the bucket both
+// sensors have is used. The coefficient is null when there are too few common bucket or a series is constant
+func (h *AnalyticsHandler) Correlate(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	sensorIds, err := parseSensorIds(c.Query("sensors"))
+	if err != nil {
+		return err
+	}
+	if len(sensorIds) < 2 {
+		return fiber.NewError(400, "Query sensors need at least two sensor, e.g. ?sensors=1,2,3")
+	}
+	if len(sensorIds) > compareMaxSensor {
+		return fiber.NewError(400, fmt.Sprintf("Can't correlate more than %d sensors", compareMaxSensor))
+	}
+
+	query, err := h.validator.ParseChannelQuery(c)
+	if err != nil {
+		return err
+	}
+
+	points := c.QueryInt("points", 500)
+	if points <= 0 || points > 10000 {
+		return fiber.NewError(400, "points parameter must be between 1 and 10000")
+	}
+
+	sensors := []entities.Sensor{}
+	for _, id := range sensorIds {
+		err = validateSensorAccess(ctx, c, h.validator, h.db, h.nodeRepository, id, entities.ShareRead, "You can’t see another user’s sensor")
+		if err != nil {
+			return err
+		}
+
+		sensor, err := h.sensorRepository.GetById(ctx, h.db, id)
+		if err != nil {
+			return err
+		}
+		sensors = append(sensors, sensor)
+	}
+
+	if query.Interval == 0 {
+		// The series must be resampled to be paired, at least per second
+		from, to := query.From, query.To
+		if from == nil || to == nil {
+			for _, sensor := range sensors {
+				first, last, err := h.channelRepository.GetTimeRangeBySensor(ctx, h.db, sensor.IdSensor)
+				if err != nil {
+					return err
+				}
+				if query.From == nil && first != nil && (from == nil || first.Before(*from)) {
+					from = first
+				}
+				if query.To == nil && last != nil && (to == nil || last.After(*to)) {
+					to = last
+				}
+			}
+		}
+
+		query.Interval = time.Second
+		if from != nil && to != nil {
+			interval := to.Sub(*from) / time.Duration(points)
+			if interval >= time.Second {
+				query.Interval = interval.Round(time.Second)
+			}
+		}
+	}
+
+	series := make([]map[int64]float64, len(sensors))
+	for i, sensor := range sensors {
+		series[i] = map[int64]float64{}
+		err = h.channelRepository.ForEachBySensor(ctx, h.db, sensor.IdSensor, query, func(channel entities.Channel) error {
+			series[i][channel.Time.UnixMilli()] = channel.Value
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	pairs := []fiber.Map{}
+	for i := range sensors {
+		for j := i + 1; j < len(sensors); j++ {
+			xs, ys := []float64{}, []float64{}
+			for bucket, x := range series[i] {
+				if y, ok := series[j][bucket]; ok {
+					xs = append(xs, x)
+					ys = append(ys, y)
+				}
+			}
+
+			pairs = append(pairs, fiber.Map{
+				"id_sensor_a": sensors[i].IdSensor,
+				"id_sensor_b": sensors[j].IdSensor,
+				"points":      len(xs),
+				"coefficient": pearson(xs, ys),
+			})
+		}
+	}
+
+	aggregate := query.Aggregate
+	if aggregate == "" {
+		aggregate = "avg"
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"interval": query.Interval.Seconds(),
+		"agg":      aggregate,
+		"pairs":    pairs,
+	})
+}
+
+// pearson return the correlation coefficient of the paired value, nil when it is undefined
+func pearson(xs []float64, ys []float64) *float64 {
+	if len(xs) < correlateMinPoints {
+		return nil
+	}
+
+	var meanX, meanY float64
+	for i := range xs {
+		meanX += xs[i]
+		meanY += ys[i]
+	}
+	meanX /= float64(len(xs))
+	meanY /= float64(len(ys))
+
+	var covariance, varianceX, varianceY float64
+	for i := range xs {
+		dx, dy := xs[i]-meanX, ys[i]-meanY
+		covariance += dx * dy
+		varianceX += dx * dx
+		varianceY += dy * dy
+	}
+	if varianceX == 0 || varianceY == 0 {
+		return nil
+	}
+
+	coefficient := covariance / math.Sqrt(varianceX*varianceY)
+	return &coefficient
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type ApiKeyHandler struct {
+	db             *pgxpool.Pool
+	repository     *repositories.ApiKeyRepository
+	nodeRepository *repositories.NodeRepository
+	validator      *dependencies.Validator
+}
+
+func NewApiKeyHandler(db *pgxpool.Pool, apiKeyRepository *repositories.ApiKeyRepository, nodeRepository *repositories.NodeRepository, validator *dependencies.Validator) (ApiKeyHandler, error) {
+	return ApiKeyHandler{
+		db:             db,
+		repository:     apiKeyRepository,
+		nodeRepository: nodeRepository,
+		validator:      validator,
+	}, nil
+}
+
+// GetAll return the API key of the node, without the key itself
+func (h *ApiKeyHandler) GetAll(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	node, err := getOwnNode(ctx, c, h.validator, h.db, h.nodeRepository, "You can’t see another user’s node")
+	if err != nil {
+		return err
+	}
+
+	keys, err := h.repository.GetByNode(ctx, h.db, node.IdNode)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(keys)
+}
+
+// Create a new API key for the node, the answer is the only time the key is shown
+func (h *ApiKeyHandler) Create(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := &entities.NodeApiKeyCreate{}
+	err = h.validator.ParseBody(c, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	node, err := getOwnNode(ctx, c, h.validator, h.db, h.nodeRepository, "You can’t edit another user’s node")
+	if err != nil {
+		return err
+	}
+
+	created, err := h.repository.Create(ctx, h.db, node.IdNode, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(created)
+}
+
+// Delete revoke the API key of the node
+func (h *ApiKeyHandler) Delete(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	keyId, err := h.validator.ParseIntFromUrlParameter(c, "key")
+	if err != nil {
+		return err
+	}
+
+	node, err := getOwnNode(ctx, c, h.validator, h.db, h.nodeRepository, "You can’t edit another user’s node")
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.Delete(ctx, h.db, node.IdNode, keyId)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success revoke node API key")
+}
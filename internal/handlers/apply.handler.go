@@ -0,0 +1,391 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type ApplyHandler struct {
+	db                  *pgxpool.Pool
+	nodeRepository      *repositories.NodeRepository
+	sensorRepository    *repositories.SensorRepository
+	hardwareRepository  *repositories.HardwareRepository
+	dashboardRepository *repositories.DashboardRepository
+	webhookRepository   *repositories.WebhookRepository
+	historyRepository   *repositories.HistoryRepository
+	validator           *dependencies.Validator
+}
+
+func NewApplyHandler(db *pgxpool.Pool, nodeRepository *repositories.NodeRepository, sensorRepository *repositories.SensorRepository, hardwareRepository *repositories.HardwareRepository, dashboardRepository *repositories.DashboardRepository, webhookRepository *repositories.WebhookRepository, historyRepository *repositories.HistoryRepository, validator *dependencies.Validator) (ApplyHandler, error) {
+	return ApplyHandler{
+		db:                  db,
+		nodeRepository:      nodeRepository,
+		sensorRepository:    sensorRepository,
+		hardwareRepository:  hardwareRepository,
+		dashboardRepository: dashboardRepository,
+		webhookRepository:   webhookRepository,
+		historyRepository:   historyRepository,
+		validator:           validator,
+	}, nil
+}
+
+// applyRun keep the state of one apply
+type applyRun struct {
+	ctx            context.Context
+	tx             pgx.Tx
+	prune          bool
+	currentUser    *entities.UserRead
+	nodeHardware   map[string]int
+	sensorHardware map[string]int
+	result         *entities.ApplyResult
+}
+
+func (a *applyRun) change(kind string, key string, action string) {
+	a.result.Changes = append(a.result.Changes, entities.ResourceChange{Kind: kind, Key: key, Action: action})
+}
+
+// enqueue queue the webhook event of the change, it is dropped with the transaction on dry run
+func (h *ApplyHandler) enqueue(run *applyRun, event string, data interface{}) error {
+	return h.webhookRepository.Enqueue(run.ctx, run.tx, run.currentUser.IdUser, event, data)
+}
+
+// record add the revision of the change to the entity history, like enqueue it is rolled back on dry run
+func (h *ApplyHandler) record(run *applyRun, entityType string, idEntity int, action string, before interface{}, after interface{}) error {
+	return h.historyRepository.Record(run.ctx, run.tx, entityType, idEntity, action, run.currentUser.IdUser, before, after)
+}
+
+// Apply reconcile the caller nodes and sensors with the manifest sent as JSON or YAML
+// (Content-Type: application/yaml). The node and sensor missing from the manifest are only
+// deleted with ?prune=true, and with ?dry_run=true every change is reported then rolled back
+func (h *ApplyHandler) Apply(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+
+	dryRun, err := h.validator.ParseDryRun(c)
+	if err != nil {
+		return err
+	}
+	prune, err := strconv.ParseBool(c.Query("prune", "false"))
+	if err != nil {
+		return fiber.NewError(400, "prune must be true or false")
+	}
+
+	manifest := entities.ApplyManifest{}
+	err = h.validator.ParseDocument(c, &manifest)
+	if err != nil {
+		return err
+	}
+
+	nodeNames := map[string]bool{}
+	for _, node := range manifest.Nodes {
+		if nodeNames[node.Name] {
+			return fiber.NewError(400, fmt.Sprintf("node %s is declared twice", node.Name))
+		}
+		nodeNames[node.Name] = true
+
+		sensorNames := map[string]bool{}
+		for _, sensor := range node.Sensors {
+			if sensorNames[sensor.Name] {
+				return fiber.NewError(400, fmt.Sprintf("sensor %s of node %s is declared twice", sensor.Name, node.Name))
+			}
+			sensorNames[sensor.Name] = true
+		}
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	result := entities.ApplyResult{DryRun: dryRun, Prune: prune, Changes: []entities.ResourceChange{}, Unmanaged: []string{}}
+	run := &applyRun{
+		ctx:            ctx,
+		tx:             tx,
+		prune:          prune,
+		currentUser:    &currentUser,
+		nodeHardware:   map[string]int{},
+		sensorHardware: map[string]int{},
+		result:         &result,
+	}
+
+	hardwares, err := h.hardwareRepository.GetAllNode(ctx, tx, &entities.HardwareQuery{})
+	if err != nil {
+		return err
+	}
+	for _, hardware := range hardwares {
+		if _, exist := run.nodeHardware[hardware.Name]; !exist {
+			run.nodeHardware[hardware.Name] = hardware.IdHardware
+		}
+	}
+	hardwares, err = h.hardwareRepository.GetAllSensor(ctx, tx, &entities.HardwareQuery{})
+	if err != nil {
+		return err
+	}
+	for _, hardware := range hardwares {
+		if _, exist := run.sensorHardware[hardware.Name]; !exist {
+			run.sensorHardware[hardware.Name] = hardware.IdHardware
+		}
+	}
+
+	err = h.applyNodes(run, manifest.Nodes)
+	if err != nil {
+		return err
+	}
+
+	if !dryRun {
+		err = tx.Commit(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(result)
+}
+
+func (h *ApplyHandler) applyNodes(run *applyRun, desired []entities.ApplyNode) error {
+	// Admin see every node in GetAll, the manifest only manage the caller own node
+	nodes, err := h.nodeRepository.GetAll(run.ctx, run.tx, run.currentUser, &entities.NodeQuery{})
+	if err != nil {
+		return err
+	}
+	existing := map[string]entities.Node{}
+	for _, node := range nodes {
+		if node.IdUser != run.currentUser.IdUser {
+			continue
+		}
+		if _, exist := existing[node.Name]; !exist {
+			existing[node.Name] = node
+		}
+	}
+
+	for _, want := range desired {
+		idHardware, ok := run.nodeHardware[want.Hardware]
+		if !ok {
+			return fiber.NewError(400, fmt.Sprintf("node %s: hardware %s not found or is not a microcontroller unit or single-board computer", want.Name, want.Hardware))
+		}
+
+		node, exist := existing[want.Name]
+		delete(existing, want.Name)
+		switch {
+		case !exist:
+			node, err = h.nodeRepository.Create(run.ctx, run.tx, &entities.NodeCreate{Name: want.Name, Location: want.Location, IdHardware: idHardware}, run.currentUser)
+			if err != nil {
+				return err
+			}
+			err = h.record(run, entities.EntityNode, node.IdNode, entities.RevisionCreate, nil, node)
+			if err != nil {
+				return err
+			}
+			err = h.enqueue(run, entities.EventNodeCreated, node)
+			if err != nil {
+				return err
+			}
+			h.instantiateTemplate(run, &node)
+			run.change("node", want.Name, "create")
+		case node.IdHardware != idHardware:
+			return fiber.NewError(400, fmt.Sprintf("node %s: the hardware of a node can't be changed, delete the node first", want.Name))
+		case node.Location != want.Location:
+			before := node
+			err = h.nodeRepository.Update(run.ctx, run.tx, &node, &entities.NodeUpdate{Location: want.Location})
+			if err != nil {
+				return err
+			}
+			node.Location = want.Location
+			err = h.record(run, entities.EntityNode, node.IdNode, entities.RevisionUpdate, before, node)
+			if err != nil {
+				return err
+			}
+			err = h.enqueue(run, entities.EventNodeUpdated, node)
+			if err != nil {
+				return err
+			}
+			run.change("node", want.Name, "update")
+		default:
+			run.result.Unchanged++
+		}
+
+		err = h.applySensors(run, &node, want.Sensors)
+		if err != nil {
+			return err
+		}
+	}
+
+	names := []string{}
+	for name := range existing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		node := existing[name]
+		if !run.prune {
+			run.result.Unmanaged = append(run.result.Unmanaged, "node "+name)
+			continue
+		}
+		sensors, err := h.sensorRepository.GetNodeSensor(run.ctx, run.tx, node.IdNode)
+		if err != nil {
+			return err
+		}
+		for _, sensor := range sensors {
+			err = h.enqueue(run, entities.EventSensorDeleted, sensor)
+			if err != nil {
+				return err
+			}
+		}
+		err = h.enqueue(run, entities.EventNodeDeleted, node)
+		if err != nil {
+			return err
+		}
+		err = h.nodeRepository.Delete(run.ctx, run.tx, node.IdNode)
+		if err != nil {
+			return err
+		}
+		for _, sensor := range sensors {
+			err = h.record(run, entities.EntitySensor, sensor.IdSensor, entities.RevisionDelete, sensor, nil)
+			if err != nil {
+				return err
+			}
+		}
+		err = h.record(run, entities.EntityNode, node.IdNode, entities.RevisionDelete, node, nil)
+		if err != nil {
+			return err
+		}
+		run.change("node", name, "delete")
+	}
+	return nil
+}
+
+func (h *ApplyHandler) applySensors(run *applyRun, node *entities.Node, desired []entities.ApplySensor) error {
+	sensors, err := h.sensorRepository.GetNodeSensor(run.ctx, run.tx, node.IdNode)
+	if err != nil {
+		return err
+	}
+	existing := map[string]entities.Sensor{}
+	for _, sensor := range sensors {
+		if _, exist := existing[sensor.Name]; !exist {
+			existing[sensor.Name] = sensor
+		}
+	}
+
+	for _, want := range desired {
+		key := node.Name + "/" + want.Name
+		idHardware, ok := run.sensorHardware[want.Hardware]
+		if !ok {
+			return fiber.NewError(400, fmt.Sprintf("sensor %s: hardware %s not found or is not a sensor", key, want.Hardware))
+		}
+
+		sensor, exist := existing[want.Name]
+		delete(existing, want.Name)
+		switch {
+		case !exist:
+			sensor, err = h.sensorRepository.Create(run.ctx, run.tx, &entities.SensorCreate{Name: want.Name, Unit: want.Unit, IdNode: node.IdNode, IdHardware: idHardware})
+			if err != nil {
+				return err
+			}
+			err = h.record(run, entities.EntitySensor, sensor.IdSensor, entities.RevisionCreate, nil, sensor)
+			if err != nil {
+				return err
+			}
+			err = h.enqueue(run, entities.EventSensorCreated, sensor)
+			if err != nil {
+				return err
+			}
+			h.bindTemplateWidgets(run, &sensor)
+			run.change("sensor", key, "create")
+		case sensor.IdHardware != idHardware:
+			return fiber.NewError(400, fmt.Sprintf("sensor %s: the hardware of a sensor can't be changed, delete the sensor first", key))
+		case sensor.Unit != want.Unit:
+			before := sensor
+			err = h.sensorRepository.Update(run.ctx, run.tx, &sensor, &entities.SensorUpdate{Unit: want.Unit})
+			if err != nil {
+				return err
+			}
+			sensor.Unit = want.Unit
+			err = h.record(run, entities.EntitySensor, sensor.IdSensor, entities.RevisionUpdate, before, sensor)
+			if err != nil {
+				return err
+			}
+			err = h.enqueue(run, entities.EventSensorUpdated, sensor)
+			if err != nil {
+				return err
+			}
+			run.change("sensor", key, "update")
+		default:
+			run.result.Unchanged++
+		}
+	}
+
+	names := []string{}
+	for name := range existing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sensor := existing[name]
+		key := node.Name + "/" + name
+		if !run.prune {
+			run.result.Unmanaged = append(run.result.Unmanaged, "sensor "+key)
+			continue
+		}
+		err = h.enqueue(run, entities.EventSensorDeleted, sensor)
+		if err != nil {
+			return err
+		}
+		err = h.sensorRepository.Delete(run.ctx, run.tx, sensor.IdSensor)
+		if err != nil {
+			return err
+		}
+		err = h.record(run, entities.EntitySensor, sensor.IdSensor, entities.RevisionDelete, sensor, nil)
+		if err != nil {
+			return err
+		}
+		run.change("sensor", key, "delete")
+	}
+	return nil
+}
+
+// The dashboard template is applied like when the node is created from the API. It run in a
+// savepoint so a broken template doesn't abort the apply transaction
+func (h *ApplyHandler) instantiateTemplate(run *applyRun, node *entities.Node) {
+	savepoint, err := run.tx.Begin(run.ctx)
+	if err == nil {
+		_, err = h.dashboardRepository.InstantiateTemplateForNode(run.ctx, savepoint, node)
+		if err == nil {
+			err = savepoint.Commit(run.ctx)
+		} else {
+			savepoint.Rollback(run.ctx)
+		}
+	}
+	if err != nil {
+		log.Printf("[DASHBOARD] Error instantiating template for node %d: %v", node.IdNode, err)
+	}
+}
+
+func (h *ApplyHandler) bindTemplateWidgets(run *applyRun, sensor *entities.Sensor) {
+	savepoint, err := run.tx.Begin(run.ctx)
+	if err == nil {
+		err = h.dashboardRepository.BindTemplateWidgetsForSensor(run.ctx, savepoint, sensor)
+		if err == nil {
+			err = savepoint.Commit(run.ctx)
+		} else {
+			savepoint.Rollback(run.ctx)
+		}
+	}
+	if err != nil {
+		log.Printf("[DASHBOARD] Error binding template widget for sensor %d: %v", sensor.IdSensor, err)
+	}
+}
@@ -0,0 +1,420 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/backup"
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type BackupHandler struct {
+	db         *pgxpool.Pool
+	repository *repositories.BackupRepository
+	fileStore  dependencies.ObjectStore
+	s3Store    dependencies.ObjectStore
+	cluster    *dependencies.Cluster
+	config     *configs.Config
+	validator  *dependencies.Validator
+}
+
+func NewBackupHandler(db *pgxpool.Pool, backupRepository *repositories.BackupRepository, fileStore dependencies.ObjectStore, s3Store dependencies.ObjectStore, cluster *dependencies.Cluster, config *configs.Config, validator *dependencies.Validator) (BackupHandler, error) {
+	return BackupHandler{
+		db:         db,
+		repository: backupRepository,
+		fileStore:  fileStore,
+		s3Store:    s3Store,
+		cluster:    cluster,
+		config:     config,
+		validator:  validator,
+	}, nil
+}
+
+const (
+	backupExtension = ".jsonl.gz"
+	// The progress is kept in the cluster store so every instance can report it
+	backupOperationTTL = 7 * 24 * time.Hour
+	// Row restored per insert statement, and how often the progress is saved
+	backupBatchSize = 1000
+)
+
+// store return the object store of the target and the key prefix of the backup in it
+func (h *BackupHandler) store(target string) (dependencies.ObjectStore, string, error) {
+	if target == "s3" {
+		if h.s3Store == nil {
+			return nil, "", fiber.NewError(400, "S3 is not configured, set s3.bucket in the config")
+		}
+		return h.s3Store, h.config.Backup.S3Prefix, nil
+	}
+	return h.fileStore, "", nil
+}
+
+func (h *BackupHandler) saveOperation(operation *entities.BackupOperation) {
+	payload, err := json.Marshal(operation)
+	if err == nil {
+		err = h.cluster.Store.Set(context.Background(), "backup:operation:"+operation.Id, string(payload), backupOperationTTL)
+	}
+	if err != nil {
+		log.Printf("[BACKUP] Can't save progress of %s: %v", operation.Id, err)
+	}
+}
+
+func (h *BackupHandler) finishOperation(operation *entities.BackupOperation, message string, err error) {
+	now := time.Now().UTC()
+	operation.FinishedAt = &now
+	operation.Status = "success"
+	operation.Message = message
+	if err != nil {
+		operation.Status = "failed"
+		operation.Message = err.Error()
+		log.Printf("[BACKUP] %s %s failed: %v", operation.Operation, operation.Name, err)
+	}
+	h.saveOperation(operation)
+}
+
+// lock take the postgres advisory lock on a dedicated connection, so only one backup or
+// restore run at a time across the instances. The lock is released with the connection
+func (h *BackupHandler) lock(ctx context.Context) (*pgxpool.Conn, error) {
+	conn, err := h.db.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var locked bool
+	err = conn.QueryRow(ctx, `SELECT pg_try_advisory_lock(hashtext('backup'))`).Scan(&locked)
+	if err != nil {
+		conn.Release()
+		return nil, err
+	}
+	if !locked {
+		conn.Release()
+		return nil, fiber.NewError(fiber.StatusConflict, "Another backup or restore is running")
+	}
+	return conn, nil
+}
+
+func (h *BackupHandler) unlock(conn *pgxpool.Conn) {
+	_, err := conn.Exec(context.Background(), `SELECT pg_advisory_unlock(hashtext('backup'))`)
+	if err != nil {
+		log.Printf("[BACKUP] Can't release lock: %v", err)
+	}
+	conn.Release()
+}
+
+func (h *BackupHandler) newOperation(kind string, target string, name string) *entities.BackupOperation {
+	return &entities.BackupOperation{
+		Id:        uuid.New().String(),
+		Operation: kind,
+		Target:    target,
+		Name:      name,
+		Status:    "running",
+		Instance:  h.cluster.InstanceId,
+		StartedAt: time.Now().UTC(),
+	}
+}
+
+func (h *BackupHandler) GetAll(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+
+	files := []entities.BackupFile{}
+	for _, target := range []string{"file", "s3"} {
+		store, prefix, err := h.store(target)
+		if err != nil {
+			continue
+		}
+		objects, err := store.List(ctx, prefix)
+		if err != nil {
+			return err
+		}
+		for _, object := range objects {
+			name := strings.TrimPrefix(object.Key, prefix)
+			if strings.Contains(name, "/") || !strings.HasSuffix(name, backupExtension) {
+				continue
+			}
+			files = append(files, entities.BackupFile{Target: target, Name: name, Size: object.Size, ModifiedAt: object.ModifiedAt})
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(files)
+}
+
+func (h *BackupHandler) GetOperation(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+
+	id := c.Params("id")
+	payload, found, err := h.cluster.Store.Get(ctx, "backup:operation:"+id)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fiber.NewError(404, fmt.Sprintf("Backup operation with id %s not found", id))
+	}
+
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Status(fiber.StatusOK).SendString(payload)
+}
+
+func (h *BackupHandler) Create(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+
+	bodyPayload := entities.BackupCreate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+	if bodyPayload.From != nil && bodyPayload.To != nil && !bodyPayload.From.Before(*bodyPayload.To) {
+		return fiber.NewError(400, "from must be before to")
+	}
+
+	store, prefix, err := h.store(bodyPayload.Target)
+	if err != nil {
+		return err
+	}
+
+	conn, err := h.lock(ctx)
+	if err != nil {
+		return err
+	}
+
+	name := "backup-" + time.Now().UTC().Format("20060102T150405Z") + backupExtension
+	operation := h.newOperation("backup", bodyPayload.Target, name)
+	h.saveOperation(operation)
+	// The operation is updated by the goroutine from now on
+	accepted := *operation
+
+	go func() {
+		defer h.unlock(conn)
+		rows, err := h.runBackup(conn, store, prefix+name, &bodyPayload, operation)
+		h.finishOperation(operation, fmt.Sprintf("Saved %d row to %s", rows, name), err)
+	}()
+
+	c.Location("/admin/backup/operation/" + accepted.Id)
+	return c.Status(fiber.StatusAccepted).JSON(accepted)
+}
+
+// runBackup read every table in one repeatable read transaction so the backup is consistent,
+// the file is written to a temporary file first then uploaded to the store
+func (h *BackupHandler) runBackup(conn *pgxpool.Conn, store dependencies.ObjectStore, key string, payload *entities.BackupCreate, operation *entities.BackupOperation) (rows int64, err error) {
+	ctx := context.Background()
+
+	file, err := os.CreateTemp("", "iot-backup-*"+backupExtension)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	tx, err := conn.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	header := backup.Header{CreatedAt: time.Now().UTC(), Channel: payload.Channel, From: payload.From, To: payload.To}
+	for _, table := range repositories.BackupTables {
+		if repositories.IsChannelTable(table.Name) && !payload.Channel {
+			continue
+		}
+		count, err := h.repository.CountRows(ctx, tx, table.Name, payload.From, payload.To)
+		if err != nil {
+			return 0, err
+		}
+		header.Tables = append(header.Tables, backup.Table{Name: table.Name, Rows: count})
+	}
+	operation.TotalRows = header.TotalRows()
+	h.saveOperation(operation)
+
+	writer, err := backup.NewWriter(file, header)
+	if err != nil {
+		return 0, err
+	}
+	for _, table := range header.Tables {
+		operation.Table = table.Name
+		err = writer.WriteTable(table.Name, table.Rows)
+		if err != nil {
+			return 0, err
+		}
+		err = h.repository.ForEachRow(ctx, tx, table.Name, payload.From, payload.To, func(row []byte) error {
+			operation.Rows++
+			if operation.Rows%backupBatchSize == 0 {
+				h.saveOperation(operation)
+			}
+			return writer.WriteRow(row)
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	err = writer.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	operation.Table = ""
+	h.saveOperation(operation)
+	return operation.Rows, store.Put(ctx, key, file)
+}
+
+func (h *BackupHandler) Restore(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+
+	bodyPayload := entities.BackupRestore{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+	if strings.ContainsAny(bodyPayload.Name, "/\\") || !strings.HasSuffix(bodyPayload.Name, backupExtension) {
+		return fiber.NewError(400, fmt.Sprintf("name must be a backup file name ending with %s", backupExtension))
+	}
+
+	store, prefix, err := h.store(bodyPayload.Target)
+	if err != nil {
+		return err
+	}
+
+	// Open the file before answering so a wrong name is reported right away
+	body, err := store.Get(ctx, prefix+bodyPayload.Name)
+	if errors.Is(err, dependencies.ErrObjectNotFound) {
+		return fiber.NewError(404, fmt.Sprintf("Backup %s not found", bodyPayload.Name))
+	}
+	if err != nil {
+		return err
+	}
+
+	conn, err := h.lock(ctx)
+	if err != nil {
+		body.Close()
+		return err
+	}
+
+	operation := h.newOperation("restore", bodyPayload.Target, bodyPayload.Name)
+	h.saveOperation(operation)
+	accepted := *operation
+
+	go func() {
+		defer h.unlock(conn)
+		defer body.Close()
+		rows, err := h.runRestore(conn, body, bodyPayload.Force, operation)
+		h.finishOperation(operation, fmt.Sprintf("Restored %d row from %s", rows, bodyPayload.Name), err)
+	}()
+
+	c.Location("/admin/backup/operation/" + accepted.Id)
+	return c.Status(fiber.StatusAccepted).JSON(accepted)
+}
+
+// runRestore replace every backup table with the content of the backup in one transaction,
+// nothing is changed when it fail
+func (h *BackupHandler) runRestore(conn *pgxpool.Conn, body io.Reader, force bool, operation *entities.BackupOperation) (rows int64, err error) {
+	ctx := context.Background()
+
+	reader, err := backup.NewReader(body)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	operation.TotalRows = reader.Header.TotalRows()
+	h.saveOperation(operation)
+
+	known := map[string]bool{}
+	for _, table := range repositories.BackupTables {
+		known[table.Name] = true
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	if !force {
+		exist, err := h.repository.HasDeviceData(ctx, tx)
+		if err != nil {
+			return 0, err
+		}
+		if exist {
+			return 0, errors.New("the instance already has node, sensor or channel, restore with force to replace them")
+		}
+	}
+
+	err = h.repository.TruncateAll(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+
+	batch := [][]byte{}
+	flush := func() error {
+		err := h.repository.InsertRows(ctx, tx, operation.Table, batch)
+		if err != nil {
+			return fmt.Errorf("restore table %s: %w", operation.Table, err)
+		}
+		operation.Rows += int64(len(batch))
+		batch = batch[:0]
+		h.saveOperation(operation)
+		return nil
+	}
+	for {
+		table, row, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		if !known[table] {
+			return 0, fmt.Errorf("unknown table %s in the backup", table)
+		}
+
+		if table != operation.Table || len(batch) == backupBatchSize {
+			err = flush()
+			if err != nil {
+				return 0, err
+			}
+			operation.Table = table
+		}
+		batch = append(batch, append([]byte(nil), row...))
+	}
+	err = flush()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, table := range repositories.BackupTables {
+		if table.IdColumn == "" {
+			continue
+		}
+		err = h.repository.ResetSequence(ctx, tx, table.Name, table.IdColumn)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, err
+	}
+	operation.Table = ""
+	return operation.Rows, nil
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type BrandingHandler struct {
+	db         *pgxpool.Pool
+	repository *repositories.BrandingRepository
+	validator  *dependencies.Validator
+}
+
+func NewBrandingHandler(db *pgxpool.Pool, brandingRepository *repositories.BrandingRepository, validator *dependencies.Validator) (BrandingHandler, error) {
+	return BrandingHandler{
+		db:         db,
+		repository: brandingRepository,
+		validator:  validator,
+	}, nil
+}
+
+func (h *BrandingHandler) Get(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+
+	branding, err := h.repository.Get(ctx, h.db)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(branding)
+}
+
+func (h *BrandingHandler) Update(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+
+	bodyPayload := entities.BrandingUpdate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	// The logo is rendered as image source, so only allow local path or http url
+	if logo := bodyPayload.LogoUrl; logo != nil && *logo != "" {
+		if !strings.HasPrefix(*logo, "/") && !strings.HasPrefix(*logo, "http://") && !strings.HasPrefix(*logo, "https://") {
+			return fiber.NewError(400, "logo_url must be a path starting with / or an http(s) url")
+		}
+	}
+
+	err = h.repository.Update(ctx, h.db, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	branding, err := h.repository.Get(ctx, h.db)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(branding)
+}
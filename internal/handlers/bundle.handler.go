@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"gopkg.in/yaml.v3"
+)
+
+type BundleHandler struct {
+	db         *pgxpool.Pool
+	repository *repositories.BundleRepository
+	validator  *dependencies.Validator
+}
+
+func NewBundleHandler(db *pgxpool.Pool, bundleRepository *repositories.BundleRepository, validator *dependencies.Validator) (BundleHandler, error) {
+	return BundleHandler{
+		db:         db,
+		repository: bundleRepository,
+		validator:  validator,
+	}, nil
+}
+
+// Export download the bundle as JSON, or YAML with ?format=yaml
+func (h *BundleHandler) Export(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+
+	format := c.Query("format", "json")
+	if format != "json" && format != "yaml" {
+		return fiber.NewError(400, "format must be json or yaml")
+	}
+
+	// Read in one transaction so the reference between the rows is consistent
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	bundle, err := h.repository.Export(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	c.Attachment(fmt.Sprintf("iot-config-%s.%s", time.Now().UTC().Format("20060102T150405Z"), format))
+	if format == "yaml" {
+		body, err := yaml.Marshal(bundle)
+		if err != nil {
+			return err
+		}
+		c.Set(fiber.HeaderContentType, "application/yaml")
+		return c.Status(fiber.StatusOK).Send(body)
+	}
+	return c.Status(fiber.StatusOK).JSON(bundle)
+}
+
+// Import apply the bundle sent as JSON or YAML (Content-Type: application/yaml),
+// with ?dry_run=true the change is reported then rolled back
+func (h *BundleHandler) Import(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+
+	dryRun, err := h.validator.ParseDryRun(c)
+	if err != nil {
+		return err
+	}
+
+	bundle := entities.ConfigBundle{}
+	err = h.validator.ParseDocument(c, &bundle)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := h.repository.Import(ctx, tx, &bundle)
+	if err != nil {
+		return err
+	}
+
+	result.DryRun = dryRun
+	if !result.DryRun {
+		err = tx.Commit(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(result)
+}
@@ -2,30 +2,57 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
 
 	"github.com/dafaath/iot-server/internal/dependencies"
 	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/ingest"
 	"github.com/dafaath/iot-server/internal/repositories"
 	"github.com/gofiber/fiber/v2"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// A channel of POST /channel/bulk may be this far in the future
+const bulkMaxClockSkew = 5 * time.Minute
+
 type ChannelHandler struct {
 	db               *pgxpool.Pool
-	repository       *repositories.ChannelRepository
+	nodeRepository   *repositories.NodeRepository
 	sensorRepository *repositories.SensorRepository
+	pipeline         *ingest.Pipeline
 	validator        *dependencies.Validator
 }
 
-func NewChannelHandler(db *pgxpool.Pool, channelRepository *repositories.ChannelRepository, sensorRepository *repositories.SensorRepository, validator *dependencies.Validator) (ChannelHandler, error) {
+func NewChannelHandler(db *pgxpool.Pool, nodeRepository *repositories.NodeRepository, sensorRepository *repositories.SensorRepository, pipeline *ingest.Pipeline, validator *dependencies.Validator) (ChannelHandler, error) {
 	return ChannelHandler{
 		db:               db,
-		repository:       channelRepository,
+		nodeRepository:   nodeRepository,
 		sensorRepository: sensorRepository,
+		pipeline:         pipeline,
 		validator:        validator,
 	}, nil
 }
 
+// checkApiKeyNode refuse the sensor of another node when the request is authenticated with a node API key
+func (h *ChannelHandler) checkApiKeyNode(ctx context.Context, c *fiber.Ctx, idSensor int) error {
+	keyNode := h.validator.GetApiKeyNode(c)
+	if keyNode == 0 {
+		return nil
+	}
+	sensorNode, err := h.sensorRepository.GetIdNodeById(ctx, h.db, idSensor)
+	if err != nil {
+		return err
+	}
+	if sensorNode != keyNode {
+		return fiber.NewError(fiber.StatusForbidden, "The API key can only send channel to the sensor of its node")
+	}
+	return nil
+}
+
 func (h *ChannelHandler) CreateForm(c *fiber.Ctx) (err error) {
 	idSensor := c.QueryInt("id_sensor", 0)
 	return c.Render("channel_form", fiber.Map{"title": "Create Channel", "idSensor": idSensor}, "layouts/main")
@@ -58,6 +85,14 @@ func (h *ChannelHandler) Create(c *fiber.Ctx) (err error) {
 	// Wait for parsing and get parsing error
 	err = <-parseChannel
 	if err != nil {
+		// An invalid body is counted on the sensor it name when the user can send channel to it
+		currentUserRes := <-currentUserChannel
+		if currentUserRes.err == nil && bodyPayload.IdSensor != 0 {
+			allowed, accessErr := h.nodeRepository.HasSensorAccess(ctx, h.db, bodyPayload.IdSensor, &currentUserRes.res, entities.ShareWrite)
+			if accessErr == nil && allowed {
+				h.pipeline.Record(bodyPayload.IdSensor, len(c.Body()), err)
+			}
+		}
 		return err
 	}
 
@@ -89,15 +124,110 @@ func (h *ChannelHandler) Create(c *fiber.Ctx) (err error) {
 		return err
 	}
 
-	if currentUser.IdUser != sensorOwnerId {
-		return fiber.NewError(fiber.StatusForbidden, "You can't send channel to another user's sensor")
+	err = checkSensorAccess(ctx, h.db, h.nodeRepository, &currentUser, bodyPayload.IdSensor, entities.ShareWrite, "You can't send channel to another user's sensor")
+	if err != nil {
+		return err
+	}
+	err = h.checkApiKeyNode(ctx, c, bodyPayload.IdSensor)
+	if err != nil {
+		return err
 	}
+	defer func() {
+		h.pipeline.Record(bodyPayload.IdSensor, len(c.Body()), err)
+	}()
 
-	_, err = h.repository.Create(ctx, h.db, &bodyPayload)
+	// Each field is stored as its own channel, the first error is returned once every field is tried
+	payloads := bodyPayload.Split()
+	allCoalesced, allDropped := true, true
+	for i := range payloads {
+		_, coalesced, storeErr := h.pipeline.Store(ctx, sensorOwnerId, &payloads[i])
+		var throttled *ingest.ThrottledError
+		if errors.As(storeErr, &throttled) {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		}
+		if errors.Is(storeErr, ingest.ErrDropped) {
+			continue
+		}
+		if storeErr != nil {
+			if err == nil {
+				err = storeErr
+			}
+			continue
+		}
+		allDropped = false
+		allCoalesced = allCoalesced && coalesced
+	}
 	if err != nil {
 		return err
 	}
+	if allDropped {
+		return c.Status(fiber.StatusOK).SendString(ingest.ErrDropped.Message)
+	}
+	if allCoalesced {
+		return c.Status(fiber.StatusOK).SendString("Channel coalesced into the last channel")
+	}
 
 	return c.Status(fiber.StatusCreated).SendString("Add new channel")
 
 }
+
+// CreateBulk store the array of channel a device buffered while offline, each at its own time, in
+// one transaction. Every channel is stored or none of it
+func (h *ChannelHandler) CreateBulk(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := entities.ChannelBulk{}
+	err = h.validator.ParseBodyArray(c, &bodyPayload, &bodyPayload.Channels)
+	if err != nil {
+		return err
+	}
+
+	// A device clock running a bit ahead is tolerated
+	latest := time.Now().Add(bulkMaxClockSkew)
+	sensorIds := []int{}
+	seen := map[int]bool{}
+	for i, channel := range bodyPayload.Channels {
+		if channel.Time != nil && channel.Time.After(latest) {
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Channel %d is in the future", i))
+		}
+		if !seen[channel.IdSensor] {
+			seen[channel.IdSensor] = true
+			sensorIds = append(sensorIds, channel.IdSensor)
+		}
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	// The usage is counted on the sensor owner, a bulk shared with the user is stored for one owner
+	ownerId := 0
+	for _, id := range sensorIds {
+		err = checkSensorAccess(ctx, h.db, h.nodeRepository, &currentUser, id, entities.ShareWrite, "You can't send channel to another user's sensor")
+		if err != nil {
+			return err
+		}
+		err = h.checkApiKeyNode(ctx, c, id)
+		if err != nil {
+			return err
+		}
+		sensorOwnerId, err := h.sensorRepository.GetIdUserWhoOwnSensorById(ctx, h.db, id)
+		if err != nil {
+			return err
+		}
+		if ownerId != 0 && sensorOwnerId != ownerId {
+			return fiber.NewError(fiber.StatusBadRequest, "The channels of a bulk must belong to the sensors of one user")
+		}
+		ownerId = sensorOwnerId
+	}
+
+	created, err := h.pipeline.StoreBulk(ctx, ownerId, bodyPayload.Channels, len(c.Body()))
+	if err != nil {
+		for _, id := range sensorIds {
+			h.pipeline.Record(id, 0, err)
+		}
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(entities.ChannelBulkResult{Created: created})
+}
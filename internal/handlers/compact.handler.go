@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/dafaath/iot-server/internal/ingest"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// compactCodes is the body of an error of the compact profile instead of the message, the device
+// only need to know what to do next
+var compactCodes = map[int]string{
+	fiber.StatusBadRequest:            "BAD",
+	fiber.StatusUnauthorized:          "AUTH",
+	fiber.StatusForbidden:             "OWN",
+	fiber.StatusNotFound:              "NF",
+	fiber.StatusRequestEntityTooLarge: "BIG",
+	fiber.StatusUnprocessableEntity:   "VAL",
+	fiber.StatusTooManyRequests:       "RATE",
+}
+
+// CompactHandler is the ingest for a 2G or NB-IoT device where every byte and round trip cost
+// battery, the body is plain text and the answer is a short code
+type CompactHandler struct {
+	db               *pgxpool.Pool
+	sensorRepository *repositories.SensorRepository
+	apiKeyRepository *repositories.ApiKeyRepository
+	pipeline         *ingest.Pipeline
+	validator        *dependencies.Validator
+}
+
+func NewCompactHandler(db *pgxpool.Pool, sensorRepository *repositories.SensorRepository, apiKeyRepository *repositories.ApiKeyRepository, pipeline *ingest.Pipeline, validator *dependencies.Validator) (CompactHandler, error) {
+	return CompactHandler{
+		db:               db,
+		sensorRepository: sensorRepository,
+		apiKeyRepository: apiKeyRepository,
+		pipeline:         pipeline,
+		validator:        validator,
+	}, nil
+}
+
+// authenticate with the node API key when it is sent, keyNode is 0 for the user token
+func (h *CompactHandler) authenticate(ctx context.Context, c *fiber.Ctx) (currentUser entities.UserRead, keyNode int, err error) {
+	key := c.Get(entities.ApiKeyHeader)
+	if key != "" {
+		currentUser, keyNode, err = h.apiKeyRepository.Authenticate(ctx, h.db, key)
+		if err == nil {
+			// Like ValidateUserOrApiKey, the rate limit then count the next request on the key
+			c.Locals("apiKeyNode", keyNode)
+		}
+		return currentUser, keyNode, err
+	}
+	currentUser, err = helper.ValidateUserCredentical(c)
+	return currentUser, 0, err
+}
+
+// fail answer the short code of the error, Retry-After is kept for a throttled channel
+func (h *CompactHandler) fail(c *fiber.Ctx, err error) error {
+	code := fiber.StatusInternalServerError
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		code = fiberErr.Code
+	}
+	var throttled *ingest.ThrottledError
+	if errors.As(err, &throttled) {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+	}
+	body, ok := compactCodes[code]
+	if !ok {
+		body = "ERR"
+	}
+	return c.Status(code).SendString(body)
+}
+
+// Create store the value of the body in the sensor of the url like POST /channel. The answer is "OK"
+// with 201, "C" with 200 when every value is coalesced and "D" with 200 when a value is dropped.
+// q=1 or "Prefer: return=minimal" answer 204 without body on success. Every value is stored even
+// when one of them fail, the code of the first error is returned
+func (h *CompactHandler) Create(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	currentUser, keyNode, err := h.authenticate(ctx, c)
+	if err != nil {
+		return h.fail(c, err)
+	}
+
+	idSensor, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return h.fail(c, err)
+	}
+
+	sensorOwnerId, err := h.sensorRepository.GetIdUserWhoOwnSensorById(ctx, h.db, idSensor)
+	if err != nil {
+		return h.fail(c, err)
+	}
+	if sensorOwnerId != currentUser.IdUser {
+		return h.fail(c, fiber.NewError(fiber.StatusForbidden, "You can't send channel to another user's sensor"))
+	}
+	if keyNode != 0 {
+		sensorNode, err := h.sensorRepository.GetIdNodeById(ctx, h.db, idSensor)
+		if err != nil {
+			return h.fail(c, err)
+		}
+		if sensorNode != keyNode {
+			return h.fail(c, fiber.NewError(fiber.StatusForbidden, "The API key can only send channel to the sensor of its node"))
+		}
+	}
+
+	channels, err := ingest.ParseCompact(idSensor, string(c.Body()))
+	if err != nil {
+		h.pipeline.Record(idSensor, len(c.Body()), err)
+		return h.fail(c, err)
+	}
+
+	var firstErr error
+	allCoalesced, dropped := true, false
+	for i := range channels {
+		_, coalesced, err := h.pipeline.Store(ctx, sensorOwnerId, &channels[i])
+		h.pipeline.Record(idSensor, len(c.Body()), err)
+		if errors.Is(err, ingest.ErrDropped) {
+			dropped = true
+			continue
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		allCoalesced = allCoalesced && coalesced
+	}
+	if firstErr != nil {
+		return h.fail(c, firstErr)
+	}
+
+	if c.Query("q") == "1" || strings.Contains(c.Get("Prefer"), "return=minimal") {
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+	if dropped {
+		return c.Status(fiber.StatusOK).SendString("D")
+	}
+	if allCoalesced {
+		return c.Status(fiber.StatusOK).SendString("C")
+	}
+	return c.Status(fiber.StatusCreated).SendString("OK")
+}
@@ -0,0 +1,814 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	dashboardAlertLimit  = 10
+	dashboardChartPoints = 200
+)
+
+type DashboardHandler struct {
+	db                 *pgxpool.Pool
+	repository         *repositories.DashboardRepository
+	sensorRepository   *repositories.SensorRepository
+	nodeRepository     *repositories.NodeRepository
+	hardwareRepository *repositories.HardwareRepository
+	channelRepository  *repositories.ChannelRepository
+	validator          *dependencies.Validator
+}
+
+func NewDashboardHandler(db *pgxpool.Pool, dashboardRepository *repositories.DashboardRepository, sensorRepository *repositories.SensorRepository, nodeRepository *repositories.NodeRepository, hardwareRepository *repositories.HardwareRepository, channelRepository *repositories.ChannelRepository, validator *dependencies.Validator) (DashboardHandler, error) {
+	return DashboardHandler{
+		db:                 db,
+		repository:         dashboardRepository,
+		sensorRepository:   sensorRepository,
+		nodeRepository:     nodeRepository,
+		hardwareRepository: hardwareRepository,
+		channelRepository:  channelRepository,
+		validator:          validator,
+	}, nil
+}
+
+// dashboardWidgetView is the widget with everything needed to render it server-side
+type dashboardWidgetView struct {
+	entities.Widget
+	GridStyle  string
+	Sensor     *entities.Sensor
+	Latest     *entities.Channel
+	LatestTime string
+	RangeMs    int64
+	Min        float64
+	Max        float64
+	Alerts     []dashboardAlertView
+	Markers    []dashboardMarkerView
+	IsValue    bool
+	IsGauge    bool
+	IsChart    bool
+	IsMap      bool
+	IsAlert    bool
+}
+
+type dashboardAlertView struct {
+	Time  string
+	Value float64
+}
+
+type dashboardMarkerView struct {
+	Name      string
+	Latitude  float64
+	Longitude float64
+}
+
+func widgetRange(widget *entities.WidgetCreate) (time.Duration, error) {
+	if widget.Options.Range == "" {
+		return 24 * time.Hour, nil
+	}
+	duration, err := time.ParseDuration(widget.Options.Range)
+	if err != nil || duration <= 0 {
+		return 0, fiber.NewError(400, fmt.Sprintf("Invalid widget range %s, use duration like 1h or 168h", widget.Options.Range))
+	}
+	return duration, nil
+}
+
+// getDashboard return the dashboard if the current user own it or is admin
+func (h *DashboardHandler) getDashboard(ctx context.Context, c *fiber.Ctx, message string) (dashboard entities.Dashboard, err error) {
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return dashboard, err
+	}
+
+	dashboard, err = h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return dashboard, err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return dashboard, err
+	}
+
+	if dashboard.IdUser != currentUser.IdUser && !currentUser.IsAdmin {
+		return dashboard, fiber.NewError(403, message)
+	}
+	return dashboard, nil
+}
+
+// validateWidget check the widget fit in the grid and its sensor belong to the dashboard owner
+func (h *DashboardHandler) validateWidget(ctx context.Context, dashboard *entities.Dashboard, payload *entities.WidgetCreate) error {
+	if payload.X+payload.Width > dashboard.Columns {
+		return fiber.NewError(400, fmt.Sprintf("Widget doesn't fit in the grid, x + width must not exceed %d columns", dashboard.Columns))
+	}
+
+	_, err := widgetRange(payload)
+	if err != nil {
+		return err
+	}
+
+	if payload.Options.Min != nil && payload.Options.Max != nil && *payload.Options.Min >= *payload.Options.Max {
+		return fiber.NewError(400, "Widget min must be less than max")
+	}
+
+	if payload.Type == "gauge" && (payload.Options.Min == nil || payload.Options.Max == nil) {
+		return fiber.NewError(400, "Gauge widget require min and max option")
+	}
+
+	if payload.IdSensor == nil {
+		return nil
+	}
+
+	sensorOwnerId, err := h.sensorRepository.GetIdUserWhoOwnSensorById(ctx, h.db, *payload.IdSensor)
+	if err != nil {
+		return err
+	}
+	if sensorOwnerId != dashboard.IdUser {
+		return fiber.NewError(403, "Widget can only show sensor owned by the dashboard owner")
+	}
+	return nil
+}
+
+// buildWidgetViews load the data of every widget for server-side rendering
+func (h *DashboardHandler) buildWidgetViews(ctx context.Context, dashboard *entities.Dashboard, widgets []entities.Widget) ([]dashboardWidgetView, error) {
+	sensorIds := []int{}
+	for _, widget := range widgets {
+		if widget.IdSensor != nil {
+			sensorIds = append(sensorIds, *widget.IdSensor)
+		}
+	}
+
+	latestChannels, err := h.channelRepository.GetLatestBySensors(ctx, h.db, sensorIds)
+	if err != nil {
+		return nil, err
+	}
+	latestBySensor := map[int]entities.Channel{}
+	for _, channel := range latestChannels {
+		latestBySensor[channel.IdSensor] = channel
+	}
+
+	// Every node of the owner, only loaded when there is a map widget
+	var ownerNodes []entities.Node
+
+	views := []dashboardWidgetView{}
+	for _, widget := range widgets {
+		view := dashboardWidgetView{
+			Widget:    widget,
+			GridStyle: fmt.Sprintf("grid-column: %d / span %d; grid-row: %d / span %d;", widget.X+1, widget.Width, widget.Y+1, widget.Height),
+			IsValue:   widget.Type == "value",
+			IsGauge:   widget.Type == "gauge",
+			IsChart:   widget.Type == "chart",
+			IsMap:     widget.Type == "map",
+			IsAlert:   widget.Type == "alert",
+		}
+
+		if widget.Options.Min != nil {
+			view.Min = *widget.Options.Min
+		}
+		if widget.Options.Max != nil {
+			view.Max = *widget.Options.Max
+		}
+
+		if widget.IdSensor != nil {
+			sensor, err := h.sensorRepository.GetById(ctx, h.db, *widget.IdSensor)
+			if err != nil {
+				return nil, err
+			}
+			view.Sensor = &sensor
+			if view.Title == "" {
+				view.Title = sensor.Name
+			}
+
+			if latest, ok := latestBySensor[sensor.IdSensor]; ok {
+				view.Latest = &latest
+				view.LatestTime = latest.Time.Format("2006-01-02 15:04:05 MST")
+			}
+		}
+
+		switch widget.Type {
+		case "chart":
+			duration, err := widgetRange(&widget.WidgetCreate)
+			if err != nil {
+				return nil, err
+			}
+			view.RangeMs = duration.Milliseconds()
+		case "alert":
+			alerts, err := h.channelRepository.GetOutOfRangeBySensor(ctx, h.db, *widget.IdSensor, widget.Options.Min, widget.Options.Max, dashboardAlertLimit)
+			if err != nil {
+				return nil, err
+			}
+			for _, alert := range alerts {
+				view.Alerts = append(view.Alerts, dashboardAlertView{
+					Time:  alert.Time.Format("2006-01-02 15:04:05 MST"),
+					Value: alert.Value,
+				})
+			}
+		case "map":
+			nodes := []entities.Node{}
+			if view.Sensor != nil {
+				node, err := h.nodeRepository.GetById(ctx, h.db, view.Sensor.IdNode)
+				if err != nil {
+					return nil, err
+				}
+				nodes = append(nodes, node)
+			} else {
+				if ownerNodes == nil {
+					ownerNodes, err = h.nodeRepository.GetAll(ctx, h.db, &entities.UserRead{IdUser: dashboard.IdUser}, &entities.NodeQuery{})
+					if err != nil {
+						return nil, err
+					}
+				}
+				nodes = ownerNodes
+			}
+
+			for _, node := range nodes {
+				latitude, longitude, ok := entities.ParseNodeLocation(node.Location)
+				if !ok {
+					continue
+				}
+				view.Markers = append(view.Markers, dashboardMarkerView{
+					Name:      node.Name,
+					Latitude:  latitude,
+					Longitude: longitude,
+				})
+			}
+			if view.Title == "" {
+				view.Title = "Node Location"
+			}
+		}
+
+		views = append(views, view)
+	}
+
+	return views, nil
+}
+
+func (h *DashboardHandler) CreateForm(c *fiber.Ctx) (err error) {
+	return c.Render("dashboard_form", fiber.Map{"title": "Create Dashboard"}, "layouts/main")
+}
+
+func (h *DashboardHandler) Create(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := entities.DashboardCreate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	dashboard, err := h.repository.Create(ctx, h.db, &bodyPayload, &currentUser)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(dashboard)
+}
+
+func (h *DashboardHandler) GetAll(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	dashboards, err := h.repository.GetAll(ctx, h.db, &currentUser)
+	if err != nil {
+		return err
+	}
+
+	accept := c.Accepts("application/json", "text/html")
+	switch accept {
+	case "text/html":
+		return c.Render("dashboard", fiber.Map{
+			"title":      "Dashboard",
+			"dashboards": dashboards,
+		}, "layouts/main")
+	default:
+		return c.Status(fiber.StatusOK).JSON(dashboards)
+	}
+}
+
+func (h *DashboardHandler) GetById(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	dashboard, err := h.getDashboard(ctx, c, "You can’t see another user’s dashboard")
+	if err != nil {
+		return err
+	}
+
+	widgets, err := h.repository.GetWidgets(ctx, h.db, dashboard.IdDashboard)
+	if err != nil {
+		return err
+	}
+
+	accept := c.Accepts("application/json", "text/html")
+	switch accept {
+	case "text/html":
+		views, err := h.buildWidgetViews(ctx, &dashboard, widgets)
+		if err != nil {
+			return err
+		}
+
+		// Sensor that can be bound to a new widget
+		sensors, err := h.sensorRepository.GetAll(ctx, h.db, &entities.UserRead{IdUser: dashboard.IdUser}, &entities.SensorQuery{})
+		if err != nil {
+			return err
+		}
+
+		publicUrl := ""
+		if dashboard.PublicToken != nil {
+			publicUrl = fmt.Sprintf("%s/public/dashboard/%s", c.BaseURL(), *dashboard.PublicToken)
+		}
+
+		return c.Render("dashboard_detail", fiber.Map{
+			"title":     dashboard.Name,
+			"dashboard": dashboard,
+			"widgets":   views,
+			"sensors":   sensors,
+			"publicUrl": publicUrl,
+		}, "layouts/main")
+	default:
+		return c.Status(fiber.StatusOK).JSON(entities.DashboardWithWidgets{
+			Dashboard: dashboard,
+			Widgets:   widgets,
+		})
+	}
+}
+
+func (h *DashboardHandler) UpdateForm(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	dashboard, err := h.getDashboard(ctx, c, "Can’t edit another user’s data")
+	if err != nil {
+		return err
+	}
+
+	return c.Render("dashboard_form", fiber.Map{
+		"title":     "Edit Dashboard",
+		"dashboard": dashboard,
+		"edit":      true,
+	}, "layouts/main")
+}
+
+func (h *DashboardHandler) Update(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := &entities.DashboardUpdate{}
+	err = h.validator.ParseBody(c, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	dashboard, err := h.getDashboard(ctx, c, "Can’t edit another user’s data")
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.Update(ctx, h.db, &dashboard, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success edit dashboard")
+}
+
+func (h *DashboardHandler) Delete(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	dashboard, err := h.getDashboard(ctx, c, "You can’t delete another user’s dashboard")
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.Delete(ctx, h.db, dashboard.IdDashboard)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString(fmt.Sprintf("Success delete dashboard, id: %d", dashboard.IdDashboard))
+}
+
+func (h *DashboardHandler) CreateWidget(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := entities.WidgetCreate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	dashboard, err := h.getDashboard(ctx, c, "Can’t edit another user’s data")
+	if err != nil {
+		return err
+	}
+
+	err = h.validateWidget(ctx, &dashboard, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	widget, err := h.repository.CreateWidget(ctx, h.db, dashboard.IdDashboard, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(widget)
+}
+
+func (h *DashboardHandler) UpdateWidget(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	widgetId, err := h.validator.ParseIntFromUrlParameter(c, "widget")
+	if err != nil {
+		return err
+	}
+
+	bodyPayload := entities.WidgetCreate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	dashboard, err := h.getDashboard(ctx, c, "Can’t edit another user’s data")
+	if err != nil {
+		return err
+	}
+
+	widget, err := h.repository.GetWidgetById(ctx, h.db, dashboard.IdDashboard, widgetId)
+	if err != nil {
+		return err
+	}
+
+	err = h.validateWidget(ctx, &dashboard, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.UpdateWidget(ctx, h.db, &widget, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success edit widget")
+}
+
+func (h *DashboardHandler) DeleteWidget(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	widgetId, err := h.validator.ParseIntFromUrlParameter(c, "widget")
+	if err != nil {
+		return err
+	}
+
+	dashboard, err := h.getDashboard(ctx, c, "Can’t edit another user’s data")
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.DeleteWidget(ctx, h.db, dashboard.IdDashboard, widgetId)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString(fmt.Sprintf("Success delete widget, id: %d", widgetId))
+}
+
+// Publish generate a new public token, so a previously shared link stop working
+func (h *DashboardHandler) Publish(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	dashboard, err := h.getDashboard(ctx, c, "Can’t edit another user’s data")
+	if err != nil {
+		return err
+	}
+
+	token := uuid.New().String()
+	err = h.repository.SetPublicToken(ctx, h.db, dashboard.IdDashboard, &token)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"public_token": token,
+		"url":          fmt.Sprintf("%s/public/dashboard/%s", c.BaseURL(), token),
+	})
+}
+
+func (h *DashboardHandler) Unpublish(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	dashboard, err := h.getDashboard(ctx, c, "Can’t edit another user’s data")
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.SetPublicToken(ctx, h.db, dashboard.IdDashboard, nil)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success unpublish dashboard")
+}
+
+func (h *DashboardHandler) getPublicDashboard(ctx context.Context, c *fiber.Ctx) (dashboard entities.Dashboard, widgets []entities.Widget, err error) {
+	dashboard, err = h.repository.GetByPublicToken(ctx, h.db, c.Params("token"))
+	if err != nil {
+		return dashboard, widgets, err
+	}
+
+	widgets, err = h.repository.GetWidgets(ctx, h.db, dashboard.IdDashboard)
+	if err != nil {
+		return dashboard, widgets, err
+	}
+	return dashboard, widgets, nil
+}
+
+// GetPublic render the published dashboard without authentication, the viewer can only
+// read the data of the sensor bound to its widgets
+func (h *DashboardHandler) GetPublic(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	dashboard, widgets, err := h.getPublicDashboard(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	views, err := h.buildWidgetViews(ctx, &dashboard, widgets)
+	if err != nil {
+		return err
+	}
+
+	return c.Render("dashboard_detail", fiber.Map{
+		"title":       dashboard.Name,
+		"dashboard":   dashboard,
+		"widgets":     views,
+		"public":      true,
+		"publicToken": c.Params("token"),
+	}, "layouts/public")
+}
+
+// GetPublicWidgetSeries return the chart series of a published chart widget over its configured range
+func (h *DashboardHandler) GetPublicWidgetSeries(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	widgetId, err := h.validator.ParseIntFromUrlParameter(c, "widget")
+	if err != nil {
+		return err
+	}
+
+	dashboard, err := h.repository.GetByPublicToken(ctx, h.db, c.Params("token"))
+	if err != nil {
+		return err
+	}
+
+	widget, err := h.repository.GetWidgetById(ctx, h.db, dashboard.IdDashboard, widgetId)
+	if err != nil {
+		return err
+	}
+	if widget.Type != "chart" || widget.IdSensor == nil {
+		return fiber.NewError(400, fmt.Sprintf("Widget %d is not a chart", widgetId))
+	}
+
+	duration, err := widgetRange(&widget.WidgetCreate)
+	if err != nil {
+		return err
+	}
+	to := time.Now().UTC()
+	from := to.Add(-duration)
+	query := entities.ChannelQuery{From: &from, To: &to}
+	interval := duration / dashboardChartPoints
+	if interval >= time.Second {
+		query.Interval = interval.Round(time.Second)
+	}
+
+	series := [][2]interface{}{}
+	err = h.channelRepository.ForEachBySensor(ctx, h.db, *widget.IdSensor, query, func(channel entities.Channel) error {
+		series = append(series, [2]interface{}{
+			channel.Time.UnixMilli(),
+			channel.Value,
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"interval": query.Interval.Seconds(),
+		"series":   series,
+	})
+}
+
+// AuthorizePublicRealtime subscribe the realtime feed to the sensor of the published dashboard widgets
+func (h *DashboardHandler) AuthorizePublicRealtime(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	if !websocket.IsWebSocketUpgrade(c) {
+		return fiber.ErrUpgradeRequired
+	}
+
+	_, widgets, err := h.getPublicDashboard(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	sensorIds := []int{}
+	for _, widget := range widgets {
+		if widget.IdSensor != nil {
+			sensorIds = append(sensorIds, *widget.IdSensor)
+		}
+	}
+	if len(sensorIds) == 0 {
+		return fiber.NewError(400, "Dashboard has no widget bound to a sensor")
+	}
+
+	c.Locals("sensorIds", sensorIds)
+	return c.Next()
+}
+
+// validateTemplate check every widget fit in the grid and the hardware exist
+func (h *DashboardHandler) validateTemplate(ctx context.Context, template *entities.DashboardTemplateCreate) error {
+	if template.Columns == 0 {
+		template.Columns = 12
+	}
+	for i, widget := range template.Widgets {
+		if widget.X+widget.Width > template.Columns {
+			return fiber.NewError(400, fmt.Sprintf("Widget %d doesn't fit in the grid, x + width must not exceed %d columns", i, template.Columns))
+		}
+		_, err := widgetRange(&entities.WidgetCreate{Options: widget.Options})
+		if err != nil {
+			return err
+		}
+	}
+
+	if template.IdHardware != nil {
+		_, err := h.hardwareRepository.GetById(ctx, h.db, *template.IdHardware)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *DashboardHandler) GetAllTemplate(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	templates, err := h.repository.GetAllTemplate(ctx, h.db, &currentUser)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(templates)
+}
+
+// CreateTemplate save a template from its JSON definition, the template is shared when
+// the admin create it with query ?global=true
+func (h *DashboardHandler) CreateTemplate(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := entities.DashboardTemplateCreate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	global, err := strconv.ParseBool(c.Query("global", "false"))
+	if err != nil {
+		return fiber.NewError(400, "global must be true or false")
+	}
+
+	idUser := &currentUser.IdUser
+	if global {
+		if !currentUser.IsAdmin {
+			return fiber.NewError(403, "Only admin can create shared template")
+		}
+		idUser = nil
+	}
+
+	err = h.validateTemplate(ctx, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	template, err := h.repository.CreateTemplate(ctx, h.db, &bodyPayload, idUser)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(template)
+}
+
+// SaveAsTemplate save the dashboard layout as template, the widget sensor is replaced by its name
+// so the template can be bound to the sensor of another node
+func (h *DashboardHandler) SaveAsTemplate(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := entities.DashboardTemplateSave{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	dashboard, err := h.getDashboard(ctx, c, "Can’t edit another user’s data")
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	idUser := &dashboard.IdUser
+	if bodyPayload.Global {
+		if !currentUser.IsAdmin {
+			return fiber.NewError(403, "Only admin can create shared template")
+		}
+		idUser = nil
+	}
+
+	widgets, err := h.repository.GetWidgets(ctx, h.db, dashboard.IdDashboard)
+	if err != nil {
+		return err
+	}
+
+	template := entities.DashboardTemplateCreate{
+		Name:       bodyPayload.Name,
+		IdHardware: bodyPayload.IdHardware,
+		Columns:    dashboard.Columns,
+		AutoApply:  bodyPayload.AutoApply,
+		Widgets:    []entities.TemplateWidget{},
+	}
+	for _, widget := range widgets {
+		templateWidget := entities.TemplateWidget{
+			Type:    widget.Type,
+			Title:   widget.Title,
+			X:       widget.X,
+			Y:       widget.Y,
+			Width:   widget.Width,
+			Height:  widget.Height,
+			Options: widget.Options,
+		}
+		if widget.IdSensor != nil {
+			sensor, err := h.sensorRepository.GetById(ctx, h.db, *widget.IdSensor)
+			if err != nil {
+				return err
+			}
+			templateWidget.SensorName = sensor.Name
+		}
+		template.Widgets = append(template.Widgets, templateWidget)
+	}
+
+	err = h.validateTemplate(ctx, &template)
+	if err != nil {
+		return err
+	}
+
+	created, err := h.repository.CreateTemplate(ctx, h.db, &template, idUser)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(created)
+}
+
+func (h *DashboardHandler) DeleteTemplate(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	template, err := h.repository.GetTemplateById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	isOwner := template.IdUser != nil && *template.IdUser == currentUser.IdUser
+	if !isOwner && !currentUser.IsAdmin {
+		return fiber.NewError(403, "You can’t delete another user’s or shared template")
+	}
+
+	err = h.repository.DeleteTemplate(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString(fmt.Sprintf("Success delete dashboard template, id: %d", id))
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+)
+
+const apiTitle = "IoT Server"
+const apiVersion = "2.0.0"
+
+// openAPIEntities is the JSON request and response of the route used by the firmware and the
+// frontend. A route answering plain text only has its request
+var openAPIEntities = map[string]helper.OpenAPIEntity{
+	"POST /user/signup":          {Request: entities.UserCreate{}},
+	"POST /user/login":           {Request: entities.UserLogin{}, Response: entities.UserTokens{}},
+	"POST /user/refresh":         {Request: entities.UserRefresh{}, Response: entities.UserTokens{}},
+	"GET /user/session":          {Response: []entities.UserSession{}},
+	"POST /user/forget-password": {Request: entities.UserForgotPassword{}},
+	"GET /user/activation":       {Query: entities.UserValidate{}},
+	"GET /user/profile":          {Response: entities.UserProfile{}},
+	"PUT /user/email":            {Request: entities.UserUpdateEmail{}},
+	"PUT /user/theme":            {Request: entities.UserTheme{}},
+	"PUT /user/language":         {Request: entities.UserLanguage{}},
+	"GET /user":                  {Response: []entities.UserRead{}},
+	"GET /user/{id}":             {Response: entities.UserRead{}},
+	"PUT /user/{id}":             {Request: entities.UserUpdatePassword{}},
+
+	"POST /hardware":     {Request: entities.HardwareCreate{}},
+	"GET /hardware":      {Query: entities.HardwareQuery{}, Response: map[string][]entities.Hardware{}},
+	"GET /hardware/{id}": {Response: entities.HardwareWithNode{}},
+	"PUT /hardware/{id}": {Request: entities.HardwareUpdate{}},
+
+	"POST /node":                     {Request: entities.NodeCreate{}},
+	"GET /node":                      {Query: entities.NodeQuery{}, Response: []entities.Node{}},
+	"GET /node/{id}":                 {Response: entities.NodeWithHardwareAndSensors{}},
+	"POST /node/{id}/heartbeat":      {Response: entities.NodeHeartbeat{}},
+	"GET /node/{id}/firmware":        {Response: entities.NodeFirmware{}},
+	"PUT /node/{id}/firmware":        {Request: entities.NodeFirmwareAssign{}, Response: entities.NodeFirmware{}},
+	"POST /node/{id}/firmware/check": {Request: entities.FirmwareCheck{}, Response: entities.FirmwareCheckResult{}},
+
+	"GET /firmware":      {Query: entities.FirmwareQuery{}, Response: []entities.Firmware{}},
+	"GET /firmware/{id}": {Response: entities.Firmware{}},
+	"PUT /node/{id}":     {Request: entities.NodeUpdate{}},
+
+	"POST /sensor":     {Request: entities.SensorCreate{}},
+	"GET /sensor":      {Query: entities.SensorListQuery{}, Response: entities.SensorList{}},
+	"GET /sensor/{id}": {Response: entities.SensorWithChannel{}},
+	"PUT /sensor/{id}": {Request: entities.SensorUpdate{}},
+
+	"POST /channel":      {Request: entities.ChannelCreate{}},
+	"POST /channel/bulk": {Request: []entities.ChannelBulkItem{}, Response: entities.ChannelBulkResult{}, Status: fiber.StatusCreated},
+}
+
+type DocsHandler struct {
+	app *fiber.App
+}
+
+func NewDocsHandler(app *fiber.App) (DocsHandler, error) {
+	return DocsHandler{
+		app: app,
+	}, nil
+}
+
+// Spec is generated on every request so newly registered routes are always included
+func (h *DocsHandler) Spec(c *fiber.Ctx) (err error) {
+	spec := helper.BuildOpenAPISpec(h.app.GetRoutes(true), apiTitle, apiVersion, openAPIEntities)
+	return c.Status(fiber.StatusOK).JSON(spec)
+}
+
+func (h *DocsHandler) SwaggerUI(c *fiber.Ctx) (err error) {
+	return c.Render("swagger", fiber.Map{
+		"title":   "API Documentation",
+		"specUrl": "/openapi.json",
+	})
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type FeatureHandler struct {
+	db             *pgxpool.Pool
+	repository     *repositories.FeatureRepository
+	userRepository *repositories.UserRepository
+	validator      *dependencies.Validator
+}
+
+func NewFeatureHandler(db *pgxpool.Pool, featureRepository *repositories.FeatureRepository, userRepository *repositories.UserRepository, validator *dependencies.Validator) (FeatureHandler, error) {
+	return FeatureHandler{
+		db:             db,
+		repository:     featureRepository,
+		userRepository: userRepository,
+		validator:      validator,
+	}, nil
+}
+
+func (h *FeatureHandler) GetAll(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	flags, err := h.repository.GetAll(ctx, h.db, &currentUser)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(flags)
+}
+
+func (h *FeatureHandler) Update(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	name := c.Params("name")
+
+	bodyPayload := entities.FeatureFlagUpdate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.Set(ctx, h.db, name, *bodyPayload.Enabled)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString(fmt.Sprintf("Success set feature %s to %t", name, *bodyPayload.Enabled))
+}
+
+func (h *FeatureHandler) UpdateForUser(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	name := c.Params("name")
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	bodyPayload := entities.FeatureFlagUpdate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.userRepository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.SetForUser(ctx, h.db, name, id, *bodyPayload.Enabled)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString(fmt.Sprintf("Success set feature %s to %t for user id: %d", name, *bodyPayload.Enabled, id))
+}
+
+func (h *FeatureHandler) DeleteForUser(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	name := c.Params("name")
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.DeleteForUser(ctx, h.db, name, id)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString(fmt.Sprintf("Success remove feature %s override for user id: %d", name, id))
+}
@@ -0,0 +1,315 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type FirmwareHandler struct {
+	db                 *pgxpool.Pool
+	repository         *repositories.FirmwareRepository
+	nodeRepository     *repositories.NodeRepository
+	hardwareRepository *repositories.HardwareRepository
+	validator          *dependencies.Validator
+}
+
+func NewFirmwareHandler(db *pgxpool.Pool, firmwareRepository *repositories.FirmwareRepository, nodeRepository *repositories.NodeRepository, hardwareRepository *repositories.HardwareRepository, validator *dependencies.Validator) (FirmwareHandler, error) {
+	return FirmwareHandler{
+		db:                 db,
+		repository:         firmwareRepository,
+		nodeRepository:     nodeRepository,
+		hardwareRepository: hardwareRepository,
+		validator:          validator,
+	}, nil
+}
+
+// getOwnFirmware return the firmware in the url when it is uploaded by the current user, an admin
+// can access every firmware
+func (h *FirmwareHandler) getOwnFirmware(ctx context.Context, c *fiber.Ctx) (firmware entities.Firmware, err error) {
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return firmware, err
+	}
+
+	firmware, err = h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return firmware, err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return firmware, err
+	}
+
+	if firmware.IdUser != currentUser.IdUser && !currentUser.IsAdmin {
+		return firmware, fiber.NewError(403, "You can’t access another user’s firmware")
+	}
+	return firmware, nil
+}
+
+// Create upload a firmware of the hardware as the multipart file field, with the version and notes
+// as form field
+func (h *FirmwareHandler) Create(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := &entities.FirmwareCreate{}
+	err = h.validator.ParseBody(c, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return fiber.NewError(400, "The firmware binary must be sent as the file field of a multipart form")
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.hardwareRepository.GetById(ctx, h.db, bodyPayload.IdHardware)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	firmware, err := h.repository.Create(ctx, tx, &currentUser, bodyPayload, file)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(firmware)
+}
+
+// GetAll return the firmware of the user, every firmware for an admin
+func (h *FirmwareHandler) GetAll(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	query := entities.FirmwareQuery{}
+	err = h.validator.ParseQuery(c, &query)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	firmwares, err := h.repository.GetAll(ctx, h.db, &currentUser, &query)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(firmwares)
+}
+
+// GetById return the firmware with a signed download url
+func (h *FirmwareHandler) GetById(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	firmware, err := h.getOwnFirmware(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	firmware.Url = h.repository.SignUrl(c.BaseURL(), firmware.IdFirmware)
+	return c.Status(fiber.StatusOK).JSON(firmware)
+}
+
+// Delete remove the firmware and its binary, the node targeting it are left without target
+func (h *FirmwareHandler) Delete(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	firmware, err := h.getOwnFirmware(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = h.repository.Delete(ctx, tx, firmware)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success delete firmware")
+}
+
+// Download send the binary of a signed url, it has no other authentication so a bootloader can
+// fetch it
+func (h *FirmwareHandler) Download(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.CheckSignature(id, c.Query("expires"), c.Query("signature"))
+	if err != nil {
+		return err
+	}
+
+	firmware, err := h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	body, err := h.repository.Open(ctx, firmware)
+	if err != nil {
+		return err
+	}
+
+	c.Set(fiber.HeaderContentType, fiber.MIMEOctetStream)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="firmware-%d-%s.bin"`, firmware.IdHardware, firmware.Version))
+	c.Set("X-Firmware-Sha256", firmware.Sha256)
+	// The stream close the body once it is sent
+	return c.Status(fiber.StatusOK).SendStream(body, int(firmware.Size))
+}
+
+// GetNode return the version the node reported and its target firmware
+func (h *FirmwareHandler) GetNode(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	node, err := getOwnNode(ctx, c, h.validator, h.db, h.nodeRepository, "You can’t see another user’s node")
+	if err != nil {
+		return err
+	}
+
+	status, err := h.repository.GetNode(ctx, h.db, node.IdNode)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(status)
+}
+
+// Assign set the target of the node to the firmware of the version, uploaded by the node owner for
+// the hardware of the node. The device get it on its next check
+func (h *FirmwareHandler) Assign(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := &entities.NodeFirmwareAssign{}
+	err = h.validator.ParseBody(c, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	node, err := getOwnNode(ctx, c, h.validator, h.db, h.nodeRepository, "You can’t edit another user’s node")
+	if err != nil {
+		return err
+	}
+
+	firmware, err := h.repository.GetByVersion(ctx, h.db, node.IdUser, node.IdHardware, bodyPayload.Version)
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.Assign(ctx, h.db, node.IdNode, &firmware.IdFirmware)
+	if err != nil {
+		return err
+	}
+
+	status, err := h.repository.GetNode(ctx, h.db, node.IdNode)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(status)
+}
+
+// Unassign clear the target of the node, the device isn't told to update anymore
+func (h *FirmwareHandler) Unassign(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	node, err := getOwnNode(ctx, c, h.validator, h.db, h.nodeRepository, "You can’t edit another user’s node")
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.Assign(ctx, h.db, node.IdNode, nil)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success clear node firmware target")
+}
+
+// Check is called by the device with the version it run, the version is saved and the answer tell
+// it to update when the target of the node is another version. The API key of the node or the token
+// of its owner is accepted
+func (h *FirmwareHandler) Check(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := &entities.FirmwareCheck{}
+	err = h.validator.ParseBody(c, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	node, err := h.nodeRepository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+	if node.IdUser != currentUser.IdUser {
+		return fiber.NewError(403, "You can't check the firmware of another user's node")
+	}
+	keyNode := h.validator.GetApiKeyNode(c)
+	if keyNode != 0 && keyNode != node.IdNode {
+		return fiber.NewError(403, "The API key can only check the firmware of its node")
+	}
+
+	err = h.repository.Report(ctx, h.db, node.IdNode, bodyPayload.Version)
+	if err != nil {
+		return err
+	}
+
+	status, err := h.repository.GetNode(ctx, h.db, node.IdNode)
+	if err != nil {
+		return err
+	}
+	if !status.Pending {
+		return c.Status(fiber.StatusOK).JSON(entities.FirmwareCheckResult{Update: false})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(entities.FirmwareCheckResult{
+		Update:  true,
+		Version: status.Target.Version,
+		Url:     h.repository.SignUrl(c.BaseURL(), status.Target.IdFirmware),
+		Size:    status.Target.Size,
+		Sha256:  status.Target.Sha256,
+	})
+}
@@ -7,26 +7,31 @@ import (
 
 	"github.com/dafaath/iot-server/internal/dependencies"
 	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
 	"github.com/dafaath/iot-server/internal/repositories"
 	"github.com/gofiber/fiber/v2"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type HardwareHandler struct {
-	db               *pgxpool.Pool
-	repository       *repositories.HardwareRepository
-	validator        *dependencies.Validator
-	nodeRepository   *repositories.NodeRepository
-	sensorRepository *repositories.SensorRepository
+	db                *pgxpool.Pool
+	repository        *repositories.HardwareRepository
+	validator         *dependencies.Validator
+	nodeRepository    *repositories.NodeRepository
+	sensorRepository  *repositories.SensorRepository
+	historyRepository *repositories.HistoryRepository
+	decoderRepository *repositories.DecoderRepository
 }
 
-func NewHardwareHandler(db *pgxpool.Pool, hardwareRepository *repositories.HardwareRepository, nodeRepository *repositories.NodeRepository, sensorRepository *repositories.SensorRepository, validator *dependencies.Validator) (HardwareHandler, error) {
+func NewHardwareHandler(db *pgxpool.Pool, hardwareRepository *repositories.HardwareRepository, nodeRepository *repositories.NodeRepository, sensorRepository *repositories.SensorRepository, historyRepository *repositories.HistoryRepository, decoderRepository *repositories.DecoderRepository, validator *dependencies.Validator) (HardwareHandler, error) {
 	return HardwareHandler{
-		db:               db,
-		validator:        validator,
-		repository:       hardwareRepository,
-		nodeRepository:   nodeRepository,
-		sensorRepository: sensorRepository,
+		db:                db,
+		validator:         validator,
+		repository:        hardwareRepository,
+		nodeRepository:    nodeRepository,
+		sensorRepository:  sensorRepository,
+		historyRepository: historyRepository,
+		decoderRepository: decoderRepository,
 	}, nil
 }
 
@@ -45,7 +50,28 @@ func (h *HardwareHandler) Create(c *fiber.Ctx) (err error) {
 		return err
 	}
 
-	_, err = h.repository.Create(ctx, h.db, bodyPayload)
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	hardware, err := h.repository.Create(ctx, tx, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	err = h.historyRepository.Record(ctx, tx, entities.EntityHardware, hardware.IdHardware, entities.RevisionCreate, currentUser.IdUser, nil, hardware)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
 	if err != nil {
 		return err
 	}
@@ -55,13 +81,18 @@ func (h *HardwareHandler) Create(c *fiber.Ctx) (err error) {
 
 func (h *HardwareHandler) GetAll(c *fiber.Ctx) (err error) {
 	ctx := context.Background()
+	query := entities.HardwareQuery{}
+	err = h.validator.ParseQuery(c, &query)
+	if err != nil {
+		return err
+	}
 
-	nodes, err := h.repository.GetAllNode(ctx, h.db)
+	nodes, err := h.repository.GetAllNode(ctx, h.db, &query)
 	if err != nil {
 		return err
 	}
 
-	sensors, err := h.repository.GetAllSensor(ctx, h.db)
+	sensors, err := h.repository.GetAllSensor(ctx, h.db, &query)
 	if err != nil {
 		return err
 	}
@@ -81,11 +112,12 @@ func (h *HardwareHandler) GetAll(c *fiber.Ctx) (err error) {
 			return sensors[i].Name < sensors[j].Name
 		})
 
-		return c.Render("hardware", fiber.Map{
+		return helper.RenderPage(c, "hardware", "partials/hardware_list", "hardware-list", fiber.Map{
 			"title":  "Hardware",
 			"node":   nodes,
 			"sensor": sensors,
-		}, "layouts/main")
+			"query":  query,
+		})
 	default:
 		return c.Status(fiber.StatusOK).JSON(fiber.Map{
 			"node":   nodes,
@@ -193,7 +225,32 @@ func (h *HardwareHandler) Update(c *fiber.Ctx) (err error) {
 		return err
 	}
 
-	err = h.repository.Update(ctx, h.db, &hardware, bodyPayload)
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	before := hardware
+	err = h.repository.Update(ctx, tx, &hardware, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	hardware.Name = bodyPayload.Name
+	hardware.Type = bodyPayload.Type
+	hardware.Description = bodyPayload.Description
+	err = h.historyRepository.Record(ctx, tx, entities.EntityHardware, hardware.IdHardware, entities.RevisionUpdate, currentUser.IdUser, before, hardware)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
 	if err != nil {
 		return err
 	}
@@ -208,15 +265,124 @@ func (h *HardwareHandler) Delete(c *fiber.Ctx) (err error) {
 		return err
 	}
 
-	_, err = h.repository.GetById(ctx, h.db, id)
+	hardware, err := h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	// The node and sensor built on the hardware are deleted with it, of every user
+	dryRun, err := h.validator.ParseDryRun(c)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		impact, err := h.repository.GetDeleteImpact(ctx, h.db, id)
+		if err != nil {
+			return err
+		}
+		return c.Status(fiber.StatusOK).JSON(impact)
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = h.repository.Delete(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	err = h.historyRepository.Record(ctx, tx, entities.EntityHardware, id, entities.RevisionDelete, currentUser.IdUser, hardware, nil)
 	if err != nil {
 		return err
 	}
 
-	err = h.repository.Delete(ctx, h.db, id)
+	err = tx.Commit(ctx)
 	if err != nil {
 		return err
 	}
 
 	return c.Status(fiber.StatusOK).SendString(fmt.Sprintf("Success delete hardware, id: %d", id))
 }
+
+// GetHistory return the configuration revision of the hardware with who changed what, newest first
+func (h *HardwareHandler) GetHistory(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	query := entities.RevisionQuery{}
+	err = h.validator.ParseQuery(c, &query)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	revisions, err := h.historyRepository.GetHistory(ctx, h.db, entities.EntityHardware, id, &query)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(revisions)
+}
+
+// GetDecoder return the decoder of the binary payload sent by the node of the hardware
+func (h *HardwareHandler) GetDecoder(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	decoder, err := h.decoderRepository.GetByHardware(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(decoder)
+}
+
+// UpdateDecoder replace the decoder of the hardware, an empty field list without plugin remove it
+func (h *HardwareHandler) UpdateDecoder(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	bodyPayload := &entities.HardwareDecoderUpdate{}
+	err = h.validator.ParseBody(c, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	err = h.decoderRepository.Update(ctx, h.db, id, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success edit hardware decoder")
+}
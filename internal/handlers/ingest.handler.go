@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/metering"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type IngestHandler struct {
+	db               *pgxpool.Pool
+	repository       *repositories.IngestRepository
+	nodeRepository   *repositories.NodeRepository
+	sensorRepository *repositories.SensorRepository
+	ingestMeter      *metering.IngestMeter
+	validator        *dependencies.Validator
+}
+
+func NewIngestHandler(db *pgxpool.Pool, ingestRepository *repositories.IngestRepository, nodeRepository *repositories.NodeRepository, sensorRepository *repositories.SensorRepository, ingestMeter *metering.IngestMeter, validator *dependencies.Validator) (IngestHandler, error) {
+	return IngestHandler{
+		db:               db,
+		repository:       ingestRepository,
+		nodeRepository:   nodeRepository,
+		sensorRepository: sensorRepository,
+		ingestMeter:      ingestMeter,
+		validator:        validator,
+	}, nil
+}
+
+// getStats return the ingest of the sensors in the ?window of the query, by default 24h. The window
+// start at the hour, so it cover up to one hour more
+func (h *IngestHandler) getStats(ctx context.Context, c *fiber.Ctx, sensors []entities.Sensor) (stats []entities.IngestStat, window string, err error) {
+	query := entities.IngestQuery{}
+	err = h.validator.ParseQuery(c, &query)
+	if err != nil {
+		return stats, window, err
+	}
+	window = query.Window
+	if window == "" {
+		window = "24h"
+	}
+
+	// Include the ingest counted by this instance that is not flushed yet
+	err = h.ingestMeter.Flush(ctx)
+	if err != nil {
+		return stats, window, err
+	}
+
+	now := time.Now()
+	since := repositories.IngestBucket(now.Add(-entities.IngestWindows[window]))
+	sensorIds := make([]int, len(sensors))
+	for i, sensor := range sensors {
+		sensorIds[i] = sensor.IdSensor
+	}
+	statBySensor, err := h.repository.GetBySensors(ctx, h.db, sensorIds, since)
+	if err != nil {
+		return stats, window, err
+	}
+
+	minutes := now.Sub(since).Minutes()
+	stats = make([]entities.IngestStat, len(sensors))
+	for i, sensor := range sensors {
+		stat := statBySensor[sensor.IdSensor]
+		stat.Name = sensor.Name
+		stat.Window = window
+		stat.RatePerMinute = float64(stat.Accepted) / minutes
+		stats[i] = stat
+	}
+	return stats, window, nil
+}
+
+// GetBySensor return the ingest rate, byte count and last error of the sensor
+func (h *IngestHandler) GetBySensor(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	err = validateSensorAccess(ctx, c, h.validator, h.db, h.nodeRepository, id, entities.ShareRead, "You can’t see another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	sensor, err := h.sensorRepository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	stats, _, err := h.getStats(ctx, c, []entities.Sensor{sensor})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(stats[0])
+}
+
+// GetByNode return the ingest of every sensor of the node and their sum
+func (h *IngestHandler) GetByNode(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	node, err := h.nodeRepository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+	if node.IdUser != currentUser.IdUser && !currentUser.IsAdmin {
+		return fiber.NewError(403, "You can’t see another user’s node")
+	}
+
+	sensors, err := h.sensorRepository.GetNodeSensor(ctx, h.db, node.IdNode)
+	if err != nil {
+		return err
+	}
+
+	stats, window, err := h.getStats(ctx, c, sensors)
+	if err != nil {
+		return err
+	}
+
+	nodeStat := entities.NodeIngestStat{IdNode: node.IdNode, Name: node.Name, Window: window, Sensors: stats}
+	for _, stat := range stats {
+		nodeStat.Accepted += stat.Accepted
+		nodeStat.Rejected += stat.Rejected
+		nodeStat.Bytes += stat.Bytes
+		nodeStat.RatePerMinute += stat.RatePerMinute
+		if stat.LastReceived != nil && (nodeStat.LastReceived == nil || stat.LastReceived.After(*nodeStat.LastReceived)) {
+			nodeStat.LastReceived = stat.LastReceived
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(nodeStat)
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// IntegrityHandler show the checksum verification of the channel to the admin and verify a sensor
+// on demand
+type IntegrityHandler struct {
+	db               *pgxpool.Pool
+	repository       *repositories.IntegrityRepository
+	sensorRepository *repositories.SensorRepository
+	validator        *dependencies.Validator
+}
+
+func NewIntegrityHandler(db *pgxpool.Pool, integrityRepository *repositories.IntegrityRepository, sensorRepository *repositories.SensorRepository, validator *dependencies.Validator) (IntegrityHandler, error) {
+	return IntegrityHandler{
+		db:               db,
+		repository:       integrityRepository,
+		sensorRepository: sensorRepository,
+		validator:        validator,
+	}, nil
+}
+
+func (h *IntegrityHandler) GetStatus(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	status, err := h.repository.GetStatus(ctx, h.db)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(status)
+}
+
+// Verify check the day of the sensor now instead of waiting for the job
+func (h *IntegrityHandler) Verify(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := entities.IntegrityVerify{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.sensorRepository.GetById(ctx, h.db, bodyPayload.IdSensor)
+	if err != nil {
+		return err
+	}
+
+	result, err := h.repository.Verify(ctx, h.db, &bodyPayload, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(result)
+}
+
+func (h *IntegrityHandler) Accept(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := entities.IntegrityAccept{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	checksum, err := h.repository.Accept(ctx, h.db, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(checksum)
+}
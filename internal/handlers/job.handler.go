@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/dafaath/iot-server/internal/scheduler"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type JobHandler struct {
+	db         *pgxpool.Pool
+	repository *repositories.JobRepository
+	scheduler  *scheduler.Scheduler
+}
+
+func NewJobHandler(db *pgxpool.Pool, jobRepository *repositories.JobRepository, jobScheduler *scheduler.Scheduler) (JobHandler, error) {
+	return JobHandler{
+		db:         db,
+		repository: jobRepository,
+		scheduler:  jobScheduler,
+	}, nil
+}
+
+func (h *JobHandler) GetAll(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+
+	jobs, err := h.repository.GetAll(ctx, h.db)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(jobs)
+}
+
+func (h *JobHandler) GetByName(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+
+	job, err := h.repository.GetByName(ctx, h.db, c.Params("name"))
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(job)
+}
+
+func (h *JobHandler) Run(c *fiber.Ctx) (err error) {
+	name := c.Params("name")
+
+	err = h.scheduler.RunNow(name)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusAccepted).SendString(fmt.Sprintf("Job %s triggered", name))
+}
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type KpiHandler struct {
+	db               *pgxpool.Pool
+	nodeRepository   *repositories.NodeRepository
+	repository       *repositories.KpiRepository
+	sensorRepository *repositories.SensorRepository
+	validator        *dependencies.Validator
+}
+
+func NewKpiHandler(db *pgxpool.Pool, nodeRepository *repositories.NodeRepository, kpiRepository *repositories.KpiRepository, sensorRepository *repositories.SensorRepository, validator *dependencies.Validator) (KpiHandler, error) {
+	return KpiHandler{
+		db:               db,
+		nodeRepository:   nodeRepository,
+		repository:       kpiRepository,
+		sensorRepository: sensorRepository,
+		validator:        validator,
+	}, nil
+}
+
+// validatePayload check what the validator tag can't, the window and the sensor access
+func (h *KpiHandler) validatePayload(ctx context.Context, c *fiber.Ctx, payload *entities.KpiCreate) error {
+	if payload.WindowSeconds > 0 && 86400%payload.WindowSeconds != 0 {
+		return fiber.NewError(400, "window_seconds must divide a day, like 3600 for hourly")
+	}
+	return validateSensorAccess(ctx, c, h.validator, h.db, h.nodeRepository, payload.IdSensor, entities.ShareWrite, "You can’t add a KPI to another user’s sensor")
+}
+
+// getOwnKpi return the KPI when the current user has the permission on its sensor
+func (h *KpiHandler) getOwnKpi(ctx context.Context, c *fiber.Ctx, permission string) (kpi entities.Kpi, err error) {
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return kpi, err
+	}
+
+	kpi, err = h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return kpi, err
+	}
+
+	err = validateSensorAccess(ctx, c, h.validator, h.db, h.nodeRepository, kpi.IdSensor, permission, "You can’t access another user’s KPI")
+	return kpi, err
+}
+
+func (h *KpiHandler) GetAll(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	kpis, err := h.repository.GetAll(ctx, h.db, currentUser.IdUser)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(kpis)
+}
+
+func (h *KpiHandler) GetById(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	kpi, err := h.getOwnKpi(ctx, c, entities.ShareRead)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(kpi)
+}
+
+// Create add the KPI, its value is computed by the next kpi job run
+func (h *KpiHandler) Create(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := entities.KpiCreate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	err = h.validatePayload(ctx, c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	kpi, err := h.repository.Create(ctx, h.db, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(kpi)
+}
+
+// Update change the KPI, its value is computed again from the first channel
+func (h *KpiHandler) Update(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := entities.KpiCreate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	kpi, err := h.getOwnKpi(ctx, c, entities.ShareWrite)
+	if err != nil {
+		return err
+	}
+
+	err = h.validatePayload(ctx, c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = h.repository.Update(ctx, tx, kpi.IdKpi, &bodyPayload)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success edit KPI")
+}
+
+func (h *KpiHandler) Delete(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	kpi, err := h.getOwnKpi(ctx, c, entities.ShareWrite)
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.Delete(ctx, h.db, kpi.IdKpi)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success delete KPI")
+}
+
+// GetSeries return the materialized KPI as [epoch milliseconds, value] pairs, like the sensor series
+func (h *KpiHandler) GetSeries(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	kpi, err := h.getOwnKpi(ctx, c, entities.ShareRead)
+	if err != nil {
+		return err
+	}
+
+	from, err := h.validator.ParseTimeQuery(c, "from")
+	if err != nil {
+		return err
+	}
+	to, err := h.validator.ParseTimeQuery(c, "to")
+	if err != nil {
+		return err
+	}
+
+	values, err := h.repository.GetValues(ctx, h.db, kpi.IdKpi, from, to)
+	if err != nil {
+		return err
+	}
+	series := make([][2]interface{}, len(values))
+	for i, value := range values {
+		series[i] = [2]interface{}{value.Bucket.UnixMilli(), value.Value}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"interval":       kpi.WindowSeconds,
+		"agg":            kpi.Function,
+		"display":        entities.ChannelDisplay{Label: kpi.Name, Unit: kpi.Unit},
+		"computed_until": kpi.ComputedUntil,
+		"series":         series,
+	})
+}
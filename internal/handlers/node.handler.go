@@ -1,40 +1,60 @@
 package handlers
 
 import (
+	"bufio"
 	"context"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
+	"log"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/dafaath/iot-server/internal/dependencies"
 	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/dafaath/iot-server/internal/metering"
 	"github.com/dafaath/iot-server/internal/repositories"
 	"github.com/gofiber/fiber/v2"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type NodeHandler struct {
-	db                 *pgxpool.Pool
-	repository         *repositories.NodeRepository
-	hardwareRepository *repositories.HardwareRepository
-	sensorRepository   *repositories.SensorRepository
-	validator          *dependencies.Validator
+	db                     *pgxpool.Pool
+	repository             *repositories.NodeRepository
+	hardwareRepository     *repositories.HardwareRepository
+	sensorRepository       *repositories.SensorRepository
+	channelRepository      *repositories.ChannelRepository
+	dashboardRepository    *repositories.DashboardRepository
+	notificationRepository *repositories.NotificationRepository
+	webhookRepository      *repositories.WebhookRepository
+	historyRepository      *repositories.HistoryRepository
+	meter                  *metering.Meter
+	validator              *dependencies.Validator
 }
 
-func NewNodeHandler(db *pgxpool.Pool, nodeRepository *repositories.NodeRepository, hardwareRepository *repositories.HardwareRepository, sensorRepository *repositories.SensorRepository, validator *dependencies.Validator) (NodeHandler, error) {
+func NewNodeHandler(db *pgxpool.Pool, nodeRepository *repositories.NodeRepository, hardwareRepository *repositories.HardwareRepository, sensorRepository *repositories.SensorRepository, channelRepository *repositories.ChannelRepository, dashboardRepository *repositories.DashboardRepository, notificationRepository *repositories.NotificationRepository, webhookRepository *repositories.WebhookRepository, historyRepository *repositories.HistoryRepository, meter *metering.Meter, validator *dependencies.Validator) (NodeHandler, error) {
 	return NodeHandler{
-		db:                 db,
-		repository:         nodeRepository,
-		hardwareRepository: hardwareRepository,
-		sensorRepository:   sensorRepository,
-		validator:          validator,
+		db:                     db,
+		repository:             nodeRepository,
+		hardwareRepository:     hardwareRepository,
+		sensorRepository:       sensorRepository,
+		channelRepository:      channelRepository,
+		dashboardRepository:    dashboardRepository,
+		notificationRepository: notificationRepository,
+		webhookRepository:      webhookRepository,
+		historyRepository:      historyRepository,
+		meter:                  meter,
+		validator:              validator,
 	}, nil
 }
 
 func (h *NodeHandler) CreateForm(c *fiber.Ctx) (err error) {
 	ctx := context.Background()
 
-	nodeHardware, err := h.hardwareRepository.GetAllNode(ctx, h.db)
+	nodeHardware, err := h.hardwareRepository.GetAllNode(ctx, h.db, &entities.HardwareQuery{})
 	if err != nil {
 		return err
 	}
@@ -89,43 +109,287 @@ func (h *NodeHandler) Create(c *fiber.Ctx) (err error) {
 		return err
 	}
 
-	_, err = h.repository.Create(ctx, h.db, &bodyPayload, &currentUser)
+	tx, err := h.db.Begin(ctx)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback(ctx)
+
+	node, err := h.repository.Create(ctx, tx, &bodyPayload, &currentUser)
+	if err != nil {
+		return err
+	}
+
+	err = h.historyRepository.Record(ctx, tx, entities.EntityNode, node.IdNode, entities.RevisionCreate, currentUser.IdUser, nil, node)
+	if err != nil {
+		return err
+	}
+
+	err = h.webhookRepository.Enqueue(ctx, tx, node.IdUser, entities.EventNodeCreated, node)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The node is already saved, a broken template shouldn't fail the request
+	dashboard, err := h.dashboardRepository.InstantiateTemplateForNode(ctx, h.db, &node)
+	if err != nil {
+		log.Printf("[DASHBOARD] Error instantiating template for node %d: %v", node.IdNode, err)
+	} else if dashboard != nil {
+		link := fmt.Sprintf("/dashboard/%d", dashboard.IdDashboard)
+		_, err = h.notificationRepository.Create(ctx, h.db, currentUser.IdUser, &entities.NotificationCreate{
+			Type:    "system",
+			Title:   fmt.Sprintf("Dashboard created for node %s", node.Name),
+			Message: fmt.Sprintf("Dashboard %s is created from template, widgets are added as the node sensors are created", dashboard.Name),
+			Link:    &link,
+		})
+		if err != nil {
+			log.Printf("[NOTIFICATION] Error notifying dashboard of node %d: %v", node.IdNode, err)
+		} else {
+			h.meter.Add(currentUser.IdUser, entities.UsageNotificationsSent, 1)
+		}
+	}
 
 	return c.Status(fiber.StatusCreated).SendString("Success add new node")
 }
 
 func (h *NodeHandler) GetAll(c *fiber.Ctx) (err error) {
 	ctx := context.Background()
+	query := entities.NodeQuery{}
+	err = h.validator.ParseQuery(c, &query)
+	if err != nil {
+		return err
+	}
 
 	currentUser, err := h.validator.GetAuthentication(c)
 	if err != nil {
 		return err
 	}
 
-	nodes, err := h.repository.GetAll(ctx, h.db, &currentUser)
+	accept := c.Accepts("application/json", "text/html")
+	pageQuery := entities.PageQuery{}
+	if accept == "text/html" {
+		err = h.validator.ParseQuery(c, &pageQuery)
+		if err != nil {
+			return err
+		}
+
+		// The status is known after the query, so the page is cut after filtering it
+		if query.Status == "" {
+			query.Limit = pageQuery.Limit()
+			query.Offset = pageQuery.Offset()
+		}
+	}
+
+	nodes, err := h.repository.GetAll(ctx, h.db, &currentUser, &query)
 	if err != nil {
 		return err
 	}
 
-	accept := c.Accepts("application/json", "text/html")
+	total := len(nodes)
+	if query.Status != "" {
+		activities, err := h.repository.GetAllActivity(ctx, h.db, &currentUser)
+		if err != nil {
+			return err
+		}
+
+		filtered := []entities.Node{}
+		for _, node := range nodes {
+			if nodeStatus(activities[node.IdNode]) == query.Status {
+				filtered = append(filtered, node)
+			}
+		}
+		nodes = filtered
+		total = len(nodes)
+	}
+
 	switch accept {
 	case "text/html":
-		sort.Slice(nodes, func(i, j int) bool {
-			return nodes[i].Name < nodes[j].Name
-		})
+		if query.Limit > 0 {
+			total, err = h.repository.CountAll(ctx, h.db, &currentUser, &query)
+			if err != nil {
+				return err
+			}
+		} else {
+			from := pageQuery.Offset()
+			if from > len(nodes) {
+				from = len(nodes)
+			}
+			to := from + pageQuery.Limit()
+			if to > len(nodes) {
+				to = len(nodes)
+			}
+			nodes = nodes[from:to]
+		}
 
-		return c.Render("node", fiber.Map{
-			"title": "Node",
-			"nodes": nodes,
-		}, "layouts/main")
+		// Option of the hardware filter
+		nodeHardware, err := h.hardwareRepository.GetAllNode(ctx, h.db, &entities.HardwareQuery{})
+		if err != nil {
+			return err
+		}
+
+		return helper.RenderPage(c, "node", "partials/node_list", "node-list", fiber.Map{
+			"title":        "Node",
+			"nodes":        nodes,
+			"query":        query,
+			"page":         entities.NewPage(pageQuery, total, helper.PageFilter(c)),
+			"nodeHardware": nodeHardware,
+		})
 	default:
 		return c.Status(fiber.StatusOK).JSON(nodes)
 	}
 }
 
+// A node is online when any of its sensor sent a channel within nodeOnlineWindow,
+// stale within nodeStaleWindow and offline after that
+const (
+	nodeOnlineWindow = 15 * time.Minute
+	nodeStaleWindow  = 24 * time.Hour
+)
+
+func nodeStatus(activity entities.NodeActivity) string {
+	if activity.LastSeen == nil {
+		return "no_data"
+	}
+	since := time.Since(*activity.LastSeen)
+	if since <= nodeOnlineWindow {
+		return "online"
+	}
+	if since <= nodeStaleWindow {
+		return "stale"
+	}
+	return "offline"
+}
+
+// GetGeoJSON return the user's nodes as GeoJSON feature collection, node without
+// "latitude,longitude" location is left out
+func (h *NodeHandler) GetGeoJSON(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	nodes, err := h.repository.GetAll(ctx, h.db, &currentUser, &entities.NodeQuery{})
+	if err != nil {
+		return err
+	}
+
+	activities, err := h.repository.GetAllActivity(ctx, h.db, &currentUser)
+	if err != nil {
+		return err
+	}
+
+	collection := entities.NodeFeatureCollection{
+		Type:     "FeatureCollection",
+		Features: []entities.NodeFeature{},
+	}
+	for _, node := range nodes {
+		latitude, longitude, ok := entities.ParseNodeLocation(node.Location)
+		if !ok {
+			continue
+		}
+
+		activity := activities[node.IdNode]
+		collection.Features = append(collection.Features, entities.NodeFeature{
+			Type: "Feature",
+			Geometry: entities.NodeFeatureGeometry{
+				Type:        "Point",
+				Coordinates: [2]float64{longitude, latitude},
+			},
+			Properties: entities.NodeFeatureProperties{
+				IdNode:      node.IdNode,
+				Name:        node.Name,
+				Location:    node.Location,
+				IdHardware:  node.IdHardware,
+				SensorCount: activity.SensorCount,
+				LastSeen:    activity.LastSeen,
+				Status:      nodeStatus(activity),
+			},
+		})
+	}
+
+	err = c.Status(fiber.StatusOK).JSON(collection)
+	if err != nil {
+		return err
+	}
+	// JSON() set application/json, override it after
+	c.Set(fiber.HeaderContentType, "application/geo+json")
+	return nil
+}
+
+// Map render the fleet map, the markers are loaded from GetGeoJSON
+func (h *NodeHandler) Map(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	nodes, err := h.repository.GetAll(ctx, h.db, &currentUser, &entities.NodeQuery{})
+	if err != nil {
+		return err
+	}
+
+	unlocated := []entities.Node{}
+	for _, node := range nodes {
+		_, _, ok := entities.ParseNodeLocation(node.Location)
+		if !ok {
+			unlocated = append(unlocated, node)
+		}
+	}
+
+	return c.Render("node_map", fiber.Map{
+		"title":     "Node Map",
+		"unlocated": unlocated,
+	}, "layouts/main")
+}
+
+// Heartbeat mark the node online without a channel, for a device with nothing to report. The API key
+// of the node or the token of its owner is accepted
+func (h *NodeHandler) Heartbeat(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	node, err := h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+	if node.IdUser != currentUser.IdUser {
+		return fiber.NewError(403, "You can't send the heartbeat of another user's node")
+	}
+	keyNode := h.validator.GetApiKeyNode(c)
+	if keyNode != 0 && keyNode != node.IdNode {
+		return fiber.NewError(403, "The API key can only send the heartbeat of its node")
+	}
+
+	now := time.Now().UTC()
+	err = h.repository.Seen(ctx, h.db, node.IdNode, now)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(entities.NodeHeartbeat{
+		IdNode:   node.IdNode,
+		LastSeen: now,
+		Online:   true,
+	})
+}
+
 func (h *NodeHandler) GetById(c *fiber.Ctx) (err error) {
 	ctx := context.Background()
 	id, err := h.validator.ParseIdFromUrlParameter(c)
@@ -155,7 +419,11 @@ func (h *NodeHandler) GetById(c *fiber.Ctx) (err error) {
 		return err
 	}
 
-	if node.IdUser != currentUser.IdUser && !currentUser.IsAdmin {
+	allowed, err := h.repository.HasAccess(ctx, h.db, node, &currentUser, entities.ShareRead)
+	if err != nil {
+		return err
+	}
+	if !allowed {
 		return fiber.NewError(403, "You can’t see another user’s node")
 	}
 
@@ -187,6 +455,84 @@ func (h *NodeHandler) GetById(c *fiber.Ctx) (err error) {
 	}
 }
 
+// Export download the channel of every sensor in the node as one CSV, ordered by sensor then time.
+// It accept the same from, to, interval and agg query as the sensor export
+func (h *NodeHandler) Export(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	query, err := h.validator.ParseChannelQuery(c)
+	if err != nil {
+		return err
+	}
+
+	node, err := h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	allowed, err := h.repository.HasAccess(ctx, h.db, node, &currentUser, entities.ShareRead)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return fiber.NewError(403, "You can’t see another user’s node")
+	}
+
+	sensors, err := h.sensorRepository.GetNodeSensor(ctx, h.db, node.IdNode)
+	if err != nil {
+		return err
+	}
+	sort.Slice(sensors, func(i, j int) bool {
+		return sensors[i].IdSensor < sensors[j].IdSensor
+	})
+
+	c.Attachment(fmt.Sprintf("node-%d.csv", node.IdNode))
+	c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	c.Status(fiber.StatusOK).Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"time", "id_sensor", "sensor", "unit", "value"})
+
+		count := 0
+		for _, sensor := range sensors {
+			err := h.channelRepository.ForEachBySensor(context.Background(), h.db, sensor.IdSensor, query, func(channel entities.Channel) error {
+				count++
+				err := writer.Write([]string{
+					channel.Time.Format(time.RFC3339),
+					strconv.Itoa(sensor.IdSensor),
+					sensor.Name,
+					sensor.Unit,
+					strconv.FormatFloat(channel.Value, 'f', -1, 64),
+				})
+				if err != nil {
+					return err
+				}
+
+				if count%1000 == 0 {
+					writer.Flush()
+					return writer.Error()
+				}
+				return nil
+			})
+			if err != nil {
+				// Status code is already sent, the client will get a truncated file
+				log.Printf("[EXPORT ERROR] node %d sensor %d channel: %v", node.IdNode, sensor.IdSensor, err)
+				break
+			}
+		}
+		writer.Flush()
+	})
+	return nil
+}
+
 func (h *NodeHandler) UpdateForm(c *fiber.Ctx) (err error) {
 	id, err := h.validator.ParseIdFromUrlParameter(c)
 	if err != nil {
@@ -199,7 +545,7 @@ func (h *NodeHandler) UpdateForm(c *fiber.Ctx) (err error) {
 		return err
 	}
 
-	nodeHardware, err := h.hardwareRepository.GetAllNode(ctx, h.db)
+	nodeHardware, err := h.hardwareRepository.GetAllNode(ctx, h.db, &entities.HardwareQuery{})
 	if err != nil {
 		return err
 	}
@@ -239,7 +585,31 @@ func (h *NodeHandler) Update(c *fiber.Ctx) (err error) {
 		return fiber.NewError(403, "Can’t edit another user’s data")
 	}
 
-	err = h.repository.Update(ctx, h.db, &node, bodyPayload)
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	before := node
+	err = h.repository.Update(ctx, tx, &node, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	node.Name = bodyPayload.Name
+	node.Location = bodyPayload.Location
+	err = h.historyRepository.Record(ctx, tx, entities.EntityNode, node.IdNode, entities.RevisionUpdate, currentUser.IdUser, before, node)
+	if err != nil {
+		return err
+	}
+
+	err = h.webhookRepository.Enqueue(ctx, tx, node.IdUser, entities.EventNodeUpdated, node)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
 	if err != nil {
 		return err
 	}
@@ -268,10 +638,329 @@ func (h *NodeHandler) Delete(c *fiber.Ctx) (err error) {
 		return fiber.NewError(403, "You can’t delete another user’s node")
 	}
 
-	err = h.repository.Delete(ctx, h.db, id)
+	dryRun, err := h.validator.ParseDryRun(c)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		impact, err := h.repository.GetDeleteImpact(ctx, h.db, id)
+		if err != nil {
+			return err
+		}
+		return c.Status(fiber.StatusOK).JSON(impact)
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// The sensor is deleted with its node, each get its own event
+	sensors, err := h.sensorRepository.GetNodeSensor(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	for _, sensor := range sensors {
+		err = h.webhookRepository.Enqueue(ctx, tx, node.IdUser, entities.EventSensorDeleted, sensor)
+		if err != nil {
+			return err
+		}
+	}
+	err = h.webhookRepository.Enqueue(ctx, tx, node.IdUser, entities.EventNodeDeleted, node)
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.Delete(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	for _, sensor := range sensors {
+		err = h.historyRepository.Record(ctx, tx, entities.EntitySensor, sensor.IdSensor, entities.RevisionDelete, currentUser.IdUser, sensor, nil)
+		if err != nil {
+			return err
+		}
+	}
+	err = h.historyRepository.Record(ctx, tx, entities.EntityNode, id, entities.RevisionDelete, currentUser.IdUser, node, nil)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
 	if err != nil {
 		return err
 	}
 
 	return c.Status(fiber.StatusOK).SendString(fmt.Sprintf("Success delete node, id: %d", id))
 }
+
+// GetHistory return the configuration revision of the node with who changed what, newest first
+func (h *NodeHandler) GetHistory(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	query := entities.RevisionQuery{}
+	err = h.validator.ParseQuery(c, &query)
+	if err != nil {
+		return err
+	}
+
+	node, err := h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	allowed, err := h.repository.HasAccess(ctx, h.db, node, &currentUser, entities.ShareRead)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return fiber.NewError(403, "You can’t see another user’s node")
+	}
+
+	revisions, err := h.historyRepository.GetHistory(ctx, h.db, entities.EntityNode, id, &query)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(revisions)
+}
+
+// GetHistoryDiff compare two version of the node
+func (h *NodeHandler) GetHistoryDiff(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	query := entities.RevisionDiffQuery{}
+	err = h.validator.ParseQuery(c, &query)
+	if err != nil {
+		return err
+	}
+
+	node, err := h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	allowed, err := h.repository.HasAccess(ctx, h.db, node, &currentUser, entities.ShareRead)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return fiber.NewError(403, "You can’t see another user’s node")
+	}
+
+	diff, err := h.historyRepository.Diff(ctx, h.db, entities.EntityNode, id, query.From, query.To)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(diff)
+}
+
+// Rollback set the name and location of the node back to an older version, it add a new version.
+// The hardware and owner of a node can't be changed so they are not rolled back
+func (h *NodeHandler) Rollback(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	version, err := h.validator.ParseIntFromUrlParameter(c, "version")
+	if err != nil {
+		return err
+	}
+
+	node, err := h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	if node.IdUser != currentUser.IdUser && !currentUser.IsAdmin {
+		return fiber.NewError(403, "Can’t edit another user’s data")
+	}
+
+	revision, err := h.historyRepository.GetRevision(ctx, h.db, entities.EntityNode, id, version)
+	if err != nil {
+		return err
+	}
+	target := entities.Node{}
+	err = json.Unmarshal(revision.Data, &target)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	before := node
+	err = h.repository.Update(ctx, tx, &node, &entities.NodeUpdate{Name: target.Name, Location: target.Location})
+	if err != nil {
+		return err
+	}
+
+	node.Name = target.Name
+	node.Location = target.Location
+	err = h.historyRepository.Record(ctx, tx, entities.EntityNode, id, entities.RevisionRollback, currentUser.IdUser, before, node)
+	if err != nil {
+		return err
+	}
+
+	err = h.webhookRepository.Enqueue(ctx, tx, node.IdUser, entities.EventNodeUpdated, node)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString(fmt.Sprintf("Success roll back node to version %d", version))
+}
+
+// GetDeleted return the deleted node of every user for the admin to restore
+func (h *NodeHandler) GetDeleted(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	nodes, err := h.repository.GetDeleted(ctx, h.db)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(nodes)
+}
+
+// Restore bring back a deleted node with the sensor deleted with it
+func (h *NodeHandler) Restore(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	node, err := h.repository.Restore(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	err = h.historyRepository.Record(ctx, tx, entities.EntityNode, id, entities.RevisionRestore, currentUser.IdUser, nil, node)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(node)
+}
+
+// GetShares return the user the node is shared with
+func (h *NodeHandler) GetShares(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	node, err := getOwnNode(ctx, c, h.validator, h.db, h.repository, "You can’t see another user’s node")
+	if err != nil {
+		return err
+	}
+
+	shares, err := h.repository.GetShares(ctx, h.db, node.IdNode)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(shares)
+}
+
+// Share give the user read or write access to the node, sharing again change the permission
+func (h *NodeHandler) Share(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := &entities.NodeShareCreate{}
+	err = h.validator.ParseBody(c, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	node, err := getOwnNode(ctx, c, h.validator, h.db, h.repository, "You can’t share another user’s node")
+	if err != nil {
+		return err
+	}
+
+	share, err := h.repository.Share(ctx, h.db, node, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	// The share is already saved, a failed notification is only logged
+	link := fmt.Sprintf("/node/%d", node.IdNode)
+	_, err = h.notificationRepository.Create(ctx, h.db, share.IdUser, &entities.NotificationCreate{
+		Type:    "share",
+		Title:   "Node shared with you",
+		Message: fmt.Sprintf("Node %s is shared with you with %s access", node.Name, share.Permission),
+		Link:    &link,
+	})
+	if err != nil {
+		log.Printf("[NOTIFICATION] Error notifying share of node %d to user %d: %v", node.IdNode, share.IdUser, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(share)
+}
+
+// Unshare remove the access of the user to the node
+func (h *NodeHandler) Unshare(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	node, err := getOwnNode(ctx, c, h.validator, h.db, h.repository, "You can’t share another user’s node")
+	if err != nil {
+		return err
+	}
+
+	idUser, err := h.validator.ParseIntFromUrlParameter(c, "user")
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.Unshare(ctx, h.db, node.IdNode, idUser)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString(fmt.Sprintf("Node %d is no longer shared with user %d", node.IdNode, idUser))
+}
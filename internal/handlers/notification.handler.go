@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/metering"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type NotificationHandler struct {
+	db              *pgxpool.Pool
+	repository      *repositories.NotificationRepository
+	userRepository  *repositories.UserRepository
+	usageRepository *repositories.UsageRepository
+	meter           *metering.Meter
+	validator       *dependencies.Validator
+}
+
+func NewNotificationHandler(db *pgxpool.Pool, notificationRepository *repositories.NotificationRepository, userRepository *repositories.UserRepository, usageRepository *repositories.UsageRepository, meter *metering.Meter, validator *dependencies.Validator) (NotificationHandler, error) {
+	return NotificationHandler{
+		db:              db,
+		repository:      notificationRepository,
+		userRepository:  userRepository,
+		usageRepository: usageRepository,
+		meter:           meter,
+		validator:       validator,
+	}, nil
+}
+
+func (h *NotificationHandler) GetAll(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	query := entities.NotificationQuery{}
+	err = h.validator.ParseQuery(c, &query)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	notifications, err := h.repository.GetAll(ctx, h.db, currentUser.IdUser, &query)
+	if err != nil {
+		return err
+	}
+
+	unread, err := h.repository.CountUnread(ctx, h.db, currentUser.IdUser)
+	if err != nil {
+		return err
+	}
+
+	accept := c.Accepts("application/json", "text/html")
+	switch accept {
+	case "text/html":
+		return c.Render("notification", fiber.Map{
+			"title":         "Notification",
+			"notifications": notifications,
+			"unread":        unread,
+			"unreadOnly":    query.Unread,
+		}, "layouts/main")
+	default:
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"unread":        unread,
+			"notifications": notifications,
+		})
+	}
+}
+
+// CountUnread is polled by the layout header to show the unread badge
+func (h *NotificationHandler) CountUnread(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	unread, err := h.repository.CountUnread(ctx, h.db, currentUser.IdUser)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"unread": unread,
+	})
+}
+
+func (h *NotificationHandler) MarkRead(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.MarkRead(ctx, h.db, currentUser.IdUser, id)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString(fmt.Sprintf("Success mark notification as read, id: %d", id))
+}
+
+func (h *NotificationHandler) MarkAllRead(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	count, err := h.repository.MarkAllRead(ctx, h.db, currentUser.IdUser)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString(fmt.Sprintf("Success mark %d notification as read", count))
+}
+
+func (h *NotificationHandler) Delete(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.Delete(ctx, h.db, currentUser.IdUser, id)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString(fmt.Sprintf("Success delete notification, id: %d", id))
+}
+
+// Broadcast send a system message from admin to a user, or every user when id_user is empty
+func (h *NotificationHandler) Broadcast(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := entities.NotificationBroadcast{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	if bodyPayload.IdUser == nil {
+		// Every recipient is metered in the same transaction, the meter only count one user at a time
+		tx, err := h.db.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(ctx)
+
+		count, err := h.repository.Broadcast(ctx, tx, &bodyPayload)
+		if err != nil {
+			return err
+		}
+
+		err = h.usageRepository.AddAllUsers(ctx, tx, time.Now(), entities.UsageNotificationsSent, 1)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Commit(ctx)
+		if err != nil {
+			return err
+		}
+		return c.Status(fiber.StatusCreated).SendString(fmt.Sprintf("Success send notification to %d user", count))
+	}
+
+	_, err = h.userRepository.GetById(ctx, h.db, *bodyPayload.IdUser)
+	if err != nil {
+		return err
+	}
+
+	notification, err := h.repository.Create(ctx, h.db, *bodyPayload.IdUser, &entities.NotificationCreate{
+		Type:    "system",
+		Title:   bodyPayload.Title,
+		Message: bodyPayload.Message,
+		Link:    bodyPayload.Link,
+	})
+	if err != nil {
+		return err
+	}
+	h.meter.Add(*bodyPayload.IdUser, entities.UsageNotificationsSent, 1)
+
+	return c.Status(fiber.StatusCreated).JSON(notification)
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/opcua"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type OpcuaHandler struct {
+	db               *pgxpool.Pool
+	nodeRepository   *repositories.NodeRepository
+	repository       *repositories.OpcuaRepository
+	sensorRepository *repositories.SensorRepository
+	validator        *dependencies.Validator
+}
+
+func NewOpcuaHandler(db *pgxpool.Pool, nodeRepository *repositories.NodeRepository, opcuaRepository *repositories.OpcuaRepository, sensorRepository *repositories.SensorRepository, validator *dependencies.Validator) (OpcuaHandler, error) {
+	return OpcuaHandler{
+		db:               db,
+		nodeRepository:   nodeRepository,
+		repository:       opcuaRepository,
+		sensorRepository: sensorRepository,
+		validator:        validator,
+	}, nil
+}
+
+// GetItems return the OPC-UA node mapped to the channel of the sensor, with whether it is monitored
+func (h *OpcuaHandler) GetItems(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	err = validateSensorAccess(ctx, c, h.validator, h.db, h.nodeRepository, id, entities.ShareRead, "You can’t see another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	items, err := h.repository.GetBySensor(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(items)
+}
+
+// UpdateItems replace the OPC-UA node mapped to the channel of the sensor
+func (h *OpcuaHandler) UpdateItems(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	bodyPayload := &entities.SensorOpcuaUpdate{}
+	err = h.validator.ParseBody(c, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	names := map[string]bool{}
+	for _, item := range bodyPayload.Items {
+		if names[item.Name] {
+			return fiber.NewError(400, fmt.Sprintf("Channel %q is mapped more than once", item.Name))
+		}
+		names[item.Name] = true
+		_, err = opcua.ParseNodeId(item.NodeId)
+		if err != nil {
+			return fiber.NewError(400, err.Error())
+		}
+	}
+
+	err = validateSensorAccess(ctx, c, h.validator, h.db, h.nodeRepository, id, entities.ShareWrite, "You can’t edit another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = h.repository.Update(ctx, tx, id, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success edit sensor OPC-UA item")
+}
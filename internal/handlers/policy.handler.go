@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Number of run audit returned for a policy
+const storagePolicyRunLimit = 50
+
+type StoragePolicyHandler struct {
+	db               *pgxpool.Pool
+	repository       *repositories.StoragePolicyRepository
+	sensorRepository *repositories.SensorRepository
+	validator        *dependencies.Validator
+}
+
+func NewStoragePolicyHandler(db *pgxpool.Pool, storagePolicyRepository *repositories.StoragePolicyRepository, sensorRepository *repositories.SensorRepository, validator *dependencies.Validator) (StoragePolicyHandler, error) {
+	return StoragePolicyHandler{
+		db:               db,
+		repository:       storagePolicyRepository,
+		sensorRepository: sensorRepository,
+		validator:        validator,
+	}, nil
+}
+
+// validatePayload check what the validator tag can't, the policy target and its tier
+func (h *StoragePolicyHandler) validatePayload(ctx context.Context, payload *entities.StoragePolicyCreate) error {
+	if payload.IdSensor != nil {
+		_, err := h.sensorRepository.GetById(ctx, h.db, *payload.IdSensor)
+		if err != nil {
+			return err
+		}
+	}
+	if payload.Tag != nil {
+		tag := strings.TrimSpace(*payload.Tag)
+		if tag == "" || len(tag) > 64 {
+			return fiber.NewError(400, "tag must be between 1 and 64 character")
+		}
+		payload.Tag = &tag
+	}
+	if payload.RollupSeconds > 0 && 86400%payload.RollupSeconds != 0 {
+		return fiber.NewError(400, "rollup_seconds must divide a day, like 3600 for hourly")
+	}
+	if payload.RollupDays > 0 && payload.RollupSeconds == 0 {
+		return fiber.NewError(400, "rollup_days need rollup_seconds")
+	}
+	if payload.RollupDays > 0 && payload.RollupDays <= payload.RawDays {
+		return fiber.NewError(400, "rollup_days must be more than raw_days")
+	}
+	return nil
+}
+
+func (h *StoragePolicyHandler) GetAll(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+
+	policies, err := h.repository.GetAll(ctx, h.db)
+	if err != nil {
+		return err
+	}
+	sensorIds, err := h.repository.GetSensorIds(ctx, h.db)
+	if err != nil {
+		return err
+	}
+	for i := range policies {
+		policies[i].Sensors = sensorIds[policies[i].IdPolicy]
+		if policies[i].Sensors == nil {
+			policies[i].Sensors = []int{}
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(policies)
+}
+
+func (h *StoragePolicyHandler) GetById(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	policy, err := h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+	sensorIds, err := h.repository.GetSensorIds(ctx, h.db)
+	if err != nil {
+		return err
+	}
+	policy.Sensors = sensorIds[policy.IdPolicy]
+	if policy.Sensors == nil {
+		policy.Sensors = []int{}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(policy)
+}
+
+func (h *StoragePolicyHandler) Create(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := entities.StoragePolicyCreate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	err = h.validatePayload(ctx, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	policy, err := h.repository.Create(ctx, h.db, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(policy)
+}
+
+func (h *StoragePolicyHandler) Update(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	bodyPayload := entities.StoragePolicyCreate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	err = h.validatePayload(ctx, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.Update(ctx, h.db, id, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success edit storage policy")
+}
+
+func (h *StoragePolicyHandler) Delete(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.Delete(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success delete storage policy")
+}
+
+// GetRuns return the audit of the latest storage-policy job run of the policy, newest first
+func (h *StoragePolicyHandler) GetRuns(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	runs, err := h.repository.GetRuns(ctx, h.db, id, storagePolicyRunLimit)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(runs)
+}
@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	realtimeMaxSensor = 200
+	// A proxy commonly close an idle request after 30 or 60 seconds
+	pollDefaultTimeout = 25 * time.Second
+	pollMaxTimeout     = 60 * time.Second
+	pollMaxChannel     = 1000
+)
+
+type RealtimeHandler struct {
+	db                *pgxpool.Pool
+	nodeRepository    *repositories.NodeRepository
+	sensorRepository  *repositories.SensorRepository
+	channelRepository *repositories.ChannelRepository
+	hub               *dependencies.RealtimeHub
+	validator         *dependencies.Validator
+}
+
+func NewRealtimeHandler(db *pgxpool.Pool, nodeRepository *repositories.NodeRepository, sensorRepository *repositories.SensorRepository, channelRepository *repositories.ChannelRepository, hub *dependencies.RealtimeHub, validator *dependencies.Validator) (RealtimeHandler, error) {
+	return RealtimeHandler{
+		db:                db,
+		nodeRepository:    nodeRepository,
+		sensorRepository:  sensorRepository,
+		channelRepository: channelRepository,
+		hub:               hub,
+		validator:         validator,
+	}, nil
+}
+
+// parseSensorIds parse comma separated sensor id like 1,2,3
+func parseSensorIds(value string) ([]int, error) {
+	sensorIds := []int{}
+	for _, idString := range strings.Split(value, ",") {
+		idString = strings.TrimSpace(idString)
+		if idString == "" {
+			continue
+		}
+		id, err := strconv.Atoi(idString)
+		if err != nil {
+			return nil, fiber.NewError(400, fmt.Sprintf("Invalid sensor id %s", idString))
+		}
+		sensorIds = append(sensorIds, id)
+	}
+	return sensorIds, nil
+}
+
+// Authorize check the websocket upgrade and the ownership of every sensor in the `sensors` query
+// before the connection is upgraded, because error can't be returned as http response afterward
+func (h *RealtimeHandler) Authorize(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	if !websocket.IsWebSocketUpgrade(c) {
+		return fiber.ErrUpgradeRequired
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	sensorIds, err := parseSensorIds(c.Query("sensors"))
+	if err != nil {
+		return err
+	}
+	if len(sensorIds) == 0 {
+		return fiber.NewError(400, "Query sensors is required, e.g. ?sensors=1,2,3")
+	}
+	if len(sensorIds) > realtimeMaxSensor {
+		return fiber.NewError(400, fmt.Sprintf("Can't subscribe to more than %d sensors", realtimeMaxSensor))
+	}
+
+	for _, id := range sensorIds {
+		err = checkSensorAccess(ctx, h.db, h.nodeRepository, &currentUser, id, entities.ShareRead, "You can’t see another user’s sensor")
+		if err != nil {
+			return err
+		}
+	}
+
+	c.Locals("sensorIds", sensorIds)
+	return c.Next()
+}
+
+// Stream send the latest channel of each sensor, then every new channel as JSON message
+func (h *RealtimeHandler) Stream(conn *websocket.Conn) {
+	ctx := context.Background()
+	sensorIds, _ := conn.Locals("sensorIds").([]int)
+
+	// Subscribe before reading the latest channel so nothing is missed in between
+	updates, unsubscribe := h.hub.Subscribe(sensorIds)
+	defer unsubscribe()
+
+	latest, err := h.channelRepository.GetLatestBySensors(ctx, h.db, sensorIds)
+	if err != nil {
+		log.Printf("[REALTIME] Error getting latest channel: %v", err)
+		return
+	}
+	for _, channel := range latest {
+		err = conn.WriteJSON(channel)
+		if err != nil {
+			return
+		}
+	}
+
+	// The client doesn't send anything, reading is only needed to notice when it disconnect
+	closed := make(chan bool)
+	go func() {
+		defer close(closed)
+		for {
+			_, _, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(30 * time.Second)
+	defer ping.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ping.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+			if err != nil {
+				return
+			}
+		case channel := <-updates:
+			err = conn.WriteJSON(channel)
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Poll is the long-polling fallback of the realtime feed for a client behind a proxy that break the
+// websocket. It answer at once with the channel of the sensor stored after since (the latest channel
+// without since), otherwise it wait for the next channel until the timeout and answer without channel
+func (h *RealtimeHandler) Poll(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	err = checkSensorAccess(ctx, h.db, h.nodeRepository, &currentUser, id, entities.ShareRead, "You can’t see another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	since, err := h.validator.ParseTimeQuery(c, "since")
+	if err != nil {
+		return err
+	}
+	timeout, err := h.validator.ParseDurationQuery(c, "timeout")
+	if err != nil {
+		return err
+	}
+	if timeout <= 0 {
+		timeout = pollDefaultTimeout
+	}
+	if timeout > pollMaxTimeout {
+		timeout = pollMaxTimeout
+	}
+
+	// Subscribe before reading the stored channel so nothing is missed in between
+	updates, unsubscribe := h.hub.Subscribe([]int{id})
+	defer unsubscribe()
+
+	var channels []entities.Channel
+	if since == nil {
+		channels, err = h.channelRepository.GetLatestBySensors(ctx, h.db, []int{id})
+	} else {
+		channels, err = h.channelRepository.GetAfterBySensor(ctx, h.db, id, *since, pollMaxChannel)
+	}
+	if err != nil {
+		return err
+	}
+
+	isNew := func(channel entities.Channel) bool {
+		return since == nil || channel.Time.After(*since)
+	}
+	if len(channels) == 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+	wait:
+		for {
+			select {
+			case channel := <-updates:
+				if isNew(channel) {
+					channels = append(channels, channel)
+					break wait
+				}
+			case <-timer.C:
+				break wait
+			}
+		}
+
+		// The channel published together with it are sent in the same answer
+	drain:
+		for len(channels) > 0 && len(channels) < pollMaxChannel {
+			select {
+			case channel := <-updates:
+				if isNew(channel) {
+					channels = append(channels, channel)
+				}
+			default:
+				break drain
+			}
+		}
+	}
+
+	cursor := since
+	for i := range channels {
+		if cursor == nil || channels[i].Time.After(*cursor) {
+			cursor = &channels[i].Time
+		}
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Status(fiber.StatusOK).JSON(entities.ChannelPoll{Cursor: cursor, Channels: channels})
+}
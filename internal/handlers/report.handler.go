@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type ReportHandler struct {
+	db               *pgxpool.Pool
+	repository       *repositories.ReportRepository
+	sensorRepository *repositories.SensorRepository
+	userRepository   *repositories.UserRepository
+	validator        *dependencies.Validator
+}
+
+func NewReportHandler(db *pgxpool.Pool, reportRepository *repositories.ReportRepository, sensorRepository *repositories.SensorRepository, userRepository *repositories.UserRepository, validator *dependencies.Validator) (ReportHandler, error) {
+	return ReportHandler{
+		db:               db,
+		repository:       reportRepository,
+		sensorRepository: sensorRepository,
+		userRepository:   userRepository,
+		validator:        validator,
+	}, nil
+}
+
+// validatePayload check what the validator tag can't, the range and the owner of every section sensor
+func (h *ReportHandler) validatePayload(ctx context.Context, payload *entities.ReportCreate, currentUser entities.UserRead) error {
+	_, err := h.repository.From(payload, time.Now())
+	if err != nil {
+		return err
+	}
+
+	for _, section := range payload.Sections {
+		for _, sensorId := range section.Sensors {
+			sensorOwnerId, err := h.sensorRepository.GetIdUserWhoOwnSensorById(ctx, h.db, sensorId)
+			if err != nil {
+				return err
+			}
+			if sensorOwnerId != currentUser.IdUser {
+				return fiber.NewError(403, "You can’t add another user’s sensor to your report")
+			}
+		}
+	}
+	return nil
+}
+
+// getOwnReport return the report in the url when it belong to the current user, an admin can access every report
+func (h *ReportHandler) getOwnReport(ctx context.Context, c *fiber.Ctx) (report entities.Report, err error) {
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return report, err
+	}
+
+	report, err = h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return report, err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return report, err
+	}
+
+	if report.IdUser != currentUser.IdUser && !currentUser.IsAdmin {
+		return report, fiber.NewError(403, "You can’t access another user’s report")
+	}
+	return report, nil
+}
+
+func (h *ReportHandler) GetAll(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	reports, err := h.repository.GetAll(ctx, h.db, currentUser.IdUser)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(reports)
+}
+
+func (h *ReportHandler) GetById(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	report, err := h.getOwnReport(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(report)
+}
+
+func (h *ReportHandler) Create(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := entities.ReportCreate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	err = h.validatePayload(ctx, &bodyPayload, currentUser)
+	if err != nil {
+		return err
+	}
+
+	report, err := h.repository.Create(ctx, h.db, currentUser.IdUser, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(report)
+}
+
+func (h *ReportHandler) Update(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := entities.ReportCreate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	report, err := h.getOwnReport(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	// The sensor must belong to the report owner, even when an admin edit it
+	err = h.validatePayload(ctx, &bodyPayload, entities.UserRead{IdUser: report.IdUser})
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.Update(ctx, h.db, report.IdReport, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success edit report")
+}
+
+func (h *ReportHandler) Delete(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	report, err := h.getOwnReport(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.Delete(ctx, h.db, report.IdReport)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success delete report")
+}
+
+// Render return the report as html or pdf, in the from and to query when set, otherwise the range of
+// the report up to now
+func (h *ReportHandler) Render(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	query := entities.ReportQuery{}
+	err = h.validator.ParseQuery(c, &query)
+	if err != nil {
+		return err
+	}
+
+	report, err := h.getOwnReport(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	from, err := h.validator.ParseTimeQuery(c, "from")
+	if err != nil {
+		return err
+	}
+	to, err := h.validator.ParseTimeQuery(c, "to")
+	if err != nil {
+		return err
+	}
+	if to == nil {
+		now := time.Now().UTC()
+		to = &now
+	}
+	if from == nil {
+		start, err := h.repository.From(&report.ReportCreate, *to)
+		if err != nil {
+			return err
+		}
+		from = &start
+	}
+	if !to.After(*from) {
+		return fiber.NewError(400, "to must be after from")
+	}
+
+	owner, err := h.userRepository.GetById(ctx, h.db, report.IdUser)
+	if err != nil {
+		return err
+	}
+
+	body, err := h.repository.Render(ctx, h.db, report, owner, *from, *to, query.Format)
+	if err != nil {
+		return err
+	}
+
+	if query.Format == "pdf" {
+		c.Attachment(fmt.Sprintf("report-%d.pdf", report.IdReport))
+		return c.Status(fiber.StatusOK).Send(body)
+	}
+	return c.Status(fiber.StatusOK).Type("html").Send(body)
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/dafaath/iot-server/internal/script"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type ScriptHandler struct {
+	db               *pgxpool.Pool
+	repository       *repositories.ScriptRepository
+	sensorRepository *repositories.SensorRepository
+	hooks            *script.Hooks
+	validator        *dependencies.Validator
+}
+
+func NewScriptHandler(db *pgxpool.Pool, scriptRepository *repositories.ScriptRepository, sensorRepository *repositories.SensorRepository, hooks *script.Hooks, validator *dependencies.Validator) (ScriptHandler, error) {
+	return ScriptHandler{
+		db:               db,
+		repository:       scriptRepository,
+		sensorRepository: sensorRepository,
+		hooks:            hooks,
+		validator:        validator,
+	}, nil
+}
+
+// validatePayload check the source compile and the sensor belong to the current user, a script only
+// run on the sensor of its user even for an admin
+func (h *ScriptHandler) validatePayload(ctx context.Context, payload *entities.ScriptCreate, currentUser *entities.UserRead) error {
+	err := h.hooks.Check(payload.Event, payload.Source)
+	if err != nil {
+		return err
+	}
+	if payload.IdSensor == nil {
+		return nil
+	}
+
+	sensorOwnerId, err := h.sensorRepository.GetIdUserWhoOwnSensorById(ctx, h.db, *payload.IdSensor)
+	if err != nil {
+		return err
+	}
+	if sensorOwnerId != currentUser.IdUser {
+		return fiber.NewError(403, "You can’t add a script to another user’s sensor")
+	}
+	return nil
+}
+
+// getOwnScript return the script when it belong to the current user
+func (h *ScriptHandler) getOwnScript(ctx context.Context, c *fiber.Ctx) (script entities.Script, err error) {
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return script, err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return script, err
+	}
+
+	script, err = h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return script, err
+	}
+
+	if script.IdUser != currentUser.IdUser {
+		return script, fiber.NewError(403, "You can’t access another user’s script")
+	}
+	return script, nil
+}
+
+func (h *ScriptHandler) GetAll(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	scripts, err := h.repository.GetAll(ctx, h.db, currentUser.IdUser)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(scripts)
+}
+
+func (h *ScriptHandler) GetById(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	script, err := h.getOwnScript(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(script)
+}
+
+func (h *ScriptHandler) Create(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := entities.ScriptCreate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	err = h.validatePayload(ctx, &bodyPayload, &currentUser)
+	if err != nil {
+		return err
+	}
+
+	script, err := h.repository.Create(ctx, h.db, currentUser.IdUser, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(script)
+}
+
+func (h *ScriptHandler) Update(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := entities.ScriptCreate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	script, err := h.getOwnScript(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	err = h.validatePayload(ctx, &bodyPayload, &currentUser)
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.Update(ctx, h.db, script.IdScript, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success edit script")
+}
+
+func (h *ScriptHandler) Delete(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	script, err := h.getOwnScript(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.Delete(ctx, h.db, script.IdScript)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString(fmt.Sprintf("Success delete script, id: %d", script.IdScript))
+}
+
+// Test run the source on a sample channel with the same limit as the ingest, nothing is stored
+func (h *ScriptHandler) Test(c *fiber.Ctx) (err error) {
+	bodyPayload := entities.ScriptTest{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	result, err := h.hooks.Test(&bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(result)
+}
@@ -1,34 +1,65 @@
 package handlers
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"sort"
+	"log"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/dafaath/iot-server/internal/chart"
 	"github.com/dafaath/iot-server/internal/dependencies"
 	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
 	"github.com/dafaath/iot-server/internal/repositories"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type SensorHandler struct {
-	db                 *pgxpool.Pool
-	repository         *repositories.SensorRepository
-	hardwareRepository *repositories.HardwareRepository
-	nodeRepository     *repositories.NodeRepository
-	validator          *dependencies.Validator
+	db                    *pgxpool.Pool
+	repository            *repositories.SensorRepository
+	hardwareRepository    *repositories.HardwareRepository
+	nodeRepository        *repositories.NodeRepository
+	channelRepository     *repositories.ChannelRepository
+	dashboardRepository   *repositories.DashboardRepository
+	compressionRepository *repositories.CompressionRepository
+	webhookRepository     *repositories.WebhookRepository
+	historyRepository     *repositories.HistoryRepository
+	validationRepository  *repositories.ValidationRepository
+	filterRepository      *repositories.FilterRepository
+	throttleRepository    *repositories.ThrottleRepository
+	retentionRepository   *repositories.RetentionRepository
+	transformRepository   *repositories.TransformRepository
+	validator             *dependencies.Validator
 }
 
-func NewSensorHandler(db *pgxpool.Pool, sensorRepository *repositories.SensorRepository, hardwareRepository *repositories.HardwareRepository, nodeRepository *repositories.NodeRepository, validator *dependencies.Validator) (SensorHandler, error) {
+func NewSensorHandler(db *pgxpool.Pool, sensorRepository *repositories.SensorRepository, hardwareRepository *repositories.HardwareRepository, nodeRepository *repositories.NodeRepository, channelRepository *repositories.ChannelRepository, dashboardRepository *repositories.DashboardRepository, compressionRepository *repositories.CompressionRepository, webhookRepository *repositories.WebhookRepository, historyRepository *repositories.HistoryRepository, validationRepository *repositories.ValidationRepository, filterRepository *repositories.FilterRepository, throttleRepository *repositories.ThrottleRepository, retentionRepository *repositories.RetentionRepository, transformRepository *repositories.TransformRepository, validator *dependencies.Validator) (SensorHandler, error) {
 	return SensorHandler{
-		db:                 db,
-		repository:         sensorRepository,
-		hardwareRepository: hardwareRepository,
-		nodeRepository:     nodeRepository,
-		validator:          validator,
+		db:                    db,
+		repository:            sensorRepository,
+		hardwareRepository:    hardwareRepository,
+		nodeRepository:        nodeRepository,
+		channelRepository:     channelRepository,
+		dashboardRepository:   dashboardRepository,
+		compressionRepository: compressionRepository,
+		webhookRepository:     webhookRepository,
+		historyRepository:     historyRepository,
+		validationRepository:  validationRepository,
+		filterRepository:      filterRepository,
+		throttleRepository:    throttleRepository,
+		retentionRepository:   retentionRepository,
+		transformRepository:   transformRepository,
+		validator:             validator,
 	}, nil
 }
 
@@ -40,12 +71,12 @@ func (h *SensorHandler) CreateForm(c *fiber.Ctx) (err error) {
 		return err
 	}
 
-	node, err := h.nodeRepository.GetAll(ctx, h.db, &currentUser)
+	node, err := h.nodeRepository.GetAll(ctx, h.db, &currentUser, &entities.NodeQuery{})
 	if err != nil {
 		return err
 	}
 
-	sensorHardware, err := h.hardwareRepository.GetAllSensor(ctx, h.db)
+	sensorHardware, err := h.hardwareRepository.GetAllSensor(ctx, h.db, &entities.HardwareQuery{})
 	if err != nil {
 		return err
 	}
@@ -86,60 +117,1732 @@ func (h *SensorHandler) Create(c *fiber.Ctx) (err error) {
 		return err
 	}
 
-	if currentUser.IdUser != node.IdUser {
+	allowed, err := h.nodeRepository.HasAccess(ctx, h.db, node, &currentUser, entities.ShareWrite)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return fiber.NewError(403, "You can’t use other user’s node")
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	sensor, err := h.repository.Create(ctx, tx, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	err = h.historyRepository.Record(ctx, tx, entities.EntitySensor, sensor.IdSensor, entities.RevisionCreate, currentUser.IdUser, nil, sensor)
+	if err != nil {
+		return err
+	}
+
+	err = h.webhookRepository.Enqueue(ctx, tx, node.IdUser, entities.EventSensorCreated, sensor)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The sensor is already saved, a broken template shouldn't fail the request
+	err = h.dashboardRepository.BindTemplateWidgetsForSensor(ctx, h.db, &sensor)
+	if err != nil {
+		log.Printf("[DASHBOARD] Error binding template widget for sensor %d: %v", sensor.IdSensor, err)
+	}
+
+	return c.Status(fiber.StatusCreated).SendString("Success add new sensor")
+}
+
+// CreateBatch create every sensor of the body array under the node in one transaction, nothing is
+// created when one of them is invalid. The result of each sensor is answered in order, with 400
+// when one is invalid
+func (h *SensorHandler) CreateBatch(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	batch := entities.SensorBatch{}
+	err = h.validator.ParseBodyArray(c, &batch, &batch.Sensors)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	node, err := h.nodeRepository.GetById(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	allowed, err := h.nodeRepository.HasAccess(ctx, tx, node, &currentUser, entities.ShareWrite)
+	if err != nil {
+		return err
+	}
+	if !allowed {
 		return fiber.NewError(403, "You can’t use other user’s node")
 	}
 
-	_, err = h.repository.Create(ctx, h.db, &bodyPayload)
+	results := make([]entities.SensorBatchResult, len(batch.Sensors))
+	hardwareTypes := map[int]string{}
+	failed := false
+	for i := range batch.Sensors {
+		results[i] = entities.SensorBatchResult{Index: i, Status: fiber.StatusCreated}
+		err = h.checkBatchSensor(ctx, tx, node.IdNode, &batch.Sensors[i], hardwareTypes)
+		var fiberError *fiber.Error
+		if errors.As(err, &fiberError) {
+			results[i].Status = fiberError.Code
+			results[i].Error = strings.TrimSpace(fiberError.Message)
+			failed = true
+		} else if err != nil {
+			return err
+		}
+	}
+	if failed {
+		for i := range results {
+			if results[i].Status == fiber.StatusCreated {
+				results[i].Status = fiber.StatusFailedDependency
+			}
+		}
+		return c.Status(fiber.StatusBadRequest).JSON(results)
+	}
+
+	sensors := make([]entities.Sensor, len(batch.Sensors))
+	for i := range batch.Sensors {
+		sensors[i], err = h.repository.Create(ctx, tx, &batch.Sensors[i])
+		if err != nil {
+			return err
+		}
+		results[i].IdSensor = &sensors[i].IdSensor
+
+		err = h.historyRepository.Record(ctx, tx, entities.EntitySensor, sensors[i].IdSensor, entities.RevisionCreate, currentUser.IdUser, nil, sensors[i])
+		if err != nil {
+			return err
+		}
+
+		err = h.webhookRepository.Enqueue(ctx, tx, node.IdUser, entities.EventSensorCreated, sensors[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := range sensors {
+		err = h.dashboardRepository.BindTemplateWidgetsForSensor(ctx, h.db, &sensors[i])
+		if err != nil {
+			log.Printf("[DASHBOARD] Error binding template widget for sensor %d: %v", sensors[i].IdSensor, err)
+		}
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(results)
+}
+
+// checkBatchSensor validate a sensor of the batch like Create, the type of each hardware is read once
+func (h *SensorHandler) checkBatchSensor(ctx context.Context, tx helper.Querier, idNode int, payload *entities.SensorCreate, hardwareTypes map[int]string) error {
+	if payload.IdNode == 0 {
+		payload.IdNode = idNode
+	}
+	if payload.IdNode != idNode {
+		return fiber.NewError(400, "id_node must be the node of the path or left out")
+	}
+	err := h.validator.ValidateStruct(payload)
+	if err != nil {
+		return err
+	}
+
+	hardwareType, ok := hardwareTypes[payload.IdHardware]
+	if !ok {
+		hardware, err := h.hardwareRepository.GetById(ctx, tx, payload.IdHardware)
+		if err != nil {
+			return err
+		}
+		hardwareType = strings.ToLower(hardware.Type)
+		hardwareTypes[payload.IdHardware] = hardwareType
+	}
+	if hardwareType != "sensor" {
+		return fiber.NewError(400, "Hardware type not match, type should be sensor")
+	}
+	return nil
+}
+
+func (h *SensorHandler) GetAll(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	query := entities.SensorQuery{}
+	err = h.validator.ParseQuery(c, &query)
+	if err != nil {
+		return err
+	}
+	// idNode is accepted too, like dryRun
+	if query.IdNode == 0 && c.Query("idNode") != "" {
+		query.IdNode, err = strconv.Atoi(c.Query("idNode"))
+		if err != nil || query.IdNode <= 0 {
+			return fiber.NewError(400, "idNode must be a valid positive integer")
+		}
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	accept := c.Accepts("application/json", "text/html")
+	switch accept {
+	case "text/html":
+		pageQuery := entities.PageQuery{}
+		err = h.validator.ParseQuery(c, &pageQuery)
+		if err != nil {
+			return err
+		}
+
+		total, err := h.repository.CountAll(ctx, h.db, &currentUser, &query)
+		if err != nil {
+			return err
+		}
+
+		query.Limit = pageQuery.Limit()
+		query.Offset = pageQuery.Offset()
+		sensors, err := h.repository.GetAll(ctx, h.db, &currentUser, &query)
+		if err != nil {
+			return err
+		}
+
+		// Option of the filter controls
+		nodes, err := h.nodeRepository.GetAll(ctx, h.db, &currentUser, &entities.NodeQuery{})
+		if err != nil {
+			return err
+		}
+
+		sensorHardware, err := h.hardwareRepository.GetAllSensor(ctx, h.db, &entities.HardwareQuery{})
+		if err != nil {
+			return err
+		}
+
+		return helper.RenderPage(c, "sensor", "partials/sensor_list", "sensor-list", fiber.Map{
+			"title":          "Sensor",
+			"sensors":        sensors,
+			"query":          query,
+			"page":           entities.NewPage(pageQuery, total, helper.PageFilter(c)),
+			"node":           nodes,
+			"sensorHardware": sensorHardware,
+		})
+	default:
+		listQuery := entities.SensorListQuery{}
+		err = h.validator.ParseQuery(c, &listQuery)
+		if err != nil {
+			return err
+		}
+		if listQuery.Limit == 0 {
+			sensors, err := h.repository.GetAll(ctx, h.db, &currentUser, &query)
+			if err != nil {
+				return err
+			}
+			err = h.repository.GetDisplays(ctx, h.db, sensors)
+			if err != nil {
+				return err
+			}
+
+			return c.Status(fiber.StatusOK).JSON(sensors)
+		}
+
+		if listQuery.Cursor != "" {
+			if listQuery.Offset != 0 {
+				return fiber.NewError(400, "Use either offset or cursor")
+			}
+			query.After, err = decodeSensorCursor(listQuery.Cursor)
+			if err != nil {
+				return err
+			}
+		}
+
+		total, err := h.repository.CountAll(ctx, h.db, &currentUser, &query)
+		if err != nil {
+			return err
+		}
+
+		query.Limit = listQuery.Limit
+		query.Offset = listQuery.Offset
+		sensors, err := h.repository.GetAll(ctx, h.db, &currentUser, &query)
+		if err != nil {
+			return err
+		}
+		err = h.repository.GetDisplays(ctx, h.db, sensors)
+		if err != nil {
+			return err
+		}
+
+		list := entities.SensorList{Total: total, Limit: listQuery.Limit, Offset: listQuery.Offset, Sensors: sensors}
+		if len(sensors) == listQuery.Limit {
+			last := sensors[len(sensors)-1]
+			list.NextCursor, err = encodeSensorCursor(entities.SensorCursor{Name: last.Name, IdSensor: last.IdSensor})
+			if err != nil {
+				return err
+			}
+		}
+		return c.Status(fiber.StatusOK).JSON(list)
+	}
+}
+
+func encodeSensorCursor(cursor entities.SensorCursor) (string, error) {
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeSensorCursor(value string) (*entities.SensorCursor, error) {
+	cursor := &entities.SensorCursor{}
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err == nil {
+		err = json.Unmarshal(data, cursor)
+	}
+	if err != nil || cursor.IdSensor <= 0 {
+		return nil, fiber.NewError(400, "cursor must be the next_cursor of the previous page")
+	}
+	return cursor, nil
+}
+
+func (h *SensorHandler) GetById(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	sensor, err := h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	err = checkSensorAccess(ctx, h.db, h.nodeRepository, &currentUser, id, entities.ShareRead, "You can’t see another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	accept := c.Accepts("application/json", "text/html")
+	switch accept {
+	case "text/html":
+		embedToken, err := h.repository.GetEmbedToken(ctx, h.db, id)
+		if err != nil {
+			return err
+		}
+		embedUrl := ""
+		if embedToken != nil {
+			embedUrl = fmt.Sprintf("%s/embed/sensor/%s", c.BaseURL(), *embedToken)
+		}
+
+		// The chart load the channel from the series endpoint based on the selected range
+		return c.Render("sensor_detail", fiber.Map{
+			"title":       "Sensor Detail",
+			"sensor":      sensor,
+			"range":       sensorRange(sensor),
+			"calibration": sensorCalibration(sensor),
+			"embedUrl":    embedUrl,
+		}, "layouts/main")
+	default:
+		// The whole history without from, to and interval
+		query, err := h.validator.ParseChannelFieldsQuery(c)
+		if err != nil {
+			return err
+		}
+
+		sensors := []entities.Sensor{sensor}
+		err = h.repository.GetDisplays(ctx, h.db, sensors)
+		if err != nil {
+			return err
+		}
+		return h.streamSensorWithChannel(c, sensors[0], query)
+	}
+}
+
+// sensorRange return the range the sensor measure in its unit to show, "-" when it has no bound
+func sensorRange(sensor entities.Sensor) string {
+	if sensor.MinValue == nil && sensor.MaxValue == nil {
+		return "-"
+	}
+	bound := func(value *float64, open string) string {
+		if value == nil {
+			return open
+		}
+		return strconv.FormatFloat(*value, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%s to %s %s", bound(sensor.MinValue, "-∞"), bound(sensor.MaxValue, "∞"), sensor.Unit)
+}
+
+// sensorCalibration return how the received value is calibrated to show, "-" without calibration
+func sensorCalibration(sensor entities.Sensor) string {
+	scale, offset := 1.0, 0.0
+	if sensor.CalibrationScale != nil {
+		scale = *sensor.CalibrationScale
+	}
+	if sensor.CalibrationOffset != nil {
+		offset = *sensor.CalibrationOffset
+	}
+	if scale == 1 && offset == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("value × %s + %s", strconv.FormatFloat(scale, 'f', -1, 64), strconv.FormatFloat(offset, 'f', -1, 64))
+}
+
+// Stream the sensor with its channel in the query as JSON (same shape as entities.SensorWithChannel),
+// encoding the rows one by one so a long history doesn't need to fit in memory.
+func (h *SensorHandler) streamSensorWithChannel(c *fiber.Ctx, sensor entities.Sensor, query entities.ChannelQuery) error {
+	sensorJSON, err := json.Marshal(sensor)
+	if err != nil {
+		return err
+	}
+
+	c.Status(fiber.StatusOK).Type("json")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		// Replace the closing bracket of sensor object with the channel array
+		w.Write(sensorJSON[:len(sensorJSON)-1])
+		w.WriteString(`,"channel":[`)
+
+		encoder := json.NewEncoder(w)
+		count := 0
+		err := h.channelRepository.ForEachBySensor(context.Background(), h.db, sensor.IdSensor, query, func(channel entities.Channel) error {
+			if count > 0 {
+				w.WriteByte(',')
+			}
+			count++
+
+			err := encoder.Encode(channel)
+			if err != nil {
+				return err
+			}
+
+			if count%1000 == 0 {
+				return w.Flush()
+			}
+			return nil
+		})
+		if err != nil {
+			// Status code is already sent, the client will get an invalid JSON
+			log.Printf("[STREAM ERROR] sensor %d channel: %v", sensor.IdSensor, err)
+			return
+		}
+
+		w.WriteString("]}")
+		w.Flush()
+	})
+	return nil
+}
+
+// Return forbidden error with the message if the current user is not admin and not the sensor owner
+func (h *SensorHandler) validateSensorOwner(ctx context.Context, c *fiber.Ctx, id int, message string) error {
+	sensorOwnerId, err := h.repository.GetIdUserWhoOwnSensorById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	if sensorOwnerId != currentUser.IdUser && !currentUser.IsAdmin {
+		return fiber.NewError(403, message)
+	}
+	return nil
+}
+
+// GetSeries return the channel as [epoch milliseconds, value] pairs for chart.
+// Without interval parameter, the range is downsampled to around `points` buckets.
+func (h *SensorHandler) GetSeries(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	query, err := h.validator.ParseChannelQuery(c)
+	if err != nil {
+		return err
+	}
+
+	points := c.QueryInt("points", 500)
+	if points <= 0 || points > 10000 {
+		return fiber.NewError(400, "points parameter must be between 1 and 10000")
+	}
+
+	err = validateSensorAccess(ctx, c, h.validator, h.db, h.nodeRepository, id, entities.ShareRead, "You can’t see another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	sensor, err := h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+	sensors := []entities.Sensor{sensor}
+	err = h.repository.GetDisplays(ctx, h.db, sensors)
+	if err != nil {
+		return err
+	}
+	name := ""
+	if query.Name != nil {
+		name = *query.Name
+	}
+
+	if query.Interval == 0 {
+		from, to := query.From, query.To
+		if from == nil || to == nil {
+			first, last, err := h.channelRepository.GetTimeRangeBySensor(ctx, h.db, id)
+			if err != nil {
+				return err
+			}
+			if from == nil {
+				from = first
+			}
+			if to == nil {
+				to = last
+			}
+		}
+
+		// Only downsample when each bucket would be at least one second
+		if from != nil && to != nil {
+			interval := to.Sub(*from) / time.Duration(points)
+			if interval >= time.Second {
+				query.Interval = interval.Round(time.Second)
+			}
+		}
+	}
+
+	series := [][2]interface{}{}
+	err = h.channelRepository.ForEachBySensor(ctx, h.db, id, query, func(channel entities.Channel) error {
+		series = append(series, [2]interface{}{
+			channel.Time.UnixMilli(),
+			channel.Value,
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	aggregate := query.Aggregate
+	if aggregate == "" && query.Interval > 0 {
+		aggregate = "avg"
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"interval": query.Interval.Seconds(),
+		"agg":      aggregate,
+		"display":  sensors[0].ChannelDisplay(name),
+		"series":   series,
+	})
+}
+
+// Chart render the sensor channel as PNG or SVG image (`/sensor/:id/chart.png`), by default over the last 24 hours
+func (h *SensorHandler) Chart(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	query, err := h.validator.ParseChannelQuery(c)
+	if err != nil {
+		return err
+	}
+
+	chartQuery := entities.SensorChartQuery{}
+	err = h.validator.ParseQuery(c, &chartQuery)
+	if err != nil {
+		return err
+	}
+
+	err = validateSensorAccess(ctx, c, h.validator, h.db, h.nodeRepository, id, entities.ShareRead, "You can’t see another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	sensor, err := h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	if query.To == nil {
+		to := time.Now().UTC()
+		query.To = &to
+	}
+	if query.From == nil {
+		from := query.To.Add(-24 * time.Hour)
+		query.From = &from
+	}
+	if !query.From.Before(*query.To) {
+		return fiber.NewError(400, "from parameter must be before to parameter")
+	}
+
+	return h.renderChart(ctx, c, sensor, query, chart.Chart{
+		Width:  chartQuery.Width,
+		Height: chartQuery.Height,
+		Dark:   chartQuery.Theme == "dark",
+	}, chartQuery.Title != "false")
+}
+
+// renderChart draw the channel of the query in the format of the url parameter.
+// Without interval, the range is downsampled to around one point per two pixel
+func (h *SensorHandler) renderChart(ctx context.Context, c *fiber.Ctx, sensor entities.Sensor, query entities.ChannelQuery, image chart.Chart, showTitle bool) error {
+	format := c.Params("format")
+	if format != "png" && format != "svg" {
+		return fiber.NewError(404, fmt.Sprintf("Chart format %s is not supported, use png or svg", format))
+	}
+
+	if image.Width == 0 {
+		image.Width = chart.DefaultWidth
+	}
+	if query.Interval == 0 {
+		interval := query.To.Sub(*query.From) / time.Duration(image.Width/2)
+		if interval >= time.Second {
+			query.Interval = interval.Round(time.Second)
+		}
+	}
+
+	image.From, image.To = *query.From, *query.To
+	if showTitle {
+		image.Title = sensor.Name
+		if sensor.Unit != "" {
+			image.Title = fmt.Sprintf("%s (%s)", sensor.Name, sensor.Unit)
+		}
+	}
+
+	err := h.channelRepository.ForEachBySensor(ctx, h.db, sensor.IdSensor, query, func(channel entities.Channel) error {
+		image.Points = append(image.Points, chart.Point{Time: channel.Time, Value: channel.Value})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var buffer bytes.Buffer
+	if format == "png" {
+		err = image.PNG(&buffer)
+	} else {
+		err = image.SVG(&buffer)
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).Type(format).Send(buffer.Bytes())
+}
+
+// compareMaxSensor limit the overlaid series of the comparison chart
+const compareMaxSensor = 8
+
+// Compare render the page to overlay the channel of several sensors, the sensors is preselected from the `sensors` query
+func (h *SensorHandler) Compare(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	sensors, err := h.repository.GetAll(ctx, h.db, &currentUser, &entities.SensorQuery{})
+	if err != nil {
+		return err
+	}
+
+	return c.Render("sensor_compare", fiber.Map{
+		"title":     "Compare Sensor",
+		"sensors":   sensors,
+		"selected":  c.Query("sensors"),
+		"maxSensor": compareMaxSensor,
+	}, "layouts/main")
+}
+
+// GetCompareSeries return the series of every sensor in the `sensors` query downsampled with the same interval,
+// so the bucket of each series fall at the same time and the chart tooltip can show them together
+func (h *SensorHandler) GetCompareSeries(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	sensorIds, err := parseSensorIds(c.Query("sensors"))
+	if err != nil {
+		return err
+	}
+	if len(sensorIds) == 0 {
+		return fiber.NewError(400, "Query sensors is required, e.g. ?sensors=1,2,3")
+	}
+	if len(sensorIds) > compareMaxSensor {
+		return fiber.NewError(400, fmt.Sprintf("Can't compare more than %d sensors", compareMaxSensor))
+	}
+
+	query, err := h.validator.ParseChannelQuery(c)
+	if err != nil {
+		return err
+	}
+
+	points := c.QueryInt("points", 500)
+	if points <= 0 || points > 10000 {
+		return fiber.NewError(400, "points parameter must be between 1 and 10000")
+	}
+
+	sensors := []entities.Sensor{}
+	for _, id := range sensorIds {
+		err = validateSensorAccess(ctx, c, h.validator, h.db, h.nodeRepository, id, entities.ShareRead, "You can’t see another user’s sensor")
+		if err != nil {
+			return err
+		}
+
+		sensor, err := h.repository.GetById(ctx, h.db, id)
+		if err != nil {
+			return err
+		}
+		sensors = append(sensors, sensor)
+	}
+	err = h.repository.GetDisplays(ctx, h.db, sensors)
+	if err != nil {
+		return err
+	}
+	name := ""
+	if query.Name != nil {
+		name = *query.Name
+	}
+
+	if query.Interval == 0 {
+		// Open range cover the channel of every compared sensor
+		from, to := query.From, query.To
+		if from == nil || to == nil {
+			for _, sensor := range sensors {
+				first, last, err := h.channelRepository.GetTimeRangeBySensor(ctx, h.db, sensor.IdSensor)
+				if err != nil {
+					return err
+				}
+				if query.From == nil && first != nil && (from == nil || first.Before(*from)) {
+					from = first
+				}
+				if query.To == nil && last != nil && (to == nil || last.After(*to)) {
+					to = last
+				}
+			}
+		}
+
+		if from != nil && to != nil {
+			interval := to.Sub(*from) / time.Duration(points)
+			if interval >= time.Second {
+				query.Interval = interval.Round(time.Second)
+			}
+		}
+	}
+
+	series := []fiber.Map{}
+	for _, sensor := range sensors {
+		data := [][2]interface{}{}
+		err = h.channelRepository.ForEachBySensor(ctx, h.db, sensor.IdSensor, query, func(channel entities.Channel) error {
+			data = append(data, [2]interface{}{
+				channel.Time.UnixMilli(),
+				channel.Value,
+			})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		display := sensor.ChannelDisplay(name)
+		series = append(series, fiber.Map{
+			"id_sensor": sensor.IdSensor,
+			"name":      sensor.Name,
+			"label":     display.Label,
+			"unit":      display.Unit,
+			"precision": display.Precision,
+			"data":      data,
+		})
+	}
+
+	aggregate := query.Aggregate
+	if aggregate == "" && query.Interval > 0 {
+		aggregate = "avg"
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"interval": query.Interval.Seconds(),
+		"agg":      aggregate,
+		"series":   series,
+	})
+}
+
+// Export download the sensor channel as CSV, filtered and downsampled with the same query as GetSeries.
+// The row are written as they are read so a long range isn't loaded in memory
+func (h *SensorHandler) Export(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	if format := c.Query("format", "csv"); format != "csv" {
+		return fiber.NewError(400, fmt.Sprintf("Export format %s is not supported, use csv", format))
+	}
+
+	query, err := h.validator.ParseChannelFieldsQuery(c)
+	if err != nil {
+		return err
+	}
+
+	err = validateSensorAccess(ctx, c, h.validator, h.db, h.nodeRepository, id, entities.ShareRead, "You can’t see another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	sensor, err := h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	c.Attachment(fmt.Sprintf("sensor-%d.csv", sensor.IdSensor))
+	c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	c.Status(fiber.StatusOK).Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"time", "value", "quality", "name"})
+
+		count := 0
+		err := h.channelRepository.ForEachBySensor(context.Background(), h.db, sensor.IdSensor, query, func(channel entities.Channel) error {
+			count++
+			err := writer.Write([]string{
+				channel.Time.Format(time.RFC3339),
+				strconv.FormatFloat(channel.Value, 'f', -1, 64),
+				channel.Quality,
+				channel.Name,
+			})
+			if err != nil {
+				return err
+			}
+
+			if count%1000 == 0 {
+				writer.Flush()
+				return writer.Error()
+			}
+			return nil
+		})
+		if err != nil {
+			// Status code is already sent, the client will get a truncated file
+			log.Printf("[EXPORT ERROR] sensor %d channel: %v", sensor.IdSensor, err)
+		}
+		writer.Flush()
+	})
+	return nil
+}
+
+// Embed create a new embed token of the sensor, the previous embedded chart stop working
+func (h *SensorHandler) Embed(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	err = validateSensorAccess(ctx, c, h.validator, h.db, h.nodeRepository, id, entities.ShareWrite, "You can’t edit another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	token := uuid.New().String()
+	err = h.repository.SetEmbedToken(ctx, h.db, id, &token)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/embed/sensor/%s", c.BaseURL(), token)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"embed_token": token,
+		"url":         url,
+		"iframe":      fmt.Sprintf(`<iframe src="%s?range=24h" width="600" height="300" frameborder="0"></iframe>`, url),
+	})
+}
+
+func (h *SensorHandler) Unembed(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	err = validateSensorAccess(ctx, c, h.validator, h.db, h.nodeRepository, id, entities.ShareWrite, "You can’t edit another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.SetEmbedToken(ctx, h.db, id, nil)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success revoke embedded chart")
+}
+
+// GetCompression return the compression setting of the sensor and how much of its channel is compressed
+func (h *SensorHandler) GetCompression(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	err = validateSensorAccess(ctx, c, h.validator, h.db, h.nodeRepository, id, entities.ShareRead, "You can’t see another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	setting, err := h.compressionRepository.GetSetting(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(setting)
+}
+
+// UpdateCompression set the days after which the sensor channel is compressed, 0 never compress it
+// and a missing after_days use the server default
+func (h *SensorHandler) UpdateCompression(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	bodyPayload := &entities.SensorCompressionUpdate{}
+	err = h.validator.ParseBody(c, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	err = h.validateSensorOwner(ctx, c, id, "You can’t change the compression of another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	err = h.compressionRepository.UpdateSetting(ctx, h.db, id, bodyPayload.AfterDays)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success edit sensor compression")
+}
+
+// GetDisplay return the label, unit and precision of the sensor channel
+func (h *SensorHandler) GetDisplay(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	err = validateSensorAccess(ctx, c, h.validator, h.db, h.nodeRepository, id, entities.ShareRead, "You can’t see another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	sensor, err := h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+	sensors := []entities.Sensor{sensor}
+	err = h.repository.GetDisplays(ctx, h.db, sensors)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(sensors[0].Display)
+}
+
+// UpdateDisplay replace the display of the sensor channel, one per channel name
+func (h *SensorHandler) UpdateDisplay(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	bodyPayload := &entities.SensorDisplayUpdate{}
+	err = h.validator.ParseBody(c, bodyPayload)
+	if err != nil {
+		return err
+	}
+	names := map[string]bool{}
+	for _, display := range bodyPayload.Display {
+		if names[display.Name] {
+			return fiber.NewError(400, fmt.Sprintf("Channel %q has more than one display", display.Name))
+		}
+		names[display.Name] = true
+	}
+
+	err = validateSensorAccess(ctx, c, h.validator, h.db, h.nodeRepository, id, entities.ShareWrite, "You can’t edit another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = h.repository.SetDisplay(ctx, tx, id, bodyPayload.Display)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success edit sensor display")
+}
+
+// GetChannelNames return the name of the channel the sensor has received, "" is the unnamed channel
+func (h *SensorHandler) GetChannelNames(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	err = validateSensorAccess(ctx, c, h.validator, h.db, h.nodeRepository, id, entities.ShareRead, "You can’t see another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	names, err := h.channelRepository.GetNamesBySensor(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(names)
+}
+
+// GetValidation return the validation rule of the sensor with the count of flagged and rejected channel
+func (h *SensorHandler) GetValidation(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	err = validateSensorAccess(ctx, c, h.validator, h.db, h.nodeRepository, id, entities.ShareRead, "You can’t see another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	validation, err := h.validationRepository.GetBySensor(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(validation)
+}
+
+// UpdateValidation replace the validation rule of the sensor, a missing bound is not checked
+func (h *SensorHandler) UpdateValidation(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	bodyPayload := &entities.SensorValidationUpdate{}
+	err = h.validator.ParseBody(c, bodyPayload)
+	if err != nil {
+		return err
+	}
+	if bodyPayload.Min != nil && bodyPayload.Max != nil && *bodyPayload.Min > *bodyPayload.Max {
+		return fiber.NewError(400, "min must not be above max")
+	}
+
+	err = validateSensorAccess(ctx, c, h.validator, h.db, h.nodeRepository, id, entities.ShareWrite, "You can’t edit another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	err = h.validationRepository.Update(ctx, h.db, id, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success edit sensor validation")
+}
+
+// GetFilter return the ingest filter of the sensor
+func (h *SensorHandler) GetFilter(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	err = validateSensorAccess(ctx, c, h.validator, h.db, h.nodeRepository, id, entities.ShareRead, "You can’t see another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	filter, err := h.filterRepository.GetBySensor(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(filter)
+}
+
+// UpdateFilter set the ingest filter of the sensor, the channel already received is not filtered
+func (h *SensorHandler) UpdateFilter(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	bodyPayload := &entities.SensorFilterUpdate{}
+	err = h.validator.ParseBody(c, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	err = validateSensorAccess(ctx, c, h.validator, h.db, h.nodeRepository, id, entities.ShareWrite, "You can’t edit another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	err = h.filterRepository.Update(ctx, h.db, id, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success edit sensor filter")
+}
+
+// GetThrottle return the ingest throttle of the sensor and how many channel it throttled
+func (h *SensorHandler) GetThrottle(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	err = validateSensorAccess(ctx, c, h.validator, h.db, h.nodeRepository, id, entities.ShareRead, "You can’t see another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	throttle, err := h.throttleRepository.GetBySensor(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(throttle)
+}
+
+// UpdateThrottle set the minimum interval between the stored channel of the sensor
+func (h *SensorHandler) UpdateThrottle(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	bodyPayload := &entities.SensorThrottleUpdate{}
+	err = h.validator.ParseBody(c, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	err = validateSensorAccess(ctx, c, h.validator, h.db, h.nodeRepository, id, entities.ShareWrite, "You can’t edit another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	err = h.throttleRepository.Update(ctx, h.db, id, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success edit sensor throttle")
+}
+
+// GetRetention return how long the channel of the sensor is kept and how many the retention purged
+func (h *SensorHandler) GetRetention(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	err = validateSensorAccess(ctx, c, h.validator, h.db, h.nodeRepository, id, entities.ShareRead, "You can’t see another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	retention, err := h.retentionRepository.GetBySensor(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(retention)
+}
+
+// UpdateRetention set how many day the channel of the sensor is kept
+func (h *SensorHandler) UpdateRetention(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	bodyPayload := &entities.SensorRetentionUpdate{}
+	err = h.validator.ParseBody(c, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	err = h.validateSensorOwner(ctx, c, id, "You can’t change the retention of another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	err = h.retentionRepository.Update(ctx, h.db, id, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success edit sensor retention")
+}
+
+// GetTransform return the ordered transformation step of the sensor
+func (h *SensorHandler) GetTransform(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	err = validateSensorAccess(ctx, c, h.validator, h.db, h.nodeRepository, id, entities.ShareRead, "You can’t see another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	transform, err := h.transformRepository.GetBySensor(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(transform)
+}
+
+// UpdateTransform replace the transformation step of the sensor, the channel already received is not
+// transformed
+func (h *SensorHandler) UpdateTransform(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	bodyPayload := &entities.SensorTransformUpdate{}
+	err = h.validator.ParseBody(c, bodyPayload)
+	if err != nil {
+		return err
+	}
+	err = h.transformRepository.Validate(bodyPayload.Steps)
+	if err != nil {
+		return err
+	}
+
+	err = h.validateSensorOwner(ctx, c, id, "You can’t change the transformation of another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	err = h.transformRepository.Update(ctx, h.db, id, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success edit sensor transformation")
+}
+
+// UpdateQuality flag the channel of the sensor received in the time range, e.g. as suspect or
+// calibrating so it is left out of the aggregate
+func (h *SensorHandler) UpdateQuality(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	bodyPayload := &entities.ChannelQualityUpdate{}
+	err = h.validator.ParseBody(c, bodyPayload)
+	if err != nil {
+		return err
+	}
+	if !bodyPayload.From.Before(bodyPayload.To) {
+		return fiber.NewError(400, "from must be before to")
+	}
+
+	err = h.validateSensorOwner(ctx, c, id, "You can’t flag the channel of another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	count, err := h.channelRepository.SetQuality(ctx, h.db, id, bodyPayload.From, bodyPayload.To, bodyPayload.Quality)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString(fmt.Sprintf("Success flag %d channel as %s", count, bodyPayload.Quality))
+}
+
+// GetTags return the tag of the sensor
+func (h *SensorHandler) GetTags(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	err = validateSensorAccess(ctx, c, h.validator, h.db, h.nodeRepository, id, entities.ShareRead, "You can’t see another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	tags, err := h.repository.GetTags(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"tags": tags})
+}
+
+// UpdateTags replace the tag of the sensor, an empty list remove every tag
+func (h *SensorHandler) UpdateTags(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	bodyPayload := &entities.SensorTagUpdate{}
+	err = h.validator.ParseBody(c, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	err = validateSensorAccess(ctx, c, h.validator, h.db, h.nodeRepository, id, entities.ShareWrite, "You can’t edit another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	tags := []string{}
+	for _, tag := range bodyPayload.Tags {
+		tag = strings.TrimSpace(tag)
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = h.repository.SetTags(ctx, tx, id, tags)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success edit sensor tag")
+}
+
+// GetHistory return the configuration revision of the sensor with who changed what, newest first
+func (h *SensorHandler) GetHistory(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	query := entities.RevisionQuery{}
+	err = h.validator.ParseQuery(c, &query)
+	if err != nil {
+		return err
+	}
+
+	err = validateSensorAccess(ctx, c, h.validator, h.db, h.nodeRepository, id, entities.ShareRead, "You can’t see another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	revisions, err := h.historyRepository.GetHistory(ctx, h.db, entities.EntitySensor, id, &query)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(revisions)
+}
+
+// GetHistoryDiff compare two version of the sensor
+func (h *SensorHandler) GetHistoryDiff(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	query := entities.RevisionDiffQuery{}
+	err = h.validator.ParseQuery(c, &query)
+	if err != nil {
+		return err
+	}
+
+	err = validateSensorAccess(ctx, c, h.validator, h.db, h.nodeRepository, id, entities.ShareRead, "You can’t see another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	diff, err := h.historyRepository.Diff(ctx, h.db, entities.EntitySensor, id, query.From, query.To)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(diff)
+}
+
+// Rollback set the name and unit of the sensor back to an older version, it add a new version.
+// The node and hardware of a sensor can't be changed so they are not rolled back
+func (h *SensorHandler) Rollback(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	version, err := h.validator.ParseIntFromUrlParameter(c, "version")
+	if err != nil {
+		return err
+	}
+
+	sensorOwnerId, err := h.repository.GetIdUserWhoOwnSensorById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	err = checkSensorAccess(ctx, h.db, h.nodeRepository, &currentUser, id, entities.ShareWrite, "You can’t edit another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	revision, err := h.historyRepository.GetRevision(ctx, h.db, entities.EntitySensor, id, version)
+	if err != nil {
+		return err
+	}
+	target := entities.Sensor{}
+	err = json.Unmarshal(revision.Data, &target)
+	if err != nil {
+		return err
+	}
+
+	sensor, err := h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	before := sensor
+	update := &entities.SensorUpdate{
+		Name:              target.Name,
+		Unit:              target.Unit,
+		MinValue:          target.MinValue,
+		MaxValue:          target.MaxValue,
+		CalibrationScale:  target.CalibrationScale,
+		CalibrationOffset: target.CalibrationOffset,
+	}
+	err = h.repository.Update(ctx, tx, &sensor, update)
+	if err != nil {
+		return err
+	}
+
+	update.Apply(&sensor)
+	err = h.historyRepository.Record(ctx, tx, entities.EntitySensor, id, entities.RevisionRollback, currentUser.IdUser, before, sensor)
+	if err != nil {
+		return err
+	}
+
+	err = h.webhookRepository.Enqueue(ctx, tx, sensorOwnerId, entities.EventSensorUpdated, sensor)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString(fmt.Sprintf("Success roll back sensor to version %d", version))
+}
+
+// The embedded chart range is limited so an external page can't make the server scan the whole history
+const (
+	embedDefaultRange = 24 * time.Hour
+	embedMaxRange     = 31 * 24 * time.Hour
+	embedChartPoints  = 200
+)
+
+func parseEmbedRange(value string) (time.Duration, error) {
+	if value == "" {
+		return embedDefaultRange, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 || duration > embedMaxRange {
+		return 0, fiber.NewError(400, fmt.Sprintf("Invalid range %s, use duration like 1h or 168h up to %s", value, embedMaxRange))
+	}
+	return duration, nil
+}
+
+// GetEmbed render a minimal chart of the sensor for iframe without authentication,
+// the token only give access to this sensor channel
+func (h *SensorHandler) GetEmbed(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	query := entities.SensorEmbedQuery{}
+	err = h.validator.ParseQuery(c, &query)
+	if err != nil {
+		return err
+	}
+
+	duration, err := parseEmbedRange(query.Range)
+	if err != nil {
+		return err
+	}
+
+	sensor, err := h.repository.GetByEmbedToken(ctx, h.db, c.Params("token"))
+	if err != nil {
+		return err
+	}
+
+	width := "100%"
+	if query.Width > 0 {
+		width = fmt.Sprintf("%dpx", query.Width)
+	}
+	height := 300
+	if query.Height > 0 {
+		height = query.Height
+	}
+
+	data := fiber.Map{
+		"title":      sensor.Name,
+		"sensor":     sensor,
+		"embedToken": c.Params("token"),
+		"rangeMs":    duration.Milliseconds(),
+		"range":      duration.String(),
+		"width":      width,
+		"height":     height,
+		"showTitle":  query.Title != "false",
+	}
+	if query.Theme != "" {
+		data["theme"] = query.Theme
+	}
+	return c.Render("sensor_embed", data, "layouts/embed")
+}
+
+// GetEmbedSeries return the downsampled series of the embedded sensor over the last range
+func (h *SensorHandler) GetEmbedSeries(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	duration, err := parseEmbedRange(c.Query("range"))
+	if err != nil {
+		return err
+	}
+
+	sensor, err := h.repository.GetByEmbedToken(ctx, h.db, c.Params("token"))
+	if err != nil {
+		return err
+	}
+
+	to := time.Now().UTC()
+	from := to.Add(-duration)
+	query := entities.ChannelQuery{From: &from, To: &to}
+	interval := duration / embedChartPoints
+	if interval >= time.Second {
+		query.Interval = interval.Round(time.Second)
+	}
+
+	series := [][2]interface{}{}
+	err = h.channelRepository.ForEachBySensor(ctx, h.db, sensor.IdSensor, query, func(channel entities.Channel) error {
+		series = append(series, [2]interface{}{
+			channel.Time.UnixMilli(),
+			channel.Value,
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"interval": query.Interval.Seconds(),
+		"series":   series,
+	})
+}
+
+// GetEmbedChart render the embedded sensor chart as PNG or SVG image over the last range,
+// so the chart can be put in email or chat message where iframe doesn't work
+func (h *SensorHandler) GetEmbedChart(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	query := entities.SensorEmbedQuery{}
+	err = h.validator.ParseQuery(c, &query)
+	if err != nil {
+		return err
+	}
+
+	duration, err := parseEmbedRange(query.Range)
+	if err != nil {
+		return err
+	}
+
+	sensor, err := h.repository.GetByEmbedToken(ctx, h.db, c.Params("token"))
 	if err != nil {
 		return err
 	}
 
-	return c.Status(fiber.StatusCreated).SendString("Success add new sensor")
+	to := time.Now().UTC()
+	from := to.Add(-duration)
+	return h.renderChart(ctx, c, sensor, entities.ChannelQuery{From: &from, To: &to}, chart.Chart{
+		Width:  query.Width,
+		Height: query.Height,
+		Dark:   query.Theme == "dark",
+	}, query.Title != "false")
 }
 
-func (h *SensorHandler) GetAll(c *fiber.Ctx) (err error) {
+// AuthorizeEmbedRealtime subscribe the realtime feed to the embedded sensor
+func (h *SensorHandler) AuthorizeEmbedRealtime(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	if !websocket.IsWebSocketUpgrade(c) {
+		return fiber.ErrUpgradeRequired
+	}
+
+	sensor, err := h.repository.GetByEmbedToken(ctx, h.db, c.Params("token"))
+	if err != nil {
+		return err
+	}
+
+	c.Locals("sensorIds", []int{sensor.IdSensor})
+	return c.Next()
+}
+
+// AuthorizeStream subscribe the realtime feed to the sensor in the url, with the same ownership
+// check as GetById
+func (h *SensorHandler) AuthorizeStream(c *fiber.Ctx) (err error) {
 	ctx := context.Background()
+	if !websocket.IsWebSocketUpgrade(c) {
+		return fiber.ErrUpgradeRequired
+	}
+
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
 
 	currentUser, err := h.validator.GetAuthentication(c)
 	if err != nil {
 		return err
 	}
 
-	sensors, err := h.repository.GetAll(ctx, h.db, &currentUser)
+	err = checkSensorAccess(ctx, h.db, h.nodeRepository, &currentUser, id, entities.ShareRead, "You can’t see another user’s sensor")
 	if err != nil {
 		return err
 	}
 
-	accept := c.Accepts("application/json", "text/html")
-	switch accept {
-	case "text/html":
-		sort.Slice(sensors, func(i, j int) bool {
-			return sensors[i].Name < sensors[j].Name
-		})
+	c.Locals("sensorIds", []int{id})
+	return c.Next()
+}
 
-		return c.Render("sensor", fiber.Map{
-			"title":   "Sensor",
-			"sensors": sensors,
-		}, "layouts/main")
-	default:
-		return c.Status(fiber.StatusOK).JSON(sensors)
+func (h *SensorHandler) UpdateForm(c *fiber.Ctx) (err error) {
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	sensor, err := h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	node, err := h.nodeRepository.GetAll(ctx, h.db, &currentUser, &entities.NodeQuery{})
+	if err != nil {
+		return err
+	}
+
+	sensorHardware, err := h.hardwareRepository.GetAllSensor(ctx, h.db, &entities.HardwareQuery{})
+	if err != nil {
+		return err
 	}
+
+	return c.Render("sensor_form", fiber.Map{
+		"title":          "Edit Sensor",
+		"sensor":         sensor,
+		"edit":           true,
+		"sensorHardware": sensorHardware,
+		"node":           node,
+	}, "layouts/main")
 }
 
-func (h *SensorHandler) GetById(c *fiber.Ctx) (err error) {
+func (h *SensorHandler) Update(c *fiber.Ctx) (err error) {
 	ctx := context.Background()
 	id, err := h.validator.ParseIdFromUrlParameter(c)
 	if err != nil {
 		return err
 	}
 
-	sensor, err := h.repository.GetById(ctx, h.db, id)
+	bodyPayload := &entities.SensorUpdate{}
+	err = h.validator.ParseBody(c, bodyPayload)
 	if err != nil {
 		return err
 	}
 
-	channels, err := h.repository.GetSensorChannel(ctx, h.db, id)
+	sensor, err := h.repository.GetById(ctx, h.db, id)
 	if err != nil {
 		return err
 	}
@@ -154,53 +1857,157 @@ func (h *SensorHandler) GetById(c *fiber.Ctx) (err error) {
 		return err
 	}
 
+	err = checkSensorAccess(ctx, h.db, h.nodeRepository, &currentUser, id, entities.ShareWrite, "You can’t edit another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	before := sensor
+	err = h.repository.Update(ctx, tx, &sensor, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	bodyPayload.Apply(&sensor)
+	err = h.historyRepository.Record(ctx, tx, entities.EntitySensor, sensor.IdSensor, entities.RevisionUpdate, currentUser.IdUser, before, sensor)
+	if err != nil {
+		return err
+	}
+
+	err = h.webhookRepository.Enqueue(ctx, tx, sensorOwnerId, entities.EventSensorUpdated, sensor)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success edit sensor")
+}
+
+// Move put the sensor in another node of its owner, an admin can move it to the node of another
+// user which then own it
+func (h *SensorHandler) Move(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	bodyPayload := &entities.SensorMove{}
+	err = h.validator.ParseBody(c, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	sensorOwnerId, err := h.repository.GetIdUserWhoOwnSensorById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
 	if sensorOwnerId != currentUser.IdUser && !currentUser.IsAdmin {
-		return fiber.NewError(403, "You can’t see another user’s sensor")
+		return fiber.NewError(403, "You can’t move another user’s sensor")
 	}
 
-	accept := c.Accepts("application/json", "text/html")
-	switch accept {
-	case "text/html":
-		sort.Slice(channels, func(i, j int) bool {
-			return channels[i].Time.Before(channels[j].Time)
-		})
+	node, err := h.nodeRepository.GetById(ctx, h.db, bodyPayload.IdNode)
+	if err != nil {
+		return err
+	}
+	if node.IdUser != sensorOwnerId && !currentUser.IsAdmin {
+		return fiber.NewError(403, "You can’t move a sensor to another user’s node")
+	}
 
-		mappedChannel := []interface{}{}
-		for _, channel := range channels {
-			// Convert time to epoch milliseconds
-			mappedChannel = append(mappedChannel, []interface{}{
-				channel.Time.UnixMilli(),
-				channel.Value,
-			})
-		}
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = h.repository.LockById(ctx, tx, []int{id})
+	if err != nil {
+		return err
+	}
+
+	sensor, err := h.repository.GetById(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if sensor.IdNode == node.IdNode {
+		return fiber.NewError(400, fmt.Sprintf("The sensor is already in node %d", node.IdNode))
+	}
 
-		channelJSONString, err := json.Marshal(mappedChannel)
+	err = h.repository.Move(ctx, tx, id, node.IdNode)
+	if err != nil {
+		return err
+	}
+	if node.IdUser != sensorOwnerId {
+		err = h.repository.DetachFromOtherUser(ctx, tx, id, node.IdUser)
 		if err != nil {
 			return err
 		}
+	}
 
-		return c.Render("sensor_detail", fiber.Map{
-			"title":   "Sensor Detail",
-			"sensor":  sensor,
-			"channel": string(channelJSONString),
-		}, "layouts/main")
-	default:
-		sensorWithChannelItem := entities.SensorWithChannel{
-			Sensor:  sensor,
-			Channel: channels,
+	moved := sensor
+	moved.IdNode = node.IdNode
+	err = h.historyRepository.Record(ctx, tx, entities.EntitySensor, id, entities.RevisionUpdate, currentUser.IdUser, sensor, moved)
+	if err != nil {
+		return err
+	}
+
+	err = h.webhookRepository.Enqueue(ctx, tx, sensorOwnerId, entities.EventSensorUpdated, moved)
+	if err != nil {
+		return err
+	}
+	if node.IdUser != sensorOwnerId {
+		err = h.webhookRepository.Enqueue(ctx, tx, node.IdUser, entities.EventSensorUpdated, moved)
+		if err != nil {
+			return err
 		}
-		return c.Status(fiber.StatusOK).JSON(sensorWithChannelItem)
 	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString(fmt.Sprintf("Success move sensor %d to node %d", id, node.IdNode))
 }
 
-func (h *SensorHandler) UpdateForm(c *fiber.Ctx) (err error) {
+// Merge move the channel, tag, widget and rule of the duplicate sensor into the target sensor and
+// delete the duplicate, in one transaction
+func (h *SensorHandler) Merge(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
 	id, err := h.validator.ParseIdFromUrlParameter(c)
 	if err != nil {
 		return err
 	}
-	ctx := context.Background()
 
-	sensor, err := h.repository.GetById(ctx, h.db, id)
+	bodyPayload := &entities.SensorMerge{}
+	err = h.validator.ParseBody(c, bodyPayload)
+	if err != nil {
+		return err
+	}
+	if bodyPayload.IdTarget == id {
+		return fiber.NewError(400, "A sensor can't be merged into itself")
+	}
+
+	err = h.validateSensorOwner(ctx, c, id, "You can't merge another user's sensor")
+	if err != nil {
+		return err
+	}
+	err = h.validateSensorOwner(ctx, c, bodyPayload.IdTarget, "You can't merge into another user's sensor")
 	if err != nil {
 		return err
 	}
@@ -210,63 +2017,110 @@ func (h *SensorHandler) UpdateForm(c *fiber.Ctx) (err error) {
 		return err
 	}
 
-	node, err := h.nodeRepository.GetAll(ctx, h.db, &currentUser)
+	sensorOwnerId, err := h.repository.GetIdUserWhoOwnSensorById(ctx, h.db, id)
 	if err != nil {
 		return err
 	}
 
-	sensorHardware, err := h.hardwareRepository.GetAllSensor(ctx, h.db)
+	tx, err := h.db.Begin(ctx)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback(ctx)
 
-	return c.Render("sensor_form", fiber.Map{
-		"title":          "Edit Sensor",
-		"sensor":         sensor,
-		"edit":           true,
-		"sensorHardware": sensorHardware,
-		"node":           node,
-	}, "layouts/main")
+	err = h.repository.LockById(ctx, tx, []int{id, bodyPayload.IdTarget})
+	if err != nil {
+		return err
+	}
+
+	sensor, err := h.repository.GetById(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	_, err = h.repository.GetById(ctx, tx, bodyPayload.IdTarget)
+	if err != nil {
+		return err
+	}
+
+	count, err := h.channelRepository.MoveSensor(ctx, tx, id, bodyPayload.IdTarget)
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.MoveSetting(ctx, tx, id, bodyPayload.IdTarget)
+	if err != nil {
+		return err
+	}
+
+	err = h.webhookRepository.Enqueue(ctx, tx, sensorOwnerId, entities.EventSensorDeleted, sensor)
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.Delete(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	err = h.historyRepository.Record(ctx, tx, entities.EntitySensor, id, entities.RevisionDelete, currentUser.IdUser, sensor, nil)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString(fmt.Sprintf("Success merge sensor %d into sensor %d, %d channel moved", id, bodyPayload.IdTarget, count))
 }
 
-func (h *SensorHandler) Update(c *fiber.Ctx) (err error) {
+// GetDeleted return the deleted sensor of every user for the admin to restore
+func (h *SensorHandler) GetDeleted(c *fiber.Ctx) (err error) {
 	ctx := context.Background()
-	id, err := h.validator.ParseIdFromUrlParameter(c)
+	sensors, err := h.repository.GetDeleted(ctx, h.db)
 	if err != nil {
 		return err
 	}
 
-	bodyPayload := &entities.SensorUpdate{}
-	err = h.validator.ParseBody(c, bodyPayload)
+	return c.Status(fiber.StatusOK).JSON(sensors)
+}
+
+// Restore bring back a deleted sensor, the sensor of a deleted node is restored with its node
+func (h *SensorHandler) Restore(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
 	if err != nil {
 		return err
 	}
 
-	sensor, err := h.repository.GetById(ctx, h.db, id)
+	currentUser, err := h.validator.GetAuthentication(c)
 	if err != nil {
 		return err
 	}
 
-	sensorOwnerId, err := h.repository.GetIdUserWhoOwnSensorById(ctx, h.db, id)
+	tx, err := h.db.Begin(ctx)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback(ctx)
 
-	currentUser, err := h.validator.GetAuthentication(c)
+	sensor, err := h.repository.Restore(ctx, tx, id)
 	if err != nil {
 		return err
 	}
 
-	if sensorOwnerId != currentUser.IdUser && !currentUser.IsAdmin {
-		return fiber.NewError(403, "You can’t edit another user’s sensor")
+	err = h.historyRepository.Record(ctx, tx, entities.EntitySensor, id, entities.RevisionRestore, currentUser.IdUser, nil, sensor)
+	if err != nil {
+		return err
 	}
 
-	err = h.repository.Update(ctx, h.db, &sensor, bodyPayload)
+	err = tx.Commit(ctx)
 	if err != nil {
 		return err
 	}
 
-	return c.Status(fiber.StatusOK).SendString("Success edit sensor")
+	return c.Status(fiber.StatusOK).JSON(sensor)
 }
 
 func (h *SensorHandler) Delete(c *fiber.Ctx) (err error) {
@@ -290,7 +2144,45 @@ func (h *SensorHandler) Delete(c *fiber.Ctx) (err error) {
 		return fiber.NewError(403, "You can't delete another user's sensor")
 	}
 
-	err = h.repository.Delete(ctx, h.db, id)
+	dryRun, err := h.validator.ParseDryRun(c)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		impact, err := h.repository.GetDeleteImpact(ctx, h.db, id)
+		if err != nil {
+			return err
+		}
+		return c.Status(fiber.StatusOK).JSON(impact)
+	}
+
+	sensor, err := h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = h.webhookRepository.Enqueue(ctx, tx, sensorOwnerId, entities.EventSensorDeleted, sensor)
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.Delete(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	err = h.historyRepository.Record(ctx, tx, entities.EntitySensor, id, entities.RevisionDelete, currentUser.IdUser, sensor, nil)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
 	if err != nil {
 		return err
 	}
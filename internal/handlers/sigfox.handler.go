@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/ingest"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type SigfoxHandler struct {
+	db                *pgxpool.Pool
+	repository        *repositories.SigfoxRepository
+	nodeRepository    *repositories.NodeRepository
+	sensorRepository  *repositories.SensorRepository
+	decoderRepository *repositories.DecoderRepository
+	pipeline          *ingest.Pipeline
+	validator         *dependencies.Validator
+}
+
+func NewSigfoxHandler(db *pgxpool.Pool, sigfoxRepository *repositories.SigfoxRepository, nodeRepository *repositories.NodeRepository, sensorRepository *repositories.SensorRepository, decoderRepository *repositories.DecoderRepository, pipeline *ingest.Pipeline, validator *dependencies.Validator) (SigfoxHandler, error) {
+	return SigfoxHandler{
+		db:                db,
+		repository:        sigfoxRepository,
+		nodeRepository:    nodeRepository,
+		sensorRepository:  sensorRepository,
+		decoderRepository: decoderRepository,
+		pipeline:          pipeline,
+		validator:         validator,
+	}, nil
+}
+
+// GetDevice return the Sigfox device linked to the node
+func (h *SigfoxHandler) GetDevice(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	node, err := getOwnNode(ctx, c, h.validator, h.db, h.nodeRepository, "You can’t see another user’s node")
+	if err != nil {
+		return err
+	}
+
+	device, err := h.repository.GetByNode(ctx, h.db, node.IdNode)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(device)
+}
+
+// UpdateDevice link the Sigfox device to the node, an empty device id unlink it
+func (h *SigfoxHandler) UpdateDevice(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := &entities.SigfoxDeviceUpdate{}
+	err = h.validator.ParseBody(c, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	node, err := getOwnNode(ctx, c, h.validator, h.db, h.nodeRepository, "You can’t edit another user’s node")
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = h.repository.SetDevice(ctx, tx, node.IdNode, bodyPayload.DeviceId)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success edit node Sigfox device")
+}
+
+// Callback receive the uplink forwarded by the Sigfox backend. The payload is decoded by the decoder of
+// the node hardware and each value is stored like POST /channel in the node sensor of the field.
+// The response list what happened to each value, the Sigfox backend doesn't retry an uplink anyway
+func (h *SigfoxHandler) Callback(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := &entities.SigfoxCallback{}
+	err = h.validator.ParseBody(c, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	idNode, err := h.repository.GetNodeByDevice(ctx, h.db, bodyPayload.Device)
+	if err != nil {
+		return err
+	}
+
+	node, err := h.nodeRepository.GetById(ctx, h.db, idNode)
+	if err != nil {
+		return err
+	}
+
+	if node.IdUser != currentUser.IdUser {
+		return fiber.NewError(fiber.StatusForbidden, "You can't send channel to another user's node")
+	}
+
+	decoder, err := h.decoderRepository.GetByHardware(ctx, h.db, node.IdHardware)
+	if err != nil {
+		return err
+	}
+	if h.decoderRepository.IsEmpty(decoder) {
+		return fiber.NewError(fiber.StatusUnprocessableEntity, fmt.Sprintf("Hardware %d of node %d has no decoder", node.IdHardware, node.IdNode))
+	}
+
+	data, err := hex.DecodeString(bodyPayload.Data)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "data must be an even number of hexadecimal digit")
+	}
+
+	values, err := h.decoderRepository.Decode(decoder, data)
+	if err != nil {
+		return err
+	}
+
+	results, err := storeDecoded(ctx, h.db, h.sensorRepository, h.pipeline, node, values, len(data))
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(results)
+}
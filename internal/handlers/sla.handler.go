@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type SlaHandler struct {
+	db             *pgxpool.Pool
+	repository     *repositories.SlaRepository
+	nodeRepository *repositories.NodeRepository
+	validator      *dependencies.Validator
+}
+
+func NewSlaHandler(db *pgxpool.Pool, slaRepository *repositories.SlaRepository, nodeRepository *repositories.NodeRepository, validator *dependencies.Validator) (SlaHandler, error) {
+	return SlaHandler{
+		db:             db,
+		repository:     slaRepository,
+		nodeRepository: nodeRepository,
+		validator:      validator,
+	}, nil
+}
+
+// parseMonth return the month of the query, default to the current month
+func (h *SlaHandler) parseMonth(c *fiber.Ctx) (query entities.SlaQuery, month time.Time, err error) {
+	err = h.validator.ParseQuery(c, &query)
+	if err != nil {
+		return query, month, err
+	}
+
+	month = time.Now()
+	if query.Month != "" {
+		month, err = time.Parse("2006-01", query.Month)
+		if err != nil {
+			return query, month, fiber.NewError(400, "month must be in YYYY-MM format")
+		}
+	}
+	return query, month, nil
+}
+
+// GetAll return the SLA report of every node of the user, or every node for admin, in ?month=YYYY-MM,
+// as JSON or with ?format=csv one line per node
+func (h *SlaHandler) GetAll(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	query, month, err := h.parseMonth(c)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	nodes, err := h.nodeRepository.GetAll(ctx, h.db, &currentUser, &entities.NodeQuery{})
+	if err != nil {
+		return err
+	}
+
+	reports, err := h.repository.GetMonthReports(ctx, h.db, nodes, month, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if query.Format != "csv" {
+		return c.Status(fiber.StatusOK).JSON(reports)
+	}
+	return h.writeCsv(c, fmt.Sprintf("sla-%s.csv", repositories.UsageMonth(month).Format("2006-01")), reports)
+}
+
+// GetByNode return the SLA report of the node in ?month=YYYY-MM with its downtime and maintenance window
+func (h *SlaHandler) GetByNode(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	query, month, err := h.parseMonth(c)
+	if err != nil {
+		return err
+	}
+
+	node, err := getOwnNode(ctx, c, h.validator, h.db, h.nodeRepository, "You can’t see another user’s node")
+	if err != nil {
+		return err
+	}
+
+	reports, err := h.repository.GetMonthReports(ctx, h.db, []entities.Node{node}, month, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if query.Format != "csv" {
+		return c.Status(fiber.StatusOK).JSON(reports[0])
+	}
+	return h.writeCsv(c, fmt.Sprintf("sla-node-%d-%s.csv", node.IdNode, reports[0].Month), reports)
+}
+
+// writeCsv send the reports as CSV, one line per node without the downtime window
+func (h *SlaHandler) writeCsv(c *fiber.Ctx, filename string, reports []entities.NodeSlaReport) error {
+	c.Attachment(filename)
+	c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	c.Status(fiber.StatusOK).Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"month", "id_node", "node", "availability", "downtime_seconds", "downtimes", "maintenance_seconds", "alerts"})
+		for _, report := range reports {
+			writer.Write([]string{
+				report.Month,
+				strconv.Itoa(report.IdNode),
+				report.Name,
+				strconv.FormatFloat(report.Availability, 'f', 3, 64),
+				strconv.FormatInt(report.DowntimeSeconds, 10),
+				strconv.Itoa(len(report.Downtimes)),
+				strconv.FormatInt(report.MaintenanceSeconds, 10),
+				strconv.FormatInt(report.Alerts, 10),
+			})
+		}
+		writer.Flush()
+	})
+	return nil
+}
+
+// GetMaintenances return every maintenance window of the node
+func (h *SlaHandler) GetMaintenances(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	node, err := getOwnNode(ctx, c, h.validator, h.db, h.nodeRepository, "You can’t see another user’s node")
+	if err != nil {
+		return err
+	}
+
+	maintenances, err := h.repository.GetMaintenances(ctx, h.db, node.IdNode, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(maintenances)
+}
+
+// CreateMaintenance plan a maintenance window of the node, its downtime is left out of the SLA
+func (h *SlaHandler) CreateMaintenance(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := entities.NodeMaintenanceCreate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	node, err := getOwnNode(ctx, c, h.validator, h.db, h.nodeRepository, "You can’t edit another user’s node")
+	if err != nil {
+		return err
+	}
+
+	maintenance, err := h.repository.CreateMaintenance(ctx, h.db, node.IdNode, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(maintenance)
+}
+
+func (h *SlaHandler) DeleteMaintenance(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	idMaintenance, err := h.validator.ParseIntFromUrlParameter(c, "maintenance")
+	if err != nil {
+		return err
+	}
+
+	node, err := getOwnNode(ctx, c, h.validator, h.db, h.nodeRepository, "You can’t edit another user’s node")
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.DeleteMaintenance(ctx, h.db, node.IdNode, idMaintenance)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success delete maintenance")
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/dafaath/iot-server/internal/snmp"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type SnmpHandler struct {
+	db               *pgxpool.Pool
+	nodeRepository   *repositories.NodeRepository
+	repository       *repositories.SnmpRepository
+	sensorRepository *repositories.SensorRepository
+	validator        *dependencies.Validator
+}
+
+func NewSnmpHandler(db *pgxpool.Pool, nodeRepository *repositories.NodeRepository, snmpRepository *repositories.SnmpRepository, sensorRepository *repositories.SensorRepository, validator *dependencies.Validator) (SnmpHandler, error) {
+	return SnmpHandler{
+		db:               db,
+		nodeRepository:   nodeRepository,
+		repository:       snmpRepository,
+		sensorRepository: sensorRepository,
+		validator:        validator,
+	}, nil
+}
+
+// GetDevice return the SNMP device of the sensor and the OID mapped to its channel, with the status
+// of its last read
+func (h *SnmpHandler) GetDevice(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	err = validateSensorAccess(ctx, c, h.validator, h.db, h.nodeRepository, id, entities.ShareRead, "You can’t see another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	device, err := h.repository.GetBySensor(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(device)
+}
+
+// UpdateDevice replace the SNMP device of the sensor and the OID mapped to its channel
+func (h *SnmpHandler) UpdateDevice(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	bodyPayload := &entities.SensorSnmpUpdate{}
+	err = h.validator.ParseBody(c, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	err = validateSnmpDevice(bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	err = validateSensorAccess(ctx, c, h.validator, h.db, h.nodeRepository, id, entities.ShareWrite, "You can’t edit another user’s sensor")
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = h.repository.Update(ctx, tx, id, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success edit sensor SNMP device")
+}
+
+// validateSnmpDevice check the device has the credential of its version, a payload without item
+// only remove the device
+func validateSnmpDevice(payload *entities.SensorSnmpUpdate) error {
+	names := map[string]bool{}
+	for _, item := range payload.Items {
+		if names[item.Name] {
+			return fiber.NewError(400, fmt.Sprintf("Channel %q is mapped more than once", item.Name))
+		}
+		names[item.Name] = true
+		_, err := snmp.ParseOid(item.Oid)
+		if err != nil {
+			return fiber.NewError(400, err.Error())
+		}
+	}
+	if len(payload.Items) == 0 {
+		return nil
+	}
+
+	_, err := snmp.ParseAddress(payload.Address)
+	if err != nil {
+		return fiber.NewError(400, err.Error())
+	}
+	switch payload.Version {
+	case "2c":
+		if payload.Community == "" {
+			return fiber.NewError(400, "SNMPv2c need a community")
+		}
+	case "3":
+		if payload.Username == "" {
+			return fiber.NewError(400, "SNMPv3 need a username")
+		}
+		if payload.PrivProtocol != "" && payload.AuthProtocol == "" {
+			return fiber.NewError(400, "SNMPv3 privacy need an auth protocol")
+		}
+		if payload.AuthProtocol != "" && len(payload.AuthPassword) < 8 {
+			return fiber.NewError(400, "The auth password must have at least 8 character")
+		}
+		if payload.PrivProtocol != "" && len(payload.PrivPassword) < 8 {
+			return fiber.NewError(400, "The priv password must have at least 8 character")
+		}
+	default:
+		return fiber.NewError(400, "The version must be 2c or 3")
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type StatsHandler struct {
+	db         *pgxpool.Pool
+	repository *repositories.StatsRepository
+	validator  *dependencies.Validator
+}
+
+func NewStatsHandler(db *pgxpool.Pool, statsRepository *repositories.StatsRepository, validator *dependencies.Validator) (StatsHandler, error) {
+	return StatsHandler{
+		db:         db,
+		repository: statsRepository,
+		validator:  validator,
+	}, nil
+}
+
+const statsTopTalkerLimit = 10
+
+func (h *StatsHandler) getStats(ctx context.Context) (stats entities.PlatformStats, err error) {
+	err = h.repository.GetTotals(ctx, h.db, &stats)
+	if err != nil {
+		return stats, err
+	}
+
+	now := time.Now().UTC()
+	stats.IngestLastMinute, err = h.repository.CountChannelSince(ctx, h.db, now.Add(-time.Minute))
+	if err != nil {
+		return stats, err
+	}
+
+	stats.IngestLastHour, err = h.repository.CountChannelSince(ctx, h.db, now.Add(-time.Hour))
+	if err != nil {
+		return stats, err
+	}
+
+	stats.IngestPerHour, err = h.repository.GetChannelBuckets(ctx, h.db, "hour", now.Add(-24*time.Hour))
+	if err != nil {
+		return stats, err
+	}
+
+	stats.ChannelPerDay, err = h.repository.GetChannelBuckets(ctx, h.db, "day", now.AddDate(0, 0, -30))
+	if err != nil {
+		return stats, err
+	}
+
+	stats.TableSizes, err = h.repository.GetTableSizes(ctx, h.db)
+	if err != nil {
+		return stats, err
+	}
+
+	stats.TopTalkers, err = h.repository.GetTopTalkers(ctx, h.db, now.Add(-24*time.Hour), statsTopTalkerLimit)
+	if err != nil {
+		return stats, err
+	}
+
+	// Only the error of the instance serving this request, each instance keep its own
+	stats.RecentErrors = helper.RecentErrors()
+	return stats, nil
+}
+
+func (h *StatsHandler) Get(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	stats, err := h.getStats(ctx)
+	if err != nil {
+		return err
+	}
+
+	accept := c.Accepts("application/json", "text/html")
+	switch accept {
+	case "text/html":
+		return c.Render("admin_stats", fiber.Map{
+			"title": "Statistics",
+			"stats": stats,
+		}, "layouts/main")
+	default:
+		return c.Status(fiber.StatusOK).JSON(stats)
+	}
+}
+
+// bytesPerChannel spread the channel table size over its rows
+func (h *StatsHandler) bytesPerChannel(ctx context.Context) (bytes int64, count int64, perChannel float64, err error) {
+	bytes, count, err = h.repository.GetChannelSize(ctx, h.db)
+	if err != nil {
+		return bytes, count, perChannel, err
+	}
+	if count > 0 {
+		perChannel = float64(bytes) / float64(count)
+	}
+	return bytes, count, perChannel, nil
+}
+
+func estimateBytes(channels int64, perChannel float64) int64 {
+	return int64(math.Round(float64(channels) * perChannel))
+}
+
+// GetStorage break down the channel storage per user, so the admin know who is filling the disk
+func (h *StatsHandler) GetStorage(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	report := entities.StorageReport{}
+	report.ChannelBytes, report.Channels, report.BytesPerChannel, err = h.bytesPerChannel(ctx)
+	if err != nil {
+		return err
+	}
+
+	report.Users, err = h.repository.GetUserStorage(ctx, h.db, 0)
+	if err != nil {
+		return err
+	}
+	for i := range report.Users {
+		report.Users[i].Bytes = estimateBytes(report.Users[i].Channels, report.BytesPerChannel)
+	}
+
+	accept := c.Accepts("application/json", "text/html")
+	switch accept {
+	case "text/html":
+		return c.Render("admin_storage", fiber.Map{
+			"title":  "Storage",
+			"report": report,
+		}, "layouts/main")
+	default:
+		return c.Status(fiber.StatusOK).JSON(report)
+	}
+}
+
+// GetUserStorage break down the channel storage of a user per node and sensor
+func (h *StatsHandler) GetUserStorage(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	users, err := h.repository.GetUserStorage(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+	if len(users) == 0 {
+		return fiber.NewError(404, fmt.Sprintf("User with id %d not found", id))
+	}
+
+	detail := entities.UserStorageDetail{User: users[0]}
+	_, _, detail.BytesPerChannel, err = h.bytesPerChannel(ctx)
+	if err != nil {
+		return err
+	}
+	detail.User.Bytes = estimateBytes(detail.User.Channels, detail.BytesPerChannel)
+
+	detail.Nodes, err = h.repository.GetNodeStorage(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+	for i := range detail.Nodes {
+		node := &detail.Nodes[i]
+		node.Bytes = estimateBytes(node.Channels, detail.BytesPerChannel)
+		for j := range node.Sensors {
+			node.Sensors[j].Bytes = estimateBytes(node.Sensors[j].Channels, detail.BytesPerChannel)
+		}
+	}
+
+	accept := c.Accepts("application/json", "text/html")
+	switch accept {
+	case "text/html":
+		return c.Render("admin_storage_detail", fiber.Map{
+			"title":  "Storage of " + detail.User.Username,
+			"detail": detail,
+		}, "layouts/main")
+	default:
+		return c.Status(fiber.StatusOK).JSON(detail)
+	}
+}
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Uptime of the public status page is the availability over this window, like the SLA report
+const statusPageUptimeWindow = 7 * 24 * time.Hour
+
+type StatusPageHandler struct {
+	db                *pgxpool.Pool
+	repository        *repositories.StatusPageRepository
+	nodeRepository    *repositories.NodeRepository
+	sensorRepository  *repositories.SensorRepository
+	channelRepository *repositories.ChannelRepository
+	slaRepository     *repositories.SlaRepository
+	validator         *dependencies.Validator
+}
+
+func NewStatusPageHandler(db *pgxpool.Pool, statusPageRepository *repositories.StatusPageRepository, nodeRepository *repositories.NodeRepository, sensorRepository *repositories.SensorRepository, channelRepository *repositories.ChannelRepository, slaRepository *repositories.SlaRepository, validator *dependencies.Validator) (StatusPageHandler, error) {
+	return StatusPageHandler{
+		db:                db,
+		repository:        statusPageRepository,
+		nodeRepository:    nodeRepository,
+		sensorRepository:  sensorRepository,
+		channelRepository: channelRepository,
+		slaRepository:     slaRepository,
+		validator:         validator,
+	}, nil
+}
+
+func (h *StatusPageHandler) pageResponse(c *fiber.Ctx, page entities.NodeStatusPage) error {
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"token":      page.Token,
+		"id_sensors": page.IdSensors,
+		"url":        fmt.Sprintf("%s/public/node/%s", c.BaseURL(), page.Token),
+	})
+}
+
+func (h *StatusPageHandler) Get(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	node, err := getOwnNode(ctx, c, h.validator, h.db, h.nodeRepository, "You can’t publish another user’s node")
+	if err != nil {
+		return err
+	}
+
+	page, err := h.repository.GetByNode(ctx, h.db, node.IdNode)
+	if err != nil {
+		return err
+	}
+
+	return h.pageResponse(c, page)
+}
+
+// Publish create the status page of the node or change its sensors. The token is kept so the shared
+// url keep working, unless rotate is set
+func (h *StatusPageHandler) Publish(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := entities.NodeStatusPageUpdate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	node, err := getOwnNode(ctx, c, h.validator, h.db, h.nodeRepository, "You can’t publish another user’s node")
+	if err != nil {
+		return err
+	}
+
+	sensors, err := h.sensorRepository.GetNodeSensor(ctx, h.db, node.IdNode)
+	if err != nil {
+		return err
+	}
+	nodeSensors := map[int]bool{}
+	for _, sensor := range sensors {
+		nodeSensors[sensor.IdSensor] = true
+	}
+	idSensors := []int{}
+	for _, idSensor := range bodyPayload.IdSensors {
+		if !nodeSensors[idSensor] {
+			return fiber.NewError(400, fmt.Sprintf("Sensor %d is not a sensor of node %d", idSensor, node.IdNode))
+		}
+		idSensors = append(idSensors, idSensor)
+	}
+
+	page, err := h.repository.GetByNode(ctx, h.db, node.IdNode)
+	if err != nil {
+		var fiberErr *fiber.Error
+		if !errors.As(err, &fiberErr) || fiberErr.Code != 404 {
+			return err
+		}
+		page = entities.NodeStatusPage{IdNode: node.IdNode}
+	}
+	if page.Token == "" || bodyPayload.Rotate {
+		page.Token = uuid.New().String()
+	}
+	page.IdSensors = idSensors
+
+	err = h.repository.Save(ctx, h.db, &page)
+	if err != nil {
+		return err
+	}
+
+	return h.pageResponse(c, page)
+}
+
+func (h *StatusPageHandler) Unpublish(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	node, err := getOwnNode(ctx, c, h.validator, h.db, h.nodeRepository, "You can’t publish another user’s node")
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.Delete(ctx, h.db, node.IdNode)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success unpublish status page")
+}
+
+// GetPublic show the status, uptime and latest value of the published node without authentication,
+// as JSON or as a page for a browser. The response is cached by the route
+func (h *StatusPageHandler) GetPublic(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	page, err := h.repository.GetByToken(ctx, h.db, c.Params("token"))
+	if err != nil {
+		return err
+	}
+
+	node, err := h.nodeRepository.GetById(ctx, h.db, page.IdNode)
+	if err != nil {
+		return err
+	}
+
+	sensors, err := h.sensorRepository.GetNodeSensor(ctx, h.db, node.IdNode)
+	if err != nil {
+		return err
+	}
+	sensorIds := make([]int, len(sensors))
+	sensorById := map[int]entities.Sensor{}
+	for i, sensor := range sensors {
+		sensorIds[i] = sensor.IdSensor
+		sensorById[sensor.IdSensor] = sensor
+	}
+
+	// Last seen is from every sensor of the node, like the node status
+	latest, err := h.channelRepository.GetLatestBySensors(ctx, h.db, sensorIds)
+	if err != nil {
+		return err
+	}
+	activity := entities.NodeActivity{IdNode: node.IdNode, SensorCount: len(sensors)}
+	latestBySensor := map[int]entities.Channel{}
+	for _, channel := range latest {
+		latestBySensor[channel.IdSensor] = channel
+		if activity.LastSeen == nil || channel.Time.After(*activity.LastSeen) {
+			channelTime := channel.Time
+			activity.LastSeen = &channelTime
+		}
+	}
+
+	now := time.Now().UTC()
+	report, err := h.slaRepository.GetNodeReport(ctx, h.db, node, now.Add(-statusPageUptimeWindow), now)
+	if err != nil {
+		return err
+	}
+
+	status := entities.NodePublicStatus{
+		Name:      node.Name,
+		Status:    nodeStatus(activity),
+		LastSeen:  activity.LastSeen,
+		Uptime:    report.Availability,
+		Values:    []entities.NodePublicValue{},
+		UpdatedAt: now,
+	}
+	for _, idSensor := range page.IdSensors {
+		sensor, ok := sensorById[idSensor]
+		if !ok {
+			continue
+		}
+		channel, ok := latestBySensor[idSensor]
+		if !ok {
+			continue
+		}
+		status.Values = append(status.Values, entities.NodePublicValue{
+			Sensor: sensor.Name,
+			Unit:   sensor.Unit,
+			Name:   channel.Name,
+			Value:  channel.Value,
+			Time:   channel.Time,
+		})
+	}
+
+	if c.Accepts("application/json", "text/html") == "text/html" {
+		// The time is written in RFC 3339 for the page script to show it in the visitor time zone
+		values := []fiber.Map{}
+		for _, value := range status.Values {
+			values = append(values, fiber.Map{
+				"sensor": value.Sensor,
+				"unit":   value.Unit,
+				"name":   value.Name,
+				"value":  value.Value,
+				"time":   value.Time.Format(time.RFC3339),
+			})
+		}
+		lastSeen := ""
+		if status.LastSeen != nil {
+			lastSeen = status.LastSeen.Format(time.RFC3339)
+		}
+		statusClass := map[string]string{"online": "bg-success", "stale": "bg-warning"}[status.Status]
+		if statusClass == "" {
+			statusClass = "bg-secondary"
+		}
+		return c.Render("node_status", fiber.Map{
+			"title":       node.Name,
+			"name":        status.Name,
+			"status":      status.Status,
+			"statusClass": statusClass,
+			"lastSeen":    lastSeen,
+			"uptime":      fmt.Sprintf("%.2f", status.Uptime),
+			"values":      values,
+			"updatedAt":   status.UpdatedAt.Format(time.RFC3339),
+		}, "layouts/public")
+	}
+	return c.Status(fiber.StatusOK).JSON(status)
+}
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/replication"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	deltaReadingLimit = 10000
+	// Reading sent to a client without cursor
+	deltaFullWindow = 24 * time.Hour
+)
+
+// SyncHandler receive the push of an edge instance on the central instance, and show the push state
+// on the edge instance
+type SyncHandler struct {
+	db         *pgxpool.Pool
+	repository *repositories.SyncRepository
+	pusher     *replication.Pusher
+	validator  *dependencies.Validator
+}
+
+// NewSyncHandler take a nil pusher when this instance doesn't push
+func NewSyncHandler(db *pgxpool.Pool, syncRepository *repositories.SyncRepository, pusher *replication.Pusher, validator *dependencies.Validator) (SyncHandler, error) {
+	return SyncHandler{
+		db:         db,
+		repository: syncRepository,
+		pusher:     pusher,
+		validator:  validator,
+	}, nil
+}
+
+// Push store the batch of the edge for the current user, the whole batch is stored or none of it
+func (h *SyncHandler) Push(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := entities.SyncBatch{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := h.repository.Apply(ctx, tx, currentUser.IdUser, &bodyPayload)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(result)
+}
+
+// getOwnSite return the site in the url when it is pushed by the current user
+func (h *SyncHandler) getOwnSite(ctx context.Context, c *fiber.Ctx) (site entities.SyncSite, err error) {
+	site, err = h.repository.GetSite(ctx, h.db, c.Params("site"))
+	if err != nil {
+		return site, err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return site, err
+	}
+
+	if site.IdUser != currentUser.IdUser {
+		return site, fiber.NewError(403, "You can’t access another user’s site")
+	}
+	return site, nil
+}
+
+func (h *SyncHandler) GetSites(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	sites, err := h.repository.GetSites(ctx, h.db, currentUser.IdUser)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(sites)
+}
+
+// UpdateSite change the conflict rule of the site, it apply from the next push
+func (h *SyncHandler) UpdateSite(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := entities.SyncSiteUpdate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	site, err := h.getOwnSite(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.SetConflict(ctx, h.db, site.Site, bodyPayload.Conflict)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success edit site")
+}
+
+func (h *SyncHandler) DeleteSite(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	site, err := h.getOwnSite(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.DeleteSite(ctx, h.db, site.Site)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString(fmt.Sprintf("Success delete site %s", site.Site))
+}
+
+// GetStatus return the push state of this edge instance with the channel waiting to be pushed
+func (h *SyncHandler) GetStatus(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	if h.pusher == nil {
+		return c.Status(fiber.StatusOK).JSON(entities.SyncStatus{})
+	}
+
+	status, err := h.repository.GetState(ctx, h.db, h.pusher.Site())
+	if err != nil {
+		return err
+	}
+	status.Enabled = true
+	status.Url = h.pusher.Url()
+
+	status.Pending, err = h.repository.CountChannelsAfter(ctx, h.db, status.Cursor)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(status)
+}
+
+// GetDelta return the entity and reading that changed for the current user since the cursor of the
+// client, so a mobile client can keep an offline copy. The last seconds are left for the next request
+// like the edge push. When the reading is over the limit, the cursor stop at the last sent reading and
+// More is set
+func (h *SyncHandler) GetDelta(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	since, err := h.validator.ParseTimeQuery(c, "since")
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	until := time.Now().UTC().Add(-replication.CommitLag)
+	after := until.Add(-deltaFullWindow)
+	if since != nil {
+		after = *since
+		if after.After(until) {
+			until = after
+		}
+	}
+
+	// Every part of the delta is read at the same point
+	tx, err := h.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	delta := entities.SyncDelta{Cursor: until, Full: since == nil}
+	err = h.repository.GetDelta(ctx, tx, currentUser, since, until, &delta)
+	if err != nil {
+		return err
+	}
+
+	channels, err := h.repository.GetUserChannelsAfter(ctx, tx, currentUser, after, until, deltaReadingLimit)
+	if err != nil {
+		return err
+	}
+	full := len(channels) == deltaReadingLimit
+	channels = replication.TrimBatch(channels, full)
+	if full {
+		delta.More = true
+		delta.Cursor = channels[len(channels)-1].Time
+	}
+
+	delta.Readings = make([]entities.SyncReading, 0, len(channels))
+	for _, channel := range channels {
+		reading := entities.SyncReading{channel.IdSensor, channel.Time.UnixMilli(), channel.Value}
+		if channel.Name != "" {
+			reading = append(reading, channel.Name)
+		}
+		delta.Readings = append(delta.Readings, reading)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(delta)
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TimeHandler give the server clock to a device without RTC, it doesn't touch the database
+type TimeHandler struct{}
+
+func NewTimeHandler() (TimeHandler, error) {
+	return TimeHandler{}, nil
+}
+
+// GetTime return the server time in unix millisecond. The integer t query, e.g. the device uptime
+// when it sent the request, is echoed back so the device can compute the round trip and add half of
+// it. format=text answer "{time}" or "{time} {t}" instead of JSON for the smallest response
+func (h *TimeHandler) GetTime(c *fiber.Ctx) error {
+	now := time.Now().UnixMilli()
+	c.Set(fiber.HeaderCacheControl, "no-store")
+
+	var echo *int64
+	if t := c.Query("t"); t != "" {
+		value, err := strconv.ParseInt(t, 10, 64)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "t must be an integer")
+		}
+		echo = &value
+	}
+
+	if c.Query("format") == "text" {
+		body := strconv.FormatInt(now, 10)
+		if echo != nil {
+			body += " " + strconv.FormatInt(*echo, 10)
+		}
+		return c.Status(fiber.StatusOK).SendString(body)
+	}
+	if echo != nil {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"time": now, "t": *echo})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"time": now})
+}
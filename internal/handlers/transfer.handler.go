@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/metering"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type TransferHandler struct {
+	db                     *pgxpool.Pool
+	repository             *repositories.TransferRepository
+	nodeRepository         *repositories.NodeRepository
+	sensorRepository       *repositories.SensorRepository
+	userRepository         *repositories.UserRepository
+	notificationRepository *repositories.NotificationRepository
+	webhookRepository      *repositories.WebhookRepository
+	historyRepository      *repositories.HistoryRepository
+	meter                  *metering.Meter
+	validator              *dependencies.Validator
+}
+
+func NewTransferHandler(db *pgxpool.Pool, transferRepository *repositories.TransferRepository, nodeRepository *repositories.NodeRepository, sensorRepository *repositories.SensorRepository, userRepository *repositories.UserRepository, notificationRepository *repositories.NotificationRepository, webhookRepository *repositories.WebhookRepository, historyRepository *repositories.HistoryRepository, meter *metering.Meter, validator *dependencies.Validator) (TransferHandler, error) {
+	return TransferHandler{
+		db:                     db,
+		repository:             transferRepository,
+		nodeRepository:         nodeRepository,
+		sensorRepository:       sensorRepository,
+		userRepository:         userRepository,
+		notificationRepository: notificationRepository,
+		webhookRepository:      webhookRepository,
+		historyRepository:      historyRepository,
+		meter:                  meter,
+		validator:              validator,
+	}, nil
+}
+
+// notify tell the user about the transfer, the transfer is already saved so a failure is only logged
+func (h *TransferHandler) notify(ctx context.Context, idUser int, title string, message string) {
+	link := "/transfer"
+	_, err := h.notificationRepository.Create(ctx, h.db, idUser, &entities.NotificationCreate{
+		Type:    "share",
+		Title:   title,
+		Message: message,
+		Link:    &link,
+	})
+	if err != nil {
+		log.Printf("[NOTIFICATION] Error notifying transfer to user %d: %v", idUser, err)
+		return
+	}
+	h.meter.Add(idUser, entities.UsageNotificationsSent, 1)
+}
+
+// GetAll return the transfer offered by and to the current user
+func (h *TransferHandler) GetAll(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	transfers, err := h.repository.GetByUser(ctx, h.db, currentUser.IdUser)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(transfers)
+}
+
+// Create offer the node to another user, the node owner or an admin can offer it
+func (h *TransferHandler) Create(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := &entities.NodeTransferCreate{}
+	err = h.validator.ParseBody(c, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	node, err := h.nodeRepository.GetById(ctx, h.db, bodyPayload.IdNode)
+	if err != nil {
+		return err
+	}
+	if node.IdUser != currentUser.IdUser && !currentUser.IsAdmin {
+		return fiber.NewError(403, "You can’t transfer another user’s node")
+	}
+
+	recipient, err := h.userRepository.GetByUsername(ctx, h.db, bodyPayload.Username)
+	if err != nil {
+		return err
+	}
+	if recipient.IdUser == node.IdUser {
+		return fiber.NewError(400, "The node is already owned by this user")
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	transfer, err := h.repository.Create(ctx, tx, node.IdNode, node.IdUser, recipient.IdUser)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	h.notify(ctx, recipient.IdUser, fmt.Sprintf("Node %s offered to you", node.Name),
+		fmt.Sprintf("%s want to transfer node %s with its sensors and their channel to you, accept or decline it in your transfers", transfer.FromUsername, node.Name))
+
+	return c.Status(fiber.StatusCreated).JSON(transfer)
+}
+
+// Accept give the node, its sensor and their channel and rule to the current user
+func (h *TransferHandler) Accept(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	transfer, err := h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+	if transfer.IdToUser != currentUser.IdUser {
+		return fiber.NewError(403, "You can’t accept a transfer offered to another user")
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = h.repository.Respond(ctx, tx, id, entities.TransferAccepted)
+	if err != nil {
+		return err
+	}
+
+	node, err := h.nodeRepository.GetById(ctx, tx, transfer.IdNode)
+	if err != nil {
+		return err
+	}
+	if node.IdUser != transfer.IdFromUser {
+		return fiber.NewError(409, "The node owner changed since the transfer was offered")
+	}
+
+	err = h.nodeRepository.UpdateOwner(ctx, tx, node.IdNode, currentUser.IdUser)
+	if err != nil {
+		return err
+	}
+
+	sensors, err := h.sensorRepository.GetNodeSensor(ctx, tx, node.IdNode)
+	if err != nil {
+		return err
+	}
+	for _, sensor := range sensors {
+		err = h.sensorRepository.DetachFromOtherUser(ctx, tx, sensor.IdSensor, currentUser.IdUser)
+		if err != nil {
+			return err
+		}
+	}
+
+	transferred := node
+	transferred.IdUser = currentUser.IdUser
+	err = h.historyRepository.Record(ctx, tx, entities.EntityNode, node.IdNode, entities.RevisionUpdate, currentUser.IdUser, node, transferred)
+	if err != nil {
+		return err
+	}
+
+	for _, idUser := range []int{transfer.IdFromUser, currentUser.IdUser} {
+		err = h.webhookRepository.Enqueue(ctx, tx, idUser, entities.EventNodeUpdated, transferred)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	h.notify(ctx, transfer.IdFromUser, fmt.Sprintf("Node %s transferred", node.Name),
+		fmt.Sprintf("%s accepted the transfer of node %s, it is now their node", transfer.ToUsername, node.Name))
+
+	return c.Status(fiber.StatusOK).SendString(fmt.Sprintf("Success transfer node %d with %d sensor", node.IdNode, len(sensors)))
+}
+
+// Decline refuse the transfer offered to the current user
+func (h *TransferHandler) Decline(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	transfer, err := h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+	if transfer.IdToUser != currentUser.IdUser {
+		return fiber.NewError(403, "You can’t decline a transfer offered to another user")
+	}
+
+	err = h.repository.Respond(ctx, h.db, id, entities.TransferDeclined)
+	if err != nil {
+		return err
+	}
+
+	h.notify(ctx, transfer.IdFromUser, fmt.Sprintf("Transfer of node %s declined", transfer.NodeName),
+		fmt.Sprintf("%s declined the transfer of node %s", transfer.ToUsername, transfer.NodeName))
+
+	return c.Status(fiber.StatusOK).SendString("Success decline transfer")
+}
+
+// Cancel withdraw the transfer, by the node owner who offered it or an admin
+func (h *TransferHandler) Cancel(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	transfer, err := h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+	if transfer.IdFromUser != currentUser.IdUser && !currentUser.IsAdmin {
+		return fiber.NewError(403, "You can’t cancel another user’s transfer")
+	}
+
+	err = h.repository.Respond(ctx, h.db, id, entities.TransferCancelled)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success cancel transfer")
+}
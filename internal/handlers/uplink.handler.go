@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/ingest"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Longest raw payload of an uplink, a LoRaWAN payload is at most 242 byte
+const maxUplinkSize = 512
+
+type UplinkHandler struct {
+	db                *pgxpool.Pool
+	nodeRepository    *repositories.NodeRepository
+	sensorRepository  *repositories.SensorRepository
+	decoderRepository *repositories.DecoderRepository
+	pipeline          *ingest.Pipeline
+	validator         *dependencies.Validator
+}
+
+func NewUplinkHandler(db *pgxpool.Pool, nodeRepository *repositories.NodeRepository, sensorRepository *repositories.SensorRepository, decoderRepository *repositories.DecoderRepository, pipeline *ingest.Pipeline, validator *dependencies.Validator) (UplinkHandler, error) {
+	return UplinkHandler{
+		db:                db,
+		nodeRepository:    nodeRepository,
+		sensorRepository:  sensorRepository,
+		decoderRepository: decoderRepository,
+		pipeline:          pipeline,
+		validator:         validator,
+	}, nil
+}
+
+// readUplink return the raw payload, the body itself with application/octet-stream or the hex or
+// base64 data of the JSON body
+func (h *UplinkHandler) readUplink(c *fiber.Ctx) ([]byte, error) {
+	if strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEOctetStream) {
+		data := c.Body()
+		if len(data) == 0 || len(data) > maxUplinkSize {
+			return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("The payload must have 1 to %d byte", maxUplinkSize))
+		}
+		return append([]byte{}, data...), nil
+	}
+
+	bodyPayload := &entities.Uplink{}
+	err := h.validator.ParseBody(c, bodyPayload)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	if bodyPayload.Encoding == "base64" {
+		data, err = base64.StdEncoding.DecodeString(bodyPayload.Data)
+		if err != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "data must be base64")
+		}
+	} else {
+		data, err = hex.DecodeString(bodyPayload.Data)
+		if err != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "data must be an even number of hexadecimal digit")
+		}
+	}
+	if len(data) > maxUplinkSize {
+		return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("The payload must have 1 to %d byte", maxUplinkSize))
+	}
+	return data, nil
+}
+
+// Receive decode the raw payload of the node with the decoder of its hardware, and store each value
+// like POST /channel in the node sensor of the field. The response list what happened to each value
+func (h *UplinkHandler) Receive(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	data, err := h.readUplink(c)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	node, err := h.nodeRepository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	if node.IdUser != currentUser.IdUser {
+		return fiber.NewError(fiber.StatusForbidden, "You can't send channel to another user's node")
+	}
+
+	decoder, err := h.decoderRepository.GetByHardware(ctx, h.db, node.IdHardware)
+	if err != nil {
+		return err
+	}
+	if h.decoderRepository.IsEmpty(decoder) {
+		return fiber.NewError(fiber.StatusUnprocessableEntity, fmt.Sprintf("Hardware %d of node %d has no decoder", node.IdHardware, node.IdNode))
+	}
+
+	values, err := h.decoderRepository.Decode(decoder, data)
+	if err != nil {
+		return err
+	}
+
+	results, err := storeDecoded(ctx, h.db, h.sensorRepository, h.pipeline, node, values, len(data))
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(results)
+}
+
+// storeDecoded store each decoded value in the node sensor of its name like POST /channel, the
+// payload size is counted for every value
+func storeDecoded(ctx context.Context, db *pgxpool.Pool, sensorRepository *repositories.SensorRepository, pipeline *ingest.Pipeline, node entities.Node, values []entities.DecodedValue, size int) ([]entities.DecodedResult, error) {
+	sensors, err := sensorRepository.GetNodeSensor(ctx, db, node.IdNode)
+	if err != nil {
+		return nil, err
+	}
+	sensorIds := map[string]int{}
+	for _, sensor := range sensors {
+		sensorIds[sensor.Name] = sensor.IdSensor
+	}
+
+	results := make([]entities.DecodedResult, 0, len(values))
+	for _, value := range values {
+		result := entities.DecodedResult{DecodedValue: value, Status: fiber.StatusCreated}
+		idSensor, ok := sensorIds[value.Sensor]
+		if !ok {
+			result.Status = fiber.StatusNotFound
+			result.Error = fmt.Sprintf("Node %d has no sensor named %s", node.IdNode, value.Sensor)
+			results = append(results, result)
+			continue
+		}
+		result.IdSensor = &idSensor
+
+		_, coalesced, err := pipeline.Store(ctx, node.IdUser, &entities.ChannelCreate{
+			IdSensor: idSensor,
+			Name:     value.Name,
+			Value:    value.Value,
+		})
+		pipeline.Record(idSensor, size, err)
+		if coalesced {
+			result.Status = fiber.StatusOK
+		}
+		if err != nil {
+			result.Status = fiber.StatusInternalServerError
+			var fiberErr *fiber.Error
+			if errors.As(err, &fiberErr) {
+				result.Status = fiberErr.Code
+			}
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
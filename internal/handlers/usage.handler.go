@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/metering"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type UsageHandler struct {
+	db         *pgxpool.Pool
+	repository *repositories.UsageRepository
+	meter      *metering.Meter
+	validator  *dependencies.Validator
+}
+
+func NewUsageHandler(db *pgxpool.Pool, usageRepository *repositories.UsageRepository, meter *metering.Meter, validator *dependencies.Validator) (UsageHandler, error) {
+	return UsageHandler{
+		db:         db,
+		repository: usageRepository,
+		meter:      meter,
+		validator:  validator,
+	}, nil
+}
+
+// Export return the usage of every user in ?month=YYYY-MM (default the current month),
+// as JSON or with ?format=csv one invoice line per user
+func (h *UsageHandler) Export(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	query := entities.UsageQuery{}
+	err = h.validator.ParseQuery(c, &query)
+	if err != nil {
+		return err
+	}
+
+	month := time.Now()
+	if query.Month != "" {
+		month, err = time.Parse("2006-01", query.Month)
+		if err != nil {
+			return fiber.NewError(400, "month must be in YYYY-MM format")
+		}
+	}
+	month = repositories.UsageMonth(month)
+
+	// Include the usage counted by this instance that is not flushed yet
+	err = h.meter.Flush(ctx)
+	if err != nil {
+		return err
+	}
+
+	reports, err := h.repository.GetMonthReport(ctx, h.db, month)
+	if err != nil {
+		return err
+	}
+
+	if query.Format != "csv" {
+		return c.Status(fiber.StatusOK).JSON(entities.UsageExport{
+			Month:      month.Format("2006-01"),
+			ExportedAt: time.Now().UTC(),
+			Users:      reports,
+		})
+	}
+
+	c.Attachment(fmt.Sprintf("usage-%s.csv", month.Format("2006-01")))
+	c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	c.Status(fiber.StatusOK).Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"month", "id_user", "username", "email", entities.UsageApiCalls, entities.UsagePointsStored, entities.UsageNotificationsSent})
+		for _, report := range reports {
+			writer.Write([]string{
+				report.Month,
+				strconv.Itoa(report.IdUser),
+				report.Username,
+				report.Email,
+				strconv.FormatInt(report.ApiCalls, 10),
+				strconv.FormatInt(report.PointsStored, 10),
+				strconv.FormatInt(report.NotificationsSent, 10),
+			})
+		}
+		writer.Flush()
+	})
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strconv"
 
+	"github.com/dafaath/iot-server/configs"
 	"github.com/dafaath/iot-server/internal/dependencies"
 	"github.com/dafaath/iot-server/internal/entities"
 	"github.com/dafaath/iot-server/internal/helper"
@@ -14,19 +15,34 @@ import (
 )
 
 type UserHandler struct {
-	db         *pgxpool.Pool
-	repository *repositories.UserRepository
-	validator  *dependencies.Validator
+	db                *pgxpool.Pool
+	repository        *repositories.UserRepository
+	sessionRepository *repositories.SessionRepository
+	webhookRepository *repositories.WebhookRepository
+	loginLimiter      *dependencies.LoginLimiter
+	validator         *dependencies.Validator
 }
 
-func NewUserHandler(db *pgxpool.Pool, userRepository *repositories.UserRepository, validator *dependencies.Validator) (UserHandler, error) {
+func NewUserHandler(db *pgxpool.Pool, userRepository *repositories.UserRepository, sessionRepository *repositories.SessionRepository, webhookRepository *repositories.WebhookRepository, loginLimiter *dependencies.LoginLimiter, validator *dependencies.Validator) (UserHandler, error) {
 	return UserHandler{
-		db:         db,
-		validator:  validator,
-		repository: userRepository,
+		db:                db,
+		validator:         validator,
+		repository:        userRepository,
+		sessionRepository: sessionRepository,
+		webhookRepository: webhookRepository,
+		loginLimiter:      loginLimiter,
 	}, nil
 }
 
+func webhookUser(user entities.UserRead) entities.WebhookUser {
+	return entities.WebhookUser{
+		IdUser:   user.IdUser,
+		Email:    user.Email,
+		Username: user.Username,
+		IsAdmin:  user.IsAdmin,
+	}
+}
+
 func (u *UserHandler) RegisterPage(c *fiber.Ctx) (err error) {
 	return c.Render("register", fiber.Map{
 		"title": "Register",
@@ -56,7 +72,23 @@ func (u *UserHandler) Register(c *fiber.Ctx) (err error) {
 		return fiber.NewError(fiber.StatusConflict, "Email already used")
 	}
 
-	user, err := u.repository.Create(ctx, u.db, bodyPayload)
+	tx, err := u.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	user, err := u.repository.Create(ctx, tx, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	err = u.webhookRepository.Enqueue(ctx, tx, user.IdUser, entities.EventUserRegistered, webhookUser(user))
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
 	if err != nil {
 		return err
 	}
@@ -91,9 +123,19 @@ func (u *UserHandler) Login(c *fiber.Ctx) (err error) {
 		return err
 	}
 
+	retryAfter, err := u.loginLimiter.Allow(ctx, c.IP(), bodyPayload.Username)
+	if err != nil {
+		return err
+	}
+	if retryAfter > 0 {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(retryAfter.Seconds())+1))
+		return fiber.NewError(fiber.StatusTooManyRequests, "Too many failed login, try again later")
+	}
+
+	// An unknown username is counted like a wrong password, so the answer doesn't tell it apart
 	user, err := u.repository.GetByUsername(ctx, u.db, bodyPayload.Username)
 	if err != nil {
-		return helper.ChangeErrorIfErrorIsNotFound(err, fiber.NewError(401, "Username or password is incorrect"))
+		return helper.ChangeErrorIfErrorIsNotFound(err, u.failLogin(ctx, c, bodyPayload.Username))
 	}
 
 	if !user.Status {
@@ -102,17 +144,152 @@ func (u *UserHandler) Login(c *fiber.Ctx) (err error) {
 
 	err = u.repository.MatchPassword(ctx, u.db, user, bodyPayload.Password)
 	if err != nil {
-		return fiber.NewError(401, "Username or password is incorrect")
+		return u.failLogin(ctx, c, bodyPayload.Username)
+	}
+	err = u.loginLimiter.Success(ctx, bodyPayload.Username)
+	if err != nil {
+		return err
 	}
 
+	session, refreshToken, err := u.sessionRepository.Create(ctx, u.db, user.IdUser, c.Get(fiber.HeaderUserAgent))
+	if err != nil {
+		return err
+	}
+	user.SessionId = session.IdSession
 	token, err := u.repository.SignJWT(ctx, user)
 	if err != nil {
 		return err
 	}
 
+	// A client preferring text, like curl, only get the access token as before
+	if c.Accepts(fiber.MIMETextPlain, fiber.MIMEApplicationJSON) == fiber.MIMEApplicationJSON {
+		return c.Status(fiber.StatusOK).JSON(userTokens(token, refreshToken))
+	}
 	return c.Status(fiber.StatusOK).SendString(token)
 }
 
+// failLogin count the failed login and return its error, a 429 when it lock the username or IP
+func (u *UserHandler) failLogin(ctx context.Context, c *fiber.Ctx, username string) error {
+	locked, err := u.loginLimiter.Fail(ctx, c.IP(), username)
+	if err != nil {
+		return err
+	}
+	if locked {
+		return fiber.NewError(fiber.StatusTooManyRequests, "Too many failed login, try again later")
+	}
+	return fiber.NewError(401, "Username or password is incorrect")
+}
+
+func userTokens(accessToken string, refreshToken string) entities.UserTokens {
+	return entities.UserTokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    configs.GetConfig().JWT.AccessMinutes * 60,
+	}
+}
+
+// Refresh exchange a refresh token for a new access token and refresh token of the same session, the
+// refresh token is only used once
+func (u *UserHandler) Refresh(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := new(entities.UserRefresh)
+	err = u.validator.ParseBody(c, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	tx, err := u.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	session, refreshToken, err := u.sessionRepository.Refresh(ctx, tx, bodyPayload.RefreshToken)
+	if err != nil {
+		return err
+	}
+
+	// The token carry the current email and role of the user
+	user, err := u.repository.GetById(ctx, tx, session.IdUser)
+	if err != nil {
+		return err
+	}
+	if !user.Status {
+		return fiber.NewError(403, "Your account is inactive. Check your email for activation")
+	}
+	user.SessionId = session.IdSession
+	token, err := u.repository.SignJWT(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+	return c.Status(fiber.StatusOK).JSON(userTokens(token, refreshToken))
+}
+
+// Logout revoke the session of the token, or every session of the user with all=true
+func (u *UserHandler) Logout(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	currentUser, err := u.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	all, err := strconv.ParseBool(c.Query("all", "false"))
+	if err != nil {
+		return fiber.NewError(400, "all must be true or false")
+	}
+	if all {
+		count, err := u.sessionRepository.RevokeAll(ctx, u.db, currentUser.IdUser, "")
+		if err != nil {
+			return err
+		}
+		return c.Status(fiber.StatusOK).SendString(fmt.Sprintf("Logged out of %d session", count))
+	}
+
+	err = u.sessionRepository.Revoke(ctx, u.db, currentUser.IdUser, currentUser.SessionId)
+	if err != nil {
+		return err
+	}
+	return c.Status(fiber.StatusOK).SendString("Logged out")
+}
+
+// GetSessions list the session of the current user, last used first
+func (u *UserHandler) GetSessions(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	currentUser, err := u.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	sessions, err := u.sessionRepository.GetByUser(ctx, u.db, currentUser.IdUser)
+	if err != nil {
+		return err
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].IdSession == currentUser.SessionId
+	}
+	return c.Status(fiber.StatusOK).JSON(sessions)
+}
+
+// RevokeSession revoke a session of the current user, e.g. of a lost phone
+func (u *UserHandler) RevokeSession(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	currentUser, err := u.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	err = u.sessionRepository.Revoke(ctx, u.db, currentUser.IdUser, c.Params("session"))
+	if err != nil {
+		return err
+	}
+	return c.Status(fiber.StatusOK).SendString("Session revoked")
+}
+
 func (u *UserHandler) Activation(c *fiber.Ctx) (err error) {
 	ctx := context.Background()
 	query := new(entities.UserValidate)
@@ -171,6 +348,14 @@ func (u *UserHandler) ForgotPassword(c *fiber.Ctx) (err error) {
 	if err != nil {
 		return err
 	}
+	_, err = u.sessionRepository.RevokeAll(ctx, u.db, user.IdUser, "")
+	if err != nil {
+		return err
+	}
+	err = u.loginLimiter.Unlock(ctx, user.Username)
+	if err != nil {
+		return err
+	}
 
 	// Untuk kepentingan testing, agar test otomatis tidak mengirim email
 	sendEmail, err := strconv.ParseBool(c.Query("sendEmail", "true"))
@@ -242,9 +427,184 @@ func (u *UserHandler) Update(c *fiber.Ctx) (err error) {
 		return err
 	}
 
+	// The other session of the user is logged out, the one changing its own password is kept
+	currentUser, err := u.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+	keepSession := ""
+	if currentUser.IdUser == id {
+		keepSession = currentUser.SessionId
+	}
+	_, err = u.sessionRepository.RevokeAll(ctx, u.db, id, keepSession)
+	if err != nil {
+		return err
+	}
+
 	return c.Status(fiber.StatusOK).SendString("Success change password")
 }
 
+// Profile show the account of the current user with the form to change password, email and preference
+func (u *UserHandler) Profile(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	currentUser, err := u.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	user, err := u.repository.GetById(ctx, u.db, currentUser.IdUser)
+	if err != nil {
+		return err
+	}
+
+	profile := entities.UserProfile{UserRead: user}
+	profile.Theme, err = u.repository.GetTheme(ctx, u.db, user.IdUser)
+	if err != nil {
+		return err
+	}
+
+	profile.Language, err = u.repository.GetLanguage(ctx, u.db, user.IdUser)
+	if err != nil {
+		return err
+	}
+
+	profile.Digest, err = u.repository.GetDigest(ctx, u.db, user.IdUser)
+	if err != nil {
+		return err
+	}
+
+	accept := c.Accepts("application/json", "text/html")
+	switch accept {
+	case "text/html":
+		return c.Render("profile", fiber.Map{
+			"title":   "Profile",
+			"profile": profile,
+		}, "layouts/main")
+	default:
+		return c.Status(fiber.StatusOK).JSON(profile)
+	}
+}
+
+// UpdateEmail change the email of the current user after checking the password.
+// The email is inside the JWT, so a new token is returned like on login
+func (u *UserHandler) UpdateEmail(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := new(entities.UserUpdateEmail)
+	err = u.validator.ParseBody(c, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := u.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	user, err := u.repository.GetById(ctx, u.db, currentUser.IdUser)
+	if err != nil {
+		return err
+	}
+
+	err = u.repository.MatchPassword(ctx, u.db, user, bodyPayload.Password)
+	if err != nil {
+		return fiber.NewError(401, "Password is incorrect")
+	}
+
+	if user.Email == bodyPayload.Email {
+		return fiber.NewError(fiber.StatusBadRequest, "Email is the same as the current email")
+	}
+
+	_, err = u.repository.GetByEmail(ctx, u.db, bodyPayload.Email)
+	if err != nil && !helper.IsErrorNotFound(err) {
+		return err
+	} else if err == nil {
+		return fiber.NewError(fiber.StatusConflict, "Email already used")
+	}
+
+	err = u.repository.UpdateEmail(ctx, u.db, user.IdUser, bodyPayload.Email)
+	if err != nil {
+		return err
+	}
+
+	user.Email = bodyPayload.Email
+	user.SessionId = currentUser.SessionId
+	token, err := u.repository.SignJWT(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString(token)
+}
+
+// UpdateTheme save the HTML UI theme preference of the current user
+func (u *UserHandler) UpdateTheme(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := new(entities.UserTheme)
+	err = u.validator.ParseBody(c, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := u.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	err = u.repository.UpdateTheme(ctx, u.db, currentUser.IdUser, bodyPayload.Theme)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString(fmt.Sprintf("Success change theme to %s", bodyPayload.Theme))
+}
+
+// UpdateLanguage save the language preference of the current user, used over the Accept-Language header
+func (u *UserHandler) UpdateLanguage(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := new(entities.UserLanguage)
+	err = u.validator.ParseBody(c, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := u.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	err = u.repository.UpdateLanguage(ctx, u.db, currentUser.IdUser, bodyPayload.Language)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString(fmt.Sprintf("Success change language to %s", bodyPayload.Language))
+}
+
+// UpdateDigest opt the current user in or out of the daily email digest of their sensor
+func (u *UserHandler) UpdateDigest(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := new(entities.UserDigestUpdate)
+	err = u.validator.ParseBody(c, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := u.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	err = u.repository.UpdateDigest(ctx, u.db, currentUser.IdUser, *bodyPayload.Digest)
+	if err != nil {
+		return err
+	}
+
+	if *bodyPayload.Digest {
+		return c.Status(fiber.StatusOK).SendString("Success subscribe to the daily digest")
+	}
+	return c.Status(fiber.StatusOK).SendString("Success unsubscribe from the daily digest")
+}
+
 func (u *UserHandler) Delete(c *fiber.Ctx) (err error) {
 	ctx := context.Background()
 	id, err := u.validator.ParseIdFromUrlParameter(c)
@@ -252,12 +612,41 @@ func (u *UserHandler) Delete(c *fiber.Ctx) (err error) {
 		return err
 	}
 
-	_, err = u.repository.GetById(ctx, u.db, id)
+	user, err := u.repository.GetById(ctx, u.db, id)
+	if err != nil {
+		return err
+	}
+
+	dryRun, err := u.validator.ParseDryRun(c)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		impact, err := u.repository.GetDeleteImpact(ctx, u.db, id)
+		if err != nil {
+			return err
+		}
+		return c.Status(fiber.StatusOK).JSON(impact)
+	}
+
+	tx, err := u.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// The node and sensor of the user are deleted with it without their own event
+	err = u.webhookRepository.Enqueue(ctx, tx, id, entities.EventUserDeleted, webhookUser(user))
+	if err != nil {
+		return err
+	}
+
+	err = u.repository.Delete(ctx, tx, id)
 	if err != nil {
 		return err
 	}
 
-	err = u.repository.Delete(ctx, u.db, id)
+	err = tx.Commit(ctx)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type WeatherHandler struct {
+	db                 *pgxpool.Pool
+	repository         *repositories.WeatherRepository
+	nodeRepository     *repositories.NodeRepository
+	hardwareRepository *repositories.HardwareRepository
+	sensorRepository   *repositories.SensorRepository
+	historyRepository  *repositories.HistoryRepository
+	webhookRepository  *repositories.WebhookRepository
+	validator          *dependencies.Validator
+}
+
+func NewWeatherHandler(db *pgxpool.Pool, weatherRepository *repositories.WeatherRepository, nodeRepository *repositories.NodeRepository, hardwareRepository *repositories.HardwareRepository, sensorRepository *repositories.SensorRepository, historyRepository *repositories.HistoryRepository, webhookRepository *repositories.WebhookRepository, validator *dependencies.Validator) (WeatherHandler, error) {
+	return WeatherHandler{
+		db:                 db,
+		repository:         weatherRepository,
+		nodeRepository:     nodeRepository,
+		hardwareRepository: hardwareRepository,
+		sensorRepository:   sensorRepository,
+		historyRepository:  historyRepository,
+		webhookRepository:  webhookRepository,
+		validator:          validator,
+	}, nil
+}
+
+// GetItems return the weather variable fetched for the node with their virtual sensor
+func (h *WeatherHandler) GetItems(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	node, err := getOwnNode(ctx, c, h.validator, h.db, h.nodeRepository, "You can’t see another user’s node")
+	if err != nil {
+		return err
+	}
+
+	items, err := h.repository.GetByNode(ctx, h.db, node.IdNode)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(items)
+}
+
+// UpdateItems replace the weather variable fetched for the node, creating the virtual sensor of the
+// new variable
+func (h *WeatherHandler) UpdateItems(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := &entities.NodeWeatherUpdate{}
+	err = h.validator.ParseBody(c, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	node, err := getOwnNode(ctx, c, h.validator, h.db, h.nodeRepository, "You can’t edit another user’s node")
+	if err != nil {
+		return err
+	}
+	if len(bodyPayload.Variables) > 0 {
+		_, _, ok := entities.ParseNodeLocation(node.Location)
+		if !ok {
+			return fiber.NewError(400, `The node location must be "latitude,longitude" to fetch its weather`)
+		}
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = h.repository.DeleteOther(ctx, tx, node.IdNode, bodyPayload.Variables)
+	if err != nil {
+		return err
+	}
+	items, err := h.repository.GetByNode(ctx, tx, node.IdNode)
+	if err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for _, item := range items {
+		existing[item.Variable] = true
+	}
+
+	for _, variable := range bodyPayload.Variables {
+		if existing[variable] {
+			continue
+		}
+		if bodyPayload.IdHardware == 0 {
+			return fiber.NewError(400, "The id_hardware of the virtual sensor is needed to add a variable")
+		}
+		hardware, err := h.hardwareRepository.GetById(ctx, tx, bodyPayload.IdHardware)
+		if err != nil {
+			return err
+		}
+		if strings.ToLower(hardware.Type) != "sensor" {
+			return fiber.NewError(400, "Hardware type not match, type should be sensor")
+		}
+
+		sensor, err := h.sensorRepository.Create(ctx, tx, &entities.SensorCreate{
+			Name:       "Weather " + strings.ReplaceAll(variable, "_", " "),
+			Unit:       entities.WeatherUnits[variable],
+			IdNode:     node.IdNode,
+			IdHardware: bodyPayload.IdHardware,
+		})
+		if err != nil {
+			return err
+		}
+		err = h.historyRepository.Record(ctx, tx, entities.EntitySensor, sensor.IdSensor, entities.RevisionCreate, currentUser.IdUser, nil, sensor)
+		if err != nil {
+			return err
+		}
+		err = h.webhookRepository.Enqueue(ctx, tx, node.IdUser, entities.EventSensorCreated, sensor)
+		if err != nil {
+			return err
+		}
+
+		err = h.repository.Create(ctx, tx, node.IdNode, variable, sensor.IdSensor)
+		if err != nil {
+			return err
+		}
+		existing[variable] = true
+	}
+
+	items, err = h.repository.GetByNode(ctx, tx, node.IdNode)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(items)
+}
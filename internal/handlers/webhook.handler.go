@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Number of delivery returned for a webhook
+const webhookDeliveryLimit = 100
+
+type WebhookHandler struct {
+	db         *pgxpool.Pool
+	repository *repositories.WebhookRepository
+	validator  *dependencies.Validator
+}
+
+func NewWebhookHandler(db *pgxpool.Pool, webhookRepository *repositories.WebhookRepository, validator *dependencies.Validator) (WebhookHandler, error) {
+	return WebhookHandler{
+		db:         db,
+		repository: webhookRepository,
+		validator:  validator,
+	}, nil
+}
+
+// validatePayload check the url scheme and the event name, the user events are only sent to
+// the webhook of an admin
+func (h *WebhookHandler) validatePayload(payload *entities.WebhookCreate, currentUser *entities.UserRead) error {
+	target, err := url.Parse(payload.Url)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return fiber.NewError(400, "url must be an http or https url")
+	}
+
+	for _, event := range payload.Events {
+		known := event == entities.EventAll
+		for _, webhookEvent := range entities.WebhookEvents {
+			known = known || event == webhookEvent
+		}
+		if !known {
+			return fiber.NewError(400, fmt.Sprintf("Unknown event %s", event))
+		}
+		if !currentUser.IsAdmin && event == entities.EventUserRegistered {
+			return fiber.NewError(403, "Only admin can subscribe to user.registered")
+		}
+	}
+	return nil
+}
+
+// getOwnWebhook return the webhook when it belong to the current user
+func (h *WebhookHandler) getOwnWebhook(ctx context.Context, c *fiber.Ctx) (webhook entities.Webhook, err error) {
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return webhook, err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return webhook, err
+	}
+
+	webhook, err = h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return webhook, err
+	}
+
+	if webhook.IdUser != currentUser.IdUser {
+		return webhook, fiber.NewError(403, "You can’t access another user’s webhook")
+	}
+	return webhook, nil
+}
+
+func (h *WebhookHandler) GetAll(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	webhooks, err := h.repository.GetAll(ctx, h.db, currentUser.IdUser)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(webhooks)
+}
+
+func (h *WebhookHandler) GetById(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	webhook, err := h.getOwnWebhook(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(webhook)
+}
+
+// Create return the webhook with its secret, it is not shown again
+func (h *WebhookHandler) Create(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := entities.WebhookCreate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	err = h.validatePayload(&bodyPayload, &currentUser)
+	if err != nil {
+		return err
+	}
+
+	webhook, err := h.repository.Create(ctx, h.db, currentUser.IdUser, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(webhook)
+}
+
+func (h *WebhookHandler) Update(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := entities.WebhookCreate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	webhook, err := h.getOwnWebhook(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	err = h.validatePayload(&bodyPayload, &currentUser)
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.Update(ctx, h.db, webhook.IdWebhook, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success edit webhook")
+}
+
+func (h *WebhookHandler) Delete(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	webhook, err := h.getOwnWebhook(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.Delete(ctx, h.db, webhook.IdWebhook)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString(fmt.Sprintf("Success delete webhook, id: %d", webhook.IdWebhook))
+}
+
+// GetDeliveries return the latest delivery of the webhook with their status, newest first
+func (h *WebhookHandler) GetDeliveries(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	webhook, err := h.getOwnWebhook(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	deliveries, err := h.repository.GetDeliveries(ctx, h.db, webhook.IdWebhook, webhookDeliveryLimit)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(deliveries)
+}
+
+// Redeliver queue a delivery again, e.g. after the receiver was down longer than the retry
+func (h *WebhookHandler) Redeliver(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	webhook, err := h.getOwnWebhook(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	idDelivery, err := h.validator.ParseIntFromUrlParameter(c, "delivery")
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.Redeliver(ctx, h.db, webhook.IdWebhook, int64(idDelivery))
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success queue webhook delivery")
+}
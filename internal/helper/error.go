@@ -7,7 +7,11 @@ import (
 	"net/http"
 	"os"
 	"runtime/debug"
+	"sync"
+	"time"
 
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/i18n"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -41,6 +45,38 @@ func ChangeErrorIfErrorIsNotFound(err error, newError error) error {
 	return err
 }
 
+const recentErrorLimit = 50
+
+var recentErrorMutex sync.Mutex
+var recentErrors []entities.RecentError
+
+// recordError keep the last server error of this instance for the admin statistics page
+func recordError(c *fiber.Ctx, code int, message string) {
+	recentErrorMutex.Lock()
+	defer recentErrorMutex.Unlock()
+	recentErrors = append(recentErrors, entities.RecentError{
+		Time:    time.Now().UTC(),
+		Method:  c.Method(),
+		Path:    c.Path(),
+		Code:    code,
+		Message: message,
+	})
+	if len(recentErrors) > recentErrorLimit {
+		recentErrors = recentErrors[len(recentErrors)-recentErrorLimit:]
+	}
+}
+
+// RecentErrors return the last server error (status 5xx) of this instance, newest first
+func RecentErrors() []entities.RecentError {
+	recentErrorMutex.Lock()
+	defer recentErrorMutex.Unlock()
+	result := make([]entities.RecentError, len(recentErrors))
+	for i, e := range recentErrors {
+		result[len(recentErrors)-1-i] = e
+	}
+	return result
+}
+
 func FiberErrorHandler(c *fiber.Ctx, err error) error {
 	// Status code defaults to 500
 	code := fiber.StatusInternalServerError
@@ -53,14 +89,22 @@ func FiberErrorHandler(c *fiber.Ctx, err error) error {
 		log.Printf("[UNHANDLED ERROR] %v", err)
 		HandleStackTrace(e)
 	}
+	if code >= 500 {
+		recordError(c, code, err.Error())
+	}
 	// Set Content-Type: text/plain; charset=utf-8
 	c.Set(fiber.HeaderContentType, fiber.MIMETextPlainCharsetUTF8)
 
+	locale, ok := c.Locals("locale").(string)
+	if !ok {
+		locale = i18n.DefaultLocale
+	}
+	message := i18n.TranslateError(locale, err.Error())
+
 	accept := c.Accepts("application/json", "text/html")
 
 	switch accept {
 	case "text/html":
-		message := err.Error()
 		showLogin := false
 		if code == 401 || code == 403 {
 			showLogin = true
@@ -73,7 +117,7 @@ func FiberErrorHandler(c *fiber.Ctx, err error) error {
 		}, "layouts/main")
 	default:
 		// Return status code with error message
-		return c.Status(code).SendString(err.Error())
+		return c.Status(code).SendString(message)
 	}
 
 }
@@ -13,18 +13,34 @@ import (
 	"github.com/golang-jwt/jwt/v4"
 )
 
+// sessionChecker return an error when the session of a token is revoked or expired, it is set at
+// startup with SetSessionChecker
+var sessionChecker func(sessionId string) error
+
+// SetSessionChecker set the check of the session every token is validated with
+func SetSessionChecker(checker func(sessionId string) error) {
+	sessionChecker = checker
+}
+
+// SignUserToken sign the access token of the session of the user, it expire after jwt.accessMinutes
+// when it is set
 func SignUserToken(user entities.UserRead) (string, error) {
 	config := configs.GetConfig()
-	// Create a new token object, specifying signing method and the claims
-	// you would like it to contain.
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	claims := jwt.MapClaims{
 		"idUser":   user.IdUser,
 		"email":    user.Email,
 		"username": user.Username,
 		"status":   user.Status,
 		"isAdmin":  user.IsAdmin,
+		"sid":      user.SessionId,
 		"iat":      time.Now().Unix(),
-	})
+	}
+	if config.JWT.AccessMinutes > 0 {
+		claims["exp"] = time.Now().Add(time.Duration(config.JWT.AccessMinutes) * time.Minute).Unix()
+	}
+	// Create a new token object, specifying signing method and the claims
+	// you would like it to contain.
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
 	// Sign and get the complete encoded token as a string using the secret
 	tokenString, err := token.SignedString([]byte(config.JWT.SecretKey))
@@ -47,16 +63,28 @@ func ValidateUserToken(tokenString string) (user entities.UserRead, err error) {
 		// hmacSampleSecret is a []byte containing your secret, e.g. []byte("my_secret_key")
 		return []byte(config.JWT.SecretKey), nil
 	})
-	if err != nil {
-		return user, err
+	// A malformed token is parsed as nil
+	var claims jwt.MapClaims
+	if token != nil {
+		claims, _ = token.Claims.(jwt.MapClaims)
 	}
-
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
+	if claims != nil && err == nil && token.Valid {
 		user.IdUser = int(claims["idUser"].(float64))
 		user.Email = claims["email"].(string)
 		user.Username = claims["username"].(string)
 		user.Status = claims["status"].(bool)
 		user.IsAdmin = claims["isAdmin"].(bool)
+		// A token signed before the session has none and can't be revoked, so it is refused
+		user.SessionId, _ = claims["sid"].(string)
+		if user.SessionId == "" {
+			return user, fiber.NewError(401, "Token has no session, log in again")
+		}
+		if sessionChecker != nil {
+			err = sessionChecker(user.SessionId)
+			if err != nil {
+				return user, err
+			}
+		}
 		return user, nil
 	} else if errors.Is(err, jwt.ErrTokenMalformed) {
 		return user, fiber.NewError(401, "Token is malformed")
@@ -67,11 +95,12 @@ func ValidateUserToken(tokenString string) (user entities.UserRead, err error) {
 	}
 }
 
-func ValidateUserCredentical(c *fiber.Ctx) (user entities.UserRead, err error) {
+// requestToken return the bearer token of the authorization header, or of the cookie of the web UI
+func requestToken(c *fiber.Ctx) (string, error) {
 	authorizationCookies := c.Cookies("authorization", "")
-	authorizationCookies, err = url.QueryUnescape(authorizationCookies)
+	authorizationCookies, err := url.QueryUnescape(authorizationCookies)
 	if err != nil {
-		return user, err
+		return "", err
 	}
 
 	headers := c.GetReqHeaders()
@@ -80,7 +109,7 @@ func ValidateUserCredentical(c *fiber.Ctx) (user entities.UserRead, err error) {
 	authorization := ""
 
 	if !haveAuthorizationHeader && authorizationCookies == "" {
-		return user, fiber.NewError(401, "Authorization not present")
+		return "", fiber.NewError(401, "Authorization not present")
 	}
 
 	if haveAuthorizationHeader {
@@ -91,11 +120,18 @@ func ValidateUserCredentical(c *fiber.Ctx) (user entities.UserRead, err error) {
 
 	authorizationSplit := strings.Split(authorization, " ")
 	authorizationType := authorizationSplit[0]
-	if authorizationType != "Bearer" {
-		return user, fiber.NewError(401, "Authorization type is not Bearer, please use 'Bearer {token}' format on your authorization header")
+	if authorizationType != "Bearer" || len(authorizationSplit) < 2 {
+		return "", fiber.NewError(401, "Authorization type is not Bearer, please use 'Bearer {token}' format on your authorization header")
 	}
 
-	token := authorizationSplit[1]
+	return authorizationSplit[1], nil
+}
+
+func ValidateUserCredentical(c *fiber.Ctx) (user entities.UserRead, err error) {
+	token, err := requestToken(c)
+	if err != nil {
+		return user, err
+	}
 
 	user, err = ValidateUserToken(token)
 	if err != nil {
@@ -104,3 +140,28 @@ func ValidateUserCredentical(c *fiber.Ctx) (user entities.UserRead, err error) {
 
 	return user, nil
 }
+
+// RequestUserId return the user of the token of the request without checking its session, for a
+// cheap identification before the route authenticate it. ok is false without a valid token
+func RequestUserId(c *fiber.Ctx) (id int, ok bool) {
+	tokenString, err := requestToken(c)
+	if err != nil {
+		return 0, false
+	}
+	secretKey := []byte(configs.GetConfig().JWT.SecretKey)
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+		return secretKey, nil
+	})
+	if err != nil || !token.Valid {
+		return 0, false
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return 0, false
+	}
+	idUser, ok := claims["idUser"].(float64)
+	return int(idUser), ok
+}
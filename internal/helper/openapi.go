@@ -0,0 +1,387 @@
+package helper
+
+import (
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// OpenAPIEntity is the entity a route read and answer, given as a zero value e.g. entities.NodeCreate{}
+// or []entities.Node{}. Status is the success status of the response, 200 when it is 0
+type OpenAPIEntity struct {
+	Request  interface{}
+	Query    interface{}
+	Response interface{}
+	Status   int
+}
+
+// BuildOpenAPISpec reflect the registered fiber routes into an OpenAPI 3 document.
+// Route with more than one handler is assumed to be behind the authentication middleware.
+// The entity of a route is keyed by its method and OpenAPI path, e.g. "POST /node", its schema is
+// reflected from the json and validate tag
+func BuildOpenAPISpec(routes []fiber.Route, title string, version string, routeEntities map[string]OpenAPIEntity) fiber.Map {
+	paths := fiber.Map{}
+	tags := map[string]bool{}
+	schemas := fiber.Map{}
+
+	for _, route := range routes {
+		if route.Method == fiber.MethodHead || route.Method == fiber.MethodConnect || route.Method == fiber.MethodTrace {
+			continue
+		}
+		if strings.HasPrefix(route.Path, "/static") || len(route.Handlers) == 0 {
+			continue
+		}
+
+		openAPIPath, parameters := openAPIPathAndParameters(route)
+		tag := openAPITag(route.Path)
+		tags[tag] = true
+
+		handlerName := openAPIHandlerName(route.Handlers[len(route.Handlers)-1])
+		operation := fiber.Map{
+			"tags":        []string{tag},
+			"summary":     openAPISummary(handlerName),
+			"operationId": openAPIOperationId(route.Method, openAPIPath),
+			"responses": fiber.Map{
+				"default": fiber.Map{"description": "Plain text message or JSON depending on the endpoint"},
+			},
+		}
+		if entity, ok := routeEntities[route.Method+" "+openAPIPath]; ok {
+			openAPIOperationEntity(operation, &parameters, entity, schemas)
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+		if len(route.Handlers) > 1 {
+			operation["security"] = []fiber.Map{{"bearerAuth": []string{}}}
+		}
+
+		pathItem, ok := paths[openAPIPath].(fiber.Map)
+		if !ok {
+			pathItem = fiber.Map{}
+			paths[openAPIPath] = pathItem
+		}
+		pathItem[strings.ToLower(route.Method)] = operation
+	}
+
+	tagList := []fiber.Map{}
+	for tag := range tags {
+		tagList = append(tagList, fiber.Map{"name": tag})
+	}
+	sort.Slice(tagList, func(i, j int) bool {
+		return tagList[i]["name"].(string) < tagList[j]["name"].(string)
+	})
+
+	return fiber.Map{
+		"openapi": "3.0.3",
+		"info": fiber.Map{
+			"title":   title,
+			"version": version,
+		},
+		"tags":  tagList,
+		"paths": paths,
+		"components": fiber.Map{
+			"schemas": schemas,
+			"securitySchemes": fiber.Map{
+				"bearerAuth": fiber.Map{
+					"type":         "http",
+					"scheme":       "bearer",
+					"bearerFormat": "JWT",
+				},
+			},
+		},
+	}
+}
+
+// Convert fiber path parameter (/sensor/:id) to OpenAPI path parameter (/sensor/{id})
+func openAPIPathAndParameters(route fiber.Route) (string, []fiber.Map) {
+	parameters := []fiber.Map{}
+	segments := strings.Split(route.Path, "/")
+	for i, segment := range segments {
+		if !strings.HasPrefix(segment, ":") {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(segment, ":"), "?")
+		segments[i] = "{" + name + "}"
+		parameters = append(parameters, fiber.Map{
+			"name":     name,
+			"in":       "path",
+			"required": true,
+			"schema":   fiber.Map{"type": "string"},
+		})
+	}
+
+	path := strings.Join(segments, "/")
+	if len(path) > 1 {
+		path = strings.TrimSuffix(path, "/")
+	}
+	return path, parameters
+}
+
+// Generate unique operation id from method and path, e.g. GET /sensor/{id} -> getSensorId
+func openAPIOperationId(method string, path string) string {
+	var sb strings.Builder
+	sb.WriteString(strings.ToLower(method))
+	for _, segment := range strings.Split(path, "/") {
+		segment = strings.Trim(segment, "{}")
+		segment = strings.ReplaceAll(segment, "-", "")
+		if segment == "" {
+			continue
+		}
+		sb.WriteString(strings.ToUpper(segment[:1]) + segment[1:])
+	}
+	return sb.String()
+}
+
+func openAPITag(path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if segments[0] == "" {
+		return "health"
+	}
+	return segments[0]
+}
+
+// Get the handler name from function pointer, e.g. handlers.(*SensorHandler).GetAll-fm
+func openAPIHandlerName(handler fiber.Handler) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(handler).Pointer())
+	if fn == nil {
+		return ""
+	}
+
+	name := fn.Name()
+	name = name[strings.LastIndex(name, "/")+1:]
+	name = strings.TrimSuffix(name, "-fm")
+	name = strings.ReplaceAll(name, "(*", "")
+	name = strings.ReplaceAll(name, ")", "")
+	return name
+}
+
+// Split the camel case method name into a sentence, e.g. SensorHandler.GetById -> Get by id
+func openAPISummary(handlerName string) string {
+	method := handlerName[strings.LastIndex(handlerName, ".")+1:]
+	if method == "" || strings.HasPrefix(method, "func") {
+		return ""
+	}
+
+	var sb strings.Builder
+	for i, r := range method {
+		if i > 0 && r >= 'A' && r <= 'Z' {
+			sb.WriteRune(' ')
+			sb.WriteRune(r + ('a' - 'A'))
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// openAPIOperationEntity add the request body, query parameter and response of the entity to the operation
+func openAPIOperationEntity(operation fiber.Map, parameters *[]fiber.Map, entity OpenAPIEntity, schemas fiber.Map) {
+	if entity.Request != nil {
+		operation["requestBody"] = fiber.Map{
+			"required": true,
+			"content": fiber.Map{
+				"application/json": fiber.Map{"schema": openAPISchema(reflect.TypeOf(entity.Request), schemas)},
+			},
+		}
+	}
+	if entity.Query != nil {
+		*parameters = append(*parameters, openAPIQueryParameters(reflect.TypeOf(entity.Query), schemas)...)
+	}
+	if entity.Response != nil {
+		status := entity.Status
+		if status == 0 {
+			status = fiber.StatusOK
+		}
+		responses := operation["responses"].(fiber.Map)
+		responses[strconv.Itoa(status)] = fiber.Map{
+			"description": "Success",
+			"content": fiber.Map{
+				"application/json": fiber.Map{"schema": openAPISchema(reflect.TypeOf(entity.Response), schemas)},
+			},
+		}
+	}
+}
+
+// openAPIQueryParameters is the field of the query struct with a query tag
+func openAPIQueryParameters(t reflect.Type, schemas fiber.Map) []fiber.Map {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	parameters := []fiber.Map{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			parameters = append(parameters, openAPIQueryParameters(field.Type, schemas)...)
+			continue
+		}
+		name := strings.SplitN(field.Tag.Get("query"), ",", 2)[0]
+		if name == "" || name == "-" || !field.IsExported() {
+			continue
+		}
+		schema, required := openAPIFieldSchema(field, schemas)
+		parameters = append(parameters, fiber.Map{
+			"name":     name,
+			"in":       "query",
+			"required": required,
+			"schema":   schema,
+		})
+	}
+	return parameters
+}
+
+// openAPISchema reflect the type, a named struct is added to schemas and referenced
+func openAPISchema(t reflect.Type, schemas fiber.Map) fiber.Map {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		return fiber.Map{"type": "string", "format": "date-time"}
+	case t == reflect.TypeOf(time.Duration(0)):
+		return fiber.Map{"type": "integer", "description": "Nanosecond"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return fiber.Map{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return fiber.Map{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return fiber.Map{"type": "number"}
+	case reflect.String:
+		return fiber.Map{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return fiber.Map{"type": "string", "format": "byte"}
+		}
+		return fiber.Map{"type": "array", "items": openAPISchema(t.Elem(), schemas)}
+	case reflect.Map:
+		return fiber.Map{"type": "object", "additionalProperties": openAPISchema(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return openAPIStructSchema(t, schemas)
+		}
+		if _, ok := schemas[t.Name()]; !ok {
+			// Placeholder so a recursive struct reference itself instead of looping
+			schemas[t.Name()] = fiber.Map{}
+			schemas[t.Name()] = openAPIStructSchema(t, schemas)
+		}
+		return fiber.Map{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return fiber.Map{}
+}
+
+// openAPIStructSchema is the object of the json field, an embedded struct field is inlined like encoding/json
+func openAPIStructSchema(t reflect.Type, schemas fiber.Map) fiber.Map {
+	properties := fiber.Map{}
+	required := []string{}
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+			if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+				addFields(field.Type)
+				continue
+			}
+			if name == "-" || !field.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			schema, isRequired := openAPIFieldSchema(field, schemas)
+			properties[name] = schema
+			if isRequired {
+				required = append(required, name)
+			}
+		}
+	}
+	addFields(t)
+
+	schema := fiber.Map{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// openAPIFieldSchema is the schema of the field with the constraint of its validate tag, the
+// constraint after dive is for the element and is left out
+func openAPIFieldSchema(field reflect.StructField, schemas fiber.Map) (schema fiber.Map, required bool) {
+	schema = openAPISchema(field.Type, schemas)
+	if _, isRef := schema["$ref"]; isRef {
+		return schema, strings.Contains(field.Tag.Get("validate"), "required")
+	}
+	schema = copyMap(schema)
+
+	kind := field.Type.Kind()
+	if kind == reflect.Pointer {
+		kind = field.Type.Elem().Kind()
+		schema["nullable"] = true
+	}
+	for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+		key, value, _ := strings.Cut(rule, "=")
+		switch key {
+		case "dive":
+			return schema, required
+		case "required":
+			required = true
+		case "email":
+			schema["format"] = "email"
+		case "url":
+			schema["format"] = "uri"
+		case "oneof":
+			schema["enum"] = openAPIEnum(value, kind)
+		case "min", "max":
+			number, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			switch kind {
+			case reflect.String:
+				schema[key+"Length"] = int(number)
+			case reflect.Slice, reflect.Array, reflect.Map:
+				schema[key+"Items"] = int(number)
+			default:
+				schema[map[string]string{"min": "minimum", "max": "maximum"}[key]] = number
+			}
+		}
+	}
+	return schema, required
+}
+
+// openAPIEnum split the oneof value, a quoted value may contain space, e.g. 'single-board computer' sensor
+func openAPIEnum(value string, kind reflect.Kind) []interface{} {
+	enum := []interface{}{}
+	for value != "" {
+		var item string
+		if strings.HasPrefix(value, "'") {
+			item, value, _ = strings.Cut(value[1:], "'")
+			value = strings.TrimSpace(value)
+		} else {
+			item, value, _ = strings.Cut(value, " ")
+			value = strings.TrimSpace(value)
+		}
+		if number, err := strconv.ParseFloat(item, 64); err == nil && kind != reflect.String {
+			enum = append(enum, number)
+			continue
+		}
+		enum = append(enum, item)
+	}
+	return enum
+}
+
+func copyMap(m fiber.Map) fiber.Map {
+	copied := fiber.Map{}
+	for key, value := range m {
+		copied[key] = value
+	}
+	return copied
+}
@@ -1,6 +1,10 @@
 package helper
 
-import "github.com/gofiber/fiber/v2"
+import (
+	"net/url"
+
+	"github.com/gofiber/fiber/v2"
+)
 
 type ErrorResponse struct {
 	message string
@@ -19,3 +23,35 @@ func ResponseWithErrorMessage(c *fiber.Ctx, msg string) error {
 	}
 	return c.Status(fiber.StatusInternalServerError).JSON(errorResponse)
 }
+
+// PageFilter return the url query of the request without page and size, ending with "&"
+// when it isn't empty, so the pagination link can append the page to it
+func PageFilter(c *fiber.Ctx) string {
+	values := url.Values{}
+	c.Request().URI().QueryArgs().VisitAll(func(key []byte, value []byte) {
+		name := string(key)
+		if name == "page" || name == "size" || len(value) == 0 {
+			return
+		}
+		values.Add(name, string(value))
+	})
+	if len(values) == 0 {
+		return ""
+	}
+	return values.Encode() + "&"
+}
+
+// IsHtmxTarget is true when htmx request the content of the element with the id,
+// the handler then render only the fragment instead of the whole page
+func IsHtmxTarget(c *fiber.Ctx, id string) bool {
+	return c.Get("HX-Request") == "true" && c.Get("HX-Target") == id
+}
+
+// RenderPage render the view in the main layout, or only the fragment when htmx request the element with the id
+func RenderPage(c *fiber.Ctx, view string, fragment string, id string, bind fiber.Map) error {
+	c.Vary("HX-Request", "HX-Target")
+	if IsHtmxTarget(c, id) {
+		return c.Render(fragment, bind)
+	}
+	return c.Render(view, bind, "layouts/main")
+}
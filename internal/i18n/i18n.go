@@ -0,0 +1,135 @@
+// Package i18n translate the rendered templates and the API error messages.
+// Each locale has a JSON catalog in locales/, "messages" is keyed by a dotted name used
+// from the templates and "errors" is keyed by the english format string of the error,
+// so the code keep returning english error and the translation happen at the error handler.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const DefaultLocale = "en"
+
+// Locales is the supported locale, the first one is used when nothing match
+var Locales = []string{"en", "id"}
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+type catalog struct {
+	Messages map[string]string `json:"messages"`
+	Errors   map[string]string `json:"errors"`
+}
+
+type errorPattern struct {
+	format      string
+	pattern     *regexp.Regexp
+	translation string
+}
+
+var catalogs = map[string]catalog{}
+var errorPatterns = map[string][]errorPattern{}
+
+var verbPattern = regexp.MustCompile(`%(\[\d+\])?[dsvq]`)
+
+func init() {
+	for _, locale := range Locales {
+		content, err := localeFiles.ReadFile(fmt.Sprintf("locales/%s.json", locale))
+		if err != nil {
+			log.Fatalf("Error reading locale %s, %s", locale, err.Error())
+		}
+
+		var c catalog
+		err = json.Unmarshal(content, &c)
+		if err != nil {
+			log.Fatalf("Error parsing locale %s, %s", locale, err.Error())
+		}
+		catalogs[locale] = c
+		errorPatterns[locale] = compileErrorPatterns(c.Errors)
+	}
+}
+
+// compileErrorPatterns turn each error format into a regex where the verb match any value,
+// the longest format is tried first because it is the most specific
+func compileErrorPatterns(errors map[string]string) []errorPattern {
+	patterns := []errorPattern{}
+	for format, translation := range errors {
+		expression := verbPattern.ReplaceAllStringFunc(regexp.QuoteMeta(format), func(verb string) string {
+			if strings.HasSuffix(verb, "d") {
+				return `(-?\d+)`
+			}
+			return `(.*)`
+		})
+		patterns = append(patterns, errorPattern{
+			format:      format,
+			pattern:     regexp.MustCompile("^" + expression + "$"),
+			translation: verbPattern.ReplaceAllString(translation, "%${1}s"),
+		})
+	}
+
+	sort.Slice(patterns, func(i, j int) bool {
+		return len(patterns[i].format) > len(patterns[j].format)
+	})
+	return patterns
+}
+
+func IsSupported(locale string) bool {
+	_, ok := catalogs[locale]
+	return ok
+}
+
+// Negotiate pick the first supported language of an Accept-Language header, "id-ID" match "id"
+func Negotiate(acceptLanguage string) string {
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		language := strings.ToLower(strings.SplitN(tag, "-", 2)[0])
+		if IsSupported(language) {
+			return language
+		}
+	}
+	return DefaultLocale
+}
+
+// T return the message of the key, falling back to the default locale then the key itself
+func T(locale string, key string) string {
+	if message, ok := catalogs[locale].Messages[key]; ok {
+		return message
+	}
+	if message, ok := catalogs[DefaultLocale].Messages[key]; ok {
+		return message
+	}
+	return key
+}
+
+// TranslateError translate an english error message line by line, a line without
+// matching format in the catalog is kept as is
+func TranslateError(locale string, message string) string {
+	patterns := errorPatterns[locale]
+	if len(patterns) == 0 {
+		return message
+	}
+
+	lines := strings.Split(message, "\n")
+	for i, line := range lines {
+		for _, p := range patterns {
+			matches := p.pattern.FindStringSubmatch(line)
+			if matches == nil {
+				continue
+			}
+
+			values := []interface{}{}
+			for _, value := range matches[1:] {
+				values = append(values, value)
+			}
+			lines[i] = fmt.Sprintf(p.translation, values...)
+			break
+		}
+	}
+	return strings.Join(lines, "\n")
+}
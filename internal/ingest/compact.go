@@ -0,0 +1,42 @@
+package ingest
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Longest body of the compact profile, a few value of a multi-channel sensor fit easily
+const MaxCompactSize = 256
+
+// ParseCompact read "23.5" for a single value sensor or "x=1.2,y=-0.4,z=9.8" for the channels of a
+// multi-channel sensor
+func ParseCompact(idSensor int, body string) ([]entities.ChannelCreate, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "The body is empty")
+	}
+	if len(body) > MaxCompactSize {
+		return nil, fiber.NewError(fiber.StatusRequestEntityTooLarge, "The body is too large")
+	}
+
+	channels := []entities.ChannelCreate{}
+	for _, part := range strings.FieldsFunc(body, func(r rune) bool { return r == ',' || r == '\n' }) {
+		name, value, hasName := strings.Cut(strings.TrimSpace(part), "=")
+		if !hasName {
+			value, name = name, ""
+		}
+		if len(name) > 32 {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "The name is too long")
+		}
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || math.IsNaN(parsed) || math.IsInf(parsed, 0) {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "The value is not a number")
+		}
+		channels = append(channels, entities.ChannelCreate{IdSensor: idSensor, Name: strings.TrimSpace(name), Value: parsed})
+	}
+	return channels, nil
+}
@@ -0,0 +1,304 @@
+// Package ingest store the channel received by any protocol the same way as POST /channel
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/bridge"
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/dependencies/notifier"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/metering"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/dafaath/iot-server/internal/script"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ThrottledError is returned when the channel come sooner than the minimum interval of a rejecting
+// throttle, it unwrap to a 429 fiber error
+type ThrottledError struct {
+	// Wait before the sensor accept a channel again
+	RetryAfter time.Duration
+	err        *fiber.Error
+}
+
+func (e *ThrottledError) Error() string {
+	return e.err.Error()
+}
+
+func (e *ThrottledError) Unwrap() error {
+	return e.err
+}
+
+// A node is marked seen at most once per seenInterval by each sensor, so every channel doesn't
+// write the node
+const seenInterval = time.Minute
+
+// ErrDropped is returned when a drop step of the sensor transformation matched the channel, or an
+// ingest script called drop(). It is a 200 fiber error since the sender did nothing wrong
+var ErrDropped = fiber.NewError(fiber.StatusOK, "The channel is dropped by the sensor transformation or script")
+
+// Pipeline run the received channel through the transformation, ingest script, throttle, validation
+// rule and filter of its sensor, then store it, count it, publish it to the live viewer and the
+// outbound bridge and run the alert script and rule
+type Pipeline struct {
+	db                     *pgxpool.Pool
+	channelRepository      *repositories.ChannelRepository
+	validationRepository   *repositories.ValidationRepository
+	filterRepository       *repositories.FilterRepository
+	throttleRepository     *repositories.ThrottleRepository
+	transformRepository    *repositories.TransformRepository
+	hooks                  *script.Hooks
+	alertRepository        *repositories.AlertRepository
+	notificationRepository *repositories.NotificationRepository
+	notifier               *notifier.Notifier
+	realtimeHub            *dependencies.RealtimeHub
+	bridge                 *bridge.Bridge
+	meter                  *metering.Meter
+	ingestMeter            *metering.IngestMeter
+	nodeRepository         *repositories.NodeRepository
+	// Last time the node of each sensor was marked seen
+	seen      map[int]time.Time
+	seenMutex sync.Mutex
+}
+
+func NewPipeline(db *pgxpool.Pool, channelRepository *repositories.ChannelRepository, validationRepository *repositories.ValidationRepository, filterRepository *repositories.FilterRepository, throttleRepository *repositories.ThrottleRepository, transformRepository *repositories.TransformRepository, hooks *script.Hooks, alertRepository *repositories.AlertRepository, notificationRepository *repositories.NotificationRepository, alertNotifier *notifier.Notifier, realtimeHub *dependencies.RealtimeHub, channelBridge *bridge.Bridge, meter *metering.Meter, ingestMeter *metering.IngestMeter, nodeRepository *repositories.NodeRepository) (*Pipeline, error) {
+	return &Pipeline{
+		db:                     db,
+		channelRepository:      channelRepository,
+		validationRepository:   validationRepository,
+		filterRepository:       filterRepository,
+		throttleRepository:     throttleRepository,
+		transformRepository:    transformRepository,
+		hooks:                  hooks,
+		alertRepository:        alertRepository,
+		notificationRepository: notificationRepository,
+		notifier:               alertNotifier,
+		realtimeHub:            realtimeHub,
+		bridge:                 channelBridge,
+		meter:                  meter,
+		ingestMeter:            ingestMeter,
+		nodeRepository:         nodeRepository,
+		seen:                   map[int]time.Time{},
+	}, nil
+}
+
+// markSeen mark the node of the sensor online, failing it shouldn't fail the ingest
+func (p *Pipeline) markSeen(ctx context.Context, idSensor int, now time.Time) {
+	p.seenMutex.Lock()
+	last, ok := p.seen[idSensor]
+	if ok && now.Sub(last) < seenInterval {
+		p.seenMutex.Unlock()
+		return
+	}
+	p.seen[idSensor] = now
+	p.seenMutex.Unlock()
+
+	err := p.nodeRepository.SeenBySensor(ctx, p.db, idSensor, now)
+	if err != nil {
+		log.Printf("[HEARTBEAT] Error marking the node of sensor %d seen: %v", idSensor, err)
+	}
+}
+
+// Store the channel of the sensor owned by idUser, the caller check the ownership. coalesced is true
+// when the channel replaced the last one of a coalescing throttle. The payload is changed by the
+// sensor transformation and ingest script. The node of the sensor is marked seen, even when the
+// channel is refused, since the device is connected
+func (p *Pipeline) Store(ctx context.Context, idUser int, payload *entities.ChannelCreate) (channel entities.Channel, coalesced bool, err error) {
+	p.markSeen(ctx, payload.IdSensor, time.Now().UTC())
+	return p.store(ctx, idUser, payload)
+}
+
+// StoreEnrichment store a channel that isn't sent by the device, like the weather, so the node
+// isn't marked seen
+func (p *Pipeline) StoreEnrichment(ctx context.Context, idUser int, payload *entities.ChannelCreate) (channel entities.Channel, coalesced bool, err error) {
+	return p.store(ctx, idUser, payload)
+}
+
+func (p *Pipeline) store(ctx context.Context, idUser int, payload *entities.ChannelCreate) (channel entities.Channel, coalesced bool, err error) {
+	dropped, err := p.transformRepository.Apply(ctx, p.db, payload)
+	if err != nil {
+		return channel, false, err
+	}
+	if dropped {
+		return channel, false, ErrDropped
+	}
+	dropped, err = p.hooks.Ingest(ctx, idUser, payload)
+	if err != nil {
+		return channel, false, err
+	}
+	if dropped {
+		return channel, false, ErrDropped
+	}
+
+	now := time.Now().UTC()
+	throttle, last, err := p.throttleRepository.Check(ctx, p.db, payload, now)
+	if err != nil {
+		return channel, false, err
+	}
+	if last != nil && throttle.Mode == entities.ThrottleReject {
+		return channel, false, &ThrottledError{
+			RetryAfter: last.Add(time.Duration(throttle.MinIntervalMs) * time.Millisecond).Sub(now),
+			err:        fiber.NewError(fiber.StatusTooManyRequests, fmt.Sprintf("The sensor accept a channel every %d ms", throttle.MinIntervalMs)),
+		}
+	}
+
+	rejected, reason, err := p.validationRepository.Check(ctx, p.db, payload)
+	if err != nil {
+		return channel, false, err
+	}
+	if rejected {
+		return channel, false, fiber.NewError(fiber.StatusUnprocessableEntity, "The channel is rejected by the sensor validation rule, "+reason)
+	}
+
+	filteredValue, err := p.filterRepository.Apply(ctx, p.db, payload)
+	if err != nil {
+		return channel, false, err
+	}
+
+	if last != nil {
+		channel, err = p.channelRepository.Coalesce(ctx, p.db, *last, payload, filteredValue)
+		if err != nil {
+			return channel, false, err
+		}
+		p.publish(ctx, channel)
+		p.hooks.Alert(ctx, idUser, channel)
+		p.alert(ctx, idUser, channel)
+		return channel, true, nil
+	}
+
+	channel, err = p.channelRepository.Create(ctx, p.db, payload, filteredValue)
+	if err != nil {
+		return channel, false, err
+	}
+	p.meter.Add(idUser, entities.UsagePointsStored, 1)
+	p.publish(ctx, channel)
+	p.hooks.Alert(ctx, idUser, channel)
+	p.alert(ctx, idUser, channel)
+	return channel, false, nil
+}
+
+// StoreBulk store the channel buffered by a device of idUser at their time in one transaction, the
+// caller check the ownership. The channel is calibrated and only the range of the sensor validation
+// rule is checked, it is history so the transformation, script, throttle and filter aren't run and it
+// isn't published.
+// bytes is the body size, shared between the sensor by their channel count
+func (p *Pipeline) StoreBulk(ctx context.Context, idUser int, items []entities.ChannelBulkItem, bytes int) (count int, err error) {
+	now := time.Now().UTC()
+	// An item with fields is a channel per field at the time of the item
+	channels := make([]entities.Channel, 0, len(items))
+	for _, item := range items {
+		channelTime := now
+		if item.Time != nil {
+			channelTime = item.Time.UTC()
+		}
+		for _, payload := range item.Split() {
+			channels = append(channels, entities.Channel{Time: channelTime, ChannelCreate: payload})
+		}
+	}
+	payloads := make([]*entities.ChannelCreate, len(channels))
+	for i := range channels {
+		payloads[i] = &channels[i].ChannelCreate
+	}
+
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	err = p.transformRepository.CalibrateMany(ctx, tx, payloads)
+	if err != nil {
+		return 0, err
+	}
+	err = p.validationRepository.CheckMany(ctx, tx, payloads)
+	if err != nil {
+		return 0, err
+	}
+	created, err := p.channelRepository.CreateMany(ctx, tx, channels)
+	if err != nil {
+		return 0, err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	p.meter.Add(idUser, entities.UsagePointsStored, created)
+	sensorCounts := map[int]int{}
+	for _, channel := range channels {
+		sensorCounts[channel.IdSensor]++
+	}
+	for idSensor, sensorCount := range sensorCounts {
+		p.ingestMeter.AcceptMany(idSensor, sensorCount, bytes*sensorCount/len(channels))
+		p.markSeen(ctx, idSensor, now)
+	}
+	return int(created), nil
+}
+
+// publish the stored channel, failing to notify live viewer shouldn't fail the ingest
+func (p *Pipeline) publish(ctx context.Context, channel entities.Channel) {
+	err := p.realtimeHub.Publish(ctx, channel)
+	if err != nil {
+		log.Printf("[REALTIME] Error publishing channel of sensor %d: %v", channel.IdSensor, err)
+	}
+	p.bridge.Enqueue(channel)
+}
+
+// alert evaluate the alert rule of the sensor with the stored channel, the owner is notified of the
+// alert it fired and resolved. Failing to do so shouldn't fail the ingest either
+func (p *Pipeline) alert(ctx context.Context, idUser int, channel entities.Channel) {
+	fired, resolved, err := p.alertRepository.Evaluate(ctx, p.db, channel)
+	if err != nil {
+		log.Printf("[ALERT] Error evaluating the alert rule of sensor %d: %v", channel.IdSensor, err)
+		return
+	}
+
+	for _, alert := range fired {
+		p.notifyAlert(ctx, idUser, entities.EventAlertFired, alert, alert.Title, fmt.Sprintf("Sensor %d sent %g at %s", alert.IdSensor, alert.Value, alert.FiredAt.Format(time.RFC3339)))
+	}
+	for _, alert := range resolved {
+		p.notifyAlert(ctx, idUser, entities.EventAlertResolved, alert, "Resolved: "+alert.Title, fmt.Sprintf("Sensor %d is back to normal at %s", alert.IdSensor, alert.ResolvedAt.Format(time.RFC3339)))
+	}
+}
+
+// notifyAlert send the alert notification and queue the event for the alert target of the user
+func (p *Pipeline) notifyAlert(ctx context.Context, idUser int, event string, alert entities.Alert, title string, message string) {
+	link := "/alert"
+	_, err := p.notificationRepository.Create(ctx, p.db, idUser, &entities.NotificationCreate{
+		Type:    "alert",
+		Title:   title,
+		Message: message,
+		Link:    &link,
+	})
+	if err != nil {
+		log.Printf("[ALERT] Error sending the alert of rule %d: %v", alert.IdRule, err)
+	}
+
+	err = p.notifier.Notify(ctx, idUser, event, alert)
+	if err != nil {
+		log.Printf("[ALERT] Error notifying the alert target of rule %d: %v", alert.IdRule, err)
+	}
+}
+
+// Record count the channel received for the sensor, stored when err is nil and otherwise refused
+// with the status of the error
+func (p *Pipeline) Record(idSensor int, bytes int, err error) {
+	if err == nil {
+		p.ingestMeter.Accept(idSensor, bytes)
+		return
+	}
+
+	status := fiber.StatusInternalServerError
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		status = fiberErr.Code
+	}
+	p.ingestMeter.Reject(idSensor, bytes, status, err.Error())
+}
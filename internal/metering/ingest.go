@@ -0,0 +1,161 @@
+package metering
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type ingestKey struct {
+	idSensor int
+	bucket   time.Time
+}
+
+type ingestCount struct {
+	accepted int64
+	rejected int64
+	bytes    int64
+}
+
+type ingestLast struct {
+	received  *time.Time
+	lastError *entities.IngestError
+}
+
+// IngestMeter count the channel posted to each sensor per hour, and keep its last received channel
+// and error. Like Meter it is kept in memory and periodically added to ingest_stat and sensor_ingest
+type IngestMeter struct {
+	db         *pgxpool.Pool
+	repository *repositories.IngestRepository
+	mutex      sync.Mutex
+	pending    map[ingestKey]ingestCount
+	last       map[int]ingestLast
+}
+
+func NewIngestMeter(db *pgxpool.Pool, ingestRepository *repositories.IngestRepository) (*IngestMeter, error) {
+	return &IngestMeter{
+		db:         db,
+		repository: ingestRepository,
+		pending:    map[ingestKey]ingestCount{},
+		last:       map[int]ingestLast{},
+	}, nil
+}
+
+// Accept count a stored channel of the sensor and its body size
+func (m *IngestMeter) Accept(idSensor int, bytes int) {
+	now := time.Now().UTC()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key := ingestKey{idSensor: idSensor, bucket: repositories.IngestBucket(now)}
+	count := m.pending[key]
+	count.accepted++
+	count.bytes += int64(bytes)
+	m.pending[key] = count
+
+	last := m.last[idSensor]
+	last.received = &now
+	m.last[idSensor] = last
+}
+
+// AcceptMany count count stored channel of the sensor received in one body, with their share of it
+func (m *IngestMeter) AcceptMany(idSensor int, count int, bytes int) {
+	now := time.Now().UTC()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key := ingestKey{idSensor: idSensor, bucket: repositories.IngestBucket(now)}
+	pending := m.pending[key]
+	pending.accepted += int64(count)
+	pending.bytes += int64(bytes)
+	m.pending[key] = pending
+
+	last := m.last[idSensor]
+	last.received = &now
+	m.last[idSensor] = last
+}
+
+// Reject count a refused channel of the sensor and its body size, and keep the error
+func (m *IngestMeter) Reject(idSensor int, bytes int, status int, message string) {
+	now := time.Now().UTC()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key := ingestKey{idSensor: idSensor, bucket: repositories.IngestBucket(now)}
+	count := m.pending[key]
+	count.rejected++
+	count.bytes += int64(bytes)
+	m.pending[key] = count
+
+	last := m.last[idSensor]
+	last.lastError = &entities.IngestError{Time: now, Status: status, Message: message}
+	m.last[idSensor] = last
+}
+
+// Flush write the pending count and last ingest to the database in one transaction. On failure
+// they are put back so they are retried on the next flush
+func (m *IngestMeter) Flush(ctx context.Context) (err error) {
+	m.mutex.Lock()
+	pending, lasts := m.pending, m.last
+	m.pending, m.last = map[ingestKey]ingestCount{}, map[int]ingestLast{}
+	m.mutex.Unlock()
+
+	if len(pending) == 0 && len(lasts) == 0 {
+		return nil
+	}
+
+	defer func() {
+		if err != nil {
+			m.mutex.Lock()
+			for key, value := range pending {
+				count := m.pending[key]
+				count.accepted += value.accepted
+				count.rejected += value.rejected
+				count.bytes += value.bytes
+				m.pending[key] = count
+			}
+			for idSensor, value := range lasts {
+				// The ingest since the failed flush is newer
+				last := m.last[idSensor]
+				if last.received == nil {
+					last.received = value.received
+				}
+				if last.lastError == nil {
+					last.lastError = value.lastError
+				}
+				m.last[idSensor] = last
+			}
+			m.mutex.Unlock()
+		}
+	}()
+
+	tx, err := m.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for key, value := range pending {
+		err = m.repository.Add(ctx, tx, key.idSensor, key.bucket, value.accepted, value.rejected, value.bytes)
+		if err != nil {
+			return err
+		}
+	}
+	for idSensor, value := range lasts {
+		err = m.repository.SetLast(ctx, tx, idSensor, value.received, value.lastError)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Start flush every interval until the context is done, then flush one last time
+func (m *IngestMeter) Start(ctx context.Context, interval time.Duration) {
+	startFlush(ctx, interval, "ingest", m.Flush)
+}
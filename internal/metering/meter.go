@@ -0,0 +1,123 @@
+package metering
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type meterKey struct {
+	idUser int
+	month  time.Time
+	metric string
+}
+
+// Meter count the usage of each user. The counter is kept in memory so metering doesn't add a
+// write to every request, and periodically added to the monthly rollup in usage_counter.
+// Each instance flush its own counter, the rollup is an increment so they add up.
+type Meter struct {
+	db         *pgxpool.Pool
+	repository *repositories.UsageRepository
+	mutex      sync.Mutex
+	pending    map[meterKey]int64
+}
+
+func NewMeter(db *pgxpool.Pool, usageRepository *repositories.UsageRepository) (*Meter, error) {
+	return &Meter{
+		db:         db,
+		repository: usageRepository,
+		pending:    map[meterKey]int64{},
+	}, nil
+}
+
+// Add count value of the metric to the user in the current month
+func (m *Meter) Add(idUser int, metric string, value int64) {
+	if idUser == 0 || value <= 0 {
+		return
+	}
+
+	key := meterKey{
+		idUser: idUser,
+		month:  repositories.UsageMonth(time.Now()),
+		metric: metric,
+	}
+	m.mutex.Lock()
+	m.pending[key] += value
+	m.mutex.Unlock()
+}
+
+// Flush write the pending counter to the database in one transaction. On failure the counter
+// is put back so it is retried on the next flush
+func (m *Meter) Flush(ctx context.Context) (err error) {
+	m.mutex.Lock()
+	pending := m.pending
+	m.pending = map[meterKey]int64{}
+	m.mutex.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	defer func() {
+		if err != nil {
+			m.mutex.Lock()
+			for key, value := range pending {
+				m.pending[key] += value
+			}
+			m.mutex.Unlock()
+		}
+	}()
+
+	tx, err := m.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for key, value := range pending {
+		err = m.repository.Add(ctx, tx, key.idUser, key.month, key.metric, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+const defaultFlushInterval = 30 * time.Second
+
+// Start flush the counter every interval until the context is done, then flush one last time
+func (m *Meter) Start(ctx context.Context, interval time.Duration) {
+	startFlush(ctx, interval, "usage", m.Flush)
+}
+
+func startFlush(ctx context.Context, interval time.Duration, name string, flush func(ctx context.Context) error) {
+	if interval <= 0 {
+		interval = defaultFlushInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				err := flush(ctx)
+				if err != nil {
+					log.Printf("[METERING] Error flushing %s: %v", name, err)
+				}
+			case <-ctx.Done():
+				err := flush(context.Background())
+				if err != nil {
+					log.Printf("[METERING] Error flushing %s: %v", name, err)
+				}
+				return
+			}
+		}
+	}()
+}
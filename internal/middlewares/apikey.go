@@ -0,0 +1,47 @@
+package middlewares
+
+import (
+	"context"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ApiKeyMiddleware authenticate a device with the API key of its node, for the route a firmware call
+type ApiKeyMiddleware struct {
+	db         *pgxpool.Pool
+	repository *repositories.ApiKeyRepository
+}
+
+func NewApiKeyMiddleware(db *pgxpool.Pool, apiKeyRepository *repositories.ApiKeyRepository) ApiKeyMiddleware {
+	return ApiKeyMiddleware{
+		db:         db,
+		repository: apiKeyRepository,
+	}
+}
+
+// ValidateUserOrApiKey set the owner of the node as the current user when the API key header is
+// present, the handler must then limit the request to the node of the key. Otherwise the user token
+// is checked like ValidateUser
+func (a *ApiKeyMiddleware) ValidateUserOrApiKey(c *fiber.Ctx) error {
+	key := c.Get(entities.ApiKeyHeader)
+	if key == "" {
+		currentUser, err := helper.ValidateUserCredentical(c)
+		if err != nil {
+			return err
+		}
+		c.Locals("currentUser", currentUser)
+		return c.Next()
+	}
+
+	currentUser, nodeId, err := a.repository.Authenticate(context.Background(), a.db, key)
+	if err != nil {
+		return err
+	}
+	c.Locals("currentUser", currentUser)
+	c.Locals("apiKeyNode", nodeId)
+	return c.Next()
+}
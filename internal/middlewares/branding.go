@@ -0,0 +1,44 @@
+package middlewares
+
+import (
+	"context"
+	"log"
+
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type BrandingMiddleware struct {
+	db         *pgxpool.Pool
+	repository *repositories.BrandingRepository
+}
+
+func NewBrandingMiddleware(db *pgxpool.Pool, brandingRepository *repositories.BrandingRepository) BrandingMiddleware {
+	return BrandingMiddleware{
+		db:         db,
+		repository: brandingRepository,
+	}
+}
+
+// Apply bind the deployment branding to every rendered view, the config branding is
+// used when the admin override can't be read so the page is still rendered
+func (b *BrandingMiddleware) Apply(c *fiber.Ctx) error {
+	if c.Accepts("application/json", "text/html") != "text/html" {
+		return c.Next()
+	}
+
+	branding, err := b.repository.Get(context.Background(), b.db)
+	if err != nil {
+		log.Printf("[BRANDING] Failed to read the branding setting: %v", err)
+	}
+
+	err = c.Bind(fiber.Map{
+		"branding": branding,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Next()
+}
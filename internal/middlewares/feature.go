@@ -0,0 +1,46 @@
+package middlewares
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type FeatureMiddleware struct {
+	db         *pgxpool.Pool
+	repository *repositories.FeatureRepository
+	validator  *dependencies.Validator
+}
+
+func NewFeatureMiddleware(db *pgxpool.Pool, featureRepository *repositories.FeatureRepository, validator *dependencies.Validator) FeatureMiddleware {
+	return FeatureMiddleware{
+		db:         db,
+		repository: featureRepository,
+		validator:  validator,
+	}
+}
+
+// RequireFeature must be placed after the authentication middleware
+func (f *FeatureMiddleware) RequireFeature(name string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		currentUser, err := f.validator.GetAuthentication(c)
+		if err != nil {
+			return err
+		}
+
+		enabled, err := f.repository.IsEnabled(context.Background(), f.db, name, &currentUser)
+		if err != nil {
+			return err
+		}
+
+		if !enabled {
+			return fiber.NewError(fiber.StatusForbidden, fmt.Sprintf("Feature %s is not enabled for this account", name))
+		}
+
+		return c.Next()
+	}
+}
@@ -0,0 +1,86 @@
+package middlewares
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/i18n"
+	"github.com/gofiber/fiber/v2"
+)
+
+type FormMiddleware struct{}
+
+func NewFormMiddleware() FormMiddleware {
+	return FormMiddleware{}
+}
+
+// isFormSubmission is true for the HTML form posted by the browser, the JSON API is left as is
+func isFormSubmission(c *fiber.Ctx) bool {
+	contentType := string(c.Request().Header.ContentType())
+	return strings.HasPrefix(contentType, fiber.MIMEApplicationForm) && c.Accepts("application/json", "text/html") == "text/html"
+}
+
+// Rerender wrap the create or update handler of an HTML form. When the submitted form fail,
+// the form handler is rendered again with the per-field errors and the submitted values
+// bound as "errors", "values" and "formError". A successful submission redirect to the given url.
+// Unauthorized and server errors still go to the error page.
+func (f *FormMiddleware) Rerender(form fiber.Handler, redirect string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !isFormSubmission(c) {
+			return c.Next()
+		}
+
+		err := c.Next()
+		if err == nil {
+			if c.Response().StatusCode() >= fiber.StatusBadRequest {
+				return nil
+			}
+			c.Response().ResetBody()
+			// htmx would follow the redirect itself and swap the list into the form, so it is asked to navigate
+			if c.Get("HX-Request") == "true" {
+				c.Set("HX-Redirect", redirect)
+				return c.SendStatus(fiber.StatusOK)
+			}
+			return c.Redirect(redirect)
+		}
+
+		locale, ok := c.Locals("locale").(string)
+		if !ok {
+			locale = i18n.DefaultLocale
+		}
+
+		fields := map[string]string{}
+		formError := ""
+		code := fiber.StatusBadRequest
+		var validationError *dependencies.ValidationError
+		var fiberError *fiber.Error
+		if errors.As(err, &validationError) {
+			for field, message := range validationError.Fields {
+				fields[field] = i18n.TranslateError(locale, message)
+			}
+		} else if errors.As(err, &fiberError) && fiberError.Code < 500 && fiberError.Code != 401 && fiberError.Code != 403 {
+			code = fiberError.Code
+			formError = i18n.TranslateError(locale, fiberError.Message)
+		} else {
+			return err
+		}
+
+		values := map[string]string{}
+		c.Request().PostArgs().VisitAll(func(key []byte, value []byte) {
+			values[string(key)] = string(value)
+		})
+
+		err = c.Bind(fiber.Map{
+			"errors":    fields,
+			"values":    values,
+			"formError": formError,
+		})
+		if err != nil {
+			return err
+		}
+
+		c.Status(code)
+		return form(c)
+	}
+}
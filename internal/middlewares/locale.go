@@ -0,0 +1,58 @@
+package middlewares
+
+import (
+	"context"
+
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/dafaath/iot-server/internal/i18n"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type LocaleMiddleware struct {
+	db         *pgxpool.Pool
+	repository *repositories.UserRepository
+}
+
+func NewLocaleMiddleware(db *pgxpool.Pool, userRepository *repositories.UserRepository) LocaleMiddleware {
+	return LocaleMiddleware{
+		db:         db,
+		repository: userRepository,
+	}
+}
+
+// Apply set the locale of the request in Locals("locale") and bind it to the rendered view.
+// Rendered page use the logged in user preference, then the lang cookie, then Accept-Language.
+// API request only use Accept-Language so the device ingestion doesn't pay a query per request
+func (l *LocaleMiddleware) Apply(c *fiber.Ctx) error {
+	locale := ""
+	if c.Accepts("application/json", "text/html") == "text/html" {
+		cookieLocale := c.Cookies("lang")
+		if i18n.IsSupported(cookieLocale) {
+			locale = cookieLocale
+		}
+
+		currentUser, err := helper.ValidateUserCredentical(c)
+		if err == nil {
+			language, err := l.repository.GetLanguage(context.Background(), l.db, currentUser.IdUser)
+			if err == nil && language != nil && i18n.IsSupported(*language) {
+				locale = *language
+			}
+		}
+	}
+
+	if locale == "" {
+		locale = i18n.Negotiate(c.Get(fiber.HeaderAcceptLanguage))
+	}
+
+	c.Locals("locale", locale)
+	err := c.Bind(fiber.Map{
+		"locale": locale,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Next()
+}
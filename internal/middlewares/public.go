@@ -0,0 +1,62 @@
+package middlewares
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cache"
+)
+
+// PublicMiddleware protect the unauthenticated status page from being scraped. The rate limit is
+// counted in the cluster store so it is shared by every instance, the cache is kept per instance
+type PublicMiddleware struct {
+	cluster   *dependencies.Cluster
+	rateLimit int64
+	Cache     fiber.Handler
+}
+
+func NewPublicMiddleware(cluster *dependencies.Cluster, config *configs.Config) PublicMiddleware {
+	rateLimit := config.StatusPage.RateLimit
+	if rateLimit <= 0 {
+		rateLimit = 30
+	}
+	cacheSeconds := config.StatusPage.CacheSeconds
+	if cacheSeconds <= 0 {
+		cacheSeconds = 60
+	}
+
+	return PublicMiddleware{
+		cluster:   cluster,
+		rateLimit: int64(rateLimit),
+		// The page and the JSON of the same token are cached apart
+		Cache: cache.New(cache.Config{
+			Expiration:   time.Duration(cacheSeconds) * time.Second,
+			CacheControl: true,
+			KeyGenerator: func(c *fiber.Ctx) string {
+				return c.Path() + "|" + c.Accepts("application/json", "text/html")
+			},
+		}),
+	}
+}
+
+// Limit allow rateLimit request per minute from each IP
+func (p *PublicMiddleware) Limit(c *fiber.Ctx) error {
+	ctx := context.Background()
+	key := "public:limit:" + c.IP()
+	count, err := p.cluster.Store.Incr(ctx, key, time.Minute)
+	if err != nil {
+		return err
+	}
+	if count > p.rateLimit {
+		ttl, err := p.cluster.Store.TTL(ctx, key)
+		if err == nil && ttl > 0 {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(ttl.Seconds())+1))
+		}
+		return fiber.NewError(fiber.StatusTooManyRequests, "Too many request, try again in a minute")
+	}
+	return c.Next()
+}
@@ -0,0 +1,139 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+)
+
+// bucketLimit is a token bucket per client refilled with rate token per second up to burst, so a client
+// can send burst request at once and then rate request per second. The bucket is kept in the cluster
+// store so every instance share it
+type bucketLimit struct {
+	name  string
+	rate  float64
+	burst int64
+}
+
+// verifiedKeyTTL is how long an authenticated API key is counted on its own bucket before it must be
+// authenticated again, a revoked key is counted on the IP after it
+const verifiedKeyTTL = 10 * time.Minute
+
+// RateLimitMiddleware keep one misbehaving client from starving the database pool. Every request
+// take a token of its API key, user or IP, and the channel ingestion also take a token of a stricter
+// limit. The bucket is kept in the cluster store, so the limit apply to the client across every replica
+type RateLimitMiddleware struct {
+	cluster *dependencies.Cluster
+	all     bucketLimit
+	ingest  bucketLimit
+}
+
+func NewRateLimitMiddleware(cluster *dependencies.Cluster, config *configs.Config) RateLimitMiddleware {
+	rate := config.RateLimit.Rate
+	if rate <= 0 {
+		rate = 20
+	}
+	burst := config.RateLimit.Burst
+	if burst <= 0 {
+		burst = 100
+	}
+	ingestRate := config.RateLimit.IngestRate
+	if ingestRate <= 0 {
+		ingestRate = 5
+	}
+	ingestBurst := config.RateLimit.IngestBurst
+	if ingestBurst <= 0 {
+		ingestBurst = 20
+	}
+
+	return RateLimitMiddleware{
+		cluster: cluster,
+		all:     bucketLimit{name: "all", rate: rate, burst: int64(burst)},
+		ingest:  bucketLimit{name: "ingest", rate: ingestRate, burst: int64(ingestBurst)},
+	}
+}
+
+// hashKey hash the API key so it isn't kept in the store
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// clientKey identify the client before the route authenticate it. An API key is only used once it was
+// authenticated by an earlier request, otherwise a client could send a new key to get a new bucket. The
+// token signature is checked so a client can't spend the limit of another user
+func (r *RateLimitMiddleware) clientKey(ctx context.Context, c *fiber.Ctx) (string, error) {
+	key := c.Get(entities.ApiKeyHeader)
+	if key != "" {
+		_, verified, err := r.cluster.Store.Get(ctx, "ratelimit:verified:"+hashKey(key))
+		if err != nil {
+			return "", err
+		}
+		if verified {
+			return "key:" + hashKey(key), nil
+		}
+	}
+	idUser, ok := helper.RequestUserId(c)
+	if ok {
+		return "user:" + strconv.Itoa(idUser), nil
+	}
+	return "ip:" + c.IP(), nil
+}
+
+func (r *RateLimitMiddleware) limit(c *fiber.Ctx, limit bucketLimit) error {
+	ctx := context.Background()
+	client, err := r.clientKey(ctx, c)
+	if err != nil {
+		return err
+	}
+	c.Locals("rateLimitClient", client)
+	bucket, err := r.cluster.Store.Take(ctx, "ratelimit:"+limit.name+":"+client, limit.rate, limit.burst)
+	if err != nil {
+		return err
+	}
+
+	c.Set("X-RateLimit-Limit", strconv.FormatInt(limit.burst, 10))
+	c.Set("X-RateLimit-Remaining", strconv.FormatInt(bucket.Remaining, 10))
+	if !bucket.Allowed {
+		retryAfter := int(math.Ceil(bucket.RetryAfter.Seconds()))
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+		return fiber.NewError(fiber.StatusTooManyRequests, "Too many request, slow down and retry after the Retry-After second")
+	}
+	return c.Next()
+}
+
+// Limit apply the limit of every request. An API key the route authenticated is remembered, so its
+// next request are counted on the key
+func (r *RateLimitMiddleware) Limit(c *fiber.Ctx) error {
+	err := r.limit(c, r.all)
+	key := c.Get(entities.ApiKeyHeader)
+	client, _ := c.Locals("rateLimitClient").(string)
+	if key == "" || c.Locals("apiKeyNode") == nil || strings.HasPrefix(client, "key:") {
+		return err
+	}
+
+	storeErr := r.cluster.Store.Set(context.Background(), "ratelimit:verified:"+hashKey(key), "1", verifiedKeyTTL)
+	if storeErr != nil {
+		log.Println("Error remembering the API key of the rate limit", storeErr)
+	}
+	return err
+}
+
+// LimitIngest apply the stricter limit of the channel ingestion, on top of Limit
+func (r *RateLimitMiddleware) LimitIngest(c *fiber.Ctx) error {
+	return r.limit(c, r.ingest)
+}
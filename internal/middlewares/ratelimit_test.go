@@ -0,0 +1,108 @@
+package middlewares
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/gofiber/fiber/v2"
+)
+
+func newTestLimitApp(t *testing.T) *fiber.App {
+	t.Helper()
+	config := &configs.Config{}
+	config.RateLimit.Rate = 0.001
+	config.RateLimit.Burst = 2
+	config.RateLimit.IngestRate = 0.001
+	config.RateLimit.IngestBurst = 1
+	cluster, err := dependencies.NewCluster(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limit := NewRateLimitMiddleware(&cluster, config)
+
+	app := fiber.New()
+	app.Use(limit.Limit)
+	// Only the key "valid" is authenticated, like ValidateUserOrApiKey
+	app.Post("/channel", limit.LimitIngest, func(c *fiber.Ctx) error {
+		if c.Get(entities.ApiKeyHeader) != "valid" {
+			return fiber.NewError(401, "API key is not valid or is revoked")
+		}
+		c.Locals("apiKeyNode", 1)
+		return c.SendStatus(201)
+	})
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendStatus(200)
+	})
+	return app
+}
+
+func send(t *testing.T, app *fiber.App, method string, path string, key string) int {
+	t.Helper()
+	request := httptest.NewRequest(method, path, nil)
+	if key != "" {
+		request.Header.Set(entities.ApiKeyHeader, key)
+	}
+	response, err := app.Test(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response.StatusCode
+}
+
+func TestRateLimitBurst(t *testing.T) {
+	app := newTestLimitApp(t)
+	for i := 0; i < 2; i++ {
+		if status := send(t, app, "GET", "/", ""); status != 200 {
+			t.Fatalf("request %d got %d, want 200", i, status)
+		}
+	}
+	response, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != 429 || response.Header.Get("X-RateLimit-Remaining") != "0" || response.Header.Get("X-RateLimit-Limit") != "2" {
+		t.Fatalf("got %d %v, want 429", response.StatusCode, response.Header)
+	}
+	retryAfter, _ := strconv.Atoi(response.Header.Get(fiber.HeaderRetryAfter))
+	if retryAfter < 1 {
+		t.Fatalf("Retry-After is %q", response.Header.Get(fiber.HeaderRetryAfter))
+	}
+}
+
+func TestRateLimitRandomKey(t *testing.T) {
+	app := newTestLimitApp(t)
+
+	// An unknown key is counted on the IP, a new key doesn't get a new bucket
+	if status := send(t, app, "POST", "/channel", "random-1"); status != 401 {
+		t.Fatalf("got %d, want 401", status)
+	}
+	if status := send(t, app, "POST", "/channel", "random-2"); status != 429 {
+		t.Fatalf("got %d, want 429", status)
+	}
+	if status := send(t, app, "GET", "/", "random-3"); status != 429 {
+		t.Fatalf("got %d, want 429", status)
+	}
+}
+
+func TestRateLimitVerifiedKey(t *testing.T) {
+	app := newTestLimitApp(t)
+
+	// The first request of the key is counted on the IP, then on the key once it is authenticated
+	if status := send(t, app, "POST", "/channel", "valid"); status != 201 {
+		t.Fatalf("got %d, want 201", status)
+	}
+	if status := send(t, app, "POST", "/channel", "valid"); status != 201 {
+		t.Fatalf("got %d, want 201", status)
+	}
+	if status := send(t, app, "POST", "/channel", "valid"); status != 429 {
+		t.Fatalf("got %d, want 429", status)
+	}
+	// The IP still has its token
+	if status := send(t, app, "GET", "/", ""); status != 200 {
+		t.Fatalf("got %d, want 200", status)
+	}
+}
@@ -0,0 +1,88 @@
+package middlewares
+
+import (
+	"context"
+	"log"
+	"regexp"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var validThemes = map[string]bool{"light": true, "dark": true, "system": true}
+
+// The palette is written inside a <style> tag, so only allow CSS variable name and color value
+var paletteKeyPattern = regexp.MustCompile(`^[a-z0-9-]+$`)
+var paletteValuePattern = regexp.MustCompile(`^[#a-zA-Z0-9(),.% -]+$`)
+
+type ThemeMiddleware struct {
+	db           *pgxpool.Pool
+	repository   *repositories.UserRepository
+	defaultTheme string
+	palette      map[string]string
+	darkPalette  map[string]string
+}
+
+func NewThemeMiddleware(db *pgxpool.Pool, userRepository *repositories.UserRepository, config *configs.Config) ThemeMiddleware {
+	defaultTheme := config.Theme.Default
+	if !validThemes[defaultTheme] {
+		log.Printf("[THEME] Invalid default theme %q, using system", defaultTheme)
+		defaultTheme = "system"
+	}
+
+	return ThemeMiddleware{
+		db:           db,
+		repository:   userRepository,
+		defaultTheme: defaultTheme,
+		palette:      filterPalette(config.Theme.Palette),
+		darkPalette:  filterPalette(config.Theme.DarkPalette),
+	}
+}
+
+func filterPalette(palette map[string]string) map[string]string {
+	filtered := map[string]string{}
+	for key, value := range palette {
+		if !paletteKeyPattern.MatchString(key) || !paletteValuePattern.MatchString(value) {
+			log.Printf("[THEME] Ignoring invalid palette entry %q: %q", key, value)
+			continue
+		}
+		filtered[key] = value
+	}
+	return filtered
+}
+
+// Apply bind the theme preference and the deployment palette to every rendered view. The preference
+// is read from the logged in user, then the theme cookie of anonymous visitor, then the deployment default
+func (t *ThemeMiddleware) Apply(c *fiber.Ctx) error {
+	if c.Accepts("application/json", "text/html") != "text/html" {
+		return c.Next()
+	}
+
+	theme := t.defaultTheme
+	cookieTheme := c.Cookies("theme")
+	if validThemes[cookieTheme] {
+		theme = cookieTheme
+	}
+
+	currentUser, err := helper.ValidateUserCredentical(c)
+	if err == nil {
+		userTheme, err := t.repository.GetTheme(context.Background(), t.db, currentUser.IdUser)
+		if err == nil {
+			theme = userTheme
+		}
+	}
+
+	err = c.Bind(fiber.Map{
+		"theme":       theme,
+		"palette":     t.palette,
+		"darkPalette": t.darkPalette,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Next()
+}
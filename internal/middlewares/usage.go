@@ -0,0 +1,30 @@
+package middlewares
+
+import (
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/metering"
+	"github.com/gofiber/fiber/v2"
+)
+
+type UsageMiddleware struct {
+	meter *metering.Meter
+}
+
+func NewUsageMiddleware(meter *metering.Meter) UsageMiddleware {
+	return UsageMiddleware{
+		meter: meter,
+	}
+}
+
+// CountApiCall count the request as an API call of the user once it is handled.
+// Only request authenticated by the route is counted, the user is set in Locals("currentUser") then
+func (u *UsageMiddleware) CountApiCall(c *fiber.Ctx) error {
+	err := c.Next()
+
+	currentUser, ok := c.Locals("currentUser").(entities.UserRead)
+	if ok {
+		u.meter.Add(currentUser.IdUser, entities.UsageApiCalls, 1)
+	}
+
+	return err
+}
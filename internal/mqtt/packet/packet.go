@@ -0,0 +1,109 @@
+// Package packet encode and decode the MQTT 3.1.1 packet used by the outbound bridge and the
+// inbound subscriber.
+package packet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// MQTT 3.1.1 control packet type, shifted into the high nibble of the fixed header
+const (
+	Connect     = 1 << 4
+	Connack     = 2 << 4
+	Publish     = 3 << 4
+	Puback      = 4 << 4
+	Subscribe   = 8 << 4
+	Suback      = 9 << 4
+	Pingreq     = 12 << 4
+	Pingresp    = 13 << 4
+	Disconnect  = 14 << 4
+	SubackError = 0x80
+)
+
+const KeepAlive = 60 * time.Second
+
+// ConnectBody return the variable header and payload of a CONNECT with a clean session, the client
+// doesn't resume anything
+func ConnectBody(clientId, username, password string) []byte {
+	flags := byte(0x02)
+	if username != "" {
+		flags |= 0x80
+		if password != "" {
+			flags |= 0x40
+		}
+	}
+	body := &bytes.Buffer{}
+	WriteString(body, "MQTT")
+	body.WriteByte(4)
+	body.WriteByte(flags)
+	binary.Write(body, binary.BigEndian, uint16(KeepAlive/time.Second))
+	WriteString(body, clientId)
+	if username != "" {
+		WriteString(body, username)
+		if password != "" {
+			WriteString(body, password)
+		}
+	}
+	return body.Bytes()
+}
+
+// Encode return the fixed header followed by the body
+func Encode(header byte, body []byte) []byte {
+	packet := &bytes.Buffer{}
+	packet.WriteByte(header)
+	WriteLength(packet, len(body))
+	packet.Write(body)
+	return packet.Bytes()
+}
+
+func WriteString(w *bytes.Buffer, value string) {
+	binary.Write(w, binary.BigEndian, uint16(len(value)))
+	w.WriteString(value)
+}
+
+// WriteLength write the remaining length, 7 bit per byte with the high bit set when more follow
+func WriteLength(w *bytes.Buffer, length int) {
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		w.WriteByte(digit)
+		if length == 0 {
+			return
+		}
+	}
+}
+
+func ReadLength(r *bufio.Reader) (int, error) {
+	length, multiplier := 0, 1
+	for i := 0; i < 4; i++ {
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		length += int(digit&0x7f) * multiplier
+		if digit&0x80 == 0 {
+			return length, nil
+		}
+		multiplier *= 128
+	}
+	return 0, errors.New("malformed remaining length from the broker")
+}
+
+// ReadString read a length prefixed string at the start of the body and return the rest
+func ReadString(body []byte) (string, []byte, error) {
+	if len(body) < 2 {
+		return "", nil, errors.New("malformed string from the broker")
+	}
+	length := int(binary.BigEndian.Uint16(body))
+	if len(body) < 2+length {
+		return "", nil, errors.New("malformed string from the broker")
+	}
+	return string(body[2 : 2+length]), body[2+length:], nil
+}
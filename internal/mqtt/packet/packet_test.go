@@ -0,0 +1,164 @@
+package packet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"io"
+	"testing"
+)
+
+func TestConnectBodyGolden(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		password string
+		want     string
+	}{
+		// "MQTT", level 4, clean session, keep alive 60 and the client id "c"
+		{"anonymous", "", "", "00044d5154540402003c000163"},
+		{"username", "u", "", "00044d5154540482003c0001630001" + "75"},
+		{"username and password", "u", "p", "00044d51545404c2003c0001630001" + "75" + "0001" + "70"},
+		// A password without username isn't allowed by MQTT 3.1.1, it is left out
+		{"password only", "", "p", "00044d5154540402003c000163"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := hex.EncodeToString(ConnectBody("c", tc.username, tc.password))
+			if got != tc.want {
+				t.Fatalf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLengthRoundTrip(t *testing.T) {
+	// The boundaries of the 1 to 4 byte remaining length of the specification
+	tests := []struct {
+		length int
+		want   string
+	}{
+		{0, "00"},
+		{127, "7f"},
+		{128, "8001"},
+		{16383, "ff7f"},
+		{16384, "808001"},
+		{2097151, "ffff7f"},
+		{2097152, "80808001"},
+		{268435455, "ffffff7f"},
+	}
+	for _, tc := range tests {
+		buffer := &bytes.Buffer{}
+		WriteLength(buffer, tc.length)
+		if got := hex.EncodeToString(buffer.Bytes()); got != tc.want {
+			t.Errorf("WriteLength(%d) is %s, want %s", tc.length, got, tc.want)
+		}
+		length, err := ReadLength(bufio.NewReader(buffer))
+		if err != nil || length != tc.length {
+			t.Errorf("ReadLength(%s) is %d %v, want %d", tc.want, length, err, tc.length)
+		}
+	}
+}
+
+func TestReadLengthMalformed(t *testing.T) {
+	tests := map[string]string{
+		"empty":          "",
+		"truncated":      "80",
+		"truncated long": "ffffff",
+		"five byte":      "ffffffff01",
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			raw, _ := hex.DecodeString(data)
+			_, err := ReadLength(bufio.NewReader(bytes.NewReader(raw)))
+			if err == nil {
+				t.Fatalf("reading %s should fail", data)
+			}
+		})
+	}
+
+	_, err := ReadLength(bufio.NewReader(bytes.NewReader(nil)))
+	if err != io.EOF {
+		t.Fatalf("got %v, want EOF for a closed connection", err)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	got := hex.EncodeToString(Encode(Pingreq, nil))
+	if got != "c000" {
+		t.Fatalf("PINGREQ is %s, want c000", got)
+	}
+
+	body := bytes.Repeat([]byte{'x'}, 200)
+	packet := Encode(Publish|0x02, body)
+	reader := bufio.NewReader(bytes.NewReader(packet))
+	header, _ := reader.ReadByte()
+	length, err := ReadLength(reader)
+	if err != nil || header != Publish|0x02 || length != 200 {
+		t.Fatalf("got header %x length %d %v", header, length, err)
+	}
+	rest, _ := io.ReadAll(reader)
+	if !bytes.Equal(rest, body) {
+		t.Fatal("the body isn't after the length")
+	}
+}
+
+func TestStringRoundTrip(t *testing.T) {
+	for _, value := range []string{"", "iot/1/2", "sénsor", string(bytes.Repeat([]byte{'a'}, 300))} {
+		buffer := &bytes.Buffer{}
+		WriteString(buffer, value)
+		buffer.WriteString("rest")
+		got, rest, err := ReadString(buffer.Bytes())
+		if err != nil || got != value || string(rest) != "rest" {
+			t.Errorf("got %q %q %v, want %q", got, rest, err, value)
+		}
+	}
+}
+
+func TestReadStringMalformed(t *testing.T) {
+	for _, data := range []string{"", "00", "0001", "000574657374"} {
+		raw, _ := hex.DecodeString(data)
+		_, _, err := ReadString(raw)
+		if err == nil {
+			t.Errorf("reading %s should fail", data)
+		}
+	}
+}
+
+// FuzzReadLength check a remaining length never panic and is written back the same, except a
+// non-minimal length like 8000 for 0 which a broker shouldn't send
+func FuzzReadLength(f *testing.F) {
+	f.Add([]byte{0x00})
+	f.Add([]byte{0xff, 0x7f})
+	f.Add([]byte{0xff, 0xff, 0xff, 0x7f})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0x01})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		length, err := ReadLength(bufio.NewReader(bytes.NewReader(data)))
+		if err != nil {
+			return
+		}
+		if length < 0 || length > 268435455 {
+			t.Fatalf("length %d is out of range", length)
+		}
+		buffer := &bytes.Buffer{}
+		WriteLength(buffer, length)
+		again, err := ReadLength(bufio.NewReader(buffer))
+		if err != nil || again != length {
+			t.Fatalf("length %d is read back as %d %v", length, again, err)
+		}
+	})
+}
+
+func FuzzReadString(f *testing.F) {
+	f.Add([]byte{0x00, 0x03, 'a', 'b', 'c', 0x00, 0x01})
+	f.Add([]byte{0x00})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		value, rest, err := ReadString(data)
+		if err != nil {
+			return
+		}
+		if 2+len(value)+len(rest) != len(data) {
+			t.Fatalf("%x is read as %q and %x", data, value, rest)
+		}
+	})
+}
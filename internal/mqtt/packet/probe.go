@@ -0,0 +1,77 @@
+package packet
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+)
+
+// Probe connect to the broker of the tcp:// or ssl:// url with a clean session and disconnect once
+// the broker accept it, so the doctor can tell the broker is reachable and the login is right
+func Probe(brokerUrl string, clientId string, username string, password string, timeout time.Duration) error {
+	parsed, err := url.Parse(brokerUrl)
+	if err != nil || parsed.Hostname() == "" {
+		return fmt.Errorf("invalid url %q", brokerUrl)
+	}
+	port := parsed.Port()
+	var tlsConfig *tls.Config
+	switch parsed.Scheme {
+	case "tcp", "mqtt":
+		if port == "" {
+			port = "1883"
+		}
+	case "ssl", "tls", "mqtts":
+		if port == "" {
+			port = "8883"
+		}
+		tlsConfig = &tls.Config{ServerName: parsed.Hostname()}
+	default:
+		return fmt.Errorf("unsupported url scheme %q, use tcp or ssl", parsed.Scheme)
+	}
+	address := net.JoinHostPort(parsed.Hostname(), port)
+
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	if tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	_, err = conn.Write(Encode(Connect, ConnectBody(clientId, username, password)))
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(conn)
+	header, err := reader.ReadByte()
+	if err != nil {
+		return err
+	}
+	length, err := ReadLength(reader)
+	if err != nil {
+		return err
+	}
+	ack := make([]byte, length)
+	_, err = io.ReadFull(reader, ack)
+	if err != nil {
+		return err
+	}
+	if header&0xf0 != Connack || len(ack) != 2 {
+		return errors.New("expected CONNACK from the broker")
+	}
+	if ack[1] != 0 {
+		return fmt.Errorf("broker refused the connection with return code %d", ack[1])
+	}
+	conn.Write([]byte{Disconnect, 0})
+	return nil
+}
@@ -0,0 +1,465 @@
+// Package mqtt subscribe to the reading that battery-powered device publish to an MQTT broker, and
+// store them like POST /channel.
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/dafaath/iot-server/internal/ingest"
+	"github.com/dafaath/iot-server/internal/mqtt/packet"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	dialTimeout    = 10 * time.Second
+	requestTimeout = 10 * time.Second
+	// The broker connection is retried this long after it failed
+	reconnectDelay = 10 * time.Second
+	// An instance not holding the lock try to take it over every this long
+	lockRetryDelay = 30 * time.Second
+	// The lock connection is checked every this long
+	lockCheckInterval = time.Minute
+	subscribePacketId = 1
+	lockName          = "mqtt_subscriber"
+)
+
+// Subscriber subscribe to the sensor topic of every node on one broker and store each message like
+// POST /channel. Only the instance holding a postgres advisory lock subscribe, so a reading isn't
+// stored once per replica, the other take over when it stop
+type Subscriber struct {
+	db               *pgxpool.Pool
+	sensorRepository *repositories.SensorRepository
+	apiKeyRepository *repositories.ApiKeyRepository
+	pipeline         *ingest.Pipeline
+	validator        *dependencies.Validator
+	address          string
+	tlsConfig        *tls.Config
+	clientId         string
+	username         string
+	password         string
+	// Topic template split by level, and the filter it subscribe with
+	levels      []string
+	filter      string
+	qos         byte
+	trustBroker bool
+	lastError   string
+}
+
+// NewSubscriber return nil when mqttIngest.url is empty. The client id default to iot-server-ingest-
+// followed by the instance id, so it doesn't take over the session of the outbound bridge
+func NewSubscriber(db *pgxpool.Pool, sensorRepository *repositories.SensorRepository, apiKeyRepository *repositories.ApiKeyRepository, pipeline *ingest.Pipeline, validator *dependencies.Validator, config *configs.Config, instanceId string) (*Subscriber, error) {
+	if config.MqttIngest.Url == "" {
+		return nil, nil
+	}
+
+	brokerUrl, err := url.Parse(config.MqttIngest.Url)
+	if err != nil || brokerUrl.Hostname() == "" {
+		return nil, fmt.Errorf("invalid mqttIngest url %q", config.MqttIngest.Url)
+	}
+	if config.MqttIngest.Qos != 0 && config.MqttIngest.Qos != 1 {
+		return nil, fmt.Errorf("mqttIngest qos must be 0 or 1, got %d", config.MqttIngest.Qos)
+	}
+
+	subscriber := &Subscriber{
+		db:               db,
+		sensorRepository: sensorRepository,
+		apiKeyRepository: apiKeyRepository,
+		pipeline:         pipeline,
+		validator:        validator,
+		clientId:         config.MqttIngest.ClientId,
+		username:         config.MqttIngest.Username,
+		password:         config.MqttIngest.Password,
+		levels:           strings.Split(config.MqttIngest.Topic, "/"),
+		qos:              byte(config.MqttIngest.Qos),
+		trustBroker:      config.MqttIngest.TrustBroker,
+	}
+	if subscriber.clientId == "" {
+		subscriber.clientId = "iot-server-ingest-" + instanceId
+	}
+
+	// Each placeholder level match any value
+	filter := make([]string, len(subscriber.levels))
+	hasSensor := false
+	for i, level := range subscriber.levels {
+		switch level {
+		case "{id_sensor}":
+			hasSensor = true
+			filter[i] = "+"
+		case "{id_node}":
+			filter[i] = "+"
+		default:
+			if level == "" || strings.ContainsAny(level, "+#{}") {
+				return nil, fmt.Errorf("invalid mqttIngest topic level %q", level)
+			}
+			filter[i] = level
+		}
+	}
+	if !hasSensor {
+		return nil, errors.New("mqttIngest topic need a {id_sensor} level")
+	}
+	subscriber.filter = strings.Join(filter, "/")
+
+	port := brokerUrl.Port()
+	switch brokerUrl.Scheme {
+	case "tcp", "mqtt":
+		if port == "" {
+			port = "1883"
+		}
+	case "ssl", "tls", "mqtts":
+		if port == "" {
+			port = "8883"
+		}
+		subscriber.tlsConfig = &tls.Config{ServerName: brokerUrl.Hostname()}
+	default:
+		return nil, fmt.Errorf("unsupported mqttIngest url scheme %q, use tcp or ssl", brokerUrl.Scheme)
+	}
+	subscriber.address = net.JoinHostPort(brokerUrl.Hostname(), port)
+
+	return subscriber, nil
+}
+
+// Start subscribe in background until the context is canceled, a nil subscriber does nothing
+func (s *Subscriber) Start(ctx context.Context) {
+	if s == nil {
+		return
+	}
+	go s.run(ctx)
+}
+
+func (s *Subscriber) run(ctx context.Context) {
+	for {
+		locked, err := s.runLocked(ctx)
+		delay := reconnectDelay
+		if !locked {
+			delay = lockRetryDelay
+		}
+		// The broker being down would log every reconnect otherwise
+		if err != nil && err.Error() != s.lastError {
+			log.Printf("[MQTT] Error subscribing to %s, retrying every %s: %v", s.address, delay, err)
+		}
+		if err != nil {
+			s.lastError = err.Error()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// runLocked subscribe while holding the lock, locked is false when another instance hold it
+func (s *Subscriber) runLocked(ctx context.Context) (locked bool, err error) {
+	conn, err := s.db.Acquire(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Release()
+
+	err = conn.QueryRow(ctx, `SELECT pg_try_advisory_lock(hashtext($1))`, lockName).Scan(&locked)
+	if err != nil || !locked {
+		return false, err
+	}
+	defer func() {
+		_, err := conn.Exec(context.Background(), `SELECT pg_advisory_unlock(hashtext($1))`, lockName)
+		if err != nil {
+			log.Printf("[MQTT] Can't release lock: %v", err)
+		}
+	}()
+
+	return true, s.subscribe(ctx, conn)
+}
+
+// subscribe receive the message until the connection to the broker or to the lock fail
+func (s *Subscriber) subscribe(ctx context.Context, lock *pgxpool.Conn) error {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	var conn net.Conn
+	var err error
+	if s.tlsConfig != nil {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: s.tlsConfig}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", s.address)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", s.address)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	err = s.send(conn, packet.Connect, packet.ConnectBody(s.clientId, s.username, s.password))
+	if err != nil {
+		return err
+	}
+	packetType, ack, err := s.receive(conn, reader, time.Now().Add(requestTimeout))
+	if err != nil {
+		return err
+	}
+	if packetType != packet.Connack || len(ack) != 2 {
+		return errors.New("expected CONNACK from the broker")
+	}
+	if ack[1] != 0 {
+		return fmt.Errorf("broker refused the connection with return code %d", ack[1])
+	}
+
+	body := &bytes.Buffer{}
+	binary.Write(body, binary.BigEndian, uint16(subscribePacketId))
+	packet.WriteString(body, s.filter)
+	body.WriteByte(s.qos)
+	// The fixed header flags of SUBSCRIBE are reserved as 0010
+	err = s.send(conn, packet.Subscribe|0x02, body.Bytes())
+	if err != nil {
+		return err
+	}
+	packetType, ack, err = s.receive(conn, reader, time.Now().Add(requestTimeout))
+	if err != nil {
+		return err
+	}
+	if packetType != packet.Suback || len(ack) != 3 || binary.BigEndian.Uint16(ack) != subscribePacketId {
+		return errors.New("expected SUBACK from the broker")
+	}
+	if ack[2] == packet.SubackError {
+		return fmt.Errorf("broker refused the subscription to %s", s.filter)
+	}
+	log.Printf("[MQTT] Subscribed to %s on %s", s.filter, s.address)
+	s.lastError = ""
+
+	lastSent := time.Now()
+	lockCheckAt := time.Now().Add(lockCheckInterval)
+	pingSent := false
+	for {
+		if ctx.Err() != nil {
+			conn.Write([]byte{packet.Disconnect, 0})
+			return ctx.Err()
+		}
+		now := time.Now()
+		if !now.Before(lockCheckAt) {
+			// Another instance take the lock when its connection is gone
+			err = lock.Ping(ctx)
+			if err != nil {
+				return fmt.Errorf("lost the lock connection: %w", err)
+			}
+			lockCheckAt = now.Add(lockCheckInterval)
+		}
+
+		// Ping the broker when nothing was sent for half the keep alive, a dead connection is
+		// noticed when the ping isn't answered before the next one
+		if now.Sub(lastSent) >= packet.KeepAlive/2 {
+			if pingSent {
+				return errors.New("expected PINGRESP from the broker")
+			}
+			err = s.send(conn, packet.Pingreq, nil)
+			if err != nil {
+				return err
+			}
+			lastSent = now
+			pingSent = true
+		}
+
+		readUntil := lastSent.Add(packet.KeepAlive / 2)
+		if lockCheckAt.Before(readUntil) {
+			readUntil = lockCheckAt
+		}
+		packetType, body, err := s.receive(conn, reader, readUntil)
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		switch packetType {
+		case packet.Pingresp:
+			pingSent = false
+		case packet.Publish:
+			// The flags carry the QoS in bit 1 and 2 and the retain in bit 0. A retained message is
+			// an old one sent again on every subscribe, so it is only acknowledged
+			qos := (body[0] >> 1) & 0x03
+			packetId, err := s.store(ctx, body[1:], qos, body[0]&0x01 == 0)
+			if err != nil {
+				return err
+			}
+			if qos > 0 {
+				ack := make([]byte, 2)
+				binary.BigEndian.PutUint16(ack, packetId)
+				err = s.send(conn, packet.Puback, ack)
+				if err != nil {
+					return err
+				}
+				lastSent = time.Now()
+			}
+		}
+	}
+}
+
+// store the message of a PUBLISH, a message that can't be stored is dropped since the device
+// doesn't get an answer. Only a malformed packet return an error
+func (s *Subscriber) store(ctx context.Context, message []byte, qos byte, live bool) (packetId uint16, err error) {
+	topic, rest, err := packet.ReadString(message)
+	if err != nil {
+		return 0, err
+	}
+	if qos > 0 {
+		if len(rest) < 2 {
+			return 0, errors.New("malformed PUBLISH from the broker")
+		}
+		packetId = binary.BigEndian.Uint16(rest)
+		rest = rest[2:]
+	}
+	if !live {
+		return packetId, nil
+	}
+
+	idNode, idSensor, ok := s.parseTopic(topic)
+	if !ok {
+		log.Printf("[MQTT] Ignoring message on topic %s, it doesn't match the sensor topic", topic)
+		return packetId, nil
+	}
+	sensor, err := s.sensorRepository.GetById(ctx, s.db, idSensor)
+	if err != nil {
+		log.Printf("[MQTT] Ignoring message on topic %s: %v", topic, err)
+		return packetId, nil
+	}
+	if idNode != 0 && sensor.IdNode != idNode {
+		s.pipeline.Record(idSensor, len(rest), fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("Sensor %d is not on node %d", idSensor, idNode)))
+		return packetId, nil
+	}
+	idUser, err := s.sensorRepository.GetIdUserWhoOwnSensorById(ctx, s.db, idSensor)
+	if err != nil {
+		s.pipeline.Record(idSensor, len(rest), err)
+		return packetId, nil
+	}
+
+	channels, err := s.parsePayload(ctx, idUser, sensor, rest)
+	if err != nil {
+		s.pipeline.Record(idSensor, len(rest), err)
+		return packetId, nil
+	}
+	for i := range channels {
+		_, _, err := s.pipeline.Store(ctx, idUser, &channels[i])
+		s.pipeline.Record(idSensor, len(rest), err)
+	}
+	return packetId, nil
+}
+
+// parseTopic return the node and sensor id of a topic matching the template, idNode is 0 when the
+// template has no {id_node}
+func (s *Subscriber) parseTopic(topic string) (idNode int, idSensor int, ok bool) {
+	levels := strings.Split(topic, "/")
+	if len(levels) != len(s.levels) {
+		return 0, 0, false
+	}
+	for i, level := range s.levels {
+		switch level {
+		case "{id_sensor}", "{id_node}":
+			id, err := strconv.Atoi(levels[i])
+			if err != nil || id <= 0 {
+				return 0, 0, false
+			}
+			if level == "{id_sensor}" {
+				idSensor = id
+			} else {
+				idNode = id
+			}
+		default:
+			if levels[i] != level {
+				return 0, 0, false
+			}
+		}
+	}
+	return idNode, idSensor, true
+}
+
+// parsePayload read a JSON reading with the token of the sensor owner or an API key of the sensor
+// node. When the broker is trusted both may be left out, and the payload may be a compact profile body
+func (s *Subscriber) parsePayload(ctx context.Context, idUser int, sensor entities.Sensor, payload []byte) ([]entities.ChannelCreate, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(payload), []byte("{")) {
+		if !s.trustBroker {
+			return nil, fiber.NewError(fiber.StatusUnauthorized, "Authorization not present")
+		}
+		return ingest.ParseCompact(sensor.IdSensor, string(payload))
+	}
+
+	reading := entities.MqttReading{}
+	err := s.validator.ParseJson(payload, &reading)
+	if err != nil {
+		return nil, err
+	}
+	if reading.ApiKey != "" {
+		_, keyNode, err := s.apiKeyRepository.Authenticate(ctx, s.db, reading.ApiKey)
+		if err != nil {
+			return nil, err
+		}
+		if keyNode != sensor.IdNode {
+			return nil, fiber.NewError(fiber.StatusForbidden, "The API key can only send channel to the sensor of its node")
+		}
+	} else if reading.Token != "" || !s.trustBroker {
+		user, err := helper.ValidateUserToken(reading.Token)
+		if err != nil {
+			return nil, fiber.NewError(fiber.StatusUnauthorized, err.Error())
+		}
+		if user.IdUser != idUser {
+			return nil, fiber.NewError(fiber.StatusForbidden, "You can't add channel to another user's sensor")
+		}
+	}
+	channel := entities.ChannelCreate{
+		IdSensor: sensor.IdSensor,
+		Name:     reading.Name,
+		Value:    reading.Value,
+		Quality:  reading.Quality,
+		Fields:   reading.Fields,
+	}
+	return channel.Split(), nil
+}
+
+func (s *Subscriber) send(conn net.Conn, header byte, body []byte) error {
+	conn.SetWriteDeadline(time.Now().Add(requestTimeout))
+	_, err := conn.Write(packet.Encode(header, body))
+	return err
+}
+
+// receive read one packet and return its type without the flags, the flags are kept as the first
+// byte of a PUBLISH body
+func (s *Subscriber) receive(conn net.Conn, reader *bufio.Reader, until time.Time) (packetType byte, body []byte, err error) {
+	conn.SetReadDeadline(until)
+	header, err := reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	// The rest of the packet follow the header right away
+	conn.SetReadDeadline(time.Now().Add(requestTimeout))
+	length, err := packet.ReadLength(reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	body = make([]byte, length)
+	_, err = io.ReadFull(reader, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	if header&0xf0 == packet.Publish {
+		body = append([]byte{header & 0x0f}, body...)
+	}
+	return header & 0xf0, body, nil
+}
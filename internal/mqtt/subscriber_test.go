@@ -0,0 +1,127 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/mqtt/packet"
+)
+
+func TestParseTopic(t *testing.T) {
+	tests := []struct {
+		template string
+		topic    string
+		idNode   int
+		idSensor int
+		ok       bool
+	}{
+		{"iot/{id_node}/{id_sensor}", "iot/3/12", 3, 12, true},
+		{"iot/{id_sensor}/reading", "iot/12/reading", 0, 12, true},
+		{"iot/{id_node}/{id_sensor}", "iot/3/12/extra", 0, 0, false},
+		{"iot/{id_node}/{id_sensor}", "iot/3", 0, 0, false},
+		{"iot/{id_node}/{id_sensor}", "other/3/12", 0, 0, false},
+		{"iot/{id_node}/{id_sensor}", "iot/3/abc", 0, 0, false},
+		{"iot/{id_node}/{id_sensor}", "iot/0/12", 0, 0, false},
+		{"iot/{id_node}/{id_sensor}", "iot/3/-1", 0, 0, false},
+		{"iot/{id_sensor}/reading", "iot/12/", 0, 0, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.topic, func(t *testing.T) {
+			s := &Subscriber{levels: strings.Split(tc.template, "/")}
+			idNode, idSensor, ok := s.parseTopic(tc.topic)
+			if idNode != tc.idNode || idSensor != tc.idSensor || ok != tc.ok {
+				t.Fatalf("got %d %d %v, want %d %d %v", idNode, idSensor, ok, tc.idNode, tc.idSensor, tc.ok)
+			}
+		})
+	}
+}
+
+// publish return the body of a PUBLISH without its fixed header
+func publish(topic string, packetId []byte, payload string) []byte {
+	body := &bytes.Buffer{}
+	packet.WriteString(body, topic)
+	body.Write(packetId)
+	body.WriteString(payload)
+	return body.Bytes()
+}
+
+func TestStorePacketId(t *testing.T) {
+	// A retained message is only acknowledged, so the subscriber isn't needed to store it
+	s := &Subscriber{}
+	tests := []struct {
+		name     string
+		message  []byte
+		qos      byte
+		packetId uint16
+		fail     bool
+	}{
+		{"qos 0", publish("iot/1/2", nil, "21.5"), 0, 0, false},
+		{"qos 1", publish("iot/1/2", []byte{0x12, 0x34}, "21.5"), 1, 0x1234, false},
+		{"qos 1 without payload", publish("iot/1/2", []byte{0x00, 0x01}, ""), 1, 1, false},
+		{"qos 1 without packet id", publish("iot/1/2", []byte{0x00}, ""), 1, 0, true},
+		{"empty", []byte{}, 0, 0, true},
+		{"truncated topic", []byte{0x00, 0x09, 'i', 'o', 't'}, 0, 0, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			packetId, err := s.store(context.Background(), tc.message, tc.qos, false)
+			if (err != nil) != tc.fail {
+				t.Fatalf("got error %v, want failure %v", err, tc.fail)
+			}
+			if packetId != tc.packetId {
+				t.Fatalf("packet id is %x, want %x", packetId, tc.packetId)
+			}
+		})
+	}
+}
+
+func TestReceive(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       []byte
+		packetType byte
+		body       []byte
+		fail       bool
+	}{
+		{"suback", packet.Encode(packet.Suback, []byte{0x00, 0x01, 0x01}), packet.Suback, []byte{0x00, 0x01, 0x01}, false},
+		{"pingresp", []byte{packet.Pingresp, 0x00}, packet.Pingresp, []byte{}, false},
+		// The flags of a PUBLISH are the first byte of its body, here retained with qos 1
+		{"publish", packet.Encode(packet.Publish|0x03, publish("t", []byte{0, 1}, "x")), packet.Publish, append([]byte{0x03}, publish("t", []byte{0, 1}, "x")...), false},
+		{"truncated body", []byte{packet.Suback, 0x03, 0x00}, 0, nil, true},
+		{"malformed length", []byte{packet.Publish, 0xff, 0xff, 0xff, 0xff, 0x01}, 0, nil, true},
+		{"closed", []byte{}, 0, nil, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer client.Close()
+			go func() {
+				server.Write(tc.data)
+				server.Close()
+			}()
+			s := &Subscriber{}
+			packetType, body, err := s.receive(client, bufio.NewReader(client), time.Now().Add(time.Second))
+			if (err != nil) != tc.fail {
+				t.Fatalf("got error %v, want failure %v", err, tc.fail)
+			}
+			if packetType != tc.packetType || !bytes.Equal(body, tc.body) {
+				t.Fatalf("got %x %x, want %x %x", packetType, body, tc.packetType, tc.body)
+			}
+		})
+	}
+}
+
+// FuzzStore check a malformed PUBLISH from the broker is an error instead of a panic
+func FuzzStore(f *testing.F) {
+	f.Add(publish("iot/1/2", []byte{0, 1}, `{"value": 1}`), byte(1))
+	f.Add([]byte{0x00}, byte(0))
+	f.Fuzz(func(t *testing.T, message []byte, qos byte) {
+		s := &Subscriber{}
+		s.store(context.Background(), message, qos%3, false)
+	})
+}
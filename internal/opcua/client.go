@@ -0,0 +1,686 @@
+package opcua
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Binary encoding id of the service request and response, from the namespace 0 of the standard
+const (
+	idServiceFault                 = 397
+	idAnonymousIdentityToken       = 321
+	idUserNameIdentityToken        = 324
+	idOpenSecureChannelRequest     = 446
+	idOpenSecureChannelResponse    = 449
+	idCloseSecureChannelRequest    = 452
+	idCreateSessionRequest         = 461
+	idCreateSessionResponse        = 464
+	idActivateSessionRequest       = 467
+	idActivateSessionResponse      = 470
+	idCloseSessionRequest          = 473
+	idCloseSessionResponse         = 476
+	idCreateMonitoredItemsRequest  = 751
+	idCreateMonitoredItemsResponse = 754
+	idDeleteMonitoredItemsRequest  = 781
+	idDeleteMonitoredItemsResponse = 784
+	idCreateSubscriptionRequest    = 787
+	idCreateSubscriptionResponse   = 790
+	idDataChangeNotification       = 811
+	idStatusChangeNotification     = 820
+	idPublishRequest               = 826
+	idPublishResponse              = 829
+)
+
+const (
+	securityPolicyNone = "http://opcfoundation.org/UA/SecurityPolicy#None"
+	// Message security mode of an endpoint without signing or encryption
+	securityModeNone = 1
+	// User token type of the user identity token policy
+	tokenAnonymous = 0
+	tokenUserName  = 1
+	// Attribute holding the value of a variable node
+	attributeValue = 13
+	// Monitoring mode sampling and reporting the value change
+	monitoringReporting = 2
+	// Timestamps to return with the data value, the channel is stored at the time it is received anyway
+	timestampsSource = 0
+
+	// Size of a message chunk the client can receive, and the whole message made of chunk
+	receiveBufferSize = 1 << 16
+	maxMessageSize    = 16 << 20
+	// The secure channel token is renewed after this part of its lifetime
+	renewRatio = 0.75
+)
+
+// Status code with a name in the error, the other are only shown as number
+var statusNames = map[uint32]string{
+	0x800A0000: "BadTimeout",
+	0x800B0000: "BadServiceUnsupported",
+	0x800C0000: "BadShutdown",
+	0x80100000: "BadTooManyOperations",
+	0x80130000: "BadSecurityChecksFailed",
+	0x801F0000: "BadUserAccessDenied",
+	0x80200000: "BadIdentityTokenInvalid",
+	0x80210000: "BadIdentityTokenRejected",
+	0x80220000: "BadSecureChannelIdInvalid",
+	0x80250000: "BadSessionIdInvalid",
+	0x80260000: "BadSessionClosed",
+	0x80270000: "BadSessionNotActivated",
+	0x80280000: "BadSubscriptionIdInvalid",
+	0x80320000: "BadWaitingForInitialData",
+	0x80330000: "BadNodeIdInvalid",
+	0x80340000: "BadNodeIdUnknown",
+	0x80350000: "BadAttributeIdInvalid",
+	0x803A0000: "BadNotReadable",
+	0x80790000: "BadNoSubscription",
+}
+
+// StatusText return the name and number of the status code, e.g. BadNodeIdUnknown (0x80340000)
+func StatusText(status uint32) string {
+	if name, ok := statusNames[status]; ok {
+		return fmt.Sprintf("%s (0x%08X)", name, status)
+	}
+	return fmt.Sprintf("0x%08X", status)
+}
+
+// isBad is true for a status code of the bad severity, the uncertain and good one have a usable value
+func isBad(status uint32) bool {
+	return status&0x80000000 != 0
+}
+
+func isUncertain(status uint32) bool {
+	return status&0xC0000000 == 0x40000000
+}
+
+// StatusError is a bad status code returned by the server for a service
+type StatusError struct {
+	Status uint32
+}
+
+func (e *StatusError) Error() string {
+	return "opcua server responded " + StatusText(e.Status)
+}
+
+// client is a minimal OPC-UA binary client over opc.tcp without security, it open one secure channel
+// and one session and send one request at a time
+type client struct {
+	endpoint string
+	conn     net.Conn
+	reader   *bufio.Reader
+	// Largest chunk the server accept
+	sendBufferSize uint32
+
+	channelId     uint32
+	tokenId       uint32
+	renewAt       time.Time
+	sequence      uint32
+	requestId     uint32
+	requestHandle uint32
+	authToken     NodeId
+}
+
+// dial open the connection to the server and exchange the hello
+func dial(ctx context.Context, endpoint string, address string) (*client, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	c := &client{endpoint: endpoint, conn: conn, reader: bufio.NewReader(conn)}
+
+	body := &encoder{}
+	body.uint32(0)
+	body.uint32(receiveBufferSize)
+	body.uint32(receiveBufferSize)
+	body.uint32(maxMessageSize)
+	body.uint32(0)
+	body.string(endpoint)
+	err = c.write(ctx, "HEL", body.Bytes())
+	if err != nil {
+		c.close()
+		return nil, err
+	}
+
+	messageType, ack, err := c.read(ctx)
+	if err != nil {
+		c.close()
+		return nil, err
+	}
+	if messageType != "ACK" {
+		c.close()
+		return nil, fmt.Errorf("expected ACK from the opcua server, got %s", messageType)
+	}
+	d := newDecoder(ack)
+	d.uint32()
+	// The receive buffer of the server
+	c.sendBufferSize = d.uint32()
+	if d.err != nil {
+		c.close()
+		return nil, d.err
+	}
+	return c, nil
+}
+
+func (c *client) close() {
+	c.conn.Close()
+}
+
+// write send one final chunk, the client request are small enough to fit in one
+func (c *client) write(ctx context.Context, messageType string, body []byte) error {
+	if c.sendBufferSize > 0 && uint32(len(body)+8) > c.sendBufferSize {
+		return fmt.Errorf("opcua request of %d byte is larger than the server buffer of %d byte", len(body)+8, c.sendBufferSize)
+	}
+	header := make([]byte, 8, 8+len(body))
+	copy(header, messageType)
+	header[3] = 'F'
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(body)+8))
+
+	c.conn.SetWriteDeadline(deadline(ctx))
+	_, err := c.conn.Write(append(header, body...))
+	return err
+}
+
+// read return one chunk, an ERR message from the server is returned as error
+func (c *client) read(ctx context.Context) (messageType string, body []byte, err error) {
+	messageType, _, body, err = c.readChunk(ctx)
+	return messageType, body, err
+}
+
+func (c *client) readChunk(ctx context.Context) (messageType string, chunk byte, body []byte, err error) {
+	c.conn.SetReadDeadline(deadline(ctx))
+	header := make([]byte, 8)
+	_, err = io.ReadFull(c.reader, header)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	size := binary.LittleEndian.Uint32(header[4:])
+	if size < 8 || size > receiveBufferSize {
+		return "", 0, nil, fmt.Errorf("opcua chunk of %d byte is outside the receive buffer", size)
+	}
+	body = make([]byte, size-8)
+	_, err = io.ReadFull(c.reader, body)
+	if err != nil {
+		return "", 0, nil, err
+	}
+
+	messageType = string(header[:3])
+	if messageType == "ERR" {
+		d := newDecoder(body)
+		status := d.uint32()
+		reason := d.string()
+		return "", 0, nil, fmt.Errorf("opcua server closed the connection with %s: %s", StatusText(status), reason)
+	}
+	return messageType, header[3], body, nil
+}
+
+func (c *client) requestHeader(e *encoder, timeout time.Duration) {
+	c.requestHandle++
+	e.nodeId(c.authToken)
+	e.dateTime(time.Now())
+	e.uint32(c.requestHandle)
+	// No diagnostic
+	e.uint32(0)
+	e.string("")
+	e.uint32(uint32(timeout / time.Millisecond))
+	e.extensionObject(0, nil)
+}
+
+// responseHeader read the header of the response and return the service result as error when it is bad
+func responseHeader(d *decoder) error {
+	d.dateTime()
+	d.uint32()
+	result := d.uint32()
+	d.skipDiagnosticInfo()
+	count := d.arrayLength()
+	for i := 0; i < count && d.err == nil; i++ {
+		d.string()
+	}
+	d.extensionObject()
+	if d.err != nil {
+		return d.err
+	}
+	if isBad(result) {
+		return &StatusError{Status: result}
+	}
+	return nil
+}
+
+// openSecureChannel issue a new secure channel, or renew the token of the opened one
+func (c *client) openSecureChannel(ctx context.Context, renew bool) error {
+	c.sequence++
+	c.requestId++
+	body := &encoder{}
+	body.uint32(c.channelId)
+	body.string(securityPolicyNone)
+	body.byteString(nil)
+	body.byteString(nil)
+	body.uint32(c.sequence)
+	body.uint32(c.requestId)
+	body.typeId(idOpenSecureChannelRequest)
+	c.requestHeader(body, requestTimeout)
+	body.uint32(0)
+	requestType := uint32(0)
+	if renew {
+		requestType = 1
+	}
+	body.uint32(requestType)
+	body.uint32(securityModeNone)
+	body.byteString(nil)
+	body.uint32(uint32(channelLifetime / time.Millisecond))
+	err := c.write(ctx, "OPN", body.Bytes())
+	if err != nil {
+		return err
+	}
+
+	messageType, response, err := c.read(ctx)
+	if err != nil {
+		return err
+	}
+	if messageType != "OPN" {
+		return fmt.Errorf("expected OPN from the opcua server, got %s", messageType)
+	}
+	d := newDecoder(response)
+	d.uint32()
+	d.string()
+	d.byteString()
+	d.byteString()
+	d.uint32()
+	d.uint32()
+	if id := d.typeId(); id != idOpenSecureChannelResponse && d.err == nil {
+		return fmt.Errorf("expected OpenSecureChannelResponse from the opcua server, got %d", id)
+	}
+	err = responseHeader(d)
+	if err != nil {
+		return err
+	}
+	d.uint32()
+	c.channelId = d.uint32()
+	c.tokenId = d.uint32()
+	d.dateTime()
+	lifetime := time.Duration(d.uint32()) * time.Millisecond
+	if d.err != nil {
+		return d.err
+	}
+	c.renewAt = time.Now().Add(time.Duration(float64(lifetime) * renewRatio))
+	return nil
+}
+
+// call send the service request and return the decoder after the header of its response
+func (c *client) call(ctx context.Context, requestId uint32, request []byte, responseId uint32) (*decoder, error) {
+	c.sequence++
+	c.requestId++
+	body := &encoder{}
+	body.uint32(c.channelId)
+	body.uint32(c.tokenId)
+	body.uint32(c.sequence)
+	body.uint32(c.requestId)
+	body.typeId(requestId)
+	body.Write(request)
+	err := c.write(ctx, "MSG", body.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	// A large response come in several chunk, each with its own security and sequence header
+	message := []byte{}
+	for {
+		messageType, chunk, response, err := c.readChunk(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if messageType != "MSG" {
+			return nil, fmt.Errorf("expected MSG from the opcua server, got %s", messageType)
+		}
+		d := newDecoder(response)
+		d.uint32()
+		d.uint32()
+		d.uint32()
+		responseRequestId := d.uint32()
+		if d.err != nil {
+			return nil, d.err
+		}
+		// The response of a request that timed out earlier
+		if responseRequestId != c.requestId {
+			continue
+		}
+		if chunk == 'A' {
+			status := d.uint32()
+			return nil, fmt.Errorf("opcua server aborted the response with %s: %s", StatusText(status), d.string())
+		}
+		message = append(message, response[d.pos:]...)
+		if len(message) > maxMessageSize {
+			return nil, fmt.Errorf("opcua response is larger than %d byte", maxMessageSize)
+		}
+		if chunk == 'F' {
+			break
+		}
+	}
+
+	d := newDecoder(message)
+	id := d.typeId()
+	if id == idServiceFault {
+		err = responseHeader(d)
+		if err == nil {
+			err = errors.New("opcua server responded a service fault")
+		}
+		return nil, err
+	}
+	if id != responseId && d.err == nil {
+		return nil, fmt.Errorf("expected response %d from the opcua server, got %d", responseId, id)
+	}
+	err = responseHeader(d)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// userTokenPolicy find the policy id of the identity token the server accept without security
+func userTokenPolicy(d *decoder, tokenType uint32) (policyId string, err error) {
+	found := false
+	endpoints := d.arrayLength()
+	for i := 0; i < endpoints && d.err == nil; i++ {
+		d.string()
+		// Server application description
+		d.string()
+		d.string()
+		d.localizedText()
+		d.uint32()
+		d.string()
+		d.string()
+		urls := d.arrayLength()
+		for j := 0; j < urls && d.err == nil; j++ {
+			d.string()
+		}
+		d.byteString()
+		securityMode := d.uint32()
+		d.string()
+
+		policies := d.arrayLength()
+		for j := 0; j < policies && d.err == nil; j++ {
+			id := d.string()
+			policyType := d.uint32()
+			d.string()
+			d.string()
+			securityPolicy := d.string()
+			// A password can only be sent in clear when the policy doesn't ask to encrypt it
+			if securityMode == securityModeNone && policyType == tokenType && !found &&
+				(tokenType == tokenAnonymous || securityPolicy == "" || securityPolicy == securityPolicyNone) {
+				policyId = id
+				found = true
+			}
+		}
+		d.string()
+		d.byte()
+	}
+	if d.err != nil {
+		return "", d.err
+	}
+	if !found {
+		if tokenType == tokenAnonymous {
+			return "", errors.New("opcua server has no anonymous login on an endpoint without security")
+		}
+		return "", errors.New("opcua server has no unencrypted username login on an endpoint without security")
+	}
+	return policyId, nil
+}
+
+// openSession create and activate the session, anonymous when username is empty
+func (c *client) openSession(ctx context.Context, name string, username string, password string) error {
+	nonce := make([]byte, 32)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return err
+	}
+
+	request := &encoder{}
+	c.requestHeader(request, requestTimeout)
+	request.string("urn:iot-server:client")
+	request.string("urn:iot-server")
+	request.localizedText("iot-server")
+	// Client application
+	request.uint32(1)
+	request.string("")
+	request.string("")
+	request.int32(-1)
+	request.string("")
+	request.string(c.endpoint)
+	request.string(name)
+	request.byteString(nonce)
+	request.byteString(nil)
+	request.double(float64(sessionTimeout / time.Millisecond))
+	request.uint32(maxMessageSize)
+	d, err := c.call(ctx, idCreateSessionRequest, request.Bytes(), idCreateSessionResponse)
+	if err != nil {
+		return err
+	}
+	d.nodeId()
+	authToken := d.nodeId()
+	d.double()
+	d.byteString()
+	d.byteString()
+	tokenType := uint32(tokenAnonymous)
+	if username != "" {
+		tokenType = tokenUserName
+	}
+	policyId, err := userTokenPolicy(d, tokenType)
+	if err != nil {
+		return err
+	}
+	c.authToken = authToken
+
+	token := &encoder{}
+	token.string(policyId)
+	tokenId := uint32(idAnonymousIdentityToken)
+	if username != "" {
+		tokenId = idUserNameIdentityToken
+		token.string(username)
+		token.byteString([]byte(password))
+		token.string("")
+	}
+	request = &encoder{}
+	c.requestHeader(request, requestTimeout)
+	// No client signature and software certificate
+	request.string("")
+	request.byteString(nil)
+	request.int32(-1)
+	request.int32(-1)
+	request.extensionObject(tokenId, token.Bytes())
+	request.string("")
+	request.byteString(nil)
+	_, err = c.call(ctx, idActivateSessionRequest, request.Bytes(), idActivateSessionResponse)
+	return err
+}
+
+// closeSession close the session with its subscription and the secure channel, the error is ignored
+// since the connection is closed anyway
+func (c *client) closeSession(ctx context.Context) {
+	request := &encoder{}
+	c.requestHeader(request, requestTimeout)
+	request.boolean(true)
+	c.call(ctx, idCloseSessionRequest, request.Bytes(), idCloseSessionResponse)
+
+	c.sequence++
+	c.requestId++
+	body := &encoder{}
+	body.uint32(c.channelId)
+	body.uint32(c.tokenId)
+	body.uint32(c.sequence)
+	body.uint32(c.requestId)
+	body.typeId(idCloseSecureChannelRequest)
+	c.requestHeader(body, requestTimeout)
+	c.write(ctx, "CLO", body.Bytes())
+}
+
+// createSubscription return the subscription id and how long the server may stay silent, its keep
+// alive interval
+func (c *client) createSubscription(ctx context.Context, publishingInterval time.Duration) (subscriptionId uint32, keepAlive time.Duration, err error) {
+	request := &encoder{}
+	c.requestHeader(request, requestTimeout)
+	request.double(float64(publishingInterval / time.Millisecond))
+	request.uint32(subscriptionLifetimeCount)
+	request.uint32(subscriptionKeepAliveCount)
+	// No limit of notification per publish
+	request.uint32(0)
+	request.boolean(true)
+	request.WriteByte(0)
+	d, err := c.call(ctx, idCreateSubscriptionRequest, request.Bytes(), idCreateSubscriptionResponse)
+	if err != nil {
+		return 0, 0, err
+	}
+	subscriptionId = d.uint32()
+	revisedInterval := d.double()
+	d.uint32()
+	revisedKeepAlive := d.uint32()
+	if d.err != nil {
+		return 0, 0, d.err
+	}
+	return subscriptionId, time.Duration(revisedInterval*float64(revisedKeepAlive)) * time.Millisecond, nil
+}
+
+// monitoredItem is a node to monitor, the client handle come back with each of its value
+type monitoredItem struct {
+	clientHandle uint32
+	node         NodeId
+}
+
+// createMonitoredItems return the monitored item id and status code of each item, in order
+func (c *client) createMonitoredItems(ctx context.Context, subscriptionId uint32, samplingInterval time.Duration, items []monitoredItem) (ids []uint32, statuses []uint32, err error) {
+	request := &encoder{}
+	c.requestHeader(request, requestTimeout)
+	request.uint32(subscriptionId)
+	request.uint32(timestampsSource)
+	request.int32(int32(len(items)))
+	for _, item := range items {
+		request.nodeId(item.node)
+		request.uint32(attributeValue)
+		request.string("")
+		request.uint16(0)
+		request.string("")
+		request.uint32(monitoringReporting)
+		request.uint32(item.clientHandle)
+		// A negative sampling interval sample at the publishing interval
+		if samplingInterval > 0 {
+			request.double(float64(samplingInterval / time.Millisecond))
+		} else {
+			request.double(-1)
+		}
+		request.extensionObject(0, nil)
+		// Only the latest value is kept between two publish
+		request.uint32(1)
+		request.boolean(true)
+	}
+	d, err := c.call(ctx, idCreateMonitoredItemsRequest, request.Bytes(), idCreateMonitoredItemsResponse)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	count := d.arrayLength()
+	for i := 0; i < count && d.err == nil; i++ {
+		statuses = append(statuses, d.uint32())
+		ids = append(ids, d.uint32())
+		d.double()
+		d.uint32()
+		d.extensionObject()
+	}
+	if d.err != nil {
+		return nil, nil, d.err
+	}
+	if len(ids) != len(items) {
+		return nil, nil, fmt.Errorf("opcua server returned %d result for %d monitored item", len(ids), len(items))
+	}
+	return ids, statuses, nil
+}
+
+func (c *client) deleteMonitoredItems(ctx context.Context, subscriptionId uint32, ids []uint32) error {
+	request := &encoder{}
+	c.requestHeader(request, requestTimeout)
+	request.uint32(subscriptionId)
+	request.int32(int32(len(ids)))
+	for _, id := range ids {
+		request.uint32(id)
+	}
+	_, err := c.call(ctx, idDeleteMonitoredItemsRequest, request.Bytes(), idDeleteMonitoredItemsResponse)
+	return err
+}
+
+// dataChange is a new value of a monitored item
+type dataChange struct {
+	clientHandle uint32
+	value        float64
+	status       uint32
+	numeric      bool
+}
+
+// publish acknowledge the previous notification message and wait for the next one, acknowledge is 0
+// when there is nothing to acknowledge. A keep alive return no change and a 0 sequence number
+func (c *client) publish(ctx context.Context, subscriptionId uint32, acknowledge uint32, timeout time.Duration) (changes []dataChange, sequenceNumber uint32, err error) {
+	request := &encoder{}
+	c.requestHeader(request, timeout)
+	if acknowledge == 0 {
+		request.int32(0)
+	} else {
+		request.int32(1)
+		request.uint32(subscriptionId)
+		request.uint32(acknowledge)
+	}
+	d, err := c.call(ctx, idPublishRequest, request.Bytes(), idPublishResponse)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	d.uint32()
+	available := d.arrayLength()
+	for i := 0; i < available && d.err == nil; i++ {
+		d.uint32()
+	}
+	d.boolean()
+	sequenceNumber = d.uint32()
+	d.dateTime()
+	notifications := d.arrayLength()
+	if notifications == 0 {
+		sequenceNumber = 0
+	}
+	for i := 0; i < notifications && d.err == nil; i++ {
+		id, body := d.extensionObject()
+		switch id {
+		case idDataChangeNotification:
+			notification := newDecoder(body)
+			count := notification.arrayLength()
+			for j := 0; j < count && notification.err == nil; j++ {
+				change := dataChange{clientHandle: notification.uint32()}
+				change.value, change.status, change.numeric = notification.dataValue()
+				changes = append(changes, change)
+			}
+			if notification.err != nil {
+				return nil, 0, notification.err
+			}
+		case idStatusChangeNotification:
+			notification := newDecoder(body)
+			status := notification.uint32()
+			if notification.err == nil && isBad(status) {
+				return nil, 0, fmt.Errorf("opcua subscription ended with %s", StatusText(status))
+			}
+		}
+	}
+	if d.err != nil {
+		return nil, 0, d.err
+	}
+	return changes, sequenceNumber, nil
+}
+
+// deadline return the deadline of the context, or the request timeout from now without one
+func deadline(ctx context.Context) time.Time {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return time.Now().Add(requestTimeout)
+	}
+	return deadline
+}
@@ -0,0 +1,526 @@
+package opcua
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DateTime is the count of 100 nanosecond since 1601-01-01, this is the count at the unix epoch
+const unixEpochTicks = 116444736000000000
+
+// Variant and diagnostic info nested deeper than this are a corrupted message, each level take a
+// single byte so a message of nested value would exhaust the stack
+const maxNesting = 100
+
+var errTruncated = errors.New("opcua message is truncated")
+
+// NodeId identify a node of the server address space. Type is 'i' for a numeric identifier, 's' for
+// a string, 'g' for a guid and 'b' for an opaque byte string
+type NodeId struct {
+	Namespace uint16
+	Type      byte
+	Numeric   uint32
+	// The string, the 16 byte guid in its binary encoding or the opaque byte string
+	Bytes []byte
+}
+
+// ParseNodeId parse the node id in its string notation, e.g. ns=2;s=Line1.Temperature or i=2258
+func ParseNodeId(text string) (NodeId, error) {
+	node := NodeId{}
+	identifier := text
+	if strings.HasPrefix(identifier, "ns=") {
+		separator := strings.Index(identifier, ";")
+		if separator < 0 {
+			return node, fmt.Errorf("node id %q has a namespace without identifier", text)
+		}
+		namespace, err := strconv.ParseUint(identifier[3:separator], 10, 16)
+		if err != nil {
+			return node, fmt.Errorf("node id %q has an invalid namespace", text)
+		}
+		node.Namespace = uint16(namespace)
+		identifier = identifier[separator+1:]
+	}
+
+	if len(identifier) < 3 || identifier[1] != '=' {
+		return node, fmt.Errorf("node id %q must be i=, s=, g= or b= optionally preceded by ns=", text)
+	}
+	node.Type = identifier[0]
+	value := identifier[2:]
+	switch node.Type {
+	case 'i':
+		numeric, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return node, fmt.Errorf("node id %q has an invalid numeric identifier", text)
+		}
+		node.Numeric = uint32(numeric)
+	case 's':
+		node.Bytes = []byte(value)
+	case 'g':
+		guid, err := hex.DecodeString(strings.ReplaceAll(value, "-", ""))
+		if err != nil || len(guid) != 16 || len(value) != 36 {
+			return node, fmt.Errorf("node id %q has an invalid guid", text)
+		}
+		// The first three group of the guid are little endian in the binary encoding
+		node.Bytes = []byte{
+			guid[3], guid[2], guid[1], guid[0],
+			guid[5], guid[4],
+			guid[7], guid[6],
+		}
+		node.Bytes = append(node.Bytes, guid[8:]...)
+	case 'b':
+		opaque, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return node, fmt.Errorf("node id %q has an invalid base64 identifier", text)
+		}
+		node.Bytes = opaque
+	default:
+		return node, fmt.Errorf("node id %q must be i=, s=, g= or b= optionally preceded by ns=", text)
+	}
+	return node, nil
+}
+
+// encoder write the OPC-UA binary encoding, every number is little endian
+type encoder struct {
+	bytes.Buffer
+}
+
+func (e *encoder) uint16(value uint16) {
+	binary.Write(e, binary.LittleEndian, value)
+}
+
+func (e *encoder) uint32(value uint32) {
+	binary.Write(e, binary.LittleEndian, value)
+}
+
+func (e *encoder) int32(value int32) {
+	binary.Write(e, binary.LittleEndian, value)
+}
+
+func (e *encoder) double(value float64) {
+	binary.Write(e, binary.LittleEndian, value)
+}
+
+func (e *encoder) boolean(value bool) {
+	if value {
+		e.WriteByte(1)
+		return
+	}
+	e.WriteByte(0)
+}
+
+// string write an empty string as the null string
+func (e *encoder) string(value string) {
+	if value == "" {
+		e.int32(-1)
+		return
+	}
+	e.int32(int32(len(value)))
+	e.WriteString(value)
+}
+
+func (e *encoder) byteString(value []byte) {
+	if value == nil {
+		e.int32(-1)
+		return
+	}
+	e.int32(int32(len(value)))
+	e.Write(value)
+}
+
+// dateTime write the zero time as 0, the null DateTime
+func (e *encoder) dateTime(value time.Time) {
+	if value.IsZero() {
+		binary.Write(e, binary.LittleEndian, int64(0))
+		return
+	}
+	binary.Write(e, binary.LittleEndian, value.UnixNano()/100+unixEpochTicks)
+}
+
+// nodeId write the node in its most compact encoding
+func (e *encoder) nodeId(node NodeId) {
+	switch node.Type {
+	case 's':
+		e.WriteByte(0x03)
+		e.uint16(node.Namespace)
+		e.int32(int32(len(node.Bytes)))
+		e.Write(node.Bytes)
+	case 'g':
+		e.WriteByte(0x04)
+		e.uint16(node.Namespace)
+		e.Write(node.Bytes)
+	case 'b':
+		e.WriteByte(0x05)
+		e.uint16(node.Namespace)
+		e.byteString(node.Bytes)
+	default:
+		switch {
+		case node.Namespace == 0 && node.Numeric <= math.MaxUint8:
+			e.WriteByte(0x00)
+			e.WriteByte(byte(node.Numeric))
+		case node.Namespace <= math.MaxUint8 && node.Numeric <= math.MaxUint16:
+			e.WriteByte(0x01)
+			e.WriteByte(byte(node.Namespace))
+			e.uint16(uint16(node.Numeric))
+		default:
+			e.WriteByte(0x02)
+			e.uint16(node.Namespace)
+			e.uint32(node.Numeric)
+		}
+	}
+}
+
+// typeId write the numeric node of namespace 0 identifying the encoding of a structure
+func (e *encoder) typeId(id uint32) {
+	e.nodeId(NodeId{Type: 'i', Numeric: id})
+}
+
+// extensionObject write the structure with its encoding id, a 0 id is the null extension object
+func (e *encoder) extensionObject(id uint32, body []byte) {
+	e.typeId(id)
+	if id == 0 {
+		e.WriteByte(0x00)
+		return
+	}
+	e.WriteByte(0x01)
+	e.byteString(body)
+}
+
+// localizedText write the text without locale
+func (e *encoder) localizedText(text string) {
+	e.WriteByte(0x02)
+	e.string(text)
+}
+
+// decoder read the OPC-UA binary encoding, the first error stick and every read after it return zero
+type decoder struct {
+	data  []byte
+	pos   int
+	err   error
+	depth int
+}
+
+func newDecoder(data []byte) *decoder {
+	return &decoder{data: data}
+}
+
+func (d *decoder) next(size int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if size < 0 || d.pos+size > len(d.data) {
+		d.err = errTruncated
+		return nil
+	}
+	value := d.data[d.pos : d.pos+size]
+	d.pos += size
+	return value
+}
+
+// nest enter a nested value, false when it is nested too deep
+func (d *decoder) nest() bool {
+	if d.depth >= maxNesting {
+		if d.err == nil {
+			d.err = errors.New("opcua message has a value nested too deep")
+		}
+		return false
+	}
+	d.depth++
+	return true
+}
+
+func (d *decoder) byte() byte {
+	value := d.next(1)
+	if value == nil {
+		return 0
+	}
+	return value[0]
+}
+
+func (d *decoder) boolean() bool {
+	return d.byte() != 0
+}
+
+func (d *decoder) uint16() uint16 {
+	value := d.next(2)
+	if value == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(value)
+}
+
+func (d *decoder) uint32() uint32 {
+	value := d.next(4)
+	if value == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(value)
+}
+
+func (d *decoder) int32() int32 {
+	return int32(d.uint32())
+}
+
+func (d *decoder) uint64() uint64 {
+	value := d.next(8)
+	if value == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(value)
+}
+
+func (d *decoder) double() float64 {
+	return math.Float64frombits(d.uint64())
+}
+
+// byteString return nil for the null byte string
+func (d *decoder) byteString() []byte {
+	length := d.int32()
+	if length < 0 {
+		return nil
+	}
+	return d.next(int(length))
+}
+
+func (d *decoder) string() string {
+	return string(d.byteString())
+}
+
+func (d *decoder) dateTime() time.Time {
+	ticks := int64(d.uint64())
+	if ticks == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, (ticks-unixEpochTicks)*100).UTC()
+}
+
+// arrayLength return the element count of an array, 0 for the null array
+func (d *decoder) arrayLength() int {
+	length := int(d.int32())
+	if length < 0 {
+		return 0
+	}
+	// Every element take at least a byte, a bigger count is a corrupted message
+	if length > len(d.data)-d.pos {
+		d.err = errTruncated
+		return 0
+	}
+	return length
+}
+
+// nodeId read a node id or an expanded node id, the namespace uri and server index are skipped
+func (d *decoder) nodeId() NodeId {
+	node := NodeId{Type: 'i'}
+	mask := d.byte()
+	switch mask & 0x0f {
+	case 0x00:
+		node.Numeric = uint32(d.byte())
+	case 0x01:
+		node.Namespace = uint16(d.byte())
+		node.Numeric = uint32(d.uint16())
+	case 0x02:
+		node.Namespace = d.uint16()
+		node.Numeric = d.uint32()
+	case 0x03:
+		node.Type = 's'
+		node.Namespace = d.uint16()
+		node.Bytes = d.byteString()
+	case 0x04:
+		node.Type = 'g'
+		node.Namespace = d.uint16()
+		node.Bytes = d.next(16)
+	case 0x05:
+		node.Type = 'b'
+		node.Namespace = d.uint16()
+		node.Bytes = d.byteString()
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("unknown node id encoding 0x%02x", mask)
+		}
+	}
+	if mask&0x80 != 0 {
+		d.string()
+	}
+	if mask&0x40 != 0 {
+		d.uint32()
+	}
+	return node
+}
+
+// typeId read the node identifying the encoding of a structure, 0 when it isn't a namespace 0 number
+func (d *decoder) typeId() uint32 {
+	node := d.nodeId()
+	if node.Type != 'i' || node.Namespace != 0 {
+		return 0
+	}
+	return node.Numeric
+}
+
+// extensionObject return the encoding id and the binary body of the structure
+func (d *decoder) extensionObject() (id uint32, body []byte) {
+	id = d.typeId()
+	switch d.byte() {
+	case 0x01, 0x02:
+		body = d.byteString()
+	}
+	return id, body
+}
+
+// localizedText return the text without its locale
+func (d *decoder) localizedText() string {
+	mask := d.byte()
+	if mask&0x01 != 0 {
+		d.string()
+	}
+	if mask&0x02 != 0 {
+		return d.string()
+	}
+	return ""
+}
+
+func (d *decoder) skipDiagnosticInfo() {
+	if !d.nest() {
+		return
+	}
+	defer func() { d.depth-- }()
+	mask := d.byte()
+	// Symbolic id, namespace uri, localized text and locale are index in the string table
+	for _, bit := range []byte{0x01, 0x02, 0x04, 0x08} {
+		if mask&bit != 0 {
+			d.int32()
+		}
+	}
+	if mask&0x10 != 0 {
+		d.string()
+	}
+	if mask&0x20 != 0 {
+		d.uint32()
+	}
+	if mask&0x40 != 0 {
+		d.skipDiagnosticInfo()
+	}
+}
+
+func (d *decoder) skipDiagnosticInfos() {
+	count := d.arrayLength()
+	for i := 0; i < count && d.err == nil; i++ {
+		d.skipDiagnosticInfo()
+	}
+}
+
+// dataValue return the numeric value and the status code of the value, numeric is false for a value
+// that isn't a number (a string, an array...) or a data value without value
+func (d *decoder) dataValue() (value float64, status uint32, numeric bool) {
+	mask := d.byte()
+	if mask&0x01 != 0 {
+		value, numeric = d.variant()
+	}
+	if mask&0x02 != 0 {
+		status = d.uint32()
+	}
+	if mask&0x04 != 0 {
+		d.dateTime()
+	}
+	if mask&0x10 != 0 {
+		d.uint16()
+	}
+	if mask&0x08 != 0 {
+		d.dateTime()
+	}
+	if mask&0x20 != 0 {
+		d.uint16()
+	}
+	return value, status, numeric
+}
+
+// variant return the value of a numeric scalar variant, any other variant is read and skipped
+func (d *decoder) variant() (value float64, numeric bool) {
+	if !d.nest() {
+		return 0, false
+	}
+	defer func() { d.depth-- }()
+	mask := d.byte()
+	builtinType := mask & 0x3f
+	if mask&0x80 == 0 {
+		return d.scalar(builtinType)
+	}
+
+	count := d.arrayLength()
+	for i := 0; i < count && d.err == nil; i++ {
+		d.scalar(builtinType)
+	}
+	if mask&0x40 != 0 {
+		dimensions := d.arrayLength()
+		for i := 0; i < dimensions && d.err == nil; i++ {
+			d.int32()
+		}
+	}
+	return 0, false
+}
+
+// scalar read one value of the builtin type, numeric is false for a type that isn't a number
+func (d *decoder) scalar(builtinType byte) (value float64, numeric bool) {
+	switch builtinType {
+	case 0:
+	case 1:
+		if d.boolean() {
+			return 1, true
+		}
+		return 0, true
+	case 2:
+		return float64(int8(d.byte())), true
+	case 3:
+		return float64(d.byte()), true
+	case 4:
+		return float64(int16(d.uint16())), true
+	case 5:
+		return float64(d.uint16()), true
+	case 6:
+		return float64(d.int32()), true
+	case 7:
+		return float64(d.uint32()), true
+	case 8:
+		return float64(int64(d.uint64())), true
+	case 9:
+		return float64(d.uint64()), true
+	case 10:
+		return float64(math.Float32frombits(d.uint32())), true
+	case 11:
+		return d.double(), true
+	case 12, 15, 16:
+		d.byteString()
+	case 13:
+		d.uint64()
+	case 14:
+		d.next(16)
+	case 17, 18:
+		d.nodeId()
+	case 19:
+		d.uint32()
+	case 20:
+		d.uint16()
+		d.string()
+	case 21:
+		d.localizedText()
+	case 22:
+		d.extensionObject()
+	case 23:
+		d.dataValue()
+	case 24:
+		d.variant()
+	case 25:
+		d.skipDiagnosticInfo()
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("unknown variant type %d", builtinType)
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,346 @@
+package opcua
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseNodeId(t *testing.T) {
+	tests := []struct {
+		text string
+		node NodeId
+		fail bool
+	}{
+		{"i=2258", NodeId{Type: 'i', Numeric: 2258}, false},
+		{"ns=2;i=4294967295", NodeId{Namespace: 2, Type: 'i', Numeric: math.MaxUint32}, false},
+		{"ns=2;s=Line1.Temperature", NodeId{Namespace: 2, Type: 's', Bytes: []byte("Line1.Temperature")}, false},
+		{"ns=1;s=a;b=c", NodeId{Namespace: 1, Type: 's', Bytes: []byte("a;b=c")}, false},
+		{"g=72962B91-FA75-4AE6-8D28-B404DC7DAF63", NodeId{Type: 'g', Bytes: mustHex("912b967275fae64a8d28b404dc7daf63")}, false},
+		{"ns=3;b=AQID", NodeId{Namespace: 3, Type: 'b', Bytes: []byte{1, 2, 3}}, false},
+		{"", NodeId{}, true},
+		{"i=", NodeId{}, true},
+		{"x=1", NodeId{}, true},
+		{"ns=2", NodeId{}, true},
+		{"ns=65536;i=1", NodeId{}, true},
+		{"i=-1", NodeId{}, true},
+		{"i=4294967296", NodeId{}, true},
+		{"g=72962B91FA754AE68D28B404DC7DAF63", NodeId{}, true},
+		{"g=72962B91-FA75-4AE6-8D28-B404DC7DAF", NodeId{}, true},
+		{"b=!!", NodeId{}, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.text, func(t *testing.T) {
+			node, err := ParseNodeId(tc.text)
+			if (err != nil) != tc.fail {
+				t.Fatalf("got error %v, want failure %v", err, tc.fail)
+			}
+			if err == nil && (node.Namespace != tc.node.Namespace || node.Type != tc.node.Type || node.Numeric != tc.node.Numeric || !bytes.Equal(node.Bytes, tc.node.Bytes)) {
+				t.Fatalf("got %+v, want %+v", node, tc.node)
+			}
+		})
+	}
+}
+
+func mustHex(text string) []byte {
+	data, err := hex.DecodeString(text)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+func TestNodeIdRoundTrip(t *testing.T) {
+	tests := []struct {
+		text string
+		data string
+	}{
+		// Two byte, four byte and numeric encoding
+		{"i=85", "0055"},
+		{"ns=2;i=1001", "0102e903"},
+		{"i=70000", "02000070110100"},
+		{"ns=300;i=1", "022c0101000000"},
+		{"ns=2;s=Temp", "030200" + "04000000" + "54656d70"},
+		{"ns=1;g=72962B91-FA75-4AE6-8D28-B404DC7DAF63", "040100" + "912b967275fae64a8d28b404dc7daf63"},
+		{"b=AQID", "050000" + "03000000" + "010203"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.text, func(t *testing.T) {
+			node, err := ParseNodeId(tc.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e := &encoder{}
+			e.nodeId(node)
+			if hex.EncodeToString(e.Bytes()) != tc.data {
+				t.Fatalf("encoded %x, want %s", e.Bytes(), tc.data)
+			}
+			d := newDecoder(e.Bytes())
+			decoded := d.nodeId()
+			if d.err != nil || d.pos != e.Len() {
+				t.Fatalf("decoded %d of %d byte with %v", d.pos, e.Len(), d.err)
+			}
+			if decoded.Namespace != node.Namespace || decoded.Type != node.Type || decoded.Numeric != node.Numeric || !bytes.Equal(decoded.Bytes, node.Bytes) {
+				t.Fatalf("decoded %+v, want %+v", decoded, node)
+			}
+		})
+	}
+}
+
+func TestExpandedNodeId(t *testing.T) {
+	// The namespace uri and the server index of an expanded node id are skipped
+	tests := []string{
+		"8055" + "04000000" + "75726e3a",
+		"4055" + "01000000",
+		"c055" + "ffffffff" + "01000000",
+	}
+	for _, data := range tests {
+		d := newDecoder(mustHex(data))
+		if id := d.typeId(); id != 85 || d.err != nil || d.pos != len(d.data) {
+			t.Errorf("decoding %s got %d with %v at %d", data, id, d.err, d.pos)
+		}
+	}
+
+	// A string node isn't a type id
+	d := newDecoder(mustHex("030000" + "01000000" + "61"))
+	if id := d.typeId(); id != 0 || d.err != nil {
+		t.Errorf("a string node is type id %d with %v", id, d.err)
+	}
+}
+
+func TestPrimitiveRoundTrip(t *testing.T) {
+	at := time.Date(2024, 5, 1, 10, 0, 0, 123456700, time.UTC)
+	e := &encoder{}
+	e.uint16(0xbeef)
+	e.uint32(0xdeadbeef)
+	e.int32(-2)
+	e.double(-21.5)
+	e.boolean(true)
+	e.boolean(false)
+	e.string("")
+	e.string("opc.tcp://plc:4840")
+	e.byteString(nil)
+	e.byteString([]byte{})
+	e.byteString([]byte{1, 2})
+	e.dateTime(at)
+	e.dateTime(time.Time{})
+	e.localizedText("Temperature")
+	e.extensionObject(0, nil)
+	e.extensionObject(idDataChangeNotification, []byte{0, 0, 0, 0})
+	encoded := e.Bytes()
+
+	// read decode the value in the order they were encoded and check them
+	read := func(d *decoder) error {
+		checks := []bool{
+			d.uint16() == 0xbeef,
+			d.uint32() == 0xdeadbeef,
+			d.int32() == -2,
+			d.double() == -21.5,
+			d.boolean(),
+			!d.boolean(),
+			d.string() == "",
+			d.string() == "opc.tcp://plc:4840",
+			d.byteString() == nil,
+		}
+		empty := d.byteString()
+		checks = append(checks, empty != nil && len(empty) == 0, bytes.Equal(d.byteString(), []byte{1, 2}))
+		checks = append(checks, d.dateTime().Equal(at), d.dateTime().IsZero(), d.localizedText() == "Temperature")
+		id, body := d.extensionObject()
+		checks = append(checks, id == 0 && body == nil)
+		id, body = d.extensionObject()
+		checks = append(checks, id == idDataChangeNotification && bytes.Equal(body, []byte{0, 0, 0, 0}))
+		if d.err != nil {
+			return d.err
+		}
+		for i, ok := range checks {
+			if !ok {
+				return fmt.Errorf("value %d is decoded wrong", i)
+			}
+		}
+		return nil
+	}
+	d := newDecoder(encoded)
+	err := read(d)
+	if err != nil || d.pos != len(encoded) {
+		t.Fatalf("decoded %d of %d byte with %v", d.pos, len(encoded), err)
+	}
+
+	// Every truncated message stop at the first value it can't read, and the error stick
+	for size := 0; size < len(encoded); size++ {
+		d := newDecoder(encoded[:size])
+		err := read(d)
+		if err != errTruncated {
+			t.Fatalf("decoding %d of %d byte got %v, want %v", size, len(encoded), err, errTruncated)
+		}
+	}
+}
+
+func TestDecoderMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		read func(d *decoder)
+	}{
+		{"negative length", "feffffff", func(d *decoder) { d.next(-2) }},
+		{"byte string longer than message", "05000000" + "0102", func(d *decoder) { d.byteString() }},
+		{"array longer than message", "ffffff7f" + "00", func(d *decoder) { d.arrayLength() }},
+		{"unknown node id encoding", "0600", func(d *decoder) { d.nodeId() }},
+		{"truncated guid", "040000" + "0102030405060708", func(d *decoder) { d.nodeId() }},
+		{"truncated namespace uri", "8055" + "05000000" + "75", func(d *decoder) { d.nodeId() }},
+		{"unknown variant type", "1a", func(d *decoder) { d.variant() }},
+		{"variant array of unknown type", "9a" + "01000000" + "00", func(d *decoder) { d.variant() }},
+		{"truncated dimensions", "c6" + "01000000" + "02000000" + "02000000", func(d *decoder) { d.variant() }},
+		{"truncated diagnostic info", "10" + "05000000", func(d *decoder) { d.skipDiagnosticInfo() }},
+		{"truncated data value", "05" + "0b" + "00000000", func(d *decoder) { d.dataValue() }},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newDecoder(mustHex(tc.data))
+			tc.read(d)
+			if d.err == nil {
+				t.Fatalf("decoding %s should fail", tc.data)
+			}
+		})
+	}
+}
+
+func TestDecoderNesting(t *testing.T) {
+	tests := []struct {
+		name   string
+		level  []byte
+		last   []byte
+		decode func(d *decoder)
+	}{
+		// A variant of variant, and a diagnostic info with an inner diagnostic info
+		{"variant", []byte{0x18}, []byte{0x00}, func(d *decoder) { d.variant() }},
+		{"data value", []byte{0x17, 0x01}, []byte{0x00}, func(d *decoder) { d.variant() }},
+		{"diagnostic info", []byte{0x40}, []byte{0x00}, func(d *decoder) { d.skipDiagnosticInfo() }},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, depth := range []int{maxNesting, maxNesting + 1, 1 << 20} {
+				data := append(bytes.Repeat(tc.level, depth-1), tc.last...)
+				d := newDecoder(data)
+				tc.decode(d)
+				if (d.err != nil) != (depth > maxNesting) {
+					t.Fatalf("decoding %d level got %v", depth, d.err)
+				}
+				if d.depth != 0 {
+					t.Fatalf("depth is %d after decoding", d.depth)
+				}
+			}
+		})
+	}
+}
+
+// dataValue return the data value of the variant with its status and both timestamp
+func dataValue(variant []byte) []byte {
+	e := &encoder{}
+	e.WriteByte(0x01 | 0x02 | 0x04 | 0x08 | 0x10 | 0x20)
+	e.Write(variant)
+	e.uint32(0x40000000)
+	e.dateTime(time.Unix(1714557600, 0))
+	e.uint16(10)
+	e.dateTime(time.Unix(1714557601, 0))
+	e.uint16(20)
+	return e.Bytes()
+}
+
+func TestDataValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		variant string
+		value   float64
+		numeric bool
+	}{
+		{"null", "00", 0, false},
+		{"boolean", "0101", 1, true},
+		{"sbyte", "02fb", -5, true},
+		{"byte", "03c8", 200, true},
+		{"int16", "04d4fe", -300, true},
+		{"uint16", "05e803", 1000, true},
+		{"int32", "06feffffff", -2, true},
+		{"uint32", "07ffffffff", math.MaxUint32, true},
+		{"int64", "08" + "0000000000000080", math.MinInt64, true},
+		{"uint64", "09" + "0000000001000000", 1 << 32, true},
+		{"float", "0a" + "0000ac41", 21.5, true},
+		{"double", "0b" + "000000000000f0bf", -1, true},
+		{"string", "0c" + "020000003231", 0, false},
+		{"datetime", "0d" + "0000000000000000", 0, false},
+		{"guid", "0e" + "912b967275fae64a8d28b404dc7daf63", 0, false},
+		{"node id", "11" + "0055", 0, false},
+		{"status code", "13" + "00003480", 0, false},
+		{"qualified name", "14" + "0100" + "0100000061", 0, false},
+		{"localized text", "15" + "03" + "0200000065" + "6e" + "0100000061", 0, false},
+		{"extension object", "16" + "00" + "5f" + "01" + "0100000000", 0, false},
+		{"variant", "18" + "0b" + "0000000000003540", 0, false},
+		{"diagnostic info", "19" + "30" + "0100000061" + "00000000", 0, false},
+		{"array", "8b" + "02000000" + "000000000000f03f" + "0000000000000040", 0, false},
+		{"matrix", "c3" + "04000000" + "01020304" + "02000000" + "02000000" + "02000000", 0, false},
+		{"null array", "8b" + "ffffffff", 0, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			data := dataValue(mustHex(tc.variant))
+			d := newDecoder(data)
+			value, status, numeric := d.dataValue()
+			if d.err != nil || d.pos != len(data) {
+				t.Fatalf("decoded %d of %d byte with %v", d.pos, len(data), d.err)
+			}
+			if value != tc.value || numeric != tc.numeric || status != 0x40000000 {
+				t.Fatalf("got %v %v and status %x, want %v %v", value, numeric, status, tc.value, tc.numeric)
+			}
+		})
+	}
+
+	// A data value with only a bad status has no value
+	d := newDecoder(mustHex("02" + "00003480"))
+	value, status, numeric := d.dataValue()
+	if d.err != nil || value != 0 || numeric || status != 0x80340000 {
+		t.Fatalf("got %v %v and status %x with %v", value, numeric, status, d.err)
+	}
+}
+
+// FuzzDataValue check a data value from the server never panic nor read past the message
+func FuzzDataValue(f *testing.F) {
+	f.Add(dataValue(mustHex("0b" + "000000000000f0bf")))
+	f.Add(dataValue(mustHex("c3" + "04000000" + "01020304" + "02000000" + "02000000" + "02000000")))
+	f.Add(dataValue(mustHex("19" + "70" + "0100000061" + "00000000" + "00")))
+	f.Add(dataValue(mustHex("17" + "01" + "18" + "0101")))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d := newDecoder(data)
+		d.dataValue()
+		if d.pos > len(data) || d.depth != 0 {
+			t.Fatalf("decoder is at %d of %d byte and depth %d", d.pos, len(data), d.depth)
+		}
+	})
+}
+
+// FuzzNodeId check a decoded node is encoded back to the same node, the encoding may be more compact
+func FuzzNodeId(f *testing.F) {
+	f.Add(mustHex("0055"))
+	f.Add(mustHex("022c0101000000"))
+	f.Add(mustHex("030200" + "04000000" + "54656d70"))
+	f.Add(mustHex("040100" + "912b967275fae64a8d28b404dc7daf63"))
+	f.Add(mustHex("c055" + "ffffffff" + "01000000"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d := newDecoder(data)
+		node := d.nodeId()
+		if d.err != nil {
+			return
+		}
+		e := &encoder{}
+		e.nodeId(node)
+		again := newDecoder(e.Bytes())
+		decoded := again.nodeId()
+		if again.err != nil || again.pos != e.Len() {
+			t.Fatalf("%+v encoded as %x can't be decoded: %v", node, e.Bytes(), again.err)
+		}
+		if decoded.Namespace != node.Namespace || decoded.Type != node.Type || decoded.Numeric != node.Numeric || !bytes.Equal(decoded.Bytes, node.Bytes) {
+			t.Fatalf("%+v encoded back as %+v", node, decoded)
+		}
+	})
+}
@@ -0,0 +1,316 @@
+// Package opcua subscribe to the node of an OPC-UA server, e.g. a PLC on the factory floor, and store
+// each value change in the sensor channel mapped to the node.
+package opcua
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/ingest"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	dialTimeout    = 10 * time.Second
+	requestTimeout = 10 * time.Second
+	// Requested lifetime of the secure channel token and the session
+	channelLifetime = time.Hour
+	sessionTimeout  = time.Minute
+	// The server send a keep alive after this many empty publishing interval, and drop the
+	// subscription after this many without publish request
+	subscriptionKeepAliveCount = 10
+	subscriptionLifetimeCount  = 60
+	defaultPublishingInterval  = time.Second
+	// The item mapping change is picked up after at most this long
+	reloadInterval = time.Minute
+	// The server connection is retried this long after it failed
+	reconnectDelay = 10 * time.Second
+	// An instance not holding the lock try to take it over every this long
+	lockRetryDelay = 30 * time.Second
+	// Monitored item created per request
+	itemBatchSize = 100
+	lockName      = "opcua_subscriber"
+)
+
+// Subscriber monitor every mapped item on one OPC-UA server. Only the instance holding a postgres
+// advisory lock subscribe, the other take over when it stop
+type Subscriber struct {
+	db                 *pgxpool.Pool
+	repository         *repositories.OpcuaRepository
+	pipeline           *ingest.Pipeline
+	endpoint           string
+	address            string
+	username           string
+	password           string
+	sessionName        string
+	publishingInterval time.Duration
+	samplingInterval   time.Duration
+	lastError          string
+}
+
+// monitoredState is an item monitored by the server, or an item with an invalid node id when its
+// monitored item id is 0
+type monitoredState struct {
+	item        entities.OpcuaItem
+	monitoredId uint32
+}
+
+// NewSubscriber return nil when opcua.endpoint is empty
+func NewSubscriber(db *pgxpool.Pool, opcuaRepository *repositories.OpcuaRepository, pipeline *ingest.Pipeline, config *configs.Config, instanceId string) (*Subscriber, error) {
+	if config.Opcua.Endpoint == "" {
+		return nil, nil
+	}
+	endpointUrl, err := url.Parse(config.Opcua.Endpoint)
+	if err != nil || endpointUrl.Scheme != "opc.tcp" || endpointUrl.Hostname() == "" {
+		return nil, fmt.Errorf("invalid opcua endpoint %q, use opc.tcp://host:4840", config.Opcua.Endpoint)
+	}
+	port := endpointUrl.Port()
+	if port == "" {
+		port = "4840"
+	}
+
+	subscriber := &Subscriber{
+		db:                 db,
+		repository:         opcuaRepository,
+		pipeline:           pipeline,
+		endpoint:           config.Opcua.Endpoint,
+		address:            net.JoinHostPort(endpointUrl.Hostname(), port),
+		username:           config.Opcua.Username,
+		password:           config.Opcua.Password,
+		sessionName:        "iot-server-" + instanceId,
+		publishingInterval: time.Duration(config.Opcua.PublishingIntervalMs) * time.Millisecond,
+		samplingInterval:   time.Duration(config.Opcua.SamplingIntervalMs) * time.Millisecond,
+	}
+	if subscriber.publishingInterval <= 0 {
+		subscriber.publishingInterval = defaultPublishingInterval
+	}
+	return subscriber, nil
+}
+
+// Start subscribe in background until the context is canceled, a nil subscriber does nothing
+func (s *Subscriber) Start(ctx context.Context) {
+	if s == nil {
+		return
+	}
+	go s.run(ctx)
+}
+
+func (s *Subscriber) run(ctx context.Context) {
+	for {
+		locked, err := s.runLocked(ctx)
+		delay := reconnectDelay
+		if !locked {
+			delay = lockRetryDelay
+		}
+		// The server being down would log every reconnect otherwise
+		if err != nil && err.Error() != s.lastError {
+			log.Printf("[OPCUA] Error subscribing to %s, retrying every %s: %v", s.endpoint, delay, err)
+		}
+		if err != nil {
+			s.lastError = err.Error()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// runLocked subscribe while holding the lock, locked is false when another instance hold it
+func (s *Subscriber) runLocked(ctx context.Context) (locked bool, err error) {
+	conn, err := s.db.Acquire(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Release()
+
+	err = conn.QueryRow(ctx, `SELECT pg_try_advisory_lock(hashtext($1))`, lockName).Scan(&locked)
+	if err != nil || !locked {
+		return false, err
+	}
+	defer func() {
+		_, err := conn.Exec(context.Background(), `SELECT pg_advisory_unlock(hashtext($1))`, lockName)
+		if err != nil {
+			log.Printf("[OPCUA] Can't release lock: %v", err)
+		}
+	}()
+
+	return true, s.subscribe(ctx, conn)
+}
+
+// subscribe monitor the item until the connection to the server or to the lock fail
+func (s *Subscriber) subscribe(ctx context.Context, lock *pgxpool.Conn) error {
+	c, err := dial(ctx, s.endpoint, s.address)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	err = c.openSecureChannel(ctx, false)
+	if err != nil {
+		return err
+	}
+	err = c.openSession(ctx, s.sessionName, s.username, s.password)
+	if err != nil {
+		return err
+	}
+	defer c.closeSession(ctx)
+
+	subscriptionId, keepAlive, err := c.createSubscription(ctx, s.publishingInterval)
+	if err != nil {
+		return err
+	}
+	log.Printf("[OPCUA] Subscribed to %s", s.endpoint)
+	s.lastError = ""
+
+	monitored := map[int]*monitoredState{}
+	var reloadAt time.Time
+	var acknowledge uint32
+	for {
+		now := time.Now()
+		if !now.Before(reloadAt) {
+			// Another instance take the lock when its connection is gone
+			err = lock.Ping(ctx)
+			if err != nil {
+				return fmt.Errorf("lost the lock connection: %w", err)
+			}
+			err = s.reload(ctx, c, subscriptionId, monitored)
+			if err != nil {
+				return err
+			}
+			reloadAt = now.Add(reloadInterval)
+		}
+		if !now.Before(c.renewAt) {
+			err = c.openSecureChannel(ctx, true)
+			if err != nil {
+				return err
+			}
+		}
+
+		// The server answer at the latest with a keep alive
+		timeout := keepAlive + requestTimeout
+		publishCtx, cancel := context.WithTimeout(ctx, timeout)
+		changes, sequenceNumber, err := c.publish(publishCtx, subscriptionId, acknowledge, timeout)
+		cancel()
+		if err != nil {
+			return err
+		}
+		acknowledge = sequenceNumber
+		s.store(ctx, changes, monitored)
+	}
+}
+
+// reload monitor the new item and stop monitoring the removed one. An item refused by the server
+// isn't kept as monitored so it is tried again on the next reload
+func (s *Subscriber) reload(ctx context.Context, c *client, subscriptionId uint32, monitored map[int]*monitoredState) error {
+	items, err := s.repository.GetAll(ctx, s.db)
+	if err != nil {
+		return err
+	}
+	current := map[int]entities.OpcuaItem{}
+	for _, item := range items {
+		current[item.IdItem] = item
+	}
+
+	removed := []uint32{}
+	for id, state := range monitored {
+		item, ok := current[id]
+		if ok && item.NodeId == state.item.NodeId {
+			// The sensor may have been moved to another user
+			state.item = item
+			continue
+		}
+		if state.monitoredId != 0 {
+			removed = append(removed, state.monitoredId)
+		}
+		delete(monitored, id)
+	}
+	for start := 0; start < len(removed); start += itemBatchSize {
+		end := start + itemBatchSize
+		if end > len(removed) {
+			end = len(removed)
+		}
+		err = c.deleteMonitoredItems(ctx, subscriptionId, removed[start:end])
+		if err != nil {
+			return err
+		}
+	}
+
+	added := []entities.OpcuaItem{}
+	requests := []monitoredItem{}
+	for _, item := range items {
+		if _, ok := monitored[item.IdItem]; ok {
+			continue
+		}
+		node, err := ParseNodeId(item.NodeId)
+		if err != nil {
+			monitored[item.IdItem] = &monitoredState{item: item}
+			s.setStatus(ctx, item, err.Error())
+			continue
+		}
+		added = append(added, item)
+		requests = append(requests, monitoredItem{clientHandle: uint32(item.IdItem), node: node})
+	}
+
+	for start := 0; start < len(requests); start += itemBatchSize {
+		end := start + itemBatchSize
+		if end > len(requests) {
+			end = len(requests)
+		}
+		ids, statuses, err := c.createMonitoredItems(ctx, subscriptionId, s.samplingInterval, requests[start:end])
+		if err != nil {
+			return err
+		}
+		for i, item := range added[start:end] {
+			if isBad(statuses[i]) {
+				s.setStatus(ctx, item, StatusText(statuses[i]))
+				continue
+			}
+			monitored[item.IdItem] = &monitoredState{item: item, monitoredId: ids[i]}
+			s.setStatus(ctx, item, entities.OpcuaMonitoring)
+		}
+	}
+	return nil
+}
+
+// setStatus save the status of the item when it changed, failing to save it doesn't stop the subscription
+func (s *Subscriber) setStatus(ctx context.Context, item entities.OpcuaItem, status string) {
+	if item.Status == status {
+		return
+	}
+	err := s.repository.SetStatus(ctx, s.db, item.IdItem, status)
+	if err != nil {
+		log.Printf("[OPCUA] Error saving the status of item %d: %v", item.IdItem, err)
+	}
+}
+
+// store the numeric value change like POST /channel, a value with a bad status is skipped and an
+// uncertain one is stored as suspect
+func (s *Subscriber) store(ctx context.Context, changes []dataChange, monitored map[int]*monitoredState) {
+	for _, change := range changes {
+		state, ok := monitored[int(change.clientHandle)]
+		if !ok || state.monitoredId == 0 || !change.numeric || isBad(change.status) {
+			continue
+		}
+
+		payload := &entities.ChannelCreate{
+			IdSensor: state.item.IdSensor,
+			Name:     state.item.Name,
+			Value:    change.value,
+		}
+		if isUncertain(change.status) {
+			payload.Quality = entities.QualitySuspect
+		}
+		_, _, err := s.pipeline.Store(ctx, state.item.IdUser, payload)
+		s.pipeline.Record(state.item.IdSensor, 0, err)
+	}
+}
@@ -0,0 +1,290 @@
+// Package pdf write a simple A4 document of heading, paragraph, table, and image on the server,
+// for the report that is downloaded or sent by email. Only the standard library is used, the text is
+// written with the standard Helvetica font so no font is embedded and only latin-1 character is shown.
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"io"
+	"strings"
+)
+
+// A4 page in point with the same margin on every side
+const (
+	pageWidth    = 595.0
+	pageHeight   = 842.0
+	margin       = 50.0
+	contentWidth = pageWidth - 2*margin
+)
+
+// Font size and line height of the block
+const (
+	headingSize = 14.0
+	textSize    = 10.0
+	lineHeight  = 14.0
+	blockGap    = 10.0
+)
+
+// helveticaWidth is the width of the printable ASCII character from space, in 1/1000 of the font size
+var helveticaWidth = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+// textWidth estimate the width of the text in point, the bold font is around 10% wider
+func textWidth(text string, size float64, bold bool) float64 {
+	width := 0
+	for _, r := range text {
+		if r >= 32 && r < 127 {
+			width += helveticaWidth[r-32]
+		} else {
+			width += 556
+		}
+	}
+	if bold {
+		width = width * 11 / 10
+	}
+	return float64(width) * size / 1000
+}
+
+// Document is built from the top of the first page, a block that doesn't fit start a new page
+type Document struct {
+	pages  []*bytes.Buffer
+	images []image.Image
+	y      float64
+}
+
+func New() *Document {
+	d := &Document{}
+	d.newPage()
+	return d
+}
+
+func (d *Document) newPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+	d.y = pageHeight - margin
+}
+
+func (d *Document) page() *bytes.Buffer {
+	return d.pages[len(d.pages)-1]
+}
+
+// reserve start a new page when the height doesn't fit the current page
+func (d *Document) reserve(height float64) {
+	if d.y-height < margin && d.y < pageHeight-margin {
+		d.newPage()
+	}
+}
+
+func (d *Document) text(x float64, y float64, text string, size float64, bold bool) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(d.page(), "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, escape(text))
+}
+
+// Heading write a bold line
+func (d *Document) Heading(text string) {
+	d.reserve(headingSize + blockGap)
+	d.y -= headingSize
+	d.text(margin, d.y, truncate(text, contentWidth, headingSize, true), headingSize, true)
+	d.y -= blockGap
+}
+
+// Paragraph write the text wrapped to the page width, each newline start a new line
+func (d *Document) Paragraph(text string) {
+	for _, line := range wrap(text, contentWidth, textSize) {
+		d.reserve(lineHeight)
+		d.y -= lineHeight
+		d.text(margin, d.y, line, textSize, false)
+	}
+	d.y -= blockGap
+}
+
+// Table write the header in bold and each row below it, every column has the same width and a
+// cell too long for its column is truncated
+func (d *Document) Table(header []string, rows [][]string) {
+	if len(header) == 0 {
+		return
+	}
+	columnWidth := contentWidth / float64(len(header))
+	writeRow := func(cells []string, bold bool) {
+		d.reserve(lineHeight)
+		d.y -= lineHeight
+		for i, cell := range cells {
+			if i >= len(header) {
+				break
+			}
+			d.text(margin+float64(i)*columnWidth, d.y, truncate(cell, columnWidth-4, textSize, bold), textSize, bold)
+		}
+	}
+
+	writeRow(header, true)
+	fmt.Fprintf(d.page(), "0.5 w %.2f %.2f m %.2f %.2f l S\n", margin, d.y-4, margin+contentWidth, d.y-4)
+	d.y -= 4
+	for _, row := range rows {
+		writeRow(row, false)
+	}
+	d.y -= blockGap
+}
+
+// Image draw the image scaled to the page width, keeping its aspect ratio
+func (d *Document) Image(img image.Image) {
+	bounds := img.Bounds()
+	if bounds.Dx() == 0 || bounds.Dy() == 0 {
+		return
+	}
+	width := contentWidth
+	height := width * float64(bounds.Dy()) / float64(bounds.Dx())
+	if height > pageHeight-2*margin {
+		height = pageHeight - 2*margin
+		width = height * float64(bounds.Dx()) / float64(bounds.Dy())
+	}
+
+	d.reserve(height)
+	d.y -= height
+	d.images = append(d.images, img)
+	fmt.Fprintf(d.page(), "q %.2f 0 0 %.2f %.2f %.2f cm /Im%d Do Q\n", width, height, margin, d.y, len(d.images))
+	d.y -= blockGap
+}
+
+// Write the document with the object in this order: catalog, page tree, the two font, the image,
+// then the page and its content stream
+func (d *Document) Write(w io.Writer) error {
+	var out bytes.Buffer
+	offsets := []int{}
+	object := func(body string, stream []byte) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\n", len(offsets), body)
+		if stream != nil {
+			out.WriteString("stream\n")
+			out.Write(stream)
+			out.WriteString("\nendstream\n")
+		}
+		out.WriteString("endobj\n")
+	}
+
+	imageStart := 5
+	pageStart := imageStart + len(d.images)
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", pageStart+2*i)
+	}
+	xObjects := make([]string, len(d.images))
+	for i := range d.images {
+		xObjects[i] = fmt.Sprintf("/Im%d %d 0 R", i+1, imageStart+i)
+	}
+	resources := fmt.Sprintf("<< /Font << /F1 3 0 R /F2 4 0 R >> /XObject << %s >> >>", strings.Join(xObjects, " "))
+
+	out.WriteString("%PDF-1.4\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>", nil)
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)), nil)
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>", nil)
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>", nil)
+	for _, img := range d.images {
+		data, err := rgb(img)
+		if err != nil {
+			return err
+		}
+		bounds := img.Bounds()
+		object(fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode /Length %d >>", bounds.Dx(), bounds.Dy(), len(data)), data)
+	}
+	for i, page := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources %s /Contents %d 0 R >>", pageWidth, pageHeight, resources, pageStart+2*i+1), nil)
+		object(fmt.Sprintf("<< /Length %d >>", page.Len()), page.Bytes())
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// rgb compress the pixel of the image as 8 bit RGB without alpha
+func rgb(img image.Image) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := zlib.NewWriter(&buffer)
+	bounds := img.Bounds()
+	row := make([]byte, 0, bounds.Dx()*3)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row = row[:0]
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			row = append(row, byte(r>>8), byte(g>>8), byte(b>>8))
+		}
+		_, err := writer.Write(row)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := writer.Close()
+	return buffer.Bytes(), err
+}
+
+// escape encode the text as a PDF string in WinAnsi, a character outside latin-1 become a question mark
+func escape(text string) string {
+	var builder strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			builder.WriteByte('\\')
+			builder.WriteRune(r)
+		case r < 32:
+			builder.WriteByte(' ')
+		case r < 127:
+			builder.WriteRune(r)
+		case r >= 160 && r < 256:
+			fmt.Fprintf(&builder, "\\%03o", r)
+		default:
+			builder.WriteByte('?')
+		}
+	}
+	return builder.String()
+}
+
+// wrap split the text into line that fit the width, a word longer than the width is kept whole
+func wrap(text string, width float64, size float64) []string {
+	lines := []string{}
+	for _, paragraph := range strings.Split(text, "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+			if line != "" && textWidth(candidate, size, false) > width {
+				lines = append(lines, line)
+				line = word
+				continue
+			}
+			line = candidate
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// truncate cut the text with an ellipsis so it fit the width
+func truncate(text string, width float64, size float64, bold bool) string {
+	if textWidth(text, size, bold) <= width {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 && textWidth(string(runes)+"...", size, bold) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
@@ -0,0 +1,39 @@
+// The page is rendered with the totals, the charts load the buckets from the JSON of the same endpoint
+function formatBytes(bytes) {
+  const units = ["B", "KB", "MB", "GB", "TB"];
+  let value = bytes;
+  let unit = 0;
+  while (value >= 1024 && unit < units.length - 1) {
+    value /= 1024;
+    unit++;
+  }
+  return `${value.toFixed(unit === 0 ? 0 : 1)} ${units[unit]}`;
+}
+
+document.querySelectorAll("[data-bytes]").forEach((el) => {
+  el.innerHTML = formatBytes(parseInt(el.dataset.bytes));
+});
+document.querySelectorAll("[data-time]").forEach((el) => {
+  el.innerHTML = new Date(el.dataset.time).toLocaleString();
+});
+
+function renderBucketChart(selector, buckets, type) {
+  // Bucket time is UTC without zone, mark it as UTC before converting to local time
+  const data = buckets.map((bucket) => [
+    new Date(bucket.time.endsWith("Z") ? bucket.time : `${bucket.time}Z`).getTime(),
+    bucket.count,
+  ]);
+  const chart = new ApexCharts(document.querySelector(selector), {
+    series: [{ name: "channel", data: data }],
+    chart: { type: type, height: 250, toolbar: { show: false } },
+    dataLabels: { enabled: false },
+    noData: { text: "No data" },
+    xaxis: { type: "datetime", labels: { datetimeUTC: false } },
+  });
+  chart.render();
+}
+
+axios.get("/admin/stats").then((res) => {
+  renderBucketChart("#ingest-chart", res.data.ingest_per_hour, "bar");
+  renderBucketChart("#growth-chart", res.data.channel_per_day, "area");
+});
@@ -0,0 +1,14 @@
+function formatBytes(bytes) {
+  const units = ["B", "KB", "MB", "GB", "TB"];
+  let value = bytes;
+  let unit = 0;
+  while (value >= 1024 && unit < units.length - 1) {
+    value /= 1024;
+    unit++;
+  }
+  return `${value.toFixed(unit === 0 ? 0 : 1)} ${units[unit]}`;
+}
+
+document.querySelectorAll("[data-bytes]").forEach((el) => {
+  el.innerHTML = formatBytes(parseFloat(el.dataset.bytes));
+});
@@ -3,22 +3,24 @@ const separated = window.location.href.split("/");
 const id = separated[separated.length - 2];
 let editOptions = {};
 if (isEdit) {
-  $("#id_hardware").val(HARDWARE_ID).change();
   editOptions = {
-    url: `/node/${id}`,
+    url: `/dashboard/${id}`,
     method: "PUT",
   };
 }
 handleFormSubmit({
-  url: "/node/",
+  url: "/dashboard/",
+  successMessage: isEdit ? "" : "Success add new dashboard",
   ...editOptions,
   handleResponse: (res) => {
     setTimeout(() => {
-      window.location.href = "/node";
+      window.location.href = isEdit
+        ? `/dashboard/${id}`
+        : `/dashboard/${res.data.id_dashboard}`;
     }, 1000);
   },
   alterData: (data) => {
-    data.id_hardware = parseInt(data.id_hardware);
+    data.columns = parseInt(data.columns);
     return data;
   },
 });
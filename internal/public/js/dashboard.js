@@ -0,0 +1,212 @@
+// Widgets are rendered server-side with their latest data, this script draws the
+// gauge, chart and map widgets and keep them updated from the realtime feed.
+// Published dashboard (PUBLIC_TOKEN set) is read-only and use the public endpoints.
+const gauges = {};
+const charts = {};
+
+if (PUBLIC_TOKEN) {
+  document.querySelectorAll(".widget-delete").forEach((el) => el.remove());
+}
+
+function gaugePercent(value, min, max) {
+  if (isNaN(value)) {
+    return 0;
+  }
+  const percent = ((value - min) / (max - min)) * 100;
+  return Math.max(0, Math.min(100, percent));
+}
+
+document.querySelectorAll(".widget-gauge").forEach((el) => {
+  const min = parseFloat(el.dataset.min);
+  const max = parseFloat(el.dataset.max);
+  const value = parseFloat(el.dataset.value);
+  const unit = el.dataset.unit;
+  const gauge = new ApexCharts(el, {
+    series: [gaugePercent(value, min, max)],
+    chart: { type: "radialBar", height: "100%" },
+    plotOptions: {
+      radialBar: {
+        startAngle: -135,
+        endAngle: 135,
+        dataLabels: {
+          name: { show: false },
+          value: {
+            formatter: () => `${el.dataset.value || "-"} ${unit}`,
+          },
+        },
+      },
+    },
+  });
+  gauge.render();
+
+  const sensor = el.dataset.sensor;
+  gauges[sensor] = gauges[sensor] || [];
+  gauges[sensor].push({ el, gauge, min, max });
+});
+
+document.querySelectorAll(".widget-chart").forEach((el) => {
+  const range = parseInt(el.dataset.range);
+  const unit = el.dataset.unit;
+  const chart = new ApexCharts(el, {
+    series: [{ name: unit ? `value (${unit})` : "value", data: [] }],
+    chart: {
+      type: "line",
+      height: "100%",
+      toolbar: { show: false },
+      animations: { enabled: false },
+    },
+    stroke: { curve: "smooth", width: 2 },
+    dataLabels: { enabled: false },
+    noData: { text: "No data in this range" },
+    xaxis: { type: "datetime", labels: { datetimeUTC: false } },
+  });
+  chart.render();
+
+  const entry = { chart, range, data: [] };
+  const sensor = el.dataset.sensor;
+  charts[sensor] = charts[sensor] || [];
+  charts[sensor].push(entry);
+
+  const now = Date.now();
+  const request = PUBLIC_TOKEN
+    ? axios.get(
+        `/public/dashboard/${PUBLIC_TOKEN}/widget/${el.dataset.widget}/series`
+      )
+    : axios.get(`/sensor/${sensor}/series`, {
+        params: { from: now - range, to: now, points: 200 },
+      });
+  request.then((res) => {
+    entry.data = res.data.series;
+    chart.updateSeries([{ data: entry.data }], false);
+  });
+});
+
+document.querySelectorAll(".widget-map").forEach((el) => {
+  const markers = el.querySelectorAll(".widget-marker");
+  if (markers.length === 0) {
+    return;
+  }
+
+  const map = L.map(el);
+  L.tileLayer("https://{s}.tile.openstreetmap.org/{z}/{x}/{y}.png", {
+    attribution: "&copy; OpenStreetMap contributors",
+  }).addTo(map);
+
+  const bounds = [];
+  markers.forEach((marker) => {
+    const position = [
+      parseFloat(marker.dataset.latitude),
+      parseFloat(marker.dataset.longitude),
+    ];
+    L.marker(position).addTo(map).bindPopup(marker.dataset.name);
+    bounds.push(position);
+  });
+  map.fitBounds(bounds, { maxZoom: 15, padding: [20, 20] });
+});
+
+function updateWidgets(channel) {
+  updateLatestChannel(channel);
+
+  (gauges[channel.id_sensor] || []).forEach(({ el, gauge, min, max }) => {
+    el.dataset.value = channel.value;
+    gauge.updateSeries([gaugePercent(channel.value, min, max)]);
+  });
+
+  const time = new Date(channel.time).getTime();
+  (charts[channel.id_sensor] || []).forEach((entry) => {
+    const last = entry.data[entry.data.length - 1];
+    if (last && last[0] >= time) {
+      return;
+    }
+    entry.data.push([time, channel.value]);
+    entry.data = entry.data.filter((point) => point[0] >= Date.now() - entry.range);
+    entry.chart.updateSeries([{ data: entry.data }], false);
+  });
+}
+
+const sensorIds = new Set();
+document
+  .querySelectorAll("[data-latest-value], [data-sensor]")
+  .forEach((el) => {
+    const id = el.dataset.latestValue || el.dataset.sensor;
+    if (id) {
+      sensorIds.add(id);
+    }
+  });
+if (PUBLIC_TOKEN) {
+  subscribeRealtime(
+    [...sensorIds],
+    updateWidgets,
+    `/public/dashboard/${PUBLIC_TOKEN}/realtime`
+  );
+} else {
+  subscribeRealtime([...sensorIds], updateWidgets);
+  setupDashboardEditor();
+}
+
+function setupDashboardEditor() {
+  document.querySelector("#publish-button")?.addEventListener("click", () => {
+    axios.post(`/dashboard/${DASHBOARD_ID}/publish`).then(() => {
+      window.location.reload();
+    });
+  });
+  document.querySelector("#unpublish-button")?.addEventListener("click", () => {
+    axios.delete(`/dashboard/${DASHBOARD_ID}/publish`).then(() => {
+      window.location.reload();
+    });
+  });
+  document.querySelector("#template-button")?.addEventListener("click", () => {
+    Swal.fire({
+      title: "Save as template",
+      input: "text",
+      inputPlaceholder: "Template name",
+      showCancelButton: true,
+      confirmButtonText: "Save",
+      inputValidator: (value) => !value && "Name is required",
+    }).then((result) => {
+      if (!result.isConfirmed) {
+        return;
+      }
+      axios
+        .post(`/dashboard/${DASHBOARD_ID}/template`, { name: result.value })
+        .then(() => {
+          Swal.fire("Saved", "Success save dashboard template", "success");
+        })
+        .catch((err) => {
+          Swal.fire("Error", err.response?.data || err.message, "error");
+        });
+    });
+  });
+
+  handleFormSubmit({
+    url: `/dashboard/${DASHBOARD_ID}/widget`,
+    successMessage: "Success add new widget",
+    handleResponse: () => {
+      setTimeout(() => {
+        window.location.reload();
+      }, 1000);
+    },
+    alterData: (data) => {
+      const options = {};
+      if (data.min !== "") {
+        options.min = parseFloat(data.min);
+      }
+      if (data.max !== "") {
+        options.max = parseFloat(data.max);
+      }
+      if (data.range !== "") {
+        options.range = data.range;
+      }
+      return {
+        type: data.type,
+        title: data.title,
+        id_sensor: data.id_sensor === "" ? null : parseInt(data.id_sensor),
+        x: parseInt(data.x),
+        y: parseInt(data.y),
+        width: parseInt(data.width),
+        height: parseInt(data.height),
+        options: options,
+      };
+    },
+  });
+}
@@ -0,0 +1,43 @@
+// Embedded chart of a single sensor, the series is loaded once over the range then
+// kept updated from the realtime feed of the embed token.
+let embedData = [];
+
+const embedChart = new ApexCharts(document.querySelector("#embed-chart"), {
+  series: [
+    { name: SENSOR_UNIT ? `value (${SENSOR_UNIT})` : "value", data: [] },
+  ],
+  chart: {
+    type: "area",
+    height: EMBED_HEIGHT,
+    toolbar: { show: false },
+    zoom: { enabled: false },
+    animations: { enabled: false },
+  },
+  stroke: { curve: "smooth", width: 2 },
+  dataLabels: { enabled: false },
+  noData: { text: "No data in this range" },
+  xaxis: { type: "datetime", labels: { datetimeUTC: false } },
+});
+embedChart.render();
+
+axios
+  .get(`/embed/sensor/${EMBED_TOKEN}/series`, { params: { range: EMBED_RANGE } })
+  .then((res) => {
+    embedData = res.data.series;
+    embedChart.updateSeries([{ data: embedData }], false);
+  });
+
+subscribeRealtime(
+  [SENSOR_ID],
+  (channel) => {
+    const time = new Date(channel.time).getTime();
+    const last = embedData[embedData.length - 1];
+    if (last && last[0] >= time) {
+      return;
+    }
+    embedData.push([time, channel.value]);
+    embedData = embedData.filter((point) => point[0] >= Date.now() - EMBED_RANGE_MS);
+    embedChart.updateSeries([{ data: embedData }], false);
+  },
+  `/embed/sensor/${EMBED_TOKEN}/realtime`
+);
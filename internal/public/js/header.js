@@ -8,6 +8,7 @@ if (authorizationCookie) {
   const jwt = authorizationCookie.split(" ")[1];
   const decoded = jwt_decode(jwt);
   if (decoded.isAdmin === true) {
+    document.querySelector("#admin-nav")?.classList.remove("d-none");
     document.querySelector(
       "#head-username"
     ).innerHTML = `${decoded.username} <span class="badge bg-primary">Admin</span>`;
@@ -17,14 +18,64 @@ if (authorizationCookie) {
     ).innerHTML = `${decoded.username} <span class="badge bg-primary">User</span>`;
   }
   document.querySelector("#head-email").innerHTML = decoded.email;
+  document.querySelector("#notification-nav")?.classList.remove("d-none");
+  updateNotificationCount();
+  setInterval(updateNotificationCount, 60000);
 } else {
   logoutSection.style.display = "none";
 }
 
+function updateNotificationCount() {
+  const badge = document.querySelector("#notification-count");
+  if (!badge) {
+    return;
+  }
+  axios.get("/notification/unread").then((res) => {
+    const unread = res.data.unread;
+    badge.innerHTML = unread > 99 ? "99+" : unread;
+    badge.classList.toggle("d-none", unread === 0);
+  });
+}
+
+// Select can't be preselected without a template helper, the page give the value in data-value.
+// htmx call it for the page and again for every fragment it swap in
+htmx.onLoad((root) => {
+  root.querySelectorAll("select[data-value]").forEach((select) => {
+    select.value = select.dataset.value;
+  });
+});
+
+// The form rendered again with its field errors come with 4xx status, which htmx doesn't swap by default
+document.body.addEventListener("htmx:beforeSwap", (e) => {
+  const status = e.detail.xhr.status;
+  const fromForm = e.detail.requestConfig.elt.tagName === "FORM";
+  if (fromForm && status >= 400 && status < 500 && status !== 401 && status !== 403) {
+    e.detail.shouldSwap = true;
+    e.detail.isError = false;
+  }
+});
+
 const logoutButton = document.querySelector("#logout-button");
 
 logoutButton?.addEventListener("click", (e) => {
   e.preventDefault();
-  Cookies.remove("authorization");
-  window.location.href = "/";
+  // Revoke the session so the token can't be used anymore
+  axios.post("/user/logout").finally(() => {
+    Cookies.remove("authorization");
+    window.location.href = "/";
+  });
+});
+
+// Switching language reload the page because the text is rendered by the server
+document.querySelector("#language-toggle")?.addEventListener("click", (e) => {
+  const locales = ["en", "id"];
+  const current = e.currentTarget.dataset.locale;
+  const next = locales[(locales.indexOf(current) + 1) % locales.length];
+  Cookies.set("lang", next, { expires: 365 });
+  const request = authorizationCookie
+    ? axios.put("/user/language", { language: next })
+    : Promise.resolve();
+  request.finally(() => {
+    window.location.reload();
+  });
 });
@@ -1,7 +1,7 @@
 handleFormSubmit({
   url: "/user/login",
   handleResponse: (res) => {
-    const token = res.data;
+    const token = res.data.access_token;
     Cookies.set("authorization", `Bearer ${token}`, { expires: 365 });
     window.location.href = "/hardware";
   },
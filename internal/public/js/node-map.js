@@ -0,0 +1,71 @@
+// Fleet map of the user's nodes from /node/geojson, the marker color is the node status
+// and a cluster take the color of the worst status inside it.
+const statusColors = {
+  online: "#14a44d",
+  stale: "#e4a11b",
+  offline: "#dc4c64",
+  no_data: "#9fa6b2",
+};
+const statusSeverity = ["online", "no_data", "stale", "offline"];
+
+const map = L.map("fleet-map").setView([-6.5593, 106.7266], 5);
+L.tileLayer("https://{s}.tile.openstreetmap.org/{z}/{x}/{y}.png", {
+  attribution: "&copy; OpenStreetMap contributors",
+}).addTo(map);
+
+const clusters = L.markerClusterGroup({
+  iconCreateFunction: (cluster) => {
+    let worst = 0;
+    cluster.getAllChildMarkers().forEach((marker) => {
+      worst = Math.max(worst, statusSeverity.indexOf(marker.options.status));
+    });
+    const size = cluster.getChildCount() < 10 ? 36 : 44;
+    return L.divIcon({
+      html: `<div class="node-cluster" style="width: ${size}px; height: ${size}px; background-color: ${
+        statusColors[statusSeverity[worst]]
+      }">${cluster.getChildCount()}</div>`,
+      className: "",
+      iconSize: [size, size],
+    });
+  },
+});
+map.addLayer(clusters);
+
+function escapeHtml(text) {
+  const el = document.createElement("span");
+  el.innerText = text;
+  return el.innerHTML;
+}
+
+axios.get("/node/geojson").then((res) => {
+  const bounds = [];
+  res.data.features.forEach((feature) => {
+    const [longitude, latitude] = feature.geometry.coordinates;
+    const node = feature.properties;
+    const lastSeen = node.last_seen
+      ? new Date(node.last_seen).toLocaleString()
+      : "-";
+
+    const marker = L.circleMarker([latitude, longitude], {
+      radius: 9,
+      color: "white",
+      weight: 2,
+      fillColor: statusColors[node.status],
+      fillOpacity: 0.9,
+      status: node.status,
+    });
+    marker.bindPopup(
+      `<strong>${escapeHtml(node.name)}</strong><br />
+      Status: ${node.status.replace("_", " ")}<br />
+      Sensor: ${node.sensor_count}<br />
+      Last seen: ${lastSeen}<br />
+      <a href="/node/${node.id_node}">Open node detail</a>`
+    );
+    clusters.addLayer(marker);
+    bounds.push([latitude, longitude]);
+  });
+
+  if (bounds.length > 0) {
+    map.fitBounds(bounds, { maxZoom: 15, padding: [30, 30] });
+  }
+});
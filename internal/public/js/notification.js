@@ -0,0 +1,27 @@
+document.querySelectorAll("[data-time]").forEach((el) => {
+  el.innerHTML = new Date(el.dataset.time).toLocaleString();
+});
+
+document.querySelectorAll(".read-button").forEach((el) => {
+  el.addEventListener("click", () => {
+    axios.put(`/notification/${el.dataset.id}/read`).then(() => {
+      window.location.reload();
+    });
+  });
+});
+
+// Opening the link of a notification also mark it as read
+document.querySelectorAll(".notification-link").forEach((el) => {
+  el.addEventListener("click", (e) => {
+    e.preventDefault();
+    axios.put(`/notification/${el.dataset.id}/read`).finally(() => {
+      window.location.href = el.getAttribute("href");
+    });
+  });
+});
+
+document.querySelector("#read-all-button")?.addEventListener("click", () => {
+  axios.put("/notification/read").then(() => {
+    window.location.reload();
+  });
+});
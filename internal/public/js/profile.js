@@ -0,0 +1,83 @@
+function showToast(icon, title) {
+  Swal.fire({
+    position: "top",
+    icon: icon,
+    title: title,
+    showConfirmButton: false,
+    toast: true,
+    timer: 5000,
+  });
+}
+
+function submitProfileForm(selector, request) {
+  const form = document.querySelector(selector);
+  form?.addEventListener("submit", (e) => {
+    e.preventDefault();
+    const data = Object.fromEntries(new FormData(form).entries());
+    showLoading(true);
+    request(data)
+      .then((res) => {
+        showToast("success", res.data);
+        form.reset();
+      })
+      .catch((err) => {
+        if (err.response) {
+          showToast("error", err.response.data);
+        } else if (err.message) {
+          showToast("error", err.message);
+        }
+      })
+      .finally(() => {
+        showLoading(false);
+      });
+  });
+}
+
+// The email is part of the JWT, the response is the new token to replace the cookie
+submitProfileForm("#email-form", (data) =>
+  axios.put("/user/email", data).then((res) => {
+    Cookies.set("authorization", `Bearer ${res.data}`, { expires: 365 });
+    window.location.reload();
+    return { data: "Success change email" };
+  })
+);
+
+submitProfileForm("#password-form", (data) => {
+  if (data.new_password !== data.confirm_password) {
+    return Promise.reject(new Error("New password confirmation doesn't match"));
+  }
+  const id = document.querySelector("#password-form").dataset.id;
+  return axios.put(`/user/${id}`, {
+    old_password: data.old_password,
+    new_password: data.new_password,
+  });
+});
+
+document.querySelector("#theme-select")?.addEventListener("change", (e) => {
+  // setTheme from theme.js apply the theme and save it for the logged in user
+  setTheme(e.currentTarget.value);
+});
+
+document.querySelector("#language-select")?.addEventListener("change", (e) => {
+  const language = e.currentTarget.value;
+  Cookies.set("lang", language, { expires: 365 });
+  axios.put("/user/language", { language: language }).finally(() => {
+    window.location.reload();
+  });
+});
+
+document.querySelector("#digest-switch")?.addEventListener("change", (e) => {
+  axios.put("/user/digest", { digest: e.currentTarget.checked });
+});
+
+const apiToken = document.querySelector("#api-token");
+const profileAuthorization = Cookies.get("authorization");
+if (apiToken && profileAuthorization) {
+  apiToken.value = profileAuthorization.split(" ")[1];
+}
+
+document.querySelector("#copy-token-button")?.addEventListener("click", () => {
+  navigator.clipboard.writeText(apiToken.value).then(() => {
+    showToast("success", "Token copied");
+  });
+});
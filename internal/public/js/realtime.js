@@ -0,0 +1,89 @@
+// Realtime feed from /realtime, the server first send the latest channel of every
+// subscribed sensor then each new channel as it arrive. The connection is retried
+// with backoff so a wall display keep updating after a restart or network drop.
+// Public dashboard pass its own path, which subscribe to the sensor of its widgets.
+// The returned subscription can be closed, which stop the retry.
+function subscribeRealtime(sensorIds, onChannel, path) {
+  let retryDelay = 1000;
+  let socket = null;
+  let closed = false;
+  const status = document.querySelector("#realtime-status");
+
+  function setStatus(connected) {
+    if (!status) {
+      return;
+    }
+    status.classList.toggle("bg-success", connected);
+    status.classList.toggle("bg-secondary", !connected);
+    status.innerHTML = connected ? "Live" : "Reconnecting";
+  }
+
+  function connect() {
+    if (closed) {
+      return;
+    }
+    const protocol = window.location.protocol === "https:" ? "wss:" : "ws:";
+    socket = new WebSocket(
+      `${protocol}//${window.location.host}${
+        path || `/realtime?sensors=${sensorIds.join(",")}`
+      }`
+    );
+
+    socket.onopen = () => {
+      retryDelay = 1000;
+      setStatus(true);
+    };
+    socket.onmessage = (event) => {
+      onChannel(JSON.parse(event.data));
+    };
+    socket.onclose = () => {
+      if (closed) {
+        return;
+      }
+      setStatus(false);
+      setTimeout(connect, retryDelay);
+      retryDelay = Math.min(retryDelay * 2, 30000);
+    };
+  }
+
+  if (sensorIds.length > 0) {
+    connect();
+  }
+
+  return {
+    close() {
+      closed = true;
+      socket?.close();
+    },
+  };
+}
+
+// Update the cell marked with data-latest-value and data-latest-time of the channel sensor
+function updateLatestChannel(channel) {
+  document
+    .querySelectorAll(`[data-latest-value="${channel.id_sensor}"]`)
+    .forEach((el) => {
+      el.innerHTML = channel.value;
+      el.classList.remove("realtime-flash");
+      // Restart the animation
+      void el.offsetWidth;
+      el.classList.add("realtime-flash");
+    });
+  document
+    .querySelectorAll(`[data-latest-time="${channel.id_sensor}"]`)
+    .forEach((el) => {
+      el.innerHTML = new Date(channel.time).toLocaleString();
+    });
+}
+
+// Subscribe every sensor that has a latest value cell in the page, the list page call it
+// again after htmx swap the table so the subscription follow the shown rows
+let latestChannelSubscription = null;
+function subscribeLatestChannelCells() {
+  const sensorIds = new Set();
+  document.querySelectorAll("[data-latest-value]").forEach((el) => {
+    sensorIds.add(el.dataset.latestValue);
+  });
+  latestChannelSubscription?.close();
+  latestChannelSubscription = subscribeRealtime([...sensorIds], updateLatestChannel);
+}
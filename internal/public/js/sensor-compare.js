@@ -0,0 +1,201 @@
+// The compared series are loaded from /sensor/compare/series with the same bucket interval,
+// so the shared tooltip show the value of every sensor at the hovered time
+let currentRange = 604800000;
+let customFrom = null;
+let customTo = null;
+
+const sensorSelect = document.querySelector("#sensor-select");
+SELECTED_SENSORS.split(",")
+  .filter((id) => id !== "")
+  .forEach((id) => {
+    const option = sensorSelect.querySelector(`option[value="${id}"]`);
+    if (option) {
+      option.selected = true;
+    }
+  });
+
+var chart = new ApexCharts(document.querySelector("#compare-chart"), {
+  series: [],
+  chart: {
+    type: "line",
+    height: 450,
+    zoom: {
+      type: "x",
+      enabled: true,
+    },
+    events: {
+      zoomed: function (chartContext, { xaxis }) {
+        if (xaxis.min === undefined || xaxis.max === undefined) {
+          loadCompare();
+          return;
+        }
+        customFrom = Math.floor(xaxis.min);
+        customTo = Math.ceil(xaxis.max);
+        loadCompare();
+      },
+    },
+  },
+  stroke: {
+    curve: "smooth",
+    width: 2,
+  },
+  dataLabels: {
+    enabled: false,
+  },
+  noData: {
+    text: "No data in this range",
+  },
+  xaxis: {
+    type: "datetime",
+    tickAmount: 6,
+    labels: {
+      datetimeUTC: false,
+    },
+    crosshairs: {
+      show: true,
+    },
+  },
+  tooltip: {
+    shared: true,
+    intersect: false,
+    x: {
+      format: "dd MMM yyyy HH:mm:ss",
+    },
+  },
+  legend: {
+    position: "top",
+  },
+});
+chart.render();
+
+function selectedSensors() {
+  return Array.from(sensorSelect.selectedOptions).map((option) => option.value);
+}
+
+// Series with the same unit share one y axis, the other units get their own axis
+function buildYAxis(series) {
+  const axisOfUnit = {};
+  return series.map((s, i) => {
+    const unit = s.unit || "";
+    if (axisOfUnit[unit] === undefined) {
+      axisOfUnit[unit] = { seriesName: s.name, index: Object.keys(axisOfUnit).length };
+      return {
+        seriesName: s.name,
+        opposite: axisOfUnit[unit].index % 2 === 1,
+        title: { text: unit },
+        decimalsInFloat: 2,
+      };
+    }
+    return {
+      seriesName: axisOfUnit[unit].seriesName,
+      show: false,
+    };
+  });
+}
+
+function loadCompare() {
+  const sensors = selectedSensors();
+  const resolution = document.querySelector("#chart-resolution");
+
+  // Keep the selection in the url so the comparison can be shared or bookmarked
+  const url = new URL(window.location.href);
+  if (sensors.length > 0) {
+    url.searchParams.set("sensors", sensors.join(","));
+  } else {
+    url.searchParams.delete("sensors");
+  }
+  window.history.replaceState(null, "", url);
+
+  if (sensors.length === 0) {
+    chart.updateOptions({ series: [], yaxis: {} });
+    resolution.innerHTML = "Select the sensors to compare";
+    return;
+  }
+  if (sensors.length > COMPARE_MAX_SENSOR) {
+    resolution.innerHTML = `Select at most ${COMPARE_MAX_SENSOR} sensors`;
+    return;
+  }
+
+  const params = {
+    sensors: sensors.join(","),
+    agg: document.querySelector("#aggregate-select").value,
+    points: 500,
+  };
+  if (customFrom !== null || customTo !== null) {
+    if (customFrom !== null) {
+      params.from = customFrom;
+    }
+    if (customTo !== null) {
+      params.to = customTo;
+    }
+  } else if (currentRange !== "all") {
+    const now = Date.now();
+    params.from = now - currentRange;
+    params.to = now;
+  }
+
+  return axios
+    .get("/sensor/compare/series", { params })
+    .then((res) => {
+      const series = res.data.series;
+      chart.updateOptions({
+        series: series.map((s) => ({
+          name: s.name,
+          data: s.data,
+        })),
+        yaxis: buildYAxis(series),
+      });
+      const count = series.reduce((total, s) => total + s.data.length, 0);
+      if (res.data.interval > 0) {
+        resolution.innerHTML = `${count} points, ${res.data.agg} per ${res.data.interval} seconds`;
+      } else {
+        resolution.innerHTML = `${count} raw points`;
+      }
+    })
+    .catch((err) => {
+      if (err.response) {
+        Swal.fire({
+          position: "top",
+          icon: "error",
+          title: err.response.data,
+          showConfirmButton: false,
+          toast: true,
+          timer: 5000,
+        });
+      }
+    });
+}
+
+document.querySelectorAll("#range-buttons button").forEach((button) => {
+  button.addEventListener("click", (e) => {
+    document
+      .querySelectorAll("#range-buttons button")
+      .forEach((el) => el.classList.remove("active"));
+    e.currentTarget.classList.add("active");
+
+    const range = e.currentTarget.dataset.range;
+    currentRange = range === "all" ? "all" : parseInt(range);
+    customFrom = null;
+    customTo = null;
+    loadCompare();
+  });
+});
+
+document.querySelector("#custom-range-button").addEventListener("click", () => {
+  const from = document.querySelector("#from-input").value;
+  const to = document.querySelector("#to-input").value;
+  customFrom = from ? new Date(from).getTime() : null;
+  customTo = to ? new Date(to).getTime() : null;
+  if (customFrom === null && customTo === null) {
+    currentRange = "all";
+  }
+  document
+    .querySelectorAll("#range-buttons button")
+    .forEach((el) => el.classList.remove("active"));
+  loadCompare();
+});
+
+document.querySelector("#aggregate-select").addEventListener("change", loadCompare);
+sensorSelect.addEventListener("change", loadCompare);
+
+loadCompare();
@@ -1,9 +1,19 @@
-console.log(CHANNEL);
+// Chart data is loaded from /sensor/:id/series, which downsample the selected range
+// so the page stay light regardless of how long the history is.
+let currentRange = 604800000;
+// New channel is appended to the chart only while the shown range end at now,
+// zooming into the past stop following until a range button is clicked again
+let followLive = true;
+let seriesData = [];
+// Range of the shown chart, used by the CSV export button
+let shownFrom = null;
+let shownTo = null;
+
 var options = {
   series: [
     {
-      name: "value",
-      data: CHANNEL,
+      name: SENSOR_UNIT ? `value (${SENSOR_UNIT})` : "value",
+      data: [],
     },
   ],
   chart: {
@@ -11,43 +21,28 @@ var options = {
     type: "area",
     height: 350,
     zoom: {
+      type: "x",
+      enabled: true,
       autoScaleYaxis: true,
     },
+    toolbar: {
+      autoSelected: "zoom",
+    },
+    events: {
+      // Load finer resolution for the zoomed range
+      zoomed: function (chartContext, { xaxis }) {
+        if (xaxis.min === undefined || xaxis.max === undefined) {
+          loadSeries(currentRange);
+          return;
+        }
+        followLive = false;
+        loadSeriesBetween(Math.floor(xaxis.min), Math.ceil(xaxis.max));
+      },
+    },
   },
   stroke: {
     curve: "smooth",
   },
-  annotations: {
-    yaxis: [
-      {
-        y: 30,
-        borderColor: "#999",
-        label: {
-          show: true,
-          text: "Support",
-          style: {
-            color: "#fff",
-            background: "#00E396",
-          },
-        },
-      },
-    ],
-    xaxis: [
-      {
-        // x: new Date("14 Nov 2012").getTime(),
-        borderColor: "#999",
-        yAxisIndex: 0,
-        label: {
-          show: true,
-          text: "Rally",
-          style: {
-            color: "#fff",
-            background: "#775DD0",
-          },
-        },
-      },
-    ],
-  },
   dataLabels: {
     enabled: false,
   },
@@ -55,14 +50,19 @@ var options = {
     size: 0,
     style: "hollow",
   },
+  noData: {
+    text: "No data in this range",
+  },
   xaxis: {
     type: "datetime",
-    // min: new Date("01 Mar 2012").getTime(),
     tickAmount: 6,
+    labels: {
+      datetimeUTC: false,
+    },
   },
   tooltip: {
     x: {
-      format: "dd MMM yyyy",
+      format: "dd MMM yyyy HH:mm:ss",
     },
   },
 };
@@ -70,55 +70,128 @@ var options = {
 var chart = new ApexCharts(document.querySelector("#channel-chart"), options);
 chart.render();
 
-// var resetCssClasses = function (activeEl) {
-//   var els = document.querySelectorAll("button");
-//   Array.prototype.forEach.call(els, function (el) {
-//     el.classList.remove("active");
-//   });
+function loadSeriesBetween(from, to) {
+  shownFrom = from;
+  shownTo = to;
+  const params = {
+    agg: document.querySelector("#aggregate-select").value,
+    points: 500,
+  };
+  if (from !== null) {
+    params.from = from;
+  }
+  if (to !== null) {
+    params.to = to;
+  }
+
+  return axios
+    .get(`/sensor/${SENSOR_ID}/series`, { params })
+    .then((res) => {
+      seriesData = res.data.series;
+      chart.updateSeries([{ data: seriesData }], false);
+      const resolution = document.querySelector("#chart-resolution");
+      if (res.data.interval > 0) {
+        resolution.innerHTML = `${res.data.series.length} points, ${res.data.agg} per ${res.data.interval} seconds`;
+      } else {
+        resolution.innerHTML = `${res.data.series.length} raw points`;
+      }
+    })
+    .catch((err) => {
+      if (err.response) {
+        Swal.fire({
+          position: "top",
+          icon: "error",
+          title: err.response.data,
+          showConfirmButton: false,
+          toast: true,
+          timer: 5000,
+        });
+      }
+    });
+}
+
+function loadSeries(range) {
+  currentRange = range;
+  followLive = true;
+  if (range === "all") {
+    return loadSeriesBetween(null, null);
+  }
+  const now = Date.now();
+  return loadSeriesBetween(now - range, now);
+}
 
-//   activeEl.target.classList.add("active");
-// };
+document.querySelectorAll("#range-buttons button").forEach((button) => {
+  button.addEventListener("click", (e) => {
+    document
+      .querySelectorAll("#range-buttons button")
+      .forEach((el) => el.classList.remove("active"));
+    e.currentTarget.classList.add("active");
 
-// document.querySelector("#one_month").addEventListener("click", function (e) {
-//   resetCssClasses(e);
+    const range = e.currentTarget.dataset.range;
+    loadSeries(range === "all" ? "all" : parseInt(range));
+  });
+});
 
-//   chart.zoomX(
-//     new Date("28 Jan 2013").getTime(),
-//     new Date("27 Feb 2013").getTime()
-//   );
-// });
+document.querySelector("#aggregate-select").addEventListener("change", () => {
+  loadSeries(currentRange);
+});
 
-// document.querySelector("#six_months").addEventListener("click", function (e) {
-//   resetCssClasses(e);
+function appendLiveChannel(channel) {
+  updateLatestChannel(channel);
+  if (!followLive) {
+    return;
+  }
 
-//   chart.zoomX(
-//     new Date("27 Sep 2012").getTime(),
-//     new Date("27 Feb 2013").getTime()
-//   );
-// });
+  const time = new Date(channel.time).getTime();
+  // The first message is the latest channel which may already be in the series
+  if (seriesData.length > 0 && seriesData[seriesData.length - 1][0] >= time) {
+    return;
+  }
 
-// document.querySelector("#one_year").addEventListener("click", function (e) {
-//   resetCssClasses(e);
-//   chart.zoomX(
-//     new Date("27 Feb 2012").getTime(),
-//     new Date("27 Feb 2013").getTime()
-//   );
-// });
+  seriesData.push([time, channel.value]);
+  if (currentRange !== "all") {
+    const from = Date.now() - currentRange;
+    seriesData = seriesData.filter((point) => point[0] >= from);
+  }
+  chart.updateSeries([{ data: seriesData }], false);
+}
 
-// document.querySelector("#ytd").addEventListener("click", function (e) {
-//   resetCssClasses(e);
+loadSeries(currentRange);
+subscribeRealtime([SENSOR_ID], appendLiveChannel);
 
-//   chart.zoomX(
-//     new Date("01 Jan 2013").getTime(),
-//     new Date("27 Feb 2013").getTime()
-//   );
-// });
+// Export the raw channel of the shown range, following live data export until now
+document.querySelector("#export-button").addEventListener("click", (e) => {
+  const params = new URLSearchParams();
+  if (shownFrom !== null) {
+    params.set("from", shownFrom);
+  }
+  if (shownTo !== null && !followLive) {
+    params.set("to", shownTo);
+  }
+  e.currentTarget.href = `/sensor/${SENSOR_ID}/export?${params.toString()}`;
+});
 
-// document.querySelector("#all").addEventListener("click", function (e) {
-//   resetCssClasses(e);
+// Image of the shown range, rendered by the server
+document.querySelector("#image-button").addEventListener("click", (e) => {
+  const params = new URLSearchParams({
+    agg: document.querySelector("#aggregate-select").value,
+  });
+  if (shownFrom !== null) {
+    params.set("from", shownFrom);
+  }
+  if (shownTo !== null && !followLive) {
+    params.set("to", shownTo);
+  }
+  e.currentTarget.href = `/sensor/${SENSOR_ID}/chart.png?${params.toString()}`;
+});
 
-//   chart.zoomX(
-//     new Date("23 Jan 2012").getTime(),
-//     new Date("27 Feb 2013").getTime()
-//   );
-// });
+document.querySelector("#embed-button")?.addEventListener("click", () => {
+  axios.post(`/sensor/${SENSOR_ID}/embed`).then(() => {
+    window.location.reload();
+  });
+});
+document.querySelector("#unembed-button")?.addEventListener("click", () => {
+  axios.delete(`/sensor/${SENSOR_ID}/embed`).then(() => {
+    window.location.reload();
+  });
+});
@@ -0,0 +1,64 @@
+// The theme preference (light, dark or system) is rendered by the server in
+// data-theme-preference, "system" follow the operating system color scheme.
+// This script is loaded in the head so the page is never painted in the wrong theme.
+const themeQuery = window.matchMedia("(prefers-color-scheme: dark)");
+const themeOrder = ["light", "dark", "system"];
+const themeIcons = {
+  light: "fa-sun",
+  dark: "fa-moon",
+  system: "fa-circle-half-stroke",
+};
+
+function applyTheme(preference) {
+  const root = document.documentElement;
+  let theme = preference;
+  if (preference === "system") {
+    theme = themeQuery.matches ? "dark" : "light";
+  }
+  root.dataset.themePreference = preference;
+  root.dataset.theme = theme;
+  root.dataset.bsTheme = theme;
+
+  // Default of every ApexCharts created after this
+  window.Apex = {
+    theme: { mode: theme },
+    chart: { background: "transparent" },
+  };
+}
+
+function updateThemeToggle() {
+  const toggle = document.querySelector("#theme-toggle");
+  if (!toggle) {
+    return;
+  }
+  const preference = document.documentElement.dataset.themePreference;
+  toggle.title = `Theme: ${preference}`;
+  toggle.querySelector("i").className = `fas ${themeIcons[preference]}`;
+}
+
+// Save the preference on the account when logged in, the cookie keep it for anonymous page
+function setTheme(preference) {
+  applyTheme(preference);
+  updateThemeToggle();
+  Cookies.set("theme", preference, { expires: 365 });
+  if (Cookies.get("authorization")) {
+    axios.put("/user/theme", { theme: preference });
+  }
+}
+
+applyTheme(document.documentElement.dataset.themePreference || "system");
+
+themeQuery.addEventListener("change", () => {
+  if (document.documentElement.dataset.themePreference === "system") {
+    applyTheme("system");
+  }
+});
+
+document.addEventListener("DOMContentLoaded", () => {
+  updateThemeToggle();
+  document.querySelector("#theme-toggle")?.addEventListener("click", () => {
+    const preference = document.documentElement.dataset.themePreference;
+    const next = themeOrder[(themeOrder.indexOf(preference) + 1) % themeOrder.length];
+    setTheme(next);
+  });
+});
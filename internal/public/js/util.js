@@ -110,7 +110,7 @@ function deleteItem(object, id, identifier) {
           };
           Swal.fire(swalOptions);
 
-          window.location.reload();
+          refreshList();
         })
         .catch((err) => {
           if (err.response) {
@@ -132,3 +132,13 @@ function deleteItem(object, id, identifier) {
     }
   });
 }
+
+// Refresh the list fragment in place when the page has one, otherwise reload the whole page
+function refreshList() {
+  const list = document.querySelector("[data-list]");
+  if (list && window.htmx) {
+    htmx.ajax("GET", window.location.href, { target: list });
+    return;
+  }
+  window.location.reload();
+}
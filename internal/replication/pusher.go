@@ -0,0 +1,179 @@
+// Package replication push the entity and reading of an edge instance, e.g. on-premise next to the
+// device, to a central instance. The edge keep every channel in its own database while the central
+// can't be reached, and the cursor of the last pushed channel only move once the central stored it.
+package replication
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	requestTimeout   = 30 * time.Second
+	defaultBatchSize = 1000
+	// Batch pushed per run at most, the rest wait for the next run
+	maxBatches = 20
+	// A channel newer than this may still be in an uncommitted transaction, it is pushed in the next run
+	CommitLag = 10 * time.Second
+)
+
+// Pusher push to the central instance once per Push, it is run by the scheduler so only one instance
+// of the edge push
+type Pusher struct {
+	db         *pgxpool.Pool
+	repository *repositories.SyncRepository
+	client     *http.Client
+	url        string
+	token      string
+	site       string
+	batchSize  int
+}
+
+// NewPusher return nil when sync.url is empty
+func NewPusher(db *pgxpool.Pool, syncRepository *repositories.SyncRepository, config *configs.Config) (*Pusher, error) {
+	if config.Sync.Url == "" {
+		return nil, nil
+	}
+	if config.Sync.Site == "" || config.Sync.Token == "" {
+		return nil, fmt.Errorf("sync.url need sync.site and sync.token")
+	}
+	batchSize := config.Sync.BatchSize
+	if batchSize <= 0 || batchSize > 10000 {
+		batchSize = defaultBatchSize
+	}
+
+	return &Pusher{
+		db:         db,
+		repository: syncRepository,
+		client:     &http.Client{Timeout: requestTimeout},
+		url:        strings.TrimSuffix(config.Sync.Url, "/") + "/sync/push",
+		token:      config.Sync.Token,
+		site:       config.Sync.Site,
+		batchSize:  batchSize,
+	}, nil
+}
+
+func (p *Pusher) Url() string {
+	return p.url
+}
+
+func (p *Pusher) Site() string {
+	return p.site
+}
+
+// Push send the hardware, node and sensor with the first batch, then the channel stored since the
+// cursor by batch
+func (p *Pusher) Push(ctx context.Context) (string, error) {
+	state, err := p.repository.GetState(ctx, p.db, p.site)
+	if err != nil {
+		return "", err
+	}
+	cursor := state.Cursor
+	until := time.Now().UTC().Add(-CommitLag)
+
+	pushed := 0
+	for i := 0; i < maxBatches; i++ {
+		batch := entities.SyncBatch{Site: p.site}
+		if i == 0 {
+			err = p.repository.GetEntities(ctx, p.db, &batch)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		batch.Channels, err = p.repository.GetChannelsAfter(ctx, p.db, cursor, until, p.batchSize)
+		if err != nil {
+			return "", err
+		}
+		full := len(batch.Channels) == p.batchSize
+		batch.Channels = TrimBatch(batch.Channels, full)
+		if i > 0 && len(batch.Channels) == 0 {
+			break
+		}
+
+		_, err = p.send(ctx, &batch)
+		if err != nil {
+			saveErr := p.repository.SaveFailure(ctx, p.db, p.site, err)
+			if saveErr != nil {
+				return "", saveErr
+			}
+			return "", err
+		}
+
+		if len(batch.Channels) > 0 {
+			last := batch.Channels[len(batch.Channels)-1].Time
+			cursor = &last
+		}
+		err = p.repository.SaveSuccess(ctx, p.db, p.site, cursor, len(batch.Channels))
+		if err != nil {
+			return "", err
+		}
+		pushed += len(batch.Channels)
+		if !full {
+			break
+		}
+	}
+	return fmt.Sprintf("pushed %d channel of site %s", pushed, p.site), nil
+}
+
+// TrimBatch drop the channel at the last time of a full batch, so the channel stored at the same
+// time are pushed together in the next batch. A batch of a single time is kept whole
+func TrimBatch(channels []entities.SyncChannel, full bool) []entities.SyncChannel {
+	if !full || len(channels) == 0 {
+		return channels
+	}
+	last := channels[len(channels)-1].Time
+	end := len(channels)
+	for end > 0 && channels[end-1].Time.Equal(last) {
+		end--
+	}
+	if end == 0 {
+		return channels
+	}
+	return channels[:end]
+}
+
+func (p *Pusher) send(ctx context.Context, batch *entities.SyncBatch) (result entities.SyncResult, err error) {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return result, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return result, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", "Bearer "+p.token)
+
+	response, err := p.client.Do(request)
+	if err != nil {
+		return result, err
+	}
+	defer response.Body.Close()
+
+	responseBody, err := io.ReadAll(io.LimitReader(response.Body, 64*1024))
+	if err != nil {
+		return result, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return result, fmt.Errorf("central answered %d: %s", response.StatusCode, strings.TrimSpace(string(responseBody)))
+	}
+	err = json.Unmarshal(responseBody, &result)
+	if err != nil {
+		return result, errors.New("central answered an invalid result")
+	}
+	return result, nil
+}
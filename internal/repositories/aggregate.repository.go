@@ -0,0 +1,184 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/jackc/pgx/v5"
+)
+
+// Resolution of the channel_aggregate, largest first. An interval query use the largest one that
+// divide its interval
+var aggregateResolutions = []time.Duration{24 * time.Hour, time.Hour}
+
+// AggregateRepository keep the hourly and daily summary of the good unnamed channel still in the
+// channel table, so an interval query over a long range doesn't read every channel. The summary of a
+// sensor is complete until its refreshed_until, a change of an older channel lower it with
+// invalidateAggregate and the channel-aggregate job compute it again
+type AggregateRepository struct {
+	lag        time.Duration
+	daysPerRun int
+}
+
+func NewAggregateRepository(config *configs.Config) (AggregateRepository, error) {
+	lagMinutes := config.Aggregate.LagMinutes
+	if lagMinutes <= 0 {
+		lagMinutes = 5
+	}
+	daysPerRun := config.Aggregate.DaysPerRun
+	if daysPerRun <= 0 {
+		daysPerRun = 31
+	}
+	return AggregateRepository{
+		lag:        time.Duration(lagMinutes) * time.Minute,
+		daysPerRun: daysPerRun,
+	}, nil
+}
+
+// deleteAggregateBefore delete the summary which bucket end before the time, with the channel
+// deleted before it, the bucket that contain the time is computed again
+func deleteAggregateBefore(ctx context.Context, tx helper.Querier, sensorId int, before time.Time) error {
+	sqlStatement := `DELETE FROM channel_aggregate WHERE ($1=0 OR id_sensor=$1) AND bucket + make_interval(secs => resolution) <= $2`
+	_, err := tx.Exec(ctx, sqlStatement, sensorId, before)
+	if err != nil {
+		return err
+	}
+	return invalidateAggregate(ctx, tx, sensorId, before)
+}
+
+// invalidateAggregate make the summary of the sensor from the time computed again, of every sensor
+// when sensorId is 0. It is called where the server change a channel that may be summarized already
+func invalidateAggregate(ctx context.Context, tx helper.Querier, sensorId int, from time.Time) error {
+	sqlStatement := `
+	UPDATE channel_aggregate_state
+	SET refreshed_until=to_timestamp(floor(extract(epoch FROM $2::TIMESTAMP) / resolution) * resolution) AT TIME ZONE 'UTC'
+	WHERE ($1=0 OR id_sensor=$1) AND refreshed_until > $2`
+	_, err := tx.Exec(ctx, sqlStatement, sensorId, from)
+	return err
+}
+
+// getRefreshedUntil return the time the summary of the sensor is complete until, nil if it has none
+func (r *AggregateRepository) getRefreshedUntil(ctx context.Context, tx helper.Querier, sensorId int, resolution time.Duration) (until *time.Time, err error) {
+	sqlStatement := `SELECT refreshed_until FROM channel_aggregate_state WHERE id_sensor=$1 AND resolution=$2`
+	err = tx.QueryRow(ctx, sqlStatement, sensorId, int(resolution.Seconds())).Scan(&until)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return until, err
+}
+
+// Refresh compute the summary of every sensor that ended lag ago, up to daysPerRun day per sensor and
+// resolution, the rest is left to the next run. The count is the number of bucket written
+func (r *AggregateRepository) Refresh(ctx context.Context, tx helper.Querier, now time.Time) (sensors int, count int64, err error) {
+	rows, err := tx.Query(ctx, `SELECT id_sensor FROM "sensor" WHERE deleted_at IS NULL ORDER BY id_sensor`)
+	if err != nil {
+		return 0, 0, err
+	}
+	sensorIds := []int{}
+	for rows.Next() {
+		var id int
+		err := rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		sensorIds = append(sensorIds, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	for _, sensorId := range sensorIds {
+		refreshed := false
+		for _, resolution := range aggregateResolutions {
+			written, ok, err := r.refreshSensor(ctx, tx, sensorId, resolution, now)
+			if err != nil {
+				return sensors, count, err
+			}
+			refreshed = refreshed || ok
+			count += written
+		}
+		if refreshed {
+			sensors++
+		}
+	}
+	return sensors, count, nil
+}
+
+// refreshSensor compute the summary of the sensor after its refreshed_until in one transaction, the
+// state row is locked so a concurrent invalidateAggregate wait and lower it after the commit
+func (r *AggregateRepository) refreshSensor(ctx context.Context, db helper.Querier, sensorId int, resolution time.Duration, now time.Time) (count int64, refreshed bool, err error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback(ctx)
+
+	seconds := int(resolution.Seconds())
+	var start time.Time
+	err = tx.QueryRow(ctx, `SELECT refreshed_until FROM channel_aggregate_state WHERE id_sensor=$1 AND resolution=$2 FOR UPDATE`, sensorId, seconds).Scan(&start)
+	if errors.Is(err, pgx.ErrNoRows) {
+		var first *time.Time
+		err = tx.QueryRow(ctx, `SELECT min(time) FROM "channel" WHERE id_sensor=$1`, sensorId).Scan(&first)
+		if err != nil || first == nil {
+			return 0, false, err
+		}
+		start = first.UTC().Truncate(resolution)
+	} else if err != nil {
+		return 0, false, err
+	}
+
+	end := now.UTC().Add(-r.lag).Truncate(resolution)
+	if !start.Before(end) {
+		return 0, false, nil
+	}
+
+	// An invalidated summary restart at the first channel after it, not at the invalidated time
+	deleteFrom := start
+	var next *time.Time
+	err = tx.QueryRow(ctx, `SELECT min(time) FROM "channel" WHERE id_sensor=$1 AND time>=$2`, sensorId, start).Scan(&next)
+	if err != nil {
+		return 0, false, err
+	}
+	if next == nil {
+		start = end
+	} else if bucket := next.UTC().Truncate(resolution); bucket.After(start) {
+		start = bucket
+	}
+	if limit := start.AddDate(0, 0, r.daysPerRun); end.After(limit) {
+		end = limit
+	}
+	if start.After(end) {
+		start = end
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM channel_aggregate WHERE id_sensor=$1 AND resolution=$2 AND bucket>=$3 AND bucket<$4`, sensorId, seconds, deleteFrom, end)
+	if err != nil {
+		return 0, false, err
+	}
+
+	sqlStatement := `
+	INSERT INTO channel_aggregate (id_sensor, resolution, bucket, row_count, sum_value, min_value, max_value, last_value, last_time)
+	SELECT $1::INTEGER, $2::INTEGER, to_timestamp(floor(extract(epoch FROM channel.time) / $2::INTEGER) * $2::INTEGER) AT TIME ZONE 'UTC' AS bucket,
+		count(*), sum(channel.value), min(channel.value), max(channel.value),
+		(array_agg(channel.value ORDER BY channel.time DESC))[1], max(channel.time)
+	FROM "channel"
+	WHERE channel.id_sensor=$1 AND channel.time>=$3 AND channel.time<$4 AND channel.quality='good' AND channel.name=''
+	GROUP BY bucket`
+	res, err := tx.Exec(ctx, sqlStatement, sensorId, seconds, start, end)
+	if err != nil {
+		return 0, false, err
+	}
+
+	_, err = tx.Exec(ctx, `
+	INSERT INTO channel_aggregate_state (id_sensor, resolution, refreshed_until) VALUES ($1, $2, $3)
+	ON CONFLICT (id_sensor, resolution) DO UPDATE SET refreshed_until=EXCLUDED.refreshed_until`, sensorId, seconds, end)
+	if err != nil {
+		return 0, false, err
+	}
+	return res.RowsAffected(), true, tx.Commit(ctx)
+}
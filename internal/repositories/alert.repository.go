@@ -0,0 +1,345 @@
+package repositories
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+const defaultAlertLimit = 100
+
+// AlertRepository keep the alert rule of the sensor and the alert they fired
+type AlertRepository struct{}
+
+func NewAlertRepository() (AlertRepository, error) {
+	return AlertRepository{}, nil
+}
+
+func (r *AlertRepository) ruleField() string {
+	return "alert_rule.id_rule, alert_rule.id_sensor, alert_rule.name, alert_rule.operator, alert_rule.threshold, alert_rule.duration_seconds, alert_rule.title, alert_rule.enabled, alert_rule.pending_since"
+}
+
+func (r *AlertRepository) rulePointer(rule *entities.AlertRule) []interface{} {
+	return []interface{}{&rule.IdRule, &rule.IdSensor, &rule.Name, &rule.Operator, &rule.Threshold, &rule.DurationSeconds, &rule.Title, &rule.Enabled, &rule.PendingSince}
+}
+
+func (r *AlertRepository) alertField() string {
+	return "alert.id_alert, alert.id_rule, alert_rule.id_sensor, alert_rule.title, alert.value, alert.fired_at, alert.resolved_at, alert.acknowledged_at"
+}
+
+func (r *AlertRepository) alertPointer(alert *entities.Alert) []interface{} {
+	return []interface{}{&alert.IdAlert, &alert.IdRule, &alert.IdSensor, &alert.Title, &alert.Value, &alert.FiredAt, &alert.ResolvedAt, &alert.AcknowledgedAt}
+}
+
+// withDefault fill the title and enabled that are not set
+func (r *AlertRepository) withDefault(payload *entities.AlertRuleCreate) entities.AlertRuleCreate {
+	rule := *payload
+	if rule.Title == "" {
+		name := ""
+		if rule.Name != nil {
+			name = " " + *rule.Name
+		}
+		rule.Title = fmt.Sprintf("value%s %s %g on sensor %d", name, rule.Operator, rule.Threshold, rule.IdSensor)
+		if rule.DurationSeconds > 0 {
+			rule.Title += fmt.Sprintf(" for %s", time.Duration(rule.DurationSeconds)*time.Second)
+		}
+	}
+	if rule.Enabled == nil {
+		enabled := true
+		rule.Enabled = &enabled
+	}
+	return rule
+}
+
+// GetAllRule return the alert rule of the user sensor, of every sensor when idUser is 0
+func (r *AlertRepository) GetAllRule(ctx context.Context, tx helper.Querier, idUser int) (rules []entities.AlertRule, err error) {
+	rules = []entities.AlertRule{}
+	sqlStatement := fmt.Sprintf(`
+	SELECT %s FROM alert_rule
+	INNER JOIN sensor ON sensor.id_sensor=alert_rule.id_sensor
+	INNER JOIN node ON node.id_node=sensor.id_node
+	WHERE $1=0 OR node.id_user=$1
+	ORDER BY alert_rule.id_rule`, r.ruleField())
+	rows, err := tx.Query(ctx, sqlStatement, idUser)
+	if err != nil {
+		return rules, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rule entities.AlertRule
+		err := rows.Scan(r.rulePointer(&rule)...)
+		if err != nil {
+			return rules, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+func (r *AlertRepository) GetRuleById(ctx context.Context, tx helper.Querier, id int) (rule entities.AlertRule, err error) {
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM alert_rule WHERE alert_rule.id_rule=$1`, r.ruleField())
+	err = tx.QueryRow(ctx, sqlStatement, id).Scan(r.rulePointer(&rule)...)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return rule, fiber.NewError(404, fmt.Sprintf("Alert rule with id %d not found", id))
+		}
+		return rule, err
+	}
+	return rule, nil
+}
+
+func (r *AlertRepository) CreateRule(ctx context.Context, tx helper.Querier, payload *entities.AlertRuleCreate) (rule entities.AlertRule, err error) {
+	values := r.withDefault(payload)
+	sqlStatement := fmt.Sprintf(`
+	INSERT INTO alert_rule (id_sensor, name, operator, threshold, duration_seconds, title, enabled)
+	VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING %s`, r.ruleField())
+	err = tx.QueryRow(ctx, sqlStatement, values.IdSensor, values.Name, values.Operator, values.Threshold, values.DurationSeconds, values.Title, values.Enabled).Scan(r.rulePointer(&rule)...)
+	return rule, err
+}
+
+// UpdateRule change the rule, the current match start again from the next channel and the active
+// alert of the rule is resolved
+func (r *AlertRepository) UpdateRule(ctx context.Context, tx helper.Querier, id int, payload *entities.AlertRuleCreate) error {
+	values := r.withDefault(payload)
+	sqlStatement := `
+	UPDATE alert_rule SET id_sensor=$2, name=$3, operator=$4, threshold=$5, duration_seconds=$6, title=$7, enabled=$8, pending_since=NULL
+	WHERE id_rule=$1`
+	_, err := tx.Exec(ctx, sqlStatement, id, values.IdSensor, values.Name, values.Operator, values.Threshold, values.DurationSeconds, values.Title, values.Enabled)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `UPDATE alert SET resolved_at=NOW() WHERE id_rule=$1 AND resolved_at IS NULL`, id)
+	return err
+}
+
+func (r *AlertRepository) DeleteRule(ctx context.Context, tx helper.Querier, id int) error {
+	_, err := tx.Exec(ctx, `DELETE FROM alert_rule WHERE id_rule=$1`, id)
+	return err
+}
+
+// Evaluate the enabled rule of the channel sensor with the channel, return the alert it fired and
+// resolved. A rule fire once its channel matched for its duration, and its alert is resolved by the
+// first channel that doesn't match. A rule has at most one active alert
+func (r *AlertRepository) Evaluate(ctx context.Context, tx helper.Querier, channel entities.Channel) (fired []entities.Alert, resolved []entities.Alert, err error) {
+	sqlStatement := fmt.Sprintf(`
+	SELECT %s FROM alert_rule
+	WHERE alert_rule.id_sensor=$1 AND alert_rule.enabled AND (alert_rule.name IS NULL OR alert_rule.name=$2)`, r.ruleField())
+	rows, err := tx.Query(ctx, sqlStatement, channel.IdSensor, channel.Name)
+	if err != nil {
+		return fired, resolved, err
+	}
+	// Read every rule first, the connection is busy until the rows are closed
+	rules := []entities.AlertRule{}
+	for rows.Next() {
+		var rule entities.AlertRule
+		err := rows.Scan(r.rulePointer(&rule)...)
+		if err != nil {
+			rows.Close()
+			return fired, resolved, err
+		}
+		rules = append(rules, rule)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return fired, resolved, rows.Err()
+	}
+
+	for _, rule := range rules {
+		if !rule.Match(channel.Value) {
+			if rule.PendingSince == nil {
+				continue
+			}
+			_, err = tx.Exec(ctx, `UPDATE alert_rule SET pending_since=NULL WHERE id_rule=$1`, rule.IdRule)
+			if err != nil {
+				return fired, resolved, err
+			}
+			sqlStatement := fmt.Sprintf(`
+			UPDATE alert SET resolved_at=$2 FROM alert_rule
+			WHERE alert_rule.id_rule=alert.id_rule AND alert.id_rule=$1 AND alert.resolved_at IS NULL
+			RETURNING %s`, r.alertField())
+			var alert entities.Alert
+			err = tx.QueryRow(ctx, sqlStatement, rule.IdRule, channel.Time).Scan(r.alertPointer(&alert)...)
+			if errors.Is(err, pgx.ErrNoRows) {
+				// Still pending
+				continue
+			}
+			if err != nil {
+				return fired, resolved, err
+			}
+			resolved = append(resolved, alert)
+			continue
+		}
+
+		var since time.Time
+		err = tx.QueryRow(ctx, `UPDATE alert_rule SET pending_since=COALESCE(pending_since, $2) WHERE id_rule=$1 RETURNING pending_since`, rule.IdRule, channel.Time).Scan(&since)
+		if err != nil {
+			return fired, resolved, err
+		}
+		if channel.Time.Sub(since) < time.Duration(rule.DurationSeconds)*time.Second {
+			continue
+		}
+
+		alert := entities.Alert{IdRule: rule.IdRule, IdSensor: rule.IdSensor, Title: rule.Title, Value: channel.Value, FiredAt: channel.Time}
+		sqlStatement := `
+		INSERT INTO alert (id_rule, value, fired_at) VALUES ($1, $2, $3)
+		ON CONFLICT (id_rule) WHERE resolved_at IS NULL DO NOTHING RETURNING id_alert`
+		err = tx.QueryRow(ctx, sqlStatement, rule.IdRule, channel.Value, channel.Time).Scan(&alert.IdAlert)
+		if errors.Is(err, pgx.ErrNoRows) {
+			// Already active
+			continue
+		}
+		if err != nil {
+			return fired, resolved, err
+		}
+		fired = append(fired, alert)
+	}
+	return fired, resolved, nil
+}
+
+// GetAll return the active alert of the user sensor newest first, with the resolved one when the
+// query ask for it. Every sensor when idUser is 0
+func (r *AlertRepository) GetAll(ctx context.Context, tx helper.Querier, idUser int, query *entities.AlertQuery) (alerts []entities.Alert, err error) {
+	limit := query.Limit
+	if limit == 0 {
+		limit = defaultAlertLimit
+	}
+
+	alerts = []entities.Alert{}
+	sqlStatement := fmt.Sprintf(`
+	SELECT %s FROM alert
+	INNER JOIN alert_rule ON alert_rule.id_rule=alert.id_rule
+	INNER JOIN sensor ON sensor.id_sensor=alert_rule.id_sensor
+	INNER JOIN node ON node.id_node=sensor.id_node
+	WHERE ($1=0 OR node.id_user=$1) AND ($2 OR alert.resolved_at IS NULL)
+	ORDER BY alert.fired_at DESC, alert.id_alert DESC
+	LIMIT $3`, r.alertField())
+	rows, err := tx.Query(ctx, sqlStatement, idUser, query.Resolved, limit)
+	if err != nil {
+		return alerts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var alert entities.Alert
+		err := rows.Scan(r.alertPointer(&alert)...)
+		if err != nil {
+			return alerts, err
+		}
+		alerts = append(alerts, alert)
+	}
+	return alerts, rows.Err()
+}
+
+func (r *AlertRepository) GetById(ctx context.Context, tx helper.Querier, id int) (alert entities.Alert, err error) {
+	sqlStatement := fmt.Sprintf(`
+	SELECT %s FROM alert
+	INNER JOIN alert_rule ON alert_rule.id_rule=alert.id_rule
+	WHERE alert.id_alert=$1`, r.alertField())
+	err = tx.QueryRow(ctx, sqlStatement, id).Scan(r.alertPointer(&alert)...)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return alert, fiber.NewError(404, fmt.Sprintf("Alert with id %d not found", id))
+		}
+		return alert, err
+	}
+	return alert, nil
+}
+
+// Acknowledge the alert, the first acknowledgement is kept
+func (r *AlertRepository) Acknowledge(ctx context.Context, tx helper.Querier, id int) error {
+	_, err := tx.Exec(ctx, `UPDATE alert SET acknowledged_at=NOW() WHERE id_alert=$1 AND acknowledged_at IS NULL`, id)
+	return err
+}
+
+func (r *AlertRepository) targetField() string {
+	return "alert_target.id_target, alert_target.id_user, alert_target.type, alert_target.address, alert_target.enabled, alert_target.last_error"
+}
+
+func (r *AlertRepository) targetPointer(target *entities.AlertTarget) []interface{} {
+	return []interface{}{&target.IdTarget, &target.IdUser, &target.Type, &target.Address, &target.Enabled, &target.LastError}
+}
+
+// GetTargets return the alert target of the user, with the secret when onlyEnabled is true since it
+// is then for the notifier
+func (r *AlertRepository) GetTargets(ctx context.Context, tx helper.Querier, idUser int, onlyEnabled bool) (targets []entities.AlertTarget, err error) {
+	targets = []entities.AlertTarget{}
+	sqlStatement := fmt.Sprintf(`
+	SELECT %s, alert_target.secret FROM alert_target
+	WHERE alert_target.id_user=$1 AND (NOT $2 OR alert_target.enabled)
+	ORDER BY alert_target.id_target`, r.targetField())
+	rows, err := tx.Query(ctx, sqlStatement, idUser, onlyEnabled)
+	if err != nil {
+		return targets, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var target entities.AlertTarget
+		err := rows.Scan(append(r.targetPointer(&target), &target.Secret)...)
+		if err != nil {
+			return targets, err
+		}
+		if !onlyEnabled {
+			target.Secret = ""
+		}
+		targets = append(targets, target)
+	}
+	return targets, rows.Err()
+}
+
+func (r *AlertRepository) GetTargetById(ctx context.Context, tx helper.Querier, id int) (target entities.AlertTarget, err error) {
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM alert_target WHERE alert_target.id_target=$1`, r.targetField())
+	err = tx.QueryRow(ctx, sqlStatement, id).Scan(r.targetPointer(&target)...)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return target, fiber.NewError(404, fmt.Sprintf("Alert target with id %d not found", id))
+		}
+		return target, err
+	}
+	return target, nil
+}
+
+// CreateTarget add the target of the user, a webhook target get the secret used to sign the
+// delivery, it is only returned here
+func (r *AlertRepository) CreateTarget(ctx context.Context, tx helper.Querier, idUser int, payload *entities.AlertTargetCreate) (target entities.AlertTarget, err error) {
+	secret := ""
+	if payload.Type == entities.AlertTargetWebhook {
+		random := make([]byte, 32)
+		_, err = rand.Read(random)
+		if err != nil {
+			return target, err
+		}
+		secret = hex.EncodeToString(random)
+	}
+	enabled := payload.Enabled == nil || *payload.Enabled
+
+	sqlStatement := fmt.Sprintf(`
+	INSERT INTO alert_target (id_user, type, address, secret, enabled)
+	VALUES ($1, $2, $3, $4, $5) RETURNING %s`, r.targetField())
+	err = tx.QueryRow(ctx, sqlStatement, idUser, payload.Type, payload.Address, secret, enabled).Scan(r.targetPointer(&target)...)
+	if err != nil {
+		return target, err
+	}
+	target.Secret = secret
+	return target, nil
+}
+
+func (r *AlertRepository) DeleteTarget(ctx context.Context, tx helper.Querier, id int) error {
+	_, err := tx.Exec(ctx, `DELETE FROM alert_target WHERE id_target=$1`, id)
+	return err
+}
+
+// SetTargetError keep the error of the last delivery to the target, nil clear it
+func (r *AlertRepository) SetTargetError(ctx context.Context, tx helper.Querier, id int, message *string) error {
+	_, err := tx.Exec(ctx, `UPDATE alert_target SET last_error=$2 WHERE id_target=$1`, id, message)
+	return err
+}
@@ -0,0 +1,105 @@
+package repositories
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+// Length of the key start kept to tell the key apart, e.g. nk_AbCd1234
+const apiKeyPrefixLength = 11
+
+// ApiKeyRepository keep the API key a device of the node authenticate with instead of the user token
+type ApiKeyRepository struct{}
+
+func NewApiKeyRepository() (ApiKeyRepository, error) {
+	return ApiKeyRepository{}, nil
+}
+
+// hashApiKey is the stored form of the key, the key is random enough that an unsalted hash is fine
+func hashApiKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// GetByNode return the key of the node, oldest first
+func (r *ApiKeyRepository) GetByNode(ctx context.Context, tx helper.Querier, nodeId int) (keys []entities.NodeApiKey, err error) {
+	sqlStatement := `SELECT id_key, id_node, name, prefix, created_at, last_used_at FROM node_api_key WHERE id_node=$1 ORDER BY id_key`
+	rows, err := tx.Query(ctx, sqlStatement, nodeId)
+	if err != nil {
+		return keys, err
+	}
+	defer rows.Close()
+
+	keys = []entities.NodeApiKey{}
+	for rows.Next() {
+		key := entities.NodeApiKey{}
+		err = rows.Scan(&key.IdKey, &key.IdNode, &key.Name, &key.Prefix, &key.CreatedAt, &key.LastUsedAt)
+		if err != nil {
+			return keys, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// Create generate a new key for the node, the key is returned once and only its hash is stored
+func (r *ApiKeyRepository) Create(ctx context.Context, tx helper.Querier, nodeId int, payload *entities.NodeApiKeyCreate) (created entities.NodeApiKeyCreated, err error) {
+	random := make([]byte, 24)
+	_, err = rand.Read(random)
+	if err != nil {
+		return created, err
+	}
+	created.Key = entities.ApiKeyPrefix + base64.RawURLEncoding.EncodeToString(random)
+
+	created.IdNode = nodeId
+	created.Name = payload.Name
+	created.Prefix = created.Key[:apiKeyPrefixLength]
+	sqlStatement := `INSERT INTO node_api_key (id_node, name, prefix, key_hash) VALUES ($1, $2, $3, $4) RETURNING id_key, created_at`
+	err = tx.QueryRow(ctx, sqlStatement, nodeId, created.Name, created.Prefix, hashApiKey(created.Key)).Scan(&created.IdKey, &created.CreatedAt)
+	return created, err
+}
+
+// Delete revoke the key of the node, a device using it is refused from the next request
+func (r *ApiKeyRepository) Delete(ctx context.Context, tx helper.Querier, nodeId int, keyId int) error {
+	tag, err := tx.Exec(ctx, `DELETE FROM node_api_key WHERE id_node=$1 AND id_key=$2`, nodeId, keyId)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fiber.NewError(404, fmt.Sprintf("API key with id %d not found on node %d", keyId, nodeId))
+	}
+	return nil
+}
+
+// Authenticate return the owner of the node of the key and the node, a 401 when the key is unknown,
+// revoked or its node is deleted. The owner is never an admin so the key can't do more than sending channel. The last
+// use is written at most once a minute
+func (r *ApiKeyRepository) Authenticate(ctx context.Context, tx helper.Querier, key string) (user entities.UserRead, nodeId int, err error) {
+	var keyId int
+	sqlStatement := `
+	SELECT node_api_key.id_key, node_api_key.id_node, user_person.id_user, user_person.email, user_person.username, user_person.status
+	FROM node_api_key
+	INNER JOIN node ON node.id_node=node_api_key.id_node
+	INNER JOIN user_person ON user_person.id_user=node.id_user
+	WHERE node_api_key.key_hash=$1 AND node.deleted_at IS NULL`
+	err = tx.QueryRow(ctx, sqlStatement, hashApiKey(key)).Scan(&keyId, &nodeId, &user.IdUser, &user.Email, &user.Username, &user.Status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return user, 0, fiber.NewError(401, "API key is not valid or is revoked")
+	}
+	if err != nil {
+		return user, 0, err
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE node_api_key SET last_used_at=NOW() WHERE id_key=$1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`, keyId)
+	return user, nodeId, err
+}
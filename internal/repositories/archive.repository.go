@@ -0,0 +1,323 @@
+package repositories
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/archive"
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+// ArchiveRepository move old channel to Parquet file in the object storage, one file per sensor
+// per day. The channel_archive table is the catalog of the file, a file not in the catalog is
+// never read and is deleted by DeleteOrphans
+type ArchiveRepository struct {
+	store  dependencies.ObjectStore
+	prefix string
+}
+
+// NewArchiveRepository use the store for the archived channel, store is nil when s3 is not configured
+func NewArchiveRepository(store dependencies.ObjectStore, config *configs.Config) (ArchiveRepository, error) {
+	return ArchiveRepository{
+		store:  store,
+		prefix: config.Archive.S3Prefix + "channel/",
+	}, nil
+}
+
+// Enabled is true when the archived channel can be read and written
+func (a *ArchiveRepository) Enabled() bool {
+	return a.store != nil
+}
+
+const archiveColumns = "id_sensor, day, object_key, row_count, first_time, last_time, archived_at"
+
+func scanArchive(row pgx.Row, segment *entities.ChannelArchive) error {
+	return row.Scan(&segment.IdSensor, &segment.Day, &segment.ObjectKey, &segment.RowCount, &segment.FirstTime, &segment.LastTime, &segment.ArchivedAt)
+}
+
+// GetSegments return the archived day of the sensor that overlap [from, to), ordered by day
+func (a *ArchiveRepository) GetSegments(ctx context.Context, tx helper.Querier, sensorId int, from *time.Time, to *time.Time) (segments []entities.ChannelArchive, err error) {
+	segments = []entities.ChannelArchive{}
+	sqlStatement := fmt.Sprintf(`
+	SELECT %s FROM channel_archive
+	WHERE id_sensor=$1 AND ($2::TIMESTAMP IS NULL OR last_time >= $2) AND ($3::TIMESTAMP IS NULL OR first_time < $3)
+	ORDER BY day`, archiveColumns)
+	rows, err := tx.Query(ctx, sqlStatement, sensorId, from, to)
+	if err != nil {
+		return segments, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var segment entities.ChannelArchive
+		err := scanArchive(rows, &segment)
+		if err != nil {
+			return segments, err
+		}
+		segments = append(segments, segment)
+	}
+	return segments, rows.Err()
+}
+
+// GetTimeRange return the time of the first and last archived channel, nil if nothing is archived
+func (a *ArchiveRepository) GetTimeRange(ctx context.Context, tx helper.Querier, sensorId int) (first *time.Time, last *time.Time, err error) {
+	sqlStatement := `SELECT min(first_time), max(last_time) FROM channel_archive WHERE id_sensor=$1`
+	err = tx.QueryRow(ctx, sqlStatement, sensorId).Scan(&first, &last)
+	return first, last, err
+}
+
+// ReadSegment download and decode the channel of the archived day, ordered by time
+func (a *ArchiveRepository) ReadSegment(ctx context.Context, segment entities.ChannelArchive) ([]archive.Point, error) {
+	if a.store == nil {
+		return nil, fiber.NewError(fiber.StatusServiceUnavailable, fmt.Sprintf("Channel of sensor %d on %s is archived, but s3 is not configured", segment.IdSensor, segment.Day.Format("2006-01-02")))
+	}
+
+	body, err := a.store.Get(ctx, segment.ObjectKey)
+	if err != nil {
+		return nil, fmt.Errorf("reading archive %s: %w", segment.ObjectKey, err)
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	return archive.Read(data)
+}
+
+// DeleteBefore remove the archived day that end before the time, of every sensor when sensorId is 0.
+// The day that is only partly before is kept. The file is deleted by the next DeleteOrphans
+func (a *ArchiveRepository) DeleteBefore(ctx context.Context, tx helper.Querier, sensorId int, before time.Time) (count int64, err error) {
+	sqlStatement := `DELETE FROM channel_archive WHERE last_time<$1 AND ($2=0 OR id_sensor=$2) RETURNING row_count`
+	rows, err := tx.Query(ctx, sqlStatement, before, sensorId)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rowCount int64
+		err := rows.Scan(&rowCount)
+		if err != nil {
+			return count, err
+		}
+		count += rowCount
+	}
+	return count, rows.Err()
+}
+
+// ArchiveBefore move every channel older than before to the object storage, one sensor day per
+// transaction so a failure only keep that day in the database. A compressed day is moved only when
+// the whole day is before, and like the compression a flagged or named channel stay in the channel table
+func (a *ArchiveRepository) ArchiveBefore(ctx context.Context, db helper.Querier, before time.Time) (days int, count int64, err error) {
+	if a.store == nil {
+		return 0, 0, errors.New("archive need s3 to be configured")
+	}
+
+	sqlStatement := `
+	SELECT id_sensor, date_trunc('day', time) AS day FROM channel WHERE time < $1 AND quality = 'good' AND name = ''
+	UNION
+	SELECT id_sensor, day::TIMESTAMP FROM channel_compressed WHERE (day + 1)::TIMESTAMP <= $1
+	ORDER BY day, id_sensor
+	LIMIT 100`
+	for {
+		type sensorDay struct {
+			idSensor int
+			day      time.Time
+		}
+		pending := []sensorDay{}
+		rows, err := db.Query(ctx, sqlStatement, before)
+		if err != nil {
+			return days, count, err
+		}
+		for rows.Next() {
+			var item sensorDay
+			err := rows.Scan(&item.idSensor, &item.day)
+			if err != nil {
+				rows.Close()
+				return days, count, err
+			}
+			pending = append(pending, item)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return days, count, err
+		}
+		if len(pending) == 0 {
+			return days, count, nil
+		}
+
+		for _, item := range pending {
+			archived, err := a.archiveDay(ctx, db, item.idSensor, item.day, before)
+			if err != nil {
+				return days, count, fmt.Errorf("archiving sensor %d on %s: %w", item.idSensor, item.day.Format("2006-01-02"), err)
+			}
+			days++
+			count += archived
+		}
+	}
+}
+
+// archiveDay move the channel of the sensor in the day (up to before) and its compressed row to a new
+// file, together with the channel already archived for that day. The catalog point to the new file
+// only on commit
+func (a *ArchiveRepository) archiveDay(ctx context.Context, db helper.Querier, sensorId int, day time.Time, before time.Time) (count int64, err error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	points := []archive.Point{}
+	var previous *entities.ChannelArchive
+	segment := entities.ChannelArchive{}
+	err = scanArchive(tx.QueryRow(ctx, fmt.Sprintf(`SELECT %s FROM channel_archive WHERE id_sensor=$1 AND day=$2 FOR UPDATE`, archiveColumns), sensorId, day), &segment)
+	if err == nil {
+		previous = &segment
+		points, err = a.ReadSegment(ctx, segment)
+		if err != nil {
+			return 0, err
+		}
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return 0, err
+	}
+
+	end := day.AddDate(0, 0, 1)
+	if before.Before(end) {
+		end = before
+	}
+	rows, err := tx.Query(ctx, `DELETE FROM channel WHERE id_sensor=$1 AND time>=$2 AND time<$3 AND quality='good' AND name='' RETURNING time, value`, sensorId, day, end)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var point archive.Point
+		err := rows.Scan(&point.Time, &point.Value)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		points = append(points, point)
+		count++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if !before.Before(day.AddDate(0, 0, 1)) {
+		var data []byte
+		err = tx.QueryRow(ctx, `DELETE FROM channel_compressed WHERE id_sensor=$1 AND day=$2 RETURNING data`, sensorId, day).Scan(&data)
+		if err == nil {
+			compressed, err := archive.DecodeDelta(data)
+			if err != nil {
+				return 0, err
+			}
+			points = append(points, compressed...)
+			count += int64(len(compressed))
+		} else if !errors.Is(err, pgx.ErrNoRows) {
+			return 0, err
+		}
+	}
+
+	if count == 0 {
+		return 0, nil
+	}
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].Time.Before(points[j].Time)
+	})
+
+	body := &bytes.Buffer{}
+	err = archive.Write(body, points)
+	if err != nil {
+		return 0, err
+	}
+	// A new key every time, the old file is still read until the catalog is committed
+	key := fmt.Sprintf("%s%d/%s-%d.parquet", a.prefix, sensorId, day.Format("2006-01-02"), time.Now().UnixNano())
+	err = a.store.Put(ctx, key, bytes.NewReader(body.Bytes()))
+	if err != nil {
+		return 0, err
+	}
+
+	sqlStatement := `
+	INSERT INTO channel_archive (id_sensor, day, object_key, row_count, first_time, last_time, archived_at)
+	VALUES ($1, $2, $3, $4, $5, $6, NOW())
+	ON CONFLICT (id_sensor, day) DO UPDATE SET
+		object_key=EXCLUDED.object_key, row_count=EXCLUDED.row_count, first_time=EXCLUDED.first_time,
+		last_time=EXCLUDED.last_time, archived_at=EXCLUDED.archived_at`
+	_, err = tx.Exec(ctx, sqlStatement, sensorId, day, key, len(points), points[0].Time, points[len(points)-1].Time)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// The old file is no longer in the catalog, DeleteOrphans retry it when this fail
+	if previous != nil {
+		err = a.store.Delete(ctx, previous.ObjectKey)
+		if err != nil {
+			log.Printf("[ARCHIVE] Error deleting replaced archive %s: %v", previous.ObjectKey, err)
+		}
+	}
+	return count, nil
+}
+
+// DeleteOrphans delete the archive file that is not in the catalog, left by a deleted sensor,
+// a purge, or a failed archive. Only file older than an hour is deleted so a file of a running
+// archive is not removed before it is committed
+func (a *ArchiveRepository) DeleteOrphans(ctx context.Context, tx helper.Querier) (count int, err error) {
+	if a.store == nil {
+		return 0, nil
+	}
+
+	objects, err := a.store.List(ctx, a.prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := tx.Query(ctx, `SELECT object_key FROM channel_archive`)
+	if err != nil {
+		return 0, err
+	}
+	keys := map[string]bool{}
+	for rows.Next() {
+		var key string
+		err := rows.Scan(&key)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		keys[key] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-time.Hour)
+	for _, object := range objects {
+		if keys[object.Key] || object.ModifiedAt.After(cutoff) {
+			continue
+		}
+		err := a.store.Delete(ctx, object.Key)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,193 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/helper"
+)
+
+type BackupRepository struct{}
+
+func NewBackupRepository() (BackupRepository, error) {
+	return BackupRepository{}, nil
+}
+
+// BackupTable is a table in the backup, IdColumn is its serial column which sequence
+// is moved past the restored id
+type BackupTable struct {
+	Name     string
+	IdColumn string
+}
+
+// Table in the order they are restored, a table come after the table it reference.
+// scheduled_job and sync_state are left out because they are the runtime state of the instance,
+// webhook_delivery so a restore doesn't send the old event again, and channel_checksum and
+// channel_aggregate which are computed again from the restored channel
+var BackupTables = []BackupTable{
+	{Name: "user_person", IdColumn: "id_user"},
+	{Name: "hardware", IdColumn: "id_hardware"},
+	{Name: "node", IdColumn: "id_node"},
+	{Name: "sensor", IdColumn: "id_sensor"},
+	{Name: "channel"},
+	{Name: "channel_compressed"},
+	{Name: "sensor_compression"},
+	{Name: "sensor_validation"},
+	{Name: "sensor_filter"},
+	{Name: "sensor_throttle"},
+	{Name: "sensor_retention"},
+	{Name: "sensor_display"},
+	{Name: "channel_archive"},
+	{Name: "channel_rollup"},
+	{Name: "sensor_tag"},
+	{Name: "feature_flag"},
+	{Name: "user_feature_flag"},
+	{Name: "dashboard_template", IdColumn: "id_template"},
+	{Name: "dashboard", IdColumn: "id_dashboard"},
+	{Name: "dashboard_widget", IdColumn: "id_widget"},
+	{Name: "notification", IdColumn: "id_notification"},
+	{Name: "setting"},
+	{Name: "usage_counter"},
+	{Name: "storage_policy", IdColumn: "id_policy"},
+	{Name: "storage_policy_run", IdColumn: "id_run"},
+	{Name: "webhook", IdColumn: "id_webhook"},
+	{Name: "entity_revision", IdColumn: "id_revision"},
+	{Name: "node_transfer", IdColumn: "id_transfer"},
+	{Name: "kpi", IdColumn: "id_kpi"},
+	{Name: "kpi_value"},
+	{Name: "node_maintenance", IdColumn: "id_maintenance"},
+	{Name: "ingest_stat"},
+	{Name: "sensor_ingest"},
+	{Name: "report", IdColumn: "id_report"},
+	{Name: "hardware_decoder"},
+	{Name: "node_sigfox"},
+	{Name: "sensor_opcua", IdColumn: "id_item"},
+	{Name: "sensor_bacnet", IdColumn: "id_item"},
+	{Name: "sensor_snmp"},
+	{Name: "sensor_snmp_item", IdColumn: "id_item"},
+	{Name: "node_weather", IdColumn: "id_item"},
+	{Name: "sensor_transform"},
+	{Name: "script", IdColumn: "id_script"},
+	{Name: "node_status_page"},
+	{Name: "sync_site"},
+	{Name: "sync_mapping"},
+	{Name: "node_api_key", IdColumn: "id_key"},
+	{Name: "alert_rule", IdColumn: "id_rule"},
+	{Name: "alert", IdColumn: "id_alert"},
+	{Name: "alert_target", IdColumn: "id_target"},
+	{Name: "node_share"},
+	{Name: "firmware", IdColumn: "id_firmware"},
+	{Name: "node_firmware"},
+}
+
+// IsChannelTable is true for the table holding the channel value, they are only in a backup with channel
+func IsChannelTable(table string) bool {
+	return table == "channel" || table == "channel_compressed" || table == "channel_rollup"
+}
+
+// backupCondition limit the channel to the time range, the other table is always complete.
+// A compressed day is included when it overlap the range, a rollup when its bucket start in it
+func (b *BackupRepository) backupCondition(table string, from *time.Time, to *time.Time) (string, []interface{}) {
+	conditions := []string{"TRUE"}
+	args := []interface{}{}
+	if table == "channel" && from != nil {
+		args = append(args, *from)
+		conditions = append(conditions, fmt.Sprintf("t.time >= $%d", len(args)))
+	}
+	if table == "channel" && to != nil {
+		args = append(args, *to)
+		conditions = append(conditions, fmt.Sprintf("t.time < $%d", len(args)))
+	}
+	if table == "channel_compressed" && from != nil {
+		args = append(args, *from)
+		conditions = append(conditions, fmt.Sprintf("t.last_time >= $%d", len(args)))
+	}
+	if table == "channel_compressed" && to != nil {
+		args = append(args, *to)
+		conditions = append(conditions, fmt.Sprintf("t.first_time < $%d", len(args)))
+	}
+	if table == "channel_rollup" && from != nil {
+		args = append(args, *from)
+		conditions = append(conditions, fmt.Sprintf("t.bucket >= $%d", len(args)))
+	}
+	if table == "channel_rollup" && to != nil {
+		args = append(args, *to)
+		conditions = append(conditions, fmt.Sprintf("t.bucket < $%d", len(args)))
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+func (b *BackupRepository) CountRows(ctx context.Context, tx helper.Querier, table string, from *time.Time, to *time.Time) (count int64, err error) {
+	condition, args := b.backupCondition(table, from, to)
+	sqlStatement := fmt.Sprintf(`SELECT COUNT(*) FROM %q t WHERE %s`, table, condition)
+	err = tx.QueryRow(ctx, sqlStatement, args...).Scan(&count)
+	return count, err
+}
+
+// ForEachRow iterate the row of the table as JSON without loading every row to memory.
+// The row is only valid during the fn call
+func (b *BackupRepository) ForEachRow(ctx context.Context, tx helper.Querier, table string, from *time.Time, to *time.Time, fn func(row []byte) error) error {
+	condition, args := b.backupCondition(table, from, to)
+	sqlStatement := fmt.Sprintf(`SELECT row_to_json(t)::TEXT FROM %q t WHERE %s`, table, condition)
+	rows, err := tx.Query(ctx, sqlStatement, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		row := rows.RawValues()[0]
+		err = fn(row)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// InsertRows insert the JSON row written by ForEachRow as is, including the id
+func (b *BackupRepository) InsertRows(ctx context.Context, tx helper.Querier, table string, rows [][]byte) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	payload := make([]byte, 0, len(rows)*64)
+	payload = append(payload, '[')
+	for i, row := range rows {
+		if i > 0 {
+			payload = append(payload, ',')
+		}
+		payload = append(payload, row...)
+	}
+	payload = append(payload, ']')
+
+	sqlStatement := fmt.Sprintf(`INSERT INTO %[1]q SELECT * FROM json_populate_recordset(NULL::%[1]q, $1::JSON)`, table)
+	_, err := tx.Exec(ctx, sqlStatement, string(payload))
+	return err
+}
+
+// ResetSequence move the serial of the column past the biggest id, so the next insert doesn't conflict
+func (b *BackupRepository) ResetSequence(ctx context.Context, tx helper.Querier, table string, column string) error {
+	sqlStatement := fmt.Sprintf(`SELECT setval(pg_get_serial_sequence($1, $2), COALESCE(MAX(%[2]q), 1), MAX(%[2]q) IS NOT NULL) FROM %[1]q`, table, column)
+	_, err := tx.Exec(ctx, sqlStatement, table, column)
+	return err
+}
+
+// HasDeviceData is true when there is any node, sensor, or channel, restore only replace an instance without them
+func (b *BackupRepository) HasDeviceData(ctx context.Context, tx helper.Querier) (exist bool, err error) {
+	sqlStatement := `SELECT EXISTS (SELECT 1 FROM "node") OR EXISTS (SELECT 1 FROM sensor) OR EXISTS (SELECT 1 FROM channel)`
+	err = tx.QueryRow(ctx, sqlStatement).Scan(&exist)
+	return exist, err
+}
+
+// TruncateAll empty every backup table and restart their serial
+func (b *BackupRepository) TruncateAll(ctx context.Context, tx helper.Querier) error {
+	names := []string{}
+	for _, table := range BackupTables {
+		names = append(names, fmt.Sprintf("%q", table.Name))
+	}
+	sqlStatement := fmt.Sprintf(`TRUNCATE %s RESTART IDENTITY CASCADE`, strings.Join(names, ", "))
+	_, err := tx.Exec(ctx, sqlStatement)
+	return err
+}
@@ -0,0 +1,96 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+)
+
+// BacnetRepository keep the BACnet object property mapped to each sensor channel
+type BacnetRepository struct{}
+
+func NewBacnetRepository() (BacnetRepository, error) {
+	return BacnetRepository{}, nil
+}
+
+// GetBySensor return the item of the sensor ordered by channel name
+func (r *BacnetRepository) GetBySensor(ctx context.Context, tx helper.Querier, sensorId int) (items []entities.SensorBacnetItem, err error) {
+	items = []entities.SensorBacnetItem{}
+	rows, err := tx.Query(ctx, `SELECT name, address, object, property, status FROM sensor_bacnet WHERE id_sensor=$1 ORDER BY name`, sensorId)
+	if err != nil {
+		return items, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := entities.SensorBacnetItem{}
+		err = rows.Scan(&item.Name, &item.Address, &item.Object, &item.Property, &item.Status)
+		if err != nil {
+			return items, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// Update replace the item of the sensor, an item keep its status when it read the same property
+func (r *BacnetRepository) Update(ctx context.Context, tx helper.Querier, sensorId int, payload *entities.SensorBacnetUpdate) error {
+	names := make([]string, 0, len(payload.Items))
+	for _, item := range payload.Items {
+		names = append(names, item.Name)
+	}
+	_, err := tx.Exec(ctx, `DELETE FROM sensor_bacnet WHERE id_sensor=$1 AND NOT (name = ANY($2))`, sensorId, names)
+	if err != nil {
+		return err
+	}
+
+	sqlStatement := `
+	INSERT INTO sensor_bacnet (id_sensor, name, address, object, property, status) VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (id_sensor, name) DO UPDATE SET address=EXCLUDED.address, object=EXCLUDED.object, property=EXCLUDED.property,
+		status=CASE
+			WHEN (sensor_bacnet.address, sensor_bacnet.object, sensor_bacnet.property) = (EXCLUDED.address, EXCLUDED.object, EXCLUDED.property)
+			THEN sensor_bacnet.status ELSE EXCLUDED.status
+		END`
+	for _, item := range payload.Items {
+		property := item.Property
+		if property == "" {
+			property = "present-value"
+		}
+		_, err = tx.Exec(ctx, sqlStatement, sensorId, item.Name, item.Address, item.Object, property, entities.BacnetPending)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetAll return every item with the owner of its sensor, for the poller
+func (r *BacnetRepository) GetAll(ctx context.Context, tx helper.Querier) (items []entities.BacnetItem, err error) {
+	sqlStatement := `
+	SELECT b.id_item, b.id_sensor, n.id_user, b.name, b.address, b.object, b.property, b.status
+	FROM sensor_bacnet b JOIN sensor s ON s.id_sensor=b.id_sensor JOIN node n ON n.id_node=s.id_node
+	WHERE s.deleted_at IS NULL AND n.deleted_at IS NULL
+	ORDER BY b.address, b.id_item`
+	rows, err := tx.Query(ctx, sqlStatement)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := entities.BacnetItem{}
+		err = rows.Scan(&item.IdItem, &item.IdSensor, &item.IdUser, &item.Name, &item.Address, &item.Object, &item.Property, &item.Status)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// SetStatus record the result of the last read of the item
+func (r *BacnetRepository) SetStatus(ctx context.Context, tx helper.Querier, itemId int, status string) error {
+	_, err := tx.Exec(ctx, `UPDATE sensor_bacnet SET status=$1 WHERE id_item=$2`, status, itemId)
+	return err
+}
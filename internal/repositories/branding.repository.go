@@ -0,0 +1,106 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+)
+
+const (
+	defaultProductName = "IoT Server V1"
+	defaultLogoUrl     = "/static/image/Bogor_Agricultural_University.png"
+)
+
+type BrandingRepository struct {
+	defaults entities.Branding
+}
+
+func NewBrandingRepository(config *configs.Config) (BrandingRepository, error) {
+	defaults := entities.Branding{
+		ProductName:  config.Branding.ProductName,
+		LogoUrl:      config.Branding.LogoUrl,
+		FooterText:   config.Branding.FooterText,
+		LoginMessage: config.Branding.LoginMessage,
+	}
+	if defaults.ProductName == "" {
+		defaults.ProductName = defaultProductName
+	}
+	if defaults.LogoUrl == "" {
+		defaults.LogoUrl = defaultLogoUrl
+	}
+
+	return BrandingRepository{
+		defaults: defaults,
+	}, nil
+}
+
+// brandingField map the setting name to the branding field
+func brandingField(branding *entities.Branding) map[string]*string {
+	return map[string]*string{
+		"branding.product_name":  &branding.ProductName,
+		"branding.logo_url":      &branding.LogoUrl,
+		"branding.footer_text":   &branding.FooterText,
+		"branding.login_message": &branding.LoginMessage,
+	}
+}
+
+// Get return the config branding with the admin override applied,
+// the config branding is still returned with the error so the page can be rendered
+func (b *BrandingRepository) Get(ctx context.Context, tx helper.Querier) (branding entities.Branding, err error) {
+	branding = b.defaults
+	fields := brandingField(&branding)
+
+	sqlStatement := `SELECT name, value FROM setting WHERE name LIKE 'branding.%'`
+	rows, err := tx.Query(ctx, sqlStatement)
+	if err != nil {
+		return b.defaults, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name, value string
+		err := rows.Scan(&name, &value)
+		if err != nil {
+			return b.defaults, err
+		}
+		if field, ok := fields[name]; ok {
+			*field = value
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return b.defaults, err
+	}
+
+	return branding, nil
+}
+
+func (b *BrandingRepository) Update(ctx context.Context, tx helper.Querier, payload *entities.BrandingUpdate) (err error) {
+	values := map[string]*string{
+		"branding.product_name":  payload.ProductName,
+		"branding.logo_url":      payload.LogoUrl,
+		"branding.footer_text":   payload.FooterText,
+		"branding.login_message": payload.LoginMessage,
+	}
+
+	for name, value := range values {
+		if value == nil {
+			continue
+		}
+
+		if *value == "" {
+			_, err = tx.Exec(ctx, `DELETE FROM setting WHERE name=$1`, name)
+		} else {
+			sqlStatement := `
+			INSERT INTO setting (name, value)
+			VALUES ($1, $2)
+			ON CONFLICT (name) DO UPDATE SET value=EXCLUDED.value`
+			_, err = tx.Exec(ctx, sqlStatement, name, *value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,626 @@
+package repositories
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type BundleRepository struct{}
+
+func NewBundleRepository() (BundleRepository, error) {
+	return BundleRepository{}, nil
+}
+
+const BundleVersion = 1
+
+// bundleIds map the natural key of each row in the instance to its id, see the key function below.
+// When several row share a key the oldest one is used
+type bundleIds struct {
+	users      map[string]int
+	hardware   map[string]int
+	nodes      map[string]int
+	sensors    map[string]int
+	dashboards map[string]int
+}
+
+// bundleOwnedKey is the key of the node and dashboard, their name is only unique per owner
+func bundleOwnedKey(owner string, name string) string {
+	return owner + "/" + name
+}
+
+func bundleSensorKey(owner string, node string, sensor string) string {
+	return owner + "/" + node + "/" + sensor
+}
+
+func bundleFlagKey(user string, name string) string {
+	return user + "/" + name
+}
+
+func (b *BundleRepository) Export(ctx context.Context, tx helper.Querier) (bundle entities.ConfigBundle, err error) {
+	bundle, _, err = b.export(ctx, tx)
+	return bundle, err
+}
+
+func (b *BundleRepository) export(ctx context.Context, tx helper.Querier) (bundle entities.ConfigBundle, ids bundleIds, err error) {
+	bundle = entities.ConfigBundle{
+		Version:      BundleVersion,
+		ExportedAt:   time.Now().UTC(),
+		Users:        []entities.BundleUser{},
+		Hardware:     []entities.BundleHardware{},
+		Nodes:        []entities.BundleNode{},
+		Sensors:      []entities.BundleSensor{},
+		Dashboards:   []entities.BundleDashboard{},
+		FeatureFlags: []entities.BundleFeatureFlag{},
+		Settings:     map[string]string{},
+	}
+	ids = bundleIds{
+		users:      map[string]int{},
+		hardware:   map[string]int{},
+		nodes:      map[string]int{},
+		sensors:    map[string]int{},
+		dashboards: map[string]int{},
+	}
+
+	rows, err := tx.Query(ctx, `SELECT id_user, username, email, isadmin, COALESCE(status, FALSE), theme, language FROM user_person ORDER BY id_user`)
+	if err != nil {
+		return bundle, ids, err
+	}
+	for rows.Next() {
+		var id int
+		user := entities.BundleUser{}
+		err = rows.Scan(&id, &user.Username, &user.Email, &user.IsAdmin, &user.Status, &user.Theme, &user.Language)
+		if err != nil {
+			rows.Close()
+			return bundle, ids, err
+		}
+		ids.users[user.Username] = id
+		bundle.Users = append(bundle.Users, user)
+	}
+	rows.Close()
+
+	rows, err = tx.Query(ctx, `SELECT id_hardware, name, type, description FROM hardware ORDER BY id_hardware`)
+	if err != nil {
+		return bundle, ids, err
+	}
+	for rows.Next() {
+		var id int
+		hardware := entities.BundleHardware{}
+		err = rows.Scan(&id, &hardware.Name, &hardware.Type, &hardware.Description)
+		if err != nil {
+			rows.Close()
+			return bundle, ids, err
+		}
+		if _, exist := ids.hardware[hardware.Name]; exist {
+			continue
+		}
+		ids.hardware[hardware.Name] = id
+		bundle.Hardware = append(bundle.Hardware, hardware)
+	}
+	rows.Close()
+
+	rows, err = tx.Query(ctx, `
+	SELECT n.id_node, u.username, n.name, n.location, h.name
+	FROM "node" n
+	JOIN user_person u ON u.id_user = n.id_user
+	JOIN hardware h ON h.id_hardware = n.id_hardware
+	ORDER BY n.id_node`)
+	if err != nil {
+		return bundle, ids, err
+	}
+	for rows.Next() {
+		var id int
+		node := entities.BundleNode{}
+		err = rows.Scan(&id, &node.Owner, &node.Name, &node.Location, &node.Hardware)
+		if err != nil {
+			rows.Close()
+			return bundle, ids, err
+		}
+		key := bundleOwnedKey(node.Owner, node.Name)
+		if _, exist := ids.nodes[key]; exist {
+			continue
+		}
+		ids.nodes[key] = id
+		bundle.Nodes = append(bundle.Nodes, node)
+	}
+	rows.Close()
+
+	rows, err = tx.Query(ctx, `
+	SELECT s.id_sensor, u.username, n.name, s.name, s.unit, h.name
+	FROM sensor s
+	JOIN "node" n ON n.id_node = s.id_node
+	JOIN user_person u ON u.id_user = n.id_user
+	JOIN hardware h ON h.id_hardware = s.id_hardware
+	ORDER BY s.id_sensor`)
+	if err != nil {
+		return bundle, ids, err
+	}
+	for rows.Next() {
+		var id int
+		sensor := entities.BundleSensor{}
+		err = rows.Scan(&id, &sensor.Owner, &sensor.Node, &sensor.Name, &sensor.Unit, &sensor.Hardware)
+		if err != nil {
+			rows.Close()
+			return bundle, ids, err
+		}
+		key := bundleSensorKey(sensor.Owner, sensor.Node, sensor.Name)
+		if _, exist := ids.sensors[key]; exist {
+			continue
+		}
+		ids.sensors[key] = id
+		bundle.Sensors = append(bundle.Sensors, sensor)
+	}
+	rows.Close()
+
+	rows, err = tx.Query(ctx, `
+	SELECT d.id_dashboard, u.username, d.name, d.columns, COALESCE(n.name, '')
+	FROM dashboard d
+	JOIN user_person u ON u.id_user = d.id_user
+	LEFT JOIN "node" n ON n.id_node = d.id_node
+	ORDER BY d.id_dashboard`)
+	if err != nil {
+		return bundle, ids, err
+	}
+	dashboardIndex := map[int]int{}
+	for rows.Next() {
+		var id int
+		dashboard := entities.BundleDashboard{Widgets: []entities.BundleWidget{}}
+		err = rows.Scan(&id, &dashboard.Owner, &dashboard.Name, &dashboard.Columns, &dashboard.Node)
+		if err != nil {
+			rows.Close()
+			return bundle, ids, err
+		}
+		key := bundleOwnedKey(dashboard.Owner, dashboard.Name)
+		if _, exist := ids.dashboards[key]; exist {
+			continue
+		}
+		ids.dashboards[key] = id
+		dashboardIndex[id] = len(bundle.Dashboards)
+		bundle.Dashboards = append(bundle.Dashboards, dashboard)
+	}
+	rows.Close()
+
+	rows, err = tx.Query(ctx, `
+	SELECT w.id_dashboard, w.type, w.title, COALESCE(n.name, ''), COALESCE(s.name, ''), w.x, w.y, w.width, w.height, w.options
+	FROM dashboard_widget w
+	LEFT JOIN sensor s ON s.id_sensor = w.id_sensor
+	LEFT JOIN "node" n ON n.id_node = s.id_node
+	ORDER BY w.id_dashboard, w.y, w.x, w.id_widget`)
+	if err != nil {
+		return bundle, ids, err
+	}
+	for rows.Next() {
+		var idDashboard int
+		widget := entities.BundleWidget{}
+		err = rows.Scan(&idDashboard, &widget.Type, &widget.Title, &widget.Node, &widget.Sensor, &widget.X, &widget.Y, &widget.Width, &widget.Height, &widget.Options)
+		if err != nil {
+			rows.Close()
+			return bundle, ids, err
+		}
+		index, ok := dashboardIndex[idDashboard]
+		if !ok {
+			continue
+		}
+		bundle.Dashboards[index].Widgets = append(bundle.Dashboards[index].Widgets, widget)
+	}
+	rows.Close()
+
+	rows, err = tx.Query(ctx, `
+	SELECT name, '', enabled FROM feature_flag
+	UNION ALL
+	SELECT f.name, u.username, f.enabled FROM user_feature_flag f JOIN user_person u ON u.id_user = f.id_user
+	ORDER BY 2, 1`)
+	if err != nil {
+		return bundle, ids, err
+	}
+	for rows.Next() {
+		flag := entities.BundleFeatureFlag{}
+		err = rows.Scan(&flag.Name, &flag.User, &flag.Enabled)
+		if err != nil {
+			rows.Close()
+			return bundle, ids, err
+		}
+		bundle.FeatureFlags = append(bundle.FeatureFlags, flag)
+	}
+	rows.Close()
+
+	rows, err = tx.Query(ctx, `SELECT name, value FROM setting ORDER BY name`)
+	if err != nil {
+		return bundle, ids, err
+	}
+	for rows.Next() {
+		var name, value string
+		err = rows.Scan(&name, &value)
+		if err != nil {
+			rows.Close()
+			return bundle, ids, err
+		}
+		bundle.Settings[name] = value
+	}
+	rows.Close()
+
+	return bundle, ids, rows.Err()
+}
+
+// bundleImport keep the state of one import, current is the instance before the import
+type bundleImport struct {
+	ctx     context.Context
+	tx      helper.Querier
+	current entities.ConfigBundle
+	ids     bundleIds
+	result  *entities.BundleImportResult
+}
+
+func (i *bundleImport) record(kind string, key string, exist bool, changed bool) {
+	switch {
+	case !exist:
+		i.result.Changes = append(i.result.Changes, entities.ResourceChange{Kind: kind, Key: key, Action: "create"})
+	case changed:
+		i.result.Changes = append(i.result.Changes, entities.ResourceChange{Kind: kind, Key: key, Action: "update"})
+	default:
+		i.result.Unchanged++
+	}
+}
+
+func (i *bundleImport) userId(username string, where string) (int, error) {
+	id, ok := i.ids.users[username]
+	if !ok {
+		return 0, fiber.NewError(400, fmt.Sprintf("%s: user %s is not in the bundle nor in the instance", where, username))
+	}
+	return id, nil
+}
+
+func (i *bundleImport) hardwareId(name string, where string) (int, error) {
+	id, ok := i.ids.hardware[name]
+	if !ok {
+		return 0, fiber.NewError(400, fmt.Sprintf("%s: hardware %s is not in the bundle nor in the instance", where, name))
+	}
+	return id, nil
+}
+
+func (i *bundleImport) nodeId(owner string, name string, where string) (int, error) {
+	id, ok := i.ids.nodes[bundleOwnedKey(owner, name)]
+	if !ok {
+		return 0, fiber.NewError(400, fmt.Sprintf("%s: node %s of %s is not in the bundle nor in the instance", where, name, owner))
+	}
+	return id, nil
+}
+
+// Import create or update every row of the bundle matched by its natural key, row of the instance
+// missing from the bundle is kept. Importing the same bundle twice change nothing the second time.
+// The caller run it in a transaction and roll it back for a dry run
+func (b *BundleRepository) Import(ctx context.Context, tx helper.Querier, bundle *entities.ConfigBundle) (result entities.BundleImportResult, err error) {
+	result.Changes = []entities.ResourceChange{}
+	if bundle.Version != BundleVersion {
+		return result, fiber.NewError(400, fmt.Sprintf("unsupported bundle version %d", bundle.Version))
+	}
+
+	current, ids, err := b.export(ctx, tx)
+	if err != nil {
+		return result, err
+	}
+	i := &bundleImport{ctx: ctx, tx: tx, current: current, ids: ids, result: &result}
+
+	for _, step := range []func(*entities.ConfigBundle) error{
+		i.importHardware, i.importUsers, i.importNodes, i.importSensors,
+		i.importFeatureFlags, i.importSettings, i.importDashboards,
+	} {
+		err = step(bundle)
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+func (i *bundleImport) importHardware(bundle *entities.ConfigBundle) error {
+	current := map[string]entities.BundleHardware{}
+	for _, hardware := range i.current.Hardware {
+		current[hardware.Name] = hardware
+	}
+
+	for _, hardware := range bundle.Hardware {
+		old, exist := current[hardware.Name]
+		changed := exist && old != hardware
+		i.record("hardware", hardware.Name, exist, changed)
+
+		var err error
+		switch {
+		case !exist:
+			var id int
+			err = i.tx.QueryRow(i.ctx, `INSERT INTO hardware (name, type, description) VALUES ($1, $2, $3) RETURNING id_hardware`,
+				hardware.Name, hardware.Type, hardware.Description).Scan(&id)
+			i.ids.hardware[hardware.Name] = id
+		case changed:
+			_, err = i.tx.Exec(i.ctx, `UPDATE hardware SET type=$1, description=$2 WHERE id_hardware=$3`,
+				hardware.Type, hardware.Description, i.ids.hardware[hardware.Name])
+		}
+		if err != nil {
+			return err
+		}
+		current[hardware.Name] = hardware
+	}
+	return nil
+}
+
+// New user get a random password that no input hash to and an activation token,
+// so they have to reset their password before logging in
+func (i *bundleImport) importUsers(bundle *entities.ConfigBundle) error {
+	current := map[string]entities.BundleUser{}
+	for _, user := range i.current.Users {
+		current[user.Username] = user
+	}
+
+	for _, user := range bundle.Users {
+		if user.Theme == "" {
+			user.Theme = "system"
+		}
+		old, exist := current[user.Username]
+		changed := exist && !reflect.DeepEqual(old, user)
+		i.record("user", user.Username, exist, changed)
+
+		var err error
+		switch {
+		case !exist:
+			password := make([]byte, 32)
+			_, err = rand.Read(password)
+			if err != nil {
+				return err
+			}
+			var id int
+			err = i.tx.QueryRow(i.ctx, `
+			INSERT INTO user_person (username, email, password, status, isadmin, token, theme, language)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id_user`,
+				user.Username, user.Email, "!"+hex.EncodeToString(password), user.Status, user.IsAdmin, uuid.New().String(), user.Theme, user.Language).Scan(&id)
+			i.ids.users[user.Username] = id
+		case changed:
+			_, err = i.tx.Exec(i.ctx, `UPDATE user_person SET email=$1, status=$2, isadmin=$3, theme=$4, language=$5 WHERE id_user=$6`,
+				user.Email, user.Status, user.IsAdmin, user.Theme, user.Language, i.ids.users[user.Username])
+		}
+		if err != nil {
+			return err
+		}
+		current[user.Username] = user
+	}
+	return nil
+}
+
+func (i *bundleImport) importNodes(bundle *entities.ConfigBundle) error {
+	current := map[string]entities.BundleNode{}
+	for _, node := range i.current.Nodes {
+		current[bundleOwnedKey(node.Owner, node.Name)] = node
+	}
+
+	for _, node := range bundle.Nodes {
+		key := bundleOwnedKey(node.Owner, node.Name)
+		idUser, err := i.userId(node.Owner, "node "+key)
+		if err != nil {
+			return err
+		}
+		idHardware, err := i.hardwareId(node.Hardware, "node "+key)
+		if err != nil {
+			return err
+		}
+
+		old, exist := current[key]
+		changed := exist && old != node
+		i.record("node", key, exist, changed)
+
+		switch {
+		case !exist:
+			var id int
+			err = i.tx.QueryRow(i.ctx, `INSERT INTO "node" (name, location, id_user, id_hardware) VALUES ($1, $2, $3, $4) RETURNING id_node`,
+				node.Name, node.Location, idUser, idHardware).Scan(&id)
+			i.ids.nodes[key] = id
+		case changed:
+			_, err = i.tx.Exec(i.ctx, `UPDATE "node" SET location=$1, id_hardware=$2 WHERE id_node=$3`,
+				node.Location, idHardware, i.ids.nodes[key])
+		}
+		if err != nil {
+			return err
+		}
+		current[key] = node
+	}
+	return nil
+}
+
+func (i *bundleImport) importSensors(bundle *entities.ConfigBundle) error {
+	current := map[string]entities.BundleSensor{}
+	for _, sensor := range i.current.Sensors {
+		current[bundleSensorKey(sensor.Owner, sensor.Node, sensor.Name)] = sensor
+	}
+
+	for _, sensor := range bundle.Sensors {
+		key := bundleSensorKey(sensor.Owner, sensor.Node, sensor.Name)
+		idNode, err := i.nodeId(sensor.Owner, sensor.Node, "sensor "+key)
+		if err != nil {
+			return err
+		}
+		idHardware, err := i.hardwareId(sensor.Hardware, "sensor "+key)
+		if err != nil {
+			return err
+		}
+
+		old, exist := current[key]
+		changed := exist && old != sensor
+		i.record("sensor", key, exist, changed)
+
+		switch {
+		case !exist:
+			var id int
+			err = i.tx.QueryRow(i.ctx, `INSERT INTO sensor (name, unit, id_node, id_hardware) VALUES ($1, $2, $3, $4) RETURNING id_sensor`,
+				sensor.Name, sensor.Unit, idNode, idHardware).Scan(&id)
+			i.ids.sensors[key] = id
+		case changed:
+			_, err = i.tx.Exec(i.ctx, `UPDATE sensor SET unit=$1, id_hardware=$2 WHERE id_sensor=$3`,
+				sensor.Unit, idHardware, i.ids.sensors[key])
+		}
+		if err != nil {
+			return err
+		}
+		current[key] = sensor
+	}
+	return nil
+}
+
+func (i *bundleImport) importFeatureFlags(bundle *entities.ConfigBundle) error {
+	current := map[string]bool{}
+	for _, flag := range i.current.FeatureFlags {
+		current[bundleFlagKey(flag.User, flag.Name)] = flag.Enabled
+	}
+
+	for _, flag := range bundle.FeatureFlags {
+		flag.Name = strings.ToLower(flag.Name)
+		key := bundleFlagKey(flag.User, flag.Name)
+		old, exist := current[key]
+		changed := exist && old != flag.Enabled
+		i.record("feature_flag", key, exist, changed)
+		if exist && !changed {
+			continue
+		}
+
+		var err error
+		if flag.User == "" {
+			_, err = i.tx.Exec(i.ctx, `
+			INSERT INTO feature_flag (name, enabled) VALUES ($1, $2)
+			ON CONFLICT (name) DO UPDATE SET enabled=EXCLUDED.enabled`, flag.Name, flag.Enabled)
+		} else {
+			var idUser int
+			idUser, err = i.userId(flag.User, "feature flag "+key)
+			if err != nil {
+				return err
+			}
+			_, err = i.tx.Exec(i.ctx, `
+			INSERT INTO user_feature_flag (id_user, name, enabled) VALUES ($1, $2, $3)
+			ON CONFLICT (id_user, name) DO UPDATE SET enabled=EXCLUDED.enabled`, idUser, flag.Name, flag.Enabled)
+		}
+		if err != nil {
+			return err
+		}
+		current[key] = flag.Enabled
+	}
+	return nil
+}
+
+func (i *bundleImport) importSettings(bundle *entities.ConfigBundle) error {
+	names := []string{}
+	for name := range bundle.Settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := bundle.Settings[name]
+		old, exist := i.current.Settings[name]
+		changed := exist && old != value
+		i.record("setting", name, exist, changed)
+		if exist && !changed {
+			continue
+		}
+
+		_, err := i.tx.Exec(i.ctx, `
+		INSERT INTO setting (name, value) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET value=EXCLUDED.value`, name, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The widgets of a changed dashboard are replaced as a whole
+func (i *bundleImport) importDashboards(bundle *entities.ConfigBundle) error {
+	current := map[string]entities.BundleDashboard{}
+	for _, dashboard := range i.current.Dashboards {
+		current[bundleOwnedKey(dashboard.Owner, dashboard.Name)] = dashboard
+	}
+
+	for _, dashboard := range bundle.Dashboards {
+		key := bundleOwnedKey(dashboard.Owner, dashboard.Name)
+		if dashboard.Columns == 0 {
+			dashboard.Columns = 12
+		}
+		// Same order and shape as the export so an exported dashboard compare equal
+		dashboard.Widgets = append([]entities.BundleWidget{}, dashboard.Widgets...)
+		for index := range dashboard.Widgets {
+			if dashboard.Widgets[index].Sensor == "" {
+				dashboard.Widgets[index].Node = ""
+			}
+		}
+		sort.SliceStable(dashboard.Widgets, func(a, b int) bool {
+			if dashboard.Widgets[a].Y != dashboard.Widgets[b].Y {
+				return dashboard.Widgets[a].Y < dashboard.Widgets[b].Y
+			}
+			return dashboard.Widgets[a].X < dashboard.Widgets[b].X
+		})
+
+		idUser, err := i.userId(dashboard.Owner, "dashboard "+key)
+		if err != nil {
+			return err
+		}
+		var idNode *int
+		if dashboard.Node != "" {
+			id, err := i.nodeId(dashboard.Owner, dashboard.Node, "dashboard "+key)
+			if err != nil {
+				return err
+			}
+			idNode = &id
+		}
+		idSensors := make([]*int, len(dashboard.Widgets))
+		for index, widget := range dashboard.Widgets {
+			if widget.Sensor == "" {
+				continue
+			}
+			sensorKey := bundleSensorKey(dashboard.Owner, widget.Node, widget.Sensor)
+			id, ok := i.ids.sensors[sensorKey]
+			if !ok {
+				return fiber.NewError(400, fmt.Sprintf("dashboard %s: sensor %s is not in the bundle nor in the instance", key, sensorKey))
+			}
+			idSensors[index] = &id
+		}
+
+		old, exist := current[key]
+		changed := exist && !reflect.DeepEqual(old, dashboard)
+		i.record("dashboard", key, exist, changed)
+
+		id := i.ids.dashboards[key]
+		switch {
+		case !exist:
+			err = i.tx.QueryRow(i.ctx, `INSERT INTO dashboard (name, columns, id_user, id_node) VALUES ($1, $2, $3, $4) RETURNING id_dashboard`,
+				dashboard.Name, dashboard.Columns, idUser, idNode).Scan(&id)
+			i.ids.dashboards[key] = id
+		case changed:
+			_, err = i.tx.Exec(i.ctx, `UPDATE dashboard SET columns=$1, id_node=$2 WHERE id_dashboard=$3`, dashboard.Columns, idNode, id)
+			if err == nil {
+				_, err = i.tx.Exec(i.ctx, `DELETE FROM dashboard_widget WHERE id_dashboard=$1`, id)
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		for index, widget := range dashboard.Widgets {
+			_, err = i.tx.Exec(i.ctx, `
+			INSERT INTO dashboard_widget (id_dashboard, type, title, id_sensor, x, y, width, height, options)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+				id, widget.Type, widget.Title, idSensors[index], widget.X, widget.Y, widget.Width, widget.Height, widget.Options)
+			if err != nil {
+				return err
+			}
+		}
+		current[key] = dashboard
+	}
+	return nil
+}
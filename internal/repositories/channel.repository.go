@@ -2,33 +2,1026 @@ package repositories
 
 import (
 	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/dafaath/iot-server/internal/archive"
 	"github.com/dafaath/iot-server/internal/entities"
 	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
 )
 
-type ChannelRepository struct{}
+var channelAggregateFunction = map[string]string{
+	"":     "avg(channel.value)",
+	"avg":  "avg(channel.value)",
+	"min":  "min(channel.value)",
+	"max":  "max(channel.value)",
+	"last": "(array_agg(channel.value ORDER BY channel.time DESC))[1]",
+}
+
+// Aggregate of the channel_aggregate bucket, the same aggregate as channelAggregateFunction
+var partAggregateFunction = map[string]string{
+	"":     "sum(part.sum_value) / sum(part.row_count)::FLOAT",
+	"avg":  "sum(part.sum_value) / sum(part.row_count)::FLOAT",
+	"min":  "min(part.min_value)",
+	"max":  "max(part.max_value)",
+	"last": "(array_agg(part.last_value ORDER BY part.last_time DESC))[1]",
+}
+
+// ChannelRepository read the compressed, archived and rolled up channel together with the channel
+// table. The repository is nil when the channel is never moved out of the table, without
+// aggregateRepository an interval query always read the channel
+type ChannelRepository struct {
+	compressionRepository *CompressionRepository
+	archiveRepository     *ArchiveRepository
+	rollupRepository      *RollupRepository
+	aggregateRepository   *AggregateRepository
+}
+
+func NewChannelRepository(compressionRepository *CompressionRepository, archiveRepository *ArchiveRepository, rollupRepository *RollupRepository, aggregateRepository *AggregateRepository) (ChannelRepository, error) {
+	return ChannelRepository{
+		compressionRepository: compressionRepository,
+		archiveRepository:     archiveRepository,
+		rollupRepository:      rollupRepository,
+		aggregateRepository:   aggregateRepository,
+	}, nil
+}
+
+// channelSegment is a sensor day moved out of the channel table, load decode its channel ordered by time
+type channelSegment struct {
+	day  time.Time
+	load func(ctx context.Context) ([]archive.Point, error)
+}
+
+// getSegments return the compressed, archived, and with withRollup the rolled up day of the sensor in
+// the query range, ordered by day. The compressed data and rollup is read now so the row doesn't have
+// to be queried while iterating the channel
+func (c *ChannelRepository) getSegments(ctx context.Context, tx helper.Querier, sensorId int, query entities.ChannelQuery, withRollup bool) ([]channelSegment, error) {
+	segments := []channelSegment{}
+	if c.compressionRepository != nil {
+		compressed, err := c.compressionRepository.GetSegments(ctx, tx, sensorId, query.From, query.To)
+		if err != nil {
+			return nil, err
+		}
+		for _, segment := range compressed {
+			data := segment.Data
+			segments = append(segments, channelSegment{day: segment.Day, load: func(ctx context.Context) ([]archive.Point, error) {
+				return archive.DecodeDelta(data)
+			}})
+		}
+	}
+
+	if c.archiveRepository != nil {
+		archived, err := c.archiveRepository.GetSegments(ctx, tx, sensorId, query.From, query.To)
+		if err != nil {
+			return nil, err
+		}
+		for _, segment := range archived {
+			segment := segment
+			segments = append(segments, channelSegment{day: segment.Day, load: func(ctx context.Context) ([]archive.Point, error) {
+				return c.archiveRepository.ReadSegment(ctx, segment)
+			}})
+		}
+	}
+
+	if c.rollupRepository != nil && withRollup {
+		rollups, err := c.rollupRepository.GetSegments(ctx, tx, sensorId, query.From, query.To)
+		if err != nil {
+			return nil, err
+		}
+		for len(rollups) > 0 {
+			day := rollups[0].Bucket.Truncate(24 * time.Hour)
+			points := []archive.Point{}
+			for len(rollups) > 0 && rollups[0].Bucket.Truncate(24*time.Hour).Equal(day) {
+				points = append(points, archive.Point{Time: rollups[0].Bucket, Value: rollupValue(rollups[0], query.Aggregate)})
+				rollups = rollups[1:]
+			}
+			segments = append(segments, channelSegment{day: day, load: func(ctx context.Context) ([]archive.Point, error) {
+				return points, nil
+			}})
+		}
+	}
 
-func NewChannelRepository() (ChannelRepository, error) {
-	return ChannelRepository{}, nil
+	sort.SliceStable(segments, func(i, j int) bool {
+		return segments[i].day.Before(segments[j].day)
+	})
+	return segments, nil
 }
 
-func (c *ChannelRepository) Create(ctx context.Context, tx helper.Querier, payload *entities.ChannelCreate) (entities.Channel, error) {
+// rollupValue is the value of the rollup read as a channel at its bucket start, the average unless the
+// query aggregate by min, max or last. An interval query aggregate the rollup like any channel
+func rollupValue(rollup entities.ChannelRollup, aggregate string) float64 {
+	switch aggregate {
+	case "min":
+		return rollup.Min
+	case "max":
+		return rollup.Max
+	case "last":
+		return rollup.Last
+	default:
+		return rollup.Avg
+	}
+}
+
+// Create add the received channel, filteredValue is its value after the sensor filter or nil
+func (c *ChannelRepository) Create(ctx context.Context, tx helper.Querier, payload *entities.ChannelCreate, filteredValue *float64) (entities.Channel, error) {
 	channel := entities.Channel{
 		Time:          time.Now().UTC(),
 		ChannelCreate: *payload,
+		FilteredValue: filteredValue,
+	}
+	if channel.Quality == "" {
+		channel.Quality = entities.QualityGood
 	}
 	sqlStatement := `
 	INSERT INTO "channel" (
 		time, 
 		value, 
-		id_sensor)
-	VALUES ($1, $2, $3)`
-	_, err := tx.Exec(ctx, sqlStatement, channel.Time, channel.Value, channel.IdSensor)
+		id_sensor, 
+		name, 
+		quality, 
+		filtered_value)
+	VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := tx.Exec(ctx, sqlStatement, channel.Time, channel.Value, channel.IdSensor, channel.Name, channel.Quality, channel.FilteredValue)
+	if err != nil {
+		return channel, err
+	}
+
+	return channel, nil
+}
+
+// CreateMany copy the channel at their time in one COPY, the checksum of the day they are added to
+// is dropped
+func (c *ChannelRepository) CreateMany(ctx context.Context, tx pgx.Tx, channels []entities.Channel) (int64, error) {
+	type timeRange struct{ first, last time.Time }
+	ranges := map[int]*timeRange{}
+	rows := make([][]interface{}, len(channels))
+	for i, channel := range channels {
+		if channel.Quality == "" {
+			channel.Quality = entities.QualityGood
+		}
+		rows[i] = []interface{}{channel.Time, channel.Value, channel.IdSensor, channel.Name, channel.Quality}
+
+		sensorRange := ranges[channel.IdSensor]
+		if sensorRange == nil {
+			ranges[channel.IdSensor] = &timeRange{channel.Time, channel.Time}
+			continue
+		}
+		if channel.Time.Before(sensorRange.first) {
+			sensorRange.first = channel.Time
+		}
+		if channel.Time.After(sensorRange.last) {
+			sensorRange.last = channel.Time
+		}
+	}
+
+	count, err := tx.CopyFrom(ctx, pgx.Identifier{"channel"}, []string{"time", "value", "id_sensor", "name", "quality"}, pgx.CopyFromRows(rows))
+	if err != nil {
+		return count, err
+	}
+	for sensorId, sensorRange := range ranges {
+		err = invalidateChecksum(ctx, tx, sensorId, sensorRange.first, sensorRange.last)
+		if err != nil {
+			return count, err
+		}
+		err = invalidateAggregate(ctx, tx, sensorId, sensorRange.first)
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// Coalesce replace the value of the channel of the same sensor and name stored at the time by the
+// received channel, for the channel of a throttled sensor
+func (c *ChannelRepository) Coalesce(ctx context.Context, tx helper.Querier, at time.Time, payload *entities.ChannelCreate, filteredValue *float64) (entities.Channel, error) {
+	channel := entities.Channel{
+		Time:          at,
+		ChannelCreate: *payload,
+		FilteredValue: filteredValue,
+	}
+	if channel.Quality == "" {
+		channel.Quality = entities.QualityGood
+	}
+	sqlStatement := `
+	UPDATE "channel" SET value=$1, quality=$2, filtered_value=$3
+	WHERE id_sensor=$4 AND name=$5 AND time=$6`
+	_, err := tx.Exec(ctx, sqlStatement, channel.Value, channel.Quality, channel.FilteredValue, channel.IdSensor, channel.Name, channel.Time)
+	if err != nil {
+		return channel, err
+	}
+	err = invalidateAggregate(ctx, tx, channel.IdSensor, channel.Time)
 	if err != nil {
 		return channel, err
 	}
 
 	return channel, nil
 }
+
+// channelValue is the value column read by the query, the filtered value when the query ask for it
+func channelValue(query entities.ChannelQuery) string {
+	if query.Filtered {
+		return "COALESCE(channel.filtered_value, channel.value)"
+	}
+	return "channel.value"
+}
+
+// channelCondition is the WHERE of the sensor channel in the query time range, quality and name
+func channelCondition(sensorId int, query entities.ChannelQuery) (string, []interface{}) {
+	conditions := []string{"channel.id_sensor=$1"}
+	args := []interface{}{sensorId}
+	if query.From != nil {
+		args = append(args, *query.From)
+		conditions = append(conditions, fmt.Sprintf("channel.time>=$%d", len(args)))
+	}
+	if query.To != nil {
+		args = append(args, *query.To)
+		conditions = append(conditions, fmt.Sprintf("channel.time<$%d", len(args)))
+	}
+	if qualities := query.Qualities(); qualities != nil {
+		args = append(args, qualities)
+		conditions = append(conditions, fmt.Sprintf("channel.quality = ANY($%d)", len(args)))
+	}
+	if name := query.ChannelName(); name != nil {
+		args = append(args, *name)
+		conditions = append(conditions, fmt.Sprintf("channel.name=$%d", len(args)))
+	}
+	if len(query.Fields) > 0 {
+		args = append(args, query.Fields)
+		conditions = append(conditions, fmt.Sprintf("channel.name = ANY($%d)", len(args)))
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// ForEachBySensor iterate the sensor channel ordered by time without loading every row to memory.
+// When query.Interval is set the rows are aggregated per interval bucket, by default only the good one.
+// Iteration stop when fn return an error, and the error is returned.
+func (c *ChannelRepository) ForEachBySensor(ctx context.Context, tx helper.Querier, sensorId int, query entities.ChannelQuery, fn func(channel entities.Channel) error) error {
+	if query.Interval > 0 && len(query.Fields) > 0 {
+		return c.forEachField(ctx, tx, sensorId, query, fn)
+	}
+	return c.forEach(ctx, tx, sensorId, query, true, fn)
+}
+
+// forEachField aggregate the channel of each field one after the other, ordered by field then time.
+// The bucket has the name of its field
+func (c *ChannelRepository) forEachField(ctx context.Context, tx helper.Querier, sensorId int, query entities.ChannelQuery, fn func(channel entities.Channel) error) error {
+	for _, field := range query.Fields {
+		name := field
+		fieldQuery := query
+		fieldQuery.Fields = nil
+		fieldQuery.Name = &name
+		err := c.forEach(ctx, tx, sensorId, fieldQuery, true, func(channel entities.Channel) error {
+			channel.Name = name
+			return fn(channel)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForEachRawBySensor is ForEachBySensor without the rollup, only the channel as it was received
+func (c *ChannelRepository) ForEachRawBySensor(ctx context.Context, tx helper.Querier, sensorId int, query entities.ChannelQuery, fn func(channel entities.Channel) error) error {
+	return c.forEach(ctx, tx, sensorId, query, false, fn)
+}
+
+func (c *ChannelRepository) forEach(ctx context.Context, tx helper.Querier, sensorId int, query entities.ChannelQuery, withRollup bool, fn func(channel entities.Channel) error) error {
+	segments, err := c.getSegments(ctx, tx, sensorId, query, withRollup)
+	if err != nil {
+		return err
+	}
+	if len(segments) > 0 {
+		return c.forEachWithSegments(ctx, tx, sensorId, query, segments, fn)
+	}
+
+	where, args := channelCondition(sensorId, query)
+	var sqlStatement string
+	var scanRaw bool
+	if query.Interval > 0 {
+		aggregate, ok := channelAggregateFunction[query.Aggregate]
+		if !ok {
+			return fiber.NewError(400, fmt.Sprintf("Aggregate %s is not supported, use avg, min, max, or last", query.Aggregate))
+		}
+		aggregated, err := c.forEachAggregated(ctx, tx, sensorId, query, fn)
+		if err != nil || aggregated {
+			return err
+		}
+		aggregate = strings.ReplaceAll(aggregate, "channel.value", channelValue(query))
+		args = append(args, query.Interval.Seconds())
+		bucket := fmt.Sprintf("to_timestamp(floor(extract(epoch FROM channel.time) / $%[1]d) * $%[1]d) AT TIME ZONE 'UTC'", len(args))
+		sqlStatement = fmt.Sprintf(`SELECT %s AS bucket, %s, channel.id_sensor FROM "channel" WHERE %s GROUP BY bucket, channel.id_sensor ORDER BY bucket`, bucket, aggregate, where)
+	} else {
+		sqlStatement = fmt.Sprintf(`SELECT channel.time, %s, channel.id_sensor, channel.name, channel.quality, channel.filtered_value FROM "channel" WHERE %s ORDER BY channel.time`, channelValue(query), where)
+		scanRaw = true
+	}
+
+	rows, err := tx.Query(ctx, sqlStatement, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var channel entities.Channel
+	for rows.Next() {
+		dest := []interface{}{&channel.Time, &channel.Value, &channel.IdSensor}
+		if scanRaw {
+			dest = append(dest, &channel.Name, &channel.Quality, &channel.FilteredValue)
+		}
+		err := rows.Scan(dest...)
+		if err != nil {
+			return err
+		}
+
+		err = fn(channel)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// aggregateResolution return the summary resolution an interval query can read, 0 when it has to read
+// the channel. The summary only has the good unnamed received value, and its bucket must fit in the interval
+func (c *ChannelRepository) aggregateResolution(query entities.ChannelQuery) time.Duration {
+	if c.aggregateRepository == nil || query.Interval <= 0 || query.Filtered {
+		return 0
+	}
+	if qualities := query.Qualities(); len(qualities) != 1 || qualities[0] != entities.QualityGood {
+		return 0
+	}
+	if name := query.ChannelName(); name == nil || *name != "" {
+		return 0
+	}
+	for _, resolution := range aggregateResolutions {
+		if query.Interval%resolution == 0 {
+			return resolution
+		}
+	}
+	return 0
+}
+
+// forEachAggregated is the interval query of forEach reading the summary for the whole bucket until
+// the summary is refreshed, and the channel for the start and end of the range that don't fill a
+// bucket and after the refresh. It is false when the query can't use the summary
+func (c *ChannelRepository) forEachAggregated(ctx context.Context, tx helper.Querier, sensorId int, query entities.ChannelQuery, fn func(channel entities.Channel) error) (bool, error) {
+	resolution := c.aggregateResolution(query)
+	if resolution == 0 {
+		return false, nil
+	}
+	until, err := c.aggregateRepository.getRefreshedUntil(ctx, tx, sensorId, resolution)
+	if err != nil || until == nil {
+		return false, err
+	}
+
+	var from *time.Time
+	if query.From != nil {
+		bucket := query.From.UTC().Truncate(resolution)
+		if bucket.Before(*query.From) {
+			bucket = bucket.Add(resolution)
+		}
+		from = &bucket
+	}
+	to := *until
+	if query.To != nil {
+		if bucket := query.To.UTC().Truncate(resolution); bucket.Before(to) {
+			to = bucket
+		}
+	}
+	if from != nil && !from.Before(to) {
+		return false, nil
+	}
+
+	where, args := channelCondition(sensorId, query)
+	args = append(args, int(resolution.Seconds()), from, to, query.Interval.Seconds())
+	resolutionArg, fromArg, toArg, intervalArg := len(args)-3, len(args)-2, len(args)-1, len(args)
+	sqlStatement := fmt.Sprintf(`
+	WITH part AS (
+		SELECT bucket AS time, row_count, sum_value, min_value, max_value, last_value, last_time FROM channel_aggregate
+		WHERE id_sensor=$1 AND resolution=$%[1]d AND ($%[2]d::TIMESTAMP IS NULL OR bucket>=$%[2]d) AND bucket<$%[3]d
+		UNION ALL
+		SELECT channel.time, 1, channel.value, channel.value, channel.value, channel.value, channel.time FROM "channel"
+		WHERE %[5]s AND NOT (($%[2]d::TIMESTAMP IS NULL OR channel.time>=$%[2]d) AND channel.time<$%[3]d)
+	)
+	SELECT to_timestamp(floor(extract(epoch FROM part.time) / $%[4]d) * $%[4]d) AT TIME ZONE 'UTC' AS bucket, %[6]s
+	FROM part GROUP BY bucket ORDER BY bucket`, resolutionArg, fromArg, toArg, intervalArg, where, partAggregateFunction[query.Aggregate])
+
+	rows, err := tx.Query(ctx, sqlStatement, args...)
+	if err != nil {
+		return true, err
+	}
+	defer rows.Close()
+
+	channel := entities.Channel{ChannelCreate: entities.ChannelCreate{IdSensor: sensorId}}
+	for rows.Next() {
+		err := rows.Scan(&channel.Time, &channel.Value)
+		if err != nil {
+			return true, err
+		}
+
+		err = fn(channel)
+		if err != nil {
+			return true, err
+		}
+	}
+	return true, rows.Err()
+}
+
+// GetTimeRangeBySensor return the time of the first and last channel, nil if the sensor has no channel
+func (c *ChannelRepository) GetTimeRangeBySensor(ctx context.Context, tx helper.Querier, sensorId int) (first *time.Time, last *time.Time, err error) {
+	sqlStatement := `SELECT min(channel.time), max(channel.time) FROM "channel" WHERE channel.id_sensor=$1`
+	err = tx.QueryRow(ctx, sqlStatement, sensorId).Scan(&first, &last)
+	if err != nil {
+		return first, last, err
+	}
+
+	ranges := []func(ctx context.Context, tx helper.Querier, sensorId int) (*time.Time, *time.Time, error){}
+	if c.compressionRepository != nil {
+		ranges = append(ranges, c.compressionRepository.GetTimeRange)
+	}
+	if c.archiveRepository != nil {
+		ranges = append(ranges, c.archiveRepository.GetTimeRange)
+	}
+	if c.rollupRepository != nil {
+		ranges = append(ranges, c.rollupRepository.GetTimeRange)
+	}
+	for _, getRange := range ranges {
+		segmentFirst, segmentLast, err := getRange(ctx, tx, sensorId)
+		if err != nil {
+			return first, last, err
+		}
+		if segmentFirst != nil && (first == nil || segmentFirst.Before(*first)) {
+			first = segmentFirst
+		}
+		if segmentLast != nil && (last == nil || segmentLast.After(*last)) {
+			last = segmentLast
+		}
+	}
+	return first, last, nil
+}
+
+// DeleteBefore delete the channel older than before, of every sensor when sensorId is 0.
+// A compressed or archived day and a rollup is only deleted when it end before the time
+func (c *ChannelRepository) DeleteBefore(ctx context.Context, tx helper.Querier, sensorId int, before time.Time) (count int64, err error) {
+	count, err = c.DeleteRawBefore(ctx, tx, sensorId, before)
+	if err != nil || c.rollupRepository == nil {
+		return count, err
+	}
+
+	rolledUp, err := c.rollupRepository.DeleteBefore(ctx, tx, sensorId, before)
+	return count + rolledUp, err
+}
+
+// DeleteRawBefore is DeleteBefore keeping the rollup
+func (c *ChannelRepository) DeleteRawBefore(ctx context.Context, tx helper.Querier, sensorId int, before time.Time) (count int64, err error) {
+	sqlStatement := `DELETE FROM "channel" WHERE channel.time<$1 AND ($2=0 OR channel.id_sensor=$2)`
+	res, err := tx.Exec(ctx, sqlStatement, before, sensorId)
+	if err != nil {
+		return 0, err
+	}
+	count = res.RowsAffected()
+	err = invalidateChecksum(ctx, tx, sensorId, time.Time{}, before)
+	if err != nil {
+		return count, err
+	}
+	err = deleteAggregateBefore(ctx, tx, sensorId, before)
+	if err != nil {
+		return count, err
+	}
+
+	if c.compressionRepository != nil {
+		compressed, err := c.compressionRepository.DeleteBefore(ctx, tx, sensorId, before)
+		if err != nil {
+			return count, err
+		}
+		count += compressed
+	}
+	if c.archiveRepository != nil {
+		archived, err := c.archiveRepository.DeleteBefore(ctx, tx, sensorId, before)
+		if err != nil {
+			return count, err
+		}
+		count += archived
+	}
+	return count, nil
+}
+
+// GetLatestBySensors return the last channel of each sensor, sensor without channel is not included
+func (c *ChannelRepository) GetLatestBySensors(ctx context.Context, tx helper.Querier, sensorIds []int) (channels []entities.Channel, err error) {
+	sqlStatement := `SELECT DISTINCT ON (channel.id_sensor) channel.time, channel.value, channel.id_sensor, channel.name, channel.quality FROM "channel" WHERE channel.id_sensor = ANY($1) ORDER BY channel.id_sensor, channel.time DESC`
+	rows, err := tx.Query(ctx, sqlStatement, sensorIds)
+	if err != nil {
+		return channels, err
+	}
+	defer rows.Close()
+
+	channels = []entities.Channel{}
+	for rows.Next() {
+		var channel entities.Channel
+		err := rows.Scan(
+			&channel.Time, &channel.Value, &channel.IdSensor, &channel.Name, &channel.Quality,
+		)
+		if err != nil {
+			return channels, err
+		}
+		channels = append(channels, channel)
+	}
+	return channels, rows.Err()
+}
+
+// GetAfterBySensor return up to limit channel of the sensor stored after the time, oldest first
+func (c *ChannelRepository) GetAfterBySensor(ctx context.Context, tx helper.Querier, sensorId int, after time.Time, limit int) (channels []entities.Channel, err error) {
+	sqlStatement := `SELECT channel.time, channel.value, channel.id_sensor, channel.name, channel.quality, channel.filtered_value FROM "channel" WHERE channel.id_sensor=$1 AND channel.time > $2 ORDER BY channel.time LIMIT $3`
+	rows, err := tx.Query(ctx, sqlStatement, sensorId, after, limit)
+	if err != nil {
+		return channels, err
+	}
+	defer rows.Close()
+
+	channels = []entities.Channel{}
+	for rows.Next() {
+		var channel entities.Channel
+		err := rows.Scan(
+			&channel.Time, &channel.Value, &channel.IdSensor, &channel.Name, &channel.Quality, &channel.FilteredValue,
+		)
+		if err != nil {
+			return channels, err
+		}
+		channels = append(channels, channel)
+	}
+	return channels, rows.Err()
+}
+
+// GetNamesBySensor return the name of the channel of the sensor, "" is the unnamed channel
+func (c *ChannelRepository) GetNamesBySensor(ctx context.Context, tx helper.Querier, sensorId int) (names []string, err error) {
+	names = []string{}
+	sqlStatement := `
+	SELECT DISTINCT channel.name FROM "channel" WHERE channel.id_sensor=$1
+	UNION SELECT '' WHERE EXISTS (SELECT 1 FROM channel_compressed WHERE id_sensor=$1)
+		OR EXISTS (SELECT 1 FROM channel_archive WHERE id_sensor=$1)
+		OR EXISTS (SELECT 1 FROM channel_rollup WHERE id_sensor=$1)
+	ORDER BY 1`
+	rows, err := tx.Query(ctx, sqlStatement, sensorId)
+	if err != nil {
+		return names, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		err := rows.Scan(&name)
+		if err != nil {
+			return names, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// GetOutOfRangeBySensor return the newest channel with value below min or above max, a nil bound is not checked
+func (c *ChannelRepository) GetOutOfRangeBySensor(ctx context.Context, tx helper.Querier, sensorId int, min *float64, max *float64, limit int) (channels []entities.Channel, err error) {
+	channels = []entities.Channel{}
+	if min == nil && max == nil {
+		return channels, nil
+	}
+
+	sqlStatement := `
+	SELECT channel.time, channel.value, channel.id_sensor, channel.name, channel.quality FROM "channel"
+	WHERE channel.id_sensor=$1 AND (channel.value < $2 OR channel.value > $3)
+	ORDER BY channel.time DESC LIMIT $4`
+	rows, err := tx.Query(ctx, sqlStatement, sensorId, min, max, limit)
+	if err != nil {
+		return channels, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var channel entities.Channel
+		err := rows.Scan(
+			&channel.Time, &channel.Value, &channel.IdSensor, &channel.Name, &channel.Quality,
+		)
+		if err != nil {
+			return channels, err
+		}
+		channels = append(channels, channel)
+	}
+	return channels, rows.Err()
+}
+
+// GetOutOfRangeBySensorBetween is GetOutOfRangeBySensor of the channel in [from, to)
+func (c *ChannelRepository) GetOutOfRangeBySensorBetween(ctx context.Context, tx helper.Querier, sensorId int, min *float64, max *float64, from time.Time, to time.Time, limit int) (channels []entities.Channel, err error) {
+	channels = []entities.Channel{}
+	if min == nil && max == nil {
+		return channels, nil
+	}
+
+	sqlStatement := `
+	SELECT channel.time, channel.value, channel.id_sensor, channel.name, channel.quality FROM "channel"
+	WHERE channel.id_sensor=$1 AND (channel.value < $2 OR channel.value > $3) AND channel.time >= $4 AND channel.time < $5
+	ORDER BY channel.time DESC LIMIT $6`
+	rows, err := tx.Query(ctx, sqlStatement, sensorId, min, max, from, to, limit)
+	if err != nil {
+		return channels, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var channel entities.Channel
+		err := rows.Scan(
+			&channel.Time, &channel.Value, &channel.IdSensor, &channel.Name, &channel.Quality,
+		)
+		if err != nil {
+			return channels, err
+		}
+		channels = append(channels, channel)
+	}
+	return channels, rows.Err()
+}
+
+// CountOutOfRangeBySensor count the channel in [from, to) with value below min or above max, a nil bound is not checked
+func (c *ChannelRepository) CountOutOfRangeBySensor(ctx context.Context, tx helper.Querier, sensorId int, min *float64, max *float64, from time.Time, to time.Time) (count int64, err error) {
+	if min == nil && max == nil {
+		return 0, nil
+	}
+
+	sqlStatement := `
+	SELECT COUNT(*) FROM "channel"
+	WHERE channel.id_sensor=$1 AND (channel.value < $2 OR channel.value > $3) AND channel.time >= $4 AND channel.time < $5`
+	err = tx.QueryRow(ctx, sqlStatement, sensorId, min, max, from, to).Scan(&count)
+	return count, err
+}
+
+// Summarize the sensor in [from, to). A gap is a time the sensor sent nothing for longer than
+// slaOnlineWindow, the alert is left to the caller
+func (c *ChannelRepository) Summarize(ctx context.Context, tx helper.Querier, sensor entities.Sensor, from time.Time, to time.Time) (summary entities.SensorSummary, err error) {
+	summary = entities.SensorSummary{IdSensor: sensor.IdSensor, Name: sensor.Name, Unit: sensor.Unit}
+	up := []entities.TimeWindow{}
+	var sum float64
+	var count int64
+	since := from.Add(-slaOnlineWindow)
+	err = c.ForEachRawBySensor(ctx, tx, sensor.IdSensor, entities.ChannelQuery{From: &since, To: &to}, func(channel entities.Channel) error {
+		window := entities.TimeWindow{Start: channel.Time.UTC(), End: channel.Time.UTC().Add(slaOnlineWindow)}
+		last := len(up) - 1
+		if last >= 0 && !window.Start.After(up[last].End) {
+			up[last].End = window.End
+		} else {
+			up = append(up, window)
+		}
+
+		if channel.Time.Before(from) {
+			return nil
+		}
+		summary.Count++
+		if channel.Name != "" || (channel.Quality != "" && channel.Quality != "good") {
+			return nil
+		}
+		value := channel.Value
+		if channel.FilteredValue != nil {
+			value = *channel.FilteredValue
+		}
+		if summary.Min == nil || value < *summary.Min {
+			summary.Min = &value
+		}
+		if summary.Max == nil || value > *summary.Max {
+			summary.Max = &value
+		}
+		sum += value
+		count++
+		return nil
+	})
+	if err != nil {
+		return summary, err
+	}
+	if count > 0 {
+		avg := sum / float64(count)
+		summary.Avg = &avg
+	}
+	summary.Gaps = subtractWindows([]entities.TimeWindow{{Start: from, End: to}}, up)
+	return summary, nil
+}
+
+// SetQuality flag the channel of the sensor in [from, to), the compressed and archived channel
+// can't be flagged anymore
+func (c *ChannelRepository) SetQuality(ctx context.Context, tx helper.Querier, sensorId int, from time.Time, to time.Time, quality string) (count int64, err error) {
+	sqlStatement := `UPDATE "channel" SET quality=$4 WHERE channel.id_sensor=$1 AND channel.time>=$2 AND channel.time<$3`
+	res, err := tx.Exec(ctx, sqlStatement, sensorId, from, to, quality)
+	if err != nil {
+		return 0, err
+	}
+	err = invalidateChecksum(ctx, tx, sensorId, from, to)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), invalidateAggregate(ctx, tx, sensorId, from)
+}
+
+// MoveSensor move every channel of the sensor fromId to the sensor toId, with its compressed,
+// archived and rolled up day. A compressed or archived day that toId also has is decoded back into
+// the channel table, the next compression or archive run merge it with the day of toId. The count
+// is the number of channel moved without the rollup
+func (c *ChannelRepository) MoveSensor(ctx context.Context, tx helper.Querier, fromId int, toId int) (count int64, err error) {
+	res, err := tx.Exec(ctx, `UPDATE "channel" SET id_sensor=$2 WHERE channel.id_sensor=$1`, fromId, toId)
+	if err != nil {
+		return 0, err
+	}
+	count = res.RowsAffected()
+	_, err = tx.Exec(ctx, `DELETE FROM channel_checksum WHERE id_sensor=$1 OR id_sensor=$2`, fromId, toId)
+	if err != nil {
+		return count, err
+	}
+	for _, sensorId := range []int{fromId, toId} {
+		err = invalidateAggregate(ctx, tx, sensorId, time.Time{})
+		if err != nil {
+			return count, err
+		}
+	}
+
+	// Compressed day of both sensor
+	rows, err := tx.Query(ctx, `
+	DELETE FROM channel_compressed WHERE id_sensor=$1 AND day IN (SELECT day FROM channel_compressed WHERE id_sensor=$2)
+	RETURNING data`, fromId, toId)
+	if err != nil {
+		return count, err
+	}
+	compressed := [][]byte{}
+	for rows.Next() {
+		var data []byte
+		err := rows.Scan(&data)
+		if err != nil {
+			rows.Close()
+			return count, err
+		}
+		compressed = append(compressed, data)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+	for _, data := range compressed {
+		points, err := archive.DecodeDelta(data)
+		if err != nil {
+			return count, err
+		}
+		err = insertPoints(ctx, tx, toId, points)
+		if err != nil {
+			return count, err
+		}
+		count += int64(len(points))
+	}
+
+	// Archived day of both sensor, the file is deleted by the next archive job
+	rows, err = tx.Query(ctx, fmt.Sprintf(`
+	DELETE FROM channel_archive WHERE id_sensor=$1 AND day IN (SELECT day FROM channel_archive WHERE id_sensor=$2)
+	RETURNING %s`, archiveColumns), fromId, toId)
+	if err != nil {
+		return count, err
+	}
+	archived := []entities.ChannelArchive{}
+	for rows.Next() {
+		var segment entities.ChannelArchive
+		err := scanArchive(rows, &segment)
+		if err != nil {
+			rows.Close()
+			return count, err
+		}
+		archived = append(archived, segment)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+	if len(archived) > 0 && c.archiveRepository == nil {
+		return count, fmt.Errorf("sensor %d has archived channel but the archive is not configured", fromId)
+	}
+	for _, segment := range archived {
+		points, err := c.archiveRepository.ReadSegment(ctx, segment)
+		if err != nil {
+			return count, err
+		}
+		err = insertPoints(ctx, tx, toId, points)
+		if err != nil {
+			return count, err
+		}
+		count += int64(len(points))
+	}
+
+	var moved int64
+	sqlStatement := `
+	WITH compressed AS (
+		UPDATE channel_compressed SET id_sensor=$2 WHERE id_sensor=$1 RETURNING row_count
+	), archived AS (
+		UPDATE channel_archive SET id_sensor=$2 WHERE id_sensor=$1 RETURNING row_count
+	)
+	SELECT COALESCE((SELECT SUM(row_count) FROM compressed), 0) + COALESCE((SELECT SUM(row_count) FROM archived), 0)`
+	err = tx.QueryRow(ctx, sqlStatement, fromId, toId).Scan(&moved)
+	if err != nil {
+		return count, err
+	}
+	count += moved
+
+	sqlStatement = `
+	INSERT INTO channel_rollup (id_sensor, resolution, bucket, row_count, avg_value, min_value, max_value, last_value)
+	SELECT $2, resolution, bucket, row_count, avg_value, min_value, max_value, last_value FROM channel_rollup WHERE id_sensor=$1
+	ON CONFLICT (id_sensor, resolution, bucket) DO UPDATE SET
+		avg_value=(channel_rollup.avg_value*channel_rollup.row_count + EXCLUDED.avg_value*EXCLUDED.row_count) / (channel_rollup.row_count + EXCLUDED.row_count),
+		row_count=channel_rollup.row_count + EXCLUDED.row_count,
+		min_value=LEAST(channel_rollup.min_value, EXCLUDED.min_value),
+		max_value=GREATEST(channel_rollup.max_value, EXCLUDED.max_value)`
+	_, err = tx.Exec(ctx, sqlStatement, fromId, toId)
+	if err != nil {
+		return count, err
+	}
+	_, err = tx.Exec(ctx, `DELETE FROM channel_rollup WHERE id_sensor=$1`, fromId)
+	return count, err
+}
+
+// insertPoints add the decoded channel back to the channel table as good channel
+func insertPoints(ctx context.Context, tx helper.Querier, sensorId int, points []archive.Point) error {
+	times := make([]time.Time, len(points))
+	values := make([]float64, len(points))
+	for i, point := range points {
+		times[i] = point.Time
+		values[i] = point.Value
+	}
+	sqlStatement := `INSERT INTO "channel" (time, value, id_sensor) SELECT unnest($1::TIMESTAMP[]), unnest($2::FLOAT[]), $3`
+	_, err := tx.Exec(ctx, sqlStatement, times, values, sensorId)
+	return err
+}
+
+// forEachWithSegments merge the compressed and archived day with the channel table by time, one day
+// is decoded at a time. The aggregate is computed here because part of the row is not in postgres,
+// with the same bucket as the SQL of ForEachBySensor. Only the good unnamed channel is moved out of
+// the table, so a segment is skipped when the query doesn't read the good or the unnamed channel
+func (c *ChannelRepository) forEachWithSegments(ctx context.Context, tx helper.Querier, sensorId int, query entities.ChannelQuery, segments []channelSegment, fn func(channel entities.Channel) error) error {
+	if _, ok := channelAggregateFunction[query.Aggregate]; query.Interval > 0 && !ok {
+		return fiber.NewError(400, fmt.Sprintf("Aggregate %s is not supported, use avg, min, max, or last", query.Aggregate))
+	}
+
+	emit := fn
+	var aggregator *channelAggregator
+	if query.Interval > 0 {
+		aggregator = &channelAggregator{sensorId: sensorId, interval: query.Interval, aggregate: query.Aggregate, fn: fn}
+		emit = aggregator.add
+	}
+
+	if qualities := query.Qualities(); qualities != nil {
+		readGood := false
+		for _, quality := range qualities {
+			readGood = readGood || quality == entities.QualityGood
+		}
+		if !readGood {
+			segments = nil
+		}
+	}
+	if name := query.ChannelName(); (name != nil && *name != "") || len(query.Fields) > 0 {
+		segments = nil
+	}
+
+	where, args := channelCondition(sensorId, query)
+	rows, err := tx.Query(ctx, fmt.Sprintf(`SELECT channel.time, %s, channel.name, channel.quality, channel.filtered_value FROM "channel" WHERE %s ORDER BY channel.time`, channelValue(query), where), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// Next row of the database, nil when there is no more row
+	var warm *entities.Channel
+	nextWarm := func() error {
+		warm = nil
+		if !rows.Next() {
+			return rows.Err()
+		}
+		channel := entities.Channel{ChannelCreate: entities.ChannelCreate{IdSensor: sensorId}}
+		err := rows.Scan(&channel.Time, &channel.Value, &channel.Name, &channel.Quality, &channel.FilteredValue)
+		if err != nil {
+			return err
+		}
+		warm = &channel
+		return nil
+	}
+	err = nextWarm()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(segments); {
+		// The same day can be both compressed and archived until the next archive run merge them
+		points := []archive.Point{}
+		day := segments[i].day
+		for ; i < len(segments) && segments[i].day.Equal(day); i++ {
+			segmentPoints, err := segments[i].load(ctx)
+			if err != nil {
+				return err
+			}
+			if len(points) > 0 {
+				segmentPoints = append(points, segmentPoints...)
+				sort.SliceStable(segmentPoints, func(i, j int) bool {
+					return segmentPoints[i].Time.Before(segmentPoints[j].Time)
+				})
+			}
+			points = segmentPoints
+		}
+
+		for _, point := range points {
+			if (query.From != nil && point.Time.Before(*query.From)) || (query.To != nil && !point.Time.Before(*query.To)) {
+				continue
+			}
+			for warm != nil && warm.Time.Before(point.Time) {
+				err = emit(*warm)
+				if err != nil {
+					return err
+				}
+				err = nextWarm()
+				if err != nil {
+					return err
+				}
+			}
+
+			err = emit(entities.Channel{Time: point.Time, ChannelCreate: entities.ChannelCreate{Value: point.Value, IdSensor: sensorId, Quality: entities.QualityGood}})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for warm != nil {
+		err = emit(*warm)
+		if err != nil {
+			return err
+		}
+		err = nextWarm()
+		if err != nil {
+			return err
+		}
+	}
+
+	if aggregator != nil {
+		return aggregator.flush()
+	}
+	return nil
+}
+
+// channelAggregator downsample channel ordered by time into interval bucket
+type channelAggregator struct {
+	sensorId  int
+	interval  time.Duration
+	aggregate string
+	fn        func(channel entities.Channel) error
+
+	bucket *time.Time
+	count  int
+	value  float64
+}
+
+func (a *channelAggregator) add(channel entities.Channel) error {
+	// Same as floor(extract(epoch FROM time) / interval) * interval
+	seconds := a.interval.Seconds()
+	epoch := math.Floor(float64(channel.Time.UnixMicro())/1e6/seconds) * seconds
+	bucket := time.UnixMicro(int64(math.Round(epoch * 1e6))).UTC()
+
+	if a.bucket != nil && !a.bucket.Equal(bucket) {
+		err := a.flush()
+		if err != nil {
+			return err
+		}
+	}
+	if a.bucket == nil {
+		a.bucket = &bucket
+		a.count = 0
+		a.value = channel.Value
+	}
+
+	a.count++
+	switch a.aggregate {
+	case "min":
+		a.value = math.Min(a.value, channel.Value)
+	case "max":
+		a.value = math.Max(a.value, channel.Value)
+	case "last":
+		a.value = channel.Value
+	default:
+		if a.count > 1 {
+			a.value += channel.Value
+		}
+	}
+	return nil
+}
+
+func (a *channelAggregator) flush() error {
+	if a.bucket == nil {
+		return nil
+	}
+
+	value := a.value
+	if a.aggregate == "" || a.aggregate == "avg" {
+		value /= float64(a.count)
+	}
+	channel := entities.Channel{Time: *a.bucket, ChannelCreate: entities.ChannelCreate{Value: value, IdSensor: a.sensorId}}
+	a.bucket = nil
+	return a.fn(channel)
+}
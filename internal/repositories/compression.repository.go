@@ -0,0 +1,226 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/archive"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/jackc/pgx/v5"
+)
+
+// CompressionRepository replace the channel of an old sensor day by one channel_compressed row
+// holding the delta encoded day, see archive.EncodeDelta
+type CompressionRepository struct {
+	defaultAfterDays int
+}
+
+func NewCompressionRepository(config *configs.Config) (CompressionRepository, error) {
+	return CompressionRepository{
+		defaultAfterDays: config.Compression.AfterDays,
+	}, nil
+}
+
+const compressedColumns = "id_sensor, day, row_count, first_time, last_time, data, compressed_at"
+
+func scanCompressed(row pgx.Row, segment *entities.ChannelCompressed) error {
+	return row.Scan(&segment.IdSensor, &segment.Day, &segment.RowCount, &segment.FirstTime, &segment.LastTime, &segment.Data, &segment.CompressedAt)
+}
+
+// GetSetting return the compression setting of the sensor and its compressed size
+func (r *CompressionRepository) GetSetting(ctx context.Context, tx helper.Querier, sensorId int) (setting entities.SensorCompression, err error) {
+	setting.IdSensor = sensorId
+	setting.DefaultAfterDays = r.defaultAfterDays
+	sqlStatement := `
+	SELECT
+		(SELECT after_days FROM sensor_compression WHERE id_sensor=$1),
+		COUNT(*), COALESCE(SUM(row_count), 0), COALESCE(SUM(octet_length(data)), 0)
+	FROM channel_compressed WHERE id_sensor=$1`
+	err = tx.QueryRow(ctx, sqlStatement, sensorId).Scan(&setting.AfterDays, &setting.Days, &setting.Rows, &setting.Bytes)
+	setting.RowBytes = setting.Rows * archive.ChannelRowBytes
+	return setting, err
+}
+
+// UpdateSetting set the days after which the sensor channel is compressed, nil use the server default
+func (r *CompressionRepository) UpdateSetting(ctx context.Context, tx helper.Querier, sensorId int, afterDays *int) error {
+	if afterDays == nil {
+		_, err := tx.Exec(ctx, `DELETE FROM sensor_compression WHERE id_sensor=$1`, sensorId)
+		return err
+	}
+
+	sqlStatement := `
+	INSERT INTO sensor_compression (id_sensor, after_days) VALUES ($1, $2)
+	ON CONFLICT (id_sensor) DO UPDATE SET after_days=EXCLUDED.after_days`
+	_, err := tx.Exec(ctx, sqlStatement, sensorId, *afterDays)
+	return err
+}
+
+// GetSegments return the compressed day of the sensor that overlap [from, to) with their data, ordered by day
+func (r *CompressionRepository) GetSegments(ctx context.Context, tx helper.Querier, sensorId int, from *time.Time, to *time.Time) (segments []entities.ChannelCompressed, err error) {
+	segments = []entities.ChannelCompressed{}
+	sqlStatement := fmt.Sprintf(`
+	SELECT %s FROM channel_compressed
+	WHERE id_sensor=$1 AND ($2::TIMESTAMP IS NULL OR last_time >= $2) AND ($3::TIMESTAMP IS NULL OR first_time < $3)
+	ORDER BY day`, compressedColumns)
+	rows, err := tx.Query(ctx, sqlStatement, sensorId, from, to)
+	if err != nil {
+		return segments, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var segment entities.ChannelCompressed
+		err := scanCompressed(rows, &segment)
+		if err != nil {
+			return segments, err
+		}
+		segments = append(segments, segment)
+	}
+	return segments, rows.Err()
+}
+
+// GetTimeRange return the time of the first and last compressed channel, nil if nothing is compressed
+func (r *CompressionRepository) GetTimeRange(ctx context.Context, tx helper.Querier, sensorId int) (first *time.Time, last *time.Time, err error) {
+	sqlStatement := `SELECT min(first_time), max(last_time) FROM channel_compressed WHERE id_sensor=$1`
+	err = tx.QueryRow(ctx, sqlStatement, sensorId).Scan(&first, &last)
+	return first, last, err
+}
+
+// DeleteBefore remove the compressed day that end before the time, of every sensor when sensorId is 0.
+// The day that is only partly before is kept
+func (r *CompressionRepository) DeleteBefore(ctx context.Context, tx helper.Querier, sensorId int, before time.Time) (count int64, err error) {
+	sqlStatement := `
+	WITH deleted AS (
+		DELETE FROM channel_compressed WHERE last_time<$1 AND ($2=0 OR id_sensor=$2) RETURNING row_count
+	)
+	SELECT COALESCE(SUM(row_count), 0) FROM deleted`
+	err = tx.QueryRow(ctx, sqlStatement, before, sensorId).Scan(&count)
+	return count, err
+}
+
+// CompressBefore compress every sensor day older than the sensor setting, counted in whole days
+// before today. Each sensor day is compressed in its own transaction. The compressed row has no
+// quality or name, a flagged or named channel stay in the channel table, and keep only the received
+// value without the filtered value
+func (r *CompressionRepository) CompressBefore(ctx context.Context, db helper.Querier, today time.Time) (days int, count int64, bytes int64, err error) {
+	var enabled bool
+	err = db.QueryRow(ctx, `SELECT $1 > 0 OR EXISTS (SELECT 1 FROM sensor_compression WHERE after_days > 0)`, r.defaultAfterDays).Scan(&enabled)
+	if err != nil || !enabled {
+		return 0, 0, 0, err
+	}
+
+	sqlStatement := `
+	SELECT DISTINCT c.id_sensor, date_trunc('day', c.time) AS day
+	FROM channel c
+	LEFT JOIN sensor_compression sc ON sc.id_sensor = c.id_sensor
+	WHERE COALESCE(sc.after_days, $2) > 0 AND c.time < $1::TIMESTAMP - make_interval(days => COALESCE(sc.after_days, $2)) AND c.quality = 'good' AND c.name = ''
+	ORDER BY day, c.id_sensor
+	LIMIT 100`
+	for {
+		type sensorDay struct {
+			idSensor int
+			day      time.Time
+		}
+		pending := []sensorDay{}
+		rows, err := db.Query(ctx, sqlStatement, today, r.defaultAfterDays)
+		if err != nil {
+			return days, count, bytes, err
+		}
+		for rows.Next() {
+			var item sensorDay
+			err := rows.Scan(&item.idSensor, &item.day)
+			if err != nil {
+				rows.Close()
+				return days, count, bytes, err
+			}
+			pending = append(pending, item)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return days, count, bytes, err
+		}
+		if len(pending) == 0 {
+			return days, count, bytes, nil
+		}
+
+		for _, item := range pending {
+			compressed, size, err := r.compressDay(ctx, db, item.idSensor, item.day)
+			if err != nil {
+				return days, count, bytes, fmt.Errorf("compressing sensor %d on %s: %w", item.idSensor, item.day.Format("2006-01-02"), err)
+			}
+			days++
+			count += compressed
+			bytes += size
+		}
+	}
+}
+
+// compressDay move the channel of the sensor in the day into its compressed row, together with
+// the channel already compressed for that day
+func (r *CompressionRepository) compressDay(ctx context.Context, db helper.Querier, sensorId int, day time.Time) (count int64, size int64, err error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	points := []archive.Point{}
+	segment := entities.ChannelCompressed{}
+	err = scanCompressed(tx.QueryRow(ctx, fmt.Sprintf(`SELECT %s FROM channel_compressed WHERE id_sensor=$1 AND day=$2 FOR UPDATE`, compressedColumns), sensorId, day), &segment)
+	if err == nil {
+		points, err = archive.DecodeDelta(segment.Data)
+		if err != nil {
+			return 0, 0, err
+		}
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return 0, 0, err
+	}
+
+	rows, err := tx.Query(ctx, `DELETE FROM channel WHERE id_sensor=$1 AND time>=$2 AND time<$3 AND quality='good' AND name='' RETURNING time, value`, sensorId, day, day.AddDate(0, 0, 1))
+	if err != nil {
+		return 0, 0, err
+	}
+	for rows.Next() {
+		var point archive.Point
+		err := rows.Scan(&point.Time, &point.Value)
+		if err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		points = append(points, point)
+		count++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+	if count == 0 {
+		return 0, 0, nil
+	}
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].Time.Before(points[j].Time)
+	})
+
+	data, err := archive.EncodeDelta(points)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	sqlStatement := `
+	INSERT INTO channel_compressed (id_sensor, day, row_count, first_time, last_time, data, compressed_at)
+	VALUES ($1, $2, $3, $4, $5, $6, NOW())
+	ON CONFLICT (id_sensor, day) DO UPDATE SET
+		row_count=EXCLUDED.row_count, first_time=EXCLUDED.first_time, last_time=EXCLUDED.last_time,
+		data=EXCLUDED.data, compressed_at=EXCLUDED.compressed_at`
+	_, err = tx.Exec(ctx, sqlStatement, sensorId, day, len(points), points[0].Time, points[len(points)-1].Time, data)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return count, int64(len(data)), tx.Commit(ctx)
+}
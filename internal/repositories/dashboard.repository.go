@@ -0,0 +1,519 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+type DashboardRepository struct{}
+
+func NewDashboardRepository() (DashboardRepository, error) {
+	return DashboardRepository{}, nil
+}
+
+func (u *DashboardRepository) dashboardFieldWithoutId() string {
+	return "name, columns, id_user"
+}
+
+func (u *DashboardRepository) dashboardField() string {
+	return "id_dashboard, " + u.dashboardFieldWithoutId() + ", public_token, id_node, id_template"
+}
+
+func (u *DashboardRepository) dashboardPointer(dashboard *entities.Dashboard) []interface{} {
+	return []interface{}{&dashboard.IdDashboard, &dashboard.Name, &dashboard.Columns, &dashboard.IdUser, &dashboard.PublicToken, &dashboard.IdNode, &dashboard.IdTemplate}
+}
+
+func (u *DashboardRepository) templateFieldWithoutId() string {
+	return "name, id_user, id_hardware, columns, auto_apply, widgets"
+}
+
+func (u *DashboardRepository) templateField() string {
+	return "id_template, " + u.templateFieldWithoutId()
+}
+
+func (u *DashboardRepository) templatePointer(template *entities.DashboardTemplate) []interface{} {
+	return []interface{}{&template.IdTemplate, &template.Name, &template.IdUser, &template.IdHardware, &template.Columns, &template.AutoApply, &template.Widgets}
+}
+
+func (u *DashboardRepository) widgetFieldWithoutId() string {
+	return "id_dashboard, type, title, id_sensor, x, y, width, height, options"
+}
+
+func (u *DashboardRepository) widgetField() string {
+	return "id_widget, " + u.widgetFieldWithoutId()
+}
+
+func (u *DashboardRepository) widgetPointer(widget *entities.Widget) []interface{} {
+	return []interface{}{&widget.IdWidget, &widget.IdDashboard, &widget.Type, &widget.Title, &widget.IdSensor, &widget.X, &widget.Y, &widget.Width, &widget.Height, &widget.Options}
+}
+
+func (u *DashboardRepository) Create(ctx context.Context, tx helper.Querier, payload *entities.DashboardCreate, currentUser *entities.UserRead) (dashboard entities.Dashboard, err error) {
+	dashboard = entities.Dashboard{
+		DashboardCreate: *payload,
+		IdUser:          currentUser.IdUser,
+	}
+	if dashboard.Columns == 0 {
+		dashboard.Columns = 12
+	}
+
+	sqlStatement := fmt.Sprintf(`
+	INSERT INTO "dashboard" (
+		%s
+	)
+	VALUES ($1, $2, $3) RETURNING id_dashboard`, u.dashboardFieldWithoutId())
+	err = tx.QueryRow(ctx, sqlStatement, dashboard.Name, dashboard.Columns, dashboard.IdUser).Scan(&dashboard.IdDashboard)
+	if err != nil {
+		return dashboard, err
+	}
+
+	return dashboard, nil
+}
+
+func (u *DashboardRepository) GetAll(ctx context.Context, tx helper.Querier, currentUser *entities.UserRead) (dashboards []entities.Dashboard, err error) {
+	dashboards = []entities.Dashboard{}
+	var rows pgx.Rows
+	if currentUser.IsAdmin {
+		sqlStatement := fmt.Sprintf(`SELECT %s FROM "dashboard" ORDER BY name`, u.dashboardField())
+		rows, err = tx.Query(ctx, sqlStatement)
+	} else {
+		sqlStatement := fmt.Sprintf(`SELECT %s FROM "dashboard" WHERE id_user=$1 ORDER BY name`, u.dashboardField())
+		rows, err = tx.Query(ctx, sqlStatement, currentUser.IdUser)
+	}
+	if err != nil {
+		return dashboards, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var dashboard entities.Dashboard
+		err := rows.Scan(
+			u.dashboardPointer(&dashboard)...,
+		)
+		if err != nil {
+			return dashboards, err
+		}
+		dashboards = append(dashboards, dashboard)
+	}
+	if err := rows.Err(); err != nil {
+		return dashboards, err
+	}
+	return dashboards, nil
+}
+
+func (u *DashboardRepository) GetById(ctx context.Context, tx helper.Querier, id int) (dashboard entities.Dashboard, err error) {
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM "dashboard" WHERE id_dashboard=$1`, u.dashboardField())
+	err = tx.QueryRow(ctx, sqlStatement, id).Scan(
+		u.dashboardPointer(&dashboard)...,
+	)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return dashboard, fiber.NewError(404, fmt.Sprintf("Dashboard with id %d not found", id))
+		}
+		return dashboard, err
+	}
+	return dashboard, nil
+}
+
+func (u *DashboardRepository) GetByPublicToken(ctx context.Context, tx helper.Querier, token string) (dashboard entities.Dashboard, err error) {
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM "dashboard" WHERE public_token=$1`, u.dashboardField())
+	err = tx.QueryRow(ctx, sqlStatement, token).Scan(
+		u.dashboardPointer(&dashboard)...,
+	)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return dashboard, fiber.NewError(404, "Dashboard not found or no longer published")
+		}
+		return dashboard, err
+	}
+	return dashboard, nil
+}
+
+// SetPublicToken publish the dashboard with the token, or unpublish it when token is nil
+func (u *DashboardRepository) SetPublicToken(ctx context.Context, tx helper.Querier, id int, token *string) (err error) {
+	sqlStatement := `UPDATE "dashboard" SET public_token=$1 WHERE id_dashboard=$2`
+	res, err := tx.Exec(ctx, sqlStatement, token, id)
+	if err != nil {
+		return err
+	}
+	count := res.RowsAffected()
+	if count == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on update dashboard with id %d", id))
+	}
+	return nil
+}
+
+func (u *DashboardRepository) Update(ctx context.Context, tx helper.Querier, dashboard *entities.Dashboard, payload *entities.DashboardUpdate) (err error) {
+	payload.ChangeSettedFieldOnly(dashboard)
+
+	sqlStatement := `
+	UPDATE "dashboard"
+	SET name=$1, columns=$2
+	WHERE id_dashboard=$3`
+	res, err := tx.Exec(ctx, sqlStatement, payload.Name, payload.Columns, dashboard.IdDashboard)
+	if err != nil {
+		return err
+	}
+	count := res.RowsAffected()
+	if count == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on update dashboard with id %d", dashboard.IdDashboard))
+	}
+	return nil
+}
+
+func (u *DashboardRepository) Delete(ctx context.Context, tx helper.Querier, id int) (err error) {
+	sqlStatement := `DELETE FROM "dashboard" WHERE id_dashboard=$1`
+	res, err := tx.Exec(ctx, sqlStatement, id)
+	if err != nil {
+		return err
+	}
+	count := res.RowsAffected()
+	if count == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on delete with id %d", id))
+	}
+	return nil
+}
+
+func (u *DashboardRepository) CreateWidget(ctx context.Context, tx helper.Querier, dashboardId int, payload *entities.WidgetCreate) (widget entities.Widget, err error) {
+	widget = entities.Widget{
+		IdDashboard:  dashboardId,
+		WidgetCreate: *payload,
+	}
+	sqlStatement := fmt.Sprintf(`
+	INSERT INTO "dashboard_widget" (
+		%s
+	)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id_widget`, u.widgetFieldWithoutId())
+	err = tx.QueryRow(ctx, sqlStatement, widget.IdDashboard, widget.Type, widget.Title, widget.IdSensor, widget.X, widget.Y, widget.Width, widget.Height, widget.Options).Scan(&widget.IdWidget)
+	if err != nil {
+		return widget, err
+	}
+
+	return widget, nil
+}
+
+// GetWidgets return the widget of the dashboard ordered from the top left of the grid
+func (u *DashboardRepository) GetWidgets(ctx context.Context, tx helper.Querier, dashboardId int) (widgets []entities.Widget, err error) {
+	widgets = []entities.Widget{}
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM "dashboard_widget" WHERE id_dashboard=$1 ORDER BY y, x`, u.widgetField())
+	rows, err := tx.Query(ctx, sqlStatement, dashboardId)
+	if err != nil {
+		return widgets, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var widget entities.Widget
+		err := rows.Scan(
+			u.widgetPointer(&widget)...,
+		)
+		if err != nil {
+			return widgets, err
+		}
+		widgets = append(widgets, widget)
+	}
+	if err := rows.Err(); err != nil {
+		return widgets, err
+	}
+	return widgets, nil
+}
+
+// GetAlertWidgetsBySensors return the alert widget of the sensors keyed by sensor id
+func (u *DashboardRepository) GetAlertWidgetsBySensors(ctx context.Context, tx helper.Querier, sensorIds []int) (widgets map[int][]entities.Widget, err error) {
+	widgets = map[int][]entities.Widget{}
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM "dashboard_widget" WHERE type='alert' AND id_sensor = ANY($1) ORDER BY id_widget`, u.widgetField())
+	rows, err := tx.Query(ctx, sqlStatement, sensorIds)
+	if err != nil {
+		return widgets, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var widget entities.Widget
+		err := rows.Scan(
+			u.widgetPointer(&widget)...,
+		)
+		if err != nil {
+			return widgets, err
+		}
+		widgets[*widget.IdSensor] = append(widgets[*widget.IdSensor], widget)
+	}
+	if err := rows.Err(); err != nil {
+		return widgets, err
+	}
+	return widgets, nil
+}
+
+func (u *DashboardRepository) GetWidgetById(ctx context.Context, tx helper.Querier, dashboardId int, id int) (widget entities.Widget, err error) {
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM "dashboard_widget" WHERE id_widget=$1 AND id_dashboard=$2`, u.widgetField())
+	err = tx.QueryRow(ctx, sqlStatement, id, dashboardId).Scan(
+		u.widgetPointer(&widget)...,
+	)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return widget, fiber.NewError(404, fmt.Sprintf("Widget with id %d not found in dashboard %d", id, dashboardId))
+		}
+		return widget, err
+	}
+	return widget, nil
+}
+
+// UpdateWidget replace every field of the widget, so the whole layout can be saved at once
+func (u *DashboardRepository) UpdateWidget(ctx context.Context, tx helper.Querier, widget *entities.Widget, payload *entities.WidgetCreate) (err error) {
+	sqlStatement := `
+	UPDATE "dashboard_widget"
+	SET type=$1, title=$2, id_sensor=$3, x=$4, y=$5, width=$6, height=$7, options=$8
+	WHERE id_widget=$9`
+	res, err := tx.Exec(ctx, sqlStatement, payload.Type, payload.Title, payload.IdSensor, payload.X, payload.Y, payload.Width, payload.Height, payload.Options, widget.IdWidget)
+	if err != nil {
+		return err
+	}
+	count := res.RowsAffected()
+	if count == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on update widget with id %d", widget.IdWidget))
+	}
+	return nil
+}
+
+func (u *DashboardRepository) DeleteWidget(ctx context.Context, tx helper.Querier, dashboardId int, id int) (err error) {
+	sqlStatement := `DELETE FROM "dashboard_widget" WHERE id_widget=$1 AND id_dashboard=$2`
+	res, err := tx.Exec(ctx, sqlStatement, id, dashboardId)
+	if err != nil {
+		return err
+	}
+	count := res.RowsAffected()
+	if count == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on delete widget with id %d", id))
+	}
+	return nil
+}
+
+// CreateTemplate save the template for the user, or as shared template when idUser is nil
+func (u *DashboardRepository) CreateTemplate(ctx context.Context, tx helper.Querier, payload *entities.DashboardTemplateCreate, idUser *int) (template entities.DashboardTemplate, err error) {
+	template = entities.DashboardTemplate{
+		IdUser:                  idUser,
+		DashboardTemplateCreate: *payload,
+	}
+	if template.Columns == 0 {
+		template.Columns = 12
+	}
+	if template.Widgets == nil {
+		template.Widgets = []entities.TemplateWidget{}
+	}
+
+	sqlStatement := fmt.Sprintf(`
+	INSERT INTO "dashboard_template" (
+		%s
+	)
+	VALUES ($1, $2, $3, $4, $5, $6) RETURNING id_template`, u.templateFieldWithoutId())
+	err = tx.QueryRow(ctx, sqlStatement, template.Name, template.IdUser, template.IdHardware, template.Columns, template.AutoApply, template.Widgets).Scan(&template.IdTemplate)
+	if err != nil {
+		return template, err
+	}
+
+	return template, nil
+}
+
+// GetAllTemplate return the shared template and the template of the user, admin get every template
+func (u *DashboardRepository) GetAllTemplate(ctx context.Context, tx helper.Querier, currentUser *entities.UserRead) (templates []entities.DashboardTemplate, err error) {
+	templates = []entities.DashboardTemplate{}
+	var rows pgx.Rows
+	if currentUser.IsAdmin {
+		sqlStatement := fmt.Sprintf(`SELECT %s FROM "dashboard_template" ORDER BY name`, u.templateField())
+		rows, err = tx.Query(ctx, sqlStatement)
+	} else {
+		sqlStatement := fmt.Sprintf(`SELECT %s FROM "dashboard_template" WHERE id_user=$1 OR id_user IS NULL ORDER BY name`, u.templateField())
+		rows, err = tx.Query(ctx, sqlStatement, currentUser.IdUser)
+	}
+	if err != nil {
+		return templates, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var template entities.DashboardTemplate
+		err := rows.Scan(
+			u.templatePointer(&template)...,
+		)
+		if err != nil {
+			return templates, err
+		}
+		templates = append(templates, template)
+	}
+	if err := rows.Err(); err != nil {
+		return templates, err
+	}
+	return templates, nil
+}
+
+func (u *DashboardRepository) GetTemplateById(ctx context.Context, tx helper.Querier, id int) (template entities.DashboardTemplate, err error) {
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM "dashboard_template" WHERE id_template=$1`, u.templateField())
+	err = tx.QueryRow(ctx, sqlStatement, id).Scan(
+		u.templatePointer(&template)...,
+	)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return template, fiber.NewError(404, fmt.Sprintf("Dashboard template with id %d not found", id))
+		}
+		return template, err
+	}
+	return template, nil
+}
+
+func (u *DashboardRepository) DeleteTemplate(ctx context.Context, tx helper.Querier, id int) (err error) {
+	sqlStatement := `DELETE FROM "dashboard_template" WHERE id_template=$1`
+	res, err := tx.Exec(ctx, sqlStatement, id)
+	if err != nil {
+		return err
+	}
+	count := res.RowsAffected()
+	if count == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on delete with id %d", id))
+	}
+	return nil
+}
+
+// InstantiateTemplateForNode create the node dashboard from the most specific auto apply template:
+// template for the node hardware before template for any hardware, and the user template before
+// the shared one. Return nil when there is no template to apply.
+func (u *DashboardRepository) InstantiateTemplateForNode(ctx context.Context, tx helper.Querier, node *entities.Node) (*entities.Dashboard, error) {
+	var template entities.DashboardTemplate
+	sqlStatement := fmt.Sprintf(`
+	SELECT %s FROM "dashboard_template"
+	WHERE auto_apply AND (id_hardware=$1 OR id_hardware IS NULL) AND (id_user=$2 OR id_user IS NULL)
+	ORDER BY id_hardware IS NULL, id_user IS NULL, id_template DESC
+	LIMIT 1`, u.templateField())
+	err := tx.QueryRow(ctx, sqlStatement, node.IdHardware, node.IdUser).Scan(
+		u.templatePointer(&template)...,
+	)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	dashboard := entities.Dashboard{
+		DashboardCreate: entities.DashboardCreate{
+			Name:    node.Name,
+			Columns: template.Columns,
+		},
+		IdUser:     node.IdUser,
+		IdNode:     &node.IdNode,
+		IdTemplate: &template.IdTemplate,
+	}
+	sqlStatement = `
+	INSERT INTO "dashboard" (
+		name, columns, id_user, id_node, id_template
+	)
+	VALUES ($1, $2, $3, $4, $5) RETURNING id_dashboard`
+	err = tx.QueryRow(ctx, sqlStatement, dashboard.Name, dashboard.Columns, dashboard.IdUser, dashboard.IdNode, dashboard.IdTemplate).Scan(&dashboard.IdDashboard)
+	if err != nil {
+		return nil, err
+	}
+
+	// Widget without sensor can be created now, the rest wait for the sensor
+	for _, templateWidget := range template.Widgets {
+		if templateWidget.SensorName != "" {
+			continue
+		}
+		_, err = u.CreateWidget(ctx, tx, dashboard.IdDashboard, &entities.WidgetCreate{
+			Type:    templateWidget.Type,
+			Title:   templateWidget.Title,
+			X:       templateWidget.X,
+			Y:       templateWidget.Y,
+			Width:   templateWidget.Width,
+			Height:  templateWidget.Height,
+			Options: templateWidget.Options,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &dashboard, nil
+}
+
+// BindTemplateWidgetsForSensor add the template widget matching the new sensor to every dashboard
+// instantiated from a template for the sensor node
+func (u *DashboardRepository) BindTemplateWidgetsForSensor(ctx context.Context, tx helper.Querier, sensor *entities.Sensor) error {
+	type nodeDashboard struct {
+		idDashboard int
+		widgets     []entities.TemplateWidget
+	}
+	dashboards := []nodeDashboard{}
+
+	sqlStatement := `
+	SELECT dashboard.id_dashboard, dashboard_template.widgets FROM "dashboard"
+	INNER JOIN "dashboard_template" ON dashboard_template.id_template=dashboard.id_template
+	WHERE dashboard.id_node=$1`
+	rows, err := tx.Query(ctx, sqlStatement, sensor.IdNode)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var dashboard nodeDashboard
+		err := rows.Scan(&dashboard.idDashboard, &dashboard.widgets)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		dashboards = append(dashboards, dashboard)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, dashboard := range dashboards {
+		// Repeated widget is placed below every existing widget, keeping its position relative to
+		// the other repeated widget of the template
+		var bottom int
+		err = tx.QueryRow(ctx, `SELECT COALESCE(max(y + height), 0) FROM "dashboard_widget" WHERE id_dashboard=$1`, dashboard.idDashboard).Scan(&bottom)
+		if err != nil {
+			return err
+		}
+		repeatedTop := -1
+		for _, templateWidget := range dashboard.widgets {
+			if templateWidget.SensorName == "*" && (repeatedTop == -1 || templateWidget.Y < repeatedTop) {
+				repeatedTop = templateWidget.Y
+			}
+		}
+
+		for _, templateWidget := range dashboard.widgets {
+			y := templateWidget.Y
+			switch {
+			case templateWidget.SensorName == "*":
+				y = bottom + templateWidget.Y - repeatedTop
+			case strings.EqualFold(templateWidget.SensorName, sensor.Name):
+			default:
+				continue
+			}
+
+			title := templateWidget.Title
+			if templateWidget.SensorName == "*" && title != "" {
+				title = fmt.Sprintf("%s %s", sensor.Name, title)
+			}
+			_, err = u.CreateWidget(ctx, tx, dashboard.idDashboard, &entities.WidgetCreate{
+				Type:     templateWidget.Type,
+				Title:    title,
+				IdSensor: &sensor.IdSensor,
+				X:        templateWidget.X,
+				Y:        y,
+				Width:    templateWidget.Width,
+				Height:   templateWidget.Height,
+				Options:  templateWidget.Options,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
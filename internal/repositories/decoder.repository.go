@@ -0,0 +1,171 @@
+package repositories
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/dafaath/iot-server/plugin"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+// DecoderRepository keep the binary payload decoder of the hardware, shared by every node built on it
+type DecoderRepository struct{}
+
+func NewDecoderRepository() (DecoderRepository, error) {
+	return DecoderRepository{}, nil
+}
+
+// GetByHardware return the decoder of the hardware, without field and plugin when it has none
+func (r *DecoderRepository) GetByHardware(ctx context.Context, tx helper.Querier, hardwareId int) (decoder entities.HardwareDecoder, err error) {
+	decoder = entities.HardwareDecoder{IdHardware: hardwareId, Fields: []entities.DecoderField{}}
+	err = tx.QueryRow(ctx, `SELECT fields, plugin FROM hardware_decoder WHERE id_hardware=$1`, hardwareId).Scan(&decoder.Fields, &decoder.Plugin)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return decoder, nil
+	}
+	return decoder, err
+}
+
+// IsEmpty is true when the hardware has no decoder
+func (r *DecoderRepository) IsEmpty(decoder entities.HardwareDecoder) bool {
+	return len(decoder.Fields) == 0 && decoder.Plugin == ""
+}
+
+// Update replace the decoder of the hardware, no field and plugin remove it. The plugin must be
+// registered and exclude the field, the error is a 400
+func (r *DecoderRepository) Update(ctx context.Context, tx helper.Querier, hardwareId int, payload *entities.HardwareDecoderUpdate) error {
+	if payload.Plugin != "" {
+		if len(payload.Fields) > 0 {
+			return fiber.NewError(400, "A decoder has either fields or a plugin")
+		}
+		if _, ok := plugin.LookupDecoder(payload.Plugin); !ok {
+			return fiber.NewError(400, fmt.Sprintf("Decoder plugin %s is not registered, registered: %s", payload.Plugin, strings.Join(plugin.Decoders(), ", ")))
+		}
+	}
+	if len(payload.Fields) == 0 && payload.Plugin == "" {
+		_, err := tx.Exec(ctx, `DELETE FROM hardware_decoder WHERE id_hardware=$1`, hardwareId)
+		return err
+	}
+
+	fields := payload.Fields
+	if fields == nil {
+		fields = []entities.DecoderField{}
+	}
+	sqlStatement := `
+	INSERT INTO hardware_decoder (id_hardware, fields, plugin) VALUES ($1, $2, $3)
+	ON CONFLICT (id_hardware) DO UPDATE SET fields=EXCLUDED.fields, plugin=EXCLUDED.plugin`
+	_, err := tx.Exec(ctx, sqlStatement, hardwareId, fields, payload.Plugin)
+	return err
+}
+
+// Decode read every field of the decoder from the payload, a field past the end of the payload is a
+// 400. The decoder plugin error is also a 400
+func (r *DecoderRepository) Decode(decoder entities.HardwareDecoder, data []byte) ([]entities.DecodedValue, error) {
+	if decoder.Plugin != "" {
+		pluginValues, err := plugin.Decode(decoder.Plugin, data)
+		if err != nil {
+			return nil, fiber.NewError(400, fmt.Sprintf("The payload can't be decoded by %s, %v", decoder.Plugin, err))
+		}
+		values := make([]entities.DecodedValue, len(pluginValues))
+		for i, value := range pluginValues {
+			values[i] = entities.DecodedValue{Sensor: value.Sensor, Name: value.Name, Value: value.Value}
+		}
+		return values, nil
+	}
+
+	values := make([]entities.DecodedValue, 0, len(decoder.Fields))
+	for _, field := range decoder.Fields {
+		var order binary.ByteOrder = binary.BigEndian
+		if field.Endian == "little" {
+			order = binary.LittleEndian
+		}
+
+		size := decoderFieldSize(field)
+		if size == 0 {
+			return nil, fmt.Errorf("unknown decoder field type %q", field.Type)
+		}
+		if field.Offset+size > len(data) {
+			return nil, fiber.NewError(400, fmt.Sprintf("The payload has %d byte, field %s need %d byte at offset %d", len(data), field.Sensor, size, field.Offset))
+		}
+		raw := data[field.Offset : field.Offset+size]
+
+		var value float64
+		switch field.Type {
+		case "int8":
+			value = float64(int8(raw[0]))
+		case "uint8":
+			value = float64(raw[0])
+		case "int16":
+			value = float64(int16(order.Uint16(raw)))
+		case "uint16":
+			value = float64(order.Uint16(raw))
+		case "int24", "uint24":
+			number := uint32(raw[0])<<16 | uint32(raw[1])<<8 | uint32(raw[2])
+			if field.Endian == "little" {
+				number = uint32(raw[2])<<16 | uint32(raw[1])<<8 | uint32(raw[0])
+			}
+			value = float64(number)
+			if field.Type == "int24" && number&0x800000 != 0 {
+				value = float64(int32(number | 0xff000000))
+			}
+		case "int32":
+			value = float64(int32(order.Uint32(raw)))
+		case "uint32":
+			value = float64(order.Uint32(raw))
+		case "int64":
+			value = float64(int64(order.Uint64(raw)))
+		case "uint64":
+			value = float64(order.Uint64(raw))
+		case "float32":
+			value = float64(math.Float32frombits(order.Uint32(raw)))
+		case "float64":
+			value = math.Float64frombits(order.Uint64(raw))
+		case "bits":
+			var number uint64
+			for _, b := range raw {
+				number = number<<8 | uint64(b)
+			}
+			number >>= uint(size*8 - field.Bit - field.Bits)
+			value = float64(number & (1<<uint(field.Bits) - 1))
+		}
+
+		scale := 1.0
+		if field.Scale != nil {
+			scale = *field.Scale
+		}
+		values = append(values, entities.DecodedValue{
+			Sensor: field.Sensor,
+			Name:   field.Name,
+			Value:  value*scale + field.Add,
+		})
+	}
+	return values, nil
+}
+
+// decoderFieldSize return the number of byte the field read from its offset
+func decoderFieldSize(field entities.DecoderField) int {
+	switch field.Type {
+	case "int8", "uint8":
+		return 1
+	case "int16", "uint16":
+		return 2
+	case "int24", "uint24":
+		return 3
+	case "int32", "uint32", "float32":
+		return 4
+	case "int64", "uint64", "float64":
+		return 8
+	case "bits":
+		if field.Bits == 0 {
+			return 0
+		}
+		return (field.Bit + field.Bits + 7) / 8
+	}
+	return 0
+}
@@ -0,0 +1,101 @@
+package repositories
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+)
+
+//go:embed templates/digest.html
+var digestTemplateText string
+
+// templateFuncs format the time and value of the emailed template
+var templateFuncs = template.FuncMap{
+	"time":  formatTime,
+	"value": formatValue,
+	"number": func(value float64, unit string) string {
+		return formatValue(&value, unit)
+	},
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04")
+}
+
+// formatValue show a missing value as a dash
+func formatValue(value *float64, unit string) string {
+	if value == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f %s", *value, unit)
+}
+
+var digestTemplate = template.Must(template.New("digest").Funcs(templateFuncs).Parse(digestTemplateText))
+
+// DigestRepository summarize the sensor of the user for the daily email digest
+type DigestRepository struct {
+	channelRepository   *ChannelRepository
+	sensorRepository    *SensorRepository
+	dashboardRepository *DashboardRepository
+	userRepository      *UserRepository
+}
+
+func NewDigestRepository(channelRepository *ChannelRepository, sensorRepository *SensorRepository, dashboardRepository *DashboardRepository, userRepository *UserRepository) (DigestRepository, error) {
+	return DigestRepository{
+		channelRepository:   channelRepository,
+		sensorRepository:    sensorRepository,
+		dashboardRepository: dashboardRepository,
+		userRepository:      userRepository,
+	}, nil
+}
+
+// GetUserDigest summarize every sensor of the user in [from, to), an alert is a channel outside an
+// alert widget range
+func (r *DigestRepository) GetUserDigest(ctx context.Context, tx helper.Querier, user entities.UserRead, from time.Time, to time.Time) (digest entities.UserDigest, err error) {
+	digest = entities.UserDigest{User: user, From: from, To: to, Sensors: []entities.SensorSummary{}}
+	sensors, err := r.sensorRepository.GetAll(ctx, tx, &entities.UserRead{IdUser: user.IdUser}, &entities.SensorQuery{})
+	if err != nil {
+		return digest, err
+	}
+	sensorIds := make([]int, len(sensors))
+	for i, sensor := range sensors {
+		sensorIds[i] = sensor.IdSensor
+	}
+	alertWidgets, err := r.dashboardRepository.GetAlertWidgetsBySensors(ctx, tx, sensorIds)
+	if err != nil {
+		return digest, err
+	}
+
+	for _, sensor := range sensors {
+		summary, err := r.channelRepository.Summarize(ctx, tx, sensor, from, to)
+		if err != nil {
+			return digest, err
+		}
+		for _, widget := range alertWidgets[sensor.IdSensor] {
+			alerts, err := r.channelRepository.CountOutOfRangeBySensor(ctx, tx, sensor.IdSensor, widget.Options.Min, widget.Options.Max, from, to)
+			if err != nil {
+				return digest, err
+			}
+			summary.Alerts += alerts
+		}
+		digest.Sensors = append(digest.Sensors, summary)
+	}
+	return digest, nil
+}
+
+// Send render the digest with the email template and send it to the user
+func (r *DigestRepository) Send(ctx context.Context, digest entities.UserDigest) (err error) {
+	var body bytes.Buffer
+	err = digestTemplate.Execute(&body, digest)
+	if err != nil {
+		return err
+	}
+	subject := fmt.Sprintf("Daily Sensor Digest %s", digest.From.UTC().Format("2006-01-02"))
+	return r.userRepository.SendEmail(ctx, digest.User.Email, subject, body.String())
+}
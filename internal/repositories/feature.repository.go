@@ -0,0 +1,133 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+)
+
+type FeatureRepository struct {
+	defaults map[string]bool
+}
+
+func NewFeatureRepository(config *configs.Config) (FeatureRepository, error) {
+	defaults := map[string]bool{}
+	for name, enabled := range config.Features {
+		defaults[strings.ToLower(name)] = enabled
+	}
+
+	return FeatureRepository{
+		defaults: defaults,
+	}, nil
+}
+
+// IsEnabled resolve the flag in order: user override, deployment override, config default
+func (f *FeatureRepository) IsEnabled(ctx context.Context, tx helper.Querier, name string, currentUser *entities.UserRead) (bool, error) {
+	name = strings.ToLower(name)
+	sqlStatement := `
+	SELECT COALESCE(
+		(SELECT enabled FROM user_feature_flag WHERE name=$1 AND id_user=$2),
+		(SELECT enabled FROM feature_flag WHERE name=$1)
+	)`
+	var enabled *bool
+	err := tx.QueryRow(ctx, sqlStatement, name, currentUser.IdUser).Scan(&enabled)
+	if err != nil {
+		return false, err
+	}
+
+	if enabled != nil {
+		return *enabled, nil
+	}
+	return f.defaults[name], nil
+}
+
+func (f *FeatureRepository) GetAll(ctx context.Context, tx helper.Querier, currentUser *entities.UserRead) (flags []entities.FeatureFlag, err error) {
+	flags = []entities.FeatureFlag{}
+	effective := map[string]bool{}
+	for name, enabled := range f.defaults {
+		effective[name] = enabled
+	}
+
+	sqlStatement := `SELECT name, enabled FROM feature_flag`
+	rows, err := tx.Query(ctx, sqlStatement)
+	if err != nil {
+		return flags, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var flag entities.FeatureFlag
+		err := rows.Scan(&flag.Name, &flag.Enabled)
+		if err != nil {
+			return flags, err
+		}
+		effective[flag.Name] = flag.Enabled
+	}
+	if err := rows.Err(); err != nil {
+		return flags, err
+	}
+
+	sqlStatement = `SELECT name, enabled FROM user_feature_flag WHERE id_user=$1`
+	userRows, err := tx.Query(ctx, sqlStatement, currentUser.IdUser)
+	if err != nil {
+		return flags, err
+	}
+	defer userRows.Close()
+
+	for userRows.Next() {
+		var flag entities.FeatureFlag
+		err := userRows.Scan(&flag.Name, &flag.Enabled)
+		if err != nil {
+			return flags, err
+		}
+		effective[flag.Name] = flag.Enabled
+	}
+	if err := userRows.Err(); err != nil {
+		return flags, err
+	}
+
+	for name, enabled := range effective {
+		flags = append(flags, entities.FeatureFlag{Name: name, Enabled: enabled})
+	}
+	sort.Slice(flags, func(i, j int) bool {
+		return flags[i].Name < flags[j].Name
+	})
+	return flags, nil
+}
+
+func (f *FeatureRepository) Set(ctx context.Context, tx helper.Querier, name string, enabled bool) (err error) {
+	sqlStatement := `
+	INSERT INTO feature_flag (name, enabled)
+	VALUES ($1, $2)
+	ON CONFLICT (name) DO UPDATE SET enabled=EXCLUDED.enabled`
+	_, err = tx.Exec(ctx, sqlStatement, strings.ToLower(name), enabled)
+	return err
+}
+
+func (f *FeatureRepository) SetForUser(ctx context.Context, tx helper.Querier, name string, idUser int, enabled bool) (err error) {
+	sqlStatement := `
+	INSERT INTO user_feature_flag (id_user, name, enabled)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (id_user, name) DO UPDATE SET enabled=EXCLUDED.enabled`
+	_, err = tx.Exec(ctx, sqlStatement, idUser, strings.ToLower(name), enabled)
+	return err
+}
+
+func (f *FeatureRepository) DeleteForUser(ctx context.Context, tx helper.Querier, name string, idUser int) (err error) {
+	sqlStatement := `DELETE FROM user_feature_flag WHERE name=$1 AND id_user=$2`
+	res, err := tx.Exec(ctx, sqlStatement, strings.ToLower(name), idUser)
+	if err != nil {
+		return err
+	}
+	count := res.RowsAffected()
+	if count == 0 {
+		return fiber.NewError(404, fmt.Sprintf("Feature %s is not overridden for user with id %d", name, idUser))
+	}
+	return nil
+}
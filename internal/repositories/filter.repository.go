@@ -0,0 +1,127 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/jackc/pgx/v5"
+)
+
+// Default of the sensor filter when the window or threshold is not set
+const (
+	defaultFilterWindow    = 5
+	defaultFilterThreshold = 3
+)
+
+// Scale the median absolute deviation to the standard deviation of a normal distribution
+const madScale = 1.4826
+
+// FilterRepository keep the ingest filter of the noisy sensor, the filter run on the received
+// value and the last channel of the sensor
+type FilterRepository struct{}
+
+func NewFilterRepository() (FilterRepository, error) {
+	return FilterRepository{}, nil
+}
+
+// GetBySensor return the filter of the sensor, off when it has none
+func (r *FilterRepository) GetBySensor(ctx context.Context, tx helper.Querier, sensorId int) (filter entities.SensorFilter, err error) {
+	filter = entities.SensorFilter{IdSensor: sensorId, Method: entities.FilterOff}
+	sqlStatement := `SELECT method, window_size, threshold FROM sensor_filter WHERE id_sensor=$1`
+	err = tx.QueryRow(ctx, sqlStatement, sensorId).Scan(&filter.Method, &filter.Window, &filter.Threshold)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return filter, nil
+	}
+	return filter, err
+}
+
+// Update set the filter of the sensor, off remove it
+func (r *FilterRepository) Update(ctx context.Context, tx helper.Querier, sensorId int, payload *entities.SensorFilterUpdate) error {
+	if payload.Method == entities.FilterOff {
+		_, err := tx.Exec(ctx, `DELETE FROM sensor_filter WHERE id_sensor=$1`, sensorId)
+		return err
+	}
+
+	window := payload.Window
+	if window == 0 {
+		window = defaultFilterWindow
+	}
+	threshold := payload.Threshold
+	if threshold == 0 {
+		threshold = defaultFilterThreshold
+	}
+
+	sqlStatement := `
+	INSERT INTO sensor_filter (id_sensor, method, window_size, threshold) VALUES ($1, $2, $3, $4)
+	ON CONFLICT (id_sensor) DO UPDATE SET method=EXCLUDED.method, window_size=EXCLUDED.window_size, threshold=EXCLUDED.threshold`
+	_, err := tx.Exec(ctx, sqlStatement, sensorId, payload.Method, window, threshold)
+	return err
+}
+
+// Apply return the filtered value of the received channel, nil when the sensor has no filter.
+// The window is the received value and the last received value of the sensor channel of the same
+// name, as long as the sensor has fewer channel the window is shorter
+func (r *FilterRepository) Apply(ctx context.Context, tx helper.Querier, payload *entities.ChannelCreate) (filtered *float64, err error) {
+	var filter entities.SensorFilter
+	sqlStatement := `SELECT method, window_size, threshold FROM sensor_filter WHERE id_sensor=$1`
+	err = tx.QueryRow(ctx, sqlStatement, payload.IdSensor).Scan(&filter.Method, &filter.Window, &filter.Threshold)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	values := []float64{payload.Value}
+	sqlStatement = `SELECT channel.value FROM "channel" WHERE channel.id_sensor=$1 AND channel.name=$2 ORDER BY channel.time DESC LIMIT $3`
+	rows, err := tx.Query(ctx, sqlStatement, payload.IdSensor, payload.Name, filter.Window-1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var value float64
+		err := rows.Scan(&value)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	value := filterValue(filter, values)
+	return &value, nil
+}
+
+// filterValue filter the first value of the window, the rest is the previous value
+func filterValue(filter entities.SensorFilter, window []float64) float64 {
+	center := median(window)
+	if filter.Method == entities.FilterMedian {
+		return center
+	}
+
+	deviations := make([]float64, len(window))
+	for i, value := range window {
+		deviations[i] = math.Abs(value - center)
+	}
+	if math.Abs(window[0]-center) > filter.Threshold*madScale*median(deviations) {
+		return center
+	}
+	return window[0]
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
@@ -0,0 +1,279 @@
+package repositories
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// FirmwareRepository keep the firmware of the hardware and the firmware each node should run. The
+// binary is written to the store of firmware.storage, and each firmware remember its store so the
+// setting can be changed later
+type FirmwareRepository struct {
+	fileStore dependencies.ObjectStore
+	s3Store   dependencies.ObjectStore
+	storage   string
+	prefix    string
+	secret    []byte
+	urlTTL    time.Duration
+}
+
+// NewFirmwareRepository use the s3 store when firmware.storage is s3, s3Store is nil when s3 is
+// not configured
+func NewFirmwareRepository(fileStore dependencies.ObjectStore, s3Store dependencies.ObjectStore, config *configs.Config) (FirmwareRepository, error) {
+	storage := config.Firmware.Storage
+	if storage == "" {
+		storage = entities.FirmwareStorageFile
+	}
+	if storage != entities.FirmwareStorageFile && storage != entities.FirmwareStorageS3 {
+		return FirmwareRepository{}, fmt.Errorf("firmware storage must be file or s3, got %q", storage)
+	}
+	if storage == entities.FirmwareStorageS3 && s3Store == nil {
+		return FirmwareRepository{}, errors.New("firmware storage is s3 but s3.bucket is not configured")
+	}
+	urlMinutes := config.Firmware.UrlMinutes
+	if urlMinutes <= 0 {
+		urlMinutes = 60
+	}
+	// The file store already has its own directory
+	prefix := ""
+	if storage == entities.FirmwareStorageS3 {
+		prefix = config.Firmware.S3Prefix
+	}
+
+	return FirmwareRepository{
+		fileStore: fileStore,
+		s3Store:   s3Store,
+		storage:   storage,
+		prefix:    prefix,
+		secret:    []byte(config.JWT.SecretKey),
+		urlTTL:    time.Duration(urlMinutes) * time.Minute,
+	}, nil
+}
+
+func (r *FirmwareRepository) store(storage string) (dependencies.ObjectStore, error) {
+	if storage == entities.FirmwareStorageS3 {
+		if r.s3Store == nil {
+			return nil, fiber.NewError(503, "The firmware is on s3, which is not configured anymore")
+		}
+		return r.s3Store, nil
+	}
+	return r.fileStore, nil
+}
+
+const firmwareColumns = "id_firmware, id_hardware, id_user, version, notes, size, sha256, storage, object_key, created_at"
+
+func scanFirmware(row pgx.Row, firmware *entities.Firmware) error {
+	return row.Scan(&firmware.IdFirmware, &firmware.IdHardware, &firmware.IdUser, &firmware.Version, &firmware.Notes,
+		&firmware.Size, &firmware.Sha256, &firmware.Storage, &firmware.ObjectKey, &firmware.CreatedAt)
+}
+
+// GetAll return the firmware of the user, or of every user for an admin, newest first
+func (r *FirmwareRepository) GetAll(ctx context.Context, tx helper.Querier, currentUser *entities.UserRead, query *entities.FirmwareQuery) (firmwares []entities.Firmware, err error) {
+	sqlStatement := fmt.Sprintf(`
+	SELECT %s FROM firmware
+	WHERE ($1 OR id_user=$2) AND ($3 = 0 OR id_hardware=$3)
+	ORDER BY created_at DESC, id_firmware DESC`, firmwareColumns)
+	rows, err := tx.Query(ctx, sqlStatement, currentUser.IsAdmin, currentUser.IdUser, query.IdHardware)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	firmwares = []entities.Firmware{}
+	for rows.Next() {
+		var firmware entities.Firmware
+		err = scanFirmware(rows, &firmware)
+		if err != nil {
+			return nil, err
+		}
+		firmwares = append(firmwares, firmware)
+	}
+	return firmwares, rows.Err()
+}
+
+func (r *FirmwareRepository) GetById(ctx context.Context, tx helper.Querier, id int) (firmware entities.Firmware, err error) {
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM firmware WHERE id_firmware=$1`, firmwareColumns)
+	err = scanFirmware(tx.QueryRow(ctx, sqlStatement, id), &firmware)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return firmware, fiber.NewError(404, fmt.Sprintf("Firmware with id %d not found", id))
+	}
+	return firmware, err
+}
+
+// GetByVersion return the firmware of the user for the hardware with the version
+func (r *FirmwareRepository) GetByVersion(ctx context.Context, tx helper.Querier, userId int, hardwareId int, version string) (firmware entities.Firmware, err error) {
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM firmware WHERE id_user=$1 AND id_hardware=$2 AND version=$3`, firmwareColumns)
+	err = scanFirmware(tx.QueryRow(ctx, sqlStatement, userId, hardwareId, version), &firmware)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return firmware, fiber.NewError(404, fmt.Sprintf("Firmware %s of hardware %d not found", version, hardwareId))
+	}
+	return firmware, err
+}
+
+// Create write the binary to the store and save the firmware, the version must be new for the
+// hardware of the user. The binary is deleted when saving fail, the caller commit the transaction
+func (r *FirmwareRepository) Create(ctx context.Context, tx helper.Querier, currentUser *entities.UserRead, payload *entities.FirmwareCreate, body io.ReadSeeker) (firmware entities.Firmware, err error) {
+	var exists bool
+	sqlStatement := `SELECT EXISTS (SELECT 1 FROM firmware WHERE id_user=$1 AND id_hardware=$2 AND version=$3)`
+	err = tx.QueryRow(ctx, sqlStatement, currentUser.IdUser, payload.IdHardware, payload.Version).Scan(&exists)
+	if err != nil {
+		return firmware, err
+	}
+	if exists {
+		return firmware, fiber.NewError(409, fmt.Sprintf("Firmware %s of hardware %d already exist", payload.Version, payload.IdHardware))
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, body)
+	if err != nil {
+		return firmware, err
+	}
+	if size == 0 {
+		return firmware, fiber.NewError(400, "The firmware file is empty")
+	}
+	_, err = body.Seek(0, io.SeekStart)
+	if err != nil {
+		return firmware, err
+	}
+
+	store, err := r.store(r.storage)
+	if err != nil {
+		return firmware, err
+	}
+	firmware = entities.Firmware{
+		IdHardware: payload.IdHardware,
+		IdUser:     currentUser.IdUser,
+		Version:    payload.Version,
+		Notes:      payload.Notes,
+		Size:       size,
+		Sha256:     hex.EncodeToString(hash.Sum(nil)),
+		Storage:    r.storage,
+		ObjectKey:  fmt.Sprintf("%s%d/%s.bin", r.prefix, payload.IdHardware, uuid.New().String()),
+	}
+	err = store.Put(ctx, firmware.ObjectKey, body)
+	if err != nil {
+		return firmware, err
+	}
+
+	sqlStatement = `
+	INSERT INTO firmware (id_hardware, id_user, version, notes, size, sha256, storage, object_key)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id_firmware, created_at`
+	err = tx.QueryRow(ctx, sqlStatement, firmware.IdHardware, firmware.IdUser, firmware.Version, firmware.Notes,
+		firmware.Size, firmware.Sha256, firmware.Storage, firmware.ObjectKey).Scan(&firmware.IdFirmware, &firmware.CreatedAt)
+	if err != nil {
+		store.Delete(ctx, firmware.ObjectKey)
+		return firmware, err
+	}
+	return firmware, nil
+}
+
+// Delete remove the firmware and its binary, a node targeting it has no target anymore
+func (r *FirmwareRepository) Delete(ctx context.Context, tx helper.Querier, firmware entities.Firmware) error {
+	_, err := tx.Exec(ctx, `DELETE FROM firmware WHERE id_firmware=$1`, firmware.IdFirmware)
+	if err != nil {
+		return err
+	}
+	store, err := r.store(firmware.Storage)
+	if err != nil {
+		return err
+	}
+	return store.Delete(ctx, firmware.ObjectKey)
+}
+
+// Open return the binary of the firmware
+func (r *FirmwareRepository) Open(ctx context.Context, firmware entities.Firmware) (io.ReadCloser, error) {
+	store, err := r.store(firmware.Storage)
+	if err != nil {
+		return nil, err
+	}
+	body, err := store.Get(ctx, firmware.ObjectKey)
+	if errors.Is(err, dependencies.ErrObjectNotFound) {
+		return nil, fiber.NewError(404, fmt.Sprintf("The binary of firmware %d is missing", firmware.IdFirmware))
+	}
+	return body, err
+}
+
+func (r *FirmwareRepository) signature(id int, expires int64) string {
+	mac := hmac.New(sha256.New, r.secret)
+	fmt.Fprintf(mac, "firmware:%d:%d", id, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignUrl return the download url of the firmware on the server at baseUrl, it is valid for
+// firmware.urlMinutes without any token so a bootloader can fetch it
+func (r *FirmwareRepository) SignUrl(baseUrl string, id int) string {
+	expires := time.Now().Add(r.urlTTL).Unix()
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("signature", r.signature(id, expires))
+	return fmt.Sprintf("%s/firmware/%d/download?%s", baseUrl, id, query.Encode())
+}
+
+// CheckSignature return a 403 when the download url is expired or not signed by the server
+func (r *FirmwareRepository) CheckSignature(id int, expires string, signature string) error {
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return fiber.NewError(403, "The download url is expired, check the firmware again")
+	}
+	if !hmac.Equal([]byte(signature), []byte(r.signature(id, expiresAt))) {
+		return fiber.NewError(403, "The download url signature is not valid")
+	}
+	return nil
+}
+
+// GetNode return the reported version and the target of the node
+func (r *FirmwareRepository) GetNode(ctx context.Context, tx helper.Querier, nodeId int) (status entities.NodeFirmware, err error) {
+	status.IdNode = nodeId
+	var firmwareId *int
+	sqlStatement := `SELECT id_firmware, current_version, reported_at, assigned_at FROM node_firmware WHERE id_node=$1`
+	err = tx.QueryRow(ctx, sqlStatement, nodeId).Scan(&firmwareId, &status.CurrentVersion, &status.ReportedAt, &status.AssignedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return status, nil
+	}
+	if err != nil {
+		return status, err
+	}
+	if firmwareId != nil {
+		target, err := r.GetById(ctx, tx, *firmwareId)
+		if err != nil {
+			return status, err
+		}
+		status.Target = &target
+		status.Pending = status.CurrentVersion == nil || *status.CurrentVersion != target.Version
+	}
+	return status, nil
+}
+
+// Assign set the firmware the node should run, nil clear the target
+func (r *FirmwareRepository) Assign(ctx context.Context, tx helper.Querier, nodeId int, firmwareId *int) error {
+	sqlStatement := `
+	INSERT INTO node_firmware (id_node, id_firmware, assigned_at) VALUES ($1, $2, NOW())
+	ON CONFLICT (id_node) DO UPDATE SET id_firmware=EXCLUDED.id_firmware, assigned_at=EXCLUDED.assigned_at`
+	_, err := tx.Exec(ctx, sqlStatement, nodeId, firmwareId)
+	return err
+}
+
+// Report save the version the node run
+func (r *FirmwareRepository) Report(ctx context.Context, tx helper.Querier, nodeId int, version string) error {
+	sqlStatement := `
+	INSERT INTO node_firmware (id_node, current_version, reported_at) VALUES ($1, $2, NOW())
+	ON CONFLICT (id_node) DO UPDATE SET current_version=EXCLUDED.current_version, reported_at=EXCLUDED.reported_at`
+	_, err := tx.Exec(ctx, sqlStatement, nodeId, version)
+	return err
+}
@@ -3,6 +3,7 @@ package repositories
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/dafaath/iot-server/internal/entities"
 	"github.com/dafaath/iot-server/internal/helper"
@@ -43,9 +44,22 @@ func (h *HardwareRepository) Create(ctx context.Context, tx helper.Querier, payl
 	return hardware, nil
 }
 
-func (u *HardwareRepository) getAllItem(ctx context.Context, tx helper.Querier, sqlStatement string) (hardwares []entities.Hardware, err error) {
+// getAllItem return the hardware matching the condition and the query filter
+func (u *HardwareRepository) getAllItem(ctx context.Context, tx helper.Querier, condition string, query *entities.HardwareQuery) (hardwares []entities.Hardware, err error) {
 	hardwares = []entities.Hardware{}
-	rows, err := tx.Query(ctx, sqlStatement)
+	conditions := []string{condition}
+	args := []interface{}{}
+	if query.Search != "" {
+		args = append(args, query.Search)
+		conditions = append(conditions, fmt.Sprintf("(strpos(lower(name), lower($%[1]d)) > 0 OR strpos(lower(description), lower($%[1]d)) > 0)", len(args)))
+	}
+	if query.Type != "" {
+		args = append(args, query.Type)
+		conditions = append(conditions, fmt.Sprintf("lower(type) = lower($%d)", len(args)))
+	}
+
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM "hardware" WHERE %s`, u.hardwareField(), strings.Join(conditions, " AND "))
+	rows, err := tx.Query(ctx, sqlStatement, args...)
 	if err != nil {
 		return hardwares, err
 	}
@@ -68,18 +82,15 @@ func (u *HardwareRepository) getAllItem(ctx context.Context, tx helper.Querier,
 
 }
 
-func (u *HardwareRepository) GetAllHardware(ctx context.Context, tx helper.Querier) (hardwares []entities.Hardware, err error) {
-	sqlStatement := fmt.Sprintf(`SELECT %s FROM "hardware"`, u.hardwareField())
-	return u.getAllItem(ctx, tx, sqlStatement)
+func (u *HardwareRepository) GetAllHardware(ctx context.Context, tx helper.Querier, query *entities.HardwareQuery) (hardwares []entities.Hardware, err error) {
+	return u.getAllItem(ctx, tx, "TRUE", query)
 }
 
-func (u *HardwareRepository) GetAllNode(ctx context.Context, tx helper.Querier) (hardwares []entities.Hardware, err error) {
-	sqlStatement := fmt.Sprintf(`SELECT %s FROM "hardware" WHERE lower(type) = 'single-board computer' or lower(type) = 'microcontroller unit'`, u.hardwareField())
-	return u.getAllItem(ctx, tx, sqlStatement)
+func (u *HardwareRepository) GetAllNode(ctx context.Context, tx helper.Querier, query *entities.HardwareQuery) (hardwares []entities.Hardware, err error) {
+	return u.getAllItem(ctx, tx, "(lower(type) = 'single-board computer' or lower(type) = 'microcontroller unit')", query)
 }
-func (u *HardwareRepository) GetAllSensor(ctx context.Context, tx helper.Querier) (hardwares []entities.Hardware, err error) {
-	sqlStatement := fmt.Sprintf(`SELECT %s FROM "hardware" WHERE lower(type) = 'sensor'`, u.hardwareField())
-	return u.getAllItem(ctx, tx, sqlStatement)
+func (u *HardwareRepository) GetAllSensor(ctx context.Context, tx helper.Querier, query *entities.HardwareQuery) (hardwares []entities.Hardware, err error) {
+	return u.getAllItem(ctx, tx, "lower(type) = 'sensor'", query)
 }
 
 func (u *HardwareRepository) GetById(ctx context.Context, tx helper.Querier, id int) (hardware entities.Hardware, err error) {
@@ -0,0 +1,178 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+// HistoryRepository keep every version of the sensor, node and hardware configuration with who
+// changed it. The revision is written in the transaction of the change and is kept after the
+// entity is deleted
+type HistoryRepository struct{}
+
+func NewHistoryRepository() (HistoryRepository, error) {
+	return HistoryRepository{}, nil
+}
+
+// toFields turn the entity into its JSON fields, nil is no field
+func toFields(entity interface{}) (fields map[string]interface{}, err error) {
+	fields = map[string]interface{}{}
+	if entity == nil {
+		return fields, nil
+	}
+	data, err := json.Marshal(entity)
+	if err != nil {
+		return fields, err
+	}
+	err = json.Unmarshal(data, &fields)
+	return fields, err
+}
+
+// diffFields return the field which value differ between before and after
+func diffFields(before map[string]interface{}, after map[string]interface{}) map[string]entities.FieldChange {
+	changes := map[string]entities.FieldChange{}
+	for key, from := range before {
+		to, exist := after[key]
+		if !exist || !reflect.DeepEqual(from, to) {
+			changes[key] = entities.FieldChange{From: from, To: to}
+		}
+	}
+	for key, to := range after {
+		if _, exist := before[key]; !exist {
+			changes[key] = entities.FieldChange{From: nil, To: to}
+		}
+	}
+	return changes
+}
+
+// Record add the next version of the entity. before is nil on create and after is nil on delete,
+// idUser 0 is a change without a user. An update or rollback that change nothing add no version. The update of the entity lock its row, so the concurrent
+// change of an entity get their version in order
+func (r *HistoryRepository) Record(ctx context.Context, tx helper.Querier, entityType string, idEntity int, action string, idUser int, before interface{}, after interface{}) (err error) {
+	beforeFields, err := toFields(before)
+	if err != nil {
+		return err
+	}
+	afterFields, err := toFields(after)
+	if err != nil {
+		return err
+	}
+
+	data := afterFields
+	if after == nil {
+		data = beforeFields
+	}
+	changes := diffFields(beforeFields, afterFields)
+	if (action == entities.RevisionUpdate || action == entities.RevisionRollback) && len(changes) == 0 {
+		return nil
+	}
+
+	var user *int
+	if idUser != 0 {
+		user = &idUser
+	}
+
+	sqlStatement := `
+	INSERT INTO entity_revision (entity_type, id_entity, version, action, id_user, data, changes)
+	SELECT $1, $2, COALESCE(MAX(version), 0) + 1, $3, $4, $5, $6
+	FROM entity_revision WHERE entity_type=$1 AND id_entity=$2`
+	_, err = tx.Exec(ctx, sqlStatement, entityType, idEntity, action, user, data, changes)
+	return err
+}
+
+// GetHistory return the latest revision of the entity, newest first
+func (r *HistoryRepository) GetHistory(ctx context.Context, tx helper.Querier, entityType string, idEntity int, query *entities.RevisionQuery) (revisions []entities.EntityRevision, err error) {
+	revisions = []entities.EntityRevision{}
+	limit := query.Limit
+	if limit == 0 {
+		limit = 100
+	}
+
+	sqlStatement := fmt.Sprintf(`
+	SELECT %s
+	FROM entity_revision r LEFT JOIN user_person u ON u.id_user = r.id_user
+	WHERE r.entity_type=$1 AND r.id_entity=$2
+	ORDER BY r.version DESC LIMIT $3`, r.revisionField())
+	rows, err := tx.Query(ctx, sqlStatement, entityType, idEntity, limit)
+	if err != nil {
+		return revisions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var revision entities.EntityRevision
+		err := rows.Scan(r.revisionPointer(&revision)...)
+		if err != nil {
+			return revisions, err
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, rows.Err()
+}
+
+func (r *HistoryRepository) revisionField() string {
+	return "r.id_revision, r.entity_type, r.id_entity, r.version, r.action, r.id_user, u.username, r.changed_at, r.data, r.changes"
+}
+
+func (r *HistoryRepository) revisionPointer(revision *entities.EntityRevision) []interface{} {
+	return []interface{}{&revision.IdRevision, &revision.EntityType, &revision.IdEntity, &revision.Version, &revision.Action,
+		&revision.IdUser, &revision.Username, &revision.ChangedAt, &revision.Data, &revision.Changes}
+}
+
+// GetRevision return one version of the entity, the latest when version is 0
+func (r *HistoryRepository) GetRevision(ctx context.Context, tx helper.Querier, entityType string, idEntity int, version int) (revision entities.EntityRevision, err error) {
+	sqlStatement := fmt.Sprintf(`
+	SELECT %s
+	FROM entity_revision r LEFT JOIN user_person u ON u.id_user = r.id_user
+	WHERE r.entity_type=$1 AND r.id_entity=$2 AND ($3=0 OR r.version=$3)
+	ORDER BY r.version DESC LIMIT 1`, r.revisionField())
+	err = tx.QueryRow(ctx, sqlStatement, entityType, idEntity, version).Scan(r.revisionPointer(&revision)...)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			if version == 0 {
+				return revision, fiber.NewError(404, fmt.Sprintf("The %s with id %d has no history", entityType, idEntity))
+			}
+			return revision, fiber.NewError(404, fmt.Sprintf("Version %d of %s with id %d not found", version, entityType, idEntity))
+		}
+		return revision, err
+	}
+	return revision, nil
+}
+
+// Diff compare the data of two version of the entity, the latest version when to is 0
+func (r *HistoryRepository) Diff(ctx context.Context, tx helper.Querier, entityType string, idEntity int, from int, to int) (diff entities.RevisionDiff, err error) {
+	fromRevision, err := r.GetRevision(ctx, tx, entityType, idEntity, from)
+	if err != nil {
+		return diff, err
+	}
+	toRevision, err := r.GetRevision(ctx, tx, entityType, idEntity, to)
+	if err != nil {
+		return diff, err
+	}
+
+	fromFields := map[string]interface{}{}
+	err = json.Unmarshal(fromRevision.Data, &fromFields)
+	if err != nil {
+		return diff, err
+	}
+	toFields := map[string]interface{}{}
+	err = json.Unmarshal(toRevision.Data, &toFields)
+	if err != nil {
+		return diff, err
+	}
+
+	return entities.RevisionDiff{
+		EntityType: entityType,
+		IdEntity:   idEntity,
+		From:       fromRevision.Version,
+		To:         toRevision.Version,
+		Changes:    diffFields(fromFields, toFields),
+	}, nil
+}
@@ -0,0 +1,59 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+)
+
+// getDeleteImpact list the node and sensor deleted with an entity, nodeSql and sensorSql select
+// their id with the args, no node is deleted when nodeSql is empty. The delete cascade through the
+// foreign key, so this follow the same key
+func getDeleteImpact(ctx context.Context, tx helper.Querier, nodeSql string, sensorSql string, args ...interface{}) (impact entities.DeleteImpact, err error) {
+	impact = entities.DeleteImpact{DryRun: true, Users: []int{}, Hardware: []int{}, Nodes: []int{}}
+	if nodeSql != "" {
+		impact.Nodes, err = scanIds(ctx, tx, nodeSql, args...)
+		if err != nil {
+			return impact, err
+		}
+	}
+	impact.Sensors, err = scanIds(ctx, tx, sensorSql, args...)
+	if err != nil {
+		return impact, err
+	}
+
+	sqlStatement := `
+	SELECT (SELECT COUNT(*) FROM channel WHERE id_sensor = ANY($1))
+		+ (SELECT COALESCE(SUM(row_count), 0) FROM channel_compressed WHERE id_sensor = ANY($1))
+		+ (SELECT COALESCE(SUM(row_count), 0) FROM channel_archive WHERE id_sensor = ANY($1))`
+	err = tx.QueryRow(ctx, sqlStatement, impact.Sensors).Scan(&impact.Channels)
+	return impact, err
+}
+
+// GetDeleteImpact return every node and sensor built on the hardware, of every user
+func (u *HardwareRepository) GetDeleteImpact(ctx context.Context, tx helper.Querier, id int) (impact entities.DeleteImpact, err error) {
+	impact, err = getDeleteImpact(ctx, tx,
+		`SELECT id_node FROM node WHERE id_hardware=$1 ORDER BY id_node`,
+		`SELECT s.id_sensor FROM sensor s JOIN node n ON n.id_node = s.id_node WHERE s.id_hardware=$1 OR n.id_hardware=$1 ORDER BY s.id_sensor`, id)
+	impact.Hardware = []int{id}
+	return impact, err
+}
+
+func (u *NodeRepository) GetDeleteImpact(ctx context.Context, tx helper.Querier, id int) (impact entities.DeleteImpact, err error) {
+	return getDeleteImpact(ctx, tx,
+		`SELECT id_node FROM node WHERE id_node=$1`,
+		`SELECT id_sensor FROM sensor WHERE id_node=$1 AND deleted_at IS NULL ORDER BY id_sensor`, id)
+}
+
+func (u *SensorRepository) GetDeleteImpact(ctx context.Context, tx helper.Querier, id int) (impact entities.DeleteImpact, err error) {
+	return getDeleteImpact(ctx, tx, "", `SELECT id_sensor FROM sensor WHERE id_sensor=$1`, id)
+}
+
+func (u *UserRepository) GetDeleteImpact(ctx context.Context, tx helper.Querier, id int) (impact entities.DeleteImpact, err error) {
+	impact, err = getDeleteImpact(ctx, tx,
+		`SELECT id_node FROM node WHERE id_user=$1 ORDER BY id_node`,
+		`SELECT s.id_sensor FROM sensor s JOIN node n ON n.id_node = s.id_node WHERE n.id_user=$1 ORDER BY s.id_sensor`, id)
+	impact.Users = []int{id}
+	return impact, err
+}
@@ -0,0 +1,125 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+)
+
+// IngestRepository keep the hourly count of the channel posted to each sensor and its last
+// received channel and error, written by the ingest meter
+type IngestRepository struct{}
+
+func NewIngestRepository() (IngestRepository, error) {
+	return IngestRepository{}, nil
+}
+
+// IngestBucket is the hour the ingest is counted in
+func IngestBucket(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Hour)
+}
+
+// Add increase the count of the sensor in the hour. The count of a sensor deleted in the meantime
+// is dropped instead of failing the whole flush
+func (r *IngestRepository) Add(ctx context.Context, tx helper.Querier, sensorId int, bucket time.Time, accepted int64, rejected int64, bytes int64) error {
+	sqlStatement := `
+	INSERT INTO ingest_stat (id_sensor, bucket, accepted, rejected, bytes)
+	SELECT $1, $2, $3, $4, $5 WHERE EXISTS (SELECT 1 FROM sensor WHERE id_sensor = $1)
+	ON CONFLICT (id_sensor, bucket) DO UPDATE SET
+		accepted = ingest_stat.accepted + EXCLUDED.accepted,
+		rejected = ingest_stat.rejected + EXCLUDED.rejected,
+		bytes = ingest_stat.bytes + EXCLUDED.bytes`
+	_, err := tx.Exec(ctx, sqlStatement, sensorId, IngestBucket(bucket), accepted, rejected, bytes)
+	return err
+}
+
+// SetLast set the last received time and last error of the sensor, the one already saved by
+// another instance is kept when it is newer
+func (r *IngestRepository) SetLast(ctx context.Context, tx helper.Querier, sensorId int, received *time.Time, lastError *entities.IngestError) error {
+	var errorAt *time.Time
+	var errorStatus *int
+	var errorMessage *string
+	if lastError != nil {
+		errorAt, errorStatus, errorMessage = &lastError.Time, &lastError.Status, &lastError.Message
+	}
+
+	sqlStatement := `
+	INSERT INTO sensor_ingest (id_sensor, last_received_at, last_error_at, last_error_status, last_error_message)
+	SELECT $1, $2, $3, $4, $5 WHERE EXISTS (SELECT 1 FROM sensor WHERE id_sensor = $1)
+	ON CONFLICT (id_sensor) DO UPDATE SET
+		last_received_at = GREATEST(sensor_ingest.last_received_at, EXCLUDED.last_received_at),
+		last_error_at = GREATEST(sensor_ingest.last_error_at, EXCLUDED.last_error_at),
+		last_error_status = CASE WHEN EXCLUDED.last_error_at > sensor_ingest.last_error_at OR sensor_ingest.last_error_at IS NULL
+			THEN COALESCE(EXCLUDED.last_error_status, sensor_ingest.last_error_status) ELSE sensor_ingest.last_error_status END,
+		last_error_message = CASE WHEN EXCLUDED.last_error_at > sensor_ingest.last_error_at OR sensor_ingest.last_error_at IS NULL
+			THEN COALESCE(EXCLUDED.last_error_message, sensor_ingest.last_error_message) ELSE sensor_ingest.last_error_message END`
+	_, err := tx.Exec(ctx, sqlStatement, sensorId, received, errorAt, errorStatus, errorMessage)
+	return err
+}
+
+// GetBySensors return the ingest of the sensors since the hour, keyed by sensor id. Every sensor is
+// in the result, the one without ingest has zero count
+func (r *IngestRepository) GetBySensors(ctx context.Context, tx helper.Querier, sensorIds []int, since time.Time) (stats map[int]entities.IngestStat, err error) {
+	stats = map[int]entities.IngestStat{}
+	for _, sensorId := range sensorIds {
+		stats[sensorId] = entities.IngestStat{IdSensor: sensorId}
+	}
+
+	sqlStatement := `
+	SELECT id_sensor, SUM(accepted), SUM(rejected), SUM(bytes) FROM ingest_stat
+	WHERE id_sensor = ANY($1) AND bucket >= $2
+	GROUP BY id_sensor`
+	rows, err := tx.Query(ctx, sqlStatement, sensorIds, IngestBucket(since))
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var stat entities.IngestStat
+		err := rows.Scan(&stat.IdSensor, &stat.Accepted, &stat.Rejected, &stat.Bytes)
+		if err != nil {
+			return stats, err
+		}
+		stats[stat.IdSensor] = stat
+	}
+	if err := rows.Err(); err != nil {
+		return stats, err
+	}
+
+	sqlStatement = `
+	SELECT id_sensor, last_received_at, last_error_at, last_error_status, last_error_message FROM sensor_ingest
+	WHERE id_sensor = ANY($1)`
+	rows, err = tx.Query(ctx, sqlStatement, sensorIds)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var sensorId int
+		var received, errorAt *time.Time
+		var errorStatus *int
+		var errorMessage *string
+		err := rows.Scan(&sensorId, &received, &errorAt, &errorStatus, &errorMessage)
+		if err != nil {
+			return stats, err
+		}
+		stat := stats[sensorId]
+		stat.LastReceived = received
+		if errorAt != nil && errorStatus != nil && errorMessage != nil {
+			stat.LastError = &entities.IngestError{Time: *errorAt, Status: *errorStatus, Message: *errorMessage}
+		}
+		stats[sensorId] = stat
+	}
+	return stats, rows.Err()
+}
+
+// DeleteBefore delete the hourly count older than the time
+func (r *IngestRepository) DeleteBefore(ctx context.Context, tx helper.Querier, before time.Time) (count int64, err error) {
+	res, err := tx.Exec(ctx, `DELETE FROM ingest_stat WHERE bucket < $1`, IngestBucket(before))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), nil
+}
@@ -0,0 +1,348 @@
+package repositories
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+// Mismatch listed in the status and in the notification to the admin
+const integrityMismatchLimit = 100
+
+// IntegrityRepository keep a checksum of the channel of every settled sensor day and verify it
+// again later, to find a channel changed or lost outside the server, e.g. by a disk or a manual
+// query. A change made by the server itself drop the checksum of the day with invalidateChecksum, so
+// it is taken again instead of reported. An archived day is not checked, its file is in the bucket
+type IntegrityRepository struct {
+	channelRepository      *ChannelRepository
+	notificationRepository *NotificationRepository
+	settleDays             int
+	daysPerRun             int
+}
+
+func NewIntegrityRepository(channelRepository *ChannelRepository, notificationRepository *NotificationRepository, config *configs.Config) (IntegrityRepository, error) {
+	settleDays := config.Integrity.SettleDays
+	if settleDays <= 0 {
+		settleDays = 2
+	}
+	daysPerRun := config.Integrity.DaysPerRun
+	if daysPerRun <= 0 {
+		daysPerRun = 1000
+	}
+	return IntegrityRepository{
+		channelRepository:      channelRepository,
+		notificationRepository: notificationRepository,
+		settleDays:             settleDays,
+		daysPerRun:             daysPerRun,
+	}, nil
+}
+
+// invalidateChecksum drop the checksum of the sensor day in [from, to] when the server change their
+// channel, of every sensor when sensorId is 0
+func invalidateChecksum(ctx context.Context, tx helper.Querier, sensorId int, from time.Time, to time.Time) error {
+	sqlStatement := `DELETE FROM channel_checksum WHERE ($1=0 OR id_sensor=$1) AND day >= $2::DATE AND day <= $3::DATE`
+	_, err := tx.Exec(ctx, sqlStatement, sensorId, from, to)
+	return err
+}
+
+const checksumColumns = "id_sensor, day, row_count, checksum, computed_at, verified_at, status, actual_row_count, actual_checksum"
+
+func scanChecksum(row pgx.Row, checksum *entities.ChannelChecksum) error {
+	return row.Scan(&checksum.IdSensor, &checksum.Day, &checksum.RowCount, &checksum.Checksum, &checksum.ComputedAt, &checksum.VerifiedAt,
+		&checksum.Status, &checksum.ActualRowCount, &checksum.ActualChecksum)
+}
+
+// settledBefore return the first day that isn't settled yet, the day before it can be checksummed
+func (r *IntegrityRepository) settledBefore(now time.Time) time.Time {
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -r.settleDays)
+}
+
+// dayChecksum return the row count and checksum of every channel of the sensor day, read through
+// the compressed day so compressing it doesn't change the checksum. The checksum is the sum of a
+// hash of each channel, it doesn't depend on the order of the channel stored at the same time
+func (r *IntegrityRepository) dayChecksum(ctx context.Context, tx helper.Querier, sensorId int, day time.Time) (count int64, checksum string, err error) {
+	from, to := day, day.AddDate(0, 0, 1)
+	var sum uint64
+	err = r.channelRepository.ForEachRawBySensor(ctx, tx, sensorId, entities.ChannelQuery{From: &from, To: &to}, func(channel entities.Channel) error {
+		quality := channel.Quality
+		if quality == "" {
+			quality = entities.QualityGood
+		}
+		hash := sha256.Sum256([]byte(fmt.Sprintf("%d|%016x|%s|%s", channel.Time.UnixMicro(), math.Float64bits(channel.Value), channel.Name, quality)))
+		sum += binary.BigEndian.Uint64(hash[:8])
+		count++
+		return nil
+	})
+	return count, fmt.Sprintf("%016x", sum), err
+}
+
+// compute take the checksum of the sensor day, an existing checksum is kept
+func (r *IntegrityRepository) compute(ctx context.Context, tx helper.Querier, sensorId int, day time.Time) error {
+	count, checksum, err := r.dayChecksum(ctx, tx, sensorId, day)
+	if err != nil {
+		return err
+	}
+	sqlStatement := `
+	INSERT INTO channel_checksum (id_sensor, day, row_count, checksum) VALUES ($1, $2, $3, $4)
+	ON CONFLICT (id_sensor, day) DO NOTHING`
+	_, err = tx.Exec(ctx, sqlStatement, sensorId, day, count, checksum)
+	return err
+}
+
+// verify take the checksum of the sensor day again and compare it with the kept one. The mismatch
+// is returned when it is new
+func (r *IntegrityRepository) verify(ctx context.Context, tx helper.Querier, expected entities.ChannelChecksum) (mismatch *entities.ChannelChecksum, err error) {
+	count, checksum, err := r.dayChecksum(ctx, tx, expected.IdSensor, expected.Day)
+	if err != nil {
+		return nil, err
+	}
+	if count == expected.RowCount && checksum == expected.Checksum {
+		sqlStatement := `UPDATE channel_checksum SET verified_at=NOW(), status=$3, actual_row_count=NULL, actual_checksum=NULL WHERE id_sensor=$1 AND day=$2`
+		_, err = tx.Exec(ctx, sqlStatement, expected.IdSensor, expected.Day, entities.ChecksumOk)
+		return nil, err
+	}
+
+	sqlStatement := fmt.Sprintf(`
+	UPDATE channel_checksum SET verified_at=NOW(), status=$3, actual_row_count=$4, actual_checksum=$5
+	WHERE id_sensor=$1 AND day=$2 RETURNING %s`, checksumColumns)
+	var found entities.ChannelChecksum
+	err = scanChecksum(tx.QueryRow(ctx, sqlStatement, expected.IdSensor, expected.Day, entities.ChecksumMismatch, count, checksum), &found)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Invalidated while it was verified
+		return nil, nil
+	}
+	if err != nil || expected.Status == entities.ChecksumMismatch {
+		return nil, err
+	}
+	return &found, nil
+}
+
+// Run take the checksum of the settled sensor day that has none, newest first, and verify the
+// checksum verified the longest time ago, up to daysPerRun of each. A new mismatch is sent to every
+// admin as a notification
+func (r *IntegrityRepository) Run(ctx context.Context, tx helper.Querier, now time.Time) (result entities.IntegrityResult, err error) {
+	result.Mismatches = []entities.ChannelChecksum{}
+	before := r.settledBefore(now)
+
+	_, err = tx.Exec(ctx, `DELETE FROM channel_checksum k USING channel_archive a WHERE a.id_sensor = k.id_sensor AND a.day = k.day`)
+	if err != nil {
+		return result, err
+	}
+
+	// The day is listed before it is checksummed, taking a checksum run other query
+	type sensorDay struct {
+		sensorId int
+		day      time.Time
+	}
+	days := []sensorDay{}
+	sqlStatement := `
+	SELECT d.id_sensor, d.day FROM (
+		SELECT DISTINCT id_sensor, time::DATE AS day FROM channel WHERE time < $1
+		UNION SELECT id_sensor, day FROM channel_compressed WHERE day < $1::DATE
+	) d
+	WHERE NOT EXISTS (SELECT 1 FROM channel_checksum k WHERE k.id_sensor = d.id_sensor AND k.day = d.day)
+		AND NOT EXISTS (SELECT 1 FROM channel_archive a WHERE a.id_sensor = d.id_sensor AND a.day = d.day)
+	ORDER BY d.day DESC, d.id_sensor
+	LIMIT $2`
+	rows, err := tx.Query(ctx, sqlStatement, before, r.daysPerRun)
+	if err != nil {
+		return result, err
+	}
+	for rows.Next() {
+		var day sensorDay
+		err = rows.Scan(&day.sensorId, &day.day)
+		if err != nil {
+			rows.Close()
+			return result, err
+		}
+		days = append(days, day)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+	for _, day := range days {
+		err = r.compute(ctx, tx, day.sensorId, day.day)
+		if err != nil {
+			return result, err
+		}
+		result.Computed++
+	}
+
+	// The checksum just taken is verified last
+	sqlStatement = fmt.Sprintf(`SELECT %s FROM channel_checksum ORDER BY verified_at LIMIT $1`, checksumColumns)
+	checksums, err := r.getChecksums(ctx, tx, sqlStatement, r.daysPerRun)
+	if err != nil {
+		return result, err
+	}
+	err = r.verifyAll(ctx, tx, checksums, &result)
+	if err != nil {
+		return result, err
+	}
+
+	return result, r.notify(ctx, tx, result.Mismatches)
+}
+
+// Verify take the checksum of the settled day of the sensor in [from, to] that has none and verify
+// the day that has one
+func (r *IntegrityRepository) Verify(ctx context.Context, tx helper.Querier, payload *entities.IntegrityVerify, now time.Time) (result entities.IntegrityResult, err error) {
+	result.Mismatches = []entities.ChannelChecksum{}
+	from := time.Date(payload.From.Year(), payload.From.Month(), payload.From.Day(), 0, 0, 0, 0, time.UTC)
+	to := time.Date(payload.To.Year(), payload.To.Month(), payload.To.Day(), 0, 0, 0, 0, time.UTC)
+	if to.Before(from) {
+		return result, fiber.NewError(400, "to must not be before from")
+	}
+	if to.Sub(from) > 366*24*time.Hour {
+		return result, fiber.NewError(400, "Can't verify more than 366 days at once")
+	}
+	before := r.settledBefore(now)
+	if !to.Before(before) {
+		to = before.AddDate(0, 0, -1)
+	}
+
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM channel_checksum WHERE id_sensor=$1 AND day >= $2 AND day <= $3 ORDER BY day`, checksumColumns)
+	checksums, err := r.getChecksums(ctx, tx, sqlStatement, payload.IdSensor, from, to)
+	if err != nil {
+		return result, err
+	}
+	err = r.verifyAll(ctx, tx, checksums, &result)
+	if err != nil {
+		return result, err
+	}
+
+	sqlStatement = `
+	SELECT d.day FROM (
+		SELECT DISTINCT time::DATE AS day FROM channel WHERE id_sensor=$1 AND time >= $2 AND time < $3
+		UNION SELECT day FROM channel_compressed WHERE id_sensor=$1 AND day >= $2::DATE AND day < $3::DATE
+	) d
+	WHERE NOT EXISTS (SELECT 1 FROM channel_checksum k WHERE k.id_sensor = $1 AND k.day = d.day)
+		AND NOT EXISTS (SELECT 1 FROM channel_archive a WHERE a.id_sensor = $1 AND a.day = d.day)
+	ORDER BY d.day`
+	rows, err := tx.Query(ctx, sqlStatement, payload.IdSensor, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		return result, err
+	}
+	days := []time.Time{}
+	for rows.Next() {
+		var day time.Time
+		err = rows.Scan(&day)
+		if err != nil {
+			rows.Close()
+			return result, err
+		}
+		days = append(days, day)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+	for _, day := range days {
+		err = r.compute(ctx, tx, payload.IdSensor, day)
+		if err != nil {
+			return result, err
+		}
+		result.Computed++
+	}
+
+	return result, r.notify(ctx, tx, result.Mismatches)
+}
+
+func (r *IntegrityRepository) getChecksums(ctx context.Context, tx helper.Querier, sqlStatement string, args ...interface{}) (checksums []entities.ChannelChecksum, err error) {
+	rows, err := tx.Query(ctx, sqlStatement, args...)
+	if err != nil {
+		return checksums, err
+	}
+	defer rows.Close()
+
+	checksums = []entities.ChannelChecksum{}
+	for rows.Next() {
+		var checksum entities.ChannelChecksum
+		err = scanChecksum(rows, &checksum)
+		if err != nil {
+			return checksums, err
+		}
+		checksums = append(checksums, checksum)
+	}
+	return checksums, rows.Err()
+}
+
+func (r *IntegrityRepository) verifyAll(ctx context.Context, tx helper.Querier, checksums []entities.ChannelChecksum, result *entities.IntegrityResult) error {
+	for _, checksum := range checksums {
+		mismatch, err := r.verify(ctx, tx, checksum)
+		if err != nil {
+			return err
+		}
+		result.Verified++
+		if mismatch != nil {
+			result.Mismatches = append(result.Mismatches, *mismatch)
+		}
+	}
+	return nil
+}
+
+// notify send the new mismatch to every admin
+func (r *IntegrityRepository) notify(ctx context.Context, tx helper.Querier, mismatches []entities.ChannelChecksum) error {
+	if len(mismatches) == 0 {
+		return nil
+	}
+
+	lines := []string{}
+	for i, mismatch := range mismatches {
+		if i == integrityMismatchLimit {
+			lines = append(lines, fmt.Sprintf("and %d more", len(mismatches)-i))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("Sensor %d on %s: %d channel, found %d", mismatch.IdSensor, mismatch.Day.Format("2006-01-02"), mismatch.RowCount, *mismatch.ActualRowCount))
+	}
+	link := "/admin/integrity"
+	_, err := r.notificationRepository.NotifyAdmins(ctx, tx, &entities.NotificationCreate{
+		Type:    "system",
+		Title:   fmt.Sprintf("Channel of %d sensor day changed unexpectedly", len(mismatches)),
+		Message: strings.Join(lines, "\n"),
+		Link:    &link,
+	})
+	return err
+}
+
+// GetStatus return the number of checksummed day and the mismatch not accepted yet
+func (r *IntegrityRepository) GetStatus(ctx context.Context, tx helper.Querier) (status entities.IntegrityStatus, err error) {
+	sqlStatement := `SELECT COUNT(*), COUNT(*) FILTER (WHERE status=$1), MIN(verified_at) FROM channel_checksum`
+	err = tx.QueryRow(ctx, sqlStatement, entities.ChecksumMismatch).Scan(&status.Days, &status.MismatchDays, &status.OldestVerifiedAt)
+	if err != nil {
+		return status, err
+	}
+
+	sqlStatement = fmt.Sprintf(`SELECT %s FROM channel_checksum WHERE status=$1 ORDER BY day DESC, id_sensor LIMIT $2`, checksumColumns)
+	status.Mismatches, err = r.getChecksums(ctx, tx, sqlStatement, entities.ChecksumMismatch, integrityMismatchLimit)
+	return status, err
+}
+
+// Accept take the current channel of the sensor day as its checksum
+func (r *IntegrityRepository) Accept(ctx context.Context, tx helper.Querier, payload *entities.IntegrityAccept) (checksum entities.ChannelChecksum, err error) {
+	day := time.Date(payload.Day.Year(), payload.Day.Month(), payload.Day.Day(), 0, 0, 0, 0, time.UTC)
+	count, sum, err := r.dayChecksum(ctx, tx, payload.IdSensor, day)
+	if err != nil {
+		return checksum, err
+	}
+
+	sqlStatement := fmt.Sprintf(`
+	UPDATE channel_checksum SET row_count=$3, checksum=$4, computed_at=NOW(), verified_at=NOW(), status=$5, actual_row_count=NULL, actual_checksum=NULL
+	WHERE id_sensor=$1 AND day=$2 RETURNING %s`, checksumColumns)
+	err = scanChecksum(tx.QueryRow(ctx, sqlStatement, payload.IdSensor, day, count, sum, entities.ChecksumOk), &checksum)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return checksum, fiber.NewError(404, fmt.Sprintf("Sensor %d has no checksum on %s", payload.IdSensor, day.Format("2006-01-02")))
+	}
+	return checksum, err
+}
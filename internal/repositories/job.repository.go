@@ -0,0 +1,86 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+type JobRepository struct{}
+
+func NewJobRepository() (JobRepository, error) {
+	return JobRepository{}, nil
+}
+
+func (j *JobRepository) jobField() string {
+	return "name, schedule, running, last_run_at, last_instance, last_duration_ms, last_status, last_message, next_run_at"
+}
+
+func (j *JobRepository) jobPointer(job *entities.Job) []interface{} {
+	return []interface{}{&job.Name, &job.Schedule, &job.Running, &job.LastRunAt, &job.LastInstance, &job.LastDurationMs, &job.LastStatus, &job.LastMessage, &job.NextRunAt}
+}
+
+// Register insert the job or update its schedule, keeping the run history
+func (j *JobRepository) Register(ctx context.Context, tx helper.Querier, name string, schedule string, nextRunAt time.Time) (err error) {
+	sqlStatement := `
+	INSERT INTO scheduled_job (name, schedule, next_run_at)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (name) DO UPDATE SET schedule=EXCLUDED.schedule, next_run_at=EXCLUDED.next_run_at`
+	_, err = tx.Exec(ctx, sqlStatement, name, schedule, nextRunAt)
+	return err
+}
+
+func (j *JobRepository) GetAll(ctx context.Context, tx helper.Querier) (jobs []entities.Job, err error) {
+	jobs = []entities.Job{}
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM scheduled_job ORDER BY name`, j.jobField())
+	rows, err := tx.Query(ctx, sqlStatement)
+	if err != nil {
+		return jobs, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var job entities.Job
+		err := rows.Scan(j.jobPointer(&job)...)
+		if err != nil {
+			return jobs, err
+		}
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return jobs, err
+	}
+	return jobs, nil
+}
+
+func (j *JobRepository) GetByName(ctx context.Context, tx helper.Querier, name string) (job entities.Job, err error) {
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM scheduled_job WHERE name=$1`, j.jobField())
+	err = tx.QueryRow(ctx, sqlStatement, name).Scan(j.jobPointer(&job)...)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return job, fiber.NewError(404, fmt.Sprintf("Job with name %s not found", name))
+		}
+		return job, err
+	}
+	return job, nil
+}
+
+func (j *JobRepository) MarkStarted(ctx context.Context, tx helper.Querier, name string, startedAt time.Time, instanceId string) (err error) {
+	sqlStatement := `UPDATE scheduled_job SET running=TRUE, last_run_at=$1, last_instance=$2 WHERE name=$3`
+	_, err = tx.Exec(ctx, sqlStatement, startedAt, instanceId, name)
+	return err
+}
+
+func (j *JobRepository) MarkFinished(ctx context.Context, tx helper.Querier, name string, duration time.Duration, status string, message string, nextRunAt time.Time) (err error) {
+	sqlStatement := `
+	UPDATE scheduled_job
+	SET running=FALSE, last_duration_ms=$1, last_status=$2, last_message=$3, next_run_at=$4
+	WHERE name=$5`
+	_, err = tx.Exec(ctx, sqlStatement, duration.Milliseconds(), status, message, nextRunAt, name)
+	return err
+}
@@ -0,0 +1,322 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+// Default of the KPI when the window, base or scale is not set
+const (
+	defaultKpiWindowSeconds = 86400
+	defaultKpiBase          = 18
+	defaultKpiScale         = 1
+)
+
+// KpiRepository keep the KPI of the sensor and their materialized value
+type KpiRepository struct {
+	channelRepository *ChannelRepository
+}
+
+func NewKpiRepository(channelRepository *ChannelRepository) (KpiRepository, error) {
+	return KpiRepository{
+		channelRepository: channelRepository,
+	}, nil
+}
+
+func (r *KpiRepository) kpiField() string {
+	return "kpi.id_kpi, kpi.id_sensor, kpi.name, kpi.function, kpi.window_seconds, kpi.base_value, kpi.scale, kpi.unit, kpi.computed_until"
+}
+
+func (r *KpiRepository) kpiPointer(kpi *entities.Kpi) []interface{} {
+	return []interface{}{&kpi.IdKpi, &kpi.IdSensor, &kpi.Name, &kpi.Function, &kpi.WindowSeconds, &kpi.Base, &kpi.Scale, &kpi.Unit, &kpi.ComputedUntil}
+}
+
+// GetAll return the KPI of the user sensor, of every sensor when idUser is 0
+func (r *KpiRepository) GetAll(ctx context.Context, tx helper.Querier, idUser int) (kpis []entities.Kpi, err error) {
+	kpis = []entities.Kpi{}
+	sqlStatement := fmt.Sprintf(`
+	SELECT %s FROM kpi
+	INNER JOIN sensor ON sensor.id_sensor=kpi.id_sensor
+	INNER JOIN node ON node.id_node=sensor.id_node
+	WHERE $1=0 OR node.id_user=$1
+	ORDER BY kpi.id_kpi`, r.kpiField())
+	rows, err := tx.Query(ctx, sqlStatement, idUser)
+	if err != nil {
+		return kpis, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var kpi entities.Kpi
+		err := rows.Scan(r.kpiPointer(&kpi)...)
+		if err != nil {
+			return kpis, err
+		}
+		kpis = append(kpis, kpi)
+	}
+	return kpis, rows.Err()
+}
+
+func (r *KpiRepository) GetById(ctx context.Context, tx helper.Querier, id int) (kpi entities.Kpi, err error) {
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM kpi WHERE kpi.id_kpi=$1`, r.kpiField())
+	err = tx.QueryRow(ctx, sqlStatement, id).Scan(r.kpiPointer(&kpi)...)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return kpi, fiber.NewError(404, fmt.Sprintf("KPI with id %d not found", id))
+		}
+		return kpi, err
+	}
+	return kpi, nil
+}
+
+// withDefault fill the window, base and scale that are not set
+func (r *KpiRepository) withDefault(payload *entities.KpiCreate) entities.KpiCreate {
+	kpi := *payload
+	if kpi.WindowSeconds == 0 {
+		kpi.WindowSeconds = defaultKpiWindowSeconds
+	}
+	if kpi.Base == nil && (kpi.Function == entities.KpiHeatingDegreeDay || kpi.Function == entities.KpiCoolingDegreeDay) {
+		base := float64(defaultKpiBase)
+		kpi.Base = &base
+	}
+	if kpi.Scale == nil {
+		scale := float64(defaultKpiScale)
+		kpi.Scale = &scale
+	}
+	return kpi
+}
+
+func (r *KpiRepository) Create(ctx context.Context, tx helper.Querier, payload *entities.KpiCreate) (kpi entities.Kpi, err error) {
+	kpi = entities.Kpi{KpiCreate: r.withDefault(payload)}
+	sqlStatement := `
+	INSERT INTO kpi (id_sensor, name, function, window_seconds, base_value, scale, unit)
+	VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id_kpi`
+	err = tx.QueryRow(ctx, sqlStatement, kpi.IdSensor, kpi.Name, kpi.Function, kpi.WindowSeconds, kpi.Base, kpi.Scale, kpi.Unit).Scan(&kpi.IdKpi)
+	return kpi, err
+}
+
+// Update change the KPI definition, the materialized value is deleted and computed again by the next
+// kpi job run
+func (r *KpiRepository) Update(ctx context.Context, tx helper.Querier, id int, payload *entities.KpiCreate) (err error) {
+	kpi := r.withDefault(payload)
+	sqlStatement := `
+	UPDATE kpi
+	SET id_sensor=$1, name=$2, function=$3, window_seconds=$4, base_value=$5, scale=$6, unit=$7, computed_until=NULL
+	WHERE id_kpi=$8`
+	res, err := tx.Exec(ctx, sqlStatement, kpi.IdSensor, kpi.Name, kpi.Function, kpi.WindowSeconds, kpi.Base, kpi.Scale, kpi.Unit, id)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on update KPI with id %d", id))
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM kpi_value WHERE id_kpi=$1`, id)
+	return err
+}
+
+func (r *KpiRepository) Delete(ctx context.Context, tx helper.Querier, id int) (err error) {
+	res, err := tx.Exec(ctx, `DELETE FROM kpi WHERE id_kpi=$1`, id)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on delete KPI with id %d", id))
+	}
+	return nil
+}
+
+// GetValues return the materialized value of the KPI which window start in [from, to), ordered by window
+func (r *KpiRepository) GetValues(ctx context.Context, tx helper.Querier, id int, from *time.Time, to *time.Time) (values []entities.KpiValue, err error) {
+	values = []entities.KpiValue{}
+	sqlStatement := `
+	SELECT bucket, value FROM kpi_value
+	WHERE id_kpi=$1 AND ($2::TIMESTAMP IS NULL OR bucket >= $2) AND ($3::TIMESTAMP IS NULL OR bucket < $3)
+	ORDER BY bucket`
+	rows, err := tx.Query(ctx, sqlStatement, id, from, to)
+	if err != nil {
+		return values, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var value entities.KpiValue
+		err := rows.Scan(&value.Bucket, &value.Value)
+		if err != nil {
+			return values, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// Materialize compute the window of every KPI finished before now since its last run. A channel
+// received late for an already computed window isn't counted until the KPI is edited
+func (r *KpiRepository) Materialize(ctx context.Context, db helper.Querier, now time.Time) (kpis int, count int64, err error) {
+	all, err := r.GetAll(ctx, db, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, kpi := range all {
+		written, err := r.materializeKpi(ctx, db, kpi, now)
+		if err != nil {
+			return kpis, count, fmt.Errorf("kpi %d: %w", kpi.IdKpi, err)
+		}
+		kpis++
+		count += written
+	}
+	return kpis, count, nil
+}
+
+// materializeKpi write the value of the KPI window in [computed_until, now truncated to the window)
+// in one transaction
+func (r *KpiRepository) materializeKpi(ctx context.Context, db helper.Querier, kpi entities.Kpi, now time.Time) (count int64, err error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	window := time.Duration(kpi.WindowSeconds) * time.Second
+	from := kpi.ComputedUntil
+	if from == nil {
+		first, _, err := r.channelRepository.GetTimeRangeBySensor(ctx, tx, kpi.IdSensor)
+		if err != nil {
+			return 0, err
+		}
+		if first == nil {
+			return 0, nil
+		}
+		start := first.UTC().Truncate(window)
+		from = &start
+	}
+	to := now.UTC().Truncate(window)
+	if !to.After(*from) {
+		return 0, nil
+	}
+
+	builder := kpiBuilder{kpi: kpi, window: window}
+	unnamed := ""
+	query := entities.ChannelQuery{From: from, To: &to, Quality: []string{entities.QualityGood}, Name: &unnamed}
+	err = r.channelRepository.ForEachBySensor(ctx, tx, kpi.IdSensor, query, builder.add)
+	if err != nil {
+		return 0, err
+	}
+	builder.finish()
+
+	if len(builder.values) > 0 {
+		buckets := make([]time.Time, len(builder.values))
+		values := make([]float64, len(builder.values))
+		for i, value := range builder.values {
+			buckets[i] = value.Bucket
+			values[i] = value.Value
+		}
+		sqlStatement := `
+		INSERT INTO kpi_value (id_kpi, bucket, value)
+		SELECT $1, * FROM unnest($2::TIMESTAMP[], $3::FLOAT[])
+		ON CONFLICT (id_kpi, bucket) DO UPDATE SET value=EXCLUDED.value`
+		_, err = tx.Exec(ctx, sqlStatement, kpi.IdKpi, buckets, values)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE kpi SET computed_until=$1 WHERE id_kpi=$2`, to, kpi.IdKpi)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(builder.values)), tx.Commit(ctx)
+}
+
+// kpiBuilder compute the KPI of channel ordered by time per window, aligned to UTC midnight. A window
+// without channel has no value, and the integral doesn't span a window without channel
+type kpiBuilder struct {
+	kpi    entities.Kpi
+	window time.Duration
+	values []entities.KpiValue
+
+	bucket   time.Time
+	count    int64
+	sum      float64
+	min      float64
+	max      float64
+	integral float64
+	previous entities.Channel
+}
+
+func (b *kpiBuilder) add(channel entities.Channel) error {
+	bucket := channel.Time.UTC().Truncate(b.window)
+	if b.count == 0 || !b.bucket.Equal(bucket) {
+		// The trapezoid between the channel of two adjacent window is split at the window boundary
+		var carried float64
+		if b.count > 0 && bucket.Equal(b.bucket.Add(b.window)) {
+			total := channel.Time.Sub(b.previous.Time)
+			before := bucket.Sub(b.previous.Time)
+			boundary := b.previous.Value + (channel.Value-b.previous.Value)*before.Seconds()/total.Seconds()
+			b.integral += (b.previous.Value + boundary) / 2 * before.Hours()
+			carried = (boundary + channel.Value) / 2 * (total - before).Hours()
+		}
+		b.finish()
+		b.bucket = bucket
+		b.count, b.sum, b.integral = 0, 0, carried
+		b.min, b.max = channel.Value, channel.Value
+	} else {
+		// Trapezoid between the channel of the same window, in hour
+		hours := channel.Time.Sub(b.previous.Time).Hours()
+		b.integral += (b.previous.Value + channel.Value) / 2 * hours
+	}
+
+	b.count++
+	b.sum += channel.Value
+	if channel.Value < b.min {
+		b.min = channel.Value
+	}
+	if channel.Value > b.max {
+		b.max = channel.Value
+	}
+	b.previous = channel
+	return nil
+}
+
+// finish add the value of the current window, it must be called after the last channel
+func (b *kpiBuilder) finish() {
+	if b.count == 0 {
+		return
+	}
+
+	var value float64
+	avg := b.sum / float64(b.count)
+	days := b.window.Hours() / 24
+	switch b.kpi.Function {
+	case entities.KpiAvg:
+		value = avg
+	case entities.KpiMin:
+		value = b.min
+	case entities.KpiMax:
+		value = b.max
+	case entities.KpiSum:
+		value = b.sum
+	case entities.KpiCount:
+		value = float64(b.count)
+	case entities.KpiIntegral:
+		value = b.integral
+	case entities.KpiHeatingDegreeDay:
+		value = math.Max(*b.kpi.Base-avg, 0) * days
+	case entities.KpiCoolingDegreeDay:
+		value = math.Max(avg-*b.kpi.Base, 0) * days
+	}
+	if b.kpi.Scale != nil {
+		value *= *b.kpi.Scale
+	}
+
+	b.values = append(b.values, entities.KpiValue{Bucket: b.bucket, Value: value})
+	b.count = 0
+}
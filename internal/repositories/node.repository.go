@@ -3,6 +3,8 @@ package repositories
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/dafaath/iot-server/internal/entities"
 	"github.com/dafaath/iot-server/internal/helper"
@@ -21,11 +23,11 @@ func (u *NodeRepository) nodeFieldWithoutId() string {
 }
 
 func (u *NodeRepository) nodeField() string {
-	return "id_node, " + u.nodeFieldWithoutId()
+	return "id_node, " + u.nodeFieldWithoutId() + ", last_seen, online"
 }
 
 func (u *NodeRepository) nodePointer(node *entities.Node) []interface{} {
-	return []interface{}{&node.IdNode, &node.Name, &node.Location, &node.IdUser, &node.IdHardware}
+	return []interface{}{&node.IdNode, &node.Name, &node.Location, &node.IdUser, &node.IdHardware, &node.LastSeen, &node.Online}
 }
 
 func (h *NodeRepository) Create(ctx context.Context, tx helper.Querier, payload *entities.NodeCreate, currentUser *entities.UserRead) (node entities.Node, err error) {
@@ -46,25 +48,36 @@ func (h *NodeRepository) Create(ctx context.Context, tx helper.Querier, payload
 	return node, nil
 }
 
-func (u *NodeRepository) GetAll(ctx context.Context, tx helper.Querier, currentUser *entities.UserRead) (nodes []entities.Node, err error) {
+// nodeCondition return the WHERE condition and its argument of the node list.
+// The status filter need the node activity, it is applied by the handler
+func (u *NodeRepository) nodeCondition(currentUser *entities.UserRead, query *entities.NodeQuery) (string, []interface{}) {
+	conditions := []string{"deleted_at IS NULL", "($1 OR id_user=$2 OR id_node IN (SELECT id_node FROM node_share WHERE node_share.id_user=$2))"}
+	args := []interface{}{currentUser.IsAdmin, currentUser.IdUser}
+	if query.Search != "" {
+		args = append(args, query.Search)
+		conditions = append(conditions, fmt.Sprintf("(strpos(lower(name), lower($%[1]d)) > 0 OR strpos(lower(location), lower($%[1]d)) > 0)", len(args)))
+	}
+	if query.IdHardware != 0 {
+		args = append(args, query.IdHardware)
+		conditions = append(conditions, fmt.Sprintf("id_hardware=$%d", len(args)))
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// GetAll return the node of the user and the node shared with them, or every node for admin, filtered by the query and ordered by name
+func (u *NodeRepository) GetAll(ctx context.Context, tx helper.Querier, currentUser *entities.UserRead, query *entities.NodeQuery) (nodes []entities.Node, err error) {
 	nodes = []entities.Node{}
-	var sqlStatement string
-	var rows pgx.Rows
-	if currentUser.IsAdmin {
-		sqlStatement = fmt.Sprintf(`SELECT %s FROM "node"`, u.nodeField())
-		rows, err = tx.Query(ctx, sqlStatement)
-		if err != nil {
-			return nodes, err
-		}
-		defer rows.Close()
-	} else {
-		sqlStatement = fmt.Sprintf(`SELECT %s FROM "node" WHERE id_user=$1`, u.nodeField())
-		rows, err = tx.Query(ctx, sqlStatement, currentUser.IdUser)
-		if err != nil {
-			return nodes, err
-		}
-		defer rows.Close()
+	condition, args := u.nodeCondition(currentUser, query)
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM "node" WHERE %s ORDER BY name, id_node`, u.nodeField(), condition)
+	if query.Limit > 0 {
+		args = append(args, query.Limit, query.Offset)
+		sqlStatement += fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(args)-1, len(args))
 	}
+	rows, err := tx.Query(ctx, sqlStatement, args...)
+	if err != nil {
+		return nodes, err
+	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var node entities.Node
@@ -82,8 +95,16 @@ func (u *NodeRepository) GetAll(ctx context.Context, tx helper.Querier, currentU
 	return nodes, nil
 }
 
+// CountAll return the number of node matching the query, without the limit
+func (u *NodeRepository) CountAll(ctx context.Context, tx helper.Querier, currentUser *entities.UserRead, query *entities.NodeQuery) (count int, err error) {
+	condition, args := u.nodeCondition(currentUser, query)
+	sqlStatement := fmt.Sprintf(`SELECT COUNT(*) FROM "node" WHERE %s`, condition)
+	err = tx.QueryRow(ctx, sqlStatement, args...).Scan(&count)
+	return count, err
+}
+
 func (u *NodeRepository) GetById(ctx context.Context, tx helper.Querier, id int) (node entities.Node, err error) {
-	sqlStatement := fmt.Sprintf(`SELECT %s FROM "node" WHERE id_node=$1`, u.nodeField())
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM "node" WHERE id_node=$1 AND deleted_at IS NULL`, u.nodeField())
 	err = tx.QueryRow(ctx, sqlStatement, id).Scan(
 		u.nodePointer(&node)...,
 	)
@@ -96,9 +117,165 @@ func (u *NodeRepository) GetById(ctx context.Context, tx helper.Querier, id int)
 	return node, nil
 }
 
+// HasAccess is true when the user own the node, is admin, or the node is shared with them with the
+// permission, a write share also give read
+func (u *NodeRepository) HasAccess(ctx context.Context, tx helper.Querier, node entities.Node, currentUser *entities.UserRead, permission string) (bool, error) {
+	if node.IdUser == currentUser.IdUser || currentUser.IsAdmin {
+		return true, nil
+	}
+
+	var shared string
+	err := tx.QueryRow(ctx, `SELECT permission FROM node_share WHERE id_node=$1 AND id_user=$2`, node.IdNode, currentUser.IdUser).Scan(&shared)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return shared == entities.ShareWrite || shared == permission, nil
+}
+
+// HasSensorAccess is true when the user has the permission on the node of the sensor, see HasAccess
+func (u *NodeRepository) HasSensorAccess(ctx context.Context, tx helper.Querier, sensorId int, currentUser *entities.UserRead, permission string) (bool, error) {
+	var node entities.Node
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM "node" WHERE deleted_at IS NULL AND id_node=(SELECT id_node FROM "sensor" WHERE id_sensor=$1 AND deleted_at IS NULL)`, u.nodeField())
+	err := tx.QueryRow(ctx, sqlStatement, sensorId).Scan(
+		u.nodePointer(&node)...,
+	)
+	if err == pgx.ErrNoRows {
+		return false, fiber.NewError(404, fmt.Sprintf("Sensor with id %d not found", sensorId))
+	}
+	if err != nil {
+		return false, err
+	}
+	return u.HasAccess(ctx, tx, node, currentUser, permission)
+}
+
+// GetShares return the user the node is shared with
+func (u *NodeRepository) GetShares(ctx context.Context, tx helper.Querier, nodeId int) (shares []entities.NodeShare, err error) {
+	shares = []entities.NodeShare{}
+	sqlStatement := `
+	SELECT node_share.id_node, node_share.id_user, user_person.username, node_share.permission, node_share.created_at
+	FROM node_share INNER JOIN user_person ON user_person.id_user=node_share.id_user
+	WHERE node_share.id_node=$1 ORDER BY user_person.username`
+	rows, err := tx.Query(ctx, sqlStatement, nodeId)
+	if err != nil {
+		return shares, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var share entities.NodeShare
+		err := rows.Scan(&share.IdNode, &share.IdUser, &share.Username, &share.Permission, &share.CreatedAt)
+		if err != nil {
+			return shares, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, rows.Err()
+}
+
+// Share the node with the user of the username, the owner can't share the node with themself
+func (u *NodeRepository) Share(ctx context.Context, tx helper.Querier, node entities.Node, payload *entities.NodeShareCreate) (share entities.NodeShare, err error) {
+	share = entities.NodeShare{IdNode: node.IdNode, Username: payload.Username, Permission: payload.Permission}
+	err = tx.QueryRow(ctx, `SELECT id_user FROM user_person WHERE username=$1`, payload.Username).Scan(&share.IdUser)
+	if err == pgx.ErrNoRows {
+		return share, fiber.NewError(404, fmt.Sprintf("User %s not found", payload.Username))
+	}
+	if err != nil {
+		return share, err
+	}
+	if share.IdUser == node.IdUser {
+		return share, fiber.NewError(400, "The node can't be shared with its owner")
+	}
+
+	sqlStatement := `
+	INSERT INTO node_share (id_node, id_user, permission) VALUES ($1, $2, $3)
+	ON CONFLICT (id_node, id_user) DO UPDATE SET permission=EXCLUDED.permission
+	RETURNING created_at`
+	err = tx.QueryRow(ctx, sqlStatement, node.IdNode, share.IdUser, share.Permission).Scan(&share.CreatedAt)
+	return share, err
+}
+
+// Unshare remove the access of the user to the node
+func (u *NodeRepository) Unshare(ctx context.Context, tx helper.Querier, nodeId int, userId int) error {
+	tag, err := tx.Exec(ctx, `DELETE FROM node_share WHERE id_node=$1 AND id_user=$2`, nodeId, userId)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fiber.NewError(404, fmt.Sprintf("Node %d is not shared with user %d", nodeId, userId))
+	}
+	return nil
+}
+
+// GetAllActivity return the sensor count and last channel time of every node visible to the user
+func (u *NodeRepository) GetAllActivity(ctx context.Context, tx helper.Querier, currentUser *entities.UserRead) (activities map[int]entities.NodeActivity, err error) {
+	activities = map[int]entities.NodeActivity{}
+	sqlStatement := `
+	SELECT n.id_node, COUNT(s.id_sensor), GREATEST(n.last_seen, MAX(c.time))
+	FROM "node" n
+	LEFT JOIN sensor s ON s.id_node = n.id_node AND s.deleted_at IS NULL
+	LEFT JOIN LATERAL (
+		SELECT time FROM channel WHERE id_sensor = s.id_sensor ORDER BY time DESC LIMIT 1
+	) c ON TRUE
+	WHERE n.deleted_at IS NULL AND ($1 OR n.id_user = $2 OR n.id_node IN (SELECT id_node FROM node_share WHERE node_share.id_user = $2))
+	GROUP BY n.id_node`
+	rows, err := tx.Query(ctx, sqlStatement, currentUser.IsAdmin, currentUser.IdUser)
+	if err != nil {
+		return activities, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var activity entities.NodeActivity
+		err := rows.Scan(&activity.IdNode, &activity.SensorCount, &activity.LastSeen)
+		if err != nil {
+			return activities, err
+		}
+		activities[activity.IdNode] = activity
+	}
+	if err := rows.Err(); err != nil {
+		return activities, err
+	}
+	return activities, nil
+}
+
+// Seen mark the node online with its last seen time, a time older than the kept one is ignored
+func (u *NodeRepository) Seen(ctx context.Context, tx helper.Querier, nodeId int, now time.Time) error {
+	sqlStatement := `UPDATE "node" SET last_seen=GREATEST(last_seen, $2), online=TRUE WHERE id_node=$1 AND deleted_at IS NULL`
+	tag, err := tx.Exec(ctx, sqlStatement, nodeId, now)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fiber.NewError(404, fmt.Sprintf("Node with id %d not found", nodeId))
+	}
+	return nil
+}
+
+// SeenBySensor mark the node of the sensor online, for a channel of the sensor
+func (u *NodeRepository) SeenBySensor(ctx context.Context, tx helper.Querier, sensorId int, now time.Time) error {
+	sqlStatement := `
+	UPDATE "node" SET last_seen=GREATEST(last_seen, $2), online=TRUE
+	WHERE id_node=(SELECT id_node FROM sensor WHERE id_sensor=$1) AND (NOT online OR last_seen IS NULL OR last_seen < $2)`
+	_, err := tx.Exec(ctx, sqlStatement, sensorId, now)
+	return err
+}
+
+// MarkOffline clear the online flag of the node not seen since the time, and return their count
+func (u *NodeRepository) MarkOffline(ctx context.Context, tx helper.Querier, since time.Time) (count int64, err error) {
+	sqlStatement := `UPDATE "node" SET online=FALSE WHERE online AND (last_seen IS NULL OR last_seen < $1) AND deleted_at IS NULL`
+	tag, err := tx.Exec(ctx, sqlStatement, since)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
 func (u *NodeRepository) GetHardwareNode(ctx context.Context, tx helper.Querier, hardwareId int) ([]entities.Node, error) {
 	nodes := []entities.Node{}
-	sqlStatement := fmt.Sprintf(`SELECT %s FROM "node" WHERE id_hardware=$1`, u.nodeField())
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM "node" WHERE id_hardware=$1 AND deleted_at IS NULL`, u.nodeField())
 	rows, err := tx.Query(ctx, sqlStatement, hardwareId)
 	if err != nil {
 		return nodes, err
@@ -140,15 +317,73 @@ func (u *NodeRepository) Update(ctx context.Context, tx helper.Querier, node *en
 	return nil
 }
 
-func (u *NodeRepository) Delete(ctx context.Context, tx helper.Querier, id int) (err error) {
-	sqlStatement := `DELETE FROM "node" WHERE id_node=$1`
-	res, err := tx.Exec(ctx, sqlStatement, id)
+// UpdateOwner give the node to another user, the dashboard of another user stop being the node dashboard
+func (u *NodeRepository) UpdateOwner(ctx context.Context, tx helper.Querier, id int, userId int) (err error) {
+	res, err := tx.Exec(ctx, `UPDATE "node" SET id_user=$2 WHERE id_node=$1`, id, userId)
 	if err != nil {
 		return err
 	}
-	count := res.RowsAffected()
-	if count == 0 {
+	if res.RowsAffected() == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on update node with id %d", id))
+	}
+	_, err = tx.Exec(ctx, `UPDATE dashboard SET id_node=NULL WHERE id_node=$1 AND id_user<>$2`, id, userId)
+	return err
+}
+
+// Delete soft delete the node with its sensor, they are hidden but their channel is kept until restored
+func (u *NodeRepository) Delete(ctx context.Context, tx helper.Querier, id int) (err error) {
+	var deletedAt time.Time
+	sqlStatement := `UPDATE "node" SET deleted_at=NOW() WHERE id_node=$1 AND deleted_at IS NULL RETURNING deleted_at`
+	err = tx.QueryRow(ctx, sqlStatement, id).Scan(&deletedAt)
+	if err == pgx.ErrNoRows {
 		return fiber.NewError(404, fmt.Sprintf("No row affected on delete with id %d", id))
 	}
-	return nil
+	if err != nil {
+		return err
+	}
+
+	// The sensor get the same time as the node, so the restore of the node only bring back these sensor
+	_, err = tx.Exec(ctx, `UPDATE "sensor" SET deleted_at=$2 WHERE id_node=$1 AND deleted_at IS NULL`, id, deletedAt)
+	return err
+}
+
+// GetDeleted return the deleted node of every user, latest deleted first
+func (u *NodeRepository) GetDeleted(ctx context.Context, tx helper.Querier) (nodes []entities.DeletedNode, err error) {
+	nodes = []entities.DeletedNode{}
+	sqlStatement := fmt.Sprintf(`SELECT %s, deleted_at FROM "node" WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC, id_node`, u.nodeField())
+	rows, err := tx.Query(ctx, sqlStatement)
+	if err != nil {
+		return nodes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var node entities.DeletedNode
+		err := rows.Scan(append(u.nodePointer(&node.Node), &node.DeletedAt)...)
+		if err != nil {
+			return nodes, err
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, rows.Err()
+}
+
+// Restore bring back the deleted node with the sensor deleted with it, a sensor deleted before the node stay deleted
+func (u *NodeRepository) Restore(ctx context.Context, tx helper.Querier, id int) (node entities.Node, err error) {
+	var deletedAt time.Time
+	sqlStatement := fmt.Sprintf(`SELECT %s, deleted_at FROM "node" WHERE id_node=$1 AND deleted_at IS NOT NULL FOR UPDATE`, u.nodeField())
+	err = tx.QueryRow(ctx, sqlStatement, id).Scan(append(u.nodePointer(&node), &deletedAt)...)
+	if err == pgx.ErrNoRows {
+		return node, fiber.NewError(404, fmt.Sprintf("Deleted node with id %d not found", id))
+	}
+	if err != nil {
+		return node, err
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE "node" SET deleted_at=NULL WHERE id_node=$1`, id)
+	if err != nil {
+		return node, err
+	}
+	_, err = tx.Exec(ctx, `UPDATE "sensor" SET deleted_at=NULL WHERE id_node=$1 AND deleted_at=$2`, id, deletedAt)
+	return node, err
 }
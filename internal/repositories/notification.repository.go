@@ -0,0 +1,161 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+)
+
+type NotificationRepository struct{}
+
+func NewNotificationRepository() (NotificationRepository, error) {
+	return NotificationRepository{}, nil
+}
+
+func (n *NotificationRepository) notificationField() string {
+	return "id_notification, id_user, type, title, message, link, is_read, created_at"
+}
+
+func (n *NotificationRepository) notificationPointer(notification *entities.Notification) []interface{} {
+	return []interface{}{&notification.IdNotification, &notification.IdUser, &notification.Type, &notification.Title, &notification.Message, &notification.Link, &notification.IsRead, &notification.CreatedAt}
+}
+
+func (n *NotificationRepository) Create(ctx context.Context, tx helper.Querier, idUser int, payload *entities.NotificationCreate) (notification entities.Notification, err error) {
+	sqlStatement := fmt.Sprintf(`
+	INSERT INTO notification (id_user, type, title, message, link)
+	VALUES ($1, $2, $3, $4, $5) RETURNING %s`, n.notificationField())
+	err = tx.QueryRow(ctx, sqlStatement, idUser, payload.Type, payload.Title, payload.Message, payload.Link).Scan(
+		n.notificationPointer(&notification)...,
+	)
+	if err != nil {
+		return notification, err
+	}
+	return notification, nil
+}
+
+// Broadcast send a system notification to every user and return the number of recipient
+func (n *NotificationRepository) Broadcast(ctx context.Context, tx helper.Querier, payload *entities.NotificationBroadcast) (count int64, err error) {
+	sqlStatement := `
+	INSERT INTO notification (id_user, type, title, message, link)
+	SELECT id_user, 'system', $1, $2, $3 FROM user_person`
+	res, err := tx.Exec(ctx, sqlStatement, payload.Title, payload.Message, payload.Link)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), nil
+}
+
+// NotifyAdmins send the notification to every admin and return the number of recipient
+func (n *NotificationRepository) NotifyAdmins(ctx context.Context, tx helper.Querier, payload *entities.NotificationCreate) (count int64, err error) {
+	sqlStatement := `
+	INSERT INTO notification (id_user, type, title, message, link)
+	SELECT id_user, $1, $2, $3, $4 FROM user_person WHERE isadmin`
+	res, err := tx.Exec(ctx, sqlStatement, payload.Type, payload.Title, payload.Message, payload.Link)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), nil
+}
+
+func (n *NotificationRepository) GetAll(ctx context.Context, tx helper.Querier, idUser int, query *entities.NotificationQuery) (notifications []entities.Notification, err error) {
+	notifications = []entities.Notification{}
+	limit := query.Limit
+	if limit == 0 {
+		limit = 50
+	}
+
+	sqlStatement := fmt.Sprintf(`
+	SELECT %s FROM notification
+	WHERE id_user=$1 AND (NOT $2 OR NOT is_read)
+	ORDER BY created_at DESC, id_notification DESC
+	LIMIT $3`, n.notificationField())
+	rows, err := tx.Query(ctx, sqlStatement, idUser, query.Unread, limit)
+	if err != nil {
+		return notifications, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var notification entities.Notification
+		err := rows.Scan(n.notificationPointer(&notification)...)
+		if err != nil {
+			return notifications, err
+		}
+		notifications = append(notifications, notification)
+	}
+	if err := rows.Err(); err != nil {
+		return notifications, err
+	}
+	return notifications, nil
+}
+
+func (n *NotificationRepository) CountUnread(ctx context.Context, tx helper.Querier, idUser int) (count int, err error) {
+	sqlStatement := `SELECT COUNT(*) FROM notification WHERE id_user=$1 AND NOT is_read`
+	err = tx.QueryRow(ctx, sqlStatement, idUser).Scan(&count)
+	return count, err
+}
+
+// MarkRead only update the notification of the user, other user's notification is reported as not found
+func (n *NotificationRepository) MarkRead(ctx context.Context, tx helper.Querier, idUser int, id int) (err error) {
+	sqlStatement := `
+	UPDATE notification
+	SET is_read=TRUE
+	WHERE id_notification=$1 AND id_user=$2`
+	res, err := tx.Exec(ctx, sqlStatement, id, idUser)
+	if err != nil {
+		return err
+	}
+	count := res.RowsAffected()
+	if count == 0 {
+		return fiber.NewError(404, fmt.Sprintf("Notification with id %d not found", id))
+	}
+	return nil
+}
+
+func (n *NotificationRepository) MarkAllRead(ctx context.Context, tx helper.Querier, idUser int) (count int64, err error) {
+	sqlStatement := `
+	UPDATE notification
+	SET is_read=TRUE
+	WHERE id_user=$1 AND NOT is_read`
+	res, err := tx.Exec(ctx, sqlStatement, idUser)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), nil
+}
+
+func (n *NotificationRepository) Delete(ctx context.Context, tx helper.Querier, idUser int, id int) (err error) {
+	sqlStatement := `DELETE FROM notification WHERE id_notification=$1 AND id_user=$2`
+	res, err := tx.Exec(ctx, sqlStatement, id, idUser)
+	if err != nil {
+		return err
+	}
+	count := res.RowsAffected()
+	if count == 0 {
+		return fiber.NewError(404, fmt.Sprintf("Notification with id %d not found", id))
+	}
+	return nil
+}
+
+// DeleteExpired delete the read notification older than retentionDays and every
+// notification beyond the newest maxPerUser of each user
+func (n *NotificationRepository) DeleteExpired(ctx context.Context, tx helper.Querier, retentionDays int, maxPerUser int) (count int64, err error) {
+	sqlStatement := `
+	DELETE FROM notification
+	WHERE (is_read AND created_at < NOW() - make_interval(days => $1))
+	OR id_notification IN (
+		SELECT id_notification FROM (
+			SELECT id_notification, ROW_NUMBER() OVER (PARTITION BY id_user ORDER BY created_at DESC, id_notification DESC) AS position
+			FROM notification
+		) ranked
+		WHERE position > $2
+	)`
+	res, err := tx.Exec(ctx, sqlStatement, retentionDays, maxPerUser)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), nil
+}
@@ -0,0 +1,89 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+)
+
+// OpcuaRepository keep the OPC-UA node mapped to each sensor channel
+type OpcuaRepository struct{}
+
+func NewOpcuaRepository() (OpcuaRepository, error) {
+	return OpcuaRepository{}, nil
+}
+
+// GetBySensor return the item of the sensor ordered by channel name
+func (r *OpcuaRepository) GetBySensor(ctx context.Context, tx helper.Querier, sensorId int) (items []entities.SensorOpcuaItem, err error) {
+	items = []entities.SensorOpcuaItem{}
+	rows, err := tx.Query(ctx, `SELECT name, node_id, status FROM sensor_opcua WHERE id_sensor=$1 ORDER BY name`, sensorId)
+	if err != nil {
+		return items, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := entities.SensorOpcuaItem{}
+		err = rows.Scan(&item.Name, &item.NodeId, &item.Status)
+		if err != nil {
+			return items, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// Update replace the item of the sensor. An item keep its id and status when its node is unchanged,
+// so the subscriber doesn't monitor it again
+func (r *OpcuaRepository) Update(ctx context.Context, tx helper.Querier, sensorId int, payload *entities.SensorOpcuaUpdate) error {
+	names := make([]string, 0, len(payload.Items))
+	for _, item := range payload.Items {
+		names = append(names, item.Name)
+	}
+	_, err := tx.Exec(ctx, `DELETE FROM sensor_opcua WHERE id_sensor=$1 AND NOT (name = ANY($2))`, sensorId, names)
+	if err != nil {
+		return err
+	}
+
+	sqlStatement := `
+	INSERT INTO sensor_opcua (id_sensor, name, node_id, status) VALUES ($1, $2, $3, $4)
+	ON CONFLICT (id_sensor, name) DO UPDATE SET node_id=EXCLUDED.node_id,
+		status=CASE WHEN sensor_opcua.node_id=EXCLUDED.node_id THEN sensor_opcua.status ELSE EXCLUDED.status END`
+	for _, item := range payload.Items {
+		_, err = tx.Exec(ctx, sqlStatement, sensorId, item.Name, item.NodeId, entities.OpcuaPending)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetAll return every item with the owner of its sensor, for the subscriber
+func (r *OpcuaRepository) GetAll(ctx context.Context, tx helper.Querier) (items []entities.OpcuaItem, err error) {
+	sqlStatement := `
+	SELECT o.id_item, o.id_sensor, n.id_user, o.name, o.node_id, o.status
+	FROM sensor_opcua o JOIN sensor s ON s.id_sensor=o.id_sensor JOIN node n ON n.id_node=s.id_node
+	WHERE s.deleted_at IS NULL AND n.deleted_at IS NULL`
+	rows, err := tx.Query(ctx, sqlStatement)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := entities.OpcuaItem{}
+		err = rows.Scan(&item.IdItem, &item.IdSensor, &item.IdUser, &item.Name, &item.NodeId, &item.Status)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// SetStatus record whether the subscriber monitor the item
+func (r *OpcuaRepository) SetStatus(ctx context.Context, tx helper.Querier, itemId int, status string) error {
+	_, err := tx.Exec(ctx, `UPDATE sensor_opcua SET status=$1 WHERE id_item=$2`, status, itemId)
+	return err
+}
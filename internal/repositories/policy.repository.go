@@ -0,0 +1,246 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+// StoragePolicyRepository keep the storage policy and apply them to the channel of their sensor
+type StoragePolicyRepository struct {
+	channelRepository *ChannelRepository
+	rollupRepository  *RollupRepository
+}
+
+func NewStoragePolicyRepository(channelRepository *ChannelRepository, rollupRepository *RollupRepository) (StoragePolicyRepository, error) {
+	return StoragePolicyRepository{
+		channelRepository: channelRepository,
+		rollupRepository:  rollupRepository,
+	}, nil
+}
+
+func (r *StoragePolicyRepository) policyField() string {
+	return "id_policy, name, id_sensor, tag, raw_days, rollup_seconds, rollup_days"
+}
+
+func (r *StoragePolicyRepository) policyPointer(policy *entities.StoragePolicy) []interface{} {
+	return []interface{}{&policy.IdPolicy, &policy.Name, &policy.IdSensor, &policy.Tag, &policy.RawDays, &policy.RollupSeconds, &policy.RollupDays}
+}
+
+func (r *StoragePolicyRepository) GetAll(ctx context.Context, tx helper.Querier) (policies []entities.StoragePolicy, err error) {
+	policies = []entities.StoragePolicy{}
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM storage_policy ORDER BY id_policy`, r.policyField())
+	rows, err := tx.Query(ctx, sqlStatement)
+	if err != nil {
+		return policies, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var policy entities.StoragePolicy
+		err := rows.Scan(r.policyPointer(&policy)...)
+		if err != nil {
+			return policies, err
+		}
+		policies = append(policies, policy)
+	}
+	return policies, rows.Err()
+}
+
+func (r *StoragePolicyRepository) GetById(ctx context.Context, tx helper.Querier, id int) (policy entities.StoragePolicy, err error) {
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM storage_policy WHERE id_policy=$1`, r.policyField())
+	err = tx.QueryRow(ctx, sqlStatement, id).Scan(r.policyPointer(&policy)...)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return policy, fiber.NewError(404, fmt.Sprintf("Storage policy with id %d not found", id))
+		}
+		return policy, err
+	}
+	return policy, nil
+}
+
+func (r *StoragePolicyRepository) Create(ctx context.Context, tx helper.Querier, payload *entities.StoragePolicyCreate) (policy entities.StoragePolicy, err error) {
+	policy = entities.StoragePolicy{StoragePolicyCreate: *payload}
+	sqlStatement := `
+	INSERT INTO storage_policy (name, id_sensor, tag, raw_days, rollup_seconds, rollup_days)
+	VALUES ($1, $2, $3, $4, $5, $6) RETURNING id_policy`
+	err = tx.QueryRow(ctx, sqlStatement, payload.Name, payload.IdSensor, payload.Tag, payload.RawDays, payload.RollupSeconds, payload.RollupDays).Scan(&policy.IdPolicy)
+	return policy, err
+}
+
+func (r *StoragePolicyRepository) Update(ctx context.Context, tx helper.Querier, id int, payload *entities.StoragePolicyCreate) (err error) {
+	sqlStatement := `
+	UPDATE storage_policy
+	SET name=$1, id_sensor=$2, tag=$3, raw_days=$4, rollup_seconds=$5, rollup_days=$6
+	WHERE id_policy=$7`
+	res, err := tx.Exec(ctx, sqlStatement, payload.Name, payload.IdSensor, payload.Tag, payload.RawDays, payload.RollupSeconds, payload.RollupDays, id)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on update storage policy with id %d", id))
+	}
+	return nil
+}
+
+func (r *StoragePolicyRepository) Delete(ctx context.Context, tx helper.Querier, id int) (err error) {
+	res, err := tx.Exec(ctx, `DELETE FROM storage_policy WHERE id_policy=$1`, id)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on delete storage policy with id %d", id))
+	}
+	return nil
+}
+
+// GetSensorIds return the sensor each policy apply to, keyed by policy id. The sensor policy win
+// over a tag policy, and the oldest policy win between tag policies
+func (r *StoragePolicyRepository) GetSensorIds(ctx context.Context, tx helper.Querier) (sensorIds map[int][]int, err error) {
+	sensorIds = map[int][]int{}
+	sqlStatement := `
+	SELECT id_policy, id_sensor FROM (
+		SELECT s.id_sensor, COALESCE(
+			(SELECT p.id_policy FROM storage_policy p WHERE p.id_sensor = s.id_sensor ORDER BY p.id_policy LIMIT 1),
+			(SELECT p.id_policy FROM storage_policy p JOIN sensor_tag t ON t.tag = p.tag WHERE t.id_sensor = s.id_sensor ORDER BY p.id_policy LIMIT 1)
+		) AS id_policy
+		FROM sensor s
+	) assignment
+	WHERE id_policy IS NOT NULL
+	ORDER BY id_policy, id_sensor`
+	rows, err := tx.Query(ctx, sqlStatement)
+	if err != nil {
+		return sensorIds, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var idPolicy, idSensor int
+		err := rows.Scan(&idPolicy, &idSensor)
+		if err != nil {
+			return sensorIds, err
+		}
+		sensorIds[idPolicy] = append(sensorIds[idPolicy], idSensor)
+	}
+	return sensorIds, rows.Err()
+}
+
+// GetRuns return the latest run audit of the policy, newest first
+func (r *StoragePolicyRepository) GetRuns(ctx context.Context, tx helper.Querier, id int, limit int) (runs []entities.StoragePolicyRun, err error) {
+	runs = []entities.StoragePolicyRun{}
+	sqlStatement := `
+	SELECT id_run, id_policy, started_at, finished_at, sensors, rolled_up, raw_deleted, rollup_deleted, status, message
+	FROM storage_policy_run WHERE id_policy=$1
+	ORDER BY started_at DESC, id_run DESC LIMIT $2`
+	rows, err := tx.Query(ctx, sqlStatement, id, limit)
+	if err != nil {
+		return runs, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var run entities.StoragePolicyRun
+		err := rows.Scan(&run.IdRun, &run.IdPolicy, &run.StartedAt, &run.FinishedAt, &run.Sensors, &run.RolledUp, &run.RawDeleted, &run.RollupDeleted, &run.Status, &run.Message)
+		if err != nil {
+			return runs, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+func (r *StoragePolicyRepository) createRun(ctx context.Context, tx helper.Querier, run *entities.StoragePolicyRun) error {
+	sqlStatement := `
+	INSERT INTO storage_policy_run (id_policy, started_at, finished_at, sensors, rolled_up, raw_deleted, rollup_deleted, status, message)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id_run`
+	return tx.QueryRow(ctx, sqlStatement, run.IdPolicy, run.StartedAt, run.FinishedAt, run.Sensors, run.RolledUp, run.RawDeleted, run.RollupDeleted, run.Status, run.Message).Scan(&run.IdRun)
+}
+
+// Apply run every policy on its sensor with the age counted in whole days before today, and record
+// the audit of each policy. A failing sensor stop its policy, the other policies still run and the
+// first error is returned
+func (r *StoragePolicyRepository) Apply(ctx context.Context, db helper.Querier, today time.Time) (runs []entities.StoragePolicyRun, err error) {
+	runs = []entities.StoragePolicyRun{}
+	policies, err := r.GetAll(ctx, db)
+	if err != nil {
+		return runs, err
+	}
+	sensorIds, err := r.GetSensorIds(ctx, db)
+	if err != nil {
+		return runs, err
+	}
+
+	var firstErr error
+	for _, policy := range policies {
+		run := entities.StoragePolicyRun{IdPolicy: policy.IdPolicy, StartedAt: time.Now().UTC(), Status: "success"}
+		for _, sensorId := range sensorIds[policy.IdPolicy] {
+			rolledUp, rawDeleted, rollupDeleted, err := r.applySensor(ctx, db, policy, sensorId, today)
+			if err != nil {
+				run.Status = "error"
+				run.Message = fmt.Sprintf("sensor %d: %v", sensorId, err)
+				if firstErr == nil {
+					firstErr = fmt.Errorf("storage policy %d %s", policy.IdPolicy, run.Message)
+				}
+				break
+			}
+			run.Sensors++
+			run.RolledUp += rolledUp
+			run.RawDeleted += rawDeleted
+			run.RollupDeleted += rollupDeleted
+		}
+		run.FinishedAt = time.Now().UTC()
+
+		err = r.createRun(ctx, db, &run)
+		if err != nil {
+			return runs, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, firstErr
+}
+
+// applySensor roll up the good unnamed channel and delete every raw channel older than the policy raw days,
+// then delete the rollup older than its rollup days, in one transaction
+func (r *StoragePolicyRepository) applySensor(ctx context.Context, db helper.Querier, policy entities.StoragePolicy, sensorId int, today time.Time) (rolledUp int64, rawDeleted int64, rollupDeleted int64, err error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	rawBefore := today.AddDate(0, 0, -policy.RawDays)
+	if policy.RollupSeconds > 0 {
+		builder := rollupBuilder{resolution: time.Duration(policy.RollupSeconds) * time.Second}
+		unnamed := ""
+		err = r.channelRepository.ForEachRawBySensor(ctx, tx, sensorId, entities.ChannelQuery{To: &rawBefore, Quality: []string{entities.QualityGood}, Name: &unnamed}, builder.add)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		builder.finish()
+
+		err = r.rollupRepository.Add(ctx, tx, sensorId, policy.RollupSeconds, builder.rollups)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		rolledUp = int64(len(builder.rollups))
+	}
+
+	rawDeleted, err = r.channelRepository.DeleteRawBefore(ctx, tx, sensorId, rawBefore)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	if policy.RollupDays > 0 {
+		rollupDeleted, err = r.rollupRepository.DeleteBefore(ctx, tx, sensorId, today.AddDate(0, 0, -policy.RollupDays))
+		if err != nil {
+			return 0, 0, 0, err
+		}
+	}
+
+	return rolledUp, rawDeleted, rollupDeleted, tx.Commit(ctx)
+}
@@ -0,0 +1,407 @@
+package repositories
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/chart"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/dafaath/iot-server/internal/pdf"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	defaultReportRange      = 24 * time.Hour
+	maxReportRange          = 366 * 24 * time.Hour
+	defaultReportAlertLimit = 20
+	reportChartWidth        = 800
+	reportChartHeight       = 300
+)
+
+//go:embed templates/report.html
+var reportTemplateText string
+
+var reportTemplate = template.Must(template.New("report").Funcs(templateFuncs).Funcs(template.FuncMap{
+	"svg": func(image chart.Chart) (template.HTML, error) {
+		var buffer bytes.Buffer
+		err := image.SVG(&buffer)
+		return template.HTML(buffer.String()), err
+	},
+	"quality": reportQuality,
+	"paragraphs": func(text string) []string {
+		return strings.Split(text, "\n")
+	},
+}).Parse(reportTemplateText))
+
+// reportDocument is the report with the data of each section in [From, To)
+type reportDocument struct {
+	Report   entities.Report
+	From     time.Time
+	To       time.Time
+	Sections []reportSection
+}
+
+type reportSection struct {
+	entities.ReportSection
+	Charts []chart.Chart
+	Stats  []entities.SensorSummary
+	Alerts []reportAlert
+}
+
+type reportAlert struct {
+	Sensor  string
+	Unit    string
+	Channel entities.Channel
+}
+
+func reportQuality(quality string) string {
+	if quality == "" {
+		return "good"
+	}
+	return quality
+}
+
+// ReportRepository keep the report definition of the user and render them
+type ReportRepository struct {
+	channelRepository   *ChannelRepository
+	sensorRepository    *SensorRepository
+	dashboardRepository *DashboardRepository
+	userRepository      *UserRepository
+}
+
+func NewReportRepository(channelRepository *ChannelRepository, sensorRepository *SensorRepository, dashboardRepository *DashboardRepository, userRepository *UserRepository) (ReportRepository, error) {
+	return ReportRepository{
+		channelRepository:   channelRepository,
+		sensorRepository:    sensorRepository,
+		dashboardRepository: dashboardRepository,
+		userRepository:      userRepository,
+	}, nil
+}
+
+func (r *ReportRepository) reportField() string {
+	return "id_report, id_user, name, time_range, schedule, format, sections, last_sent"
+}
+
+func (r *ReportRepository) reportPointer(report *entities.Report) []interface{} {
+	return []interface{}{&report.IdReport, &report.IdUser, &report.Name, &report.Range, &report.Schedule, &report.Format, &report.Sections, &report.LastSent}
+}
+
+func (r *ReportRepository) query(ctx context.Context, tx helper.Querier, sqlStatement string, args ...interface{}) (reports []entities.Report, err error) {
+	reports = []entities.Report{}
+	rows, err := tx.Query(ctx, sqlStatement, args...)
+	if err != nil {
+		return reports, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var report entities.Report
+		err := rows.Scan(r.reportPointer(&report)...)
+		if err != nil {
+			return reports, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, rows.Err()
+}
+
+// GetAll return the report of the user, of every user when idUser is 0
+func (r *ReportRepository) GetAll(ctx context.Context, tx helper.Querier, idUser int) (reports []entities.Report, err error) {
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM report WHERE $1=0 OR id_user=$1 ORDER BY id_report`, r.reportField())
+	return r.query(ctx, tx, sqlStatement, idUser)
+}
+
+// GetDue return the scheduled report to send at the midnight of now, the weekly report on monday and
+// the monthly report on the first day of the month
+func (r *ReportRepository) GetDue(ctx context.Context, tx helper.Querier, now time.Time) (reports []entities.Report, err error) {
+	now = now.UTC()
+	schedules := []string{"daily"}
+	if now.Weekday() == time.Monday {
+		schedules = append(schedules, "weekly")
+	}
+	if now.Day() == 1 {
+		schedules = append(schedules, "monthly")
+	}
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM report WHERE schedule=ANY($1) ORDER BY id_report`, r.reportField())
+	return r.query(ctx, tx, sqlStatement, schedules)
+}
+
+func (r *ReportRepository) GetById(ctx context.Context, tx helper.Querier, id int) (report entities.Report, err error) {
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM report WHERE id_report=$1`, r.reportField())
+	err = tx.QueryRow(ctx, sqlStatement, id).Scan(r.reportPointer(&report)...)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return report, fiber.NewError(404, fmt.Sprintf("Report with id %d not found", id))
+		}
+		return report, err
+	}
+	return report, nil
+}
+
+func (r *ReportRepository) Create(ctx context.Context, tx helper.Querier, idUser int, payload *entities.ReportCreate) (report entities.Report, err error) {
+	report = entities.Report{IdUser: idUser, ReportCreate: *payload}
+	sqlStatement := `
+	INSERT INTO report (id_user, name, time_range, schedule, format, sections)
+	VALUES ($1, $2, $3, $4, $5, $6) RETURNING id_report`
+	err = tx.QueryRow(ctx, sqlStatement, idUser, report.Name, report.Range, report.Schedule, report.Format, report.Sections).Scan(&report.IdReport)
+	return report, err
+}
+
+func (r *ReportRepository) Update(ctx context.Context, tx helper.Querier, id int, payload *entities.ReportCreate) (err error) {
+	sqlStatement := `
+	UPDATE report
+	SET name=$1, time_range=$2, schedule=$3, format=$4, sections=$5
+	WHERE id_report=$6`
+	res, err := tx.Exec(ctx, sqlStatement, payload.Name, payload.Range, payload.Schedule, payload.Format, payload.Sections, id)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on update report with id %d", id))
+	}
+	return nil
+}
+
+func (r *ReportRepository) SetLastSent(ctx context.Context, tx helper.Querier, id int, lastSent time.Time) (err error) {
+	_, err = tx.Exec(ctx, `UPDATE report SET last_sent=$1 WHERE id_report=$2`, lastSent, id)
+	return err
+}
+
+func (r *ReportRepository) Delete(ctx context.Context, tx helper.Querier, id int) (err error) {
+	res, err := tx.Exec(ctx, `DELETE FROM report WHERE id_report=$1`, id)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on delete report with id %d", id))
+	}
+	return nil
+}
+
+// From return the start of the report ending at to. Without range the daily report show a day, the
+// weekly report a week, and the monthly report the previous month
+func (r *ReportRepository) From(report *entities.ReportCreate, to time.Time) (from time.Time, err error) {
+	if report.Range == "" {
+		switch report.Schedule {
+		case "weekly":
+			return to.AddDate(0, 0, -7), nil
+		case "monthly":
+			return to.AddDate(0, -1, 0), nil
+		default:
+			return to.Add(-defaultReportRange), nil
+		}
+	}
+	duration, err := time.ParseDuration(report.Range)
+	if err != nil || duration <= 0 || duration > maxReportRange {
+		return from, fiber.NewError(400, fmt.Sprintf("Invalid range %s, use duration like 24h or 168h up to %s", report.Range, maxReportRange))
+	}
+	return to.Add(-duration), nil
+}
+
+// build read the data of each section in [from, to), a sensor the owner doesn't have anymore is left out
+func (r *ReportRepository) build(ctx context.Context, tx helper.Querier, report entities.Report, owner entities.UserRead, from time.Time, to time.Time) (document reportDocument, err error) {
+	document = reportDocument{Report: report, From: from, To: to, Sections: []reportSection{}}
+	sensors := map[int]entities.Sensor{}
+	ownSensors, err := r.sensorRepository.GetAll(ctx, tx, &owner, &entities.SensorQuery{})
+	if err != nil {
+		return document, err
+	}
+	for _, sensor := range ownSensors {
+		sensors[sensor.IdSensor] = sensor
+	}
+
+	for _, payload := range report.Sections {
+		section := reportSection{ReportSection: payload}
+		sectionSensors := []entities.Sensor{}
+		sensorIds := []int{}
+		for _, id := range payload.Sensors {
+			if sensor, ok := sensors[id]; ok {
+				sectionSensors = append(sectionSensors, sensor)
+				sensorIds = append(sensorIds, id)
+			}
+		}
+
+		switch payload.Type {
+		case entities.ReportSectionChart:
+			for _, sensor := range sectionSensors {
+				image, err := r.buildChart(ctx, tx, sensor, from, to)
+				if err != nil {
+					return document, err
+				}
+				section.Charts = append(section.Charts, image)
+			}
+		case entities.ReportSectionStats:
+			alertWidgets, err := r.dashboardRepository.GetAlertWidgetsBySensors(ctx, tx, sensorIds)
+			if err != nil {
+				return document, err
+			}
+			for _, sensor := range sectionSensors {
+				summary, err := r.channelRepository.Summarize(ctx, tx, sensor, from, to)
+				if err != nil {
+					return document, err
+				}
+				for _, widget := range alertWidgets[sensor.IdSensor] {
+					count, err := r.channelRepository.CountOutOfRangeBySensor(ctx, tx, sensor.IdSensor, widget.Options.Min, widget.Options.Max, from, to)
+					if err != nil {
+						return document, err
+					}
+					summary.Alerts += count
+				}
+				section.Stats = append(section.Stats, summary)
+			}
+		case entities.ReportSectionAlerts:
+			section.Alerts, err = r.buildAlerts(ctx, tx, payload, sectionSensors, sensorIds, from, to)
+			if err != nil {
+				return document, err
+			}
+		}
+		document.Sections = append(document.Sections, section)
+	}
+	return document, nil
+}
+
+// buildChart resample the channel to around one point per two pixel, like the sensor chart
+func (r *ReportRepository) buildChart(ctx context.Context, tx helper.Querier, sensor entities.Sensor, from time.Time, to time.Time) (image chart.Chart, err error) {
+	image = chart.Chart{Width: reportChartWidth, Height: reportChartHeight, From: from, To: to, Title: sensor.Name}
+	if sensor.Unit != "" {
+		image.Title = fmt.Sprintf("%s (%s)", sensor.Name, sensor.Unit)
+	}
+	query := entities.ChannelQuery{From: &from, To: &to}
+	interval := to.Sub(from) / time.Duration(reportChartWidth/2)
+	if interval >= time.Second {
+		query.Interval = interval.Round(time.Second)
+	}
+	err = r.channelRepository.ForEachBySensor(ctx, tx, sensor.IdSensor, query, func(channel entities.Channel) error {
+		image.Points = append(image.Points, chart.Point{Time: channel.Time, Value: channel.Value})
+		return nil
+	})
+	return image, err
+}
+
+// buildAlerts list the newest channel outside the section range, or outside the alert widget range of
+// each sensor when the section has no range
+func (r *ReportRepository) buildAlerts(ctx context.Context, tx helper.Querier, section entities.ReportSection, sensors []entities.Sensor, sensorIds []int, from time.Time, to time.Time) (alerts []reportAlert, err error) {
+	alerts = []reportAlert{}
+	limit := section.Limit
+	if limit == 0 {
+		limit = defaultReportAlertLimit
+	}
+	alertWidgets := map[int][]entities.Widget{}
+	if section.Min == nil && section.Max == nil {
+		alertWidgets, err = r.dashboardRepository.GetAlertWidgetsBySensors(ctx, tx, sensorIds)
+		if err != nil {
+			return alerts, err
+		}
+	}
+
+	for _, sensor := range sensors {
+		ranges := [][2]*float64{}
+		if section.Min != nil || section.Max != nil {
+			ranges = append(ranges, [2]*float64{section.Min, section.Max})
+		}
+		for _, widget := range alertWidgets[sensor.IdSensor] {
+			ranges = append(ranges, [2]*float64{widget.Options.Min, widget.Options.Max})
+		}
+
+		seen := map[time.Time]bool{}
+		sensorAlerts := []reportAlert{}
+		for _, bound := range ranges {
+			channels, err := r.channelRepository.GetOutOfRangeBySensorBetween(ctx, tx, sensor.IdSensor, bound[0], bound[1], from, to, limit)
+			if err != nil {
+				return alerts, err
+			}
+			for _, channel := range channels {
+				if seen[channel.Time] || len(sensorAlerts) >= limit {
+					continue
+				}
+				seen[channel.Time] = true
+				sensorAlerts = append(sensorAlerts, reportAlert{Sensor: sensor.Name, Unit: sensor.Unit, Channel: channel})
+			}
+		}
+		alerts = append(alerts, sensorAlerts...)
+	}
+	return alerts, nil
+}
+
+// Render the report of the owner in [from, to) as html or pdf
+func (r *ReportRepository) Render(ctx context.Context, tx helper.Querier, report entities.Report, owner entities.UserRead, from time.Time, to time.Time, format string) (body []byte, err error) {
+	document, err := r.build(ctx, tx, report, owner, from, to)
+	if err != nil {
+		return body, err
+	}
+
+	var buffer bytes.Buffer
+	if format == "pdf" {
+		err = r.renderPDF(&buffer, document)
+	} else {
+		err = reportTemplate.Execute(&buffer, document)
+	}
+	return buffer.Bytes(), err
+}
+
+func (r *ReportRepository) renderPDF(buffer *bytes.Buffer, document reportDocument) error {
+	doc := pdf.New()
+	doc.Heading(document.Report.Name)
+	doc.Paragraph(fmt.Sprintf("%s - %s UTC", formatTime(document.From), formatTime(document.To)))
+	for _, section := range document.Sections {
+		if section.Title != "" {
+			doc.Heading(section.Title)
+		}
+		switch section.Type {
+		case entities.ReportSectionChart:
+			for _, image := range section.Charts {
+				doc.Image(image.Image())
+			}
+		case entities.ReportSectionStats:
+			rows := [][]string{}
+			for _, stat := range section.Stats {
+				rows = append(rows, []string{stat.Name, fmt.Sprint(stat.Count), formatValue(stat.Min, stat.Unit), formatValue(stat.Max, stat.Unit), formatValue(stat.Avg, stat.Unit), fmt.Sprint(len(stat.Gaps)), fmt.Sprint(stat.Alerts)})
+			}
+			doc.Table([]string{"Sensor", "Channel", "Min", "Max", "Avg", "Gap", "Alert"}, rows)
+		case entities.ReportSectionAlerts:
+			rows := [][]string{}
+			for _, alert := range section.Alerts {
+				rows = append(rows, []string{formatTime(alert.Channel.Time), alert.Sensor, formatValue(&alert.Channel.Value, alert.Unit), reportQuality(alert.Channel.Quality)})
+			}
+			if len(rows) == 0 {
+				rows = append(rows, []string{"No alert"})
+			}
+			doc.Table([]string{"Time", "Sensor", "Value", "Quality"}, rows)
+		case entities.ReportSectionText:
+			doc.Paragraph(section.Text)
+		}
+	}
+	return doc.Write(buffer)
+}
+
+// Send email the report in [from, to) to its owner, the pdf report is attached to the email
+func (r *ReportRepository) Send(ctx context.Context, tx helper.Querier, report entities.Report, owner entities.UserRead, from time.Time, to time.Time) (err error) {
+	subject := fmt.Sprintf("%s %s", report.Name, from.UTC().Format("2006-01-02"))
+	if report.Format != "pdf" {
+		body, err := r.Render(ctx, tx, report, owner, from, to, "html")
+		if err != nil {
+			return err
+		}
+		return r.userRepository.SendEmail(ctx, owner.Email, subject, string(body))
+	}
+
+	attachment, err := r.Render(ctx, tx, report, owner, from, to, "pdf")
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf(`<html>
+		  <body>
+			<h3>Dear %s.</h3>
+			<p>The report %s from %s to %s UTC is attached.</p>
+			<p>Thank You</p>
+		  </body>
+		</html>`, template.HTMLEscapeString(owner.Username), template.HTMLEscapeString(report.Name), formatTime(from), formatTime(to))
+	return r.userRepository.SendEmailAttachment(ctx, owner.Email, subject, body, fmt.Sprintf("report-%d.pdf", report.IdReport), attachment)
+}
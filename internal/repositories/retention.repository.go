@@ -0,0 +1,115 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/jackc/pgx/v5"
+)
+
+// RetentionRepository keep how long the channel of a sensor is kept and purge the expired channel
+type RetentionRepository struct {
+	channelRepository *ChannelRepository
+	batchSize         int
+}
+
+func NewRetentionRepository(channelRepository *ChannelRepository, config *configs.Config) (RetentionRepository, error) {
+	batchSize := config.Retention.BatchSize
+	if batchSize <= 0 {
+		batchSize = 10000
+	}
+	return RetentionRepository{
+		channelRepository: channelRepository,
+		batchSize:         batchSize,
+	}, nil
+}
+
+// GetBySensor return the retention of the sensor, 0 day when it keep the channel forever
+func (r *RetentionRepository) GetBySensor(ctx context.Context, tx helper.Querier, sensorId int) (retention entities.SensorRetention, err error) {
+	retention = entities.SensorRetention{IdSensor: sensorId}
+	sqlStatement := `SELECT keep_days, purged, last_purged, last_run_at FROM sensor_retention WHERE id_sensor=$1`
+	err = tx.QueryRow(ctx, sqlStatement, sensorId).Scan(&retention.KeepDays, &retention.Purged, &retention.LastPurged, &retention.LastRunAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return retention, nil
+	}
+	return retention, err
+}
+
+// Update set the retention of the sensor, 0 day remove it. The purged count is kept when the day change
+func (r *RetentionRepository) Update(ctx context.Context, tx helper.Querier, sensorId int, payload *entities.SensorRetentionUpdate) error {
+	if payload.KeepDays == 0 {
+		_, err := tx.Exec(ctx, `DELETE FROM sensor_retention WHERE id_sensor=$1`, sensorId)
+		return err
+	}
+
+	sqlStatement := `
+	INSERT INTO sensor_retention (id_sensor, keep_days) VALUES ($1, $2)
+	ON CONFLICT (id_sensor) DO UPDATE SET keep_days=EXCLUDED.keep_days`
+	_, err := tx.Exec(ctx, sqlStatement, sensorId, payload.KeepDays)
+	return err
+}
+
+// Purge delete the channel older than the retention of every sensor that has one. The raw channel
+// is deleted batchSize row per statement, so tx should be the pool for each batch to commit on its
+// own, then the compressed, archived and rolled up day that ended before the cutoff
+func (r *RetentionRepository) Purge(ctx context.Context, tx helper.Querier, now time.Time) (sensors int, count int64, err error) {
+	rows, err := tx.Query(ctx, `SELECT id_sensor, keep_days FROM sensor_retention ORDER BY id_sensor`)
+	if err != nil {
+		return 0, 0, err
+	}
+	retentions := []entities.SensorRetention{}
+	for rows.Next() {
+		var retention entities.SensorRetention
+		err := rows.Scan(&retention.IdSensor, &retention.KeepDays)
+		if err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		retentions = append(retentions, retention)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	for _, retention := range retentions {
+		purged, err := r.purgeSensor(ctx, tx, retention.IdSensor, now.AddDate(0, 0, -retention.KeepDays))
+		if err != nil {
+			return sensors, count, err
+		}
+
+		_, err = tx.Exec(ctx, `UPDATE sensor_retention SET purged=purged+$2, last_purged=$2, last_run_at=$3 WHERE id_sensor=$1`, retention.IdSensor, purged, now)
+		if err != nil {
+			return sensors, count, err
+		}
+		sensors++
+		count += purged
+	}
+	return sensors, count, nil
+}
+
+// purgeSensor delete the channel of the sensor older than the cutoff
+func (r *RetentionRepository) purgeSensor(ctx context.Context, tx helper.Querier, sensorId int, cutoff time.Time) (count int64, err error) {
+	sqlStatement := `
+	DELETE FROM "channel" WHERE ctid IN (
+		SELECT ctid FROM "channel" WHERE id_sensor=$1 AND time<$2 LIMIT $3
+	)`
+	for {
+		res, err := tx.Exec(ctx, sqlStatement, sensorId, cutoff, r.batchSize)
+		if err != nil {
+			return count, err
+		}
+		count += res.RowsAffected()
+		if res.RowsAffected() < int64(r.batchSize) {
+			break
+		}
+	}
+
+	// The raw channel is already gone, this delete the rest and drop the checksum of the purged day
+	rest, err := r.channelRepository.DeleteBefore(ctx, tx, sensorId, cutoff)
+	return count + rest, err
+}
@@ -0,0 +1,141 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+)
+
+// RollupRepository keep the channel_rollup written by the storage policy, the summary of the
+// channel that is no longer kept raw
+type RollupRepository struct{}
+
+func NewRollupRepository() (RollupRepository, error) {
+	return RollupRepository{}, nil
+}
+
+// GetSegments return the rollup of the sensor which bucket start in [from, to), ordered by bucket
+func (r *RollupRepository) GetSegments(ctx context.Context, tx helper.Querier, sensorId int, from *time.Time, to *time.Time) (rollups []entities.ChannelRollup, err error) {
+	rollups = []entities.ChannelRollup{}
+	sqlStatement := `
+	SELECT id_sensor, resolution, bucket, row_count, avg_value, min_value, max_value, last_value
+	FROM channel_rollup
+	WHERE id_sensor=$1 AND ($2::TIMESTAMP IS NULL OR bucket >= $2) AND ($3::TIMESTAMP IS NULL OR bucket < $3)
+	ORDER BY bucket`
+	rows, err := tx.Query(ctx, sqlStatement, sensorId, from, to)
+	if err != nil {
+		return rollups, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rollup entities.ChannelRollup
+		err := rows.Scan(&rollup.IdSensor, &rollup.Resolution, &rollup.Bucket, &rollup.RowCount, &rollup.Avg, &rollup.Min, &rollup.Max, &rollup.Last)
+		if err != nil {
+			return rollups, err
+		}
+		rollups = append(rollups, rollup)
+	}
+	return rollups, rows.Err()
+}
+
+// GetTimeRange return the first and last rollup bucket, nil if the sensor has no rollup
+func (r *RollupRepository) GetTimeRange(ctx context.Context, tx helper.Querier, sensorId int) (first *time.Time, last *time.Time, err error) {
+	sqlStatement := `SELECT min(bucket), max(bucket) FROM channel_rollup WHERE id_sensor=$1`
+	err = tx.QueryRow(ctx, sqlStatement, sensorId).Scan(&first, &last)
+	return first, last, err
+}
+
+// Add merge the rollups of a sensor into the existing bucket, the average is weighted by the row count
+func (r *RollupRepository) Add(ctx context.Context, tx helper.Querier, sensorId int, resolution int, rollups []entities.ChannelRollup) error {
+	if len(rollups) == 0 {
+		return nil
+	}
+
+	buckets := make([]time.Time, len(rollups))
+	counts := make([]int64, len(rollups))
+	avgs := make([]float64, len(rollups))
+	mins := make([]float64, len(rollups))
+	maxs := make([]float64, len(rollups))
+	lasts := make([]float64, len(rollups))
+	for i, rollup := range rollups {
+		buckets[i] = rollup.Bucket
+		counts[i] = rollup.RowCount
+		avgs[i] = rollup.Avg
+		mins[i] = rollup.Min
+		maxs[i] = rollup.Max
+		lasts[i] = rollup.Last
+	}
+
+	sqlStatement := `
+	INSERT INTO channel_rollup (id_sensor, resolution, bucket, row_count, avg_value, min_value, max_value, last_value)
+	SELECT $1, $2, * FROM unnest($3::TIMESTAMP[], $4::BIGINT[], $5::FLOAT[], $6::FLOAT[], $7::FLOAT[], $8::FLOAT[])
+	ON CONFLICT (id_sensor, resolution, bucket) DO UPDATE SET
+		avg_value=(channel_rollup.avg_value*channel_rollup.row_count + EXCLUDED.avg_value*EXCLUDED.row_count) / (channel_rollup.row_count + EXCLUDED.row_count),
+		row_count=channel_rollup.row_count + EXCLUDED.row_count,
+		min_value=LEAST(channel_rollup.min_value, EXCLUDED.min_value),
+		max_value=GREATEST(channel_rollup.max_value, EXCLUDED.max_value),
+		last_value=EXCLUDED.last_value`
+	_, err := tx.Exec(ctx, sqlStatement, sensorId, resolution, buckets, counts, avgs, mins, maxs, lasts)
+	return err
+}
+
+// DeleteBefore delete the rollup which bucket end before the time, of every sensor when sensorId is 0.
+// The count is the number of channel summarized by the deleted rollup
+func (r *RollupRepository) DeleteBefore(ctx context.Context, tx helper.Querier, sensorId int, before time.Time) (count int64, err error) {
+	sqlStatement := `
+	WITH deleted AS (
+		DELETE FROM channel_rollup
+		WHERE bucket + make_interval(secs => resolution) <= $1 AND ($2=0 OR id_sensor=$2)
+		RETURNING row_count
+	)
+	SELECT COALESCE(SUM(row_count), 0) FROM deleted`
+	err = tx.QueryRow(ctx, sqlStatement, before, sensorId).Scan(&count)
+	return count, err
+}
+
+// rollupBuilder summarize channel ordered by time into bucket of resolution, aligned to UTC midnight
+type rollupBuilder struct {
+	resolution time.Duration
+	rollups    []entities.ChannelRollup
+	sum        float64
+}
+
+func (b *rollupBuilder) add(channel entities.Channel) error {
+	bucket := channel.Time.UTC().Truncate(b.resolution)
+	last := len(b.rollups) - 1
+	if last < 0 || !b.rollups[last].Bucket.Equal(bucket) {
+		b.finish()
+		b.rollups = append(b.rollups, entities.ChannelRollup{
+			IdSensor:   channel.IdSensor,
+			Resolution: int(b.resolution.Seconds()),
+			Bucket:     bucket,
+			Min:        channel.Value,
+			Max:        channel.Value,
+		})
+		b.sum = 0
+		last++
+	}
+
+	rollup := &b.rollups[last]
+	rollup.RowCount++
+	b.sum += channel.Value
+	if channel.Value < rollup.Min {
+		rollup.Min = channel.Value
+	}
+	if channel.Value > rollup.Max {
+		rollup.Max = channel.Value
+	}
+	rollup.Last = channel.Value
+	return nil
+}
+
+// finish set the average of the current bucket, it must be called after the last channel
+func (b *rollupBuilder) finish() {
+	if len(b.rollups) > 0 {
+		rollup := &b.rollups[len(b.rollups)-1]
+		rollup.Avg = b.sum / float64(rollup.RowCount)
+	}
+}
@@ -0,0 +1,118 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+// ScriptRepository keep the script the user run on the ingest and alert event of their sensor
+type ScriptRepository struct{}
+
+func NewScriptRepository() (ScriptRepository, error) {
+	return ScriptRepository{}, nil
+}
+
+func (r *ScriptRepository) scriptField() string {
+	return "id_script, id_user, name, event, id_sensor, source, is_active, last_error, created_at, updated_at"
+}
+
+func (r *ScriptRepository) scriptPointer(script *entities.Script) []interface{} {
+	return []interface{}{&script.IdScript, &script.IdUser, &script.Name, &script.Event, &script.IdSensor, &script.Source, &script.IsActive, &script.LastError, &script.CreatedAt, &script.UpdatedAt}
+}
+
+func (r *ScriptRepository) scan(rows pgx.Rows) (scripts []entities.Script, err error) {
+	scripts = []entities.Script{}
+	defer rows.Close()
+	for rows.Next() {
+		var script entities.Script
+		err := rows.Scan(r.scriptPointer(&script)...)
+		if err != nil {
+			return scripts, err
+		}
+		scripts = append(scripts, script)
+	}
+	return scripts, rows.Err()
+}
+
+func (r *ScriptRepository) GetAll(ctx context.Context, tx helper.Querier, idUser int) (scripts []entities.Script, err error) {
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM script WHERE id_user=$1 ORDER BY id_script`, r.scriptField())
+	rows, err := tx.Query(ctx, sqlStatement, idUser)
+	if err != nil {
+		return []entities.Script{}, err
+	}
+	return r.scan(rows)
+}
+
+func (r *ScriptRepository) GetById(ctx context.Context, tx helper.Querier, id int) (script entities.Script, err error) {
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM script WHERE id_script=$1`, r.scriptField())
+	err = tx.QueryRow(ctx, sqlStatement, id).Scan(r.scriptPointer(&script)...)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return script, fiber.NewError(404, fmt.Sprintf("Script with id %d not found", id))
+		}
+		return script, err
+	}
+	return script, nil
+}
+
+// GetActive return the active script of the user for the event of the sensor, in the order they
+// are run
+func (r *ScriptRepository) GetActive(ctx context.Context, tx helper.Querier, idUser int, sensorId int, event string) (scripts []entities.Script, err error) {
+	sqlStatement := fmt.Sprintf(`
+	SELECT %s FROM script
+	WHERE id_user=$1 AND event=$2 AND is_active AND (id_sensor IS NULL OR id_sensor=$3)
+	ORDER BY id_script`, r.scriptField())
+	rows, err := tx.Query(ctx, sqlStatement, idUser, event, sensorId)
+	if err != nil {
+		return []entities.Script{}, err
+	}
+	return r.scan(rows)
+}
+
+func (r *ScriptRepository) Create(ctx context.Context, tx helper.Querier, idUser int, payload *entities.ScriptCreate) (script entities.Script, err error) {
+	isActive := payload.IsActive == nil || *payload.IsActive
+	sqlStatement := fmt.Sprintf(`
+	INSERT INTO script (id_user, name, event, id_sensor, source, is_active)
+	VALUES ($1, $2, $3, $4, $5, $6) RETURNING %s`, r.scriptField())
+	err = tx.QueryRow(ctx, sqlStatement, idUser, payload.Name, payload.Event, payload.IdSensor, payload.Source, isActive).Scan(r.scriptPointer(&script)...)
+	return script, err
+}
+
+// Update keep the active state when it is omitted, the error of the last run is cleared
+func (r *ScriptRepository) Update(ctx context.Context, tx helper.Querier, id int, payload *entities.ScriptCreate) (err error) {
+	sqlStatement := `
+	UPDATE script
+	SET name=$1, event=$2, id_sensor=$3, source=$4, is_active=COALESCE($5, is_active), last_error=NULL, updated_at=NOW()
+	WHERE id_script=$6`
+	res, err := tx.Exec(ctx, sqlStatement, payload.Name, payload.Event, payload.IdSensor, payload.Source, payload.IsActive, id)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on update script with id %d", id))
+	}
+	return nil
+}
+
+func (r *ScriptRepository) Delete(ctx context.Context, tx helper.Querier, id int) (err error) {
+	res, err := tx.Exec(ctx, `DELETE FROM script WHERE id_script=$1`, id)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on delete script with id %d", id))
+	}
+	return nil
+}
+
+// SetError record the error of the last run, nil when it succeeded. The row is only written when
+// the error changed
+func (r *ScriptRepository) SetError(ctx context.Context, tx helper.Querier, id int, lastError *string) error {
+	_, err := tx.Exec(ctx, `UPDATE script SET last_error=$1 WHERE id_script=$2 AND last_error IS DISTINCT FROM $1`, lastError, id)
+	return err
+}
@@ -3,6 +3,7 @@ package repositories
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/dafaath/iot-server/internal/entities"
 	"github.com/dafaath/iot-server/internal/helper"
@@ -17,18 +18,22 @@ func NewSensorRepository() (SensorRepository, error) {
 }
 
 func (u *SensorRepository) sensorFieldWithoutId() string {
-	return "name, unit, id_node, id_hardware"
+	return "name, unit, id_node, id_hardware, min_value, max_value, calibration_scale, calibration_offset"
 }
 
 func (u *SensorRepository) sensorField() string {
-	return "sensor.id_sensor, sensor.name, sensor.unit, sensor.id_node, sensor.id_hardware"
+	return "sensor.id_sensor, sensor.name, sensor.unit, sensor.id_node, sensor.id_hardware, sensor.min_value, sensor.max_value, sensor.calibration_scale, sensor.calibration_offset"
 }
 
 func (u *SensorRepository) sensorPointer(sensor *entities.Sensor) []interface{} {
-	return []interface{}{&sensor.IdSensor, &sensor.Name, &sensor.Unit, &sensor.IdNode, &sensor.IdHardware}
+	return []interface{}{&sensor.IdSensor, &sensor.Name, &sensor.Unit, &sensor.IdNode, &sensor.IdHardware,
+		&sensor.MinValue, &sensor.MaxValue, &sensor.CalibrationScale, &sensor.CalibrationOffset}
 }
 
 func (h *SensorRepository) Create(ctx context.Context, tx helper.Querier, payload *entities.SensorCreate) (sensor entities.Sensor, err error) {
+	if !payload.ValidRange() {
+		return sensor, fiber.NewError(400, "min_value must not be more than max_value")
+	}
 	sensor = entities.Sensor{
 		IdSensor:     0,
 		SensorCreate: *payload,
@@ -37,8 +42,9 @@ func (h *SensorRepository) Create(ctx context.Context, tx helper.Querier, payloa
 	INSERT INTO "sensor" (
 		%s
 	)
-	VALUES ($1, $2, $3, $4) RETURNING id_sensor`, h.sensorFieldWithoutId())
-	err = tx.QueryRow(ctx, sqlStatement, sensor.Name, sensor.Unit, sensor.IdNode, sensor.IdHardware).Scan(&sensor.IdSensor)
+	VALUES ($1, $2, $3, $4, $5, $6, COALESCE($7, 1), COALESCE($8, 0)) RETURNING id_sensor, calibration_scale, calibration_offset`, h.sensorFieldWithoutId())
+	err = tx.QueryRow(ctx, sqlStatement, sensor.Name, sensor.Unit, sensor.IdNode, sensor.IdHardware,
+		sensor.MinValue, sensor.MaxValue, sensor.CalibrationScale, sensor.CalibrationOffset).Scan(&sensor.IdSensor, &sensor.CalibrationScale, &sensor.CalibrationOffset)
 	if err != nil {
 		return sensor, err
 	}
@@ -46,25 +52,47 @@ func (h *SensorRepository) Create(ctx context.Context, tx helper.Querier, payloa
 	return sensor, nil
 }
 
-func (u *SensorRepository) GetAll(ctx context.Context, tx helper.Querier, currentUser *entities.UserRead) (sensors []entities.Sensor, err error) {
+// sensorCondition return the WHERE condition and its argument of the sensor list
+func (u *SensorRepository) sensorCondition(currentUser *entities.UserRead, query *entities.SensorQuery) (string, []interface{}) {
+	conditions := []string{"sensor.deleted_at IS NULL", "($1 OR node.id_user=$2 OR node.id_node IN (SELECT id_node FROM node_share WHERE node_share.id_user=$2))"}
+	args := []interface{}{currentUser.IsAdmin, currentUser.IdUser}
+	if query.Search != "" {
+		args = append(args, query.Search)
+		conditions = append(conditions, fmt.Sprintf("(strpos(lower(sensor.name), lower($%[1]d)) > 0 OR strpos(lower(sensor.unit), lower($%[1]d)) > 0)", len(args)))
+	}
+	if query.Name != "" {
+		args = append(args, query.Name)
+		conditions = append(conditions, fmt.Sprintf("strpos(lower(sensor.name), lower($%d)) > 0", len(args)))
+	}
+	if query.IdNode != 0 {
+		args = append(args, query.IdNode)
+		conditions = append(conditions, fmt.Sprintf("sensor.id_node=$%d", len(args)))
+	}
+	if query.IdHardware != 0 {
+		args = append(args, query.IdHardware)
+		conditions = append(conditions, fmt.Sprintf("sensor.id_hardware=$%d", len(args)))
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// GetAll return the sensor of the user node and the node shared with them, or every sensor for admin, filtered by the query and ordered by name
+func (u *SensorRepository) GetAll(ctx context.Context, tx helper.Querier, currentUser *entities.UserRead, query *entities.SensorQuery) (sensors []entities.Sensor, err error) {
 	sensors = []entities.Sensor{}
-	var sqlStatement string
-	var rows pgx.Rows
-	if currentUser.IsAdmin {
-		sqlStatement = fmt.Sprintf(`SELECT %s FROM "sensor"`, u.sensorField())
-		rows, err = tx.Query(ctx, sqlStatement)
-		if err != nil {
-			return sensors, err
-		}
-		defer rows.Close()
-	} else {
-		sqlStatement = fmt.Sprintf(`SELECT %s FROM "sensor" INNER JOIN "node" ON node.id_node=sensor.id_node WHERE node.id_user=$1`, u.sensorField())
-		rows, err = tx.Query(ctx, sqlStatement, currentUser.IdUser)
-		if err != nil {
-			return sensors, err
-		}
-		defer rows.Close()
+	condition, args := u.sensorCondition(currentUser, query)
+	if query.After != nil {
+		args = append(args, query.After.Name, query.After.IdSensor)
+		condition += fmt.Sprintf(" AND (sensor.name, sensor.id_sensor) > ($%d, $%d)", len(args)-1, len(args))
 	}
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM "sensor" INNER JOIN "node" ON node.id_node=sensor.id_node WHERE %s ORDER BY sensor.name, sensor.id_sensor`, u.sensorField(), condition)
+	if query.Limit > 0 {
+		args = append(args, query.Limit, query.Offset)
+		sqlStatement += fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+	}
+	rows, err := tx.Query(ctx, sqlStatement, args...)
+	if err != nil {
+		return sensors, err
+	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var sensor entities.Sensor
@@ -82,8 +110,16 @@ func (u *SensorRepository) GetAll(ctx context.Context, tx helper.Querier, curren
 	return sensors, nil
 }
 
+// CountAll return the number of sensor matching the query, without the limit
+func (u *SensorRepository) CountAll(ctx context.Context, tx helper.Querier, currentUser *entities.UserRead, query *entities.SensorQuery) (count int, err error) {
+	condition, args := u.sensorCondition(currentUser, query)
+	sqlStatement := fmt.Sprintf(`SELECT COUNT(*) FROM "sensor" INNER JOIN "node" ON node.id_node=sensor.id_node WHERE %s`, condition)
+	err = tx.QueryRow(ctx, sqlStatement, args...).Scan(&count)
+	return count, err
+}
+
 func (u *SensorRepository) GetById(ctx context.Context, tx helper.Querier, id int) (sensor entities.Sensor, err error) {
-	sqlStatement := fmt.Sprintf(`SELECT %s FROM "sensor" WHERE id_sensor=$1`, u.sensorField())
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM "sensor" WHERE id_sensor=$1 AND deleted_at IS NULL`, u.sensorField())
 	err = tx.QueryRow(ctx, sqlStatement, id).Scan(
 		u.sensorPointer(&sensor)...,
 	)
@@ -98,7 +134,7 @@ func (u *SensorRepository) GetById(ctx context.Context, tx helper.Querier, id in
 
 func (u *SensorRepository) GetHardwareSensor(ctx context.Context, tx helper.Querier, hardwareId int) ([]entities.Sensor, error) {
 	sensors := []entities.Sensor{}
-	sqlStatement := fmt.Sprintf(`SELECT %s FROM "sensor" WHERE id_hardware=$1`, u.sensorField())
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM "sensor" WHERE id_hardware=$1 AND deleted_at IS NULL`, u.sensorField())
 	rows, err := tx.Query(ctx, sqlStatement, hardwareId)
 	if err != nil {
 		return sensors, err
@@ -124,7 +160,7 @@ func (u *SensorRepository) GetHardwareSensor(ctx context.Context, tx helper.Quer
 
 func (u *SensorRepository) GetNodeSensor(ctx context.Context, tx helper.Querier, nodeId int) ([]entities.Sensor, error) {
 	sensors := []entities.Sensor{}
-	sqlStatement := fmt.Sprintf(`SELECT %s FROM "sensor" WHERE id_node=$1`, u.sensorField())
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM "sensor" WHERE id_node=$1 AND deleted_at IS NULL`, u.sensorField())
 	rows, err := tx.Query(ctx, sqlStatement, nodeId)
 	if err != nil {
 		return sensors, err
@@ -148,51 +184,112 @@ func (u *SensorRepository) GetNodeSensor(ctx context.Context, tx helper.Querier,
 	return sensors, nil
 }
 
-func (u *SensorRepository) GetSensorChannel(ctx context.Context, tx helper.Querier, sensorId int) (channels []entities.Channel, err error) {
-	channels = []entities.Channel{}
-	sqlStatement := `SELECT channel.time, channel.value, channel.id_sensor FROM "channel" WHERE channel.id_sensor=$1`
-	rows, err := tx.Query(ctx, sqlStatement, sensorId)
+func (u *SensorRepository) GetIdUserWhoOwnSensorById(ctx context.Context, tx helper.Querier, sensorId int) (userId int, err error) {
+	sqlStatement := `SELECT node.id_user FROM "sensor" INNER JOIN "node" ON node.id_node=sensor.id_node WHERE sensor.id_sensor=$1 AND sensor.deleted_at IS NULL`
+	err = tx.QueryRow(ctx, sqlStatement, sensorId).Scan(&userId)
 	if err != nil {
-		return channels, err
+		if err == pgx.ErrNoRows {
+			return userId, fiber.NewError(404, fmt.Sprintf("Sensor with id %d not found", sensorId))
+		}
+		return userId, err
+	}
+	return userId, nil
+}
+
+// GetIdNodeById return the node of the sensor, to limit a node API key to its own sensor
+func (u *SensorRepository) GetIdNodeById(ctx context.Context, tx helper.Querier, sensorId int) (nodeId int, err error) {
+	err = tx.QueryRow(ctx, `SELECT id_node FROM "sensor" WHERE id_sensor=$1 AND deleted_at IS NULL`, sensorId).Scan(&nodeId)
+	if err == pgx.ErrNoRows {
+		return nodeId, fiber.NewError(404, fmt.Sprintf("Sensor with id %d not found", sensorId))
+	}
+	return nodeId, err
+}
+
+func (u *SensorRepository) GetByEmbedToken(ctx context.Context, tx helper.Querier, token string) (sensor entities.Sensor, err error) {
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM "sensor" WHERE embed_token=$1 AND deleted_at IS NULL`, u.sensorField())
+	err = tx.QueryRow(ctx, sqlStatement, token).Scan(
+		u.sensorPointer(&sensor)...,
+	)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return sensor, fiber.NewError(404, "Embedded chart not found or no longer shared")
+		}
+		return sensor, err
+	}
+	return sensor, nil
+}
+
+func (u *SensorRepository) GetEmbedToken(ctx context.Context, tx helper.Querier, id int) (token *string, err error) {
+	sqlStatement := `SELECT embed_token FROM "sensor" WHERE id_sensor=$1`
+	err = tx.QueryRow(ctx, sqlStatement, id).Scan(&token)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return token, fiber.NewError(404, fmt.Sprintf("Sensor with id %d not found", id))
+		}
+		return token, err
+	}
+	return token, nil
+}
+
+// SetEmbedToken replace the embed token, nil revoke every embedded chart of the sensor
+func (u *SensorRepository) SetEmbedToken(ctx context.Context, tx helper.Querier, id int, token *string) (err error) {
+	sqlStatement := `
+	UPDATE "sensor"
+	SET embed_token=$1
+	WHERE id_sensor=$2`
+	res, err := tx.Exec(ctx, sqlStatement, token, id)
+	if err != nil {
+		return err
+	}
+	count := res.RowsAffected()
+	if count == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on update sensor with id %d", id))
+	}
+	return nil
+}
+
+func (u *SensorRepository) GetTags(ctx context.Context, tx helper.Querier, id int) (tags []string, err error) {
+	tags = []string{}
+	rows, err := tx.Query(ctx, `SELECT tag FROM sensor_tag WHERE id_sensor=$1 ORDER BY tag`, id)
+	if err != nil {
+		return tags, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var channel entities.Channel
-		err := rows.Scan(
-			&channel.Time, &channel.Value, &channel.IdSensor,
-		)
+		var tag string
+		err := rows.Scan(&tag)
 		if err != nil {
-			return channels, err
+			return tags, err
 		}
-		channels = append(channels, channel)
-	}
-	if err := rows.Err(); err != nil {
-		return channels, err
+		tags = append(tags, tag)
 	}
-	return channels, nil
+	return tags, rows.Err()
 }
 
-func (u *SensorRepository) GetIdUserWhoOwnSensorById(ctx context.Context, tx helper.Querier, sensorId int) (userId int, err error) {
-	sqlStatement := `SELECT node.id_user FROM "sensor" INNER JOIN "node" ON node.id_node=sensor.id_node WHERE sensor.id_sensor=$1`
-	err = tx.QueryRow(ctx, sqlStatement, sensorId).Scan(&userId)
+// SetTags replace every tag of the sensor, the tag select the storage policy of the sensor
+func (u *SensorRepository) SetTags(ctx context.Context, tx helper.Querier, id int, tags []string) (err error) {
+	_, err = tx.Exec(ctx, `DELETE FROM sensor_tag WHERE id_sensor=$1`, id)
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return userId, fiber.NewError(404, fmt.Sprintf("Sensor with id %d not found", sensorId))
-		}
-		return userId, err
+		return err
 	}
-	return userId, nil
+	_, err = tx.Exec(ctx, `INSERT INTO sensor_tag (id_sensor, tag) SELECT DISTINCT $1::INTEGER, unnest($2::VARCHAR[])`, id, tags)
+	return err
 }
 
 func (u *SensorRepository) Update(ctx context.Context, tx helper.Querier, sensor *entities.Sensor, payload *entities.SensorUpdate) (err error) {
 	payload.ChangeSettedFieldOnly(sensor)
+	updated := entities.SensorCreate{MinValue: payload.MinValue, MaxValue: payload.MaxValue}
+	if !updated.ValidRange() {
+		return fiber.NewError(400, "min_value must not be more than max_value")
+	}
 
 	sqlStatement := `
 	UPDATE "sensor"
-	SET name=$1, unit=$2
-	WHERE id_sensor=$3`
-	res, err := tx.Exec(ctx, sqlStatement, payload.Name, payload.Unit, sensor.IdSensor)
+	SET name=$1, unit=$2, min_value=$3, max_value=$4, calibration_scale=COALESCE($5, 1), calibration_offset=COALESCE($6, 0)
+	WHERE id_sensor=$7`
+	res, err := tx.Exec(ctx, sqlStatement, payload.Name, payload.Unit, payload.MinValue, payload.MaxValue,
+		payload.CalibrationScale, payload.CalibrationOffset, sensor.IdSensor)
 	if err != nil {
 		return err
 	}
@@ -203,8 +300,136 @@ func (u *SensorRepository) Update(ctx context.Context, tx helper.Querier, sensor
 	return nil
 }
 
+// GetDisplays set the channel display of the sensors. The unnamed channel is shown with the sensor
+// name and unit unless the owner set its display
+func (u *SensorRepository) GetDisplays(ctx context.Context, tx helper.Querier, sensors []entities.Sensor) (err error) {
+	ids := make([]int, len(sensors))
+	displays := map[int][]entities.ChannelDisplay{}
+	for i, sensor := range sensors {
+		ids[i] = sensor.IdSensor
+	}
+
+	sqlStatement := `SELECT id_sensor, name, label, unit, decimals FROM sensor_display WHERE id_sensor = ANY($1) ORDER BY id_sensor, name`
+	rows, err := tx.Query(ctx, sqlStatement, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var display entities.ChannelDisplay
+		err := rows.Scan(&id, &display.Name, &display.Label, &display.Unit, &display.Precision)
+		if err != nil {
+			return err
+		}
+		displays[id] = append(displays[id], display)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range sensors {
+		sensorDisplays := displays[sensors[i].IdSensor]
+		if len(sensorDisplays) == 0 || sensorDisplays[0].Name != "" {
+			sensorDisplays = append([]entities.ChannelDisplay{sensors[i].ChannelDisplay("")}, sensorDisplays...)
+		}
+		sensors[i].Display = sensorDisplays
+	}
+	return nil
+}
+
+// SetDisplay replace the channel display of the sensor
+func (u *SensorRepository) SetDisplay(ctx context.Context, tx helper.Querier, id int, displays []entities.ChannelDisplay) (err error) {
+	_, err = tx.Exec(ctx, `DELETE FROM sensor_display WHERE id_sensor=$1`, id)
+	if err != nil {
+		return err
+	}
+	for _, display := range displays {
+		sqlStatement := `INSERT INTO sensor_display (id_sensor, name, label, unit, decimals) VALUES ($1, $2, $3, $4, $5)`
+		_, err = tx.Exec(ctx, sqlStatement, id, display.Name, display.Label, display.Unit, display.Precision)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Move put the sensor in another node, its channel and history stay with it
+func (u *SensorRepository) Move(ctx context.Context, tx helper.Querier, id int, nodeId int) (err error) {
+	sqlStatement := `UPDATE "sensor" SET id_node=$2 WHERE id_sensor=$1`
+	res, err := tx.Exec(ctx, sqlStatement, id, nodeId)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on move sensor with id %d", id))
+	}
+	return nil
+}
+
+// DetachFromOtherUser remove the widget of the sensor on the dashboard of another user than userId
+// and its embed token, after the sensor is moved to the node of userId
+func (u *SensorRepository) DetachFromOtherUser(ctx context.Context, tx helper.Querier, id int, userId int) (err error) {
+	sqlStatement := `
+	DELETE FROM dashboard_widget w USING dashboard d
+	WHERE w.id_dashboard = d.id_dashboard AND w.id_sensor=$1 AND d.id_user<>$2`
+	_, err = tx.Exec(ctx, sqlStatement, id, userId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `UPDATE "sensor" SET embed_token=NULL WHERE id_sensor=$1`, id)
+	return err
+}
+
+// LockById lock the sensor row until the end of the transaction, in id order so two transaction
+// locking the same sensor don't deadlock
+func (u *SensorRepository) LockById(ctx context.Context, tx helper.Querier, ids []int) (err error) {
+	sqlStatement := `SELECT id_sensor FROM "sensor" WHERE id_sensor = ANY($1) ORDER BY id_sensor FOR UPDATE`
+	rows, err := tx.Query(ctx, sqlStatement, ids)
+	if err != nil {
+		return err
+	}
+	rows.Close()
+	return rows.Err()
+}
+
+// MoveSetting move the tag, dashboard widget, storage policy, script, rule and display of the sensor
+// fromId to the sensor toId. The validation rule, filter, throttle, transformation, compression
+// setting and display of toId is kept when it has one, the validation counter is added up
+func (u *SensorRepository) MoveSetting(ctx context.Context, tx helper.Querier, fromId int, toId int) (err error) {
+	sqlStatements := []string{
+		`INSERT INTO sensor_tag (id_sensor, tag) SELECT $2, tag FROM sensor_tag WHERE id_sensor=$1 ON CONFLICT DO NOTHING`,
+		`UPDATE dashboard_widget SET id_sensor=$2 WHERE id_sensor=$1`,
+		`UPDATE storage_policy SET id_sensor=$2 WHERE id_sensor=$1`,
+		`UPDATE script SET id_sensor=$2 WHERE id_sensor=$1`,
+		`INSERT INTO sensor_validation (id_sensor, min_value, max_value, max_step, action, flagged, rejected)
+		SELECT $2, min_value, max_value, max_step, action, flagged, rejected FROM sensor_validation WHERE id_sensor=$1
+		ON CONFLICT (id_sensor) DO UPDATE SET
+			flagged=sensor_validation.flagged + EXCLUDED.flagged, rejected=sensor_validation.rejected + EXCLUDED.rejected`,
+		`INSERT INTO sensor_filter (id_sensor, method, window_size, threshold)
+		SELECT $2, method, window_size, threshold FROM sensor_filter WHERE id_sensor=$1 ON CONFLICT DO NOTHING`,
+		`INSERT INTO sensor_throttle (id_sensor, min_interval_ms, mode)
+		SELECT $2, min_interval_ms, mode FROM sensor_throttle WHERE id_sensor=$1 ON CONFLICT DO NOTHING`,
+		`INSERT INTO sensor_transform (id_sensor, steps)
+		SELECT $2, steps FROM sensor_transform WHERE id_sensor=$1 ON CONFLICT DO NOTHING`,
+		`INSERT INTO sensor_compression (id_sensor, after_days)
+		SELECT $2, after_days FROM sensor_compression WHERE id_sensor=$1 ON CONFLICT DO NOTHING`,
+		`INSERT INTO sensor_display (id_sensor, name, label, unit, decimals)
+		SELECT $2, name, label, unit, decimals FROM sensor_display WHERE id_sensor=$1 ON CONFLICT DO NOTHING`,
+	}
+	for _, sqlStatement := range sqlStatements {
+		_, err = tx.Exec(ctx, sqlStatement, fromId, toId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete soft delete the sensor, it is hidden but its channel is kept until restored
 func (u *SensorRepository) Delete(ctx context.Context, tx helper.Querier, id int) (err error) {
-	sqlStatement := `DELETE FROM "sensor" WHERE id_sensor=$1`
+	sqlStatement := `UPDATE "sensor" SET deleted_at=NOW() WHERE id_sensor=$1 AND deleted_at IS NULL`
 	res, err := tx.Exec(ctx, sqlStatement, id)
 	if err != nil {
 		return err
@@ -215,3 +440,38 @@ func (u *SensorRepository) Delete(ctx context.Context, tx helper.Querier, id int
 	}
 	return nil
 }
+
+// GetDeleted return the deleted sensor of every user, latest deleted first
+func (u *SensorRepository) GetDeleted(ctx context.Context, tx helper.Querier) (sensors []entities.DeletedSensor, err error) {
+	sensors = []entities.DeletedSensor{}
+	sqlStatement := fmt.Sprintf(`SELECT %s, sensor.deleted_at FROM "sensor" WHERE sensor.deleted_at IS NOT NULL ORDER BY sensor.deleted_at DESC, sensor.id_sensor`, u.sensorField())
+	rows, err := tx.Query(ctx, sqlStatement)
+	if err != nil {
+		return sensors, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sensor entities.DeletedSensor
+		err := rows.Scan(append(u.sensorPointer(&sensor.Sensor), &sensor.DeletedAt)...)
+		if err != nil {
+			return sensors, err
+		}
+		sensors = append(sensors, sensor)
+	}
+	return sensors, rows.Err()
+}
+
+// Restore bring back the deleted sensor, the sensor of a deleted node is restored with its node
+func (u *SensorRepository) Restore(ctx context.Context, tx helper.Querier, id int) (sensor entities.Sensor, err error) {
+	sqlStatement := fmt.Sprintf(`
+	UPDATE "sensor" SET deleted_at=NULL
+	FROM "node"
+	WHERE sensor.id_sensor=$1 AND sensor.deleted_at IS NOT NULL AND node.id_node=sensor.id_node AND node.deleted_at IS NULL
+	RETURNING %s`, u.sensorField())
+	err = tx.QueryRow(ctx, sqlStatement, id).Scan(u.sensorPointer(&sensor)...)
+	if err == pgx.ErrNoRows {
+		return sensor, fiber.NewError(404, fmt.Sprintf("Deleted sensor with id %d not found, or its node is deleted", id))
+	}
+	return sensor, err
+}
@@ -0,0 +1,196 @@
+package repositories
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// A session checked by Check is trusted this long without reading it again, so a revoked session
+// is refused by another instance within this time
+const sessionCheckCache = 30 * time.Second
+
+// SessionRepository keep the login session of the user. A session is revoked by deleting it, which
+// refuse its access token and refresh token
+type SessionRepository struct {
+	refreshDays int
+	// Time until each session is trusted without reading it
+	checked map[string]time.Time
+	mutex   *sync.Mutex
+}
+
+func NewSessionRepository(config *configs.Config) (SessionRepository, error) {
+	refreshDays := config.JWT.RefreshDays
+	if refreshDays <= 0 {
+		refreshDays = 30
+	}
+	return SessionRepository{
+		refreshDays: refreshDays,
+		checked:     map[string]time.Time{},
+		mutex:       &sync.Mutex{},
+	}, nil
+}
+
+func generateRefreshToken() (string, error) {
+	random := make([]byte, 32)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+	return entities.RefreshTokenPrefix + base64.RawURLEncoding.EncodeToString(random), nil
+}
+
+// Create start a session of the user and return its refresh token, only its hash is kept
+func (r *SessionRepository) Create(ctx context.Context, tx helper.Querier, userId int, userAgent string) (session entities.UserSession, refreshToken string, err error) {
+	refreshToken, err = generateRefreshToken()
+	if err != nil {
+		return session, "", err
+	}
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+
+	sqlStatement := `
+	INSERT INTO user_session (id_session, id_user, refresh_hash, user_agent) VALUES ($1, $2, $3, $4)
+	RETURNING id_session, id_user, user_agent, created_at, last_used_at`
+	err = tx.QueryRow(ctx, sqlStatement, uuid.New().String(), userId, hashApiKey(refreshToken), userAgent).Scan(
+		&session.IdSession, &session.IdUser, &session.UserAgent, &session.CreatedAt, &session.LastUsedAt)
+	return session, refreshToken, err
+}
+
+// Refresh replace the refresh token of its session with a new one, a refresh token is only used once
+func (r *SessionRepository) Refresh(ctx context.Context, tx helper.Querier, refreshToken string) (session entities.UserSession, newToken string, err error) {
+	newToken, err = generateRefreshToken()
+	if err != nil {
+		return session, "", err
+	}
+
+	sqlStatement := `
+	UPDATE user_session SET refresh_hash=$2, last_used_at=NOW()
+	WHERE refresh_hash=$1 AND last_used_at > NOW() - make_interval(days => $3)
+	RETURNING id_session, id_user, user_agent, created_at, last_used_at`
+	err = tx.QueryRow(ctx, sqlStatement, hashApiKey(refreshToken), hashApiKey(newToken), r.refreshDays).Scan(
+		&session.IdSession, &session.IdUser, &session.UserAgent, &session.CreatedAt, &session.LastUsedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return session, "", fiber.NewError(401, "Refresh token is not valid, expired or its session is revoked")
+	}
+	return session, newToken, err
+}
+
+// Check return a 401 when the session is revoked or expired. The last use is written at most once
+// an hour
+func (r *SessionRepository) Check(ctx context.Context, tx helper.Querier, sessionId string) error {
+	now := time.Now()
+	r.mutex.Lock()
+	until, ok := r.checked[sessionId]
+	r.mutex.Unlock()
+	if ok && now.Before(until) {
+		return nil
+	}
+
+	var stale bool
+	sqlStatement := `SELECT last_used_at < NOW() - INTERVAL '1 hour' FROM user_session WHERE id_session=$1 AND last_used_at > NOW() - make_interval(days => $2)`
+	err := tx.QueryRow(ctx, sqlStatement, sessionId, r.refreshDays).Scan(&stale)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fiber.NewError(401, "Session is expired or revoked, log in again")
+	}
+	if err != nil {
+		return err
+	}
+	if stale {
+		_, err = tx.Exec(ctx, `UPDATE user_session SET last_used_at=NOW() WHERE id_session=$1`, sessionId)
+		if err != nil {
+			return err
+		}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	// Expired entry is dropped when the cache grow, a session is only checked by a logged in user
+	if len(r.checked) > 10000 {
+		for id, until := range r.checked {
+			if now.After(until) {
+				delete(r.checked, id)
+			}
+		}
+	}
+	r.checked[sessionId] = now.Add(sessionCheckCache)
+	return nil
+}
+
+// GetByUser return the session of the user that isn't expired, last used first
+func (r *SessionRepository) GetByUser(ctx context.Context, tx helper.Querier, userId int) (sessions []entities.UserSession, err error) {
+	sqlStatement := `
+	SELECT id_session, id_user, user_agent, created_at, last_used_at FROM user_session
+	WHERE id_user=$1 AND last_used_at > NOW() - make_interval(days => $2)
+	ORDER BY last_used_at DESC`
+	rows, err := tx.Query(ctx, sqlStatement, userId, r.refreshDays)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions = []entities.UserSession{}
+	for rows.Next() {
+		var session entities.UserSession
+		err = rows.Scan(&session.IdSession, &session.IdUser, &session.UserAgent, &session.CreatedAt, &session.LastUsedAt)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// Revoke delete a session of the user, a 404 when the user has no such session
+func (r *SessionRepository) Revoke(ctx context.Context, tx helper.Querier, userId int, sessionId string) error {
+	res, err := tx.Exec(ctx, `DELETE FROM user_session WHERE id_session=$1 AND id_user=$2`, sessionId, userId)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return fiber.NewError(404, "Session not found")
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.checked, sessionId)
+	return nil
+}
+
+// RevokeAll delete every session of the user except the one of exceptId, which may be empty
+func (r *SessionRepository) RevokeAll(ctx context.Context, tx helper.Querier, userId int, exceptId string) (count int64, err error) {
+	res, err := tx.Exec(ctx, `DELETE FROM user_session WHERE id_user=$1 AND id_session<>$2`, userId, exceptId)
+	if err != nil {
+		return 0, err
+	}
+
+	// The session of the user aren't known here, so every session is checked again
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for id := range r.checked {
+		if id != exceptId {
+			delete(r.checked, id)
+		}
+	}
+	return res.RowsAffected(), nil
+}
+
+// DeleteExpired delete the session not used for refreshDays
+func (r *SessionRepository) DeleteExpired(ctx context.Context, tx helper.Querier) (count int64, err error) {
+	res, err := tx.Exec(ctx, `DELETE FROM user_session WHERE last_used_at <= NOW() - make_interval(days => $1)`, r.refreshDays)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), nil
+}
@@ -0,0 +1,62 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+// SigfoxRepository link the Sigfox device id to the node receiving its uplink
+type SigfoxRepository struct{}
+
+func NewSigfoxRepository() (SigfoxRepository, error) {
+	return SigfoxRepository{}, nil
+}
+
+// GetByNode return the device linked to the node, a nil device id when it has none
+func (r *SigfoxRepository) GetByNode(ctx context.Context, tx helper.Querier, nodeId int) (device entities.SigfoxDevice, err error) {
+	device = entities.SigfoxDevice{IdNode: nodeId}
+	err = tx.QueryRow(ctx, `SELECT device_id FROM node_sigfox WHERE id_node=$1`, nodeId).Scan(&device.DeviceId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return device, nil
+	}
+	return device, err
+}
+
+// SetDevice link the device to the node in place of its previous one, an empty device id unlink it.
+// A device linked to another node is a 409
+func (r *SigfoxRepository) SetDevice(ctx context.Context, tx helper.Querier, nodeId int, deviceId string) error {
+	_, err := tx.Exec(ctx, `DELETE FROM node_sigfox WHERE id_node=$1`, nodeId)
+	if err != nil || deviceId == "" {
+		return err
+	}
+
+	deviceId = strings.ToUpper(deviceId)
+	var otherNode int
+	err = tx.QueryRow(ctx, `SELECT id_node FROM node_sigfox WHERE device_id=$1`, deviceId).Scan(&otherNode)
+	if err == nil {
+		return fiber.NewError(409, fmt.Sprintf("Sigfox device %s is already linked to node %d", deviceId, otherNode))
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `INSERT INTO node_sigfox (device_id, id_node) VALUES ($1, $2)`, deviceId, nodeId)
+	return err
+}
+
+// GetNodeByDevice return the node the device is linked to
+func (r *SigfoxRepository) GetNodeByDevice(ctx context.Context, tx helper.Querier, deviceId string) (nodeId int, err error) {
+	deviceId = strings.ToUpper(deviceId)
+	err = tx.QueryRow(ctx, `SELECT id_node FROM node_sigfox WHERE device_id=$1`, deviceId).Scan(&nodeId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, fiber.NewError(404, fmt.Sprintf("Sigfox device %s is not linked to a node", deviceId))
+	}
+	return nodeId, err
+}
@@ -0,0 +1,249 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+)
+
+// slaOnlineWindow is how long a node is up after any of its sensor sent a channel, the same as the
+// online node status
+const slaOnlineWindow = 15 * time.Minute
+
+// SlaRepository keep the maintenance window of the node and compute their SLA report
+type SlaRepository struct {
+	channelRepository   *ChannelRepository
+	sensorRepository    *SensorRepository
+	dashboardRepository *DashboardRepository
+}
+
+func NewSlaRepository(channelRepository *ChannelRepository, sensorRepository *SensorRepository, dashboardRepository *DashboardRepository) (SlaRepository, error) {
+	return SlaRepository{
+		channelRepository:   channelRepository,
+		sensorRepository:    sensorRepository,
+		dashboardRepository: dashboardRepository,
+	}, nil
+}
+
+// GetMaintenances return the maintenance of the node overlapping [from, to), every maintenance when
+// they are nil, ordered by start
+func (r *SlaRepository) GetMaintenances(ctx context.Context, tx helper.Querier, nodeId int, from *time.Time, to *time.Time) (maintenances []entities.NodeMaintenance, err error) {
+	maintenances = []entities.NodeMaintenance{}
+	sqlStatement := `
+	SELECT id_maintenance, id_node, start_time, end_time, reason FROM node_maintenance
+	WHERE id_node=$1 AND ($2::TIMESTAMP IS NULL OR end_time > $2) AND ($3::TIMESTAMP IS NULL OR start_time < $3)
+	ORDER BY start_time, id_maintenance`
+	rows, err := tx.Query(ctx, sqlStatement, nodeId, from, to)
+	if err != nil {
+		return maintenances, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var maintenance entities.NodeMaintenance
+		err := rows.Scan(&maintenance.IdMaintenance, &maintenance.IdNode, &maintenance.Start, &maintenance.End, &maintenance.Reason)
+		if err != nil {
+			return maintenances, err
+		}
+		maintenances = append(maintenances, maintenance)
+	}
+	return maintenances, rows.Err()
+}
+
+func (r *SlaRepository) CreateMaintenance(ctx context.Context, tx helper.Querier, nodeId int, payload *entities.NodeMaintenanceCreate) (maintenance entities.NodeMaintenance, err error) {
+	maintenance = entities.NodeMaintenance{IdNode: nodeId, NodeMaintenanceCreate: *payload}
+	maintenance.Start = payload.Start.UTC()
+	maintenance.End = payload.End.UTC()
+	sqlStatement := `INSERT INTO node_maintenance (id_node, start_time, end_time, reason) VALUES ($1, $2, $3, $4) RETURNING id_maintenance`
+	err = tx.QueryRow(ctx, sqlStatement, nodeId, maintenance.Start, maintenance.End, maintenance.Reason).Scan(&maintenance.IdMaintenance)
+	return maintenance, err
+}
+
+func (r *SlaRepository) DeleteMaintenance(ctx context.Context, tx helper.Querier, nodeId int, id int) (err error) {
+	res, err := tx.Exec(ctx, `DELETE FROM node_maintenance WHERE id_maintenance=$1 AND id_node=$2`, id, nodeId)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return fiber.NewError(404, fmt.Sprintf("Maintenance with id %d not found", id))
+	}
+	return nil
+}
+
+// GetNodeReport compute the availability, downtime and alert of the node in [from, to)
+func (r *SlaRepository) GetNodeReport(ctx context.Context, tx helper.Querier, node entities.Node, from time.Time, to time.Time) (report entities.NodeSlaReport, err error) {
+	report = entities.NodeSlaReport{
+		Month:  from.Format("2006-01"),
+		IdNode: node.IdNode,
+		IdUser: node.IdUser,
+		Name:   node.Name,
+		From:   from,
+		To:     to,
+	}
+	period := entities.TimeWindow{Start: from, End: to}
+
+	report.Maintenances, err = r.GetMaintenances(ctx, tx, node.IdNode, &from, &to)
+	if err != nil {
+		return report, err
+	}
+	maintenances := []entities.TimeWindow{}
+	for _, maintenance := range report.Maintenances {
+		maintenances = append(maintenances, entities.TimeWindow{Start: maintenance.Start, End: maintenance.End})
+	}
+	maintenances = clipWindows(mergeWindows(maintenances), period)
+	expected := subtractWindows([]entities.TimeWindow{period}, maintenances)
+
+	sensors, err := r.sensorRepository.GetNodeSensor(ctx, tx, node.IdNode)
+	if err != nil {
+		return report, err
+	}
+	sensorIds := make([]int, len(sensors))
+	for i, sensor := range sensors {
+		sensorIds[i] = sensor.IdSensor
+	}
+
+	// The node is up for slaOnlineWindow after each channel of any sensor, of any name and quality
+	up := []entities.TimeWindow{}
+	since := from.Add(-slaOnlineWindow)
+	for _, sensorId := range sensorIds {
+		sensorUp := []entities.TimeWindow{}
+		err = r.channelRepository.ForEachRawBySensor(ctx, tx, sensorId, entities.ChannelQuery{From: &since, To: &to}, func(channel entities.Channel) error {
+			window := entities.TimeWindow{Start: channel.Time.UTC(), End: channel.Time.UTC().Add(slaOnlineWindow)}
+			last := len(sensorUp) - 1
+			if last >= 0 && !window.Start.After(sensorUp[last].End) {
+				sensorUp[last].End = window.End
+				return nil
+			}
+			sensorUp = append(sensorUp, window)
+			return nil
+		})
+		if err != nil {
+			return report, err
+		}
+		up = append(up, sensorUp...)
+	}
+	up = mergeWindows(up)
+
+	report.Downtimes = subtractWindows(expected, up)
+	report.DowntimeSeconds = int64(windowsDuration(report.Downtimes).Seconds())
+	report.MaintenanceSeconds = int64(windowsDuration(maintenances).Seconds())
+	report.Availability = 100
+	if expectedDuration := windowsDuration(expected); expectedDuration > 0 {
+		report.Availability = 100 * (1 - windowsDuration(report.Downtimes).Seconds()/expectedDuration.Seconds())
+	}
+
+	alertWidgets, err := r.dashboardRepository.GetAlertWidgetsBySensors(ctx, tx, sensorIds)
+	if err != nil {
+		return report, err
+	}
+	for sensorId, widgets := range alertWidgets {
+		for _, widget := range widgets {
+			for _, window := range expected {
+				count, err := r.channelRepository.CountOutOfRangeBySensor(ctx, tx, sensorId, widget.Options.Min, widget.Options.Max, window.Start, window.End)
+				if err != nil {
+					return report, err
+				}
+				report.Alerts += count
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// GetMonthReports return the report of every node in the month, up to now for the current month
+func (r *SlaRepository) GetMonthReports(ctx context.Context, tx helper.Querier, nodes []entities.Node, month time.Time, now time.Time) (reports []entities.NodeSlaReport, err error) {
+	reports = []entities.NodeSlaReport{}
+	from := UsageMonth(month)
+	to := from.AddDate(0, 1, 0)
+	if to.After(now) {
+		to = now.UTC()
+	}
+	if !to.After(from) {
+		return reports, fiber.NewError(400, "month must not be in the future")
+	}
+
+	for _, node := range nodes {
+		report, err := r.GetNodeReport(ctx, tx, node, from, to)
+		if err != nil {
+			return reports, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// mergeWindows sort the windows and join the overlapping or touching one
+func mergeWindows(windows []entities.TimeWindow) []entities.TimeWindow {
+	sorted := append([]entities.TimeWindow{}, windows...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Start.Before(sorted[j].Start)
+	})
+
+	merged := []entities.TimeWindow{}
+	for _, window := range sorted {
+		last := len(merged) - 1
+		if last >= 0 && !window.Start.After(merged[last].End) {
+			if window.End.After(merged[last].End) {
+				merged[last].End = window.End
+			}
+			continue
+		}
+		merged = append(merged, window)
+	}
+	return merged
+}
+
+// clipWindows cut the windows to the period, the window outside it is left out
+func clipWindows(windows []entities.TimeWindow, period entities.TimeWindow) []entities.TimeWindow {
+	clipped := []entities.TimeWindow{}
+	for _, window := range windows {
+		if window.Start.Before(period.Start) {
+			window.Start = period.Start
+		}
+		if window.End.After(period.End) {
+			window.End = period.End
+		}
+		if window.Start.Before(window.End) {
+			clipped = append(clipped, window)
+		}
+	}
+	return clipped
+}
+
+// subtractWindows return the part of the sorted merged windows not covered by the sorted merged cut
+func subtractWindows(windows []entities.TimeWindow, cut []entities.TimeWindow) []entities.TimeWindow {
+	result := []entities.TimeWindow{}
+	for _, window := range windows {
+		start := window.Start
+		for _, c := range cut {
+			if !c.End.After(start) {
+				continue
+			}
+			if !c.Start.Before(window.End) {
+				break
+			}
+			if c.Start.After(start) {
+				result = append(result, entities.TimeWindow{Start: start, End: c.Start})
+			}
+			start = c.End
+		}
+		if start.Before(window.End) {
+			result = append(result, entities.TimeWindow{Start: start, End: window.End})
+		}
+	}
+	return result
+}
+
+func windowsDuration(windows []entities.TimeWindow) time.Duration {
+	var total time.Duration
+	for _, window := range windows {
+		total += window.End.Sub(window.Start)
+	}
+	return total
+}
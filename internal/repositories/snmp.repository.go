@@ -0,0 +1,127 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/jackc/pgx/v5"
+)
+
+// SnmpRepository keep the SNMP device of each sensor and the OID mapped to its channel
+type SnmpRepository struct{}
+
+func NewSnmpRepository() (SnmpRepository, error) {
+	return SnmpRepository{}, nil
+}
+
+// GetBySensor return the device of the sensor with its item ordered by channel name, a sensor
+// without device has no item
+func (r *SnmpRepository) GetBySensor(ctx context.Context, tx helper.Querier, sensorId int) (device entities.SensorSnmp, err error) {
+	device.Items = []entities.SensorSnmpItem{}
+	sqlStatement := `SELECT address, version, username, auth_protocol, priv_protocol FROM sensor_snmp WHERE id_sensor=$1`
+	err = tx.QueryRow(ctx, sqlStatement, sensorId).Scan(&device.Address, &device.Version, &device.Username, &device.AuthProtocol, &device.PrivProtocol)
+	if err == pgx.ErrNoRows {
+		return device, nil
+	}
+	if err != nil {
+		return device, err
+	}
+
+	rows, err := tx.Query(ctx, `SELECT name, oid, status FROM sensor_snmp_item WHERE id_sensor=$1 ORDER BY name`, sensorId)
+	if err != nil {
+		return device, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := entities.SensorSnmpItem{}
+		err = rows.Scan(&item.Name, &item.Oid, &item.Status)
+		if err != nil {
+			return device, err
+		}
+		device.Items = append(device.Items, item)
+	}
+	return device, rows.Err()
+}
+
+// Update replace the device and item of the sensor, no item remove the device. An item keep its
+// status when its OID is unchanged
+func (r *SnmpRepository) Update(ctx context.Context, tx helper.Querier, sensorId int, payload *entities.SensorSnmpUpdate) error {
+	if len(payload.Items) == 0 {
+		_, err := tx.Exec(ctx, `DELETE FROM sensor_snmp WHERE id_sensor=$1`, sensorId)
+		return err
+	}
+
+	sqlStatement := `
+	INSERT INTO sensor_snmp (id_sensor, address, version, community, username, auth_protocol, auth_password, priv_protocol, priv_password)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	ON CONFLICT (id_sensor) DO UPDATE SET address=EXCLUDED.address, version=EXCLUDED.version, community=EXCLUDED.community,
+		username=EXCLUDED.username, auth_protocol=EXCLUDED.auth_protocol, auth_password=EXCLUDED.auth_password,
+		priv_protocol=EXCLUDED.priv_protocol, priv_password=EXCLUDED.priv_password`
+	_, err := tx.Exec(ctx, sqlStatement, sensorId, payload.Address, payload.Version, payload.Community, payload.Username,
+		payload.AuthProtocol, payload.AuthPassword, payload.PrivProtocol, payload.PrivPassword)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(payload.Items))
+	for _, item := range payload.Items {
+		names = append(names, item.Name)
+	}
+	_, err = tx.Exec(ctx, `DELETE FROM sensor_snmp_item WHERE id_sensor=$1 AND NOT (name = ANY($2))`, sensorId, names)
+	if err != nil {
+		return err
+	}
+
+	sqlStatement = `
+	INSERT INTO sensor_snmp_item (id_sensor, name, oid, status) VALUES ($1, $2, $3, $4)
+	ON CONFLICT (id_sensor, name) DO UPDATE SET oid=EXCLUDED.oid,
+		status=CASE WHEN sensor_snmp_item.oid=EXCLUDED.oid THEN sensor_snmp_item.status ELSE EXCLUDED.status END`
+	for _, item := range payload.Items {
+		_, err = tx.Exec(ctx, sqlStatement, sensorId, item.Name, item.Oid, entities.SnmpPending)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetAll return every device with its item and the owner of its sensor, for the poller
+func (r *SnmpRepository) GetAll(ctx context.Context, tx helper.Querier) (devices []entities.SnmpDevice, err error) {
+	sqlStatement := `
+	SELECT d.id_sensor, n.id_user, d.address, d.version, d.community, d.username, d.auth_protocol, d.auth_password,
+		d.priv_protocol, d.priv_password, i.id_item, i.name, i.oid, i.status
+	FROM sensor_snmp d JOIN sensor_snmp_item i ON i.id_sensor=d.id_sensor
+	JOIN sensor s ON s.id_sensor=d.id_sensor JOIN node n ON n.id_node=s.id_node
+	WHERE s.deleted_at IS NULL AND n.deleted_at IS NULL
+	ORDER BY d.id_sensor, i.id_item`
+	rows, err := tx.Query(ctx, sqlStatement)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		device := entities.SnmpDevice{}
+		item := entities.SnmpItem{}
+		err = rows.Scan(&device.IdSensor, &device.IdUser, &device.Address, &device.Version, &device.Community, &device.Username,
+			&device.AuthProtocol, &device.AuthPassword, &device.PrivProtocol, &device.PrivPassword,
+			&item.IdItem, &item.Name, &item.Oid, &item.Status)
+		if err != nil {
+			return nil, err
+		}
+		if len(devices) == 0 || devices[len(devices)-1].IdSensor != device.IdSensor {
+			devices = append(devices, device)
+		}
+		last := &devices[len(devices)-1]
+		last.Items = append(last.Items, item)
+	}
+	return devices, rows.Err()
+}
+
+// SetStatus record the result of the last read of the item
+func (r *SnmpRepository) SetStatus(ctx context.Context, tx helper.Querier, itemId int, status string) error {
+	_, err := tx.Exec(ctx, `UPDATE sensor_snmp_item SET status=$1 WHERE id_item=$2`, status, itemId)
+	return err
+}
@@ -0,0 +1,215 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+)
+
+type StatsRepository struct{}
+
+func NewStatsRepository() (StatsRepository, error) {
+	return StatsRepository{}, nil
+}
+
+// Table reported on the storage section, the channel table is where the growth happen
+var statsTables = []string{"channel", "sensor", "node", "user_person", "dashboard_widget", "notification"}
+
+func (s *StatsRepository) GetTotals(ctx context.Context, tx helper.Querier, stats *entities.PlatformStats) (err error) {
+	// The channel count use the planner estimate because counting a big table is slow
+	sqlStatement := `
+	SELECT
+		(SELECT COUNT(*) FROM user_person),
+		(SELECT COUNT(*) FROM "node" WHERE deleted_at IS NULL),
+		(SELECT COUNT(*) FROM sensor WHERE deleted_at IS NULL),
+		(SELECT GREATEST(reltuples, 0)::BIGINT FROM pg_class WHERE relname = 'channel')`
+	return tx.QueryRow(ctx, sqlStatement).Scan(&stats.Users, &stats.Nodes, &stats.Sensors, &stats.Channels)
+}
+
+// CountChannelSince count the channel with time after since, channel time is stored in UTC
+func (s *StatsRepository) CountChannelSince(ctx context.Context, tx helper.Querier, since time.Time) (count int64, err error) {
+	sqlStatement := `SELECT COUNT(*) FROM channel WHERE time >= $1`
+	err = tx.QueryRow(ctx, sqlStatement, since).Scan(&count)
+	return count, err
+}
+
+// GetChannelBuckets count the channel per bucket (hour, day) since the given time
+func (s *StatsRepository) GetChannelBuckets(ctx context.Context, tx helper.Querier, bucket string, since time.Time) (buckets []entities.StatsBucket, err error) {
+	buckets = []entities.StatsBucket{}
+	sqlStatement := `
+	SELECT date_trunc($1, time) AS bucket, COUNT(*)
+	FROM channel
+	WHERE time >= $2
+	GROUP BY bucket
+	ORDER BY bucket`
+	rows, err := tx.Query(ctx, sqlStatement, bucket, since)
+	if err != nil {
+		return buckets, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var b entities.StatsBucket
+		err := rows.Scan(&b.Time, &b.Count)
+		if err != nil {
+			return buckets, err
+		}
+		buckets = append(buckets, b)
+	}
+	if err := rows.Err(); err != nil {
+		return buckets, err
+	}
+	return buckets, nil
+}
+
+func (s *StatsRepository) GetTableSizes(ctx context.Context, tx helper.Querier) (sizes []entities.TableSize, err error) {
+	sizes = []entities.TableSize{}
+	sqlStatement := `
+	SELECT relname, pg_total_relation_size(oid)
+	FROM pg_class
+	WHERE relkind = 'r' AND relname = ANY($1)
+	ORDER BY pg_total_relation_size(oid) DESC`
+	rows, err := tx.Query(ctx, sqlStatement, statsTables)
+	if err != nil {
+		return sizes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var size entities.TableSize
+		err := rows.Scan(&size.Name, &size.Bytes)
+		if err != nil {
+			return sizes, err
+		}
+		sizes = append(sizes, size)
+	}
+	if err := rows.Err(); err != nil {
+		return sizes, err
+	}
+	return sizes, nil
+}
+
+func (s *StatsRepository) GetTopTalkers(ctx context.Context, tx helper.Querier, since time.Time, limit int) (talkers []entities.TopTalker, err error) {
+	talkers = []entities.TopTalker{}
+	sqlStatement := `
+	SELECT s.id_sensor, s.name, n.id_node, n.name, u.username, t.count
+	FROM (
+		SELECT id_sensor, COUNT(*) AS count
+		FROM channel
+		WHERE time >= $1
+		GROUP BY id_sensor
+		ORDER BY count DESC
+		LIMIT $2
+	) t
+	INNER JOIN sensor s ON s.id_sensor = t.id_sensor
+	INNER JOIN "node" n ON n.id_node = s.id_node
+	INNER JOIN user_person u ON u.id_user = n.id_user
+	ORDER BY t.count DESC`
+	rows, err := tx.Query(ctx, sqlStatement, since, limit)
+	if err != nil {
+		return talkers, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var talker entities.TopTalker
+		err := rows.Scan(&talker.IdSensor, &talker.Sensor, &talker.IdNode, &talker.Node, &talker.Username, &talker.Count)
+		if err != nil {
+			return talkers, err
+		}
+		talkers = append(talkers, talker)
+	}
+	if err := rows.Err(); err != nil {
+		return talkers, err
+	}
+	return talkers, nil
+}
+
+// GetChannelSize return the size of the channel table with its index and its exact row count
+func (s *StatsRepository) GetChannelSize(ctx context.Context, tx helper.Querier) (bytes int64, count int64, err error) {
+	sqlStatement := `SELECT pg_total_relation_size('channel'), (SELECT COUNT(*) FROM channel)`
+	err = tx.QueryRow(ctx, sqlStatement).Scan(&bytes, &count)
+	return bytes, count, err
+}
+
+// GetUserStorage count the node, sensor and channel of each user, biggest first.
+// idUser 0 return every user
+func (s *StatsRepository) GetUserStorage(ctx context.Context, tx helper.Querier, idUser int) (users []entities.UserStorage, err error) {
+	users = []entities.UserStorage{}
+	sqlStatement := `
+	WITH channel_count AS (
+		SELECT id_sensor, COUNT(*) AS channels FROM channel GROUP BY id_sensor
+	)
+	SELECT u.id_user, u.username, COUNT(DISTINCT n.id_node), COUNT(s.id_sensor), COALESCE(SUM(cc.channels), 0)
+	FROM user_person u
+	LEFT JOIN "node" n ON n.id_user = u.id_user
+	LEFT JOIN sensor s ON s.id_node = n.id_node
+	LEFT JOIN channel_count cc ON cc.id_sensor = s.id_sensor
+	WHERE $1 = 0 OR u.id_user = $1
+	GROUP BY u.id_user, u.username
+	ORDER BY 5 DESC, u.id_user`
+	rows, err := tx.Query(ctx, sqlStatement, idUser)
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user entities.UserStorage
+		err := rows.Scan(&user.IdUser, &user.Username, &user.Nodes, &user.Sensors, &user.Channels)
+		if err != nil {
+			return users, err
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return users, err
+	}
+	return users, nil
+}
+
+// GetNodeStorage count the channel of every sensor of the user, grouped per node
+func (s *StatsRepository) GetNodeStorage(ctx context.Context, tx helper.Querier, idUser int) (nodes []entities.NodeStorage, err error) {
+	nodes = []entities.NodeStorage{}
+	sqlStatement := `
+	SELECT n.id_node, n.name, s.id_sensor, s.name, COALESCE(cc.channels, 0)
+	FROM "node" n
+	LEFT JOIN sensor s ON s.id_node = n.id_node
+	LEFT JOIN LATERAL (
+		SELECT COUNT(*) AS channels FROM channel c WHERE c.id_sensor = s.id_sensor
+	) cc ON TRUE
+	WHERE n.id_user = $1
+	ORDER BY n.id_node, s.id_sensor`
+	rows, err := tx.Query(ctx, sqlStatement, idUser)
+	if err != nil {
+		return nodes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var idNode int
+		var nodeName string
+		var idSensor *int
+		var sensorName *string
+		var channels int64
+		err := rows.Scan(&idNode, &nodeName, &idSensor, &sensorName, &channels)
+		if err != nil {
+			return nodes, err
+		}
+
+		if len(nodes) == 0 || nodes[len(nodes)-1].IdNode != idNode {
+			nodes = append(nodes, entities.NodeStorage{IdNode: idNode, Name: nodeName, Sensors: []entities.SensorStorage{}})
+		}
+		node := &nodes[len(nodes)-1]
+		if idSensor != nil {
+			node.Channels += channels
+			node.Sensors = append(node.Sensors, entities.SensorStorage{IdSensor: *idSensor, Name: *sensorName, Channels: channels})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nodes, err
+	}
+	return nodes, nil
+}
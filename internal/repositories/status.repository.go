@@ -0,0 +1,55 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+// StatusPageRepository keep the public status page of the node
+type StatusPageRepository struct{}
+
+func NewStatusPageRepository() (StatusPageRepository, error) {
+	return StatusPageRepository{}, nil
+}
+
+func (r *StatusPageRepository) GetByNode(ctx context.Context, tx helper.Querier, nodeId int) (page entities.NodeStatusPage, err error) {
+	err = tx.QueryRow(ctx, `SELECT id_node, token, id_sensors FROM node_status_page WHERE id_node=$1`, nodeId).Scan(&page.IdNode, &page.Token, &page.IdSensors)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return page, fiber.NewError(404, fmt.Sprintf("Node %d has no status page", nodeId))
+	}
+	return page, err
+}
+
+func (r *StatusPageRepository) GetByToken(ctx context.Context, tx helper.Querier, token string) (page entities.NodeStatusPage, err error) {
+	err = tx.QueryRow(ctx, `SELECT id_node, token, id_sensors FROM node_status_page WHERE token=$1`, token).Scan(&page.IdNode, &page.Token, &page.IdSensors)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return page, fiber.NewError(404, "Status page not found or no longer shared")
+	}
+	return page, err
+}
+
+// Save create or replace the status page of the node
+func (r *StatusPageRepository) Save(ctx context.Context, tx helper.Querier, page *entities.NodeStatusPage) error {
+	sqlStatement := `
+	INSERT INTO node_status_page (id_node, token, id_sensors) VALUES ($1, $2, $3)
+	ON CONFLICT (id_node) DO UPDATE SET token=EXCLUDED.token, id_sensors=EXCLUDED.id_sensors`
+	_, err := tx.Exec(ctx, sqlStatement, page.IdNode, page.Token, page.IdSensors)
+	return err
+}
+
+func (r *StatusPageRepository) Delete(ctx context.Context, tx helper.Querier, nodeId int) error {
+	res, err := tx.Exec(ctx, `DELETE FROM node_status_page WHERE id_node=$1`, nodeId)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return fiber.NewError(404, fmt.Sprintf("Node %d has no status page", nodeId))
+	}
+	return nil
+}
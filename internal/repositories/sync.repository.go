@@ -0,0 +1,717 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+// SyncRepository keep the push state of an edge instance, and the site and id mapping of the edge
+// pushing to a central instance
+type SyncRepository struct {
+	hardwareRepository   *HardwareRepository
+	nodeRepository       *NodeRepository
+	sensorRepository     *SensorRepository
+	historyRepository    *HistoryRepository
+	transformRepository  *TransformRepository
+	validationRepository *ValidationRepository
+}
+
+func NewSyncRepository(hardwareRepository *HardwareRepository, nodeRepository *NodeRepository, sensorRepository *SensorRepository, historyRepository *HistoryRepository, transformRepository *TransformRepository, validationRepository *ValidationRepository) (SyncRepository, error) {
+	return SyncRepository{
+		hardwareRepository:   hardwareRepository,
+		nodeRepository:       nodeRepository,
+		sensorRepository:     sensorRepository,
+		historyRepository:    historyRepository,
+		transformRepository:  transformRepository,
+		validationRepository: validationRepository,
+	}, nil
+}
+
+// GetState return the push state of the site, without cursor when it never pushed
+func (r *SyncRepository) GetState(ctx context.Context, tx helper.Querier, site string) (status entities.SyncStatus, err error) {
+	status = entities.SyncStatus{Site: site}
+	sqlStatement := `SELECT cursor_time, last_attempt_at, last_success_at, last_error, pushed FROM sync_state WHERE site=$1`
+	err = tx.QueryRow(ctx, sqlStatement, site).Scan(&status.Cursor, &status.LastAttemptAt, &status.LastSuccessAt, &status.LastError, &status.Pushed)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return status, nil
+	}
+	return status, err
+}
+
+// SaveFailure keep the error of the push, the cursor stay where it is so the channel is pushed again
+func (r *SyncRepository) SaveFailure(ctx context.Context, tx helper.Querier, site string, pushErr error) error {
+	sqlStatement := `
+	INSERT INTO sync_state (site, last_attempt_at, last_error) VALUES ($1, NOW(), $2)
+	ON CONFLICT (site) DO UPDATE SET last_attempt_at=EXCLUDED.last_attempt_at, last_error=EXCLUDED.last_error`
+	_, err := tx.Exec(ctx, sqlStatement, site, pushErr.Error())
+	return err
+}
+
+// SaveSuccess move the cursor after the pushed channel
+func (r *SyncRepository) SaveSuccess(ctx context.Context, tx helper.Querier, site string, cursor *time.Time, pushed int) error {
+	sqlStatement := `
+	INSERT INTO sync_state (site, cursor_time, last_attempt_at, last_success_at, pushed) VALUES ($1, $2, NOW(), NOW(), $3)
+	ON CONFLICT (site) DO UPDATE SET cursor_time=EXCLUDED.cursor_time, last_attempt_at=EXCLUDED.last_attempt_at,
+		last_success_at=EXCLUDED.last_success_at, last_error=NULL, pushed=sync_state.pushed + EXCLUDED.pushed`
+	_, err := tx.Exec(ctx, sqlStatement, site, cursor, pushed)
+	return err
+}
+
+// GetEntities add every hardware, node and sensor of this instance to the batch
+func (r *SyncRepository) GetEntities(ctx context.Context, tx helper.Querier, batch *entities.SyncBatch) error {
+	rows, err := tx.Query(ctx, `SELECT id_hardware, name, type, description FROM hardware ORDER BY id_hardware`)
+	if err != nil {
+		return err
+	}
+	batch.Hardware = []entities.SyncHardware{}
+	for rows.Next() {
+		var hardware entities.SyncHardware
+		err = rows.Scan(&hardware.Id, &hardware.Name, &hardware.Type, &hardware.Description)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		batch.Hardware = append(batch.Hardware, hardware)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = tx.Query(ctx, `SELECT id_node, name, location, id_hardware FROM node ORDER BY id_node`)
+	if err != nil {
+		return err
+	}
+	batch.Nodes = []entities.SyncNode{}
+	for rows.Next() {
+		var node entities.SyncNode
+		err = rows.Scan(&node.Id, &node.Name, &node.Location, &node.IdHardware)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		batch.Nodes = append(batch.Nodes, node)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = tx.Query(ctx, `SELECT id_sensor, name, unit, id_node, id_hardware FROM sensor ORDER BY id_sensor`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	batch.Sensors = []entities.SyncSensor{}
+	for rows.Next() {
+		var sensor entities.SyncSensor
+		err = rows.Scan(&sensor.Id, &sensor.Name, &sensor.Unit, &sensor.IdNode, &sensor.IdHardware)
+		if err != nil {
+			return err
+		}
+		batch.Sensors = append(batch.Sensors, sensor)
+	}
+	return rows.Err()
+}
+
+// GetChannelsAfter return up to limit channel stored in (after, until] by time order, from the
+// start when after is nil. It read each sensor with its (id_sensor, time) index
+func (r *SyncRepository) GetChannelsAfter(ctx context.Context, tx helper.Querier, after *time.Time, until time.Time, limit int) (channels []entities.SyncChannel, err error) {
+	from := time.Time{}
+	if after != nil {
+		from = *after
+	}
+	sqlStatement := `
+	SELECT c.id_sensor, c.time, c.name, c.value, c.quality
+	FROM sensor s
+	CROSS JOIN LATERAL (
+		SELECT id_sensor, time, name, value, quality FROM channel
+		WHERE id_sensor = s.id_sensor AND time > $1 AND time <= $2
+		ORDER BY time LIMIT $3
+	) c
+	ORDER BY c.time, c.id_sensor
+	LIMIT $3`
+	rows, err := tx.Query(ctx, sqlStatement, from, until, limit)
+	if err != nil {
+		return channels, err
+	}
+	defer rows.Close()
+
+	channels = []entities.SyncChannel{}
+	for rows.Next() {
+		var channel entities.SyncChannel
+		err = rows.Scan(&channel.IdSensor, &channel.Time, &channel.Name, &channel.Value, &channel.Quality)
+		if err != nil {
+			return channels, err
+		}
+		channels = append(channels, channel)
+	}
+	return channels, rows.Err()
+}
+
+// CountChannelsAfter count the channel stored after the cursor, every channel when it is nil
+func (r *SyncRepository) CountChannelsAfter(ctx context.Context, tx helper.Querier, after *time.Time) (count int64, err error) {
+	from := time.Time{}
+	if after != nil {
+		from = *after
+	}
+	err = tx.QueryRow(ctx, `SELECT COUNT(*) FROM channel WHERE time > $1`, from).Scan(&count)
+	return count, err
+}
+
+// GetSites return the site pushed by the user with the number of synced node and sensor
+func (r *SyncRepository) GetSites(ctx context.Context, tx helper.Querier, idUser int) (sites []entities.SyncSite, err error) {
+	sqlStatement := `
+	SELECT s.site, s.id_user, s.conflict, s.last_sync_at, s.channels,
+		(SELECT COUNT(*) FROM sync_mapping m WHERE m.site = s.site AND m.entity = 'node'),
+		(SELECT COUNT(*) FROM sync_mapping m WHERE m.site = s.site AND m.entity = 'sensor')
+	FROM sync_site s WHERE s.id_user = $1 ORDER BY s.site`
+	rows, err := tx.Query(ctx, sqlStatement, idUser)
+	if err != nil {
+		return sites, err
+	}
+	defer rows.Close()
+
+	sites = []entities.SyncSite{}
+	for rows.Next() {
+		var site entities.SyncSite
+		err = rows.Scan(&site.Site, &site.IdUser, &site.Conflict, &site.LastSyncAt, &site.Channels, &site.Nodes, &site.Sensors)
+		if err != nil {
+			return sites, err
+		}
+		sites = append(sites, site)
+	}
+	return sites, rows.Err()
+}
+
+func (r *SyncRepository) GetSite(ctx context.Context, tx helper.Querier, name string) (site entities.SyncSite, err error) {
+	sqlStatement := `SELECT site, id_user, conflict, last_sync_at, channels FROM sync_site WHERE site=$1`
+	err = tx.QueryRow(ctx, sqlStatement, name).Scan(&site.Site, &site.IdUser, &site.Conflict, &site.LastSyncAt, &site.Channels)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return site, fiber.NewError(404, fmt.Sprintf("Site %s not found", name))
+	}
+	return site, err
+}
+
+func (r *SyncRepository) SetConflict(ctx context.Context, tx helper.Querier, name string, conflict string) error {
+	_, err := tx.Exec(ctx, `UPDATE sync_site SET conflict=$1 WHERE site=$2`, conflict, name)
+	return err
+}
+
+// DeleteSite forget the site and its id mapping, the synced entity and channel are kept. A later
+// push of the site create its entity again
+func (r *SyncRepository) DeleteSite(ctx context.Context, tx helper.Querier, name string) error {
+	_, err := tx.Exec(ctx, `DELETE FROM sync_site WHERE site=$1`, name)
+	return err
+}
+
+// scanIds return the single integer column of the query
+func scanIds(ctx context.Context, tx helper.Querier, sqlStatement string, args ...interface{}) (ids []int, err error) {
+	rows, err := tx.Query(ctx, sqlStatement, args...)
+	if err != nil {
+		return ids, err
+	}
+	defer rows.Close()
+
+	ids = []int{}
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetDelta add to the delta the hardware, node and sensor visible to the user that changed in
+// (since, until] by their history, every one of them when since is nil. A node or sensor is deleted
+// for the user when it changed and is no longer visible, while it belonged to the user before
+func (r *SyncRepository) GetDelta(ctx context.Context, tx helper.Querier, currentUser entities.UserRead, since *time.Time, until time.Time, delta *entities.SyncDelta) error {
+	hardwareSql := `SELECT id_hardware, name, type, description FROM hardware`
+	nodeSql := `SELECT n.id_node, n.name, n.location, n.id_hardware, n.id_user FROM node n WHERE n.deleted_at IS NULL AND ($1 OR n.id_user = $2)`
+	sensorSql := `SELECT s.id_sensor, s.name, s.unit, s.id_node, s.id_hardware FROM sensor s JOIN node n ON n.id_node = s.id_node WHERE s.deleted_at IS NULL AND n.deleted_at IS NULL AND ($1 OR n.id_user = $2)`
+	hardwareArgs := []interface{}{}
+	args := []interface{}{currentUser.IsAdmin, currentUser.IdUser}
+	if since != nil {
+		hardwareSql += ` WHERE id_hardware IN (SELECT id_entity FROM entity_revision WHERE entity_type = 'hardware' AND changed_at > $1 AND changed_at <= $2)`
+		nodeSql += ` AND n.id_node IN (SELECT id_entity FROM entity_revision WHERE entity_type = 'node' AND changed_at > $3 AND changed_at <= $4)`
+		sensorSql += ` AND (s.id_sensor IN (SELECT id_entity FROM entity_revision WHERE entity_type = 'sensor' AND changed_at > $3 AND changed_at <= $4)
+			OR s.id_node IN (SELECT id_entity FROM entity_revision WHERE entity_type = 'node' AND changed_at > $3 AND changed_at <= $4))`
+		hardwareArgs = []interface{}{*since, until}
+		args = append(args, *since, until)
+	}
+
+	rows, err := tx.Query(ctx, hardwareSql+` ORDER BY id_hardware`, hardwareArgs...)
+	if err != nil {
+		return err
+	}
+	delta.Hardware = []entities.Hardware{}
+	for rows.Next() {
+		var hardware entities.Hardware
+		err = rows.Scan(&hardware.IdHardware, &hardware.Name, &hardware.Type, &hardware.Description)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		delta.Hardware = append(delta.Hardware, hardware)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = tx.Query(ctx, nodeSql+` ORDER BY n.id_node`, args...)
+	if err != nil {
+		return err
+	}
+	delta.Nodes = []entities.Node{}
+	for rows.Next() {
+		var node entities.Node
+		err = rows.Scan(&node.IdNode, &node.Name, &node.Location, &node.IdHardware, &node.IdUser)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		delta.Nodes = append(delta.Nodes, node)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = tx.Query(ctx, sensorSql+` ORDER BY s.id_sensor`, args...)
+	if err != nil {
+		return err
+	}
+	delta.Sensors = []entities.Sensor{}
+	for rows.Next() {
+		var sensor entities.Sensor
+		err = rows.Scan(&sensor.IdSensor, &sensor.Name, &sensor.Unit, &sensor.IdNode, &sensor.IdHardware)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		delta.Sensors = append(delta.Sensors, sensor)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	delta.Deleted = entities.SyncDeleted{Hardware: []int{}, Nodes: []int{}, Sensors: []int{}}
+	if since == nil {
+		return nil
+	}
+
+	sqlStatement := `
+	SELECT DISTINCT r.id_entity FROM entity_revision r
+	WHERE r.entity_type = 'hardware' AND r.changed_at > $1 AND r.changed_at <= $2
+		AND NOT EXISTS (SELECT 1 FROM hardware h WHERE h.id_hardware = r.id_entity)
+	ORDER BY r.id_entity`
+	delta.Deleted.Hardware, err = scanIds(ctx, tx, sqlStatement, hardwareArgs...)
+	if err != nil {
+		return err
+	}
+
+	sqlStatement = `
+	SELECT DISTINCT r.id_entity FROM entity_revision r
+	WHERE r.entity_type = 'node' AND r.changed_at > $3 AND r.changed_at <= $4
+		AND ($1 OR EXISTS (SELECT 1 FROM entity_revision o WHERE o.entity_type = 'node' AND o.id_entity = r.id_entity AND (o.data->>'id_user')::INTEGER = $2))
+		AND NOT EXISTS (SELECT 1 FROM node n WHERE n.id_node = r.id_entity AND n.deleted_at IS NULL AND ($1 OR n.id_user = $2))
+	ORDER BY r.id_entity`
+	delta.Deleted.Nodes, err = scanIds(ctx, tx, sqlStatement, args...)
+	if err != nil {
+		return err
+	}
+
+	sqlStatement = `
+	SELECT DISTINCT r.id_entity FROM entity_revision r
+	WHERE r.entity_type = 'sensor' AND r.changed_at > $3 AND r.changed_at <= $4
+		AND ($1 OR EXISTS (
+			SELECT 1 FROM entity_revision o
+			JOIN entity_revision p ON p.entity_type = 'node' AND p.id_entity = (o.data->>'id_node')::INTEGER
+			WHERE o.entity_type = 'sensor' AND o.id_entity = r.id_entity AND (p.data->>'id_user')::INTEGER = $2))
+		AND NOT EXISTS (SELECT 1 FROM sensor s JOIN node n ON n.id_node = s.id_node WHERE s.id_sensor = r.id_entity AND s.deleted_at IS NULL AND n.deleted_at IS NULL AND ($1 OR n.id_user = $2))
+	ORDER BY r.id_entity`
+	delta.Deleted.Sensors, err = scanIds(ctx, tx, sqlStatement, args...)
+	return err
+}
+
+// GetUserChannelsAfter return up to limit channel of the sensor visible to the user stored in
+// (after, until] by time order
+func (r *SyncRepository) GetUserChannelsAfter(ctx context.Context, tx helper.Querier, currentUser entities.UserRead, after time.Time, until time.Time, limit int) (channels []entities.SyncChannel, err error) {
+	sqlStatement := `
+	SELECT c.id_sensor, c.time, c.name, c.value
+	FROM sensor s
+	JOIN node n ON n.id_node = s.id_node
+	CROSS JOIN LATERAL (
+		SELECT id_sensor, time, name, value FROM channel
+		WHERE id_sensor = s.id_sensor AND time > $3 AND time <= $4
+		ORDER BY time LIMIT $5
+	) c
+	WHERE s.deleted_at IS NULL AND n.deleted_at IS NULL AND ($1 OR n.id_user = $2)
+	ORDER BY c.time, c.id_sensor
+	LIMIT $5`
+	rows, err := tx.Query(ctx, sqlStatement, currentUser.IsAdmin, currentUser.IdUser, after, until, limit)
+	if err != nil {
+		return channels, err
+	}
+	defer rows.Close()
+
+	channels = []entities.SyncChannel{}
+	for rows.Next() {
+		var channel entities.SyncChannel
+		err = rows.Scan(&channel.IdSensor, &channel.Time, &channel.Name, &channel.Value)
+		if err != nil {
+			return channels, err
+		}
+		channels = append(channels, channel)
+	}
+	return channels, rows.Err()
+}
+
+type syncKey struct {
+	entity  string
+	localId int
+}
+
+// syncApply is the state of one Apply
+type syncApply struct {
+	ctx      context.Context
+	tx       helper.Querier
+	site     entities.SyncSite
+	remoteId map[syncKey]int
+	lastTime map[int]*time.Time
+	result   *entities.SyncResult
+}
+
+// resolve return the central id of the edge entity, creating or updating it with the conflict rule
+// of the site. It return 0 when the entity is deleted on the central with the central rule. update
+// return false when the entity is already the same
+func (a *syncApply) resolve(entity string, localId int, existSql string, existArgs []interface{}, update func(remoteId int) (bool, error), create func() (int, error)) (int, error) {
+	key := syncKey{entity: entity, localId: localId}
+	remoteId, mapped := a.remoteId[key]
+	if mapped {
+		exist := false
+		err := a.tx.QueryRow(a.ctx, existSql, append([]interface{}{remoteId}, existArgs...)...).Scan(&exist)
+		if err != nil {
+			return 0, err
+		}
+		if exist {
+			if a.site.Conflict != entities.SyncConflictEdge {
+				return remoteId, nil
+			}
+			updated, err := update(remoteId)
+			if updated {
+				a.result.Updated++
+			}
+			return remoteId, err
+		}
+		if a.site.Conflict == entities.SyncConflictCentral {
+			return 0, nil
+		}
+	}
+
+	remoteId, err := create()
+	if err != nil {
+		return 0, err
+	}
+	a.result.Created++
+	a.remoteId[key] = remoteId
+	sqlStatement := `
+	INSERT INTO sync_mapping (site, entity, local_id, remote_id) VALUES ($1, $2, $3, $4)
+	ON CONFLICT (site, entity, local_id) DO UPDATE SET remote_id=EXCLUDED.remote_id, last_time=NULL`
+	_, err = a.tx.Exec(a.ctx, sqlStatement, a.site.Site, entity, localId, remoteId)
+	if entity == entities.EntitySensor {
+		a.lastTime[remoteId] = nil
+	}
+	return remoteId, err
+}
+
+// Apply store the batch pushed by the site for the user. The site belong to the user that pushed it
+// first. A channel at or before the last received time of its sensor is skipped, so a batch sent
+// again after a lost answer is not stored twice. A deleted node or sensor is never updated and doesn't
+// receive channel. The channel is calibrated and validated like POST /channel/bulk, except a channel
+// the rule reject is skipped so the site can still push. The created and changed entity is in its history
+// like an edit of the user
+func (r *SyncRepository) Apply(ctx context.Context, tx helper.Querier, idUser int, batch *entities.SyncBatch) (result entities.SyncResult, err error) {
+	site, err := r.GetSite(ctx, tx, batch.Site)
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) && fiberErr.Code == 404 {
+		site = entities.SyncSite{Site: batch.Site, IdUser: idUser, Conflict: entities.SyncConflictEdge}
+		_, err = tx.Exec(ctx, `INSERT INTO sync_site (site, id_user, conflict) VALUES ($1, $2, $3)`, site.Site, site.IdUser, site.Conflict)
+	}
+	if err != nil {
+		return result, err
+	}
+	if site.IdUser != idUser {
+		return result, fiber.NewError(403, fmt.Sprintf("Site %s is synced by another user", batch.Site))
+	}
+
+	a := syncApply{ctx: ctx, tx: tx, site: site, remoteId: map[syncKey]int{}, lastTime: map[int]*time.Time{}, result: &result}
+	rows, err := tx.Query(ctx, `SELECT entity, local_id, remote_id, last_time FROM sync_mapping WHERE site=$1`, site.Site)
+	if err != nil {
+		return result, err
+	}
+	for rows.Next() {
+		var key syncKey
+		var remoteId int
+		var lastTime *time.Time
+		err = rows.Scan(&key.entity, &key.localId, &remoteId, &lastTime)
+		if err != nil {
+			rows.Close()
+			return result, err
+		}
+		a.remoteId[key] = remoteId
+		if key.entity == entities.EntitySensor {
+			a.lastTime[remoteId] = lastTime
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+
+	hardwareIds := map[int]int{}
+	for _, hardware := range batch.Hardware {
+		hardware := hardware
+		remoteId, err := a.resolve(entities.EntityHardware, hardware.Id, `SELECT EXISTS (SELECT 1 FROM hardware WHERE id_hardware=$1)`, nil,
+			func(remoteId int) (bool, error) {
+				before, err := r.hardwareRepository.GetById(ctx, tx, remoteId)
+				if err != nil {
+					return false, err
+				}
+				after := entities.Hardware{IdHardware: remoteId, HardwareCreate: hardware.HardwareCreate}
+				if after == before {
+					return false, nil
+				}
+				_, err = tx.Exec(ctx, `UPDATE hardware SET name=$1, type=$2, description=$3 WHERE id_hardware=$4`, after.Name, after.Type, after.Description, remoteId)
+				if err != nil {
+					return false, err
+				}
+				return true, r.historyRepository.Record(ctx, tx, entities.EntityHardware, remoteId, entities.RevisionUpdate, idUser, before, after)
+			},
+			func() (remoteId int, err error) {
+				// Hardware is shared by every user, an existing one of the same name and type is reused
+				err = tx.QueryRow(ctx, `SELECT id_hardware FROM hardware WHERE name=$1 AND type=$2 ORDER BY id_hardware LIMIT 1`, hardware.Name, hardware.Type).Scan(&remoteId)
+				if !errors.Is(err, pgx.ErrNoRows) {
+					return remoteId, err
+				}
+				created, err := r.hardwareRepository.Create(ctx, tx, &hardware.HardwareCreate)
+				if err != nil {
+					return 0, err
+				}
+				return created.IdHardware, r.historyRepository.Record(ctx, tx, entities.EntityHardware, created.IdHardware, entities.RevisionCreate, idUser, nil, created)
+			})
+		if err != nil {
+			return result, err
+		}
+		hardwareIds[hardware.Id] = remoteId
+	}
+
+	nodeIds := map[int]int{}
+	for _, node := range batch.Nodes {
+		node := node
+		idHardware := hardwareIds[node.IdHardware]
+		if idHardware == 0 {
+			continue
+		}
+		remoteId, err := a.resolve(entities.EntityNode, node.Id, `SELECT EXISTS (SELECT 1 FROM node WHERE id_node=$1 AND id_user=$2 AND deleted_at IS NULL)`, []interface{}{idUser},
+			func(remoteId int) (bool, error) {
+				before, err := r.nodeRepository.GetById(ctx, tx, remoteId)
+				if err != nil {
+					return false, err
+				}
+				after := before
+				after.Name, after.Location, after.IdHardware = node.Name, node.Location, idHardware
+				if after == before {
+					return false, nil
+				}
+				_, err = tx.Exec(ctx, `UPDATE node SET name=$1, location=$2, id_hardware=$3 WHERE id_node=$4`, after.Name, after.Location, after.IdHardware, remoteId)
+				if err != nil {
+					return false, err
+				}
+				return true, r.historyRepository.Record(ctx, tx, entities.EntityNode, remoteId, entities.RevisionUpdate, idUser, before, after)
+			},
+			func() (int, error) {
+				created := entities.Node{NodeCreate: entities.NodeCreate{Name: node.Name, Location: node.Location, IdHardware: idHardware}, IdUser: idUser}
+				err := tx.QueryRow(ctx, `INSERT INTO node (name, location, id_hardware, id_user) VALUES ($1, $2, $3, $4) RETURNING id_node`, created.Name, created.Location, created.IdHardware, created.IdUser).Scan(&created.IdNode)
+				if err != nil {
+					return 0, err
+				}
+				return created.IdNode, r.historyRepository.Record(ctx, tx, entities.EntityNode, created.IdNode, entities.RevisionCreate, idUser, nil, created)
+			})
+		if err != nil {
+			return result, err
+		}
+		nodeIds[node.Id] = remoteId
+	}
+
+	sensorIds := map[int]int{}
+	for _, sensor := range batch.Sensors {
+		sensor := sensor
+		idNode, idHardware := nodeIds[sensor.IdNode], hardwareIds[sensor.IdHardware]
+		if idNode == 0 || idHardware == 0 {
+			continue
+		}
+		remoteId, err := a.resolve(entities.EntitySensor, sensor.Id, `SELECT EXISTS (SELECT 1 FROM sensor s JOIN node n ON n.id_node = s.id_node WHERE s.id_sensor=$1 AND n.id_user=$2 AND s.deleted_at IS NULL AND n.deleted_at IS NULL)`, []interface{}{idUser},
+			func(remoteId int) (bool, error) {
+				before, err := r.sensorRepository.GetById(ctx, tx, remoteId)
+				if err != nil {
+					return false, err
+				}
+				after := entities.Sensor{IdSensor: remoteId, SensorCreate: entities.SensorCreate{Name: sensor.Name, Unit: sensor.Unit, IdNode: idNode, IdHardware: idHardware}}
+				if after.SensorCreate == before.SensorCreate {
+					return false, nil
+				}
+				_, err = tx.Exec(ctx, `UPDATE sensor SET name=$1, unit=$2, id_node=$3, id_hardware=$4 WHERE id_sensor=$5`, after.Name, after.Unit, after.IdNode, after.IdHardware, remoteId)
+				if err != nil {
+					return false, err
+				}
+				return true, r.historyRepository.Record(ctx, tx, entities.EntitySensor, remoteId, entities.RevisionUpdate, idUser, before, after)
+			},
+			func() (int, error) {
+				created := entities.Sensor{SensorCreate: entities.SensorCreate{Name: sensor.Name, Unit: sensor.Unit, IdNode: idNode, IdHardware: idHardware}}
+				err := tx.QueryRow(ctx, `INSERT INTO sensor (name, unit, id_node, id_hardware) VALUES ($1, $2, $3, $4) RETURNING id_sensor`, created.Name, created.Unit, created.IdNode, created.IdHardware).Scan(&created.IdSensor)
+				if err != nil {
+					return 0, err
+				}
+				return created.IdSensor, r.historyRepository.Record(ctx, tx, entities.EntitySensor, created.IdSensor, entities.RevisionCreate, idUser, nil, created)
+			})
+		if err != nil {
+			return result, err
+		}
+		sensorIds[sensor.Id] = remoteId
+	}
+
+	// A batch without entity only carry channel, the sensor is then resolved by the saved mapping
+	// while it isn't deleted
+	if len(batch.Sensors) == 0 {
+		mappedIds := []int{}
+		for key, remoteId := range a.remoteId {
+			if key.entity == entities.EntitySensor {
+				mappedIds = append(mappedIds, remoteId)
+			}
+		}
+		aliveIds, err := scanIds(ctx, tx, `
+		SELECT s.id_sensor FROM sensor s JOIN node n ON n.id_node = s.id_node
+		WHERE s.id_sensor = ANY($1) AND n.id_user = $2 AND s.deleted_at IS NULL AND n.deleted_at IS NULL`, mappedIds, idUser)
+		if err != nil {
+			return result, err
+		}
+		alive := map[int]bool{}
+		for _, id := range aliveIds {
+			alive[id] = true
+		}
+		for key, remoteId := range a.remoteId {
+			if key.entity == entities.EntitySensor && alive[remoteId] {
+				sensorIds[key.localId] = remoteId
+			}
+		}
+	}
+
+	channels := []entities.Channel{}
+	for _, channel := range batch.Channels {
+		remoteId := sensorIds[channel.IdSensor]
+		if remoteId == 0 {
+			result.Skipped++
+			continue
+		}
+		lastTime := a.lastTime[remoteId]
+		if lastTime != nil && !channel.Time.After(*lastTime) {
+			result.Skipped++
+			continue
+		}
+		quality := channel.Quality
+		if quality == "" {
+			quality = entities.QualityGood
+		}
+		channels = append(channels, entities.Channel{
+			Time:          channel.Time.UTC(),
+			ChannelCreate: entities.ChannelCreate{IdSensor: remoteId, Value: channel.Value, Name: channel.Name, Quality: quality},
+		})
+	}
+	payloads := make([]*entities.ChannelCreate, len(channels))
+	for i := range channels {
+		payloads[i] = &channels[i].ChannelCreate
+	}
+	err = r.transformRepository.CalibrateMany(ctx, tx, payloads)
+	if err != nil {
+		return result, err
+	}
+	rejected, err := r.validationRepository.FilterMany(ctx, tx, payloads)
+	if err != nil {
+		return result, err
+	}
+
+	type channelColumns struct {
+		times     []time.Time
+		values    []float64
+		names     []string
+		qualities []string
+	}
+	columns := map[int]*channelColumns{}
+	sensorOrder := []int{}
+	for i, channel := range channels {
+		if rejected[i] {
+			result.Skipped++
+			continue
+		}
+		remoteId := channel.IdSensor
+		if columns[remoteId] == nil {
+			columns[remoteId] = &channelColumns{}
+			sensorOrder = append(sensorOrder, remoteId)
+		}
+		column := columns[remoteId]
+		column.times = append(column.times, channel.Time)
+		column.values = append(column.values, channel.Value)
+		column.names = append(column.names, channel.Name)
+		column.qualities = append(column.qualities, channel.Quality)
+	}
+
+	for _, remoteId := range sensorOrder {
+		column := columns[remoteId]
+		sqlStatement := `
+		INSERT INTO "channel" (time, value, id_sensor, name, quality)
+		SELECT unnest($1::TIMESTAMP[]), unnest($2::FLOAT[]), $3, unnest($4::VARCHAR[]), unnest($5::VARCHAR[])`
+		_, err = tx.Exec(ctx, sqlStatement, column.times, column.values, remoteId, column.names, column.qualities)
+		if err != nil {
+			return result, err
+		}
+		first, last := column.times[0], column.times[0]
+		for _, t := range column.times {
+			if t.Before(first) {
+				first = t
+			}
+			if t.After(last) {
+				last = t
+			}
+		}
+		// A site pushing after a long outage add channel to a settled day
+		err = invalidateChecksum(ctx, tx, remoteId, first, last)
+		if err != nil {
+			return result, err
+		}
+		err = invalidateAggregate(ctx, tx, remoteId, first)
+		if err != nil {
+			return result, err
+		}
+		_, err = tx.Exec(ctx, `UPDATE sync_mapping SET last_time=$1 WHERE site=$2 AND entity='sensor' AND remote_id=$3`, last, site.Site, remoteId)
+		if err != nil {
+			return result, err
+		}
+		result.Channels += len(column.times)
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE sync_site SET last_sync_at=NOW(), channels=channels + $1 WHERE site=$2`, result.Channels, site.Site)
+	return result, err
+}
@@ -0,0 +1,72 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/jackc/pgx/v5"
+)
+
+// ThrottleRepository keep the ingest throttle of the sensor posting too often, the last stored channel
+// is read from the channel table so the throttle hold across instances
+type ThrottleRepository struct{}
+
+func NewThrottleRepository() (ThrottleRepository, error) {
+	return ThrottleRepository{}, nil
+}
+
+// GetBySensor return the throttle of the sensor, a 0 interval when it has none
+func (r *ThrottleRepository) GetBySensor(ctx context.Context, tx helper.Querier, sensorId int) (throttle entities.SensorThrottle, err error) {
+	throttle = entities.SensorThrottle{IdSensor: sensorId, Mode: entities.ThrottleReject}
+	sqlStatement := `SELECT min_interval_ms, mode, throttled FROM sensor_throttle WHERE id_sensor=$1`
+	err = tx.QueryRow(ctx, sqlStatement, sensorId).Scan(&throttle.MinIntervalMs, &throttle.Mode, &throttle.Throttled)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return throttle, nil
+	}
+	return throttle, err
+}
+
+// Update set the throttle of the sensor, a 0 interval remove it
+func (r *ThrottleRepository) Update(ctx context.Context, tx helper.Querier, sensorId int, payload *entities.SensorThrottleUpdate) error {
+	if payload.MinIntervalMs == 0 {
+		_, err := tx.Exec(ctx, `DELETE FROM sensor_throttle WHERE id_sensor=$1`, sensorId)
+		return err
+	}
+
+	mode := payload.Mode
+	if mode == "" {
+		mode = entities.ThrottleReject
+	}
+
+	sqlStatement := `
+	INSERT INTO sensor_throttle (id_sensor, min_interval_ms, mode) VALUES ($1, $2, $3)
+	ON CONFLICT (id_sensor) DO UPDATE SET min_interval_ms=EXCLUDED.min_interval_ms, mode=EXCLUDED.mode`
+	_, err := tx.Exec(ctx, sqlStatement, sensorId, payload.MinIntervalMs, mode)
+	return err
+}
+
+// Check return the throttle of the sensor and the time of the last stored channel of the same name
+// when the received channel come sooner than the minimum interval, nil when it can be stored.
+// The throttled channel is counted
+func (r *ThrottleRepository) Check(ctx context.Context, tx helper.Querier, payload *entities.ChannelCreate, now time.Time) (throttle entities.SensorThrottle, last *time.Time, err error) {
+	throttle = entities.SensorThrottle{IdSensor: payload.IdSensor}
+	sqlStatement := `
+	SELECT t.min_interval_ms, t.mode, (
+		SELECT max(channel.time) FROM "channel"
+		WHERE channel.id_sensor=t.id_sensor AND channel.name=$2 AND channel.time > $3::TIMESTAMP - t.min_interval_ms * INTERVAL '1 millisecond'
+	)
+	FROM sensor_throttle t WHERE t.id_sensor=$1`
+	err = tx.QueryRow(ctx, sqlStatement, payload.IdSensor, payload.Name, now).Scan(&throttle.MinIntervalMs, &throttle.Mode, &last)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return throttle, nil, nil
+	}
+	if err != nil || last == nil {
+		return throttle, nil, err
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE sensor_throttle SET throttled=throttled+1 WHERE id_sensor=$1`, payload.IdSensor)
+	return throttle, last, err
+}
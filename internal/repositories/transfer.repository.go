@@ -0,0 +1,99 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+// Number of transfer returned for a user, newest first
+const transferLimit = 100
+
+// TransferRepository keep the offer to transfer a node to another user
+type TransferRepository struct{}
+
+func NewTransferRepository() (TransferRepository, error) {
+	return TransferRepository{}, nil
+}
+
+func (r *TransferRepository) transferField() string {
+	return `t.id_transfer, t.id_node, n.name, t.id_from_user, f.username, t.id_to_user, u.username, t.status, t.created_at, t.responded_at
+	FROM node_transfer t
+	INNER JOIN node n ON n.id_node = t.id_node
+	INNER JOIN user_person f ON f.id_user = t.id_from_user
+	INNER JOIN user_person u ON u.id_user = t.id_to_user`
+}
+
+func (r *TransferRepository) transferPointer(transfer *entities.NodeTransfer) []interface{} {
+	return []interface{}{&transfer.IdTransfer, &transfer.IdNode, &transfer.NodeName, &transfer.IdFromUser, &transfer.FromUsername,
+		&transfer.IdToUser, &transfer.ToUsername, &transfer.Status, &transfer.CreatedAt, &transfer.RespondedAt}
+}
+
+// GetByUser return the transfer offered by or to the user
+func (r *TransferRepository) GetByUser(ctx context.Context, tx helper.Querier, idUser int) (transfers []entities.NodeTransfer, err error) {
+	transfers = []entities.NodeTransfer{}
+	sqlStatement := fmt.Sprintf(`SELECT %s WHERE t.id_from_user=$1 OR t.id_to_user=$1 ORDER BY t.created_at DESC LIMIT $2`, r.transferField())
+	rows, err := tx.Query(ctx, sqlStatement, idUser, transferLimit)
+	if err != nil {
+		return transfers, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var transfer entities.NodeTransfer
+		err := rows.Scan(r.transferPointer(&transfer)...)
+		if err != nil {
+			return transfers, err
+		}
+		transfers = append(transfers, transfer)
+	}
+	return transfers, rows.Err()
+}
+
+func (r *TransferRepository) GetById(ctx context.Context, tx helper.Querier, id int) (transfer entities.NodeTransfer, err error) {
+	sqlStatement := fmt.Sprintf(`SELECT %s WHERE t.id_transfer=$1`, r.transferField())
+	err = tx.QueryRow(ctx, sqlStatement, id).Scan(r.transferPointer(&transfer)...)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return transfer, fiber.NewError(404, fmt.Sprintf("Transfer with id %d not found", id))
+		}
+		return transfer, err
+	}
+	return transfer, nil
+}
+
+// Create offer the node to the user, the pending offer of the node is cancelled so a node only has
+// one pending offer
+func (r *TransferRepository) Create(ctx context.Context, tx helper.Querier, idNode int, idFromUser int, idToUser int) (transfer entities.NodeTransfer, err error) {
+	sqlStatement := `UPDATE node_transfer SET status=$2, responded_at=NOW() WHERE id_node=$1 AND status=$3`
+	_, err = tx.Exec(ctx, sqlStatement, idNode, entities.TransferCancelled, entities.TransferPending)
+	if err != nil {
+		return transfer, err
+	}
+
+	var id int
+	sqlStatement = `INSERT INTO node_transfer (id_node, id_from_user, id_to_user, status) VALUES ($1, $2, $3, $4) RETURNING id_transfer`
+	err = tx.QueryRow(ctx, sqlStatement, idNode, idFromUser, idToUser, entities.TransferPending).Scan(&id)
+	if err != nil {
+		return transfer, err
+	}
+	return r.GetById(ctx, tx, id)
+}
+
+// Respond set the status of a pending transfer, it fail when the transfer is already answered.
+// The row stay locked until the end of the transaction
+func (r *TransferRepository) Respond(ctx context.Context, tx helper.Querier, id int, status string) (err error) {
+	sqlStatement := `UPDATE node_transfer SET status=$2, responded_at=NOW() WHERE id_transfer=$1 AND status=$3`
+	res, err := tx.Exec(ctx, sqlStatement, id, status, entities.TransferPending)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return fiber.NewError(409, fmt.Sprintf("Transfer with id %d is no longer pending", id))
+	}
+	return nil
+}
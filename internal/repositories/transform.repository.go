@@ -0,0 +1,209 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+// transformUnit convert a value to the base unit of its quantity as value*factor+offset
+type transformUnit struct {
+	quantity string
+	factor   float64
+	offset   float64
+}
+
+// Unit known by the convert step, by lowercase symbol
+var transformUnits = map[string]transformUnit{
+	"k":    {"temperature", 1, 0},
+	"c":    {"temperature", 1, 273.15},
+	"f":    {"temperature", 5.0 / 9, 273.15 - 32*5.0/9},
+	"pa":   {"pressure", 1, 0},
+	"hpa":  {"pressure", 100, 0},
+	"kpa":  {"pressure", 1000, 0},
+	"bar":  {"pressure", 100000, 0},
+	"mbar": {"pressure", 100, 0},
+	"psi":  {"pressure", 6894.757293168, 0},
+	"mmhg": {"pressure", 133.322387415, 0},
+	"mm":   {"length", 0.001, 0},
+	"cm":   {"length", 0.01, 0},
+	"m":    {"length", 1, 0},
+	"km":   {"length", 1000, 0},
+	"in":   {"length", 0.0254, 0},
+	"ft":   {"length", 0.3048, 0},
+	"m/s":  {"speed", 1, 0},
+	"km/h": {"speed", 1 / 3.6, 0},
+	"mph":  {"speed", 0.44704, 0},
+	"kn":   {"speed", 1852 / 3600.0, 0},
+	"g":    {"mass", 0.001, 0},
+	"kg":   {"mass", 1, 0},
+	"lb":   {"mass", 0.45359237, 0},
+	"wh":   {"energy", 1, 0},
+	"kwh":  {"energy", 1000, 0},
+	"mwh":  {"energy", 1000000, 0},
+	"w":    {"power", 1, 0},
+	"kw":   {"power", 1000, 0},
+	"l":    {"volume", 1, 0},
+	"m3":   {"volume", 1000, 0},
+	"gal":  {"volume", 3.785411784, 0},
+}
+
+// TransformRepository keep the ordered transformation step of the sensor, run on the received
+// channel before anything else
+type TransformRepository struct{}
+
+func NewTransformRepository() (TransformRepository, error) {
+	return TransformRepository{}, nil
+}
+
+// GetBySensor return the transformation of the sensor, without step when it has none
+func (r *TransformRepository) GetBySensor(ctx context.Context, tx helper.Querier, sensorId int) (transform entities.SensorTransform, err error) {
+	transform = entities.SensorTransform{IdSensor: sensorId, Steps: []entities.SensorTransformStep{}}
+	err = tx.QueryRow(ctx, `SELECT steps FROM sensor_transform WHERE id_sensor=$1`, sensorId).Scan(&transform.Steps)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return transform, nil
+	}
+	return transform, err
+}
+
+// Validate check each step has what its type need, the error is a 400
+func (r *TransformRepository) Validate(steps []entities.SensorTransformStep) error {
+	for i, step := range steps {
+		switch step.Type {
+		case entities.TransformScale:
+			if step.Factor == nil && step.Offset == nil {
+				return fiber.NewError(400, fmt.Sprintf("Step %d scale need a factor or an offset", i+1))
+			}
+		case entities.TransformClamp:
+			if step.Min == nil && step.Max == nil {
+				return fiber.NewError(400, fmt.Sprintf("Step %d clamp need a min or a max", i+1))
+			}
+			if step.Min != nil && step.Max != nil && *step.Min > *step.Max {
+				return fiber.NewError(400, fmt.Sprintf("Step %d clamp min must not be above max", i+1))
+			}
+		case entities.TransformConvert:
+			from, fromOk := transformUnits[strings.ToLower(step.From)]
+			to, toOk := transformUnits[strings.ToLower(step.To)]
+			if !fromOk || !toOk {
+				return fiber.NewError(400, fmt.Sprintf("Step %d convert has an unknown unit, use one of %s", i+1, transformUnitList()))
+			}
+			if from.quantity != to.quantity {
+				return fiber.NewError(400, fmt.Sprintf("Step %d can't convert %s to %s", i+1, from.quantity, to.quantity))
+			}
+		case entities.TransformRename:
+			if step.Name == nil {
+				return fiber.NewError(400, fmt.Sprintf("Step %d rename need a name", i+1))
+			}
+		}
+	}
+	return nil
+}
+
+func transformUnitList() string {
+	symbols := []string{}
+	for symbol := range transformUnits {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	return strings.Join(symbols, ", ")
+}
+
+// Update replace the step of the sensor, no step remove the transformation
+func (r *TransformRepository) Update(ctx context.Context, tx helper.Querier, sensorId int, payload *entities.SensorTransformUpdate) error {
+	if len(payload.Steps) == 0 {
+		_, err := tx.Exec(ctx, `DELETE FROM sensor_transform WHERE id_sensor=$1`, sensorId)
+		return err
+	}
+
+	sqlStatement := `
+	INSERT INTO sensor_transform (id_sensor, steps) VALUES ($1, $2)
+	ON CONFLICT (id_sensor) DO UPDATE SET steps=EXCLUDED.steps`
+	_, err := tx.Exec(ctx, sqlStatement, sensorId, payload.Steps)
+	return err
+}
+
+// Apply calibrate the channel with the sensor calibration, then run the step of the sensor on it in
+// order, changing its value and name. dropped is true when a drop step matched the channel
+func (r *TransformRepository) Apply(ctx context.Context, tx helper.Querier, payload *entities.ChannelCreate) (dropped bool, err error) {
+	var scale, offset float64
+	steps := []entities.SensorTransformStep{}
+	sqlStatement := `
+	SELECT sensor.calibration_scale, sensor.calibration_offset, sensor_transform.steps
+	FROM sensor LEFT JOIN sensor_transform ON sensor_transform.id_sensor=sensor.id_sensor
+	WHERE sensor.id_sensor=$1`
+	err = tx.QueryRow(ctx, sqlStatement, payload.IdSensor).Scan(&scale, &offset, &steps)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	payload.Value = payload.Value*scale + offset
+
+	for _, step := range steps {
+		if step.Channel != nil && *step.Channel != payload.Name {
+			continue
+		}
+		switch step.Type {
+		case entities.TransformScale:
+			if step.Factor != nil {
+				payload.Value *= *step.Factor
+			}
+			if step.Offset != nil {
+				payload.Value += *step.Offset
+			}
+		case entities.TransformClamp:
+			if step.Min != nil {
+				payload.Value = math.Max(payload.Value, *step.Min)
+			}
+			if step.Max != nil {
+				payload.Value = math.Min(payload.Value, *step.Max)
+			}
+		case entities.TransformConvert:
+			from, fromOk := transformUnits[strings.ToLower(step.From)]
+			to, toOk := transformUnits[strings.ToLower(step.To)]
+			if fromOk && toOk {
+				payload.Value = (payload.Value*from.factor + from.offset - to.offset) / to.factor
+			}
+		case entities.TransformRename:
+			if step.Name != nil {
+				payload.Name = *step.Name
+			}
+		case entities.TransformDrop:
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CalibrateMany calibrate the buffered channel with the calibration of their sensor, the step isn't
+// run on a buffered channel
+func (r *TransformRepository) CalibrateMany(ctx context.Context, tx helper.Querier, payloads []*entities.ChannelCreate) error {
+	type calibration struct {
+		scale  float64
+		offset float64
+	}
+	calibrations := map[int]calibration{}
+	for _, payload := range payloads {
+		sensorCalibration, ok := calibrations[payload.IdSensor]
+		if !ok {
+			sensorCalibration = calibration{scale: 1}
+			err := tx.QueryRow(ctx, `SELECT calibration_scale, calibration_offset FROM sensor WHERE id_sensor=$1`, payload.IdSensor).
+				Scan(&sensorCalibration.scale, &sensorCalibration.offset)
+			if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+				return err
+			}
+			calibrations[payload.IdSensor] = sensorCalibration
+		}
+		payload.Value = payload.Value*sensorCalibration.scale + sensorCalibration.offset
+	}
+	return nil
+}
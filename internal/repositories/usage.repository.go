@@ -0,0 +1,74 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+)
+
+type UsageRepository struct{}
+
+func NewUsageRepository() (UsageRepository, error) {
+	return UsageRepository{}, nil
+}
+
+// UsageMonth truncate the time to the first day of its month in UTC, the key of the monthly rollup
+func UsageMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Add increase the counter of the user in the month by value. The usage of a user deleted in the
+// meantime is dropped instead of failing the whole flush
+func (u *UsageRepository) Add(ctx context.Context, tx helper.Querier, idUser int, month time.Time, metric string, value int64) error {
+	sqlStatement := `
+	INSERT INTO usage_counter (id_user, month, metric, value)
+	SELECT $1, $2, $3, $4 WHERE EXISTS (SELECT 1 FROM user_person WHERE id_user = $1)
+	ON CONFLICT (id_user, month, metric) DO UPDATE SET value = usage_counter.value + EXCLUDED.value`
+	_, err := tx.Exec(ctx, sqlStatement, idUser, UsageMonth(month), metric, value)
+	return err
+}
+
+// AddAllUsers increase the counter of every user in the month by value
+func (u *UsageRepository) AddAllUsers(ctx context.Context, tx helper.Querier, month time.Time, metric string, value int64) error {
+	sqlStatement := `
+	INSERT INTO usage_counter (id_user, month, metric, value)
+	SELECT id_user, $1, $2, $3 FROM user_person
+	ON CONFLICT (id_user, month, metric) DO UPDATE SET value = usage_counter.value + EXCLUDED.value`
+	_, err := tx.Exec(ctx, sqlStatement, UsageMonth(month), metric, value)
+	return err
+}
+
+// GetMonthReport return the usage of every user that have any usage in the month, one row per user
+func (u *UsageRepository) GetMonthReport(ctx context.Context, tx helper.Querier, month time.Time) (reports []entities.UsageReport, err error) {
+	reports = []entities.UsageReport{}
+	sqlStatement := `
+	SELECT u.id_user, u.username, u.email,
+		COALESCE(SUM(c.value) FILTER (WHERE c.metric = $2), 0),
+		COALESCE(SUM(c.value) FILTER (WHERE c.metric = $3), 0),
+		COALESCE(SUM(c.value) FILTER (WHERE c.metric = $4), 0)
+	FROM usage_counter c
+	JOIN user_person u ON u.id_user = c.id_user
+	WHERE c.month = $1
+	GROUP BY u.id_user, u.username, u.email
+	ORDER BY u.id_user`
+	month = UsageMonth(month)
+	rows, err := tx.Query(ctx, sqlStatement, month, entities.UsageApiCalls, entities.UsagePointsStored, entities.UsageNotificationsSent)
+	if err != nil {
+		return reports, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		report := entities.UsageReport{Month: month.Format("2006-01")}
+		err := rows.Scan(&report.IdUser, &report.Username, &report.Email, &report.ApiCalls, &report.PointsStored, &report.NotificationsSent)
+		if err != nil {
+			return reports, err
+		}
+		reports = append(reports, report)
+	}
+
+	return reports, rows.Err()
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"io"
 
 	"github.com/dafaath/iot-server/configs"
 	"github.com/dafaath/iot-server/internal/entities"
@@ -124,6 +125,22 @@ func (u *UserRepository) UpdatePassword(ctx context.Context, tx helper.Querier,
 	return nil
 }
 
+func (u *UserRepository) UpdateEmail(ctx context.Context, tx helper.Querier, id int, email string) (err error) {
+	sqlStatement := `
+	UPDATE user_person
+	SET email=$1
+	WHERE id_user=$2`
+	res, err := tx.Exec(ctx, sqlStatement, email, id)
+	if err != nil {
+		return err
+	}
+	count := res.RowsAffected()
+	if count == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on update user email with id %d", id))
+	}
+	return nil
+}
+
 func (u *UserRepository) UpdateStatus(ctx context.Context, tx helper.Querier, id int, status bool) (err error) {
 	sqlStatement := `
 	UPDATE user_person 
@@ -140,6 +157,135 @@ func (u *UserRepository) UpdateStatus(ctx context.Context, tx helper.Querier, id
 	return nil
 }
 
+func (u *UserRepository) UpdateIsAdmin(ctx context.Context, tx helper.Querier, id int, isAdmin bool) (err error) {
+	sqlStatement := `
+	UPDATE user_person 
+	set isadmin=$1 
+	WHERE id_user=$2`
+	res, err := tx.Exec(ctx, sqlStatement, isAdmin, id)
+	if err != nil {
+		return err
+	}
+	count := res.RowsAffected()
+	if count == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on update user admin with id %d", id))
+	}
+	return nil
+}
+
+func (u *UserRepository) GetTheme(ctx context.Context, tx helper.Querier, id int) (theme string, err error) {
+	sqlStatement := `SELECT theme FROM user_person WHERE id_user=$1`
+	err = tx.QueryRow(ctx, sqlStatement, id).Scan(&theme)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return theme, fiber.NewError(404, fmt.Sprintf("User with id %d not found", id))
+		}
+		return theme, err
+	}
+	return theme, nil
+}
+
+func (u *UserRepository) UpdateTheme(ctx context.Context, tx helper.Querier, id int, theme string) (err error) {
+	sqlStatement := `
+	UPDATE user_person 
+	set theme=$1 
+	WHERE id_user=$2`
+	res, err := tx.Exec(ctx, sqlStatement, theme, id)
+	if err != nil {
+		return err
+	}
+	count := res.RowsAffected()
+	if count == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on update user theme with id %d", id))
+	}
+	return nil
+}
+
+// GetLanguage return nil when the user has no preference and the language is negotiated from the browser
+func (u *UserRepository) GetLanguage(ctx context.Context, tx helper.Querier, id int) (language *string, err error) {
+	sqlStatement := `SELECT language FROM user_person WHERE id_user=$1`
+	err = tx.QueryRow(ctx, sqlStatement, id).Scan(&language)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return language, fiber.NewError(404, fmt.Sprintf("User with id %d not found", id))
+		}
+		return language, err
+	}
+	return language, nil
+}
+
+func (u *UserRepository) UpdateLanguage(ctx context.Context, tx helper.Querier, id int, language string) (err error) {
+	sqlStatement := `
+	UPDATE user_person 
+	set language=$1 
+	WHERE id_user=$2`
+	res, err := tx.Exec(ctx, sqlStatement, language, id)
+	if err != nil {
+		return err
+	}
+	count := res.RowsAffected()
+	if count == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on update user language with id %d", id))
+	}
+	return nil
+}
+
+func (u *UserRepository) GetDigest(ctx context.Context, tx helper.Querier, id int) (digest bool, err error) {
+	sqlStatement := `SELECT digest FROM user_person WHERE id_user=$1`
+	err = tx.QueryRow(ctx, sqlStatement, id).Scan(&digest)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return digest, fiber.NewError(404, fmt.Sprintf("User with id %d not found", id))
+		}
+		return digest, err
+	}
+	return digest, nil
+}
+
+func (u *UserRepository) UpdateDigest(ctx context.Context, tx helper.Querier, id int, digest bool) (err error) {
+	sqlStatement := `
+	UPDATE user_person 
+	set digest=$1 
+	WHERE id_user=$2`
+	res, err := tx.Exec(ctx, sqlStatement, digest, id)
+	if err != nil {
+		return err
+	}
+	count := res.RowsAffected()
+	if count == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on update user digest with id %d", id))
+	}
+	return nil
+}
+
+// GetDigestUsers return the activated user who opted in the daily email digest
+func (u *UserRepository) GetDigestUsers(ctx context.Context, tx helper.Querier) (users []entities.UserRead, err error) {
+	users = []entities.UserRead{}
+	sqlStatement := `SELECT id_user, email, username, status, token, isadmin FROM user_person WHERE digest AND status ORDER BY id_user`
+	rows, err := tx.Query(ctx, sqlStatement)
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user entities.UserRead
+		err := rows.Scan(
+			&user.IdUser,
+			&user.Email,
+			&user.Username,
+			&user.Status,
+			&user.Token,
+			&user.IsAdmin,
+		)
+		if err != nil {
+			return users, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
 func (u *UserRepository) Delete(ctx context.Context, tx helper.Querier, id int) (err error) {
 	sqlStatement := `DELETE FROM user_person WHERE id_user=$1`
 	res, err := tx.Exec(ctx, sqlStatement, id)
@@ -261,6 +407,23 @@ func (u *UserRepository) SendEmail(ctx context.Context, to string, subject strin
 	return nil
 }
 
+// SendEmailAttachment is SendEmail with a file attached
+func (u *UserRepository) SendEmailAttachment(ctx context.Context, to string, subject string, body string, filename string, attachment []byte) (err error) {
+	configs := configs.GetConfig()
+
+	mailer := gomail.NewMessage()
+	mailer.SetHeader("From", configs.Mail.SenderName)
+	mailer.SetHeader("To", to)
+	mailer.SetHeader("Subject", subject)
+	mailer.SetBody("text/html", body)
+	mailer.Attach(filename, gomail.SetCopyFunc(func(w io.Writer) error {
+		_, err := w.Write(attachment)
+		return err
+	}))
+
+	return u.mailDialer.DialAndSend(mailer)
+}
+
 func (u *UserRepository) SendEmailActivation(ctx context.Context, user entities.UserRead) (err error) {
 	configs := configs.GetConfig()
 
@@ -0,0 +1,152 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+// ValidationRepository keep the per sensor validation rule checked when a channel is received
+type ValidationRepository struct{}
+
+func NewValidationRepository() (ValidationRepository, error) {
+	return ValidationRepository{}, nil
+}
+
+// GetBySensor return the validation rule of the sensor, a sensor without rule check nothing
+func (r *ValidationRepository) GetBySensor(ctx context.Context, tx helper.Querier, sensorId int) (validation entities.SensorValidation, err error) {
+	validation = entities.SensorValidation{IdSensor: sensorId, Action: entities.ValidationFlag}
+	sqlStatement := `SELECT min_value, max_value, max_step, action, flagged, rejected FROM sensor_validation WHERE id_sensor=$1`
+	err = tx.QueryRow(ctx, sqlStatement, sensorId).Scan(&validation.Min, &validation.Max, &validation.MaxStep, &validation.Action, &validation.Flagged, &validation.Rejected)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return validation, nil
+	}
+	return validation, err
+}
+
+// Update replace the rule of the sensor and keep its counter
+func (r *ValidationRepository) Update(ctx context.Context, tx helper.Querier, sensorId int, payload *entities.SensorValidationUpdate) error {
+	action := payload.Action
+	if action == "" {
+		action = entities.ValidationFlag
+	}
+
+	sqlStatement := `
+	INSERT INTO sensor_validation (id_sensor, min_value, max_value, max_step, action) VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (id_sensor) DO UPDATE SET
+		min_value=EXCLUDED.min_value, max_value=EXCLUDED.max_value, max_step=EXCLUDED.max_step, action=EXCLUDED.action`
+	_, err := tx.Exec(ctx, sqlStatement, sensorId, payload.Min, payload.Max, payload.MaxStep, action)
+	return err
+}
+
+// CheckMany validate the buffered channel with the range of the rule of their sensor like Check. The
+// step isn't checked since a buffered channel may come out of order. It return a 422 error for the
+// first channel the rule reject, the counter is then left as is
+func (r *ValidationRepository) CheckMany(ctx context.Context, tx helper.Querier, payloads []*entities.ChannelCreate) error {
+	_, err := r.checkMany(ctx, tx, payloads, true)
+	return err
+}
+
+// FilterMany validate the channel like CheckMany, but a channel the rule reject is counted and left
+// out instead of failing every channel, for a channel another instance already accepted. rejected
+// is true at the index of the left out channel
+func (r *ValidationRepository) FilterMany(ctx context.Context, tx helper.Querier, payloads []*entities.ChannelCreate) (rejected []bool, err error) {
+	return r.checkMany(ctx, tx, payloads, false)
+}
+
+func (r *ValidationRepository) checkMany(ctx context.Context, tx helper.Querier, payloads []*entities.ChannelCreate, failOnReject bool) (rejected []bool, err error) {
+	rules := map[int]entities.SensorValidation{}
+	flagged := map[int]int{}
+	rejectedCount := map[int]int{}
+	sensorOrder := []int{}
+	rejected = make([]bool, len(payloads))
+	for i, payload := range payloads {
+		rule, ok := rules[payload.IdSensor]
+		if !ok {
+			rule, err = r.GetBySensor(ctx, tx, payload.IdSensor)
+			if err != nil {
+				return nil, err
+			}
+			rules[payload.IdSensor] = rule
+			sensorOrder = append(sensorOrder, payload.IdSensor)
+		}
+		if (rule.Min == nil || payload.Value >= *rule.Min) && (rule.Max == nil || payload.Value <= *rule.Max) {
+			continue
+		}
+		if rule.Action == entities.ValidationReject {
+			if failOnReject {
+				return nil, fiber.NewError(fiber.StatusUnprocessableEntity, fmt.Sprintf("Channel %d is rejected by the sensor validation rule, value %g is outside the sensor range", i, payload.Value))
+			}
+			rejected[i] = true
+			rejectedCount[payload.IdSensor]++
+			continue
+		}
+		if payload.Quality == "" || payload.Quality == entities.QualityGood {
+			payload.Quality = entities.QualityOutOfRange
+		}
+		flagged[payload.IdSensor]++
+	}
+
+	for _, sensorId := range sensorOrder {
+		if flagged[sensorId] == 0 && rejectedCount[sensorId] == 0 {
+			continue
+		}
+		_, err := tx.Exec(ctx, `UPDATE sensor_validation SET flagged=flagged+$2, rejected=rejected+$3 WHERE id_sensor=$1`, sensorId, flagged[sensorId], rejectedCount[sensorId])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rejected, nil
+}
+
+// Check validate the channel with the rule of its sensor, the step is from the channel of the same name. A violating channel is flagged as
+// out-of-range or suspect (a quality sent by the device is kept), or rejected is true when the
+// rule reject it. The counter of the rule is incremented either way
+func (r *ValidationRepository) Check(ctx context.Context, tx helper.Querier, payload *entities.ChannelCreate) (rejected bool, reason string, err error) {
+	var min, max, maxStep *float64
+	var action string
+	sqlStatement := `SELECT min_value, max_value, max_step, action FROM sensor_validation WHERE id_sensor=$1`
+	err = tx.QueryRow(ctx, sqlStatement, payload.IdSensor).Scan(&min, &max, &maxStep, &action)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, "", nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+
+	quality := ""
+	if (min != nil && payload.Value < *min) || (max != nil && payload.Value > *max) {
+		quality = entities.QualityOutOfRange
+		reason = fmt.Sprintf("value %g is outside the sensor range", payload.Value)
+	} else if maxStep != nil {
+		var last float64
+		sqlStatement := `SELECT channel.value FROM "channel" WHERE channel.id_sensor=$1 AND channel.name=$2 AND channel.quality='good' ORDER BY channel.time DESC LIMIT 1`
+		err = tx.QueryRow(ctx, sqlStatement, payload.IdSensor, payload.Name).Scan(&last)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return false, "", err
+		}
+		if err == nil && math.Abs(payload.Value-last) > *maxStep {
+			quality = entities.QualitySuspect
+			reason = fmt.Sprintf("value %g changed more than %g from the last channel %g", payload.Value, *maxStep, last)
+		}
+	}
+	if quality == "" {
+		return false, "", nil
+	}
+
+	counter := "flagged"
+	if action == entities.ValidationReject {
+		counter = "rejected"
+		rejected = true
+	} else if payload.Quality == "" || payload.Quality == entities.QualityGood {
+		payload.Quality = quality
+	}
+	_, err = tx.Exec(ctx, fmt.Sprintf(`UPDATE sensor_validation SET %[1]s=%[1]s+1 WHERE id_sensor=$1`, counter), payload.IdSensor)
+	return rejected, reason, err
+}
@@ -0,0 +1,81 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+)
+
+// WeatherRepository keep the weather variable fetched for each node and its virtual sensor
+type WeatherRepository struct{}
+
+func NewWeatherRepository() (WeatherRepository, error) {
+	return WeatherRepository{}, nil
+}
+
+// GetByNode return the item of the node ordered by variable
+func (r *WeatherRepository) GetByNode(ctx context.Context, tx helper.Querier, nodeId int) (items []entities.NodeWeatherItem, err error) {
+	items = []entities.NodeWeatherItem{}
+	rows, err := tx.Query(ctx, `SELECT variable, id_sensor, status FROM node_weather WHERE id_node=$1 ORDER BY variable`, nodeId)
+	if err != nil {
+		return items, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := entities.NodeWeatherItem{}
+		err = rows.Scan(&item.Variable, &item.IdSensor, &item.Status)
+		if err != nil {
+			return items, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// DeleteOther stop fetching the variable of the node not in variables, its sensor is kept
+func (r *WeatherRepository) DeleteOther(ctx context.Context, tx helper.Querier, nodeId int, variables []string) error {
+	_, err := tx.Exec(ctx, `DELETE FROM node_weather WHERE id_node=$1 AND NOT (variable = ANY($2))`, nodeId, variables)
+	return err
+}
+
+// Create fetch the variable of the node into the sensor
+func (r *WeatherRepository) Create(ctx context.Context, tx helper.Querier, nodeId int, variable string, sensorId int) error {
+	_, err := tx.Exec(ctx, `INSERT INTO node_weather (id_node, variable, id_sensor, status) VALUES ($1, $2, $3, $4)`, nodeId, variable, sensorId, entities.WeatherPending)
+	return err
+}
+
+// GetAll return every node with its item and owner, for the weather job
+func (r *WeatherRepository) GetAll(ctx context.Context, tx helper.Querier) (nodes []entities.WeatherNode, err error) {
+	sqlStatement := `
+	SELECT n.id_node, n.id_user, n.location, w.id_item, w.id_sensor, w.variable, w.status
+	FROM node_weather w JOIN node n ON n.id_node=w.id_node
+	ORDER BY n.id_node, w.id_item`
+	rows, err := tx.Query(ctx, sqlStatement)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		node := entities.WeatherNode{}
+		item := entities.WeatherItem{}
+		err = rows.Scan(&node.IdNode, &node.IdUser, &node.Location, &item.IdItem, &item.IdSensor, &item.Variable, &item.Status)
+		if err != nil {
+			return nil, err
+		}
+		if len(nodes) == 0 || nodes[len(nodes)-1].IdNode != node.IdNode {
+			nodes = append(nodes, node)
+		}
+		last := &nodes[len(nodes)-1]
+		last.Items = append(last.Items, item)
+	}
+	return nodes, rows.Err()
+}
+
+// SetStatus record the result of the last fetch of the item
+func (r *WeatherRepository) SetStatus(ctx context.Context, tx helper.Querier, itemId int, status string) error {
+	_, err := tx.Exec(ctx, `UPDATE node_weather SET status=$1 WHERE id_item=$2`, status, itemId)
+	return err
+}
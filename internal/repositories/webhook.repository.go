@@ -0,0 +1,245 @@
+package repositories
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+// WebhookRepository keep the webhook of the user and the outbox of their delivery. The event is
+// queued in the transaction that change the entity, so it is only sent when the change is committed
+type WebhookRepository struct{}
+
+func NewWebhookRepository() (WebhookRepository, error) {
+	return WebhookRepository{}, nil
+}
+
+func (r *WebhookRepository) webhookField() string {
+	return "id_webhook, id_user, url, events, is_active, created_at"
+}
+
+func (r *WebhookRepository) webhookPointer(webhook *entities.Webhook) []interface{} {
+	return []interface{}{&webhook.IdWebhook, &webhook.IdUser, &webhook.Url, &webhook.Events, &webhook.IsActive, &webhook.CreatedAt}
+}
+
+func (r *WebhookRepository) GetAll(ctx context.Context, tx helper.Querier, idUser int) (webhooks []entities.Webhook, err error) {
+	webhooks = []entities.Webhook{}
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM webhook WHERE id_user=$1 ORDER BY id_webhook`, r.webhookField())
+	rows, err := tx.Query(ctx, sqlStatement, idUser)
+	if err != nil {
+		return webhooks, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var webhook entities.Webhook
+		err := rows.Scan(r.webhookPointer(&webhook)...)
+		if err != nil {
+			return webhooks, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+func (r *WebhookRepository) GetById(ctx context.Context, tx helper.Querier, id int) (webhook entities.Webhook, err error) {
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM webhook WHERE id_webhook=$1`, r.webhookField())
+	err = tx.QueryRow(ctx, sqlStatement, id).Scan(r.webhookPointer(&webhook)...)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return webhook, fiber.NewError(404, fmt.Sprintf("Webhook with id %d not found", id))
+		}
+		return webhook, err
+	}
+	return webhook, nil
+}
+
+// Create generate the secret used to sign the delivery, it is only returned here
+func (r *WebhookRepository) Create(ctx context.Context, tx helper.Querier, idUser int, payload *entities.WebhookCreate) (webhook entities.Webhook, err error) {
+	secret := make([]byte, 32)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return webhook, err
+	}
+	isActive := payload.IsActive == nil || *payload.IsActive
+
+	sqlStatement := fmt.Sprintf(`
+	INSERT INTO webhook (id_user, url, secret, events, is_active)
+	VALUES ($1, $2, $3, $4, $5) RETURNING %s`, r.webhookField())
+	err = tx.QueryRow(ctx, sqlStatement, idUser, payload.Url, hex.EncodeToString(secret), payload.Events, isActive).Scan(r.webhookPointer(&webhook)...)
+	if err != nil {
+		return webhook, err
+	}
+	webhook.Secret = hex.EncodeToString(secret)
+	return webhook, nil
+}
+
+// Update keep the active state when it is omitted
+func (r *WebhookRepository) Update(ctx context.Context, tx helper.Querier, id int, payload *entities.WebhookCreate) (err error) {
+	sqlStatement := `
+	UPDATE webhook
+	SET url=$1, events=$2, is_active=COALESCE($3, is_active)
+	WHERE id_webhook=$4`
+	res, err := tx.Exec(ctx, sqlStatement, payload.Url, payload.Events, payload.IsActive, id)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on update webhook with id %d", id))
+	}
+	return nil
+}
+
+func (r *WebhookRepository) Delete(ctx context.Context, tx helper.Querier, id int) (err error) {
+	res, err := tx.Exec(ctx, `DELETE FROM webhook WHERE id_webhook=$1`, id)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on delete webhook with id %d", id))
+	}
+	return nil
+}
+
+// GetDeliveries return the latest delivery of the webhook, newest first
+func (r *WebhookRepository) GetDeliveries(ctx context.Context, tx helper.Querier, id int, limit int) (deliveries []entities.WebhookDelivery, err error) {
+	deliveries = []entities.WebhookDelivery{}
+	sqlStatement := `
+	SELECT id_delivery, id_webhook, event, payload, status, attempts, next_attempt_at, response_code, last_error, created_at, delivered_at
+	FROM webhook_delivery WHERE id_webhook=$1
+	ORDER BY id_delivery DESC LIMIT $2`
+	rows, err := tx.Query(ctx, sqlStatement, id, limit)
+	if err != nil {
+		return deliveries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var delivery entities.WebhookDelivery
+		err := rows.Scan(&delivery.IdDelivery, &delivery.IdWebhook, &delivery.Event, &delivery.Payload, &delivery.Status, &delivery.Attempts,
+			&delivery.NextAttemptAt, &delivery.ResponseCode, &delivery.LastError, &delivery.CreatedAt, &delivery.DeliveredAt)
+		if err != nil {
+			return deliveries, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// Enqueue queue the event of an entity owned by idOwner to every active webhook subscribed to it,
+// the webhook of the owner and of every admin
+func (r *WebhookRepository) Enqueue(ctx context.Context, tx helper.Querier, idOwner int, event string, data interface{}) (err error) {
+	payload, err := json.Marshal(entities.WebhookPayload{
+		Event:      event,
+		OccurredAt: time.Now().UTC(),
+		Data:       data,
+	})
+	if err != nil {
+		return err
+	}
+
+	sqlStatement := `
+	INSERT INTO webhook_delivery (id_webhook, event, payload)
+	SELECT w.id_webhook, $1, $2
+	FROM webhook w JOIN user_person u ON u.id_user = w.id_user
+	WHERE w.is_active AND ($1 = ANY(w.events) OR '*' = ANY(w.events)) AND (w.id_user = $3 OR u.isadmin)`
+	_, err = tx.Exec(ctx, sqlStatement, event, string(payload), idOwner)
+	return err
+}
+
+// WebhookAttempt is a delivery claimed to be sent with the webhook it is sent to
+type WebhookAttempt struct {
+	IdDelivery int64
+	Event      string
+	Payload    string
+	Attempts   int
+	Url        string
+	Secret     string
+}
+
+// ClaimDue take the pending delivery which attempt is due and push their next attempt by lease,
+// so another instance doesn't send them while they are in flight. The attempt is counted here
+func (r *WebhookRepository) ClaimDue(ctx context.Context, tx helper.Querier, limit int, lease time.Duration) (attempts []WebhookAttempt, err error) {
+	attempts = []WebhookAttempt{}
+	sqlStatement := `
+	UPDATE webhook_delivery d
+	SET attempts = d.attempts + 1, next_attempt_at = NOW() + make_interval(secs => $2)
+	FROM webhook w
+	WHERE w.id_webhook = d.id_webhook AND d.id_delivery IN (
+		SELECT id_delivery FROM webhook_delivery
+		WHERE status = 'pending' AND next_attempt_at <= NOW()
+		ORDER BY id_delivery LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING d.id_delivery, d.event, d.payload, d.attempts, w.url, w.secret`
+	rows, err := tx.Query(ctx, sqlStatement, limit, lease.Seconds())
+	if err != nil {
+		return attempts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var attempt WebhookAttempt
+		err := rows.Scan(&attempt.IdDelivery, &attempt.Event, &attempt.Payload, &attempt.Attempts, &attempt.Url, &attempt.Secret)
+		if err != nil {
+			return attempts, err
+		}
+		attempts = append(attempts, attempt)
+	}
+	return attempts, rows.Err()
+}
+
+func (r *WebhookRepository) MarkDelivered(ctx context.Context, tx helper.Querier, id int64, responseCode int) (err error) {
+	sqlStatement := `
+	UPDATE webhook_delivery
+	SET status='delivered', response_code=$1, last_error='', delivered_at=NOW()
+	WHERE id_delivery=$2`
+	_, err = tx.Exec(ctx, sqlStatement, responseCode, id)
+	return err
+}
+
+// MarkFailed record the failed attempt, the delivery is retried after retryIn or failed for good
+// when it is 0
+func (r *WebhookRepository) MarkFailed(ctx context.Context, tx helper.Querier, id int64, responseCode *int, message string, retryIn time.Duration) (err error) {
+	sqlStatement := `
+	UPDATE webhook_delivery
+	SET status=CASE WHEN $3 > 0 THEN 'pending' ELSE 'failed' END,
+		response_code=$1, last_error=$2, next_attempt_at=NOW() + make_interval(secs => $3)
+	WHERE id_delivery=$4`
+	_, err = tx.Exec(ctx, sqlStatement, responseCode, message, retryIn.Seconds(), id)
+	return err
+}
+
+// Redeliver queue a delivered or failed delivery again
+func (r *WebhookRepository) Redeliver(ctx context.Context, tx helper.Querier, idWebhook int, id int64) (err error) {
+	sqlStatement := `
+	UPDATE webhook_delivery
+	SET status='pending', attempts=0, next_attempt_at=NOW()
+	WHERE id_delivery=$1 AND id_webhook=$2`
+	res, err := tx.Exec(ctx, sqlStatement, id, idWebhook)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return fiber.NewError(404, fmt.Sprintf("Webhook delivery with id %d not found", id))
+	}
+	return nil
+}
+
+// DeleteExpired delete the delivered and failed delivery older than retentionDays
+func (r *WebhookRepository) DeleteExpired(ctx context.Context, tx helper.Querier, retentionDays int) (count int64, err error) {
+	sqlStatement := `DELETE FROM webhook_delivery WHERE status <> 'pending' AND created_at < NOW() - make_interval(days => $1)`
+	res, err := tx.Exec(ctx, sqlStatement, retentionDays)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), nil
+}
@@ -0,0 +1,375 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/ingest"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ApiService implement iot.v1.NodeService, iot.v1.SensorService and iot.v1.ChannelService of
+// api.proto with the repositories of the REST API and the same access rule
+type ApiService struct {
+	db                *pgxpool.Pool
+	nodeRepository    *repositories.NodeRepository
+	sensorRepository  *repositories.SensorRepository
+	channelRepository *repositories.ChannelRepository
+	pipeline          *ingest.Pipeline
+	validator         *dependencies.Validator
+}
+
+func invalidArgument(err error) error {
+	return &Status{Code: codeInvalidArgument, Message: err.Error()}
+}
+
+// recvFields read the request message of a unary or server streaming call
+func recvFields(stream *Stream) ([]protoField, error) {
+	message, err := stream.Recv()
+	if err == io.EOF {
+		return nil, &Status{Code: codeInvalidArgument, Message: "Request message is missing"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	fields, err := decodeFields(message)
+	if err != nil {
+		return nil, invalidArgument(err)
+	}
+	return fields, nil
+}
+
+// recvId read a GetRequest
+func recvId(stream *Stream) (int, error) {
+	fields, err := recvFields(stream)
+	if err != nil {
+		return 0, err
+	}
+	id := 0
+	for _, field := range fields {
+		if field.number == 1 {
+			id = int(int32(field.value))
+		}
+	}
+	if id <= 0 {
+		return 0, &Status{Code: codeInvalidArgument, Message: "id is required"}
+	}
+	return id, nil
+}
+
+func encodeNode(w *protoWriter, node entities.Node) {
+	w.int64(1, int64(node.IdNode))
+	w.string(2, node.Name)
+	w.string(3, node.Location)
+	w.int64(4, int64(node.IdHardware))
+	w.int64(5, int64(node.IdUser))
+}
+
+func encodeSensor(w *protoWriter, sensor entities.Sensor) {
+	w.int64(1, int64(sensor.IdSensor))
+	w.string(2, sensor.Name)
+	w.string(3, sensor.Unit)
+	w.int64(4, int64(sensor.IdNode))
+	w.int64(5, int64(sensor.IdHardware))
+}
+
+func sendEncoded(stream *Stream, encode func(w *protoWriter)) error {
+	w := &protoWriter{}
+	encode(w)
+	return stream.Send(w.buffer.Bytes())
+}
+
+// ListNodes stream the node of the user and the node shared with them, every node for an admin
+func (s *ApiService) ListNodes(stream *Stream) error {
+	ctx := stream.request.Context()
+	fields, err := recvFields(stream)
+	if err != nil {
+		return err
+	}
+	query := entities.NodeQuery{}
+	for _, field := range fields {
+		switch field.number {
+		case 1:
+			query.Search = string(field.data)
+		case 2:
+			query.IdHardware = int(int32(field.value))
+		}
+	}
+	err = s.validator.ValidateStruct(&query)
+	if err != nil {
+		return invalidArgument(err)
+	}
+
+	nodes, err := s.nodeRepository.GetAll(ctx, s.db, &stream.currentUser, &query)
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		err = sendEncoded(stream, func(w *protoWriter) { encodeNode(w, node) })
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetNode return a node the user can see
+func (s *ApiService) GetNode(stream *Stream) error {
+	ctx := stream.request.Context()
+	id, err := recvId(stream)
+	if err != nil {
+		return err
+	}
+	node, err := s.nodeRepository.GetById(ctx, s.db, id)
+	if err != nil {
+		return err
+	}
+	allowed, err := s.nodeRepository.HasAccess(ctx, s.db, node, &stream.currentUser, entities.ShareRead)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return &Status{Code: codePermissionDenied, Message: "You can’t see another user’s node"}
+	}
+	return sendEncoded(stream, func(w *protoWriter) { encodeNode(w, node) })
+}
+
+// ListSensors stream the sensor of the node the user can see
+func (s *ApiService) ListSensors(stream *Stream) error {
+	ctx := stream.request.Context()
+	fields, err := recvFields(stream)
+	if err != nil {
+		return err
+	}
+	query := entities.SensorQuery{}
+	for _, field := range fields {
+		switch field.number {
+		case 1:
+			query.IdNode = int(int32(field.value))
+		case 2:
+			query.Search = string(field.data)
+		}
+	}
+	err = s.validator.ValidateStruct(&query)
+	if err != nil {
+		return invalidArgument(err)
+	}
+
+	sensors, err := s.sensorRepository.GetAll(ctx, s.db, &stream.currentUser, &query)
+	if err != nil {
+		return err
+	}
+	for _, sensor := range sensors {
+		err = sendEncoded(stream, func(w *protoWriter) { encodeSensor(w, sensor) })
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSensorAccess return a permission denied status when the user can't access the node of the
+// sensor with the permission, like validateSensorAccess of the sensor handler
+func (s *ApiService) checkSensorAccess(ctx context.Context, currentUser *entities.UserRead, id int, permission string) error {
+	allowed, err := s.nodeRepository.HasSensorAccess(ctx, s.db, id, currentUser, permission)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return &Status{Code: codePermissionDenied, Message: "You can’t see another user’s sensor"}
+	}
+	return nil
+}
+
+// GetSensor return a sensor the user can see
+func (s *ApiService) GetSensor(stream *Stream) error {
+	ctx := stream.request.Context()
+	id, err := recvId(stream)
+	if err != nil {
+		return err
+	}
+	sensor, err := s.sensorRepository.GetById(ctx, s.db, id)
+	if err != nil {
+		return err
+	}
+	err = s.checkSensorAccess(ctx, &stream.currentUser, id, entities.ShareRead)
+	if err != nil {
+		return err
+	}
+	return sendEncoded(stream, func(w *protoWriter) { encodeSensor(w, sensor) })
+}
+
+func decodeChannelCreate(message []byte) (payload entities.ChannelCreate, err error) {
+	fields, err := decodeFields(message)
+	if err != nil {
+		return payload, invalidArgument(err)
+	}
+	for _, field := range fields {
+		switch field.number {
+		case 1:
+			payload.IdSensor = int(int32(field.value))
+		case 2:
+			payload.Value = math.Float64frombits(field.value)
+		case 3:
+			payload.Name = string(field.data)
+		case 4:
+			payload.Quality = string(field.data)
+		}
+	}
+	return payload, nil
+}
+
+// Ingest store each reading of the client stream like POST /channel and answer the count when the
+// client close its side. The sensor owner is read once per sensor. A refused reading is counted
+// and recorded in the ingest stat of its sensor, only an error of the server end the call
+func (s *ApiService) Ingest(stream *Stream) error {
+	ctx := stream.request.Context()
+	owners := map[int]int{}
+	var stored, coalesced, dropped, failed int
+	firstError := ""
+	for {
+		message, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		err = s.ingest(ctx, stream, owners, message, &coalesced)
+		var fiberError *fiber.Error
+		switch {
+		case errors.Is(err, ingest.ErrDropped):
+			dropped++
+		case err == nil:
+			stored++
+		case errors.As(err, &fiberError) || errors.As(err, new(*Status)):
+			failed++
+			if firstError == "" {
+				firstError = err.Error()
+			}
+		default:
+			return err
+		}
+	}
+
+	return sendEncoded(stream, func(w *protoWriter) {
+		w.int64(1, int64(stored))
+		w.int64(2, int64(coalesced))
+		w.int64(3, int64(dropped))
+		w.int64(4, int64(failed))
+		w.string(5, firstError)
+	})
+}
+
+func (s *ApiService) ingest(ctx context.Context, stream *Stream, owners map[int]int, message []byte, coalesced *int) (err error) {
+	payload, err := decodeChannelCreate(message)
+	if err != nil {
+		return err
+	}
+	err = s.validator.ValidateStruct(&payload)
+	if err != nil {
+		return err
+	}
+
+	// Only the sensor the user can write to is cached
+	owner, ok := owners[payload.IdSensor]
+	if !ok {
+		allowed, err := s.nodeRepository.HasSensorAccess(ctx, s.db, payload.IdSensor, &stream.currentUser, entities.ShareWrite)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return fiber.NewError(fiber.StatusForbidden, "You can't send channel to another user's sensor")
+		}
+		owner, err = s.sensorRepository.GetIdUserWhoOwnSensorById(ctx, s.db, payload.IdSensor)
+		if err != nil {
+			return err
+		}
+		owners[payload.IdSensor] = owner
+	}
+
+	_, isCoalesced, err := s.pipeline.Store(ctx, owner, &payload)
+	s.pipeline.Record(payload.IdSensor, len(message), err)
+	if err == nil && isCoalesced {
+		*coalesced++
+	}
+	return err
+}
+
+func decodeChannelQuery(message []byte) (id int, query entities.ChannelQuery, err error) {
+	fields, err := decodeFields(message)
+	if err != nil {
+		return 0, query, invalidArgument(err)
+	}
+	for _, field := range fields {
+		switch field.number {
+		case 1:
+			id = int(int32(field.value))
+		case 2:
+			from := time.UnixMicro(int64(field.value)).UTC()
+			query.From = &from
+		case 3:
+			to := time.UnixMicro(int64(field.value)).UTC()
+			query.To = &to
+		case 4:
+			seconds := math.Float64frombits(field.value)
+			if seconds < 0 || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+				return 0, query, &Status{Code: codeInvalidArgument, Message: "interval must be a positive number of seconds"}
+			}
+			query.Interval = time.Duration(seconds * float64(time.Second))
+		case 5:
+			query.Aggregate = string(field.data)
+		case 6:
+			query.Quality = append(query.Quality, string(field.data))
+		case 7:
+			query.Filtered = field.value != 0
+		case 8:
+			name := string(field.data)
+			query.Name = &name
+		}
+	}
+	return id, query, nil
+}
+
+// Query stream the reading of a sensor the user can see, the same as GET /sensor/{id}/export
+func (s *ApiService) Query(stream *Stream) error {
+	ctx := stream.request.Context()
+	message, err := stream.Recv()
+	if err == io.EOF {
+		return &Status{Code: codeInvalidArgument, Message: "Request message is missing"}
+	}
+	if err != nil {
+		return err
+	}
+	id, query, err := decodeChannelQuery(message)
+	if err != nil {
+		return err
+	}
+	if id <= 0 {
+		return &Status{Code: codeInvalidArgument, Message: "id_sensor is required"}
+	}
+	if query.From != nil && query.To != nil && !query.From.Before(*query.To) {
+		return &Status{Code: codeInvalidArgument, Message: "from must be before to"}
+	}
+	err = s.validator.ValidateStruct(&query)
+	if err != nil {
+		return invalidArgument(err)
+	}
+	err = s.checkSensorAccess(ctx, &stream.currentUser, id, entities.ShareRead)
+	if err != nil {
+		return err
+	}
+
+	stream.SendHeader()
+	return s.channelRepository.ForEachBySensor(ctx, s.db, id, query, func(channel entities.Channel) error {
+		return sendEncoded(stream, func(w *protoWriter) { encodeChannel(w, channel) })
+	})
+}
@@ -0,0 +1,212 @@
+// Package rpc serve the gRPC API on its own port. The gRPC framing is implemented on the HTTP/2
+// of net/http and the message is encoded by hand (wire.go), the contract is in stream.proto and
+// api.proto.
+// net/http only speak HTTP/2 over TLS, so the server need a certificate.
+package rpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+)
+
+// gRPC status code
+const (
+	codeOK                = 0
+	codeCanceled          = 1
+	codeInvalidArgument   = 3
+	codeNotFound          = 5
+	codePermissionDenied  = 7
+	codeResourceExhausted = 8
+	codeInternal          = 13
+	codeUnimplemented     = 12
+	codeUnavailable       = 14
+	codeUnauthenticated   = 16
+)
+
+// Biggest request message accepted, like the default of grpc-go
+const maxRequestSize = 4 << 20
+
+// Status is an error returned to the client as grpc-status and grpc-message
+type Status struct {
+	Code    int
+	Message string
+}
+
+func (s *Status) Error() string {
+	return fmt.Sprintf("rpc error: code = %d desc = %s", s.Code, s.Message)
+}
+
+// statusFromError map the fiber error of the repositories to the gRPC status
+func statusFromError(err error) *Status {
+	var status *Status
+	if errors.As(err, &status) {
+		return status
+	}
+	var fiberError *fiber.Error
+	if errors.As(err, &fiberError) {
+		switch fiberError.Code {
+		case 400, 413, 415:
+			return &Status{Code: codeInvalidArgument, Message: fiberError.Message}
+		case 401:
+			return &Status{Code: codeUnauthenticated, Message: fiberError.Message}
+		case 403:
+			return &Status{Code: codePermissionDenied, Message: fiberError.Message}
+		case 404:
+			return &Status{Code: codeNotFound, Message: fiberError.Message}
+		case 429:
+			return &Status{Code: codeResourceExhausted, Message: fiberError.Message}
+		case 503:
+			return &Status{Code: codeUnavailable, Message: fiberError.Message}
+		}
+	}
+	log.Printf("[RPC] Error: %v", err)
+	return &Status{Code: codeInternal, Message: "Internal server error"}
+}
+
+// encodeGrpcMessage percent encode the status message as required by the grpc-message trailer
+func encodeGrpcMessage(message string) string {
+	var builder strings.Builder
+	for _, b := range []byte(message) {
+		if b >= 0x20 && b <= 0x7e && b != '%' {
+			builder.WriteByte(b)
+		} else {
+			fmt.Fprintf(&builder, "%%%02X", b)
+		}
+	}
+	return builder.String()
+}
+
+// Stream is one call of a method, the request message is read with Recv and the response written with Send
+type Stream struct {
+	writer      http.ResponseWriter
+	request     *http.Request
+	currentUser entities.UserRead
+	headerSent  bool
+}
+
+// Recv read the next request message, io.EOF when the client has no more message
+func (s *Stream) Recv() ([]byte, error) {
+	var prefix [5]byte
+	_, err := io.ReadFull(s.request.Body, prefix[:])
+	if err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, &Status{Code: codeInvalidArgument, Message: "Request message is truncated"}
+		}
+		return nil, err
+	}
+	if prefix[0] != 0 {
+		return nil, &Status{Code: codeUnimplemented, Message: "Compressed message is not supported"}
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > maxRequestSize {
+		return nil, &Status{Code: codeInvalidArgument, Message: fmt.Sprintf("Request message is bigger than %d byte", maxRequestSize)}
+	}
+	message := make([]byte, size)
+	_, err = io.ReadFull(s.request.Body, message)
+	if err != nil {
+		return nil, &Status{Code: codeInvalidArgument, Message: "Request message is truncated"}
+	}
+	return message, nil
+}
+
+// SendHeader start the response, it is sent by the first Send otherwise
+func (s *Stream) SendHeader() {
+	if s.headerSent {
+		return
+	}
+	s.headerSent = true
+	s.writer.WriteHeader(http.StatusOK)
+	if flusher, ok := s.writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Send write a response message and flush it to the client
+func (s *Stream) Send(message []byte) error {
+	s.SendHeader()
+	var prefix [5]byte
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(message)))
+	_, err := s.writer.Write(prefix[:])
+	if err != nil {
+		return err
+	}
+	_, err = s.writer.Write(message)
+	if err != nil {
+		return err
+	}
+	if flusher, ok := s.writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// Server route the gRPC call by its path, every method need the user token in the authorization
+// metadata as "Bearer {token}"
+type Server struct {
+	methods map[string]func(stream *Stream) error
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 {
+		http.Error(w, "gRPC need HTTP/2", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	contentType := strings.TrimSpace(strings.SplitN(r.Header.Get("Content-Type"), ";", 2)[0])
+	if r.Method != http.MethodPost || (contentType != "application/grpc" && contentType != "application/grpc+proto") {
+		http.Error(w, "Not a gRPC request", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	stream := &Stream{writer: w, request: r}
+	err := s.call(stream, r.URL.Path)
+
+	status := &Status{Code: codeOK}
+	if err != nil {
+		status = statusFromError(err)
+	}
+	stream.SendHeader()
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(status.Code))
+	if status.Message != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", encodeGrpcMessage(status.Message))
+	}
+}
+
+func (s *Server) call(stream *Stream, path string) error {
+	method, ok := s.methods[path]
+	if !ok {
+		return &Status{Code: codeUnimplemented, Message: fmt.Sprintf("Method %s is not implemented", path)}
+	}
+
+	authorization := strings.SplitN(stream.request.Header.Get("Authorization"), " ", 2)
+	if len(authorization) != 2 || authorization[0] != "Bearer" {
+		return &Status{Code: codeUnauthenticated, Message: "Authorization metadata must be 'Bearer {token}'"}
+	}
+	currentUser, err := helper.ValidateUserToken(authorization[1])
+	if err != nil {
+		var fiberError *fiber.Error
+		if errors.As(err, &fiberError) {
+			return err
+		}
+		return &Status{Code: codeUnauthenticated, Message: err.Error()}
+	}
+	stream.currentUser = currentUser
+
+	return method(stream)
+}
+
+// ListenAndServeTLS serve the gRPC API until it fail
+func (s *Server) ListenAndServeTLS(addr string, certFile string, keyFile string) error {
+	server := &http.Server{Addr: addr, Handler: s}
+	return server.ListenAndServeTLS(certFile, keyFile)
+}
@@ -0,0 +1,243 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+)
+
+// frame prefix the message like a gRPC client
+func frame(messages ...[]byte) []byte {
+	body := &bytes.Buffer{}
+	for _, message := range messages {
+		var prefix [5]byte
+		binary.BigEndian.PutUint32(prefix[1:], uint32(len(message)))
+		body.Write(prefix[:])
+		body.Write(message)
+	}
+	return body.Bytes()
+}
+
+// unframe split the response body into its message
+func unframe(t *testing.T, body []byte) [][]byte {
+	t.Helper()
+	messages := [][]byte{}
+	for len(body) > 0 {
+		if len(body) < 5 {
+			t.Fatalf("response is truncated: %x", body)
+		}
+		size := int(binary.BigEndian.Uint32(body[1:5]))
+		if body[0] != 0 || len(body) < 5+size {
+			t.Fatalf("response frame is invalid: %x", body)
+		}
+		messages = append(messages, body[5:5+size])
+		body = body[5+size:]
+	}
+	return messages
+}
+
+type testCall struct {
+	messages [][]byte
+	status   int
+	message  string
+}
+
+// newTestServer serve the methods over HTTP/2 with TLS like ListenAndServeTLS
+func newTestServer(t *testing.T, methods map[string]func(stream *Stream) error) (*httptest.Server, string) {
+	t.Helper()
+	server := httptest.NewUnstartedServer(&Server{methods: methods})
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	token, err := helper.SignUserToken(entities.UserRead{IdUser: 7, Username: "budi", Email: "budi@example.com", Status: true, SessionId: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, token
+}
+
+func call(t *testing.T, server *httptest.Server, token string, path string, body []byte, header http.Header) testCall {
+	t.Helper()
+	request, err := http.NewRequest(http.MethodPost, server.URL+path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Content-Type", "application/grpc")
+	request.Header.Set("TE", "trailers")
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	for key, values := range header {
+		request.Header[key] = values
+	}
+
+	response, err := server.Client().Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.ProtoMajor != 2 {
+		t.Fatalf("response is HTTP/%d, want HTTP/2", response.ProtoMajor)
+	}
+	if response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != "application/grpc" {
+		t.Fatalf("response is %d %s", response.StatusCode, response.Header.Get("Content-Type"))
+	}
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The trailer is only read once the body is read
+	status, err := strconv.Atoi(response.Trailer.Get("Grpc-Status"))
+	if err != nil {
+		t.Fatalf("grpc-status trailer is %q", response.Trailer.Get("Grpc-Status"))
+	}
+	message, err := url.PathUnescape(response.Trailer.Get("Grpc-Message"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testCall{messages: unframe(t, responseBody), status: status, message: message}
+}
+
+func TestServerRoundTrip(t *testing.T) {
+	var currentUser entities.UserRead
+	server, token := newTestServer(t, map[string]func(stream *Stream) error{
+		// Echo every request message then the count, like a client streaming call
+		"/test.Echo/Stream": func(stream *Stream) error {
+			currentUser = stream.currentUser
+			count := 0
+			for {
+				message, err := stream.Recv()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return err
+				}
+				count++
+				err = stream.Send(message)
+				if err != nil {
+					return err
+				}
+			}
+			return sendEncoded(stream, func(w *protoWriter) { w.int64(1, int64(count)) })
+		},
+	})
+
+	first := &protoWriter{}
+	first.int64(1, 42)
+	first.string(2, "héllo")
+	result := call(t, server, token, "/test.Echo/Stream", frame(first.buffer.Bytes(), nil, []byte("third")), nil)
+	if result.status != codeOK || result.message != "" {
+		t.Fatalf("status is %d %q", result.status, result.message)
+	}
+	if len(result.messages) != 4 {
+		t.Fatalf("got %d messages, want 4", len(result.messages))
+	}
+	if !bytes.Equal(result.messages[0], first.buffer.Bytes()) || len(result.messages[1]) != 0 || string(result.messages[2]) != "third" {
+		t.Fatalf("echo is %x", result.messages)
+	}
+	fields, err := decodeFields(result.messages[3])
+	if err != nil || fields[0].value != 3 {
+		t.Fatalf("count is %+v %v", fields, err)
+	}
+	if currentUser.IdUser != 7 || currentUser.Username != "budi" {
+		t.Fatalf("current user is %+v", currentUser)
+	}
+}
+
+func TestServerStatus(t *testing.T) {
+	server, token := newTestServer(t, map[string]func(stream *Stream) error{
+		"/test.Echo/NotFound": func(stream *Stream) error {
+			return fiber.NewError(404, "Sensor with id 9 not found")
+		},
+		"/test.Echo/Denied": func(stream *Stream) error {
+			// The message already sent is kept before the status
+			err := stream.Send([]byte("partial"))
+			if err != nil {
+				return err
+			}
+			return &Status{Code: codePermissionDenied, Message: "You can’t see another user’s sensor"}
+		},
+		"/test.Echo/Internal": func(stream *Stream) error {
+			return errors.New("connection refused")
+		},
+		"/test.Echo/Recv": func(stream *Stream) error {
+			_, err := stream.Recv()
+			return err
+		},
+	})
+
+	tests := []struct {
+		name     string
+		path     string
+		token    string
+		body     []byte
+		status   int
+		message  string
+		messages int
+	}{
+		{"fiber error", "/test.Echo/NotFound", token, nil, codeNotFound, "Sensor with id 9 not found", 0},
+		{"status with message", "/test.Echo/Denied", token, nil, codePermissionDenied, "You can’t see another user’s sensor", 1},
+		{"internal error is hidden", "/test.Echo/Internal", token, nil, codeInternal, "Internal server error", 0},
+		{"unknown method", "/test.Echo/Missing", token, nil, codeUnimplemented, "Method /test.Echo/Missing is not implemented", 0},
+		{"missing token", "/test.Echo/NotFound", "", nil, codeUnauthenticated, "Authorization metadata must be 'Bearer {token}'", 0},
+		{"invalid token", "/test.Echo/NotFound", "abc", nil, codeUnauthenticated, "Token is malformed", 0},
+		{"compressed message", "/test.Echo/Recv", token, []byte{1, 0, 0, 0, 1, 0}, codeUnimplemented, "Compressed message is not supported", 0},
+		{"truncated prefix", "/test.Echo/Recv", token, []byte{0, 0, 0}, codeInvalidArgument, "Request message is truncated", 0},
+		{"truncated message", "/test.Echo/Recv", token, []byte{0, 0, 0, 0, 9, 1}, codeInvalidArgument, "Request message is truncated", 0},
+		{"too big", "/test.Echo/Recv", token, []byte{0, 0xff, 0, 0, 0}, codeInvalidArgument, "Request message is bigger than 4194304 byte", 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			result := call(t, server, tc.token, tc.path, tc.body, nil)
+			if result.status != tc.status || result.message != tc.message {
+				t.Fatalf("status is %d %q, want %d %q", result.status, result.message, tc.status, tc.message)
+			}
+			if len(result.messages) != tc.messages {
+				t.Fatalf("got %d messages, want %d", len(result.messages), tc.messages)
+			}
+		})
+	}
+}
+
+func TestServerRejectNonGrpc(t *testing.T) {
+	server, _ := newTestServer(t, map[string]func(stream *Stream) error{})
+
+	response, err := server.Client().Post(server.URL+"/test.Echo/Stream", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusUnsupportedMediaType {
+		t.Fatalf("status is %d, want 415", response.StatusCode)
+	}
+
+	// net/http speak HTTP/1.1 without the TLS negotiation
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/test.Echo/Stream", nil)
+	request.Header.Set("Content-Type", "application/grpc")
+	(&Server{}).ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusHTTPVersionNotSupported {
+		t.Fatalf("status is %d, want 505", recorder.Code)
+	}
+}
+
+func TestEncodeGrpcMessage(t *testing.T) {
+	got := encodeGrpcMessage("can’t 100%\n")
+	want := "can%E2%80%99t 100%25%0A"
+	if got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,190 @@
+package rpc
+
+import (
+	"fmt"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/ingest"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Same limit as the realtime websocket
+const subscribeMaxSensor = 200
+
+// SensorStreamService implement iot.v1.SensorStream of stream.proto
+type SensorStreamService struct {
+	db                  *pgxpool.Pool
+	nodeRepository      *repositories.NodeRepository
+	sensorRepository    *repositories.SensorRepository
+	channelRepository   *repositories.ChannelRepository
+	dashboardRepository *repositories.DashboardRepository
+	hub                 *dependencies.RealtimeHub
+}
+
+func NewServer(db *pgxpool.Pool, nodeRepository *repositories.NodeRepository, sensorRepository *repositories.SensorRepository, channelRepository *repositories.ChannelRepository, dashboardRepository *repositories.DashboardRepository, pipeline *ingest.Pipeline, hub *dependencies.RealtimeHub, validator *dependencies.Validator) (Server, error) {
+	sensorStream := &SensorStreamService{
+		db:                  db,
+		nodeRepository:      nodeRepository,
+		sensorRepository:    sensorRepository,
+		channelRepository:   channelRepository,
+		dashboardRepository: dashboardRepository,
+		hub:                 hub,
+	}
+	api := &ApiService{
+		db:                db,
+		nodeRepository:    nodeRepository,
+		sensorRepository:  sensorRepository,
+		channelRepository: channelRepository,
+		pipeline:          pipeline,
+		validator:         validator,
+	}
+	return Server{
+		methods: map[string]func(stream *Stream) error{
+			"/iot.v1.SensorStream/Subscribe": sensorStream.Subscribe,
+			"/iot.v1.NodeService/List":       api.ListNodes,
+			"/iot.v1.NodeService/Get":        api.GetNode,
+			"/iot.v1.SensorService/List":     api.ListSensors,
+			"/iot.v1.SensorService/Get":      api.GetSensor,
+			"/iot.v1.ChannelService/Ingest":  api.Ingest,
+			"/iot.v1.ChannelService/Query":   api.Query,
+		},
+	}, nil
+}
+
+// SubscribeRequest is the request of Subscribe
+type SubscribeRequest struct {
+	SensorIds []int
+	Latest    bool
+}
+
+func decodeSubscribeRequest(message []byte) (request SubscribeRequest, err error) {
+	fields, err := decodeFields(message)
+	if err != nil {
+		return request, &Status{Code: codeInvalidArgument, Message: err.Error()}
+	}
+	for _, field := range fields {
+		switch field.number {
+		case 1:
+			values, err := field.varints()
+			if err != nil {
+				return request, &Status{Code: codeInvalidArgument, Message: err.Error()}
+			}
+			for _, value := range values {
+				request.SensorIds = append(request.SensorIds, int(int32(value)))
+			}
+		case 2:
+			request.Latest = field.value != 0
+		}
+	}
+	return request, nil
+}
+
+func encodeReading(w *protoWriter, channel entities.Channel) {
+	w.int64(1, int64(channel.IdSensor))
+	w.int64(2, channel.Time.UnixMicro())
+	w.double(3, channel.Value)
+}
+
+// encodeChannel is encodeReading with the name and quality of the channel
+func encodeChannel(w *protoWriter, channel entities.Channel) {
+	encodeReading(w, channel)
+	w.string(4, channel.Name)
+	w.string(5, channel.Quality)
+}
+
+func encodeAlert(w *protoWriter, channel entities.Channel, widget entities.Widget) {
+	encodeReading(w, channel)
+	w.int64(4, int64(widget.IdWidget))
+	if widget.Options.Min != nil {
+		w.double(5, *widget.Options.Min)
+	}
+	if widget.Options.Max != nil {
+		w.double(6, *widget.Options.Max)
+	}
+	w.string(7, widget.Title)
+}
+
+// Subscribe push every new reading of the sensors, with the latest reading first when requested.
+// A reading outside the normal range of an alert widget of the sensor is also pushed as an alert,
+// the widgets are read when the stream start
+func (s *SensorStreamService) Subscribe(stream *Stream) error {
+	ctx := stream.request.Context()
+	message, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	request, err := decodeSubscribeRequest(message)
+	if err != nil {
+		return err
+	}
+	if len(request.SensorIds) == 0 {
+		return &Status{Code: codeInvalidArgument, Message: "sensor_ids is required"}
+	}
+	if len(request.SensorIds) > subscribeMaxSensor {
+		return &Status{Code: codeInvalidArgument, Message: fmt.Sprintf("Can't subscribe to more than %d sensors", subscribeMaxSensor)}
+	}
+
+	for _, id := range request.SensorIds {
+		allowed, err := s.nodeRepository.HasSensorAccess(ctx, s.db, id, &stream.currentUser, entities.ShareRead)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return &Status{Code: codePermissionDenied, Message: "You can’t see another user’s sensor"}
+		}
+	}
+
+	alertWidgets, err := s.dashboardRepository.GetAlertWidgetsBySensors(ctx, s.db, request.SensorIds)
+	if err != nil {
+		return err
+	}
+
+	// Subscribe before reading the latest channel so nothing is missed in between
+	updates, unsubscribe := s.hub.Subscribe(request.SensorIds)
+	defer unsubscribe()
+	stream.SendHeader()
+
+	if request.Latest {
+		latest, err := s.channelRepository.GetLatestBySensors(ctx, s.db, request.SensorIds)
+		if err != nil {
+			return err
+		}
+		for _, channel := range latest {
+			err = s.sendReading(stream, channel)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return &Status{Code: codeCanceled, Message: "Client closed the stream"}
+		case channel := <-updates:
+			err = s.sendReading(stream, channel)
+			if err != nil {
+				return err
+			}
+			for _, widget := range alertWidgets[channel.IdSensor] {
+				if !widget.Options.IsOutOfRange(channel.Value) {
+					continue
+				}
+				event := &protoWriter{}
+				event.message(2, func(w *protoWriter) { encodeAlert(w, channel, widget) })
+				err = stream.Send(event.buffer.Bytes())
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+func (s *SensorStreamService) sendReading(stream *Stream, channel entities.Channel) error {
+	event := &protoWriter{}
+	event.message(1, func(w *protoWriter) { encodeChannel(w, channel) })
+	return stream.Send(event.buffer.Bytes())
+}
@@ -0,0 +1,143 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Protobuf wire type
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errWireTruncated = errors.New("protobuf message is truncated")
+
+// protoWriter encode a protobuf message, a field with the default value is still written
+type protoWriter struct {
+	buffer bytes.Buffer
+}
+
+func (w *protoWriter) uvarint(value uint64) {
+	var scratch [binary.MaxVarintLen64]byte
+	w.buffer.Write(scratch[:binary.PutUvarint(scratch[:], value)])
+}
+
+func (w *protoWriter) key(field int, wireType int) {
+	w.uvarint(uint64(field)<<3 | uint64(wireType))
+}
+
+// int32 and int64 is the same on the wire, a negative value take ten byte
+func (w *protoWriter) int64(field int, value int64) {
+	w.key(field, wireVarint)
+	w.uvarint(uint64(value))
+}
+
+func (w *protoWriter) bool(field int, value bool) {
+	w.key(field, wireVarint)
+	if value {
+		w.uvarint(1)
+	} else {
+		w.uvarint(0)
+	}
+}
+
+func (w *protoWriter) double(field int, value float64) {
+	w.key(field, wireFixed64)
+	var scratch [8]byte
+	binary.LittleEndian.PutUint64(scratch[:], math.Float64bits(value))
+	w.buffer.Write(scratch[:])
+}
+
+func (w *protoWriter) bytes(field int, value []byte) {
+	w.key(field, wireBytes)
+	w.uvarint(uint64(len(value)))
+	w.buffer.Write(value)
+}
+
+func (w *protoWriter) string(field int, value string) {
+	w.bytes(field, []byte(value))
+}
+
+func (w *protoWriter) message(field int, encode func(w *protoWriter)) {
+	inner := &protoWriter{}
+	encode(inner)
+	w.bytes(field, inner.buffer.Bytes())
+}
+
+// protoField is a decoded field, value is set for varint and fixed field, data for length delimited
+type protoField struct {
+	number   int
+	wireType int
+	value    uint64
+	data     []byte
+}
+
+// decodeFields return every field of the message in order, a repeated field appear more than once
+func decodeFields(message []byte) ([]protoField, error) {
+	fields := []protoField{}
+	for len(message) > 0 {
+		key, n := binary.Uvarint(message)
+		if n <= 0 {
+			return nil, errWireTruncated
+		}
+		message = message[n:]
+
+		field := protoField{number: int(key >> 3), wireType: int(key & 7)}
+		switch field.wireType {
+		case wireVarint:
+			field.value, n = binary.Uvarint(message)
+			if n <= 0 {
+				return nil, errWireTruncated
+			}
+			message = message[n:]
+		case wireFixed64:
+			if len(message) < 8 {
+				return nil, errWireTruncated
+			}
+			field.value = binary.LittleEndian.Uint64(message)
+			message = message[8:]
+		case wireFixed32:
+			if len(message) < 4 {
+				return nil, errWireTruncated
+			}
+			field.value = uint64(binary.LittleEndian.Uint32(message))
+			message = message[4:]
+		case wireBytes:
+			size, n := binary.Uvarint(message)
+			if n <= 0 || size > uint64(len(message)-n) {
+				return nil, errWireTruncated
+			}
+			field.data = message[n : n+int(size)]
+			message = message[n+int(size):]
+		default:
+			return nil, errors.New("protobuf group is not supported")
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// varints return the value of a repeated integer field, packed or not
+func (f protoField) varints() ([]uint64, error) {
+	if f.wireType == wireVarint {
+		return []uint64{f.value}, nil
+	}
+	if f.wireType != wireBytes {
+		return nil, errors.New("protobuf field is not an integer")
+	}
+	values := []uint64{}
+	for data := f.data; len(data) > 0; {
+		value, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errWireTruncated
+		}
+		values = append(values, value)
+		data = data[n:]
+	}
+	return values, nil
+}
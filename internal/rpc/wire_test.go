@@ -0,0 +1,240 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/entities"
+)
+
+func TestProtoWriterGolden(t *testing.T) {
+	// The example of the protobuf encoding guide, and the negative int32 taking ten byte
+	tests := []struct {
+		name   string
+		encode func(w *protoWriter)
+		want   string
+	}{
+		{"varint", func(w *protoWriter) { w.int64(1, 150) }, "089601"},
+		{"string", func(w *protoWriter) { w.string(2, "testing") }, "120774657374696e67"},
+		{"message", func(w *protoWriter) { w.message(3, func(w *protoWriter) { w.int64(1, 150) }) }, "1a03089601"},
+		{"negative", func(w *protoWriter) { w.int64(1, -1) }, "08ffffffffffffffffff01"},
+		{"bool", func(w *protoWriter) { w.bool(7, true); w.bool(8, false) }, "38014000"},
+		{"double", func(w *protoWriter) { w.double(3, 1.5) }, "19000000000000f83f"},
+		{"empty string", func(w *protoWriter) { w.string(5, "") }, "2a00"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := &protoWriter{}
+			tc.encode(w)
+			got := hex.EncodeToString(w.buffer.Bytes())
+			if got != tc.want {
+				t.Fatalf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestProtoRoundTrip(t *testing.T) {
+	w := &protoWriter{}
+	w.int64(1, 42)
+	w.int64(2, -7)
+	w.double(3, -273.15)
+	w.string(4, "suhu ruang")
+	w.bool(5, true)
+	w.bytes(6, []byte{0, 1, 2})
+	w.message(7, func(w *protoWriter) { w.string(1, "inner") })
+	w.string(8, "first")
+	w.string(8, "second")
+
+	fields, err := decodeFields(w.buffer.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 9 {
+		t.Fatalf("decoded %d fields, want 9", len(fields))
+	}
+	if fields[0].number != 1 || fields[0].wireType != wireVarint || fields[0].value != 42 {
+		t.Errorf("field 1 is %+v", fields[0])
+	}
+	if int32(fields[1].value) != -7 {
+		t.Errorf("field 2 is %d, want -7", int32(fields[1].value))
+	}
+	if fields[2].wireType != wireFixed64 || math.Float64frombits(fields[2].value) != -273.15 {
+		t.Errorf("field 3 is %+v", fields[2])
+	}
+	if fields[3].wireType != wireBytes || string(fields[3].data) != "suhu ruang" {
+		t.Errorf("field 4 is %+v", fields[3])
+	}
+	if fields[4].value != 1 {
+		t.Errorf("field 5 is %+v", fields[4])
+	}
+	if !bytes.Equal(fields[5].data, []byte{0, 1, 2}) {
+		t.Errorf("field 6 is %+v", fields[5])
+	}
+	inner, err := decodeFields(fields[6].data)
+	if err != nil || len(inner) != 1 || string(inner[0].data) != "inner" {
+		t.Errorf("field 7 is %+v %v", inner, err)
+	}
+	if string(fields[7].data) != "first" || string(fields[8].data) != "second" {
+		t.Errorf("repeated field 8 is %q %q", fields[7].data, fields[8].data)
+	}
+}
+
+func TestDecodeFieldsFixed32(t *testing.T) {
+	// float field 1 = 1.0, a type the server never write but a client may send
+	fields, err := decodeFields([]byte{0x0d, 0x00, 0x00, 0x80, 0x3f})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 1 || fields[0].wireType != wireFixed32 || math.Float32frombits(uint32(fields[0].value)) != 1 {
+		t.Fatalf("got %+v", fields)
+	}
+}
+
+func TestDecodeFieldsTruncated(t *testing.T) {
+	tests := map[string]string{
+		"key":            "80",
+		"varint":         "0896",
+		"fixed64":        "19000000",
+		"fixed32":        "0d0000",
+		"length":         "12",
+		"data":           "120774657374",
+		"huge length":    "12ffffffffffffffffff01",
+		"group":          "0b",
+		"packed varints": "0a0196",
+	}
+	for name, message := range tests {
+		t.Run(name, func(t *testing.T) {
+			data, _ := hex.DecodeString(message)
+			fields, err := decodeFields(data)
+			if name == "packed varints" {
+				if err == nil {
+					_, err = fields[0].varints()
+				}
+			}
+			if err == nil {
+				t.Fatalf("decoding %s should fail", message)
+			}
+		})
+	}
+}
+
+func TestVarints(t *testing.T) {
+	// Packed like proto3 write a repeated int32, then one more unpacked
+	w := &protoWriter{}
+	w.message(1, func(w *protoWriter) {
+		w.uvarint(3)
+		w.uvarint(270)
+		w.uvarint(86942)
+	})
+	w.int64(1, 5)
+	w.bool(2, true)
+
+	request, err := decodeSubscribeRequest(w.buffer.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int{3, 270, 86942, 5}
+	if len(request.SensorIds) != len(want) {
+		t.Fatalf("got %v, want %v", request.SensorIds, want)
+	}
+	for i := range want {
+		if request.SensorIds[i] != want[i] {
+			t.Fatalf("got %v, want %v", request.SensorIds, want)
+		}
+	}
+	if !request.Latest {
+		t.Fatal("latest should be true")
+	}
+
+	_, err = protoField{wireType: wireFixed64}.varints()
+	if err == nil {
+		t.Fatal("a fixed64 field isn't an integer")
+	}
+}
+
+func TestDecodeChannelCreate(t *testing.T) {
+	w := &protoWriter{}
+	w.int64(1, 12)
+	w.double(2, 21.4)
+	w.string(3, "humidity")
+	w.string(4, "suspect")
+	w.string(99, "unknown field is skipped")
+
+	payload, err := decodeChannelCreate(w.buffer.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload.IdSensor != 12 || payload.Value != 21.4 || payload.Name != "humidity" || payload.Quality != "suspect" {
+		t.Fatalf("got %+v", payload)
+	}
+
+	_, err = decodeChannelCreate([]byte{0x08})
+	if _, ok := err.(*Status); !ok || err.(*Status).Code != codeInvalidArgument {
+		t.Fatalf("got %v, want invalid argument", err)
+	}
+}
+
+func TestDecodeChannelQuery(t *testing.T) {
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(24 * time.Hour)
+	w := &protoWriter{}
+	w.int64(1, 3)
+	w.int64(2, from.UnixMicro())
+	w.int64(3, to.UnixMicro())
+	w.double(4, 3600)
+	w.string(5, "max")
+	w.string(6, "good")
+	w.string(6, "suspect")
+	w.bool(7, true)
+	w.string(8, "x")
+
+	id, query, err := decodeChannelQuery(w.buffer.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 3 || !query.From.Equal(from) || !query.To.Equal(to) || query.Interval != time.Hour || query.Aggregate != "max" {
+		t.Fatalf("got %d %+v", id, query)
+	}
+	if len(query.Quality) != 2 || query.Quality[1] != "suspect" || !query.Filtered || query.Name == nil || *query.Name != "x" {
+		t.Fatalf("got %+v", query)
+	}
+
+	w = &protoWriter{}
+	w.double(4, -1)
+	_, _, err = decodeChannelQuery(w.buffer.Bytes())
+	if status, ok := err.(*Status); !ok || status.Code != codeInvalidArgument {
+		t.Fatalf("got %v, want invalid argument", err)
+	}
+}
+
+func TestEncodeChannel(t *testing.T) {
+	channel := entities.Channel{
+		Time: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		ChannelCreate: entities.ChannelCreate{
+			IdSensor: 4,
+			Value:    -0.5,
+			Name:     "x",
+			Quality:  "good",
+		},
+	}
+	w := &protoWriter{}
+	encodeChannel(w, channel)
+
+	fields, err := decodeFields(w.buffer.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 5 {
+		t.Fatalf("got %d fields, want 5", len(fields))
+	}
+	if fields[0].value != 4 || int64(fields[1].value) != channel.Time.UnixMicro() || math.Float64frombits(fields[2].value) != -0.5 {
+		t.Fatalf("got %+v", fields)
+	}
+	if string(fields[3].data) != "x" || string(fields[4].data) != "good" {
+		t.Fatalf("got %+v", fields)
+	}
+}
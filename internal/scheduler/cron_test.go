@@ -0,0 +1,110 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	at := func(value string) time.Time {
+		parsed, err := time.Parse("2006-01-02 15:04:05", value)
+		if err != nil {
+			panic(err)
+		}
+		return parsed
+	}
+
+	// 2024-05-01 is a Wednesday
+	tests := []struct {
+		spec  string
+		after string
+		want  string
+	}{
+		{"*/5 * * * *", "2024-05-01 10:02:30", "2024-05-01 10:05:00"},
+		{"* * * * *", "2024-05-01 10:02:30", "2024-05-01 10:03:00"},
+		{"0 * * * *", "2024-05-01 10:00:00", "2024-05-01 11:00:00"},
+		{"15,45 9-10 * * *", "2024-05-01 10:45:00", "2024-05-02 09:15:00"},
+		{"0 22-23/1 * * *", "2024-05-01 23:30:00", "2024-05-02 22:00:00"},
+		{"@hourly", "2024-05-01 10:59:59", "2024-05-01 11:00:00"},
+		{"@daily", "2024-05-01 23:59:00", "2024-05-02 00:00:00"},
+		{"@midnight", "2024-12-31 12:00:00", "2025-01-01 00:00:00"},
+		{"@weekly", "2024-05-01 00:00:00", "2024-05-05 00:00:00"},
+		{"@monthly", "2024-05-01 00:00:00", "2024-06-01 00:00:00"},
+		{"0 0 * 1 *", "2024-05-01 00:00:00", "2025-01-01 00:00:00"},
+		{"0 0 31 * *", "2024-04-15 00:00:00", "2024-05-31 00:00:00"},
+		{"0 12 29 2 *", "2024-05-01 00:00:00", "2028-02-29 12:00:00"},
+		// Only the day-of-week is restricted
+		{"30 8 * * 1-5", "2024-05-03 09:00:00", "2024-05-06 08:30:00"},
+		{"0 0 * * 0,6", "2024-05-01 00:00:00", "2024-05-04 00:00:00"},
+		// Only the day-of-month is restricted
+		{"0 0 13 * *", "2024-05-01 00:00:00", "2024-05-13 00:00:00"},
+		// Both are restricted, either match
+		{"0 0 1 * 1", "2024-05-01 00:00:00", "2024-05-06 00:00:00"},
+		{"0 0 1 * 1", "2024-05-27 00:00:00", "2024-06-01 00:00:00"},
+		{"0 0 13 * 5", "2024-05-01 00:00:00", "2024-05-03 00:00:00"},
+		{"0 0 13 * 5", "2024-05-10 00:00:00", "2024-05-13 00:00:00"},
+		{"0 0 1-7 * 1", "2024-05-08 00:00:00", "2024-05-13 00:00:00"},
+		// A field starting with * doesn't restrict the day even with a step, both must match
+		{"0 0 */2 * 1", "2024-05-01 00:00:00", "2024-05-13 00:00:00"},
+		{"0 0 1 * */7", "2024-05-02 00:00:00", "2024-09-01 00:00:00"},
+		// The month restrict both day field
+		{"0 0 1 6 1", "2024-05-01 00:00:00", "2024-06-01 00:00:00"},
+		{"0 0 1 6 1", "2024-06-01 00:00:00", "2024-06-03 00:00:00"},
+		// A day that never come stop at the five year limit
+		{"0 0 31 2 *", "2024-05-01 00:00:00", "2029-05-01 00:01:00"},
+		{"@every 90s", "2024-05-01 10:00:10", "2024-05-01 10:01:40"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.spec+" after "+tc.after, func(t *testing.T) {
+			schedule, err := ParseSchedule(tc.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := schedule.Next(at(tc.after))
+			if !got.Equal(at(tc.want)) {
+				t.Fatalf("got %s (%s), want %s", got.Format("2006-01-02 15:04:05"), got.Weekday(), tc.want)
+			}
+		})
+	}
+}
+
+func TestScheduleNextLocation(t *testing.T) {
+	jakarta := time.FixedZone("WIB", 7*60*60)
+	schedule, err := ParseSchedule("0 7 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := schedule.Next(time.Date(2024, 5, 1, 8, 0, 0, 0, jakarta))
+	want := time.Date(2024, 5, 2, 7, 0, 0, 0, jakarta)
+	if !got.Equal(want) {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestParseScheduleError(t *testing.T) {
+	specs := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 7",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"1-a * * * *",
+		"@yearly",
+		"@every 10ms",
+		"@every ten minutes",
+	}
+	for _, spec := range specs {
+		t.Run(spec, func(t *testing.T) {
+			_, err := ParseSchedule(spec)
+			if err == nil {
+				t.Fatalf("%q should be invalid", spec)
+			}
+		})
+	}
+}
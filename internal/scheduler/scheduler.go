@@ -0,0 +1,218 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// JobFunc is the work of a job, the returned message is saved as the last run message
+type JobFunc func(ctx context.Context) (message string, err error)
+
+type job struct {
+	name     string
+	spec     string
+	schedule Schedule
+	fn       JobFunc
+	next     time.Time
+	running  bool
+}
+
+type Scheduler struct {
+	db         *pgxpool.Pool
+	repository *repositories.JobRepository
+	config     *configs.Config
+	cluster    *dependencies.Cluster
+	mutex      sync.Mutex
+	jobs       map[string]*job
+}
+
+func NewScheduler(db *pgxpool.Pool, jobRepository *repositories.JobRepository, config *configs.Config, cluster *dependencies.Cluster) (Scheduler, error) {
+	return Scheduler{
+		db:         db,
+		repository: jobRepository,
+		config:     config,
+		cluster:    cluster,
+		jobs:       map[string]*job{},
+	}, nil
+}
+
+// Register add a job with the default schedule, the schedule can be overridden in config scheduler.jobs
+func (s *Scheduler) Register(name string, defaultSpec string, fn JobFunc) error {
+	spec := defaultSpec
+	if override, ok := s.config.Scheduler.Jobs[name]; ok && override != "" {
+		spec = override
+	}
+
+	schedule, err := ParseSchedule(spec)
+	if err != nil {
+		return fmt.Errorf("job %s: %w", name, err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, exist := s.jobs[name]; exist {
+		return fmt.Errorf("job %s already registered", name)
+	}
+
+	s.jobs[name] = &job{
+		name:     name,
+		spec:     spec,
+		schedule: schedule,
+		fn:       fn,
+		next:     schedule.Next(time.Now().UTC()),
+	}
+	return nil
+}
+
+// Start run the scheduler loop in background until the context is canceled
+func (s *Scheduler) Start(ctx context.Context) error {
+	if !s.config.Scheduler.Enabled {
+		log.Println("Scheduler is disabled")
+		return nil
+	}
+
+	s.mutex.Lock()
+	for _, j := range s.jobs {
+		err := s.repository.Register(ctx, s.db, j.name, j.spec, j.next)
+		if err != nil {
+			s.mutex.Unlock()
+			return err
+		}
+	}
+	s.mutex.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				s.runDueJobs(ctx, now.UTC())
+			}
+		}
+	}()
+
+	log.Printf("Scheduler started with %d jobs", len(s.jobs))
+	return nil
+}
+
+func (s *Scheduler) runDueJobs(ctx context.Context, now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, j := range s.jobs {
+		if j.running || now.Before(j.next) {
+			continue
+		}
+
+		scheduledAt := j.next
+		j.next = j.schedule.Next(now)
+		j.running = true
+		go s.execute(ctx, j, scheduledAt, false)
+	}
+}
+
+// RunNow trigger the job immediately in background, regardless of its schedule
+func (s *Scheduler) RunNow(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	j, ok := s.jobs[name]
+	if !ok {
+		return fiber.NewError(404, fmt.Sprintf("Job with name %s not found", name))
+	}
+	if j.running {
+		return fiber.NewError(fiber.StatusConflict, fmt.Sprintf("Job %s is already running", name))
+	}
+
+	j.running = true
+	go s.execute(context.Background(), j, time.Now().UTC(), true)
+	return nil
+}
+
+// Execute the job while holding a postgres advisory lock, so only one instance run the job at a time
+func (s *Scheduler) execute(ctx context.Context, j *job, scheduledAt time.Time, force bool) {
+	defer func() {
+		s.mutex.Lock()
+		j.running = false
+		s.mutex.Unlock()
+	}()
+
+	conn, err := s.db.Acquire(ctx)
+	if err != nil {
+		log.Printf("[SCHEDULER] Job %s can't acquire connection: %v", j.name, err)
+		return
+	}
+	defer conn.Release()
+
+	var locked bool
+	err = conn.QueryRow(ctx, `SELECT pg_try_advisory_lock(hashtext($1))`, "scheduled_job:"+j.name).Scan(&locked)
+	if err != nil {
+		log.Printf("[SCHEDULER] Job %s can't take lock: %v", j.name, err)
+		return
+	}
+	if !locked {
+		return
+	}
+	defer func() {
+		_, err := conn.Exec(context.Background(), `SELECT pg_advisory_unlock(hashtext($1))`, "scheduled_job:"+j.name)
+		if err != nil {
+			log.Printf("[SCHEDULER] Job %s can't release lock: %v", j.name, err)
+		}
+	}()
+
+	// Another instance may have run this occurrence before we got the lock
+	status, err := s.repository.GetByName(ctx, conn, j.name)
+	if err != nil {
+		log.Printf("[SCHEDULER] Job %s can't read status: %v", j.name, err)
+		return
+	}
+	if !force && status.LastRunAt != nil && !status.LastRunAt.Before(scheduledAt) {
+		return
+	}
+
+	startedAt := time.Now().UTC()
+	err = s.repository.MarkStarted(ctx, conn, j.name, startedAt, s.cluster.InstanceId)
+	if err != nil {
+		log.Printf("[SCHEDULER] Job %s can't mark started: %v", j.name, err)
+		return
+	}
+
+	message, err := s.safeRun(ctx, j)
+	runStatus := "success"
+	if err != nil {
+		runStatus = "failed"
+		message = err.Error()
+		log.Printf("[SCHEDULER] Job %s failed: %v", j.name, err)
+	}
+
+	s.mutex.Lock()
+	next := j.next
+	s.mutex.Unlock()
+
+	err = s.repository.MarkFinished(context.Background(), conn, j.name, time.Since(startedAt), runStatus, message, next)
+	if err != nil {
+		log.Printf("[SCHEDULER] Job %s can't mark finished: %v", j.name, err)
+	}
+}
+
+func (s *Scheduler) safeRun(ctx context.Context, j *job) (message string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return j.fn(ctx)
+}
@@ -0,0 +1,126 @@
+package script
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// builtins can be called by every script
+var builtins = map[string]Func{
+	"abs":   math1(math.Abs),
+	"floor": math1(math.Floor),
+	"ceil":  math1(math.Ceil),
+	"sqrt":  math1(math.Sqrt),
+	"exp":   math1(math.Exp),
+	"log":   math1(math.Log),
+	"log10": math1(math.Log10),
+	"sin":   math1(math.Sin),
+	"cos":   math1(math.Cos),
+	"pow": func(args []Value) (Value, error) {
+		numbers, err := numberArgs(args, 2, 2)
+		if err != nil {
+			return nil, err
+		}
+		return math.Pow(numbers[0], numbers[1]), nil
+	},
+	// round(x) or round(x, digits)
+	"round": func(args []Value) (Value, error) {
+		numbers, err := numberArgs(args, 1, 2)
+		if err != nil {
+			return nil, err
+		}
+		if len(numbers) == 1 {
+			return math.Round(numbers[0]), nil
+		}
+		scale := math.Pow(10, math.Max(-15, math.Min(15, math.Round(numbers[1]))))
+		return math.Round(numbers[0]*scale) / scale, nil
+	},
+	"min": func(args []Value) (Value, error) {
+		numbers, err := numberArgs(args, 1, 64)
+		if err != nil {
+			return nil, err
+		}
+		result := numbers[0]
+		for _, number := range numbers[1:] {
+			result = math.Min(result, number)
+		}
+		return result, nil
+	},
+	"max": func(args []Value) (Value, error) {
+		numbers, err := numberArgs(args, 1, 64)
+		if err != nil {
+			return nil, err
+		}
+		result := numbers[0]
+		for _, number := range numbers[1:] {
+			result = math.Max(result, number)
+		}
+		return result, nil
+	},
+	// number parse a string, it return null when the string isn't a number
+	"number": func(args []Value) (Value, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("need 1 argument")
+		}
+		switch v := args[0].(type) {
+		case float64:
+			return v, nil
+		case bool:
+			if v {
+				return 1.0, nil
+			}
+			return 0.0, nil
+		case string:
+			number, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return nil, nil
+			}
+			return number, nil
+		}
+		return nil, nil
+	},
+	"string": func(args []Value) (Value, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("need 1 argument")
+		}
+		return toString(args[0]), nil
+	},
+	"isNaN": func(args []Value) (Value, error) {
+		numbers, err := numberArgs(args, 1, 1)
+		if err != nil {
+			return nil, err
+		}
+		return math.IsNaN(numbers[0]), nil
+	},
+}
+
+func math1(fn func(float64) float64) Func {
+	return func(args []Value) (Value, error) {
+		numbers, err := numberArgs(args, 1, 1)
+		if err != nil {
+			return nil, err
+		}
+		return fn(numbers[0]), nil
+	}
+}
+
+// numberArgs check there are min to max argument and each is a number
+func numberArgs(args []Value, min int, max int) ([]float64, error) {
+	if len(args) < min || len(args) > max {
+		if min == max {
+			return nil, fmt.Errorf("need %d argument", min)
+		}
+		return nil, fmt.Errorf("need %d to %d argument", min, max)
+	}
+	numbers := make([]float64, len(args))
+	for i, arg := range args {
+		number, ok := arg.(float64)
+		if !ok {
+			return nil, fmt.Errorf("argument %d is %s, not a number", i+1, typeName(arg))
+		}
+		numbers[i] = number
+	}
+	return numbers, nil
+}
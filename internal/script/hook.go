@@ -0,0 +1,236 @@
+package script
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// alert() call a script can make in one run, so a script can't flood the notification of its owner
+const maxAlerts = 5
+
+// Function given to the script of each event, beside the builtin one
+var eventFuncs = map[string][]string{
+	entities.ScriptIngest: {"drop", "reject"},
+	entities.ScriptAlert:  {"alert"},
+}
+
+type compiled struct {
+	updatedAt time.Time
+	program   *Program
+	err       error
+}
+
+// Hooks run the active script of the user on the ingest and alert event of their sensor. A script
+// is compiled once per change
+type Hooks struct {
+	db                     *pgxpool.Pool
+	repository             *repositories.ScriptRepository
+	notificationRepository *repositories.NotificationRepository
+	limits                 Limits
+	mu                     sync.Mutex
+	programs               map[int]compiled
+}
+
+func NewHooks(db *pgxpool.Pool, scriptRepository *repositories.ScriptRepository, notificationRepository *repositories.NotificationRepository, config *configs.Config) (*Hooks, error) {
+	return &Hooks{
+		db:                     db,
+		repository:             scriptRepository,
+		notificationRepository: notificationRepository,
+		limits: Limits{
+			MaxSteps: config.Script.MaxSteps,
+			Timeout:  time.Duration(config.Script.TimeoutMs) * time.Millisecond,
+		},
+		programs: map[int]compiled{},
+	}, nil
+}
+
+// Check compile the source of the event, the syntax error is a 400
+func (h *Hooks) Check(event string, source string) error {
+	_, err := Compile(source, eventFuncs[event]...)
+	if err != nil {
+		return fiber.NewError(400, "The script has a syntax error, "+err.Error())
+	}
+	return nil
+}
+
+func (h *Hooks) program(script entities.Script) (*Program, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c, ok := h.programs[script.IdScript]
+	if !ok || !c.updatedAt.Equal(script.UpdatedAt) {
+		c.updatedAt = script.UpdatedAt
+		c.program, c.err = Compile(script.Source, eventFuncs[script.Event]...)
+		h.programs[script.IdScript] = c
+	}
+	return c.program, c.err
+}
+
+// run the program of the event on the channel, the result keep the channel as it was when the
+// script failed
+func (h *Hooks) run(program *Program, event string, channel entities.Channel) (result entities.ScriptResult) {
+	result = entities.ScriptResult{Value: channel.Value, Name: channel.Name, Alerts: []entities.ScriptAlertCall{}}
+	quality := channel.Quality
+	if quality == "" {
+		quality = entities.QualityGood
+	}
+	vars := map[string]Value{
+		"value":     channel.Value,
+		"name":      channel.Name,
+		"quality":   quality,
+		"id_sensor": float64(channel.IdSensor),
+		"time":      float64(channel.Time.UnixMilli()) / 1000,
+	}
+
+	funcs := map[string]Func{}
+	switch event {
+	case entities.ScriptIngest:
+		funcs["drop"] = func(args []Value) (Value, error) {
+			result.Dropped = true
+			return nil, Stop
+		}
+		funcs["reject"] = func(args []Value) (Value, error) {
+			reason := "no reason given"
+			if len(args) > 0 {
+				reason = toString(args[0])
+			}
+			result.Reject = &reason
+			return nil, Stop
+		}
+	case entities.ScriptAlert:
+		funcs["alert"] = func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return nil, fmt.Errorf("need a title and an optional message")
+			}
+			if len(result.Alerts) == maxAlerts {
+				return nil, fmt.Errorf("a script can send %d alert per run", maxAlerts)
+			}
+			call := entities.ScriptAlertCall{Title: toString(args[0])}
+			if len(args) == 2 {
+				call.Message = toString(args[1])
+			}
+			if len(call.Title) > 255 {
+				call.Title = call.Title[:255]
+			}
+			result.Alerts = append(result.Alerts, call)
+			return nil, nil
+		}
+	}
+
+	fail := func(message string) entities.ScriptResult {
+		return entities.ScriptResult{Value: channel.Value, Name: channel.Name, Alerts: []entities.ScriptAlertCall{}, Error: &message}
+	}
+	_, err := program.Run(vars, funcs, h.limits)
+	if err != nil {
+		return fail(err.Error())
+	}
+	if event == entities.ScriptIngest {
+		value, ok := vars["value"].(float64)
+		if !ok {
+			return fail("value must be a number after the script")
+		}
+		name, ok := vars["name"].(string)
+		if !ok || len(name) > 32 {
+			return fail("name must be a string of at most 32 byte after the script")
+		}
+		result.Value, result.Name = value, name
+	}
+	return result
+}
+
+// Test run the source on the sample channel, nothing is stored or notified
+func (h *Hooks) Test(payload *entities.ScriptTest) (result entities.ScriptResult, err error) {
+	program, err := Compile(payload.Source, eventFuncs[payload.Event]...)
+	if err != nil {
+		return result, fiber.NewError(400, "The script has a syntax error, "+err.Error())
+	}
+	channel := entities.Channel{
+		Time: time.Now().UTC(),
+		ChannelCreate: entities.ChannelCreate{
+			Value:   payload.Value,
+			Name:    payload.Name,
+			Quality: payload.Quality,
+		},
+	}
+	return h.run(program, payload.Event, channel), nil
+}
+
+// setError save the error of the run, failing to save it doesn't fail the ingest
+func (h *Hooks) setError(ctx context.Context, script entities.Script, message *string) {
+	err := h.repository.SetError(ctx, h.db, script.IdScript, message)
+	if err != nil {
+		log.Printf("[SCRIPT] Error saving the error of script %d: %v", script.IdScript, err)
+	}
+}
+
+// Ingest run the ingest script of the sensor owned by idUser on the received channel in order,
+// changing its value and name. dropped is true when a script called drop(), and reject() is a 422.
+// A failing script is skipped and its error is kept in the script
+func (h *Hooks) Ingest(ctx context.Context, idUser int, payload *entities.ChannelCreate) (dropped bool, err error) {
+	scripts, err := h.repository.GetActive(ctx, h.db, idUser, payload.IdSensor, entities.ScriptIngest)
+	if err != nil {
+		return false, err
+	}
+
+	for _, script := range scripts {
+		program, err := h.program(script)
+		if err != nil {
+			message := err.Error()
+			h.setError(ctx, script, &message)
+			continue
+		}
+		result := h.run(program, script.Event, entities.Channel{Time: time.Now().UTC(), ChannelCreate: *payload})
+		h.setError(ctx, script, result.Error)
+		if result.Error != nil {
+			continue
+		}
+		if result.Reject != nil {
+			return false, fiber.NewError(fiber.StatusUnprocessableEntity, fmt.Sprintf("The channel is rejected by the script %s, %s", script.Name, *result.Reject))
+		}
+		if result.Dropped {
+			return true, nil
+		}
+		payload.Value, payload.Name = result.Value, result.Name
+	}
+	return false, nil
+}
+
+// Alert run the alert script of the sensor owned by idUser on the stored channel, each alert() call
+// is an alert notification of the user. It doesn't fail the ingest
+func (h *Hooks) Alert(ctx context.Context, idUser int, channel entities.Channel) {
+	scripts, err := h.repository.GetActive(ctx, h.db, idUser, channel.IdSensor, entities.ScriptAlert)
+	if err != nil {
+		log.Printf("[SCRIPT] Error getting the alert script of sensor %d: %v", channel.IdSensor, err)
+		return
+	}
+
+	for _, script := range scripts {
+		program, err := h.program(script)
+		if err != nil {
+			message := err.Error()
+			h.setError(ctx, script, &message)
+			continue
+		}
+		result := h.run(program, script.Event, channel)
+		h.setError(ctx, script, result.Error)
+
+		for _, alert := range result.Alerts {
+			_, err = h.notificationRepository.Create(ctx, h.db, idUser, &entities.NotificationCreate{
+				Type:    "alert",
+				Title:   alert.Title,
+				Message: alert.Message,
+			})
+			if err != nil {
+				log.Printf("[SCRIPT] Error sending the alert of script %d: %v", script.IdScript, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,428 @@
+package script
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Deepest nesting of block and expression, so a script can't overflow the stack of the parser and
+// the interpreter
+const maxDepth = 64
+
+const (
+	tokenEnd = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenPunct
+)
+
+type token struct {
+	kind int
+	text string
+	line int
+}
+
+var keywords = map[string]bool{
+	"let": true, "if": true, "else": true, "while": true, "return": true,
+	"true": true, "false": true, "null": true,
+}
+
+// Operator and punctuation, the two character one first so they are matched before their prefix
+var puncts = []string{"==", "!=", "<=", ">=", "&&", "||", "(", ")", "{", "}", ",", ";", "=", "<", ">", "+", "-", "*", "/", "%", "!"}
+
+// lex split the source into token, a comment start with // and run to the end of the line
+func lex(source string) ([]token, error) {
+	tokens := []token{}
+	line := 1
+	for i := 0; i < len(source); {
+		c := source[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case strings.HasPrefix(source[i:], "//"):
+			for i < len(source) && source[i] != '\n' {
+				i++
+			}
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(source) && source[i+1] >= '0' && source[i+1] <= '9':
+			start := i
+			for i < len(source) && (source[i] >= '0' && source[i] <= '9' || source[i] == '.') {
+				i++
+			}
+			if i < len(source) && (source[i] == 'e' || source[i] == 'E') {
+				i++
+				if i < len(source) && (source[i] == '+' || source[i] == '-') {
+					i++
+				}
+				for i < len(source) && source[i] >= '0' && source[i] <= '9' {
+					i++
+				}
+			}
+			tokens = append(tokens, token{tokenNumber, source[start:i], line})
+		case c == '"' || c == '\'':
+			text := strings.Builder{}
+			i++
+			for {
+				if i >= len(source) || source[i] == '\n' {
+					return nil, &Error{Line: line, Message: "unterminated string"}
+				}
+				if source[i] == c {
+					i++
+					break
+				}
+				if source[i] == '\\' && i+1 < len(source) {
+					i++
+					switch source[i] {
+					case 'n':
+						text.WriteByte('\n')
+					case 't':
+						text.WriteByte('\t')
+					default:
+						text.WriteByte(source[i])
+					}
+					i++
+					continue
+				}
+				text.WriteByte(source[i])
+				i++
+			}
+			tokens = append(tokens, token{tokenString, text.String(), line})
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			start := i
+			for i < len(source) && (source[i] == '_' || source[i] >= 'a' && source[i] <= 'z' || source[i] >= 'A' && source[i] <= 'Z' || source[i] >= '0' && source[i] <= '9') {
+				i++
+			}
+			tokens = append(tokens, token{tokenIdent, source[start:i], line})
+		default:
+			matched := false
+			for _, punct := range puncts {
+				if strings.HasPrefix(source[i:], punct) {
+					tokens = append(tokens, token{tokenPunct, punct, line})
+					i += len(punct)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, &Error{Line: line, Message: fmt.Sprintf("unexpected character %q", c)}
+			}
+		}
+	}
+	return append(tokens, token{tokenEnd, "", line}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	depth  int
+	// Function the script can call, checked when it is parsed
+	funcs map[string]bool
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEnd {
+		p.pos++
+	}
+	return t
+}
+
+// is return true when the next token is the punctuation or keyword text
+func (p *parser) is(text string) bool {
+	t := p.peek()
+	return (t.kind == tokenPunct || t.kind == tokenIdent) && t.text == text
+}
+
+// back put the token back, the end is never consumed
+func (p *parser) back(t token) {
+	if t.kind != tokenEnd {
+		p.pos--
+	}
+}
+
+func (p *parser) expect(text string) error {
+	if !p.is(text) {
+		return p.unexpected("expected " + text)
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) unexpected(message string) error {
+	t := p.peek()
+	if t.kind == tokenEnd {
+		return &Error{Line: t.line, Message: message + " before the end of the script"}
+	}
+	return &Error{Line: t.line, Message: fmt.Sprintf("%s, found %q", message, t.text)}
+}
+
+func (p *parser) enter() error {
+	p.depth++
+	if p.depth > maxDepth {
+		return &Error{Line: p.peek().line, Message: "the script is nested too deep"}
+	}
+	return nil
+}
+
+// block parse the statement until the closing brace, or until the end of the script at the top level
+func (p *parser) block(top bool) (stmts []stmt, err error) {
+	err = p.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+
+	for {
+		if top && p.peek().kind == tokenEnd {
+			return stmts, nil
+		}
+		if !top && p.is("}") {
+			p.next()
+			return stmts, nil
+		}
+		if p.is(";") {
+			p.next()
+			continue
+		}
+		s, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, s)
+	}
+}
+
+func (p *parser) braced() ([]stmt, error) {
+	err := p.expect("{")
+	if err != nil {
+		return nil, err
+	}
+	return p.block(false)
+}
+
+func (p *parser) statement() (stmt, error) {
+	t := p.peek()
+	switch {
+	case p.is("let"):
+		p.next()
+		name := p.next()
+		if name.kind != tokenIdent || keywords[name.text] {
+			p.back(name)
+			return nil, p.unexpected("expected a variable name")
+		}
+		err := p.expect("=")
+		if err != nil {
+			return nil, err
+		}
+		x, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		return &letStmt{name: name.text, x: x, line: t.line}, nil
+	case p.is("if"):
+		p.next()
+		// An else if is nested in the else of the previous if
+		err := p.enter()
+		if err != nil {
+			return nil, err
+		}
+		defer func() { p.depth-- }()
+		cond, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		s := &ifStmt{cond: cond, line: t.line}
+		s.then, err = p.braced()
+		if err != nil {
+			return nil, err
+		}
+		if p.is("else") {
+			p.next()
+			if p.is("if") {
+				elseIf, err := p.statement()
+				if err != nil {
+					return nil, err
+				}
+				s.els = []stmt{elseIf}
+			} else {
+				s.els, err = p.braced()
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		return s, nil
+	case p.is("while"):
+		p.next()
+		cond, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		body, err := p.braced()
+		if err != nil {
+			return nil, err
+		}
+		return &whileStmt{cond: cond, body: body, line: t.line}, nil
+	case p.is("return"):
+		p.next()
+		s := &returnStmt{line: t.line}
+		if p.is(";") || p.is("}") || p.peek().kind == tokenEnd || p.peek().line != t.line {
+			return s, nil
+		}
+		var err error
+		s.x, err = p.expression()
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case t.kind == tokenIdent && !keywords[t.text] && p.tokens[p.pos+1].kind == tokenPunct && p.tokens[p.pos+1].text == "=":
+		p.next()
+		p.next()
+		x, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		return &assignStmt{name: t.text, x: x, line: t.line}, nil
+	}
+
+	x, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	return &exprStmt{x: x, line: t.line}, nil
+}
+
+// Binary operator by precedence, the lowest first
+var precedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *parser) expression() (expr, error) {
+	err := p.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+	return p.binary(0)
+}
+
+func (p *parser) binary(level int) (expr, error) {
+	if level == len(precedence) {
+		return p.unary()
+	}
+	x, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		matched := false
+		for _, op := range precedence[level] {
+			if t.kind == tokenPunct && t.text == op {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return x, nil
+		}
+		p.next()
+		y, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{op: t.text, x: x, y: y, line: t.line}
+	}
+}
+
+func (p *parser) unary() (expr, error) {
+	t := p.peek()
+	if t.kind == tokenPunct && (t.text == "-" || t.text == "!") {
+		p.next()
+		err := p.enter()
+		if err != nil {
+			return nil, err
+		}
+		defer func() { p.depth-- }()
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op: t.text, x: x, line: t.line}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenNumber:
+		value, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, &Error{Line: t.line, Message: fmt.Sprintf("invalid number %q", t.text)}
+		}
+		return &literal{value: value}, nil
+	case tokenString:
+		return &literal{value: t.text}, nil
+	case tokenIdent:
+		switch t.text {
+		case "true":
+			return &literal{value: true}, nil
+		case "false":
+			return &literal{value: false}, nil
+		case "null":
+			return &literal{value: nil}, nil
+		}
+		if keywords[t.text] {
+			p.back(t)
+			return nil, p.unexpected("expected an expression")
+		}
+		if !p.is("(") {
+			return &ident{name: t.text, line: t.line}, nil
+		}
+
+		p.next()
+		if !p.funcs[t.text] {
+			return nil, &Error{Line: t.line, Message: fmt.Sprintf("unknown function %s", t.text)}
+		}
+		call := &callExpr{name: t.text, line: t.line}
+		for !p.is(")") {
+			if len(call.args) > 0 {
+				err := p.expect(",")
+				if err != nil {
+					return nil, err
+				}
+			}
+			arg, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, arg)
+		}
+		p.next()
+		return call, nil
+	case tokenPunct:
+		if t.text == "(" {
+			x, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		}
+	}
+	p.back(t)
+	return nil, p.unexpected("expected an expression")
+}
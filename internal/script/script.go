@@ -0,0 +1,488 @@
+// Package script run the small script a user attach to the ingest and alert event of their sensor.
+// The language is a subset of JavaScript: number, string, true, false and null, let, assignment,
+// if/else, while, return and a call to a builtin function. It can't reach anything outside the
+// variable and function it is given, and each run is limited in step and time.
+//
+// The script run inside the ingest of every channel, so it must cost about nothing and never take
+// more than its limit. It is interpreted here instead of embedding goja or gopher-lua for that:
+//   - A run is stopped after MaxSteps statement and expression, the same script always stop at the
+//     same place. goja can only be interrupted from another goroutine after a time, and gopher-lua
+//     by a context, so a busy instance would stop a script earlier or later.
+//   - A script can't hold memory: there is no array, object, closure or user function, a string is
+//     at most 1024 byte and the nesting is limited when it is compiled. A goja or Lua script can fill
+//     a table or array until the timeout, and neither engine can limit the memory of a run.
+//   - A call to an unknown function, e.g. a typo, is an error when the script is saved instead of
+//     when a channel is received.
+//   - A run only allocate its variable, a new goja or Lua state cost far more than the script.
+//
+// The language is small on purpose, a feature should only be added when it keep those property.
+package script
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Longest string a script can build
+const maxString = 1024
+
+// Value is a float64, string, bool or nil
+type Value interface{}
+
+// Func is a function the script can call, its error stop the script
+type Func func(args []Value) (Value, error)
+
+// Stop is returned by a Func to end the script without error, like drop()
+var Stop = errors.New("the script stopped")
+
+// Error is a syntax or run error at the line of the script
+type Error struct {
+	Line    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// Limits stop the script running more than MaxSteps statement and expression, or longer than Timeout
+type Limits struct {
+	MaxSteps int
+	Timeout  time.Duration
+}
+
+// Program is a parsed script, it can be run concurrently
+type Program struct {
+	body []stmt
+}
+
+// Compile parse the source, a call to a function that isn't builtin or in funcs is a syntax error
+func Compile(source string, funcs ...string) (*Program, error) {
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, funcs: map[string]bool{}}
+	for name := range builtins {
+		p.funcs[name] = true
+	}
+	for _, name := range funcs {
+		p.funcs[name] = true
+	}
+
+	body, err := p.block(true)
+	if err != nil {
+		return nil, err
+	}
+	return &Program{body: body}, nil
+}
+
+// Run the program with vars as its variable, changed in place by the script, and funcs beside the
+// builtin function. The returned value is the one of the return statement, nil without one
+func (p *Program) Run(vars map[string]Value, funcs map[string]Func, limits Limits) (Value, error) {
+	r := &runner{
+		vars:     vars,
+		funcs:    funcs,
+		maxSteps: limits.MaxSteps,
+		deadline: time.Now().Add(limits.Timeout),
+	}
+	_, err := r.execBlock(p.body)
+	if errors.Is(err, Stop) {
+		return nil, nil
+	}
+	return r.returned, err
+}
+
+type runner struct {
+	vars     map[string]Value
+	funcs    map[string]Func
+	steps    int
+	maxSteps int
+	deadline time.Time
+	returned Value
+}
+
+// step count one step of the script and stop it past its limit, the clock is only read every 256
+// step
+func (r *runner) step(line int) error {
+	r.steps++
+	if r.steps > r.maxSteps {
+		return &Error{Line: line, Message: fmt.Sprintf("the script ran more than %d step", r.maxSteps)}
+	}
+	if r.steps%256 == 0 && time.Now().After(r.deadline) {
+		return &Error{Line: line, Message: "the script ran out of time"}
+	}
+	return nil
+}
+
+type stmt interface {
+	// exec return true when the script returned
+	exec(r *runner) (bool, error)
+}
+
+type expr interface {
+	eval(r *runner) (Value, error)
+}
+
+func (r *runner) execBlock(stmts []stmt) (bool, error) {
+	for _, s := range stmts {
+		done, err := s.exec(r)
+		if done || err != nil {
+			return done, err
+		}
+	}
+	return false, nil
+}
+
+type letStmt struct {
+	name string
+	x    expr
+	line int
+}
+
+func (s *letStmt) exec(r *runner) (bool, error) {
+	err := r.step(s.line)
+	if err != nil {
+		return false, err
+	}
+	value, err := s.x.eval(r)
+	if err != nil {
+		return false, err
+	}
+	r.vars[s.name] = value
+	return false, nil
+}
+
+type assignStmt struct {
+	name string
+	x    expr
+	line int
+}
+
+func (s *assignStmt) exec(r *runner) (bool, error) {
+	err := r.step(s.line)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := r.vars[s.name]; !ok {
+		return false, &Error{Line: s.line, Message: s.name + " is not defined, declare it with let"}
+	}
+	value, err := s.x.eval(r)
+	if err != nil {
+		return false, err
+	}
+	r.vars[s.name] = value
+	return false, nil
+}
+
+type exprStmt struct {
+	x    expr
+	line int
+}
+
+func (s *exprStmt) exec(r *runner) (bool, error) {
+	err := r.step(s.line)
+	if err != nil {
+		return false, err
+	}
+	_, err = s.x.eval(r)
+	return false, err
+}
+
+type ifStmt struct {
+	cond expr
+	then []stmt
+	els  []stmt
+	line int
+}
+
+func (s *ifStmt) exec(r *runner) (bool, error) {
+	err := r.step(s.line)
+	if err != nil {
+		return false, err
+	}
+	cond, err := s.cond.eval(r)
+	if err != nil {
+		return false, err
+	}
+	if truthy(cond) {
+		return r.execBlock(s.then)
+	}
+	return r.execBlock(s.els)
+}
+
+type whileStmt struct {
+	cond expr
+	body []stmt
+	line int
+}
+
+func (s *whileStmt) exec(r *runner) (bool, error) {
+	for {
+		err := r.step(s.line)
+		if err != nil {
+			return false, err
+		}
+		cond, err := s.cond.eval(r)
+		if err != nil {
+			return false, err
+		}
+		if !truthy(cond) {
+			return false, nil
+		}
+		done, err := r.execBlock(s.body)
+		if done || err != nil {
+			return done, err
+		}
+	}
+}
+
+type returnStmt struct {
+	x    expr
+	line int
+}
+
+func (s *returnStmt) exec(r *runner) (bool, error) {
+	err := r.step(s.line)
+	if err != nil {
+		return false, err
+	}
+	if s.x != nil {
+		r.returned, err = s.x.eval(r)
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+type literal struct {
+	value Value
+}
+
+func (x *literal) eval(r *runner) (Value, error) {
+	return x.value, nil
+}
+
+type ident struct {
+	name string
+	line int
+}
+
+func (x *ident) eval(r *runner) (Value, error) {
+	value, ok := r.vars[x.name]
+	if !ok {
+		return nil, &Error{Line: x.line, Message: x.name + " is not defined"}
+	}
+	return value, nil
+}
+
+type unaryExpr struct {
+	op   string
+	x    expr
+	line int
+}
+
+func (x *unaryExpr) eval(r *runner) (Value, error) {
+	err := r.step(x.line)
+	if err != nil {
+		return nil, err
+	}
+	value, err := x.x.eval(r)
+	if err != nil {
+		return nil, err
+	}
+	if x.op == "!" {
+		return !truthy(value), nil
+	}
+	number, ok := value.(float64)
+	if !ok {
+		return nil, &Error{Line: x.line, Message: fmt.Sprintf("can't negate %s", typeName(value))}
+	}
+	return -number, nil
+}
+
+type binaryExpr struct {
+	op   string
+	x    expr
+	y    expr
+	line int
+}
+
+func (x *binaryExpr) eval(r *runner) (Value, error) {
+	err := r.step(x.line)
+	if err != nil {
+		return nil, err
+	}
+	left, err := x.x.eval(r)
+	if err != nil {
+		return nil, err
+	}
+	// && and || return the operand deciding the result like JavaScript, without evaluating the other
+	switch x.op {
+	case "&&":
+		if !truthy(left) {
+			return left, nil
+		}
+		return x.y.eval(r)
+	case "||":
+		if truthy(left) {
+			return left, nil
+		}
+		return x.y.eval(r)
+	}
+	right, err := x.y.eval(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch x.op {
+	case "==":
+		return left == right, nil
+	case "!=":
+		return left != right, nil
+	case "+":
+		leftString, leftOk := left.(string)
+		rightString, rightOk := right.(string)
+		if leftOk || rightOk {
+			if !leftOk {
+				leftString = toString(left)
+			}
+			if !rightOk {
+				rightString = toString(right)
+			}
+			if len(leftString)+len(rightString) > maxString {
+				return nil, &Error{Line: x.line, Message: fmt.Sprintf("the string is longer than %d byte", maxString)}
+			}
+			return leftString + rightString, nil
+		}
+	}
+
+	if leftString, ok := left.(string); ok {
+		if rightString, ok := right.(string); ok {
+			switch x.op {
+			case "<":
+				return leftString < rightString, nil
+			case "<=":
+				return leftString <= rightString, nil
+			case ">":
+				return leftString > rightString, nil
+			case ">=":
+				return leftString >= rightString, nil
+			}
+		}
+	}
+
+	a, leftOk := left.(float64)
+	b, rightOk := right.(float64)
+	if !leftOk || !rightOk {
+		return nil, &Error{Line: x.line, Message: fmt.Sprintf("can't apply %s to %s and %s", x.op, typeName(left), typeName(right))}
+	}
+	switch x.op {
+	case "<":
+		return a < b, nil
+	case "<=":
+		return a <= b, nil
+	case ">":
+		return a > b, nil
+	case ">=":
+		return a >= b, nil
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/", "%":
+		if b == 0 {
+			return nil, &Error{Line: x.line, Message: "division by zero"}
+		}
+		if x.op == "%" {
+			return math.Mod(a, b), nil
+		}
+		return a / b, nil
+	}
+	return nil, &Error{Line: x.line, Message: "unknown operator " + x.op}
+}
+
+type callExpr struct {
+	name string
+	args []expr
+	line int
+}
+
+func (x *callExpr) eval(r *runner) (Value, error) {
+	err := r.step(x.line)
+	if err != nil {
+		return nil, err
+	}
+	args := make([]Value, len(x.args))
+	for i, arg := range x.args {
+		args[i], err = arg.eval(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fn, ok := r.funcs[x.name]
+	if !ok {
+		fn, ok = builtins[x.name]
+	}
+	if !ok {
+		return nil, &Error{Line: x.line, Message: "unknown function " + x.name}
+	}
+	value, err := fn(args)
+	if err != nil {
+		if _, ok := err.(*Error); ok || errors.Is(err, Stop) {
+			return nil, err
+		}
+		return nil, &Error{Line: x.line, Message: x.name + ": " + err.Error()}
+	}
+	return value, nil
+}
+
+// truthy is false for false, null, 0, NaN and the empty string like JavaScript
+func truthy(value Value) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0 && !math.IsNaN(v)
+	case string:
+		return v != ""
+	}
+	return true
+}
+
+func typeName(value Value) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func toString(value Value) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return v
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,264 @@
+package script
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testLimits = Limits{MaxSteps: 10000, Timeout: time.Second}
+
+func run(t *testing.T, source string, vars map[string]Value) (Value, error) {
+	t.Helper()
+	program, err := Compile(source, "emit")
+	if err != nil {
+		return nil, err
+	}
+	if vars == nil {
+		vars = map[string]Value{}
+	}
+	return program.Run(vars, nil, testLimits)
+}
+
+func TestLex(t *testing.T) {
+	tokens, err := lex("let x = 1.5e2 // comment\nx >= 'a\\'b' && !y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []token{
+		{tokenIdent, "let", 1}, {tokenIdent, "x", 1}, {tokenPunct, "=", 1}, {tokenNumber, "1.5e2", 1},
+		{tokenIdent, "x", 2}, {tokenPunct, ">=", 2}, {tokenString, "a'b", 2}, {tokenPunct, "&&", 2},
+		{tokenPunct, "!", 2}, {tokenIdent, "y", 2}, {tokenEnd, "", 2},
+	}
+	if len(tokens) != len(want) {
+		t.Fatalf("got %d token %v, want %d", len(tokens), tokens, len(want))
+	}
+	for i := range want {
+		if tokens[i] != want[i] {
+			t.Errorf("token %d is %v, want %v", i, tokens[i], want[i])
+		}
+	}
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		source string
+		want   Value
+	}{
+		{"return 1 + 2 * 3", 7.0},
+		{"return (1 + 2) * 3", 9.0},
+		{"return 10 - 4 - 3", 3.0},
+		{"return 7 % 4", 3.0},
+		{"return -2 * -3", 6.0},
+		{"return .5 + 1e1", 10.5},
+		{"return 1 < 2 && 2 <= 2", true},
+		{"return 3 > 4 || 4 >= 5", false},
+		{"return 1 == 1 && 'a' != 'b'", true},
+		{"return !0", true},
+		{"return null || 'fallback'", "fallback"},
+		{"return 0 && unknown", 0.0},
+		{"return 'a' < 'b'", true},
+		{"return 'v=' + 1.5", "v=1.5"},
+		{"return true + ''", "true"},
+		{"let x = 1; x = x + 1; return x", 2.0},
+		{"let x = 5\nif x > 3 { return 'big' } else { return 'small' }", "big"},
+		{"let x = 2\nif x > 3 { return 'big' } else if x > 1 { return 'medium' } else { return 'small' }", "medium"},
+		{"let i = 0; let sum = 0; while i < 5 { i = i + 1; sum = sum + i }; return sum", 15.0},
+		{"let x = 1", nil},
+		{"return", nil},
+		{"return round(3.14159, 2)", 3.14},
+		{"return max(1, 5, 3) + min(4, 2)", 7.0},
+		{"return abs(-2) + floor(1.7) + ceil(1.2) + sqrt(9) + pow(2, 3)", 16.0},
+		{"return number(' 4.5 ')", 4.5},
+		{"return number('abc')", nil},
+		{"return string(2) + string(null)", "2null"},
+		{"return isNaN(sqrt(-1))", true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.source, func(t *testing.T) {
+			got, err := run(t, tc.source, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Fatalf("got %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestVarsAndFuncs(t *testing.T) {
+	program, err := Compile("value = value * 2\nemit(value, name)\nif value > 10 { drop() }\nreturn value", "emit", "drop")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	emitted := []Value{}
+	funcs := map[string]Func{
+		"emit": func(args []Value) (Value, error) {
+			emitted = append(emitted, args...)
+			return nil, nil
+		},
+		"drop": func(args []Value) (Value, error) {
+			return nil, Stop
+		},
+	}
+
+	vars := map[string]Value{"value": 3.0, "name": "temp"}
+	got, err := program.Run(vars, funcs, testLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 6.0 || vars["value"] != 6.0 {
+		t.Fatalf("got %v and value %v, want 6", got, vars["value"])
+	}
+	if len(emitted) != 2 || emitted[0] != 6.0 || emitted[1] != "temp" {
+		t.Fatalf("emitted %v", emitted)
+	}
+
+	// Stop end the script without error and without value
+	vars = map[string]Value{"value": 6.0, "name": "temp"}
+	got, err = program.Run(vars, funcs, testLimits)
+	if err != nil || got != nil {
+		t.Fatalf("got %v %v, want nil without error", got, err)
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	tests := []struct {
+		source  string
+		line    int
+		message string
+	}{
+		{"let = 1", 1, "expected a variable name"},
+		{"let x 1", 1, "expected ="},
+		{"if true { return 1", 1, "expected an expression before the end"},
+		{"return (1 + 2", 1, "expected )"},
+		{"let x = 1\nlet y = 'abc", 2, "unterminated string"},
+		{"let x = 1\nx = #", 2, "unexpected character"},
+		{"\n\nfetch('http://example.com')", 3, "unknown function fetch"},
+		{"return while", 1, "expected an expression"},
+		{"return " + strings.Repeat("(", maxDepth+1) + "1" + strings.Repeat(")", maxDepth+1), 1, "nested too deep"},
+		{strings.Repeat("if true {", maxDepth+1), 1, "nested too deep"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.source, func(t *testing.T) {
+			_, err := Compile(tc.source)
+			var scriptErr *Error
+			if !errors.As(err, &scriptErr) {
+				t.Fatalf("got %v, want a script error", err)
+			}
+			if scriptErr.Line != tc.line || !strings.Contains(scriptErr.Message, tc.message) {
+				t.Fatalf("got %q, want line %d %q", err, tc.line, tc.message)
+			}
+		})
+	}
+}
+
+func TestRunError(t *testing.T) {
+	tests := []struct {
+		source  string
+		line    int
+		message string
+	}{
+		{"return y", 1, "y is not defined"},
+		{"y = 1", 1, "y is not defined, declare it with let"},
+		{"let x = 1\nreturn x / 0", 2, "division by zero"},
+		{"return 5 % 0", 1, "division by zero"},
+		{"return -'a'", 1, "can't negate string"},
+		{"return 1 - 'a'", 1, "can't apply - to number and string"},
+		{"return null < 1", 1, "can't apply < to null and number"},
+		{"\nreturn round('a')", 2, "round: argument 1 is string, not a number"},
+		{"return pow(1)", 1, "pow: need 2 argument"},
+		{"let s = 'x'; while true { s = s + s }", 1, "longer than 1024 byte"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.source, func(t *testing.T) {
+			_, err := run(t, tc.source, nil)
+			var scriptErr *Error
+			if !errors.As(err, &scriptErr) {
+				t.Fatalf("got %v, want a script error", err)
+			}
+			if scriptErr.Line != tc.line || !strings.Contains(scriptErr.Message, tc.message) {
+				t.Fatalf("got %q, want line %d %q", err, tc.line, tc.message)
+			}
+		})
+	}
+}
+
+func TestStepLimit(t *testing.T) {
+	program, err := Compile("let i = 0\nwhile true {\ni = i + 1\n}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := map[string]Value{}
+	_, err = program.Run(vars, nil, Limits{MaxSteps: 100, Timeout: time.Minute})
+	var scriptErr *Error
+	if !errors.As(err, &scriptErr) || !strings.Contains(scriptErr.Message, "more than 100 step") {
+		t.Fatalf("got %v, want the step limit error", err)
+	}
+	// The loop is stopped as soon as it pass the limit, each iteration is a few step
+	if i := vars["i"].(float64); i < 10 || i > 100 {
+		t.Fatalf("the loop ran %v time", i)
+	}
+
+	// A script just under the limit finish
+	program, err = Compile("let i = 0\nwhile i < 10 { i = i + 1 }\nreturn i")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := program.Run(map[string]Value{}, nil, Limits{MaxSteps: 100, Timeout: time.Minute})
+	if err != nil || got != 10.0 {
+		t.Fatalf("got %v %v, want 10", got, err)
+	}
+}
+
+func TestTimeLimit(t *testing.T) {
+	program, err := Compile("while true { wait() }", "wait")
+	if err != nil {
+		t.Fatal(err)
+	}
+	funcs := map[string]Func{
+		"wait": func(args []Value) (Value, error) {
+			time.Sleep(10 * time.Microsecond)
+			return nil, nil
+		},
+	}
+
+	start := time.Now()
+	_, err = program.Run(map[string]Value{}, funcs, Limits{MaxSteps: math.MaxInt32, Timeout: 20 * time.Millisecond})
+	elapsed := time.Since(start)
+	var scriptErr *Error
+	if !errors.As(err, &scriptErr) || !strings.Contains(scriptErr.Message, "ran out of time") {
+		t.Fatalf("got %v, want the time limit error", err)
+	}
+	// The clock is read every 256 step, so the script stop shortly after its timeout
+	if elapsed < 20*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("the script stopped after %v", elapsed)
+	}
+}
+
+func TestConcurrentRun(t *testing.T) {
+	program, err := Compile("let i = 0\nwhile i < n { i = i + 1 }\nreturn i")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	for n := 1; n <= 8; n++ {
+		go func(n float64) {
+			got, err := program.Run(map[string]Value{"n": n}, nil, testLimits)
+			if err == nil && got != n {
+				err = errors.New("a run changed another run")
+			}
+			done <- err
+		}(float64(n))
+	}
+	for n := 1; n <= 8; n++ {
+		err := <-done
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package snmp
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BER tag of the SNMP type
+const (
+	tagInteger        = 0x02
+	tagOctetString    = 0x04
+	tagNull           = 0x05
+	tagOid            = 0x06
+	tagSequence       = 0x30
+	tagIpAddress      = 0x40
+	tagCounter32      = 0x41
+	tagGauge32        = 0x42
+	tagTimeTicks      = 0x43
+	tagOpaque         = 0x44
+	tagCounter64      = 0x46
+	tagNoSuchObject   = 0x80
+	tagNoSuchInstance = 0x81
+	tagEndOfMibView   = 0x82
+	tagGetRequest     = 0xa0
+	tagResponse       = 0xa2
+	tagReport         = 0xa8
+)
+
+var errTruncated = errors.New("the response is truncated")
+
+// ParseOid parse the dotted OID like 1.3.6.1.2.1.33.1.2.4.0, a leading dot is allowed
+func ParseOid(text string) ([]uint32, error) {
+	parts := strings.Split(strings.TrimPrefix(text, "."), ".")
+	if len(parts) < 2 || len(parts) > 128 {
+		return nil, fmt.Errorf("OID %q must be dotted numbers, e.g. 1.3.6.1.2.1.1.3.0", text)
+	}
+	oid := make([]uint32, len(parts))
+	for i, part := range parts {
+		number, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("OID %q must be dotted numbers, e.g. 1.3.6.1.2.1.1.3.0", text)
+		}
+		oid[i] = uint32(number)
+	}
+	// The first two arc are encoded as one, 40*first+second, which must fit in an arc too
+	if oid[0] > 2 || (oid[0] < 2 && oid[1] > 39) || oid[1] > 0xffffffff-80 {
+		return nil, fmt.Errorf("OID %q has an invalid first arc", text)
+	}
+	return oid, nil
+}
+
+func formatOid(oid []uint32) string {
+	parts := make([]string, len(oid))
+	for i, arc := range oid {
+		parts[i] = strconv.FormatUint(uint64(arc), 10)
+	}
+	return strings.Join(parts, ".")
+}
+
+// tlv encode the tag, length and content
+func tlv(tag byte, content ...[]byte) []byte {
+	length := 0
+	for _, c := range content {
+		length += len(c)
+	}
+	out := []byte{tag}
+	switch {
+	case length < 0x80:
+		out = append(out, byte(length))
+	case length <= 0xff:
+		out = append(out, 0x81, byte(length))
+	case length <= 0xffff:
+		out = append(out, 0x82, byte(length>>8), byte(length))
+	default:
+		out = append(out, 0x83, byte(length>>16), byte(length>>8), byte(length))
+	}
+	for _, c := range content {
+		out = append(out, c...)
+	}
+	return out
+}
+
+func encodeInteger(value int64) []byte {
+	content := []byte{}
+	for {
+		content = append([]byte{byte(value)}, content...)
+		value >>= 8
+		// Stop once the remaining byte is only the sign extension of the encoded one
+		if (value == 0 && content[0]&0x80 == 0) || (value == -1 && content[0]&0x80 != 0) {
+			break
+		}
+	}
+	return tlv(tagInteger, content)
+}
+
+func encodeOctetString(value []byte) []byte {
+	return tlv(tagOctetString, value)
+}
+
+func encodeOid(oid []uint32) []byte {
+	content := []byte{}
+	arcs := append([]uint32{oid[0]*40 + oid[1]}, oid[2:]...)
+	for _, arc := range arcs {
+		encoded := []byte{byte(arc & 0x7f)}
+		for arc >>= 7; arc > 0; arc >>= 7 {
+			encoded = append([]byte{byte(arc&0x7f) | 0x80}, encoded...)
+		}
+		content = append(content, encoded...)
+	}
+	return tlv(tagOid, content)
+}
+
+// element is a decoded TLV, content is the encoded content
+type element struct {
+	tag     byte
+	content []byte
+}
+
+// readElement return the first element of data and what follow it
+func readElement(data []byte) (e element, rest []byte, err error) {
+	if len(data) < 2 {
+		return e, nil, errTruncated
+	}
+	e.tag = data[0]
+	length := int(data[1])
+	offset := 2
+	if length&0x80 != 0 {
+		size := length & 0x7f
+		if size == 0 || size > 3 || len(data) < offset+size {
+			return e, nil, errTruncated
+		}
+		length = 0
+		for _, b := range data[offset : offset+size] {
+			length = length<<8 | int(b)
+		}
+		offset += size
+	}
+	if len(data) < offset+length {
+		return e, nil, errTruncated
+	}
+	e.content = data[offset : offset+length]
+	return e, data[offset+length:], nil
+}
+
+// readExpected read the first element and check its tag
+func readExpected(data []byte, tag byte) (content []byte, rest []byte, err error) {
+	e, rest, err := readElement(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if e.tag != tag {
+		return nil, nil, fmt.Errorf("unexpected tag 0x%02x instead of 0x%02x", e.tag, tag)
+	}
+	return e.content, rest, nil
+}
+
+func decodeInteger(content []byte) (int64, error) {
+	if len(content) == 0 || len(content) > 8 {
+		return 0, errors.New("invalid integer length")
+	}
+	value := int64(int8(content[0]))
+	for _, b := range content[1:] {
+		value = value<<8 | int64(b)
+	}
+	return value, nil
+}
+
+// decodeUnsigned decode the unsigned type, which may have a leading 0 beyond its size
+func decodeUnsigned(content []byte) (uint64, error) {
+	if len(content) == 0 || len(content) > 9 || (len(content) == 9 && content[0] != 0) {
+		return 0, errors.New("invalid unsigned length")
+	}
+	var value uint64
+	for _, b := range content {
+		value = value<<8 | uint64(b)
+	}
+	return value, nil
+}
+
+func decodeOid(content []byte) ([]uint32, error) {
+	if len(content) == 0 {
+		return nil, errors.New("empty OID")
+	}
+	arcs := []uint32{}
+	var current uint64
+	for i, b := range content {
+		current = current<<7 | uint64(b&0x7f)
+		if current > 0xffffffff {
+			return nil, errors.New("OID arc too large")
+		}
+		if b&0x80 == 0 {
+			arcs = append(arcs, uint32(current))
+			current = 0
+		} else if i == len(content)-1 {
+			return nil, errTruncated
+		}
+	}
+	first := arcs[0]
+	oid := []uint32{}
+	switch {
+	case first < 40:
+		oid = append(oid, 0, first)
+	case first < 80:
+		oid = append(oid, 1, first-40)
+	default:
+		oid = append(oid, 2, first-80)
+	}
+	return append(oid, arcs[1:]...), nil
+}
@@ -0,0 +1,260 @@
+package snmp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"testing"
+)
+
+func TestParseOid(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+		fail bool
+	}{
+		{"1.3.6.1.2.1.1.3.0", "1.3.6.1.2.1.1.3.0", false},
+		{".1.3.6.1.2.1.33.1.2.4.0", "1.3.6.1.2.1.33.1.2.4.0", false},
+		{"0.39", "0.39", false},
+		{"2.999.1", "2.999.1", false},
+		{"1.3.4294967295", "1.3.4294967295", false},
+		{"2.4294967215", "2.4294967215", false},
+		{"1", "", true},
+		{"", "", true},
+		{"1..3", "", true},
+		{"1.3.a", "", true},
+		{"1.3.-1", "", true},
+		{"1.3.4294967296", "", true},
+		{"3.1", "", true},
+		{"1.40", "", true},
+		{"2.4294967216", "", true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.text, func(t *testing.T) {
+			oid, err := ParseOid(tc.text)
+			if (err != nil) != tc.fail {
+				t.Fatalf("got error %v, want failure %v", err, tc.fail)
+			}
+			if err == nil && formatOid(oid) != tc.want {
+				t.Fatalf("got %s, want %s", formatOid(oid), tc.want)
+			}
+		})
+	}
+}
+
+func TestOidRoundTrip(t *testing.T) {
+	tests := []struct {
+		oid  string
+		data string
+	}{
+		{"1.3.6.1.2.1.1.3.0", "06082b06010201010300"},
+		{"1.3.6.1.4.1.2021.10.1.3.1", "060b2b060104018f650a010301"},
+		{"0.0", "060100"},
+		{"2.999.3", "0603883703"},
+		{"1.3.4294967295", "06062b8fffffff7f"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.oid, func(t *testing.T) {
+			oid, err := ParseOid(tc.oid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data := encodeOid(oid)
+			if hex.EncodeToString(data) != tc.data {
+				t.Fatalf("encoded %x, want %s", data, tc.data)
+			}
+			content, rest, err := readExpected(data, tagOid)
+			if err != nil || len(rest) != 0 {
+				t.Fatalf("got rest %x and error %v", rest, err)
+			}
+			decoded, err := decodeOid(content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if formatOid(decoded) != tc.oid {
+				t.Fatalf("decoded %s, want %s", formatOid(decoded), tc.oid)
+			}
+		})
+	}
+}
+
+func TestDecodeOidMalformed(t *testing.T) {
+	tests := map[string]string{
+		"empty":          "",
+		"truncated arc":  "2b86",
+		"arc over 32bit": "2b9080808000",
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			data, _ := hex.DecodeString(content)
+			_, err := decodeOid(data)
+			if err == nil {
+				t.Fatalf("decoding %s should fail", content)
+			}
+		})
+	}
+}
+
+func TestIntegerRoundTrip(t *testing.T) {
+	tests := []struct {
+		value int64
+		data  string
+	}{
+		{0, "020100"},
+		{127, "02017f"},
+		{128, "02020080"},
+		{256, "02020100"},
+		{-1, "0201ff"},
+		{-128, "020180"},
+		{-129, "0202ff7f"},
+		{65507, "020300ffe3"},
+		{math.MaxInt64, "02087fffffffffffffff"},
+		{math.MinInt64, "02088000000000000000"},
+	}
+	for _, tc := range tests {
+		data := encodeInteger(tc.value)
+		if hex.EncodeToString(data) != tc.data {
+			t.Errorf("encodeInteger(%d) is %x, want %s", tc.value, data, tc.data)
+			continue
+		}
+		content, _, err := readExpected(data, tagInteger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := decodeInteger(content)
+		if err != nil || value != tc.value {
+			t.Errorf("decodeInteger(%x) is %d %v, want %d", content, value, err, tc.value)
+		}
+	}
+
+	for _, content := range []string{"", "010203040506070809"} {
+		data, _ := hex.DecodeString(content)
+		_, err := decodeInteger(data)
+		if err == nil {
+			t.Errorf("decodeInteger(%s) should fail", content)
+		}
+	}
+}
+
+func TestDecodeUnsigned(t *testing.T) {
+	tests := []struct {
+		content string
+		value   uint64
+		fail    bool
+	}{
+		{"00", 0, false},
+		{"ff", 255, false},
+		{"00ffffffff", math.MaxUint32, false},
+		{"00ffffffffffffffff", math.MaxUint64, false},
+		{"", 0, true},
+		{"01ffffffffffffffff", 0, true},
+		{"00000000000000000000", 0, true},
+	}
+	for _, tc := range tests {
+		data, _ := hex.DecodeString(tc.content)
+		value, err := decodeUnsigned(data)
+		if (err != nil) != tc.fail || value != tc.value {
+			t.Errorf("decodeUnsigned(%s) is %d %v, want %d", tc.content, value, err, tc.value)
+		}
+	}
+}
+
+func TestElementLengthRoundTrip(t *testing.T) {
+	// The length take one byte below 128, then a 0x81, 0x82 or 0x83 byte and the length
+	tests := []struct {
+		length int
+		header string
+	}{
+		{0, "0400"},
+		{127, "047f"},
+		{128, "048180"},
+		{255, "0481ff"},
+		{256, "04820100"},
+		{65535, "0482ffff"},
+		{65536, "0483010000"},
+	}
+	for _, tc := range tests {
+		content := bytes.Repeat([]byte{'a'}, tc.length)
+		data := append(tlv(tagOctetString, content), 0x05, 0x00)
+		if hex.EncodeToString(data[:len(tc.header)/2]) != tc.header {
+			t.Errorf("length %d is encoded as %x, want %s", tc.length, data[:len(tc.header)/2], tc.header)
+			continue
+		}
+		e, rest, err := readElement(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.tag != tagOctetString || !bytes.Equal(e.content, content) || !bytes.Equal(rest, []byte{0x05, 0x00}) {
+			t.Errorf("length %d is read as tag %x, %d byte and rest %x", tc.length, e.tag, len(e.content), rest)
+		}
+	}
+}
+
+func TestReadElementMalformed(t *testing.T) {
+	tests := map[string]string{
+		"empty":               "",
+		"no length":           "04",
+		"truncated content":   "040461",
+		"indefinite length":   "0480",
+		"length over 3 byte":  "04840000000100",
+		"truncated length":    "048201",
+		"truncated long form": "0481056162",
+	}
+	for name, message := range tests {
+		t.Run(name, func(t *testing.T) {
+			data, _ := hex.DecodeString(message)
+			_, _, err := readElement(data)
+			if err != errTruncated {
+				t.Fatalf("reading %s got %v, want %v", message, err, errTruncated)
+			}
+		})
+	}
+
+	_, _, err := readExpected([]byte{tagInteger, 0x01, 0x00}, tagOctetString)
+	if err == nil {
+		t.Error("an integer read as an octet string should fail")
+	}
+}
+
+// FuzzReadElement check a TLV never panic, and that its content is read back from its encoding
+func FuzzReadElement(f *testing.F) {
+	f.Add([]byte{0x30, 0x03, 0x02, 0x01, 0x00})
+	f.Add([]byte{0x04, 0x81, 0x01, 0x61})
+	f.Add([]byte{0x04, 0x83, 0x00, 0x00, 0x01, 0x61})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		e, rest, err := readElement(data)
+		if err != nil {
+			return
+		}
+		if len(e.content)+len(rest) > len(data) {
+			t.Fatalf("read %d byte of content and %d byte of rest from %d byte", len(e.content), len(rest), len(data))
+		}
+		encoded := tlv(e.tag, e.content)
+		again, _, err := readElement(encoded)
+		if err != nil || again.tag != e.tag || !bytes.Equal(again.content, e.content) {
+			t.Fatalf("%x encoded back as %x", data, encoded)
+		}
+	})
+}
+
+// FuzzDecodeOid check a decoded OID is encoded back to an OID decoded the same, the decoded one may
+// have a redundant leading 0x80 the encoding doesn't keep
+func FuzzDecodeOid(f *testing.F) {
+	f.Add([]byte{0x2b, 0x06, 0x01, 0x02, 0x01, 0x01, 0x03, 0x00})
+	f.Add([]byte{0x88, 0x37, 0x03})
+	f.Add([]byte{0x2b, 0x8f, 0xff, 0xff, 0xff, 0x7f})
+	f.Fuzz(func(t *testing.T, content []byte) {
+		oid, err := decodeOid(content)
+		if err != nil {
+			return
+		}
+		again, _, err := readExpected(encodeOid(oid), tagOid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := decodeOid(again)
+		if err != nil || formatOid(decoded) != formatOid(oid) {
+			t.Fatalf("%s encoded back as %s", formatOid(oid), formatOid(decoded))
+		}
+	})
+}
@@ -0,0 +1,663 @@
+package snmp
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"math"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPort = "161"
+	// Largest message the server is told this client accept, the largest UDP payload
+	maxMessageSize = 65507
+	// msgFlags of SNMPv3
+	flagAuth       = 0x01
+	flagPriv       = 0x02
+	flagReportable = 0x04
+	// User-based security model
+	securityModelUsm = 3
+)
+
+var errorStatuses = []string{
+	"noError", "tooBig", "noSuchName", "badValue", "readOnly", "genErr", "noAccess", "wrongType",
+	"wrongLength", "wrongEncoding", "wrongValue", "noCreation", "inconsistentValue",
+	"resourceUnavailable", "commitFailed", "undoFailed", "authorizationError", "notWritable",
+	"inconsistentName",
+}
+
+// The usmStats counter returned in a report, by the OID of the counter below 1.3.6.1.6.3.15.1.1
+var usmReports = map[uint32]string{
+	1: "the device doesn't support the security level",
+	2: "the engine time isn't in the time window",
+	3: "the device doesn't know the username",
+	4: "the device doesn't know the engine id",
+	5: "the device refused the authentication, check the auth protocol and password",
+	6: "the device can't decrypt the request, check the priv protocol and password",
+}
+
+// Target is a device polled with one version and credential
+type Target struct {
+	// host:port
+	Address string
+	// 2c or 3
+	Version   string
+	Community string
+	Username  string
+	// md5, sha, sha256 or empty for noAuthNoPriv
+	AuthProtocol string
+	AuthPassword string
+	// des, aes or empty for no privacy
+	PrivProtocol string
+	PrivPassword string
+}
+
+// ParseAddress add the default SNMP port to the address without one
+func ParseAddress(text string) (string, error) {
+	host, port, err := net.SplitHostPort(text)
+	if err != nil {
+		host, port = text, defaultPort
+	}
+	if host == "" || strings.ContainsAny(host, "/ ") {
+		return "", fmt.Errorf("address %q must be a host with an optional port", text)
+	}
+	number, err := strconv.Atoi(port)
+	if err != nil || number <= 0 || number > 65535 {
+		return "", fmt.Errorf("address %q has an invalid port", text)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// Variable is the value of an OID, Err is set when the device has no numeric value for it
+type Variable struct {
+	Oid   []uint32
+	Value float64
+	Err   error
+}
+
+// session send the request of one target over its own socket
+type session struct {
+	target  Target
+	conn    *net.UDPConn
+	address *net.UDPAddr
+	timeout time.Duration
+	retries int
+	// Random at the start so a response to an earlier poll isn't taken for this one
+	requestId int32
+	salt      uint64
+
+	// Authoritative engine of the device and its localized key, learned by discovery
+	engineId      []byte
+	engineBoots   int64
+	engineTime    int64
+	engineTimeAt  time.Time
+	authKey       []byte
+	privKey       []byte
+	authParamSize int
+}
+
+// dial resolve the address of the target and open its socket
+func dial(target Target, timeout time.Duration, retries int) (*session, error) {
+	address, err := ParseAddress(target.Address)
+	if err != nil {
+		return nil, err
+	}
+	udpAddress, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	return &session{
+		target:    target,
+		conn:      conn,
+		address:   udpAddress,
+		timeout:   timeout,
+		retries:   retries,
+		requestId: rand.Int31n(math.MaxInt32 / 2),
+		salt:      rand.Uint64(),
+	}, nil
+}
+
+func (s *session) close() {
+	s.conn.Close()
+}
+
+// get return the value of each OID in the same order
+func (s *session) get(oids [][]uint32) ([]Variable, error) {
+	if s.target.Version != "3" {
+		return s.exchange(oids)
+	}
+	if s.engineId == nil {
+		err := s.discover()
+		if err != nil {
+			return nil, err
+		}
+	}
+	variables, err := s.exchange(oids)
+	var report *reportError
+	if errors.As(err, &report) && report.counter == 2 {
+		// The report carry the current engine time, the request is sent again once with it
+		variables, err = s.exchange(oids)
+	}
+	return variables, err
+}
+
+// discover learn the engine id, boots and time of the device with an empty unauthenticated request
+func (s *session) discover() error {
+	_, err := s.exchange(nil)
+	var report *reportError
+	if err != nil && !errors.As(err, &report) {
+		return err
+	}
+	if len(s.engineId) == 0 {
+		return errors.New("the device didn't return its engine id")
+	}
+
+	s.authParamSize = 12
+	var newHash func() hash.Hash
+	switch s.target.AuthProtocol {
+	case "md5":
+		newHash = md5.New
+	case "sha":
+		newHash = sha1.New
+	case "sha256":
+		newHash = sha256.New
+		s.authParamSize = 24
+	default:
+		return nil
+	}
+	s.authKey = localizeKey(newHash, s.target.AuthPassword, s.engineId)
+	if s.target.PrivProtocol != "" {
+		s.privKey = localizeKey(newHash, s.target.PrivPassword, s.engineId)
+	}
+	return nil
+}
+
+// localizeKey derive the key of the password for the engine, RFC 3414 A.2
+func localizeKey(newHash func() hash.Hash, password string, engineId []byte) []byte {
+	h := newHash()
+	repeated := bytes.Repeat([]byte(password), 1048576/len(password)+1)
+	h.Write(repeated[:1048576])
+	key := h.Sum(nil)
+
+	h = newHash()
+	h.Write(key)
+	h.Write(engineId)
+	h.Write(key)
+	return h.Sum(nil)
+}
+
+// reportError is a report returned by the device instead of a response
+type reportError struct {
+	counter uint32
+	oid     string
+}
+
+func (e *reportError) Error() string {
+	if message, ok := usmReports[e.counter]; ok {
+		return message
+	}
+	return "the device returned report " + e.oid
+}
+
+// exchange send the GetRequest and wait for its response, sent again on timeout
+func (s *session) exchange(oids [][]uint32) (variables []Variable, err error) {
+	for attempt := 0; attempt <= s.retries; attempt++ {
+		s.requestId++
+		var request []byte
+		request, err = s.encode(oids)
+		if err != nil {
+			return nil, err
+		}
+		_, err = s.conn.WriteToUDP(request, s.address)
+		if err != nil {
+			return nil, err
+		}
+		variables, err = s.receive()
+		var timeout net.Error
+		if err == nil || !(errors.As(err, &timeout) && timeout.Timeout()) {
+			return variables, err
+		}
+	}
+	return nil, fmt.Errorf("the device didn't answer in %s", s.timeout)
+}
+
+func (s *session) receive() ([]Variable, error) {
+	err := s.conn.SetReadDeadline(time.Now().Add(s.timeout))
+	if err != nil {
+		return nil, err
+	}
+	buffer := make([]byte, maxMessageSize)
+	for {
+		n, sender, err := s.conn.ReadFromUDP(buffer)
+		if err != nil {
+			return nil, err
+		}
+		if !sender.IP.Equal(s.address.IP) || sender.Port != s.address.Port {
+			continue
+		}
+		requestId, variables, err := s.decode(buffer[:n])
+		// A response to an earlier request that timed out, or a message that isn't a response
+		if requestId != s.requestId {
+			continue
+		}
+		return variables, err
+	}
+}
+
+// encode the GetRequest of the OIDs, an empty request without security is used for discovery
+func (s *session) encode(oids [][]uint32) ([]byte, error) {
+	varbinds := [][]byte{}
+	for _, oid := range oids {
+		varbinds = append(varbinds, tlv(tagSequence, encodeOid(oid), tlv(tagNull)))
+	}
+	pdu := tlv(tagGetRequest, encodeInteger(int64(s.requestId)), encodeInteger(0), encodeInteger(0), tlv(tagSequence, varbinds...))
+
+	if s.target.Version != "3" {
+		return tlv(tagSequence, encodeInteger(1), encodeOctetString([]byte(s.target.Community)), pdu), nil
+	}
+
+	discovery := s.engineId == nil
+	flags := byte(flagReportable)
+	if !discovery && s.authKey != nil {
+		flags |= flagAuth
+		if s.privKey != nil {
+			flags |= flagPriv
+		}
+	}
+	engineTime := s.engineTime + int64(time.Since(s.engineTimeAt)/time.Second)
+	username := []byte(s.target.Username)
+	if discovery {
+		engineTime = 0
+		username = nil
+	}
+
+	scopedPdu := tlv(tagSequence, encodeOctetString(s.engineId), encodeOctetString(nil), pdu)
+	msgData := scopedPdu
+	var privParams []byte
+	if flags&flagPriv != 0 {
+		var encrypted []byte
+		var err error
+		encrypted, privParams, err = s.encrypt(scopedPdu, engineTime)
+		if err != nil {
+			return nil, err
+		}
+		msgData = encodeOctetString(encrypted)
+	}
+	var authParams []byte
+	if flags&flagAuth != 0 {
+		authParams = make([]byte, s.authParamSize)
+	}
+
+	securityParams := tlv(tagSequence,
+		encodeOctetString(s.engineId),
+		encodeInteger(s.engineBoots),
+		encodeInteger(engineTime),
+		encodeOctetString(username),
+		encodeOctetString(authParams),
+		encodeOctetString(privParams),
+	)
+	header := tlv(tagSequence, encodeInteger(int64(s.requestId)), encodeInteger(maxMessageSize), encodeOctetString([]byte{flags}), encodeInteger(securityModelUsm))
+	message := tlv(tagSequence, encodeInteger(3), header, encodeOctetString(securityParams), msgData)
+
+	if flags&flagAuth != 0 {
+		// The zeroed auth param is replaced by the HMAC of the whole message
+		placeholder := encodeOctetString(authParams)
+		offset := bytes.Index(message, placeholder) + len(placeholder) - len(authParams)
+		copy(message[offset:], s.sign(message))
+	}
+	return message, nil
+}
+
+// sign return the truncated HMAC of the message with the zeroed auth param
+func (s *session) sign(message []byte) []byte {
+	var mac hash.Hash
+	switch s.target.AuthProtocol {
+	case "md5":
+		mac = hmac.New(md5.New, s.authKey)
+	case "sha":
+		mac = hmac.New(sha1.New, s.authKey)
+	default:
+		mac = hmac.New(sha256.New, s.authKey)
+	}
+	mac.Write(message)
+	return mac.Sum(nil)[:s.authParamSize]
+}
+
+// encrypt the scoped PDU with DES-CBC (RFC 3414) or AES-128-CFB (RFC 3826), return the salt sent as
+// the priv param
+func (s *session) encrypt(scopedPdu []byte, engineTime int64) (encrypted []byte, salt []byte, err error) {
+	s.salt++
+	if s.target.PrivProtocol == "des" {
+		salt = binary.BigEndian.AppendUint32(nil, uint32(s.engineBoots))
+		salt = binary.BigEndian.AppendUint32(salt, uint32(s.salt))
+		block, err := des.NewCipher(s.privKey[:8])
+		if err != nil {
+			return nil, nil, err
+		}
+		iv := make([]byte, 8)
+		for i := range iv {
+			iv[i] = s.privKey[8+i] ^ salt[i]
+		}
+		padded := append([]byte{}, scopedPdu...)
+		if len(padded)%8 != 0 {
+			padded = append(padded, make([]byte, 8-len(padded)%8)...)
+		}
+		encrypted = make([]byte, len(padded))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, padded)
+		return encrypted, salt, nil
+	}
+
+	salt = binary.BigEndian.AppendUint64(nil, s.salt)
+	block, err := aes.NewCipher(s.privKey[:16])
+	if err != nil {
+		return nil, nil, err
+	}
+	encrypted = make([]byte, len(scopedPdu))
+	cipher.NewCFBEncrypter(block, aesIv(s.engineBoots, engineTime, salt)).XORKeyStream(encrypted, scopedPdu)
+	return encrypted, salt, nil
+}
+
+func (s *session) decrypt(encrypted []byte, salt []byte, engineBoots int64, engineTime int64) ([]byte, error) {
+	if len(salt) != 8 {
+		return nil, errors.New("the response has an invalid priv param")
+	}
+	if s.target.PrivProtocol == "des" {
+		if len(encrypted)%8 != 0 {
+			return nil, errors.New("the response has an invalid encrypted length")
+		}
+		block, err := des.NewCipher(s.privKey[:8])
+		if err != nil {
+			return nil, err
+		}
+		iv := make([]byte, 8)
+		for i := range iv {
+			iv[i] = s.privKey[8+i] ^ salt[i]
+		}
+		decrypted := make([]byte, len(encrypted))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, encrypted)
+		return decrypted, nil
+	}
+
+	block, err := aes.NewCipher(s.privKey[:16])
+	if err != nil {
+		return nil, err
+	}
+	decrypted := make([]byte, len(encrypted))
+	cipher.NewCFBDecrypter(block, aesIv(engineBoots, engineTime, salt)).XORKeyStream(decrypted, encrypted)
+	return decrypted, nil
+}
+
+func aesIv(engineBoots int64, engineTime int64, salt []byte) []byte {
+	iv := binary.BigEndian.AppendUint32(nil, uint32(engineBoots))
+	iv = binary.BigEndian.AppendUint32(iv, uint32(engineTime))
+	return append(iv, salt...)
+}
+
+// decode the response or report, requestId is 0 when the message can't be matched to a request
+func (s *session) decode(message []byte) (requestId int32, variables []Variable, err error) {
+	content, _, err := readExpected(message, tagSequence)
+	if err != nil {
+		return 0, nil, err
+	}
+	versionContent, content, err := readExpected(content, tagInteger)
+	if err != nil {
+		return 0, nil, err
+	}
+	version, err := decodeInteger(versionContent)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if version != 3 {
+		// The community isn't checked, the response come from the polled address
+		_, pdu, err := readExpected(content, tagOctetString)
+		if err != nil {
+			return 0, nil, err
+		}
+		return decodePdu(pdu)
+	}
+	return s.decodeV3(message, content)
+}
+
+func (s *session) decodeV3(message []byte, content []byte) (requestId int32, variables []Variable, err error) {
+	header, content, err := readExpected(content, tagSequence)
+	if err != nil {
+		return 0, nil, err
+	}
+	messageIdContent, header, err := readExpected(header, tagInteger)
+	if err != nil {
+		return 0, nil, err
+	}
+	messageId, err := decodeInteger(messageIdContent)
+	if err != nil {
+		return 0, nil, err
+	}
+	_, header, err = readExpected(header, tagInteger)
+	if err != nil {
+		return 0, nil, err
+	}
+	flags, _, err := readExpected(header, tagOctetString)
+	if err != nil || len(flags) != 1 {
+		return 0, nil, errors.New("the response has invalid flags")
+	}
+
+	securityContent, msgData, err := readExpected(content, tagOctetString)
+	if err != nil {
+		return 0, nil, err
+	}
+	security, _, err := readExpected(securityContent, tagSequence)
+	if err != nil {
+		return 0, nil, err
+	}
+	engineId, security, err := readExpected(security, tagOctetString)
+	if err != nil {
+		return 0, nil, err
+	}
+	bootsContent, security, err := readExpected(security, tagInteger)
+	if err != nil {
+		return 0, nil, err
+	}
+	timeContent, security, err := readExpected(security, tagInteger)
+	if err != nil {
+		return 0, nil, err
+	}
+	_, security, err = readExpected(security, tagOctetString)
+	if err != nil {
+		return 0, nil, err
+	}
+	authParams, security, err := readExpected(security, tagOctetString)
+	if err != nil {
+		return 0, nil, err
+	}
+	privParams, _, err := readExpected(security, tagOctetString)
+	if err != nil {
+		return 0, nil, err
+	}
+	engineBoots, err := decodeInteger(bootsContent)
+	if err != nil {
+		return 0, nil, err
+	}
+	engineTime, err := decodeInteger(timeContent)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if flags[0]&flagAuth != 0 {
+		if s.authKey == nil || len(authParams) != s.authParamSize {
+			return int32(messageId), nil, errors.New("the response is authenticated with another protocol")
+		}
+		// authParams share the array of message, so its offset in message is the difference of capacity
+		offset := cap(message) - cap(authParams)
+		unsigned := append([]byte{}, message...)
+		copy(unsigned[offset:offset+len(authParams)], make([]byte, len(authParams)))
+		if !hmac.Equal(s.sign(unsigned), authParams) {
+			return int32(messageId), nil, errors.New("the response has a wrong digest")
+		}
+	}
+	// The engine of an authenticated message, or of the report to a discovery or an unauthenticated
+	// request, is kept as the device engine
+	if flags[0]&flagAuth != 0 || s.authKey == nil || s.engineId == nil {
+		s.engineId = append([]byte{}, engineId...)
+		s.engineBoots = engineBoots
+		s.engineTime = engineTime
+		s.engineTimeAt = time.Now()
+	}
+
+	var scopedPdu []byte
+	if flags[0]&flagPriv != 0 {
+		if s.privKey == nil {
+			return int32(messageId), nil, errors.New("the response is encrypted with another protocol")
+		}
+		encrypted, _, err := readExpected(msgData, tagOctetString)
+		if err != nil {
+			return int32(messageId), nil, err
+		}
+		scopedPdu, err = s.decrypt(encrypted, privParams, engineBoots, engineTime)
+		if err != nil {
+			return int32(messageId), nil, err
+		}
+	} else {
+		scopedPdu = msgData
+	}
+	scoped, _, err := readExpected(scopedPdu, tagSequence)
+	if err != nil {
+		return int32(messageId), nil, errors.New("the response can't be decrypted, check the priv password")
+	}
+	_, scoped, err = readExpected(scoped, tagOctetString)
+	if err != nil {
+		return int32(messageId), nil, err
+	}
+	_, pdu, err := readExpected(scoped, tagOctetString)
+	if err != nil {
+		return int32(messageId), nil, err
+	}
+	_, variables, err = decodePdu(pdu)
+	return int32(messageId), variables, err
+}
+
+// decodePdu decode a response or report PDU, a report or an error status is returned as the error
+func decodePdu(data []byte) (requestId int32, variables []Variable, err error) {
+	pdu, _, err := readElement(data)
+	if err != nil {
+		return 0, nil, err
+	}
+	if pdu.tag != tagResponse && pdu.tag != tagReport {
+		return 0, nil, fmt.Errorf("unexpected PDU 0x%02x", pdu.tag)
+	}
+	integers := [3]int64{}
+	content := pdu.content
+	for i := range integers {
+		var integer []byte
+		integer, content, err = readExpected(content, tagInteger)
+		if err != nil {
+			return 0, nil, err
+		}
+		integers[i], err = decodeInteger(integer)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	requestId = int32(integers[0])
+
+	list, _, err := readExpected(content, tagSequence)
+	if err != nil {
+		return requestId, nil, err
+	}
+	for len(list) > 0 {
+		var varbind []byte
+		varbind, list, err = readExpected(list, tagSequence)
+		if err != nil {
+			return requestId, nil, err
+		}
+		oidContent, rest, err := readExpected(varbind, tagOid)
+		if err != nil {
+			return requestId, nil, err
+		}
+		oid, err := decodeOid(oidContent)
+		if err != nil {
+			return requestId, nil, err
+		}
+		value, _, err := readElement(rest)
+		if err != nil {
+			return requestId, nil, err
+		}
+		variable := Variable{Oid: oid}
+		variable.Value, variable.Err = decodeValue(value)
+		variables = append(variables, variable)
+	}
+
+	if pdu.tag == tagReport {
+		report := &reportError{}
+		if len(variables) > 0 {
+			oid := variables[0].Oid
+			report.oid = formatOid(oid)
+			if len(oid) == 11 && strings.HasPrefix(report.oid, "1.3.6.1.6.3.15.1.1.") {
+				report.counter = oid[9]
+			}
+		}
+		return requestId, nil, report
+	}
+	if integers[1] != 0 {
+		status := strconv.FormatInt(integers[1], 10)
+		if integers[1] > 0 && integers[1] < int64(len(errorStatuses)) {
+			status = errorStatuses[integers[1]]
+		}
+		return requestId, nil, fmt.Errorf("the device returned error %s", status)
+	}
+	return requestId, variables, nil
+}
+
+// decodeValue return the value as a number, a numeric string and a float in an opaque are accepted
+func decodeValue(value element) (float64, error) {
+	switch value.tag {
+	case tagInteger:
+		integer, err := decodeInteger(value.content)
+		return float64(integer), err
+	case tagCounter32, tagGauge32, tagTimeTicks, tagCounter64:
+		unsigned, err := decodeUnsigned(value.content)
+		return float64(unsigned), err
+	case tagOctetString:
+		number, err := strconv.ParseFloat(strings.TrimSpace(string(value.content)), 64)
+		if err != nil {
+			return 0, errors.New("the value is a string, not a number")
+		}
+		return number, nil
+	case tagOpaque:
+		// Net-SNMP encode a float as the opaque float tag 0x9f78 and a double as 0x9f79
+		content := value.content
+		if len(content) == 7 && content[0] == 0x9f && content[1] == 0x78 && content[2] == 4 {
+			return float64(math.Float32frombits(binary.BigEndian.Uint32(content[3:]))), nil
+		}
+		if len(content) == 11 && content[0] == 0x9f && content[1] == 0x79 && content[2] == 8 {
+			return math.Float64frombits(binary.BigEndian.Uint64(content[3:])), nil
+		}
+		return 0, errors.New("the value is an opaque, not a number")
+	case tagNoSuchObject:
+		return 0, errors.New("noSuchObject")
+	case tagNoSuchInstance:
+		return 0, errors.New("noSuchInstance")
+	case tagEndOfMibView:
+		return 0, errors.New("endOfMibView")
+	case tagNull:
+		return 0, errors.New("the value is null")
+	}
+	return 0, fmt.Errorf("the value has type 0x%02x, not a number", value.tag)
+}
@@ -0,0 +1,313 @@
+package snmp
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestLocalizeKey(t *testing.T) {
+	// RFC 3414 A.3 and RFC 7860 A.2
+	engineId, _ := hex.DecodeString("000000000000000000000002")
+	tests := []struct {
+		name    string
+		newHash func() hash.Hash
+		want    string
+	}{
+		{"md5", md5.New, "526f5eed9fcce26f8964c2930787d82b"},
+		{"sha", sha1.New, "6695febc9288e36282235fc7151f128497b38f3f"},
+		{"sha256", sha256.New, "8982e0e549e866db361a6b625d84cccc11162d453ee8ce3a6445c2d6776f0f8b"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := hex.EncodeToString(localizeKey(tc.newHash, "maplesyrup", engineId))
+			if got != tc.want {
+				t.Fatalf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+// response return the response PDU with the value of each varbind
+func response(requestId int64, errorStatus int64, varbinds ...[]byte) []byte {
+	list := [][]byte{}
+	for _, varbind := range varbinds {
+		list = append(list, tlv(tagSequence, varbind))
+	}
+	return tlv(tagResponse, encodeInteger(requestId), encodeInteger(errorStatus), encodeInteger(0), tlv(tagSequence, list...))
+}
+
+func varbind(oid string, value []byte) []byte {
+	parsed, err := ParseOid(oid)
+	if err != nil {
+		panic(err)
+	}
+	return append(encodeOid(parsed), value...)
+}
+
+func TestDecodePdu(t *testing.T) {
+	pdu := response(42, 0,
+		varbind("1.3.6.1.2.1.1.3.0", tlv(tagTimeTicks, []byte{0x01, 0x00})),
+		varbind("1.3.6.1.2.1.33.1.2.4.0", encodeInteger(-5)),
+		varbind("1.3.6.1.2.1.1.1.0", encodeOctetString([]byte("router"))),
+		varbind("1.3.6.1.2.1.1.2.0", tlv(tagNoSuchInstance)),
+	)
+	requestId, variables, err := decodePdu(pdu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requestId != 42 || len(variables) != 4 {
+		t.Fatalf("got request %d and %d variable", requestId, len(variables))
+	}
+	if formatOid(variables[0].Oid) != "1.3.6.1.2.1.1.3.0" || variables[0].Value != 256 || variables[0].Err != nil {
+		t.Errorf("first variable is %+v", variables[0])
+	}
+	if variables[1].Value != -5 || variables[1].Err != nil {
+		t.Errorf("second variable is %+v", variables[1])
+	}
+	if variables[2].Err == nil || variables[3].Err == nil {
+		t.Errorf("a string or a missing instance should be an error: %+v %+v", variables[2], variables[3])
+	}
+
+	_, _, err = decodePdu(response(42, 2, varbind("1.3.6.1.2.1.1.3.0", tlv(tagNull))))
+	if err == nil || err.Error() != "the device returned error noSuchName" {
+		t.Errorf("error status is %v", err)
+	}
+	_, _, err = decodePdu(response(42, 99))
+	if err == nil || err.Error() != "the device returned error 99" {
+		t.Errorf("unknown error status is %v", err)
+	}
+
+	report := response(42, 0, varbind("1.3.6.1.6.3.15.1.1.2.0", tlv(tagCounter32, []byte{0x01})))
+	report[0] = tagReport
+	_, _, err = decodePdu(report)
+	var reportErr *reportError
+	if !errors.As(err, &reportErr) || reportErr.counter != 2 || err.Error() != usmReports[2] {
+		t.Errorf("report is %v", err)
+	}
+}
+
+func TestDecodePduMalformed(t *testing.T) {
+	valid := response(7, 0, varbind("1.3.6.1.2.1.1.3.0", encodeInteger(1)))
+	tests := map[string][]byte{
+		"empty":                 {},
+		"get request":           tlv(tagGetRequest, valid[2:]),
+		"truncated":             valid[:len(valid)-1],
+		"missing error index":   tlv(tagResponse, encodeInteger(7), encodeInteger(0)),
+		"missing varbind list":  tlv(tagResponse, encodeInteger(7), encodeInteger(0), encodeInteger(0)),
+		"empty integer":         tlv(tagResponse, tlv(tagInteger), encodeInteger(0), encodeInteger(0), tlv(tagSequence)),
+		"varbind not sequence":  tlv(tagResponse, encodeInteger(7), encodeInteger(0), encodeInteger(0), tlv(tagSequence, encodeInteger(1))),
+		"varbind without oid":   tlv(tagResponse, encodeInteger(7), encodeInteger(0), encodeInteger(0), tlv(tagSequence, tlv(tagSequence, encodeInteger(1)))),
+		"varbind without value": tlv(tagResponse, encodeInteger(7), encodeInteger(0), encodeInteger(0), tlv(tagSequence, tlv(tagSequence, encodeOid([]uint32{1, 3})))),
+		"empty oid":             tlv(tagResponse, encodeInteger(7), encodeInteger(0), encodeInteger(0), tlv(tagSequence, tlv(tagSequence, tlv(tagOid), tlv(tagNull)))),
+	}
+	for name, pdu := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, variables, err := decodePdu(pdu)
+			if err == nil {
+				t.Fatalf("decoding %x got %+v, want an error", pdu, variables)
+			}
+		})
+	}
+}
+
+func TestDecodeValue(t *testing.T) {
+	float32Opaque, _ := hex.DecodeString("9f780441ac0000")
+	float64Opaque, _ := hex.DecodeString("9f7908c00c000000000000")
+	tests := []struct {
+		name  string
+		value element
+		want  float64
+		fail  bool
+	}{
+		{"integer", element{tagInteger, []byte{0xff, 0x38}}, -200, false},
+		{"counter32", element{tagCounter32, []byte{0x00, 0xff, 0xff, 0xff, 0xff}}, math.MaxUint32, false},
+		{"gauge32", element{tagGauge32, []byte{0x64}}, 100, false},
+		{"counter64", element{tagCounter64, []byte{0x01, 0x00, 0x00, 0x00, 0x00}}, 1 << 32, false},
+		{"numeric string", element{tagOctetString, []byte(" 21.5 ")}, 21.5, false},
+		{"float opaque", element{tagOpaque, float32Opaque}, 21.5, false},
+		{"double opaque", element{tagOpaque, float64Opaque}, -3.5, false},
+		{"string", element{tagOctetString, []byte("up")}, 0, true},
+		{"other opaque", element{tagOpaque, []byte{0x01}}, 0, true},
+		{"empty integer", element{tagInteger, nil}, 0, true},
+		{"null", element{tagNull, nil}, 0, true},
+		{"no such object", element{tagNoSuchObject, nil}, 0, true},
+		{"end of mib view", element{tagEndOfMibView, nil}, 0, true},
+		{"ip address", element{tagIpAddress, []byte{10, 0, 0, 1}}, 0, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			value, err := decodeValue(tc.value)
+			if (err != nil) != tc.fail || value != tc.want {
+				t.Fatalf("got %v %v, want %v", value, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestDecodeV2c(t *testing.T) {
+	s := &session{target: Target{Version: "2c", Community: "public"}, requestId: 1000}
+	request, err := s.encode([][]uint32{{1, 3, 6, 1, 2, 1, 1, 3, 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetRequest with the version 1 (2c), the community and the request id
+	want := "302702010104067075626c6963a01a020203e8020100020100300e300c06082b060102010103000500"
+	if hex.EncodeToString(request) != want {
+		t.Fatalf("encoded %x, want %s", request, want)
+	}
+
+	message := tlv(tagSequence, encodeInteger(1), encodeOctetString([]byte("public")),
+		response(1000, 0, varbind("1.3.6.1.2.1.1.3.0", tlv(tagTimeTicks, []byte{0x10}))))
+	requestId, variables, err := s.decode(message)
+	if err != nil || requestId != 1000 || len(variables) != 1 || variables[0].Value != 16 {
+		t.Fatalf("got request %d, %+v and %v", requestId, variables, err)
+	}
+
+	for _, malformed := range [][]byte{
+		{},
+		message[:len(message)-1],
+		tlv(tagSequence, encodeOctetString([]byte("public"))),
+		tlv(tagSequence, encodeInteger(1), encodeInteger(1)),
+	} {
+		_, _, err := s.decode(malformed)
+		if err == nil {
+			t.Errorf("decoding %x should fail", malformed)
+		}
+	}
+}
+
+// v3Session return a session past its discovery, like the device would have for its own user
+func v3Session(auth string, priv string) *session {
+	engineId, _ := hex.DecodeString("80001f8880e9630000d61ff449")
+	s := &session{
+		target: Target{
+			Version: "3", Username: "monitor",
+			AuthProtocol: auth, AuthPassword: "authpassword", PrivProtocol: priv, PrivPassword: "privpassword",
+		},
+		requestId:    5000,
+		engineId:     engineId,
+		engineBoots:  3,
+		engineTime:   1200,
+		engineTimeAt: time.Now(),
+	}
+	newHash := sha1.New
+	s.authParamSize = 12
+	switch auth {
+	case "":
+		return s
+	case "md5":
+		newHash = md5.New
+	case "sha256":
+		newHash = sha256.New
+		s.authParamSize = 24
+	}
+	s.authKey = localizeKey(newHash, s.target.AuthPassword, engineId)
+	if priv != "" {
+		s.privKey = localizeKey(newHash, s.target.PrivPassword, engineId)
+	}
+	return s
+}
+
+func TestDecodeV3RoundTrip(t *testing.T) {
+	// The session can't answer its own GetRequest, so the decoded message is authenticated and
+	// decrypted up to its PDU, which isn't a response
+	tests := []struct {
+		auth string
+		priv string
+	}{
+		{"", ""},
+		{"md5", ""},
+		{"sha", "des"},
+		{"sha", "aes"},
+		{"sha256", "aes"},
+		{"md5", "des"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.auth+" "+tc.priv, func(t *testing.T) {
+			s := v3Session(tc.auth, tc.priv)
+			request, err := s.encode([][]uint32{{1, 3, 6, 1, 2, 1, 1, 3, 0}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			requestId, _, err := s.decode(request)
+			if requestId != s.requestId || err == nil || err.Error() != "unexpected PDU 0xa0" {
+				t.Fatalf("got request %d and %v", requestId, err)
+			}
+			if tc.auth == "" {
+				return
+			}
+
+			// A byte changed anywhere in the message fail the digest
+			tampered := append([]byte{}, request...)
+			tampered[len(tampered)-1] ^= 0x01
+			_, _, err = s.decode(tampered)
+			if err == nil || err.Error() != "the response has a wrong digest" {
+				t.Fatalf("tampered message got %v", err)
+			}
+			other := v3Session("", "")
+			other.authParamSize = s.authParamSize
+			_, _, err = other.decode(request)
+			if err == nil {
+				t.Fatal("an authenticated message should fail without the key")
+			}
+		})
+	}
+}
+
+func TestDecodeV3Malformed(t *testing.T) {
+	s := v3Session("sha", "aes")
+	header := tlv(tagSequence, encodeInteger(5000), encodeInteger(maxMessageSize), encodeOctetString([]byte{flagReportable}), encodeInteger(securityModelUsm))
+	security := tlv(tagSequence, encodeOctetString(s.engineId), encodeInteger(3), encodeInteger(1200),
+		encodeOctetString([]byte("monitor")), encodeOctetString(nil), encodeOctetString(nil))
+	scopedPdu := tlv(tagSequence, encodeOctetString(s.engineId), encodeOctetString(nil), response(5000, 0))
+	tests := map[string][]byte{
+		"no header":          tlv(tagSequence, encodeInteger(3)),
+		"empty flags":        tlv(tagSequence, encodeInteger(3), tlv(tagSequence, encodeInteger(5000), encodeInteger(maxMessageSize), encodeOctetString(nil)), encodeOctetString(security), scopedPdu),
+		"no security":        tlv(tagSequence, encodeInteger(3), header),
+		"security truncated": tlv(tagSequence, encodeInteger(3), header, encodeOctetString(security[:len(security)-2]), scopedPdu),
+		"no scoped pdu":      tlv(tagSequence, encodeInteger(3), header, encodeOctetString(security)),
+		"unauthenticated":    tlv(tagSequence, encodeInteger(3), tlv(tagSequence, encodeInteger(5000), encodeInteger(maxMessageSize), encodeOctetString([]byte{flagAuth}), encodeInteger(securityModelUsm)), encodeOctetString(security), scopedPdu),
+	}
+	for name, message := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := s.decode(message)
+			if err == nil {
+				t.Fatalf("decoding %x should fail", message)
+			}
+		})
+	}
+
+	// The same message with a valid scoped PDU is decoded, the engine of an unauthenticated report
+	// isn't taken since the session already has one
+	requestId, variables, err := s.decode(tlv(tagSequence, encodeInteger(3), header, encodeOctetString(security), scopedPdu))
+	if err != nil || requestId != 5000 || len(variables) != 0 {
+		t.Fatalf("got request %d, %+v and %v", requestId, variables, err)
+	}
+}
+
+// FuzzDecode check a message from the device never panic, whatever the version and security
+func FuzzDecode(f *testing.F) {
+	for _, s := range []*session{v3Session("", ""), v3Session("sha", "des"), v3Session("md5", "aes")} {
+		request, err := s.encode([][]uint32{{1, 3, 6, 1, 2, 1, 1, 3, 0}})
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(request)
+	}
+	f.Add(tlv(tagSequence, encodeInteger(1), encodeOctetString([]byte("public")),
+		response(1000, 0, varbind("1.3.6.1.2.1.1.3.0", tlv(tagOpaque, []byte{0x9f, 0x78, 0x04, 0x41, 0xac, 0x00, 0x00})))))
+	// The key are localized once, a decoded message may change the engine but not the key
+	sessions := []*session{v3Session("", ""), v3Session("sha", "des"), v3Session("md5", "aes")}
+	f.Fuzz(func(t *testing.T, message []byte) {
+		for _, s := range sessions {
+			s.decode(message)
+		}
+	})
+}
@@ -0,0 +1,168 @@
+// Package snmp read the OID of network-attached equipment, e.g. the battery of a UPS or the load of a
+// PDU, over SNMPv2c or SNMPv3 and store it in the sensor channel mapped to the OID.
+package snmp
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/ingest"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	defaultTimeout = 3 * time.Second
+	// Device read at the same time
+	maxParallelDevice = 16
+	// OID per GetRequest, small enough for the response to fit in one datagram
+	maxOidPerRequest = 32
+)
+
+// Poller read every mapped item once per Poll, it is run by the scheduler so only one instance poll
+type Poller struct {
+	db         *pgxpool.Pool
+	repository *repositories.SnmpRepository
+	pipeline   *ingest.Pipeline
+	timeout    time.Duration
+	retries    int
+}
+
+func NewPoller(db *pgxpool.Pool, snmpRepository *repositories.SnmpRepository, pipeline *ingest.Pipeline, config *configs.Config) (*Poller, error) {
+	poller := &Poller{
+		db:         db,
+		repository: snmpRepository,
+		pipeline:   pipeline,
+		timeout:    time.Duration(config.Snmp.TimeoutMs) * time.Millisecond,
+		retries:    config.Snmp.Retries,
+	}
+	if poller.timeout <= 0 {
+		poller.timeout = defaultTimeout
+	}
+	if poller.retries < 0 {
+		poller.retries = 0
+	}
+	return poller, nil
+}
+
+// Poll read the item of every device and store the value like POST /channel
+func (p *Poller) Poll(ctx context.Context) (string, error) {
+	devices, err := p.repository.GetAll(ctx, p.db)
+	if err != nil {
+		return "", err
+	}
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	read, total := 0, 0
+	limit := make(chan struct{}, maxParallelDevice)
+	for _, device := range devices {
+		total += len(device.Items)
+		wg.Add(1)
+		limit <- struct{}{}
+		go func(device entities.SnmpDevice) {
+			defer func() {
+				<-limit
+				wg.Done()
+			}()
+			count := p.pollDevice(ctx, device)
+			mutex.Lock()
+			read += count
+			mutex.Unlock()
+		}(device)
+	}
+	wg.Wait()
+
+	return fmt.Sprintf("Read %d of %d SNMP item from %d device", read, total, len(devices)), nil
+}
+
+// pollDevice read the item of one device, return the number of item read
+func (p *Poller) pollDevice(ctx context.Context, device entities.SnmpDevice) int {
+	s, err := dial(Target{
+		Address:      device.Address,
+		Version:      device.Version,
+		Community:    device.Community,
+		Username:     device.Username,
+		AuthProtocol: device.AuthProtocol,
+		AuthPassword: device.AuthPassword,
+		PrivProtocol: device.PrivProtocol,
+		PrivPassword: device.PrivPassword,
+	}, p.timeout, p.retries)
+	if err != nil {
+		for _, item := range device.Items {
+			p.setStatus(ctx, item, err.Error())
+		}
+		return 0
+	}
+	defer s.close()
+
+	items := []entities.SnmpItem{}
+	oids := [][]uint32{}
+	for _, item := range device.Items {
+		oid, err := ParseOid(item.Oid)
+		if err != nil {
+			p.setStatus(ctx, item, err.Error())
+			continue
+		}
+		items = append(items, item)
+		oids = append(oids, oid)
+	}
+
+	read := 0
+	for start := 0; start < len(items); start += maxOidPerRequest {
+		if ctx.Err() != nil {
+			return read
+		}
+		end := start + maxOidPerRequest
+		if end > len(items) {
+			end = len(items)
+		}
+		variables, err := s.get(oids[start:end])
+		if err == nil && len(variables) != end-start {
+			err = fmt.Errorf("the device returned %d value for %d OID", len(variables), end-start)
+		}
+		if err != nil {
+			for _, item := range items[start:end] {
+				p.setStatus(ctx, item, err.Error())
+			}
+			continue
+		}
+
+		for i, item := range items[start:end] {
+			variable := variables[i]
+			if variable.Err != nil {
+				p.setStatus(ctx, item, variable.Err.Error())
+				continue
+			}
+			p.setStatus(ctx, item, entities.SnmpOk)
+
+			payload := &entities.ChannelCreate{
+				IdSensor: device.IdSensor,
+				Name:     item.Name,
+				Value:    variable.Value,
+			}
+			_, _, err = p.pipeline.Store(ctx, device.IdUser, payload)
+			p.pipeline.Record(device.IdSensor, 0, err)
+			if err == nil {
+				read++
+			}
+		}
+	}
+	return read
+}
+
+// setStatus save the status of the item when it changed, failing to save it doesn't stop the poll
+func (p *Poller) setStatus(ctx context.Context, item entities.SnmpItem, status string) {
+	if item.Status == status {
+		return
+	}
+	err := p.repository.SetStatus(ctx, p.db, item.IdItem, status)
+	if err != nil {
+		log.Printf("[SNMP] Error saving the status of item %d: %v", item.IdItem, err)
+	}
+}
@@ -0,0 +1,142 @@
+// Package weather fetch the current weather at the node coordinate and store it in the virtual
+// sensor of each weather variable, to compare the indoor reading with the outdoor condition.
+package weather
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/ingest"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	requestTimeout = 10 * time.Second
+	// Node closer than about a kilometer share one request
+	coordinatePrecision = 100
+)
+
+// Fetcher store the current weather of every node once per Fetch, it is run by the scheduler so only
+// one instance fetch
+type Fetcher struct {
+	db         *pgxpool.Pool
+	repository *repositories.WeatherRepository
+	pipeline   *ingest.Pipeline
+	provider   provider
+}
+
+// NewFetcher return nil when weather.provider is empty
+func NewFetcher(db *pgxpool.Pool, weatherRepository *repositories.WeatherRepository, pipeline *ingest.Pipeline, config *configs.Config) (*Fetcher, error) {
+	client := &http.Client{Timeout: requestTimeout}
+	url := config.Weather.Url
+	var p provider
+	switch config.Weather.Provider {
+	case "":
+		return nil, nil
+	case "open-meteo":
+		if url == "" {
+			url = openMeteoUrl
+		}
+		p = &openMeteo{client: client, url: url}
+	case "openweathermap":
+		if config.Weather.ApiKey == "" {
+			return nil, fmt.Errorf("weather.apiKey is needed by openweathermap")
+		}
+		if url == "" {
+			url = openWeatherMapUrl
+		}
+		p = &openWeatherMap{client: client, url: url, apiKey: config.Weather.ApiKey}
+	default:
+		return nil, fmt.Errorf("unknown weather provider %q, use open-meteo or openweathermap", config.Weather.Provider)
+	}
+
+	return &Fetcher{
+		db:         db,
+		repository: weatherRepository,
+		pipeline:   pipeline,
+		provider:   p,
+	}, nil
+}
+
+// Fetch store the weather of every node like POST /channel
+func (f *Fetcher) Fetch(ctx context.Context) (string, error) {
+	nodes, err := f.repository.GetAll(ctx, f.db)
+	if err != nil {
+		return "", err
+	}
+
+	type result struct {
+		values map[string]float64
+		err    error
+	}
+	// The weather of each rounded coordinate, so node on the same site are fetched once
+	results := map[[2]float64]result{}
+	stored, failed := 0, 0
+	for _, node := range nodes {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		latitude, longitude, ok := entities.ParseNodeLocation(node.Location)
+		if !ok {
+			f.setStatus(ctx, node.Items, `the node location isn't "latitude,longitude"`)
+			failed++
+			continue
+		}
+		latitude = math.Round(latitude*coordinatePrecision) / coordinatePrecision
+		longitude = math.Round(longitude*coordinatePrecision) / coordinatePrecision
+
+		key := [2]float64{latitude, longitude}
+		r, ok := results[key]
+		if !ok {
+			r.values, r.err = f.provider.current(ctx, latitude, longitude)
+			results[key] = r
+		}
+		if r.err != nil {
+			f.setStatus(ctx, node.Items, r.err.Error())
+			failed++
+			continue
+		}
+
+		for _, item := range node.Items {
+			value, ok := r.values[item.Variable]
+			if !ok {
+				f.setStatus(ctx, []entities.WeatherItem{item}, "the provider has no "+item.Variable)
+				continue
+			}
+			payload := &entities.ChannelCreate{
+				IdSensor: item.IdSensor,
+				Value:    value,
+			}
+			_, _, err = f.pipeline.StoreEnrichment(ctx, node.IdUser, payload)
+			f.pipeline.Record(item.IdSensor, 0, err)
+			if err != nil {
+				f.setStatus(ctx, []entities.WeatherItem{item}, err.Error())
+				continue
+			}
+			f.setStatus(ctx, []entities.WeatherItem{item}, entities.WeatherOk)
+			stored++
+		}
+	}
+
+	return fmt.Sprintf("Stored %d weather value of %d node with %d request, %d node failed", stored, len(nodes), len(results), failed), nil
+}
+
+// setStatus save the status of the item when it changed, failing to save it doesn't stop the fetch
+func (f *Fetcher) setStatus(ctx context.Context, items []entities.WeatherItem, status string) {
+	for _, item := range items {
+		if item.Status == status {
+			continue
+		}
+		err := f.repository.SetStatus(ctx, f.db, item.IdItem, status)
+		if err != nil {
+			log.Printf("[WEATHER] Error saving the status of item %d: %v", item.IdItem, err)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	openMeteoUrl      = "https://api.open-meteo.com/v1/forecast"
+	openWeatherMapUrl = "https://api.openweathermap.org/data/2.5/weather"
+)
+
+// provider return the current weather at the coordinate by variable, a variable the provider
+// doesn't have is left out
+type provider interface {
+	current(ctx context.Context, latitude float64, longitude float64) (map[string]float64, error)
+}
+
+// getJson get the url and decode its JSON body into v, only a 2xx response is a success
+func getJson(ctx context.Context, client *http.Client, target string, v interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+	request.Header.Set("User-Agent", "iot-server-weather")
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1024*1024))
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		message := strings.TrimSpace(string(body))
+		if len(message) > 200 {
+			message = message[:200]
+		}
+		return fmt.Errorf("the weather provider responded %s: %s", response.Status, message)
+	}
+	return json.Unmarshal(body, v)
+}
+
+func formatCoordinate(value float64) string {
+	return strconv.FormatFloat(value, 'f', 4, 64)
+}
+
+// openMeteo need no key, https://open-meteo.com/en/docs
+type openMeteo struct {
+	client *http.Client
+	url    string
+}
+
+func (p *openMeteo) current(ctx context.Context, latitude float64, longitude float64) (map[string]float64, error) {
+	query := url.Values{}
+	query.Set("latitude", formatCoordinate(latitude))
+	query.Set("longitude", formatCoordinate(longitude))
+	query.Set("current", "temperature_2m,relative_humidity_2m,surface_pressure,wind_speed_10m,precipitation,cloud_cover")
+	query.Set("wind_speed_unit", "ms")
+
+	body := struct {
+		Current map[string]interface{} `json:"current"`
+	}{}
+	err := getJson(ctx, p.client, p.url+"?"+query.Encode(), &body)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]string{
+		"temperature":   "temperature_2m",
+		"humidity":      "relative_humidity_2m",
+		"pressure":      "surface_pressure",
+		"wind_speed":    "wind_speed_10m",
+		"precipitation": "precipitation",
+		"cloud_cover":   "cloud_cover",
+	}
+	values := map[string]float64{}
+	for variable, field := range fields {
+		if value, ok := body.Current[field].(float64); ok {
+			values[variable] = value
+		}
+	}
+	return values, nil
+}
+
+// openWeatherMap need weather.apiKey, https://openweathermap.org/current
+type openWeatherMap struct {
+	client *http.Client
+	url    string
+	apiKey string
+}
+
+func (p *openWeatherMap) current(ctx context.Context, latitude float64, longitude float64) (map[string]float64, error) {
+	query := url.Values{}
+	query.Set("lat", formatCoordinate(latitude))
+	query.Set("lon", formatCoordinate(longitude))
+	query.Set("units", "metric")
+	query.Set("appid", p.apiKey)
+
+	body := struct {
+		Main *struct {
+			Temp     *float64 `json:"temp"`
+			Humidity *float64 `json:"humidity"`
+			Pressure *float64 `json:"pressure"`
+		} `json:"main"`
+		Wind *struct {
+			Speed *float64 `json:"speed"`
+		} `json:"wind"`
+		Clouds *struct {
+			All *float64 `json:"all"`
+		} `json:"clouds"`
+		Rain *struct {
+			OneHour *float64 `json:"1h"`
+		} `json:"rain"`
+	}{}
+	err := getJson(ctx, p.client, p.url+"?"+query.Encode(), &body)
+	if err != nil {
+		// The key is in the url, which the client error contain
+		return nil, fmt.Errorf("%s", strings.ReplaceAll(err.Error(), p.apiKey, "***"))
+	}
+
+	values := map[string]float64{}
+	set := func(variable string, value *float64) {
+		if value != nil {
+			values[variable] = *value
+		}
+	}
+	if body.Main != nil {
+		set("temperature", body.Main.Temp)
+		set("humidity", body.Main.Humidity)
+		set("pressure", body.Main.Pressure)
+	}
+	if body.Wind != nil {
+		set("wind_speed", body.Wind.Speed)
+	}
+	if body.Clouds != nil {
+		set("cloud_cover", body.Clouds.All)
+	}
+	// No rain is left out of the response
+	values["precipitation"] = 0
+	if body.Rain != nil {
+		set("precipitation", body.Rain.OneHour)
+	}
+	return values, nil
+}
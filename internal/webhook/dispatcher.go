@@ -0,0 +1,162 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// Delivery claimed per poll
+	batchSize = 50
+	// A claimed delivery is not claimed again before this, it must be longer than the request timeout
+	leaseDuration  = time.Minute
+	requestTimeout = 10 * time.Second
+	// The delivery is failed for good after this many attempt, about 12 hours after the event
+	maxAttempts = 12
+	firstRetry  = 30 * time.Second
+	maxRetry    = 4 * time.Hour
+
+	defaultPollInterval = 5 * time.Second
+)
+
+// Dispatcher send the queued delivery to their webhook. Every instance can run it, a delivery is
+// claimed by one instance at a time
+type Dispatcher struct {
+	db         *pgxpool.Pool
+	repository *repositories.WebhookRepository
+	client     *http.Client
+}
+
+func NewDispatcher(db *pgxpool.Pool, webhookRepository *repositories.WebhookRepository) (*Dispatcher, error) {
+	return &Dispatcher{
+		db:         db,
+		repository: webhookRepository,
+		client:     &http.Client{Timeout: requestTimeout},
+	}, nil
+}
+
+// Sign return the signature of the body sent at timestamp, the receiver compute it with the
+// webhook secret and compare it to the X-Webhook-Signature header
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// retryDelay double the wait after each failed attempt, 0 when there is no attempt left
+func retryDelay(attempts int) time.Duration {
+	if attempts >= maxAttempts {
+		return 0
+	}
+	delay := firstRetry
+	for i := 1; i < attempts && delay < maxRetry; i++ {
+		delay *= 2
+	}
+	if delay > maxRetry {
+		delay = maxRetry
+	}
+	return delay
+}
+
+// Deliver send every due delivery until there is none left
+func (d *Dispatcher) Deliver(ctx context.Context) (delivered int, failed int, err error) {
+	for {
+		attempts, err := d.repository.ClaimDue(ctx, d.db, batchSize, leaseDuration)
+		if err != nil {
+			return delivered, failed, err
+		}
+		if len(attempts) == 0 {
+			return delivered, failed, nil
+		}
+
+		for _, attempt := range attempts {
+			responseCode, err := d.send(ctx, attempt)
+			if err == nil {
+				err = d.repository.MarkDelivered(ctx, d.db, attempt.IdDelivery, *responseCode)
+				if err != nil {
+					return delivered, failed, err
+				}
+				delivered++
+				continue
+			}
+
+			failed++
+			err = d.repository.MarkFailed(ctx, d.db, attempt.IdDelivery, responseCode, err.Error(), retryDelay(attempt.Attempts))
+			if err != nil {
+				return delivered, failed, err
+			}
+		}
+
+		if len(attempts) < batchSize {
+			return delivered, failed, nil
+		}
+	}
+}
+
+// send post the payload, only a 2xx response is a success
+func (d *Dispatcher) send(ctx context.Context, attempt repositories.WebhookAttempt) (responseCode *int, err error) {
+	body := []byte(attempt.Payload)
+	timestamp := time.Now().Unix()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, attempt.Url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "iot-server-webhook")
+	request.Header.Set("X-Webhook-Event", attempt.Event)
+	request.Header.Set("X-Webhook-Delivery", strconv.FormatInt(attempt.IdDelivery, 10))
+	request.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	request.Header.Set("X-Webhook-Signature", Sign(attempt.Secret, timestamp, body))
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+
+	responseCode = &response.StatusCode
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return responseCode, fmt.Errorf("webhook responded %s", response.Status)
+	}
+	return responseCode, nil
+}
+
+// Start deliver the due delivery every interval until the context is done
+func (d *Dispatcher) Start(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				_, _, err := d.Deliver(ctx)
+				if err != nil {
+					log.Printf("[WEBHOOK] Error delivering webhook: %v", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
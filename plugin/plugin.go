@@ -0,0 +1,152 @@
+// Package plugin let a third party add a proprietary device protocol or an output without forking the
+// server. A plugin package register its decoder and sink in its init function, and is linked in the
+// server binary by a blank import in cmd/plugins.go:
+//
+//	import _ "example.com/acme/iot-decoder"
+//
+// A decoder is picked by name in the decoder of a hardware, and a sink is enabled by its setting in
+// plugin.sinks of the config.
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Value is one value read from the payload, stored in the channel Name of the node sensor named
+// Sensor. Name is empty for a single value sensor
+type Value struct {
+	Sensor string
+	Name   string
+	Value  float64
+}
+
+// Decoder turn the raw payload sent by a device into value. It is called concurrently
+type Decoder interface {
+	Decode(payload []byte) ([]Value, error)
+}
+
+// DecoderFunc is a Decoder from a function
+type DecoderFunc func(payload []byte) ([]Value, error)
+
+func (f DecoderFunc) Decode(payload []byte) ([]Value, error) {
+	return f(payload)
+}
+
+// Reading is a stored channel
+type Reading struct {
+	IdSensor int
+	Name     string
+	Value    float64
+	Quality  string
+	Time     time.Time
+}
+
+// Alert is a reading outside the normal range of an alert widget of its sensor
+type Alert struct {
+	Reading
+	IdWidget int
+	Title    string
+	Min      *float64
+	Max      *float64
+}
+
+// Sink receive every stored reading and the alert they raise, from a single goroutine in the
+// background so a slow sink never slow the ingest. A reading is dropped when the sink fall too far
+// behind
+type Sink interface {
+	WriteReading(ctx context.Context, reading Reading) error
+	WriteAlert(ctx context.Context, alert Alert) error
+	Close() error
+}
+
+// SinkFactory create the sink from its setting in plugin.sinks, the key is lowercase
+type SinkFactory func(settings map[string]string) (Sink, error)
+
+var (
+	mu       sync.RWMutex
+	decoders = map[string]Decoder{}
+	sinks    = map[string]SinkFactory{}
+)
+
+// RegisterDecoder make the decoder available by name, it panic when the name is already registered
+func RegisterDecoder(name string, decoder Decoder) {
+	mu.Lock()
+	defer mu.Unlock()
+	if decoder == nil {
+		panic("plugin: decoder " + name + " is nil")
+	}
+	if _, ok := decoders[name]; ok {
+		panic("plugin: decoder " + name + " is registered twice")
+	}
+	decoders[name] = decoder
+}
+
+// RegisterSink make the sink available by name, it panic when the name is already registered
+func RegisterSink(name string, factory SinkFactory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if factory == nil {
+		panic("plugin: sink " + name + " is nil")
+	}
+	if _, ok := sinks[name]; ok {
+		panic("plugin: sink " + name + " is registered twice")
+	}
+	sinks[name] = factory
+}
+
+func LookupDecoder(name string) (Decoder, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	decoder, ok := decoders[name]
+	return decoder, ok
+}
+
+func LookupSink(name string) (SinkFactory, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	factory, ok := sinks[name]
+	return factory, ok
+}
+
+// Decoders return the name of the registered decoder, sorted
+func Decoders() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(decoders))
+	for name := range decoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Sinks return the name of the registered sink, sorted
+func Sinks() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(sinks))
+	for name := range sinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Decode run the registered decoder, a panic of the decoder is returned as an error so a bad plugin
+// can't crash the server on a malformed payload
+func Decode(name string, payload []byte) (values []Value, err error) {
+	decoder, ok := LookupDecoder(name)
+	if !ok {
+		return nil, fmt.Errorf("decoder plugin %s is not registered", name)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			values, err = nil, fmt.Errorf("decoder plugin %s panicked: %v", name, r)
+		}
+	}()
+	return decoder.Decode(payload)
+}